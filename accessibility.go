@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+
+	"github.com/chromedp/cdproto/accessibility"
+	"github.com/chromedp/chromedp"
+)
+
+// maxAccessibilityIssues caps the issues recorded per check and page
+const maxAccessibilityIssues = 50
+
+// Checks on the accessibility tree; the DOM checks of accessibilityChecksScript are
+// missing_alt, heading_order, missing_h1, missing_lang and low_contrast
+const (
+	a11yUnnamedControl  = "unnamed_control"
+	a11yMissingLandmark = "missing_main_landmark"
+)
+
+// AccessibilityReport is the accessibility audit of a rendered page: basic checks
+// and a summary of its accessibility tree
+type AccessibilityReport struct {
+	Issues    []AccessibilityIssue `json:"issues"`
+	Counts    map[string]int       `json:"counts"`    // Issues per check, including those beyond the cap
+	Roles     map[string]int       `json:"roles"`     // Nodes per role in the accessibility tree
+	Landmarks []string             `json:"landmarks"` // Landmark roles present, in tree order
+}
+
+// AccessibilityIssue is a failed check on one element
+type AccessibilityIssue struct {
+	Check   string `json:"check"`
+	Element string `json:"element,omitempty"` // Tag, id and classes, e.g. img#logo.brand
+	Detail  string `json:"detail,omitempty"`  // e.g. "h2 followed by h4" or "contrast 2.1:1, needs 4.5:1"
+}
+
+// AccessibilitySummary aggregates the audits of a crawl for the report
+type AccessibilitySummary struct {
+	PagesAudited int                   `json:"pages_audited"`
+	Counts       map[string]int        `json:"counts"` // Issues per check across all pages
+	Pages        []AccessibilityByPage `json:"pages"`  // Pages with issues, most issues first
+}
+
+// AccessibilityByPage is the issue count of one audited page
+type AccessibilityByPage struct {
+	URL    string         `json:"url"`
+	Issues int            `json:"issues"`
+	Counts map[string]int `json:"counts"`
+}
+
+// landmarkRoles are the ARIA landmark roles listed in the report
+var landmarkRoles = map[string]bool{
+	"banner": true, "navigation": true, "main": true, "contentinfo": true, "complementary": true,
+	"search": true, "form": true, "region": true,
+}
+
+// controlRoles are interactive roles that need an accessible name
+var controlRoles = map[string]bool{
+	"button": true, "link": true, "textbox": true, "searchbox": true, "checkbox": true, "radio": true,
+	"combobox": true, "listbox": true, "slider": true, "spinbutton": true, "switch": true, "menuitem": true, "tab": true,
+}
+
+// accessibilityChecksScript runs the DOM checks: images without alt attributes,
+// skipped heading levels, a missing h1 or lang attribute, and text whose contrast
+// with its effective background is below WCAG AA (4.5:1, 3:1 for large text)
+const accessibilityChecksScript = `(() => {
+	const issues = [];
+	const describe = el => el.tagName.toLowerCase() + (el.id ? "#" + el.id : "") +
+		Array.from(el.classList).slice(0, 3).map(c => "." + c).join("");
+	const visible = el => { const r = el.getBoundingClientRect(); const s = getComputedStyle(el); return r.width > 0 && r.height > 0 && s.visibility !== "hidden" && s.display !== "none"; };
+
+	if (!document.documentElement.getAttribute("lang")) issues.push({check: "missing_lang"});
+	document.querySelectorAll("img:not([alt]), input[type=image]:not([alt]), area:not([alt])").forEach(el => {
+		if (el.getAttribute("role") !== "presentation" && el.getAttribute("aria-hidden") !== "true") {
+			issues.push({check: "missing_alt", element: describe(el), detail: (el.getAttribute("src") || "").slice(0, 200)});
+		}
+	});
+
+	const headings = Array.from(document.querySelectorAll("h1, h2, h3, h4, h5, h6")).filter(visible);
+	if (!headings.some(h => h.tagName === "H1")) issues.push({check: "missing_h1"});
+	let previous = 0;
+	for (const h of headings) {
+		const level = Number(h.tagName[1]);
+		if (previous && level > previous + 1) {
+			issues.push({check: "heading_order", element: describe(h), detail: "h" + previous + " followed by h" + level});
+		}
+		previous = level;
+	}
+
+	const parse = color => { const m = color.match(/[\d.]+/g); return m ? m.map(Number) : [0, 0, 0, 0]; };
+	const luminance = ([r, g, b]) => {
+		const c = [r, g, b].map(v => { v /= 255; return v <= 0.03928 ? v / 12.92 : Math.pow((v + 0.055) / 1.055, 2.4); });
+		return 0.2126 * c[0] + 0.7152 * c[1] + 0.0722 * c[2];
+	};
+	const background = el => {
+		for (; el; el = el.parentElement) {
+			const s = getComputedStyle(el);
+			if (s.backgroundImage !== "none") return null;
+			const c = parse(s.backgroundColor);
+			if (c.length < 4 || c[3] > 0) return c;
+		}
+		return [255, 255, 255, 1];
+	};
+	const walker = document.createTreeWalker(document.body || document.documentElement, NodeFilter.SHOW_TEXT);
+	const seen = new Set();
+	for (let node = walker.nextNode(); node; node = walker.nextNode()) {
+		const el = node.parentElement;
+		if (!el || seen.has(el) || !node.textContent.trim() || !visible(el)) continue;
+		seen.add(el);
+		const s = getComputedStyle(el);
+		const bg = background(el);
+		if (!bg) continue; // Text over images can't be judged
+		const fg = parse(s.color);
+		const [l1, l2] = [luminance(fg), luminance(bg)].sort((a, b) => b - a);
+		const ratio = (l1 + 0.05) / (l2 + 0.05);
+		const size = parseFloat(s.fontSize);
+		const large = size >= 24 || (size >= 18.66 && Number(s.fontWeight) >= 700);
+		const needed = large ? 3 : 4.5;
+		if (ratio < needed) {
+			issues.push({check: "low_contrast", element: describe(el), detail: "contrast " + ratio.toFixed(2) + ":1, needs " + needed + ":1"});
+		}
+	}
+	return issues;
+})()`
+
+// auditAccessibility runs the DOM checks and reads the accessibility tree of the
+// rendered page into report
+func auditAccessibility(report *AccessibilityReport) chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		report.Counts = make(map[string]int)
+		report.Roles = make(map[string]int)
+		report.Issues = []AccessibilityIssue{}
+		report.Landmarks = []string{}
+		var issues []AccessibilityIssue
+		if err := chromedp.Evaluate(accessibilityChecksScript, &issues).Do(ctx); err != nil {
+			return err
+		}
+		for _, issue := range issues {
+			report.add(issue)
+		}
+
+		if err := accessibility.Enable().Do(ctx); err != nil {
+			return err
+		}
+		nodes, err := accessibility.GetFullAXTree().Do(ctx)
+		if err != nil {
+			return err
+		}
+		for _, node := range nodes {
+			if node.Ignored {
+				continue
+			}
+			role := axString(node.Role)
+			if role == "" {
+				continue
+			}
+			report.Roles[role]++
+			if landmarkRoles[role] {
+				report.Landmarks = append(report.Landmarks, role)
+			}
+			if controlRoles[role] && axString(node.Name) == "" {
+				report.add(AccessibilityIssue{Check: a11yUnnamedControl, Detail: role})
+			}
+		}
+		if report.Roles["main"] == 0 {
+			report.add(AccessibilityIssue{Check: a11yMissingLandmark})
+		}
+		return nil
+	})
+}
+
+// add records an issue, counting it even beyond the per-check cap
+func (report *AccessibilityReport) add(issue AccessibilityIssue) {
+	report.Counts[issue.Check]++
+	if report.Counts[issue.Check] <= maxAccessibilityIssues {
+		report.Issues = append(report.Issues, issue)
+	}
+}
+
+// axString returns the string of an accessibility tree value
+func axString(value *accessibility.Value) string {
+	if value == nil || len(value.Value) == 0 {
+		return ""
+	}
+	var text string
+	if err := json.Unmarshal(value.Value, &text); err != nil {
+		return ""
+	}
+	return text
+}
+
+// buildAccessibilitySummary aggregates the audits of the crawled pages, or returns
+// nil when no page was audited
+func buildAccessibilitySummary(crawledDataMap map[string]*CrawledData) *AccessibilitySummary {
+	summary := &AccessibilitySummary{Counts: make(map[string]int), Pages: []AccessibilityByPage{}}
+	for pageURL, data := range crawledDataMap {
+		report, ok := data.StructuredData["accessibility"].(*AccessibilityReport)
+		if !ok {
+			continue
+		}
+		summary.PagesAudited++
+		total := 0
+		for check, count := range report.Counts {
+			summary.Counts[check] += count
+			total += count
+		}
+		if total > 0 {
+			summary.Pages = append(summary.Pages, AccessibilityByPage{URL: pageURL, Issues: total, Counts: report.Counts})
+		}
+	}
+	if summary.PagesAudited == 0 {
+		return nil
+	}
+	sort.Slice(summary.Pages, func(i, j int) bool {
+		if summary.Pages[i].Issues != summary.Pages[j].Issues {
+			return summary.Pages[i].Issues > summary.Pages[j].Issues
+		}
+		return summary.Pages[i].URL < summary.Pages[j].URL
+	})
+	return summary
+}
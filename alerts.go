@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Alert rule types of AlertRule.Type
+const (
+	alertPageDisappeared    = "page_disappeared"    // A page of the earlier crawl is gone
+	alertKeywordAppeared    = "keyword_appeared"    // A page now contains the keyword
+	alertKeywordDisappeared = "keyword_disappeared" // A page no longer contains the keyword
+	alertFieldChanged       = "field_changed"       // A structured data field changed, by more than Percent for numbers
+	alertStatus             = "status"              // A page now answers with the HTTP status, e.g. 404
+)
+
+// Defaults and limits of alerts
+const (
+	defaultAlertField = "$.product.price"
+	maxAlertRules     = 50
+	maxAlertHits      = 1000 // Per run, so a site-wide change doesn't flood the notifications
+)
+
+// AlertConfig are the alert rules of a job, evaluated when it completes against an
+// earlier crawl of the same site. Jobs resubmitted on a schedule, or recrawling a
+// corpus, thus alert on what changed since the last run.
+type AlertConfig struct {
+	Rules   []AlertRule `json:"rules"`
+	Against string      `json:"against,omitempty"` // Job to compare with, by default the latest earlier completed job of the same URL
+}
+
+// AlertRule is a condition on the change of a page between two crawls
+type AlertRule struct {
+	Name    string  `json:"name,omitempty"`
+	Type    string  `json:"type"`
+	Pages   string  `json:"pages,omitempty"`   // URL pattern of the pages watched (fetch_rules syntax), default all
+	Keyword string  `json:"keyword,omitempty"` // Of keyword rules, matched case-insensitively in the markdown
+	Field   string  `json:"field,omitempty"`   // Of field_changed: JSONPath into the structured data, default $.product.price
+	Percent float64 `json:"percent,omitempty"` // Of field_changed: change of a number needed, default any
+	Status  int     `json:"status,omitempty"`  // Of status rules
+}
+
+// AlertReport is the outcome of a job's alert rules
+type AlertReport struct {
+	Against string     `json:"against,omitempty"` // Job the results were compared with
+	Error   string     `json:"error,omitempty"`   // Why the rules couldn't be evaluated, e.g. no earlier crawl
+	Hits    []AlertHit `json:"hits"`
+}
+
+// AlertHit is a page a rule fired on
+type AlertHit struct {
+	Rule    string `json:"rule"` // Name of the rule, else its type
+	Type    string `json:"type"`
+	URL     string `json:"url"`
+	Message string `json:"message"`
+	Before  string `json:"before,omitempty"`
+	After   string `json:"after,omitempty"`
+}
+
+// validate checks the rules of an alert config
+func (config *AlertConfig) validate() error {
+	if len(config.Rules) == 0 || len(config.Rules) > maxAlertRules {
+		return fmt.Errorf("alerts need 1 to %d rules", maxAlertRules)
+	}
+	for _, rule := range config.Rules {
+		if rule.Pages != "" {
+			if _, err := compileURLPattern(rule.Pages); err != nil {
+				return fmt.Errorf("invalid pages pattern of alert rule %q: %w", rule.name(), err)
+			}
+		}
+		switch rule.Type {
+		case alertPageDisappeared:
+		case alertKeywordAppeared, alertKeywordDisappeared:
+			if strings.TrimSpace(rule.Keyword) == "" {
+				return fmt.Errorf("alert rule %q needs a keyword", rule.name())
+			}
+		case alertFieldChanged:
+			if _, err := compileJSONPath(rule.field()); err != nil {
+				return fmt.Errorf("invalid field of alert rule %q: %w", rule.name(), err)
+			}
+			if rule.Percent < 0 {
+				return errors.New("percent of alert rules must not be negative")
+			}
+		case alertStatus:
+			if rule.Status < 100 || rule.Status > 599 {
+				return fmt.Errorf("alert rule %q needs an HTTP status", rule.name())
+			}
+		default:
+			return fmt.Errorf("unknown alert rule type %q (want page_disappeared, keyword_appeared, keyword_disappeared, field_changed or status)", rule.Type)
+		}
+	}
+	return nil
+}
+
+// name returns the name of a rule, else its type
+func (rule AlertRule) name() string {
+	return firstNonEmpty(rule.Name, rule.Type)
+}
+
+// field returns the JSONPath a field_changed rule watches
+func (rule AlertRule) field() string {
+	return firstNonEmpty(rule.Field, defaultAlertField)
+}
+
+// watches reports whether a rule applies to a page
+func (rule AlertRule) watches(pageURL string) bool {
+	return rule.Pages == "" || urlPatternMatches(rule.Pages, pageURL)
+}
+
+// evaluateAlerts evaluates the alert rules of a completed job against its previous
+// run and records the hits on the job, see notifyFinished
+func (m *JobManager) evaluateAlerts(job *Job) {
+	config := job.Request.Alerts
+	if config == nil {
+		return
+	}
+	report := &AlertReport{Hits: []AlertHit{}}
+	m.mutex.Lock()
+	previous, err := m.previousRun(job.tenant, job, config.Against)
+	if err == nil && previous.Status != JobCompleted {
+		err = errJobNotDone
+	}
+	m.mutex.Unlock()
+	if err != nil {
+		report.Error = err.Error()
+	} else {
+		report.Against = previous.ID
+		report.Hits = config.evaluate(previous, job)
+	}
+
+	m.mutex.Lock()
+	job.Alerts = report
+	m.mutex.Unlock()
+}
+
+// evaluate returns the hits of the rules on the changes from the results of previous
+// to those of current. Pages are matched by canonical URL, as by Compare. Jobs without
+// a start URL, such as corpus recrawls, only miss the pages they were seeded with.
+func (config *AlertConfig) evaluate(previous *Job, current *Job) []AlertHit {
+	before, after := pagesByKey(previous.results), pagesByKey(current.results)
+	keys := make([]string, 0, len(before))
+	for key := range before {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	seeded := make(map[string]bool)
+	for _, seed := range current.Request.Seeds {
+		seeded[seed.URL] = true
+	}
+	failedBefore, failedAfter := failedStatuses(previous.Report), failedStatuses(current.Report)
+
+	hits := []AlertHit{}
+	hit := func(rule AlertRule, pageURL string, message string, beforeValue string, afterValue string) {
+		if len(hits) < maxAlertHits {
+			hits = append(hits, AlertHit{Rule: rule.name(), Type: rule.Type, URL: pageURL, Message: message, Before: beforeValue, After: afterValue})
+		}
+	}
+	for _, rule := range config.Rules {
+		if rule.Type == alertStatus {
+			urls := make([]string, 0, len(failedAfter))
+			for pageURL := range failedAfter {
+				urls = append(urls, pageURL)
+			}
+			sort.Strings(urls)
+			for _, pageURL := range urls {
+				if failedAfter[pageURL] != rule.Status || failedBefore[pageURL] == rule.Status || !rule.watches(pageURL) {
+					continue
+				}
+				previousStatus := ""
+				if failedBefore[pageURL] != 0 {
+					previousStatus = strconv.Itoa(failedBefore[pageURL])
+				} else if _, ok := previous.results[pageURL]; ok {
+					previousStatus = "200"
+				}
+				hit(rule, pageURL, fmt.Sprintf("now answers %d", rule.Status), previousStatus, strconv.Itoa(rule.Status))
+			}
+			continue
+		}
+
+		var path []jsonPathStep
+		if rule.Type == alertFieldChanged {
+			path, _ = compileJSONPath(rule.field()) // Checked by validate
+		}
+		keyword := strings.ToLower(rule.Keyword)
+		for _, key := range keys {
+			urlBefore := before[key]
+			if !rule.watches(urlBefore) {
+				continue
+			}
+			urlAfter, ok := after[key]
+			if rule.Type == alertPageDisappeared {
+				if !ok && (current.Request.URL != "" || seeded[urlBefore]) {
+					message, status := "no longer found", ""
+					if failedAfter[urlBefore] != 0 {
+						status = strconv.Itoa(failedAfter[urlBefore])
+						message = "no longer found, answers " + status
+					}
+					hit(rule, urlBefore, message, "", status)
+				}
+				continue
+			}
+			if !ok {
+				continue
+			}
+			dataBefore, dataAfter := previous.results[urlBefore], current.results[urlAfter]
+			switch rule.Type {
+			case alertKeywordAppeared, alertKeywordDisappeared:
+				had := strings.Contains(strings.ToLower(dataBefore.Markdown), keyword)
+				has := strings.Contains(strings.ToLower(dataAfter.Markdown), keyword)
+				if rule.Type == alertKeywordAppeared && !had && has {
+					hit(rule, urlAfter, fmt.Sprintf("%q appeared", rule.Keyword), "", "")
+				} else if rule.Type == alertKeywordDisappeared && had && !has {
+					hit(rule, urlAfter, fmt.Sprintf("%q disappeared", rule.Keyword), "", "")
+				}
+			case alertFieldChanged:
+				valueBefore, foundBefore := structuredField(dataBefore, path)
+				valueAfter, foundAfter := structuredField(dataAfter, path)
+				if (!foundBefore && !foundAfter) || valueBefore == valueAfter {
+					continue
+				}
+				message := rule.field() + " changed"
+				numberBefore, errBefore := strconv.ParseFloat(valueBefore, 64)
+				numberAfter, errAfter := strconv.ParseFloat(valueAfter, 64)
+				if errBefore == nil && errAfter == nil {
+					change := math.Inf(1)
+					if numberBefore != 0 {
+						change = (numberAfter - numberBefore) / math.Abs(numberBefore) * 100
+					}
+					if math.Abs(change) <= rule.Percent {
+						continue
+					}
+					if !math.IsInf(change, 0) {
+						message = fmt.Sprintf("%s changed by %+.1f%%", rule.field(), change)
+					}
+				}
+				hit(rule, urlAfter, message, valueBefore, valueAfter)
+			}
+		}
+	}
+	return hits
+}
+
+// failedStatuses returns the HTTP status of the failed pages of a report by URL
+func failedStatuses(report *CrawlReport) map[string]int {
+	statuses := make(map[string]int)
+	if report != nil {
+		for _, failed := range report.Failed {
+			statuses[failed.URL] = failed.Status
+		}
+	}
+	return statuses
+}
+
+// structuredField returns the first value a JSONPath selects in a page's structured
+// data, as text: strings as they are, other values as JSON
+func structuredField(data *CrawledData, path []jsonPathStep) (string, bool) {
+	encoded, err := json.Marshal(data.StructuredData)
+	if err != nil {
+		return "", false
+	}
+	var document interface{}
+	if err := json.Unmarshal(encoded, &document); err != nil {
+		return "", false
+	}
+	values := evalJSONPath(document, path)
+	if len(values) == 0 || values[0] == nil {
+		return "", false
+	}
+	if text, ok := values[0].(string); ok {
+		return text, true
+	}
+	text, _ := json.Marshal(values[0])
+	return string(text), true
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limits of alt text generation, so a crawl's cost stays bounded; both can be
+// changed per crawl
+const (
+	defaultAltTextMaxImages = 100 // Images described per crawl
+	defaultAltTextPerMinute = 30  // Requests to the vision model per minute
+	maxAltTextResponse      = 1 << 20
+	maxAltTextWords         = 20 // Replies are cut to this many words
+)
+
+// altTextClient calls the vision model
+var altTextClient = &http.Client{Timeout: time.Minute}
+
+// visionModel describes images with an OpenAI-compatible chat completions API
+type visionModel struct {
+	apiURL, apiKey, model string
+}
+
+// visionModelFromEnv returns the vision-capable model at LEXICRAWLER_VISION_URL (e.g.
+// https://api.openai.com/v1), authenticated with LEXICRAWLER_VISION_API_KEY, named
+// by LEXICRAWLER_VISION_MODEL. It returns nil without a URL.
+func visionModelFromEnv() *visionModel {
+	apiURL := strings.TrimRight(os.Getenv("LEXICRAWLER_VISION_URL"), "/")
+	if apiURL == "" {
+		return nil
+	}
+	model := os.Getenv("LEXICRAWLER_VISION_MODEL")
+	if model == "" {
+		model = "gpt-4o-mini"
+	}
+	return &visionModel{apiURL: apiURL, apiKey: os.Getenv("LEXICRAWLER_VISION_API_KEY"), model: model}
+}
+
+// visionBudget spaces the requests of a crawl to the vision model for one purpose
+// and counts them against its limit. Images are sent once per crawl, however many
+// pages show them (logos, icons), so their results are kept by URL.
+type visionBudget struct {
+	mutex     sync.Mutex
+	requested int
+	nextStart time.Time
+	results   map[string]interface{} // By image URL, the zero value where the request failed
+}
+
+// result returns the result of an image requested before
+func (b *visionBudget) result(imageURL string) (interface{}, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	result, ok := b.results[imageURL]
+	return result, ok
+}
+
+// store records the result of an image
+func (b *visionBudget) store(imageURL string, result interface{}) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.results == nil {
+		b.results = make(map[string]interface{})
+	}
+	b.results[imageURL] = result
+}
+
+// reserve takes a request from the budget, waiting for its turn; it returns false
+// once the crawl's images are used up
+func (b *visionBudget) reserve(maxImages int, perMinute int) bool {
+	b.mutex.Lock()
+	if b.requested >= maxImages {
+		b.mutex.Unlock()
+		return false
+	}
+	b.requested++
+	start := time.Now()
+	if b.nextStart.After(start) {
+		start = b.nextStart
+	}
+	b.nextStart = start.Add(time.Minute / time.Duration(perMinute))
+	b.mutex.Unlock()
+	time.Sleep(time.Until(start))
+	return true
+}
+
+// altTextMaxImages returns the crawl's limit of described images
+func (config CrawlerConfig) altTextMaxImages() int {
+	if config.AltTextMaxImages == 0 {
+		return defaultAltTextMaxImages
+	}
+	return config.AltTextMaxImages
+}
+
+// altTextPerMinute returns the crawl's rate of requests to the vision model
+func (config CrawlerConfig) altTextPerMinute() int {
+	if config.AltTextPerMinute == 0 {
+		return defaultAltTextPerMinute
+	}
+	return config.AltTextPerMinute
+}
+
+// describeImages generates alt text for the images of a page without an alt
+// attribute (alt="" marks decorative images, which are left alone) and uses it in
+// the markdown. Generated alt text is flagged AltGenerated in the page's media.
+func (c *Crawler) describeImages(data *CrawledData) {
+	model := visionModelFromEnv()
+	media, _ := data.StructuredData["media"].([]MediaItem)
+	if model == nil || len(media) == 0 {
+		return
+	}
+	alts := make(map[string]string)
+	for _, item := range media {
+		if item.Type != "image" || !item.altMissing {
+			continue
+		}
+		if alt, ok := c.imageAltText(model, item.URL, data.Metadata["title"]); ok {
+			alts[item.URL] = alt
+		}
+	}
+	useAltText(data, alts)
+}
+
+// useAltText gives the images of a page without an alt attribute the alt text
+// generated for their URL, in its media and markdown
+func useAltText(data *CrawledData, alts map[string]string) {
+	media, _ := data.StructuredData["media"].([]MediaItem)
+	markdown := data.Markdown
+	for i, item := range media {
+		alt := alts[item.URL]
+		if item.Type != "image" || !item.altMissing || alt == "" {
+			continue
+		}
+		media[i].Alt, media[i].AltGenerated = alt, true
+		markdown = strings.ReplaceAll(markdown, fmt.Sprintf("![](%s)", item.URL), fmt.Sprintf("![%s](%s)", escapeMarkdown(alt), item.URL))
+	}
+	if markdown == data.Markdown {
+		return
+	}
+	previous := pageOutline(data)
+	data.Markdown = markdown
+	outline := buildOutline(data.Markdown)
+	carrySourceAnchors(previous, outline)
+	data.StructuredData["outline"] = outline
+}
+
+// imageAltText returns the alt text of an image, described by the model unless it
+// was before or the crawl's budget is used up
+func (c *Crawler) imageAltText(model *visionModel, imageURL string, pageTitle string) (string, bool) {
+	if result, seen := c.altText.result(imageURL); seen {
+		alt := result.(string)
+		return alt, alt != ""
+	}
+	if !c.altText.reserve(c.Config.altTextMaxImages(), c.Config.altTextPerMinute()) {
+		return "", false
+	}
+
+	var alt string
+	image, err := downloadImage(imageURL)
+	if err == nil {
+		alt, err = model.describe(image.DataURI, pageTitle)
+	}
+	if err != nil {
+		fmt.Printf("Alt text generation failed for %s: %v\n", imageURL, err)
+	}
+	c.altText.store(imageURL, alt)
+	return alt, alt != ""
+}
+
+// describe asks the model for short alt text of an image given as a data URI. The
+// title of the page showing it is context.
+func (m *visionModel) describe(dataURI string, pageTitle string) (string, error) {
+	prompt := "Write alt text for this image: one short phrase saying what it shows, for readers who can't see it. " +
+		"Reply with the alt text only, without quotes."
+	if pageTitle != "" {
+		prompt += fmt.Sprintf(" It appears on a page titled %q.", pageTitle)
+	}
+	reply, err := m.ask(prompt, dataURI, 60)
+	if err != nil {
+		return "", err
+	}
+	words := strings.Fields(strings.Trim(strings.TrimSpace(reply), `"'`))
+	if len(words) > maxAltTextWords {
+		words = words[:maxAltTextWords]
+	}
+	return strings.Join(words, " "), nil
+}
+
+// ask sends the model a prompt with an image given as a data URI and returns its
+// reply, of up to maxTokens
+func (m *visionModel) ask(prompt string, dataURI string, maxTokens int) (string, error) {
+	request := map[string]interface{}{
+		"model": m.model,
+		"messages": []map[string]interface{}{{
+			"role": "user",
+			"content": []map[string]interface{}{
+				{"type": "text", "text": prompt},
+				{"type": "image_url", "image_url": map[string]string{"url": dataURI}},
+			},
+		}},
+		"max_tokens": maxTokens,
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(http.MethodPost, m.apiURL+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if m.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+m.apiKey)
+	}
+	resp, err := altTextClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, maxAltTextResponse))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("vision API returned %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+	var response struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	if err := json.Unmarshal(respBody, &response); err != nil {
+		return "", err
+	}
+	if len(response.Choices) == 0 {
+		return "", errors.New("vision API returned no choices")
+	}
+	return response.Choices[0].Message.Content, nil
+}
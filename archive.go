@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxArchiveRecordBytes bounds a single WARC record or HAR body held in memory
+const maxArchiveRecordBytes = 64 << 20
+
+// loadArchive reads the responses of a WARC (.warc, .warc.gz) or HAR (.har) file
+func loadArchive(path string) ([]*ArchivedResponse, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	lowerPath := strings.ToLower(path)
+	switch {
+	case strings.HasSuffix(lowerPath, ".har"):
+		return readHAR(file)
+	case strings.HasSuffix(lowerPath, ".warc.gz"):
+		gz, err := gzip.NewReader(file) // Each record is its own gzip member, read as one stream
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		return readWARC(gz)
+	case strings.HasSuffix(lowerPath, ".warc"):
+		return readWARC(file)
+	default:
+		return nil, fmt.Errorf("unsupported archive %s, expected .warc, .warc.gz or .har", path)
+	}
+}
+
+// readWARC extracts the HTTP responses of "response" records. Other record types
+// (request, metadata, warcinfo, ...) are skipped.
+func readWARC(r io.Reader) ([]*ArchivedResponse, error) {
+	reader := bufio.NewReader(r)
+	var responses []*ArchivedResponse
+	for {
+		headers, err := readWARCHeaders(reader)
+		if err == io.EOF {
+			return responses, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		length, err := strconv.ParseInt(headers["content-length"], 10, 64)
+		if err != nil || length < 0 {
+			return nil, fmt.Errorf("WARC record without valid Content-Length")
+		}
+		if length > maxArchiveRecordBytes {
+			if _, err := io.CopyN(io.Discard, reader, length); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		block := make([]byte, length)
+		if _, err := io.ReadFull(reader, block); err != nil {
+			return nil, fmt.Errorf("truncated WARC record: %w", err)
+		}
+
+		targetURI := strings.Trim(headers["warc-target-uri"], "<>") // WARC 1.0 examples wrap the URI in brackets
+		if headers["warc-type"] != "response" || !strings.HasPrefix(targetURI, "http") {
+			continue
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(block)), nil)
+		if err != nil {
+			continue // Non-HTTP payloads (e.g. DNS records)
+		}
+		body, err := readArchivedBody(resp)
+		if err != nil {
+			continue
+		}
+		responses = append(responses, &ArchivedResponse{
+			URL:         targetURI,
+			StatusCode:  resp.StatusCode,
+			ContentType: resp.Header.Get("Content-Type"),
+			Body:        body,
+		})
+	}
+}
+
+// readWARCHeaders reads the version line and named fields of the next WARC record,
+// skipping the blank lines separating records. Field names are lower-cased.
+func readWARCHeaders(reader *bufio.Reader) (map[string]string, error) {
+	var line string
+	for {
+		raw, err := reader.ReadString('\n')
+		if err != nil {
+			if err == io.EOF && strings.TrimSpace(raw) == "" {
+				return nil, io.EOF
+			}
+			return nil, err
+		}
+		line = strings.TrimSpace(raw)
+		if line != "" {
+			break
+		}
+	}
+	if !strings.HasPrefix(line, "WARC/") {
+		return nil, fmt.Errorf("invalid WARC record start %q", line)
+	}
+
+	headers := make(map[string]string)
+	for {
+		raw, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line := strings.TrimRight(raw, "\r\n")
+		if line == "" {
+			return headers, nil
+		}
+		if name, value, ok := strings.Cut(line, ":"); ok {
+			headers[strings.ToLower(strings.TrimSpace(name))] = strings.TrimSpace(value)
+		}
+	}
+}
+
+// readArchivedBody reads a recorded response body, undoing gzip content encoding
+func readArchivedBody(resp *http.Response) ([]byte, error) {
+	defer resp.Body.Close()
+	var body io.Reader = resp.Body
+	if strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		body = gz
+	}
+	return io.ReadAll(io.LimitReader(body, maxArchiveRecordBytes))
+}
+
+// harFile is the subset of the HAR 1.2 format needed to replay responses
+type harFile struct {
+	Log struct {
+		Entries []struct {
+			Request struct {
+				Method string `json:"method"`
+				URL    string `json:"url"`
+			} `json:"request"`
+			Response struct {
+				Status  int `json:"status"`
+				Content struct {
+					MimeType string `json:"mimeType"`
+					Text     string `json:"text"`
+					Encoding string `json:"encoding"`
+				} `json:"content"`
+			} `json:"response"`
+		} `json:"entries"`
+	} `json:"log"`
+}
+
+// readHAR extracts the GET responses of a HAR file that include their body
+func readHAR(r io.Reader) ([]*ArchivedResponse, error) {
+	var har harFile
+	if err := json.NewDecoder(r).Decode(&har); err != nil {
+		return nil, fmt.Errorf("parsing HAR: %w", err)
+	}
+	var responses []*ArchivedResponse
+	for _, entry := range har.Log.Entries {
+		if entry.Request.Method != http.MethodGet || entry.Response.Content.Text == "" {
+			continue
+		}
+		body := []byte(entry.Response.Content.Text)
+		if entry.Response.Content.Encoding == "base64" {
+			decoded, err := base64.StdEncoding.DecodeString(entry.Response.Content.Text)
+			if err != nil {
+				continue
+			}
+			body = decoded
+		}
+		responses = append(responses, &ArchivedResponse{
+			URL:         entry.Request.URL,
+			StatusCode:  entry.Response.Status,
+			ContentType: entry.Response.Content.MimeType,
+			Body:        body,
+		})
+	}
+	return responses, nil
+}
+
+// loadArchiveFetcher loads an archive and checks it holds something to process
+func loadArchiveFetcher(path string) (*ArchiveFetcher, error) {
+	responses, err := loadArchive(path)
+	if err != nil {
+		return nil, err
+	}
+	fetcher := NewArchiveFetcher(responses)
+	if len(fetcher.PageURLs()) == 0 {
+		return nil, errors.New("archive contains no HTML responses")
+	}
+	return fetcher, nil
+}
+
+// PageURLs returns the URLs of the successful HTML, JSON and XML responses in the
+// archive, sorted
+func (f *ArchiveFetcher) PageURLs() []string {
+	var urls []string
+	for pageURL, response := range f.responses {
+		if response.StatusCode < 400 && (isHTMLContentType(response.ContentType) || isDocumentContentType(response.ContentType)) {
+			urls = append(urls, pageURL)
+		}
+	}
+	sort.Strings(urls)
+	return urls
+}
+
+// runArchiveCrawl replays every HTML response of a WARC or HAR file through the
+// extraction pipeline without network access and writes one markdown file per page
+// (under host/path) plus report.json to outDir. It backs the -archive command-line flag.
+func runArchiveCrawl(path string, outDir string, config CrawlerConfig) error {
+	archive, err := loadArchiveFetcher(path)
+	if err != nil {
+		return err
+	}
+	config.ArchivePath = path
+	config.MaxDepth = 1 // Every archived page is a seed
+	config.DisableJSFallback = true
+
+	crawler := NewCrawler(config)
+	crawler.Archive = archive
+	results, err := crawler.Crawl()
+	if err != nil {
+		return err
+	}
+	defer removeSpilled(results)
+	if err := writeOfflineOutput(outDir, config.StartURL, results, archiveOutputPath); err != nil {
+		return err
+	}
+	fmt.Printf("Processed %d pages from %s into %s\n", len(results), path, outDir)
+	return nil
+}
+
+// archiveOutputPath maps an archived URL to host/path, using "index" for directory
+// URLs and adding the page ID when a query string would otherwise collide
+func archiveOutputPath(parsedURL *url.URL) (string, error) {
+	rel := strings.TrimSuffix(parsedURL.Path, path.Ext(parsedURL.Path))
+	if rel == "" || strings.HasSuffix(rel, "/") {
+		rel += "index"
+	}
+	if parsedURL.RawQuery != "" {
+		rel += "_" + pageID(parsedURL.String())
+	}
+	return filepath.Join(parsedURL.Host, filepath.FromSlash(path.Clean("/"+rel))), nil
+}
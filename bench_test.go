@@ -0,0 +1,160 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+	readability "github.com/go-shiori/go-readability"
+	"golang.org/x/net/html"
+)
+
+// Benchmarks of the CPU-bound extraction steps, on the pages in testdata/bench and a
+// large page built from them. Compare runs before and after a change with benchstat:
+//
+//	go test -run '^$' -bench . -benchmem -count 10 > old.txt
+
+const benchURL = "https://example.com/bench"
+
+// benchPages returns the fixture pages by name, plus "large", the docs page with its
+// content repeated to the size of a long reference page
+func benchPages(b *testing.B) map[string]string {
+	b.Helper()
+	paths, err := filepath.Glob(filepath.Join("testdata", "bench", "*.html"))
+	if err != nil || len(paths) == 0 {
+		b.Fatalf("No fixture pages in testdata/bench: %v", err)
+	}
+	pages := make(map[string]string)
+	for _, path := range paths {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			b.Fatal(err)
+		}
+		pages[strings.TrimSuffix(filepath.Base(path), ".html")] = string(raw)
+	}
+	docs := pages["docs"]
+	start, end := strings.Index(docs, "<body>")+len("<body>"), strings.Index(docs, "</body>")
+	pages["large"] = docs[:start] + strings.Repeat(docs[start:end], 40) + docs[end:]
+	return pages
+}
+
+// runBenchPages runs a benchmark for every page, reporting throughput in page bytes
+func runBenchPages(b *testing.B, bench func(b *testing.B, raw string)) {
+	pages := benchPages(b)
+	for _, name := range sortedKeys(pages) {
+		raw := pages[name]
+		b.Run(name, func(b *testing.B) {
+			b.SetBytes(int64(len(raw)))
+			b.ReportAllocs()
+			bench(b, raw)
+		})
+	}
+}
+
+func parseBenchPage(b *testing.B, raw string) *html.Node {
+	node, err := html.Parse(strings.NewReader(raw))
+	if err != nil {
+		b.Fatal(err)
+	}
+	return node
+}
+
+func BenchmarkParse(b *testing.B) {
+	runBenchPages(b, func(b *testing.B, raw string) {
+		for i := 0; i < b.N; i++ {
+			parseBenchPage(b, raw)
+		}
+	})
+}
+
+func BenchmarkGenerateMarkdown(b *testing.B) {
+	runBenchPages(b, func(b *testing.B, raw string) {
+		for i := 0; i < b.N; i++ {
+			b.StopTimer() // The conversion strips its input, every run needs a fresh tree
+			doc := goquery.NewDocumentFromNode(parseBenchPage(b, raw))
+			b.StartTimer()
+			generateMarkdown(doc.Selection, benchURL, CrawlerConfig{}, map[string]string{})
+		}
+	})
+}
+
+func BenchmarkReadability(b *testing.B) {
+	pageURL, _ := url.Parse(benchURL)
+	runBenchPages(b, func(b *testing.B, raw string) {
+		node := parseBenchPage(b, raw)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := readability.FromDocument(node, pageURL); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkChunkMarkdown(b *testing.B) {
+	runBenchPages(b, func(b *testing.B, raw string) {
+		doc := goquery.NewDocumentFromNode(parseBenchPage(b, raw))
+		markdown, _, _ := generateMarkdown(doc.Selection, benchURL, CrawlerConfig{}, map[string]string{})
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			chunkMarkdown(markdown, buildOutline(markdown))
+		}
+	})
+}
+
+// BenchmarkExtractPage processes whole pages the way the extract stage does, from
+// raw HTML to markdown, metadata, outline, statistics and quality
+func BenchmarkExtractPage(b *testing.B) {
+	configs := map[string]CrawlerConfig{
+		"default":     {},
+		"readability": {EnableReadability: true},
+		"selector":    {ContentSelector: "article, main, #content"},
+	}
+	for _, name := range sortedKeys(configs) {
+		crawler := NewCrawler(configs[name])
+		b.Run(name, func(b *testing.B) {
+			runBenchPages(b, func(b *testing.B, raw string) {
+				restore := silenceOutput(b)
+				for i := 0; i < b.N; i++ {
+					if _, err := crawler.extractPage(benchURL, raw); err != nil {
+						restore()
+						b.Fatal(err)
+					}
+				}
+				restore()
+			})
+		})
+	}
+}
+
+// silenceOutput discards the progress the crawler prints, which would otherwise break
+// up the benchmark result lines, until the returned function restores it
+func silenceOutput(b *testing.B) func() {
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = devNull
+	log.SetOutput(io.Discard)
+	return func() {
+		os.Stdout = stdout
+		log.SetOutput(os.Stderr)
+		devNull.Close()
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
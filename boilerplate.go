@@ -0,0 +1,241 @@
+package main
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Lines of the markdown of a site's pages are boilerplate once the site has
+// minBoilerplatePages pages and boilerplateShare of them have the line
+const (
+	minBoilerplatePages = 10
+	boilerplateShare    = 0.6
+	maxBoilerplateWords = 12   // Longer lines are content, however often they repeat
+	maxBoilerplateLines = 5000 // Distinct lines counted per site before rare ones are forgotten
+)
+
+// SiteBoilerplate are the lines learned as boilerplate of a site
+type SiteBoilerplate struct {
+	Host    string   `json:"host"`
+	Pages   int      `json:"pages"` // Learned from
+	Phrases []string `json:"phrases"`
+}
+
+// boilerplateLearner learns the lines repeating on most pages of a site during a
+// crawl, such as navigation labels, calls to action and sign-up prompts, so they can
+// be stripped without StripSelectors written for the site
+type boilerplateLearner struct {
+	mutex sync.Mutex
+	sites map[string]*siteLines // By host
+}
+
+// siteLines counts the pages of a site each candidate line was seen on
+type siteLines struct {
+	pages  int
+	counts map[string]int
+}
+
+// newBoilerplateLearner creates a learner that has seen no pages
+func newBoilerplateLearner() *boilerplateLearner {
+	return &boilerplateLearner{sites: make(map[string]*siteLines)}
+}
+
+// learn counts the candidate lines of a page of host
+func (l *boilerplateLearner) learn(host string, lines []string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	site := l.sites[host]
+	if site == nil {
+		site = &siteLines{counts: make(map[string]int)}
+		l.sites[host] = site
+	}
+	site.pages++
+	for _, line := range lines {
+		site.counts[line]++
+	}
+	if len(site.counts) > maxBoilerplateLines {
+		for line, count := range site.counts {
+			if float64(count) < boilerplateShare*float64(site.pages)/2 {
+				delete(site.counts, line)
+			}
+		}
+	}
+}
+
+// phrases returns the lines that are boilerplate of host on the pages seen so far
+func (l *boilerplateLearner) phrases(host string) map[string]bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	site := l.sites[host]
+	if site == nil || site.pages < minBoilerplatePages {
+		return nil
+	}
+	phrases := make(map[string]bool)
+	for line, count := range site.counts {
+		if float64(count) >= boilerplateShare*float64(site.pages) {
+			phrases[line] = true
+		}
+	}
+	return phrases
+}
+
+// report lists the boilerplate of every site, by host
+func (l *boilerplateLearner) report() []SiteBoilerplate {
+	l.mutex.Lock()
+	hosts := make(map[string]int, len(l.sites))
+	for host, site := range l.sites {
+		hosts[host] = site.pages
+	}
+	l.mutex.Unlock()
+
+	var report []SiteBoilerplate
+	for host, pages := range hosts {
+		phrases := l.phrases(host)
+		if len(phrases) == 0 {
+			continue
+		}
+		site := SiteBoilerplate{Host: host, Pages: pages}
+		for phrase := range phrases {
+			site.Phrases = append(site.Phrases, phrase)
+		}
+		sort.Strings(site.Phrases)
+		report = append(report, site)
+	}
+	sort.Slice(report, func(i, j int) bool { return report[i].Host < report[j].Host })
+	return report
+}
+
+// boilerplateCandidates returns the distinct lines of markdown that could be
+// boilerplate: short lines with letters outside code blocks, tables and headings
+// (stripping a heading would merge its section into the previous one). Lines of the
+// page's header and footer templates are left to them.
+func boilerplateCandidates(markdown string, frame map[string]bool) []string {
+	var candidates []string
+	seen := make(map[string]bool)
+	fence := ""
+	for _, line := range strings.Split(markdown, "\n") {
+		if fence != "" {
+			if marker := codeFence.FindStringSubmatch(line); marker != nil && marker[1][0] == fence[0] && len(marker[1]) >= len(fence) {
+				fence = ""
+			}
+			continue
+		}
+		if marker := codeFence.FindStringSubmatch(line); marker != nil {
+			fence = marker[1]
+			continue
+		}
+		line = strings.TrimSpace(line)
+		if seen[line] || frame[line] || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "|") ||
+			len(strings.Fields(line)) > maxBoilerplateWords || strings.IndexFunc(line, unicode.IsLetter) < 0 {
+			continue
+		}
+		seen[line] = true
+		candidates = append(candidates, line)
+	}
+	return candidates
+}
+
+// stripLines removes the lines of markdown outside code blocks that are phrases,
+// returning the markdown and the number of lines removed
+func stripLines(markdown string, phrases map[string]bool) (string, int) {
+	var kept []string
+	removed := 0
+	fence := ""
+	for _, line := range strings.Split(markdown, "\n") {
+		if fence != "" {
+			if marker := codeFence.FindStringSubmatch(line); marker != nil && marker[1][0] == fence[0] && len(marker[1]) >= len(fence) {
+				fence = ""
+			}
+		} else if marker := codeFence.FindStringSubmatch(line); marker != nil {
+			fence = marker[1]
+		} else if phrases[strings.TrimSpace(line)] {
+			removed++
+			continue
+		}
+		kept = append(kept, line)
+	}
+	if removed == 0 {
+		return markdown, 0
+	}
+	return blankLines.ReplaceAllString(strings.Join(kept, "\n"), "\n\n"), removed
+}
+
+// frameLines returns the lines the header and footer templates render for a page
+func (c *Crawler) frameLines(data *CrawledData) map[string]bool {
+	var rendered strings.Builder
+	frame := markdownFrame{URL: data.URL, Metadata: data.Metadata}
+	renderMarkdownFrame(&rendered, c.Config.markdownHeader(data.URL), frame)
+	renderMarkdownFrame(&rendered, c.Config.markdownFooter(data.URL), frame)
+	lines := make(map[string]bool)
+	for _, line := range strings.Split(rendered.String(), "\n") {
+		lines[strings.TrimSpace(line)] = true
+	}
+	return lines
+}
+
+// stripBoilerplate learns the candidate lines of a page and strips the boilerplate
+// its site is known to have so far. Pages crawled before enough of their site was
+// seen are stripped by stripLearnedBoilerplate once the crawl is done.
+func (c *Crawler) stripBoilerplate(data *CrawledData) {
+	if c.boilerplate == nil || data.Markdown == "" {
+		return
+	}
+	parsedURL, err := url.Parse(data.URL)
+	if err != nil {
+		return
+	}
+	frame := c.frameLines(data)
+	c.boilerplate.learn(parsedURL.Host, boilerplateCandidates(data.Markdown, frame))
+	c.removeBoilerplate(data, parsedURL.Host, frame)
+}
+
+// stripLearnedBoilerplate strips the boilerplate learned by the end of a crawl from
+// all its pages, including those crawled before it was learned. Sinks have received
+// those pages as they were crawled.
+func (c *Crawler) stripLearnedBoilerplate(pages map[string]*CrawledData) {
+	if c.boilerplate == nil {
+		return
+	}
+	for _, data := range pages {
+		parsedURL, err := url.Parse(data.URL)
+		if err != nil || data.Markdown == "" {
+			continue
+		}
+		if c.removeBoilerplate(data, parsedURL.Host, c.frameLines(data)) && data.ContentHash != "" {
+			data.ContentHash = contentHash(data)
+		}
+	}
+}
+
+// removeBoilerplate strips the boilerplate of host from a page, keeping the lines of
+// its frame, and rebuilds its outline. It reports whether the markdown changed.
+func (c *Crawler) removeBoilerplate(data *CrawledData, host string, frame map[string]bool) bool {
+	phrases := c.boilerplate.phrases(host)
+	for line := range frame {
+		delete(phrases, line)
+	}
+	markdown, removed := stripLines(data.Markdown, phrases)
+	if removed == 0 {
+		return false
+	}
+	previous := pageOutline(data)
+	data.Markdown = markdown
+	outline := buildOutline(data.Markdown)
+	carrySourceAnchors(previous, outline)
+	data.StructuredData["outline"] = outline
+	relocateEntities(data)
+	c.relocateTopics(data)
+	return true
+}
+
+// BoilerplateReport lists the boilerplate learned of every site, nil unless the
+// crawl learns boilerplate
+func (c *Crawler) BoilerplateReport() []SiteBoilerplate {
+	if c.boilerplate == nil {
+		return nil
+	}
+	return c.boilerplate.report()
+}
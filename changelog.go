@@ -0,0 +1,281 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Limits of changelog recognition
+const (
+	minChangelogVersions   = 3   // Version headings a page needs, or 2 when its URL or title says it is a changelog
+	maxChangelogVersions   = 500 // Versions kept of very long changelogs
+	maxVersionHeadingChars = 120
+)
+
+// Changelog is a changelog or release notes page as its versions, in page order
+type Changelog struct {
+	Title    string             `json:"title,omitempty"`
+	URL      string             `json:"url"`
+	Latest   string             `json:"latest,omitempty"` // The highest released version, pre-releases aside
+	Versions []ChangelogVersion `json:"versions"`
+}
+
+// ChangelogVersion is a version of a changelog
+type ChangelogVersion struct {
+	Version    string            `json:"version"`        // Without a "v" prefix, or "unreleased"
+	Date       string            `json:"date,omitempty"` // YYYY-MM-DD
+	Heading    string            `json:"heading"`
+	URL        string            `json:"url,omitempty"` // The page URL with the heading's anchor
+	Prerelease bool              `json:"prerelease,omitempty"`
+	Yanked     bool              `json:"yanked,omitempty"`
+	Changes    []ChangelogChange `json:"changes,omitempty"`
+}
+
+// ChangelogChange is an item of a version
+type ChangelogChange struct {
+	Type string `json:"type,omitempty"` // added, changed, deprecated, removed, fixed, security, breaking, or the heading it is under
+	Text string `json:"text"`
+}
+
+var (
+	// versionPattern matches a dotted version number, optionally with a "v" and a
+	// pre-release suffix, as in "[1.2.0]", "v2.0.0-rc.1" or "lodash@4.17.21"
+	versionPattern = regexp.MustCompile(`(?:^|[\s\[(@/])[vV]?(\d+(?:\.\d+)+(?:-[0-9A-Za-z]+(?:\.[0-9A-Za-z]+)*)?)(?:$|[\s\]),:])`)
+	// unreleasedPattern matches the heading of changes not released yet
+	unreleasedPattern = regexp.MustCompile(`(?i)^\W*unreleased\W*$`)
+	// prereleasePattern matches the words of pre-release headings
+	prereleasePattern = regexp.MustCompile(`(?i)\b(alpha|beta|rc|preview|pre-release|prerelease|canary|nightly)\b`)
+	// changelogHint matches URLs and titles of changelog pages
+	changelogHint = regexp.MustCompile(`(?i)change-?log|changes|release|history|what-?s-?new|news|updates`)
+	// isoDatePattern, monthDayPattern and dayMonthPattern match "2024-01-02",
+	// "January 2, 2024" and "2 Jan 2024"
+	isoDatePattern   = regexp.MustCompile(`\b(\d{4})[-/](\d{1,2})[-/](\d{1,2})\b`)
+	monthDayPattern  = regexp.MustCompile(`(?i)\b([a-z]{3,9})\.?\s+(\d{1,2})(?:st|nd|rd|th)?,?\s+(\d{4})\b`)
+	dayMonthPattern  = regexp.MustCompile(`(?i)\b(\d{1,2})(?:st|nd|rd|th)?\s+([a-z]{3,9})\.?,?\s+(\d{4})\b`)
+	changeTypePrefix = regexp.MustCompile(`^\W*([A-Za-z ]+?)(?:\([^)]*\))?!?\s*[:\]]\s+`)
+)
+
+// changeTypes normalizes the headings and prefixes changes are grouped by to the
+// types of Keep a Changelog, plus breaking
+var changeTypes = map[string]string{
+	"added": "added", "new": "added", "feature": "added", "features": "added", "feat": "added", "new features": "added", "additions": "added",
+	"changed": "changed", "changes": "changed", "improved": "changed", "improvements": "changed", "enhancements": "changed",
+	"updated": "changed", "perf": "changed", "performance": "changed", "refactor": "changed",
+	"deprecated": "deprecated", "deprecations": "deprecated",
+	"removed": "removed", "removals": "removed",
+	"fixed": "fixed", "fix": "fixed", "fixes": "fixed", "bug fixes": "fixed", "bugfixes": "fixed", "bug fix": "fixed", "bugs": "fixed",
+	"security": "security",
+	"breaking": "breaking", "breaking changes": "breaking", "breaking change": "breaking",
+}
+
+// extractChangelog recognizes changelog and release notes pages by their version
+// headings, collecting the date and the items listed under each
+func extractChangelog(page *extractorPage) (interface{}, bool) {
+	headings := page.Doc.Find("h1, h2, h3, h4, h5, h6").FilterFunction(func(_ int, heading *goquery.Selection) bool {
+		return heading.ParentsFiltered("nav, header, footer, aside").Length() == 0
+	})
+	counts := make(map[int]int)
+	level := 0
+	headings.Each(func(_ int, heading *goquery.Selection) {
+		if _, ok := headingVersion(heading.Text()); ok {
+			n := headingLevel(heading)
+			counts[n]++
+			if level == 0 || counts[n] > counts[level] || (counts[n] == counts[level] && n < level) {
+				level = n
+			}
+		}
+	})
+	needed := minChangelogVersions
+	if changelogHint.MatchString(page.URL) || changelogHint.MatchString(page.Meta["title"]) {
+		needed = 2
+	}
+	if counts[level] < needed {
+		return nil, false
+	}
+
+	changelog := Changelog{Title: page.Meta["title"], URL: page.URL}
+	var version *ChangelogVersion
+	changeType := ""
+	finish := func() {
+		if version != nil && len(changelog.Versions) < maxChangelogVersions {
+			changelog.Versions = append(changelog.Versions, *version)
+		}
+		version = nil
+	}
+	page.Doc.Find("h1, h2, h3, h4, h5, h6, li, p, time, relative-time").Each(func(_ int, element *goquery.Selection) {
+		if element.ParentsFiltered("nav, header, footer, aside, details").Length() > 0 {
+			return
+		}
+		text := strings.Join(strings.Fields(element.Text()), " ")
+		switch {
+		case element.Is("h1, h2, h3, h4, h5, h6"):
+			if n := headingLevel(element); n <= level {
+				finish()
+				if number, ok := headingVersion(text); ok && n == level {
+					version = &ChangelogVersion{
+						Version:    number,
+						Date:       changelogDate(element, text),
+						Heading:    text,
+						Prerelease: strings.Contains(number, "-") || prereleasePattern.MatchString(text),
+						Yanked:     strings.Contains(strings.ToUpper(text), "YANKED"),
+					}
+					if id := element.AttrOr("id", ""); id != "" {
+						version.URL = strings.SplitN(page.URL, "#", 2)[0] + "#" + id
+					}
+					changeType = ""
+				}
+			} else if version != nil {
+				changeType = normalizeChangeType(text)
+			}
+		case version == nil:
+		case element.Is("li"):
+			own := element.Clone()
+			own.Find("ul, ol").Remove() // Nested items are changes of their own
+			if text = strings.Join(strings.Fields(own.Text()), " "); text == "" {
+				return
+			}
+			change := ChangelogChange{Type: changeType, Text: text}
+			if prefix := changeTypePrefix.FindStringSubmatch(text); prefix != nil {
+				if prefixType, ok := changeTypes[strings.ToLower(strings.TrimSpace(prefix[1]))]; ok {
+					change.Type, change.Text = prefixType, text[len(prefix[0]):]
+				}
+			}
+			version.Changes = append(version.Changes, change)
+		case version.Date == "" && len(version.Changes) == 0: // Dates under the heading, as "Released on March 3, 2024"
+			if element.Is("time, relative-time") {
+				version.Date = parseChangelogDate(element.AttrOr("datetime", text))
+			} else if len(text) < maxVersionHeadingChars {
+				version.Date = parseChangelogDate(text)
+			}
+		}
+	})
+	finish()
+	if len(changelog.Versions) == 0 {
+		return nil, false
+	}
+	for _, v := range changelog.Versions {
+		if v.Version != "unreleased" && !v.Prerelease && !v.Yanked && (changelog.Latest == "" || compareVersions(v.Version, changelog.Latest) > 0) {
+			changelog.Latest = v.Version
+		}
+	}
+	return changelog, true
+}
+
+// headingLevel returns the level of a heading element, 1 for h1
+func headingLevel(heading *goquery.Selection) int {
+	return int(goquery.NodeName(heading)[1] - '0')
+}
+
+// headingVersion returns the version a heading names: its first version number
+// without a "v", or "unreleased"
+func headingVersion(text string) (string, bool) {
+	text = strings.TrimSpace(text)
+	if len(text) > maxVersionHeadingChars {
+		return "", false
+	}
+	if unreleasedPattern.MatchString(text) {
+		return "unreleased", true
+	}
+	if match := versionPattern.FindStringSubmatch(text); match != nil {
+		return match[1], true
+	}
+	return "", false
+}
+
+// changelogDate returns the date of a version from its heading, leaving the version
+// number out so "1.2.3" isn't read as a date
+func changelogDate(heading *goquery.Selection, text string) string {
+	if datetime := heading.Find("time[datetime], relative-time[datetime]").AttrOr("datetime", ""); datetime != "" {
+		return parseChangelogDate(datetime)
+	}
+	return parseChangelogDate(versionPattern.ReplaceAllString(text, " "))
+}
+
+// parseChangelogDate returns the first date in text as YYYY-MM-DD, or "" when there
+// is none
+func parseChangelogDate(text string) string {
+	var year, month, day int
+	if match := isoDatePattern.FindStringSubmatch(text); match != nil {
+		year, _ = strconv.Atoi(match[1])
+		month, _ = strconv.Atoi(match[2])
+		day, _ = strconv.Atoi(match[3])
+	} else if match := monthDayPattern.FindStringSubmatch(text); match != nil && monthNumber(match[1]) > 0 {
+		month = monthNumber(match[1])
+		day, _ = strconv.Atoi(match[2])
+		year, _ = strconv.Atoi(match[3])
+	} else if match := dayMonthPattern.FindStringSubmatch(text); match != nil && monthNumber(match[2]) > 0 {
+		day, _ = strconv.Atoi(match[1])
+		month = monthNumber(match[2])
+		year, _ = strconv.Atoi(match[3])
+	} else {
+		return ""
+	}
+	date := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+	if date.Month() != time.Month(month) || date.Day() != day { // Normalized, so not a real date
+		return ""
+	}
+	return date.Format("2006-01-02")
+}
+
+// monthNumber returns the number of an English month name or abbreviation, 0 for
+// other words
+func monthNumber(name string) int {
+	name = strings.ToLower(name)
+	for i := time.January; i <= time.December; i++ {
+		full := strings.ToLower(i.String())
+		if name == full || (len(name) >= 3 && strings.HasPrefix(full, name)) || (name == "sept" && i == time.September) {
+			return int(i)
+		}
+	}
+	return 0
+}
+
+// normalizeChangeType returns the type of the changes under a heading such as
+// "### Added" or "🐛 Bug Fixes"
+func normalizeChangeType(heading string) string {
+	words := strings.FieldsFunc(strings.ToLower(heading), func(r rune) bool { return !unicode.IsLetter(r) })
+	name := strings.Join(words, " ")
+	if changeType, ok := changeTypes[name]; ok {
+		return changeType
+	}
+	return name
+}
+
+// compareVersions compares dotted version numbers part by part, numerically where
+// both parts are numbers; a version with a pre-release suffix sorts before the release
+func compareVersions(a string, b string) int {
+	aRelease, aPre, _ := strings.Cut(a, "-")
+	bRelease, bPre, _ := strings.Cut(b, "-")
+	aParts, bParts := strings.Split(aRelease, "."), strings.Split(bRelease, ".")
+	for i := 0; i < len(aParts) || i < len(bParts); i++ {
+		aPart, bPart := "0", "0"
+		if i < len(aParts) {
+			aPart = aParts[i]
+		}
+		if i < len(bParts) {
+			bPart = bParts[i]
+		}
+		aNumber, aErr := strconv.Atoi(aPart)
+		bNumber, bErr := strconv.Atoi(bPart)
+		switch {
+		case aErr == nil && bErr == nil && aNumber != bNumber:
+			return aNumber - bNumber
+		case (aErr != nil || bErr != nil) && aPart != bPart:
+			return strings.Compare(aPart, bPart)
+		}
+	}
+	switch {
+	case aPre == bPre:
+		return 0
+	case aPre == "":
+		return 1
+	case bPre == "":
+		return -1
+	}
+	return strings.Compare(aPre, bPre)
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Chunking strategies, see ChunkerConfig
+const (
+	chunkByHeadings  = "headings"
+	chunkByTokens    = "tokens"
+	chunkBySentences = "sentences"
+	chunkByCode      = "code"
+)
+
+// defaultChunkTokens is the size of chunks when a strategy bounding it names none
+const defaultChunkTokens = 512
+
+// Chunk is a section of a page's markdown, the unit stored for search and retrieval
+type Chunk struct {
+	Index       int             `json:"index"`
+	Chunker     string          `json:"chunker"`      // Strategy that made the chunk
+	HeadingPath []string        `json:"heading_path"` // Texts of the enclosing headings, outermost first
+	Text        string          `json:"text"`
+	Start       int             `json:"start"` // Character offsets in the page markdown
+	End         int             `json:"end"`
+	Citation    ChunkCitation   `json:"citation"`               // Filled in by pageChunks
+	DuplicateOf *ChunkDuplicate `json:"duplicate_of,omitempty"` // First occurrence of a repeated chunk, see chunkDeduper
+	Topics      []string        `json:"topics,omitempty"`       // Topics of the page found in the chunk, see chunkTopics
+	DocsVersion string          `json:"docs_version,omitempty"` // Version of the docs the page is of, see CrawlerConfig.DocsVersion
+
+	headings []*OutlineNode // Enclosing headings, outermost first
+}
+
+// ChunkerConfig selects how pages are split into chunks, as content types chunk
+// best differently. Tokens are approximated by words.
+//
+//   - headings (default): a chunk per heading section, whatever its size
+//   - tokens: windows of MaxTokens words overlapping by OverlapTokens, ignoring structure
+//   - sentences: the sentences of each heading section, packed into chunks of up to
+//     MaxTokens without splitting a sentence
+//   - code: the blocks of each heading section, packed into chunks of up to MaxTokens
+//     without splitting a code block or separating it from the text introducing it
+//
+// Where chunks of many pages are stored or listed together, chunks repeated across
+// pages are tagged or dropped by Dedupe.
+type ChunkerConfig struct {
+	Strategy      string `json:"strategy"`
+	MaxTokens     int    `json:"max_tokens"`     // Default 512, ignored by headings
+	OverlapTokens int    `json:"overlap_tokens"` // Of consecutive token windows
+	Dedupe        string `json:"dedupe"`         // "tag" or "drop" repeated chunks, "" to keep them as they are
+}
+
+// validate checks a chunker configuration
+func (c ChunkerConfig) validate() error {
+	switch c.Strategy {
+	case "", chunkByHeadings, chunkByTokens, chunkBySentences, chunkByCode:
+	default:
+		return fmt.Errorf("unknown chunking strategy %q (want headings, tokens, sentences or code)", c.Strategy)
+	}
+	if c.Dedupe != "" && c.Dedupe != dedupeTag && c.Dedupe != dedupeDrop {
+		return fmt.Errorf("unknown dedupe mode %q (want tag or drop)", c.Dedupe)
+	}
+	if c.MaxTokens < 0 || c.OverlapTokens < 0 {
+		return errors.New("max_tokens and overlap_tokens must not be negative")
+	}
+	if c.OverlapTokens > 0 && c.OverlapTokens >= c.maxTokens() {
+		return errors.New("overlap_tokens must be less than max_tokens")
+	}
+	return nil
+}
+
+// strategy returns the configured strategy, headings by default
+func (c ChunkerConfig) strategy() string {
+	if c.Strategy == "" {
+		return chunkByHeadings
+	}
+	return c.Strategy
+}
+
+// maxTokens returns the configured chunk size or its default
+func (c ChunkerConfig) maxTokens() int {
+	if c.MaxTokens == 0 {
+		return defaultChunkTokens
+	}
+	return c.MaxTokens
+}
+
+// chunk splits markdown with the configured strategy. Chunks are cited by the
+// headings of the outline enclosing their start.
+func (c ChunkerConfig) chunk(markdown string, outline []*OutlineNode) []Chunk {
+	strategy := c.strategy()
+	sections := headingSections(markdown, outline)
+	runes := []rune(markdown)
+	var chunks []Chunk
+	add := func(start, end int) {
+		text := strings.TrimSpace(string(runes[start:end]))
+		if text == "" {
+			return
+		}
+		section := sections[0]
+		for _, s := range sections {
+			if s.start <= start {
+				section = s
+			}
+		}
+		chunks = append(chunks, Chunk{Index: len(chunks), Chunker: strategy, HeadingPath: section.path, Text: text, Start: start, End: end, headings: section.headings})
+	}
+
+	if strategy == chunkByTokens {
+		words := wordSpans(runes, 0, len(runes))
+		step := c.maxTokens() - c.OverlapTokens
+		for first := 0; first < len(words); first += step {
+			last := min(first+c.maxTokens(), len(words)) - 1
+			add(words[first][0], words[last][1])
+			if last == len(words)-1 {
+				break
+			}
+		}
+		return chunks
+	}
+	for _, section := range sections {
+		switch strategy {
+		case chunkBySentences:
+			var sentences [][2]int
+			for _, block := range blockSpans(runes, section.start, section.end, false) {
+				if codeFence.MatchString(string(runes[block[0]:block[1]])) {
+					sentences = append(sentences, block) // Code has no sentences
+				} else {
+					sentences = append(sentences, sentenceSpans(runes, block[0], block[1])...)
+				}
+			}
+			packSpans(sentences, runes, c.maxTokens(), add)
+		case chunkByCode:
+			packSpans(blockSpans(runes, section.start, section.end, true), runes, c.maxTokens(), add)
+		default:
+			add(section.start, section.end)
+		}
+	}
+	return chunks
+}
+
+// chunkMarkdown splits markdown at every heading of its outline. Text before the
+// first heading becomes a chunk without a heading path; blank chunks are dropped.
+func chunkMarkdown(markdown string, outline []*OutlineNode) []Chunk {
+	return ChunkerConfig{}.chunk(markdown, outline)
+}
+
+// headingSection is the markdown from a heading of an outline to the next one
+type headingSection struct {
+	start, end int // Character offsets
+	path       []string
+	headings   []*OutlineNode
+}
+
+// headingSections splits markdown at every heading of its outline, starting with the
+// text before the first heading
+func headingSections(markdown string, outline []*OutlineNode) []headingSection {
+	sections := []headingSection{{start: 0}}
+	var walk func(nodes []*OutlineNode, path []string, headings []*OutlineNode)
+	walk = func(nodes []*OutlineNode, path []string, headings []*OutlineNode) {
+		for _, node := range nodes {
+			nodePath := append(append([]string{}, path...), node.Text)
+			nodeHeadings := append(append([]*OutlineNode{}, headings...), node)
+			sections = append(sections, headingSection{start: node.Start, path: nodePath, headings: nodeHeadings})
+			walk(node.Children, nodePath, nodeHeadings)
+		}
+	}
+	walk(outline, nil, nil)
+
+	length := len([]rune(markdown))
+	valid := sections[:0]
+	for i, section := range sections {
+		section.end = length
+		if i+1 < len(sections) {
+			section.end = sections[i+1].start
+		}
+		if section.start < section.end && section.end <= length {
+			valid = append(valid, section)
+		}
+	}
+	if len(valid) == 0 {
+		valid = append(valid, headingSection{start: 0, end: length})
+	}
+	return valid
+}
+
+// wordSpans returns the offsets of the whitespace-separated words of runes[start:end]
+func wordSpans(runes []rune, start int, end int) [][2]int {
+	var spans [][2]int
+	for i := start; i < end; {
+		for i < end && unicode.IsSpace(runes[i]) {
+			i++
+		}
+		if i == end {
+			break
+		}
+		wordStart := i
+		for i < end && !unicode.IsSpace(runes[i]) {
+			i++
+		}
+		spans = append(spans, [2]int{wordStart, i})
+	}
+	return spans
+}
+
+// sentenceSpans splits runes[start:end] into sentences, ending at terminal
+// punctuation followed by whitespace and at blank lines
+func sentenceSpans(runes []rune, start int, end int) [][2]int {
+	var spans [][2]int
+	sentenceStart := start
+	for i := start; i < end; i++ {
+		boundary := false
+		switch runes[i] {
+		case '.', '!', '?':
+			next := i + 1
+			for next < end && strings.ContainsRune(`"')]*_`, runes[next]) { // Closing quotes, brackets and emphasis
+				next++
+			}
+			if next == end || unicode.IsSpace(runes[next]) {
+				i, boundary = next-1, true
+			}
+		case '\n':
+			boundary = i+1 < end && runes[i+1] == '\n'
+		}
+		if boundary {
+			spans = append(spans, [2]int{sentenceStart, i + 1})
+			sentenceStart = i + 1
+		}
+	}
+	if sentenceStart < end {
+		spans = append(spans, [2]int{sentenceStart, end})
+	}
+	return spans
+}
+
+// blockSpans splits runes[start:end] into blocks separated by blank lines. Fenced
+// code blocks are one block, joined with the block just before them if joinCode.
+func blockSpans(runes []rune, start int, end int, joinCode bool) [][2]int {
+	var spans [][2]int
+	blockStart, fence, previousCode := start, "", false
+	for lineStart := start; lineStart < end; {
+		lineEnd := lineStart
+		for lineEnd < end && runes[lineEnd] != '\n' {
+			lineEnd++
+		}
+		if lineEnd < end {
+			lineEnd++ // With its newline
+		}
+		line := string(runes[lineStart:lineEnd])
+		if fence != "" {
+			if marker := codeFence.FindStringSubmatch(line); marker != nil && marker[1][0] == fence[0] && len(marker[1]) >= len(fence) {
+				fence = ""
+				code := [2]int{blockStart, lineEnd}
+				if joinCode && len(spans) > 0 && !previousCode {
+					spans[len(spans)-1][1] = lineEnd // The text introducing the code
+				} else {
+					spans = append(spans, code)
+				}
+				blockStart, previousCode = lineEnd, true
+			}
+		} else if marker := codeFence.FindStringSubmatch(line); marker != nil {
+			if blockStart < lineStart {
+				spans = append(spans, [2]int{blockStart, lineStart})
+				previousCode = false
+			}
+			fence, blockStart = marker[1], lineStart
+		} else if strings.TrimSpace(line) == "" {
+			if strings.TrimSpace(string(runes[blockStart:lineStart])) != "" {
+				spans = append(spans, [2]int{blockStart, lineStart})
+				previousCode = false
+			}
+			blockStart = lineEnd
+		}
+		lineStart = lineEnd
+	}
+	if blockStart < end && strings.TrimSpace(string(runes[blockStart:end])) != "" {
+		spans = append(spans, [2]int{blockStart, end}) // Including an unclosed code block
+	}
+	return spans
+}
+
+// packSpans adds chunks of consecutive spans of up to maxTokens words. Spans longer
+// than that are chunks of their own.
+func packSpans(spans [][2]int, runes []rune, maxTokens int, add func(start, end int)) {
+	packStart, packEnd, packTokens := -1, 0, 0
+	for _, span := range spans {
+		tokens := len(wordSpans(runes, span[0], span[1]))
+		if packStart >= 0 && packTokens+tokens > maxTokens {
+			add(packStart, packEnd)
+			packStart = -1
+		}
+		if packStart < 0 {
+			packStart, packTokens = span[0], 0
+		}
+		packEnd, packTokens = span[1], packTokens+tokens
+	}
+	if packStart >= 0 {
+		add(packStart, packEnd)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"hash/fnv"
+	"math/bits"
+	"strings"
+	"sync"
+)
+
+// Dedupe modes of ChunkerConfig.Dedupe
+const (
+	dedupeTag  = "tag"  // Mark repeated chunks with the chunk they repeat
+	dedupeDrop = "drop" // Leave repeated chunks out
+)
+
+// Near-duplicate chunks differ in at most maxChunkSimhashDistance bits of their
+// SimHash. Chunks of fewer than minNearDuplicateWords words only match exactly, as
+// the hashes of short texts are too noisy.
+const (
+	maxChunkSimhashDistance = 3
+	minNearDuplicateWords   = 8
+	chunkSimhashBands       = maxChunkSimhashDistance + 1 // Hashes within the distance agree on one band at least
+)
+
+// ChunkDuplicate names the chunk a repeated chunk repeats: its first occurrence
+type ChunkDuplicate struct {
+	URL   string `json:"url"`   // Of the page
+	Index int    `json:"index"` // Of the chunk on that page
+	Exact bool   `json:"exact"` // Same words, rather than nearly the same
+}
+
+// chunkDeduper finds chunks repeated across the pages of a crawl, such as shared
+// footers, legal text and warnings, identical up to case, punctuation and markup or
+// nearly identical (see maxChunkSimhashDistance)
+type chunkDeduper struct {
+	mutex sync.Mutex
+	exact map[uint64]ChunkDuplicate
+	bands [chunkSimhashBands]map[uint16][]chunkSimhash
+}
+
+// chunkSimhash is a chunk seen by a chunkDeduper
+type chunkSimhash struct {
+	hash  uint64
+	first ChunkDuplicate
+}
+
+// newChunkDeduper creates a deduper that has seen no chunks
+func newChunkDeduper() *chunkDeduper {
+	d := &chunkDeduper{exact: make(map[uint64]ChunkDuplicate)}
+	for i := range d.bands {
+		d.bands[i] = make(map[uint16][]chunkSimhash)
+	}
+	return d
+}
+
+// apply tags the chunks of a page repeating chunks seen before with their first
+// occurrence, or drops them, by mode; chunks of the page itself are compared too.
+// Without a mode, chunks are returned unchanged.
+func (d *chunkDeduper) apply(pageURL string, chunks []Chunk, mode string) []Chunk {
+	if mode != dedupeTag && mode != dedupeDrop {
+		return chunks
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	kept := make([]Chunk, 0, len(chunks))
+	for _, chunk := range chunks {
+		chunk.DuplicateOf = d.check(ChunkDuplicate{URL: pageURL, Index: chunk.Index}, chunk.Text)
+		if chunk.DuplicateOf == nil || mode == dedupeTag {
+			kept = append(kept, chunk)
+		}
+	}
+	return kept
+}
+
+// check returns the first occurrence of the text of a chunk, or records the chunk
+// as the first occurrence and returns nil; the mutex must be held
+func (d *chunkDeduper) check(chunk ChunkDuplicate, text string) *ChunkDuplicate {
+	words := retrievalTokens(text)
+	if len(words) == 0 {
+		return nil
+	}
+	exact := fnv.New64a()
+	exact.Write([]byte(strings.Join(words, " ")))
+	key := exact.Sum64()
+	if first, ok := d.exact[key]; ok {
+		first.Exact = true
+		return &first
+	}
+	d.exact[key] = chunk
+	if len(words) < minNearDuplicateWords {
+		return nil
+	}
+
+	hash := simhash(words)
+	for band := range d.bands {
+		for _, seen := range d.bands[band][bandOf(hash, band)] {
+			if bits.OnesCount64(hash^seen.hash) <= maxChunkSimhashDistance {
+				first := seen.first
+				return &first
+			}
+		}
+	}
+	for band := range d.bands {
+		d.bands[band][bandOf(hash, band)] = append(d.bands[band][bandOf(hash, band)], chunkSimhash{hash: hash, first: chunk})
+	}
+	return nil
+}
+
+// bandOf returns a 16-bit band of a hash
+func bandOf(hash uint64, band int) uint16 {
+	return uint16(hash >> (16 * band))
+}
+
+// simhash computes the SimHash of words: texts sharing most words get hashes
+// differing in few bits. Words rather than shingles of words are hashed, as the
+// chunks compared are short and a changed word would change several shingles.
+func simhash(words []string) uint64 {
+	var weights [64]int
+	for _, word := range words {
+		feature := fnv.New64a()
+		feature.Write([]byte(word))
+		sum := feature.Sum64()
+		for bit := 0; bit < 64; bit++ {
+			if sum&(1<<bit) != 0 {
+				weights[bit]++
+			} else {
+				weights[bit]--
+			}
+		}
+	}
+	var hash uint64
+	for bit, weight := range weights {
+		if weight > 0 {
+			hash |= 1 << bit
+		}
+	}
+	return hash
+}
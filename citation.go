@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+	"unicode"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+// maxFragmentWords bounds the words of a text fragment link (#:~:text=...)
+const maxFragmentWords = 8
+
+// ChunkCitation locates a chunk on its source page, so answers generated from it can
+// link back to the section it came from
+type ChunkCitation struct {
+	URL            string `json:"url"`             // Source page URL deep-linking to the chunk's section
+	Heading        string `json:"heading"`         // Text of the nearest enclosing heading, "" before the first heading
+	Anchor         string `json:"anchor"`          // ID of that heading (or the nearest heading above it with one) on the source page
+	MarkdownAnchor string `json:"markdown_anchor"` // GitHub-style anchor of the heading in the page markdown
+	Start          int    `json:"start"`           // Character offsets of the chunk in the page markdown
+	End            int    `json:"end"`
+}
+
+// pageChunks splits a page's markdown into chunks citing their sections of the page,
+// tagged with their topics and docs version
+func pageChunks(data *CrawledData, chunking ChunkerConfig) []Chunk {
+	outline := pageOutline(data)
+	chunks := chunking.chunk(data.Markdown, outline)
+	for i := range chunks {
+		chunks[i].Citation = newChunkCitation(data.URL, chunks[i])
+		chunks[i].Topics = chunkTopics(data, chunks[i])
+		chunks[i].DocsVersion = data.Metadata["docs_version"]
+	}
+	return chunks
+}
+
+// newChunkCitation cites a chunk by the anchor of its nearest heading on the source
+// page. Sections without one are linked with a text fragment of their heading, which
+// browsers scroll to, and chunks before the first heading with the page URL.
+func newChunkCitation(pageURL string, chunk Chunk) ChunkCitation {
+	base, _, _ := strings.Cut(pageURL, "#")
+	citation := ChunkCitation{URL: base, Start: chunk.Start, End: chunk.End}
+	if len(chunk.headings) == 0 {
+		return citation
+	}
+	nearest := chunk.headings[len(chunk.headings)-1]
+	citation.Heading, citation.MarkdownAnchor = nearest.Text, nearest.Anchor
+	for i := len(chunk.headings) - 1; i >= 0 && citation.Anchor == ""; i-- {
+		citation.Anchor = chunk.headings[i].SourceAnchor
+	}
+
+	if nearest.SourceAnchor != "" {
+		citation.URL = base + "#" + url.PathEscape(nearest.SourceAnchor)
+	} else if fragment := textFragment(nearest.Text); fragment != "" {
+		citation.URL = base + "#:~:text=" + fragment
+	} else if citation.Anchor != "" {
+		citation.URL = base + "#" + url.PathEscape(citation.Anchor)
+	}
+	return citation
+}
+
+// textFragment encodes the first words of text as the text directive of a URL fragment
+func textFragment(text string) string {
+	words := strings.Fields(text)
+	if len(words) > maxFragmentWords {
+		words = words[:maxFragmentWords]
+	}
+	// Dashes, commas and ampersands delimit the directive's parts
+	escaper := strings.NewReplacer("-", "%2D", ",", "%2C", "&", "%26")
+	return escaper.Replace(url.PathEscape(strings.Join(words, " ")))
+}
+
+// pageOutline returns the outline stored on a page, decoding it when the page was
+// read back from JSON (e.g. the cache) and rebuilding it when the page has none
+func pageOutline(data *CrawledData) []*OutlineNode {
+	switch outline := data.StructuredData["outline"].(type) {
+	case []*OutlineNode:
+		return outline
+	case []interface{}:
+		var decoded []*OutlineNode
+		if encoded, err := json.Marshal(outline); err == nil && json.Unmarshal(encoded, &decoded) == nil {
+			return decoded
+		}
+	}
+	return buildOutline(data.Markdown)
+}
+
+// addSourceAnchors records the IDs the headings of an outline have on the page
+// they were converted from, matching headings by their text in document order
+func addSourceAnchors(outline []*OutlineNode, content *goquery.Selection) {
+	anchors := make(map[string][]string) // Heading text to the anchors of its headings, in page order
+	content.Find("h1, h2, h3, h4, h5, h6").Each(func(_ int, heading *goquery.Selection) {
+		key := headingKey(heading.Text())
+		anchors[key] = append(anchors[key], headingAnchor(heading.Nodes[0]))
+	})
+	var walk func([]*OutlineNode)
+	walk = func(nodes []*OutlineNode) {
+		for _, node := range nodes {
+			key := headingKey(node.Text)
+			if queue := anchors[key]; len(queue) > 0 {
+				node.SourceAnchor, anchors[key] = queue[0], queue[1:]
+			}
+			walk(node.Children)
+		}
+	}
+	walk(outline)
+}
+
+// carrySourceAnchors copies the source anchors of an outline to the outline rebuilt
+// after its markdown changed, heading by heading in document order. It suits changes
+// keeping the headings (translation) or appending sections (transcripts).
+func carrySourceAnchors(from []*OutlineNode, to []*OutlineNode) {
+	var flatten func([]*OutlineNode, []*OutlineNode) []*OutlineNode
+	flatten = func(nodes []*OutlineNode, flat []*OutlineNode) []*OutlineNode {
+		for _, node := range nodes {
+			flat = flatten(node.Children, append(flat, node))
+		}
+		return flat
+	}
+	source, target := flatten(from, nil), flatten(to, nil)
+	for i := 0; i < len(source) && i < len(target); i++ {
+		target[i].SourceAnchor = source[i].SourceAnchor
+	}
+}
+
+// headingKey normalizes heading text for matching, ignoring case, whitespace,
+// punctuation and markup (such as the pilcrows of permalinks)
+func headingKey(text string) string {
+	var key strings.Builder
+	for _, r := range strings.ToLower(text) {
+		if unicode.IsLetter(r) || unicode.IsNumber(r) {
+			key.WriteRune(r)
+		}
+	}
+	return key.String()
+}
+
+// headingAnchor returns the fragment linking to a heading: its own ID, the target of
+// its permalink or the ID or name of an anchor in it, else the ID of the section it
+// starts or of an empty anchor just before it
+func headingAnchor(heading *html.Node) string {
+	if id, _ := attr(heading, "id"); id != "" {
+		return id
+	}
+	var inner string
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		for child := n.FirstChild; child != nil && inner == ""; child = child.NextSibling {
+			if child.Type != html.ElementNode {
+				continue
+			}
+			if href, _ := attr(child, "href"); child.Data == "a" && strings.HasPrefix(href, "#") && len(href) > 1 {
+				inner, _ = url.PathUnescape(href[1:])
+			} else if id, _ := attr(child, "id"); id != "" {
+				inner = id
+			} else if name, _ := attr(child, "name"); child.Data == "a" && name != "" {
+				inner = name
+			} else {
+				walk(child)
+			}
+		}
+	}
+	walk(heading)
+	if inner != "" {
+		return inner
+	}
+
+	previous := heading.PrevSibling
+	for previous != nil && previous.Type != html.ElementNode {
+		previous = previous.PrevSibling
+	}
+	if previous == nil && heading.Parent != nil && heading.Parent.Type == html.ElementNode {
+		if id, _ := attr(heading.Parent, "id"); id != "" && heading.Parent.Data != "body" {
+			return id // <section id="install"><h2>Install</h2>
+		}
+	}
+	if previous != nil && previous.Data == "a" && strings.TrimSpace(nodeText(previous)) == "" {
+		if id, _ := attr(previous, "id"); id != "" {
+			return id
+		}
+		name, _ := attr(previous, "name")
+		return name
+	}
+	return ""
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Comment is a reader comment of a page
+type Comment struct {
+	ID       string `json:"id,omitempty"`
+	ParentID string `json:"parent_id,omitempty"` // The comment replied to
+	Depth    int    `json:"depth"`               // 0 for top-level comments
+	Author   string `json:"author,omitempty"`
+	Date     string `json:"date,omitempty"`
+	Text     string `json:"text"`
+	Source   string `json:"source"` // "native" or "discourse"
+}
+
+// nativeCommentSelector matches single comments of WordPress-style threads
+// (ol.commentlist/ol.comments), common theme markup and schema.org Comment
+const nativeCommentSelector = "li.comment, article.comment, div.comment, ol.comments > li, ol.commentlist > li, ol.comment-list > li, [itemtype*='schema.org/Comment']"
+
+var discourseURLPattern = regexp.MustCompile(`discourseUrl\s*:\s*['"]([^'"]+)['"]`)
+
+// extractComments stores the comments of a page in its structured data
+// ("comments"): comment threads in the page itself and, for pages embedding
+// Discourse comments, the posts of the embedded topic
+func (c *Crawler) extractComments(data *CrawledData, dom *goquery.Selection) {
+	if dom == nil {
+		return
+	}
+	comments := nativeComments(dom)
+	if discourse, err := discourseComments(dom, data.URL); err != nil {
+		fmt.Printf("Discourse comments fetch failed for %s: %v\n", data.URL, err)
+	} else {
+		comments = append(comments, discourse...)
+	}
+	if len(comments) > 0 {
+		data.StructuredData["comments"] = comments
+	}
+}
+
+// nativeComments extracts the comments marked up in a page, in page order, with
+// their reply structure
+func nativeComments(dom *goquery.Selection) []Comment {
+	comments := []Comment{}
+	elements := dom.Find(nativeCommentSelector)
+	elements.Each(func(i int, element *goquery.Selection) {
+		// Parts of nested replies don't belong to this comment. Selectors are tried
+		// in order, the first one matching wins.
+		own := func(selectors ...string) *goquery.Selection {
+			for _, selector := range selectors {
+				match := element.Find(selector).FilterFunction(func(_ int, s *goquery.Selection) bool {
+					return s.ParentsFiltered(nativeCommentSelector).First().IsSelection(element)
+				}).First()
+				if match.Length() > 0 {
+					return match
+				}
+			}
+			return element.Slice(0, 0)
+		}
+
+		text := own(".comment-content", ".comment-text", "[itemprop='text']", ".comment-body")
+		if text.Length() == 0 {
+			text = element.Clone()
+			text.Find(nativeCommentSelector + ", .comment-meta, .comment-metadata, .comment-author, .reply, footer").Remove()
+		} else {
+			text = text.Clone()
+			text.Find(".reply, .comment-meta, .comment-metadata, .comment-author").Remove()
+		}
+		comment := Comment{
+			ID:     element.AttrOr("id", fmt.Sprintf("comment-%d", i+1)),
+			Author: strings.TrimSpace(own(".comment-author .fn", ".comment-author", "[itemprop='author']", ".author", ".username").Text()),
+			Text:   strings.Join(strings.Fields(text.Text()), " "),
+			Source: "native",
+		}
+		if date := own("time"); date.Length() > 0 {
+			comment.Date = date.AttrOr("datetime", strings.TrimSpace(date.Text()))
+		}
+		if comment.Text == "" {
+			return
+		}
+
+		parents := element.ParentsFiltered(nativeCommentSelector)
+		comment.Depth = parents.Length()
+		if parents.Length() > 0 {
+			comment.ParentID = parents.First().AttrOr("id", "")
+		}
+		comments = append(comments, comment)
+	})
+	return comments
+}
+
+// discourseComments fetches the posts of the Discourse topic embedded in a page
+// (the DiscourseEmbed snippet) from the forum's embed endpoint
+func discourseComments(dom *goquery.Selection, pageURL string) ([]Comment, error) {
+	if dom.Find("#discourse-comments").Length() == 0 {
+		return nil, nil
+	}
+	var discourseURL string
+	dom.Find("script").EachWithBreak(func(_ int, script *goquery.Selection) bool {
+		if match := discourseURLPattern.FindStringSubmatch(script.Text()); match != nil {
+			discourseURL = match[1]
+			return false
+		}
+		return true
+	})
+	if discourseURL == "" {
+		return nil, nil
+	}
+
+	embedURL := strings.TrimRight(discourseURL, "/") + "/embed/comments?embed_url=" + url.QueryEscape(pageURL)
+	body, _, err := downloadText(embedURL)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	var comments []Comment
+	doc.Find("article.post").Each(func(_ int, post *goquery.Selection) {
+		text := strings.Join(strings.Fields(post.Find(".cooked").Text()), " ")
+		if text == "" {
+			return
+		}
+		comment := Comment{
+			ID:     post.AttrOr("id", ""),
+			Author: strings.TrimSpace(post.Find(".username").First().Text()),
+			Text:   text,
+			Source: "discourse",
+		}
+		if date := post.Find(".post-date span[title]").First(); date.Length() > 0 {
+			comment.Date = date.AttrOr("title", "")
+		} else {
+			comment.Date = strings.TrimSpace(post.Find(".post-date").First().Text())
+		}
+		comments = append(comments, comment)
+	})
+	return comments, nil
+}
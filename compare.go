@@ -0,0 +1,264 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Limits of page diffs
+const (
+	diffContextLines = 3
+	maxDiffCells     = 4_000_000 // Lines of one page times lines of the other; longer pages are diffed as replaced
+)
+
+// CrawlComparison is what changed between two crawls of a site: the pages only the
+// later crawl has, those only the earlier one has, and those whose content changed.
+// Pages are matched by canonical URL and compared by content hash.
+type CrawlComparison struct {
+	JobA      string         `json:"job_a"`
+	JobB      string         `json:"job_b"`
+	Added     []ComparedPage `json:"added"`
+	Removed   []ComparedPage `json:"removed"`
+	Changed   []ComparedPage `json:"changed"`
+	Unchanged int            `json:"unchanged"`
+}
+
+// ComparedPage is a page of a comparison
+type ComparedPage struct {
+	URL          string `json:"url"` // Canonical URL the page is matched by
+	Title        string `json:"title"`
+	PageA        string `json:"page_a,omitempty"` // ID of the page in each job
+	PageB        string `json:"page_b,omitempty"`
+	HashA        string `json:"hash_a,omitempty"`
+	HashB        string `json:"hash_b,omitempty"`
+	LinesAdded   int    `json:"lines_added,omitempty"` // Of the markdown, for changed pages
+	LinesRemoved int    `json:"lines_removed,omitempty"`
+	DiffURL      string `json:"diff_url,omitempty"` // API path of the diff of the markdown, for changed pages
+}
+
+// comparisonKey returns the URL a page is matched across crawls by: its canonical URL,
+// else its URL, without a fragment
+func comparisonKey(pageURL string, data *CrawledData) string {
+	key := firstNonEmpty(data.Metadata["canonical_url"], pageURL)
+	key, _, _ = strings.Cut(key, "#")
+	return key
+}
+
+// pagesByKey returns the pages of a job's results by comparison key. Of pages sharing
+// a canonical URL, the first by URL stands for them.
+func pagesByKey(results map[string]*CrawledData) map[string]string {
+	pages := make(map[string]string)
+	for _, pageURL := range sortedResultURLs(results) {
+		key := comparisonKey(pageURL, results[pageURL])
+		if _, ok := pages[key]; !ok {
+			pages[key] = pageURL
+		}
+	}
+	return pages
+}
+
+// Compare compares two completed jobs of a tenant, the earlier one first
+func (m *JobManager) Compare(tenant *Tenant, jobA string, jobB string) (*CrawlComparison, error) {
+	_, resultsA, err := m.Results(tenant, jobA)
+	if err != nil {
+		return nil, err
+	}
+	_, resultsB, err := m.Results(tenant, jobB)
+	if err != nil {
+		return nil, err
+	}
+	pagesA, pagesB := pagesByKey(resultsA), pagesByKey(resultsB)
+	comparison := &CrawlComparison{JobA: jobA, JobB: jobB, Added: []ComparedPage{}, Removed: []ComparedPage{}, Changed: []ComparedPage{}}
+	for key, urlB := range pagesB {
+		dataB := resultsB[urlB]
+		page := ComparedPage{URL: key, Title: dataB.Metadata["title"], PageB: pageID(urlB), HashB: contentHash(dataB)}
+		urlA, ok := pagesA[key]
+		if !ok {
+			comparison.Added = append(comparison.Added, page)
+			continue
+		}
+		dataA := resultsA[urlA]
+		page.PageA, page.HashA = pageID(urlA), contentHash(dataA)
+		if page.HashA == page.HashB {
+			comparison.Unchanged++
+			continue
+		}
+		for _, line := range diffLines(markdownLines(dataA.Markdown), markdownLines(dataB.Markdown)) {
+			switch line.op {
+			case '+':
+				page.LinesAdded++
+			case '-':
+				page.LinesRemoved++
+			}
+		}
+		page.DiffURL = "/compare/diff?" + url.Values{"jobA": {jobA}, "jobB": {jobB}, "url": {key}}.Encode()
+		comparison.Changed = append(comparison.Changed, page)
+	}
+	for key, urlA := range pagesA {
+		if _, ok := pagesB[key]; !ok {
+			dataA := resultsA[urlA]
+			comparison.Removed = append(comparison.Removed, ComparedPage{URL: key, Title: dataA.Metadata["title"], PageA: pageID(urlA), HashA: contentHash(dataA)})
+		}
+	}
+	for _, pages := range [][]ComparedPage{comparison.Added, comparison.Removed, comparison.Changed} {
+		sort.Slice(pages, func(i, j int) bool { return pages[i].URL < pages[j].URL })
+	}
+	return comparison, nil
+}
+
+// CompareDiff returns the unified diff of the markdown of a page, by its canonical
+// URL, between two completed jobs of a tenant
+func (m *JobManager) CompareDiff(tenant *Tenant, jobA string, jobB string, key string) (string, error) {
+	_, resultsA, err := m.Results(tenant, jobA)
+	if err != nil {
+		return "", err
+	}
+	_, resultsB, err := m.Results(tenant, jobB)
+	if err != nil {
+		return "", err
+	}
+	urlA, okA := pagesByKey(resultsA)[key]
+	urlB, okB := pagesByKey(resultsB)[key]
+	if !okA || !okB {
+		return "", fmt.Errorf("%s was not crawled by both jobs", key)
+	}
+	return unifiedDiff(markdownLines(resultsA[urlA].Markdown), markdownLines(resultsB[urlB].Markdown), jobA+"/"+pageID(urlA), jobB+"/"+pageID(urlB)), nil
+}
+
+// markdownLines splits markdown into lines, ignoring trailing whitespace as
+// contentHash does
+func markdownLines(markdown string) []string {
+	lines := strings.Split(strings.TrimSpace(markdown), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t\r")
+	}
+	return lines
+}
+
+// diffLine is a line of a diff: ' ' kept, '-' removed or '+' added, with its line
+// numbers in each text (0-based; the side it isn't in is -1)
+type diffLine struct {
+	op   byte
+	text string
+	a, b int
+}
+
+// diffLines returns the lines of a and b as kept, removed and added lines, by their
+// longest common subsequence. Texts too long to compare are diffed as replaced.
+func diffLines(a []string, b []string) []diffLine {
+	// Common leading and trailing lines are kept without entering the table
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	var lines []diffLine
+	for i := 0; i < prefix; i++ {
+		lines = append(lines, diffLine{op: ' ', text: a[i], a: i, b: i})
+	}
+	middleA, middleB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	n, m := len(middleA), len(middleB)
+	if n*m > maxDiffCells {
+		for i, line := range middleA {
+			lines = append(lines, diffLine{op: '-', text: line, a: prefix + i, b: -1})
+		}
+		for j, line := range middleB {
+			lines = append(lines, diffLine{op: '+', text: line, a: -1, b: prefix + j})
+		}
+	} else {
+		// common[i][j] is the length of the longest common subsequence of middleA[i:] and middleB[j:]
+		common := make([][]int32, n+1)
+		for i := range common {
+			common[i] = make([]int32, m+1)
+		}
+		for i := n - 1; i >= 0; i-- {
+			for j := m - 1; j >= 0; j-- {
+				if middleA[i] == middleB[j] {
+					common[i][j] = common[i+1][j+1] + 1
+				} else {
+					common[i][j] = max(common[i+1][j], common[i][j+1])
+				}
+			}
+		}
+		i, j := 0, 0
+		for i < n || j < m {
+			switch {
+			case i < n && j < m && middleA[i] == middleB[j]:
+				lines = append(lines, diffLine{op: ' ', text: middleA[i], a: prefix + i, b: prefix + j})
+				i++
+				j++
+			case j == m || (i < n && common[i+1][j] >= common[i][j+1]):
+				lines = append(lines, diffLine{op: '-', text: middleA[i], a: prefix + i, b: -1})
+				i++
+			default:
+				lines = append(lines, diffLine{op: '+', text: middleB[j], a: -1, b: prefix + j})
+				j++
+			}
+		}
+	}
+	for k := 0; k < suffix; k++ {
+		lines = append(lines, diffLine{op: ' ', text: a[len(a)-suffix+k], a: len(a) - suffix + k, b: len(b) - suffix + k})
+	}
+	return lines
+}
+
+// unifiedDiff renders the diff of a and b in the unified format of diff -u, with
+// hunks of changes and diffContextLines of context; "" when they are the same
+func unifiedDiff(a []string, b []string, nameA string, nameB string) string {
+	lines := diffLines(a, b)
+	var out strings.Builder
+	for start := 0; start < len(lines); {
+		if lines[start].op == ' ' {
+			start++
+			continue
+		}
+		// A hunk runs from the context before a change to the context after the last
+		// change less than two contexts away from the one before it
+		first := max(start-diffContextLines, 0)
+		end := start
+		for k := start; k < len(lines) && k-end <= 2*diffContextLines; k++ {
+			if lines[k].op != ' ' {
+				end = k
+			}
+		}
+		last := min(end+diffContextLines, len(lines)-1)
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %s\n+++ %s\n", nameA, nameB)
+		}
+		startA, startB, countA, countB := -1, -1, 0, 0
+		for _, line := range lines[first : last+1] {
+			if line.op != '+' {
+				countA++
+				if startA < 0 {
+					startA = line.a
+				}
+			}
+			if line.op != '-' {
+				countB++
+				if startB < 0 {
+					startB = line.b
+				}
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(startA, countA), hunkRange(startB, countB))
+		for _, line := range lines[first : last+1] {
+			out.WriteString(string(line.op) + line.text + "\n")
+		}
+		start = last + 1
+	}
+	return out.String()
+}
+
+// hunkRange formats the line range of one side of a hunk as "start,count", 1-based.
+// A side without lines in a hunk is an empty text, numbered "0,0" as diff -u does.
+func hunkRange(start int, count int) string {
+	if count == 0 {
+		return "0,0"
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
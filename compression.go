@@ -0,0 +1,149 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// acceptEncoding is sent with static fetches. Responses are decoded by
+// decodingTransport, before colly reads them.
+const acceptEncoding = "br, zstd, gzip"
+
+// maxZstdWindow caps the memory a zstd response may make the decoder allocate
+const maxZstdWindow = 64 << 20
+
+// Bandwidth reports the bytes a crawl downloaded, as transferred and decoded, to
+// estimate transfer costs
+type Bandwidth struct {
+	WireBytes        int64   `json:"wire_bytes"` // Response bodies as transferred, compressed
+	Bytes            int64   `json:"bytes"`      // Response bodies once decoded
+	CompressionRatio float64 `json:"compression_ratio"`
+}
+
+// bandwidthCounter counts downloaded bytes
+type bandwidthCounter struct {
+	wire    atomic.Int64
+	decoded atomic.Int64
+}
+
+// totalBandwidth counts the bytes of every crawl since the server started, for /metrics
+var totalBandwidth bandwidthCounter
+
+// report returns the counted bytes
+func (b *bandwidthCounter) report() *Bandwidth {
+	bandwidth := &Bandwidth{WireBytes: b.wire.Load(), Bytes: b.decoded.Load()}
+	if bandwidth.Bytes > 0 {
+		bandwidth.CompressionRatio = float64(bandwidth.WireBytes) / float64(bandwidth.Bytes)
+	}
+	return bandwidth
+}
+
+// writeBandwidthMetrics writes the bytes of all crawls in the Prometheus text
+// exposition format
+func writeBandwidthMetrics(out *strings.Builder) {
+	out.WriteString("# HELP lexicrawler_fetched_bytes_total Bytes of static fetch responses, as transferred (wire) and decoded.\n# TYPE lexicrawler_fetched_bytes_total counter\n")
+	fmt.Fprintf(out, "lexicrawler_fetched_bytes_total{encoding=\"wire\"} %d\n", totalBandwidth.wire.Load())
+	fmt.Fprintf(out, "lexicrawler_fetched_bytes_total{encoding=\"decoded\"} %d\n", totalBandwidth.decoded.Load())
+}
+
+// BandwidthReport returns the bytes the crawl downloaded with static fetches
+func (c *Crawler) BandwidthReport() *Bandwidth {
+	return c.bandwidth.report()
+}
+
+// decodingTransport asks for compressed responses, decodes them transparently and
+// counts their bytes before and after decoding
+type decodingTransport struct {
+	next    http.RoundTripper
+	counter *bandwidthCounter
+}
+
+// RoundTrip sends a request accepting compressed responses and decodes the response
+func (t *decodingTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if request.Header.Get("Accept-Encoding") == "" {
+		request = request.Clone(request.Context())
+		request.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	response, err := t.next.RoundTrip(request)
+	if err != nil {
+		return nil, err
+	}
+
+	body := response.Body
+	wire := &countingReader{reader: body, counters: []*atomic.Int64{&t.counter.wire, &totalBandwidth.wire}}
+	encoding := strings.ToLower(strings.TrimSpace(response.Header.Get("Content-Encoding")))
+	decoded, closeDecoder, err := newDecoder(encoding, wire)
+	if err != nil {
+		body.Close()
+		return nil, fmt.Errorf("decoding %s response: %w", encoding, err)
+	}
+	if decoded != io.Reader(wire) {
+		response.Header.Del("Content-Encoding")
+		response.Header.Del("Content-Length")
+		response.ContentLength = -1
+		response.Uncompressed = true // Keeps colly from decoding gzip again
+	}
+	response.Body = &decodedBody{
+		Reader: &countingReader{reader: decoded, counters: []*atomic.Int64{&t.counter.decoded, &totalBandwidth.decoded}},
+		close: func() error {
+			closeDecoder()
+			return body.Close()
+		},
+	}
+	return response, nil
+}
+
+// newDecoder returns a reader decoding a body with the given Content-Encoding. Bodies
+// in other encodings are returned as they are.
+func newDecoder(encoding string, body io.Reader) (io.Reader, func(), error) {
+	switch encoding {
+	case "gzip", "x-gzip":
+		reader, err := gzip.NewReader(body) // Reads the header right away
+		if err == io.EOF {
+			return body, func() {}, nil // Empty, e.g. the body of a HEAD request
+		} else if err != nil {
+			return nil, nil, err
+		}
+		return reader, func() { reader.Close() }, nil
+	case "br":
+		return brotli.NewReader(body), func() {}, nil
+	case "zstd":
+		reader, err := zstd.NewReader(body, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxWindow(maxZstdWindow))
+		if err != nil {
+			return nil, nil, err
+		}
+		return reader, reader.Close, nil
+	}
+	return body, func() {}, nil
+}
+
+// countingReader adds the bytes read to counters
+type countingReader struct {
+	reader   io.Reader
+	counters []*atomic.Int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	for _, counter := range r.counters {
+		counter.Add(int64(n))
+	}
+	return n, err
+}
+
+// decodedBody is a decoded response body that closes the decoder and the original body
+type decodedBody struct {
+	io.Reader
+	close func() error
+}
+
+func (b *decodedBody) Close() error {
+	return b.close()
+}
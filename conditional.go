@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// pageETag returns the entity tag of a representation of a page, from its content
+// hash. The markdown is the page's own tag; other representations add their name.
+// Tags of JSON representations are weak, as they also hold fields outside the hash
+// (stats, diagnostics) that don't change what the page says.
+func pageETag(page *CrawledData, representation string, weak bool) string {
+	hash := page.ContentHash
+	if hash == "" {
+		hash = contentHash(page)
+	}
+	if representation != "" {
+		hash += "-" + representation
+	}
+	if weak {
+		return `W/"` + hash + `"`
+	}
+	return `"` + hash + `"`
+}
+
+// notModified sets the validators of a response and reports whether the request's
+// conditional headers show the client's copy is current, so it gets a 304 instead.
+// As RFC 9110 requires, If-Modified-Since only counts without If-None-Match.
+func notModified(c *fiber.Ctx, etag string, modified time.Time) bool {
+	c.Set(fiber.HeaderETag, etag)
+	if !modified.IsZero() {
+		c.Set(fiber.HeaderLastModified, modified.UTC().Format(http.TimeFormat))
+	}
+	c.Set(fiber.HeaderCacheControl, "private, no-cache") // Results are per tenant, and revalidated on every use
+	if noneMatch := c.Get(fiber.HeaderIfNoneMatch); noneMatch != "" {
+		for _, candidate := range strings.Split(noneMatch, ",") {
+			if candidate = strings.TrimSpace(candidate); candidate == "*" || weakETag(candidate) == weakETag(etag) {
+				return true
+			}
+		}
+		return false
+	}
+	if modifiedSince := c.Get(fiber.HeaderIfModifiedSince); modifiedSince != "" && !modified.IsZero() {
+		since, err := http.ParseTime(modifiedSince)
+		return err == nil && !modified.Truncate(time.Second).After(since)
+	}
+	return false
+}
+
+// weakETag strips the weakness indicator of an entity tag, for the weak comparison
+// of If-None-Match
+func weakETag(etag string) string {
+	return strings.TrimPrefix(etag, "W/")
+}
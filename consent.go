@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+// consentWait is how long a page whose consent manager is loaded but hasn't shown
+// its banner yet is polled for it
+const (
+	consentWait         = 2 * time.Second
+	consentPollInterval = 250 * time.Millisecond
+)
+
+// dismissConsentScript clicks the reject (or, failing that, accept) button of the
+// consent managers below, removes their overlay and unlocks scrolling. It returns the
+// name of the dismissed manager, "pending" while a known consent API is loaded
+// without its banner, or "" for pages without one. Usercentrics renders into a
+// shadow root and Sourcepoint into a cross-origin iframe, which is only removed.
+const dismissConsentScript = `(() => {
+	const managers = [
+		{name: "onetrust", container: "#onetrust-consent-sdk", reject: "#onetrust-reject-all-handler", accept: "#onetrust-accept-btn-handler"},
+		{name: "cookiebot", container: "#CybotCookiebotDialog", reject: "#CybotCookiebotDialogBodyButtonDecline", accept: "#CybotCookiebotDialogBodyLevelButtonLevelOptinAllowAll, #CybotCookiebotDialogBodyButtonAccept"},
+		{name: "didomi", container: "#didomi-host", reject: "#didomi-notice-disagree-button", accept: "#didomi-notice-agree-button"},
+		{name: "quantcast", container: ".qc-cmp2-container", reject: ".qc-cmp2-summary-buttons button[mode='secondary']", accept: ".qc-cmp2-summary-buttons button[mode='primary']"},
+		{name: "trustarc", container: "#truste-consent-track, .truste_overlay, .truste_box_overlay", reject: "#truste-consent-required", accept: "#truste-consent-button"},
+		{name: "usercentrics", container: "#usercentrics-root", shadow: true, reject: "[data-testid='uc-deny-all-button']", accept: "[data-testid='uc-accept-all-button']"},
+		{name: "osano", container: ".osano-cm-window", reject: ".osano-cm-denyAll", accept: ".osano-cm-accept-all"},
+		{name: "cookieyes", container: ".cky-consent-container, .cky-overlay", reject: ".cky-btn-reject", accept: ".cky-btn-accept"},
+		{name: "complianz", container: "#cmplz-cookiebanner-container, .cmplz-cookiebanner", reject: ".cmplz-deny", accept: ".cmplz-accept"},
+		{name: "klaro", container: ".klaro", reject: ".cm-btn-decline", accept: ".cm-btn-success"},
+		{name: "sourcepoint", container: "[id^='sp_message_container']"},
+	];
+	for (const manager of managers) {
+		const containers = document.querySelectorAll(manager.container);
+		if (containers.length === 0) continue;
+		const root = manager.shadow ? containers[0].shadowRoot : document;
+		if (root && (manager.reject || manager.accept)) {
+			const button = (manager.reject && root.querySelector(manager.reject)) || (manager.accept && root.querySelector(manager.accept));
+			if (!button) continue;
+			button.click();
+		}
+		containers.forEach(element => element.remove());
+		for (const element of [document.documentElement, document.body]) {
+			element.style.setProperty("overflow", "visible", "important");
+			element.classList.remove("noscroll", "no-scroll", "modal-open");
+		}
+		return manager.name;
+	}
+	return (window.OneTrust || window.Cookiebot || window.Didomi || window.UC_UI || window.__tcfapi || window.__cmp) ? "pending" : "";
+})()`
+
+// dismissConsent closes the cookie consent banner of a rendered page before its
+// HTML and screenshot are taken
+func (c *Crawler) dismissConsent(pageURL string) chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		if c.Config.KeepCookieBanners {
+			return nil
+		}
+		deadline := time.Now().Add(consentWait)
+		for {
+			var manager string
+			if err := chromedp.Evaluate(dismissConsentScript, &manager).Do(ctx); err != nil {
+				return nil // Consent handling never fails a render
+			}
+			if manager != "pending" {
+				if manager != "" {
+					fmt.Printf("Dismissed %s consent banner on %s\n", manager, pageURL)
+				}
+				return nil
+			}
+			if time.Now().After(deadline) {
+				return nil
+			}
+			if err := chromedp.Sleep(consentPollInterval).Do(ctx); err != nil {
+				return nil
+			}
+		}
+	})
+}
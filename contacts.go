@@ -0,0 +1,232 @@
+package main
+
+import (
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// PageContacts are the contact details found on a page
+type PageContacts struct {
+	Emails []string        `json:"emails,omitempty"`
+	Phones []string        `json:"phones,omitempty"` // Digits with a leading + for international numbers
+	Social []SocialProfile `json:"social,omitempty"`
+}
+
+// SocialProfile is a link to a profile on a social network
+type SocialProfile struct {
+	Network string `json:"network"`
+	URL     string `json:"url"`
+}
+
+// SiteContacts is the contact report of one site: every distinct contact with
+// the pages it was found on
+type SiteContacts struct {
+	Host   string          `json:"host"`
+	Emails []ContactSource `json:"emails"`
+	Phones []ContactSource `json:"phones"`
+	Social []ContactSource `json:"social"`
+}
+
+// ContactSource is a harvested contact and the pages it appears on
+type ContactSource struct {
+	Value   string   `json:"value"`
+	Network string   `json:"network,omitempty"` // For social profiles
+	Pages   []string `json:"pages"`
+}
+
+// socialNetworks maps the hosts of social networks to their names
+var socialNetworks = map[string]string{
+	"twitter.com":   "x",
+	"x.com":         "x",
+	"facebook.com":  "facebook",
+	"instagram.com": "instagram",
+	"linkedin.com":  "linkedin",
+	"youtube.com":   "youtube",
+	"github.com":    "github",
+	"gitlab.com":    "gitlab",
+	"tiktok.com":    "tiktok",
+	"pinterest.com": "pinterest",
+	"threads.net":   "threads",
+	"bsky.app":      "bluesky",
+	"medium.com":    "medium",
+	"reddit.com":    "reddit",
+	"t.me":          "telegram",
+}
+
+// socialSharePaths are the share and intent endpoints linked from share buttons,
+// which aren't profiles
+var socialSharePaths = []string{"/share", "/sharer", "/intent/", "/sharearticle", "/pin/create", "/submit", "/watch", "/embed/", "/hashtag/", "/search"}
+
+var (
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`)
+	// phonePattern only matches numbers written with a country code or an area code
+	// in parentheses, so dates, prices and IDs in text aren't taken for phones
+	phonePattern = regexp.MustCompile(`(?:\+\d{1,3}[\s.-]?(?:\(\d{1,4}\)[\s.-]?)?\d{1,4}(?:[\s.-]?\d{2,4}){2,4})|(?:\(\d{2,4}\)\s?\d{3,4}[\s.-]\d{3,4})`)
+)
+
+// harvestContacts collects the email addresses, phone numbers and social profile
+// links of a page: mailto: and tel: links, addresses and numbers in its text and
+// links to profiles on social networks
+func harvestContacts(doc *goquery.Selection, pageURL string) *PageContacts {
+	contacts := &PageContacts{}
+	doc.Find("a[href]").Each(func(_ int, a *goquery.Selection) {
+		href := strings.TrimSpace(a.AttrOr("href", ""))
+		switch lower := strings.ToLower(href); {
+		case strings.HasPrefix(lower, "mailto:"):
+			address, _, _ := strings.Cut(href[len("mailto:"):], "?")
+			if address, err := url.PathUnescape(address); err == nil {
+				for _, email := range strings.Split(address, ",") {
+					contacts.addEmail(email)
+				}
+			}
+		case strings.HasPrefix(lower, "tel:"):
+			if number, err := url.PathUnescape(href[len("tel:"):]); err == nil {
+				contacts.addPhone(number)
+			}
+		default:
+			if profile, ok := socialProfile(resolveURL(pageURL, href)); ok {
+				for _, existing := range contacts.Social {
+					if existing.URL == profile.URL {
+						return
+					}
+				}
+				contacts.Social = append(contacts.Social, profile)
+			}
+		}
+	})
+
+	text := visibleText(doc.Find("body"))
+	for _, email := range emailPattern.FindAllString(text, -1) {
+		contacts.addEmail(email)
+	}
+	for _, number := range phonePattern.FindAllString(text, -1) {
+		contacts.addPhone(number)
+	}
+	if len(contacts.Emails) == 0 && len(contacts.Phones) == 0 && len(contacts.Social) == 0 {
+		return nil
+	}
+	return contacts
+}
+
+// addEmail adds an address, lowercased, unless it is already known or is an
+// image name such as logo@2x.png
+func (contacts *PageContacts) addEmail(email string) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	switch {
+	case !emailPattern.MatchString(email) || emailPattern.FindString(email) != email:
+		return
+	case strings.HasSuffix(email, ".png"), strings.HasSuffix(email, ".jpg"), strings.HasSuffix(email, ".jpeg"),
+		strings.HasSuffix(email, ".gif"), strings.HasSuffix(email, ".svg"), strings.HasSuffix(email, ".webp"):
+		return
+	}
+	contacts.Emails = appendUnique(contacts.Emails, email)
+}
+
+// addPhone adds a number reduced to its digits (and a leading +), so different
+// spellings of the same number are only listed once
+func (contacts *PageContacts) addPhone(number string) {
+	number = normalizePhone(number)
+	if digits := strings.TrimPrefix(number, "+"); len(digits) < 7 || len(digits) > 15 { // E.164 allows 15 digits
+		return
+	}
+	contacts.Phones = appendUnique(contacts.Phones, number)
+}
+
+// normalizePhone strips the formatting of a phone number
+func normalizePhone(number string) string {
+	number = strings.TrimSpace(number)
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, number)
+	if strings.HasPrefix(number, "+") {
+		return "+" + digits
+	}
+	return digits
+}
+
+// socialProfile recognizes a link to a profile on a social network and
+// normalizes it (https, no www., query or trailing slash)
+func socialProfile(link string) (SocialProfile, bool) {
+	linkURL, err := url.Parse(link)
+	if err != nil || (linkURL.Scheme != "http" && linkURL.Scheme != "https") {
+		return SocialProfile{}, false
+	}
+	host := strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(linkURL.Hostname()), "www."), "m.")
+	network, ok := socialNetworks[host]
+	profilePath := strings.TrimRight(linkURL.Path, "/")
+	if !ok || profilePath == "" {
+		return SocialProfile{}, false
+	}
+	lowerPath := strings.ToLower(profilePath)
+	for _, sharePath := range socialSharePaths {
+		if strings.HasPrefix(lowerPath, sharePath) {
+			return SocialProfile{}, false
+		}
+	}
+	return SocialProfile{Network: network, URL: "https://" + host + profilePath}, true
+}
+
+// buildContactReport merges the contacts of the crawled pages into one report per
+// site (host), listing each distinct contact once with the pages it was found on
+func buildContactReport(crawledDataMap map[string]*CrawledData) []SiteContacts {
+	type siteSources struct {
+		emails, phones, social map[string]*ContactSource
+	}
+	sites := map[string]*siteSources{}
+	add := func(sources map[string]*ContactSource, value, network, pageURL string) {
+		source, ok := sources[value]
+		if !ok {
+			source = &ContactSource{Value: value, Network: network}
+			sources[value] = source
+		}
+		source.Pages = append(source.Pages, pageURL)
+	}
+
+	for pageURL, data := range crawledDataMap {
+		contacts, ok := data.StructuredData["contacts"].(*PageContacts)
+		if !ok || contacts == nil {
+			continue
+		}
+		host := pageURL
+		if parsedURL, err := url.Parse(pageURL); err == nil && parsedURL.Host != "" {
+			host = parsedURL.Host
+		}
+		site, ok := sites[host]
+		if !ok {
+			site = &siteSources{map[string]*ContactSource{}, map[string]*ContactSource{}, map[string]*ContactSource{}}
+			sites[host] = site
+		}
+		for _, email := range contacts.Emails {
+			add(site.emails, email, "", pageURL)
+		}
+		for _, phone := range contacts.Phones {
+			add(site.phones, phone, "", pageURL)
+		}
+		for _, profile := range contacts.Social {
+			add(site.social, profile.URL, profile.Network, pageURL)
+		}
+	}
+
+	sorted := func(sources map[string]*ContactSource) []ContactSource {
+		list := []ContactSource{}
+		for _, source := range sources {
+			sort.Strings(source.Pages)
+			list = append(list, *source)
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].Value < list[j].Value })
+		return list
+	}
+	var report []SiteContacts
+	for host, site := range sites {
+		report = append(report, SiteContacts{Host: host, Emails: sorted(site.emails), Phones: sorted(site.phones), Social: sorted(site.social)})
+	}
+	sort.Slice(report, func(i, j int) bool { return report[i].Host < report[j].Host })
+	return report
+}
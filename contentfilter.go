@@ -0,0 +1,196 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Reasons of SkippedContent.Reason
+const (
+	skipExtension   = "extension"    // The URL's extension is blocked
+	skipContentType = "content_type" // The response's Content-Type is blocked or can't be processed
+	skipSize        = "size"         // The response announced more than MaxBytes
+)
+
+// defaultBlockedExtensions are the extensions of binary and irrelevant assets,
+// skipped unless BlockExtensions replaces them
+var defaultBlockedExtensions = wordSet(`zip tar gz tgz bz2 xz 7z rar exe msi dmg pkg deb rpm apk iso bin jar
+	mp4 mov avi mkv webm wmv flv m4v mp3 wav flac aac ogg m4a jpg jpeg png gif webp svg ico bmp tif tiff avif
+	woff woff2 ttf otf eot css js map pdf doc docx xls xlsx ppt pptx odt ods odp`)
+
+// ContentFilter keeps a crawl from downloading binary and irrelevant assets. URLs are
+// skipped by extension before they are fetched; static fetches are skipped by their
+// Content-Type and Content-Length once the response headers arrive, before the body
+// is downloaded. Responses of types the crawler can't process (anything but HTML,
+// JSON and XML) are always skipped then.
+type ContentFilter struct {
+	BlockExtensions   []string `json:"block_extensions"`    // Extensions skipped, without the dot; defaultBlockedExtensions when omitted
+	AllowExtensions   []string `json:"allow_extensions"`    // When set, only URLs with one of these extensions, or none, are fetched
+	BlockContentTypes []string `json:"block_content_types"` // Content types skipped, e.g. "application/json" or "application/*"
+	AllowContentTypes []string `json:"allow_content_types"` // When set, only responses of these content types are downloaded
+	MaxBytes          int64    `json:"max_bytes"`           // Responses announcing a larger Content-Length are skipped, 0 for no limit
+}
+
+// SkippedContent counts the URLs of a crawl skipped for a reason and type
+type SkippedContent struct {
+	Reason string `json:"reason"` // "extension", "content_type" or "size"
+	Type   string `json:"type"`   // The extension, or the content type
+	URLs   int    `json:"urls"`
+}
+
+// skippedError is a fetch the content filter skipped. Skipped types the crawler
+// can't process are errNotHTML too.
+type skippedError struct {
+	reason  string
+	value   string
+	notHTML bool
+}
+
+func (e *skippedError) Error() string {
+	return fmt.Sprintf("skipped by %s %s", strings.ReplaceAll(e.reason, "_", " "), e.value)
+}
+
+func (e *skippedError) Unwrap() error {
+	if e.notHTML {
+		return errNotHTML
+	}
+	return nil
+}
+
+// normalized validates a filter and returns it with lowercase extensions without
+// dots and lowercase content types
+func (filter ContentFilter) normalized() (ContentFilter, error) {
+	if filter.MaxBytes < 0 {
+		return ContentFilter{}, errors.New("content_filter max_bytes must not be negative")
+	}
+	var err error
+	for _, list := range []*[]string{&filter.BlockExtensions, &filter.AllowExtensions} {
+		if *list == nil {
+			continue
+		}
+		normalized := []string{}
+		for _, extension := range *list {
+			extension = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(extension), "."))
+			if extension == "" || strings.ContainsAny(extension, "/. ") {
+				err = fmt.Errorf("invalid extension %q in content_filter", extension)
+			}
+			normalized = append(normalized, extension)
+		}
+		*list = normalized
+	}
+	for _, list := range []*[]string{&filter.BlockContentTypes, &filter.AllowContentTypes} {
+		if *list == nil {
+			continue
+		}
+		normalized := []string{}
+		for _, contentType := range *list {
+			contentType = strings.ToLower(strings.TrimSpace(contentType))
+			major, minor, ok := strings.Cut(contentType, "/")
+			if !ok || major == "" || minor == "" || strings.ContainsAny(contentType, " ;") {
+				err = fmt.Errorf("invalid content type %q in content_filter (want e.g. video/mp4 or video/*)", contentType)
+			}
+			normalized = append(normalized, contentType)
+		}
+		*list = normalized
+	}
+	if err != nil {
+		return ContentFilter{}, err
+	}
+	return filter, nil
+}
+
+// skipsURL returns the extension of a URL and whether the filter skips it
+func (filter ContentFilter) skipsURL(pageURL string) (string, bool) {
+	parsedURL, err := url.Parse(pageURL)
+	if err != nil {
+		return "", false
+	}
+	extension := strings.ToLower(strings.TrimPrefix(path.Ext(parsedURL.Path), "."))
+	if extension == "" {
+		return "", false
+	}
+	if filter.AllowExtensions != nil {
+		return extension, !containsString(filter.AllowExtensions, extension)
+	}
+	if filter.BlockExtensions != nil {
+		return extension, containsString(filter.BlockExtensions, extension)
+	}
+	return extension, defaultBlockedExtensions[extension]
+}
+
+// skipsResponse returns why the filter skips a response by its headers, nil when
+// its body is downloaded
+func (filter ContentFilter) skipsResponse(header http.Header) *skippedError {
+	contentType := header.Get("Content-Type")
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	}
+	if filter.MaxBytes > 0 {
+		if length, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64); err == nil && length > filter.MaxBytes {
+			return &skippedError{reason: skipSize, value: firstNonEmpty(mediaType, "unknown")}
+		}
+	}
+	if mediaType == "" {
+		return nil // Sniffed as HTML, as isHTMLContentType does
+	}
+	switch {
+	case filter.AllowContentTypes != nil && !contentTypeListed(filter.AllowContentTypes, mediaType),
+		contentTypeListed(filter.BlockContentTypes, mediaType):
+		return &skippedError{reason: skipContentType, value: mediaType}
+	case !isHTMLContentType(mediaType) && !isDocumentContentType(mediaType):
+		return &skippedError{reason: skipContentType, value: mediaType, notHTML: true}
+	}
+	return nil
+}
+
+// contentTypeListed reports whether a media type is in a list of content types, in
+// which "type/*" stands for every subtype
+func contentTypeListed(list []string, mediaType string) bool {
+	for _, listed := range list {
+		if listed == mediaType || (strings.HasSuffix(listed, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(listed, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// recordSkipped counts a URL the content filter skipped
+func (c *Crawler) recordSkipped(skipped *skippedError) {
+	key := skipped.reason + " " + skipped.value
+	c.SkippedMutex.Lock()
+	defer c.SkippedMutex.Unlock()
+	if c.Skipped == nil {
+		c.Skipped = make(map[string]*SkippedContent)
+	}
+	counter, ok := c.Skipped[key]
+	if !ok {
+		counter = &SkippedContent{Reason: skipped.reason, Type: skipped.value}
+		c.Skipped[key] = counter
+	}
+	counter.URLs++
+}
+
+// SkippedReport returns the counts of skipped URLs, the most frequent first
+func (c *Crawler) SkippedReport() []SkippedContent {
+	c.SkippedMutex.Lock()
+	defer c.SkippedMutex.Unlock()
+	var report []SkippedContent
+	for _, counter := range c.Skipped {
+		report = append(report, *counter)
+	}
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].URLs != report[j].URLs {
+			return report[i].URLs > report[j].URLs
+		}
+		return report[i].Reason+report[i].Type < report[j].Reason+report[j].Type
+	})
+	return report
+}
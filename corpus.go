@@ -0,0 +1,640 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	fiberlog "github.com/gofiber/fiber/v2/log"
+)
+
+var (
+	errCorpusNotFound   = errors.New("corpus not found")
+	errDocumentNotFound = errors.New("document not found")
+)
+
+// corpusNamePattern is the form of corpus names, which appear in URLs
+var corpusNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// Corpus is a named collection of documents that crawl jobs add to, the stable unit
+// retrieval indexes are built from. A URL is in a corpus once, as last crawled.
+type Corpus struct {
+	Name         string         `json:"name"`
+	TenantID     string         `json:"tenant_id"`
+	CreatedAt    time.Time      `json:"created_at"`
+	UpdatedAt    time.Time      `json:"updated_at"`
+	Jobs         []string       `json:"jobs"` // Jobs that added documents, oldest first
+	Documents    int            `json:"documents"`
+	StorageBytes int64          `json:"storage_bytes"`
+	Domains      map[string]int `json:"domains,omitempty"` // Documents per host, returned by Get
+	Topics       map[string]int `json:"topics,omitempty"`  // Documents per topic, returned by Get
+
+	Policy         CorpusPolicy `json:"policy"`
+	StaleDocuments int          `json:"stale_documents"`       // Documents due for a recrawl under the policy
+	RecrawlJob     string       `json:"recrawl_job,omitempty"` // Last job recrawling stale documents
+
+	documents map[string]*CorpusDocument // By URL
+}
+
+// CorpusDocument is a crawled page in a corpus
+type CorpusDocument struct {
+	ID          string    `json:"id"` // Page ID of the URL, as in jobs
+	URL         string    `json:"url"`
+	Title       string    `json:"title"`
+	Host        string    `json:"host"`
+	JobID       string    `json:"job_id"`     // Job that crawled this version
+	CrawledAt   time.Time `json:"crawled_at"` // When that job finished
+	ContentHash string    `json:"content_hash"`
+	Topics      []string  `json:"topics,omitempty"` // See TopicConfig
+	Stale       bool      `json:"stale"`            // Due for a recrawl under the corpus's policy, set by Documents
+
+	data *CrawledData
+}
+
+// CorpusChange counts the documents a change of a corpus touched
+type CorpusChange struct {
+	Added     int `json:"added"`
+	Updated   int `json:"updated"`
+	Unchanged int `json:"unchanged"`
+	Removed   int `json:"removed"`
+}
+
+// CorpusPrune selects the documents removed by a prune. Given both, documents must
+// match both.
+type CorpusPrune struct {
+	OlderThanDays int      `json:"older_than_days"` // Crawled more than this many days ago
+	Domains       []string `json:"domains"`         // On these hosts or their subdomains
+}
+
+// CorpusManager keeps the corpora of all tenants in memory, and on disk when
+// LEXICRAWLER_DATA_DIR is set
+type CorpusManager struct {
+	corpora map[string]*Corpus // By corpusKey
+	store   *corpusStore       // nil to keep corpora in memory only
+	mutex   sync.Mutex
+}
+
+// NewCorpusManager loads the corpora kept in LEXICRAWLER_DATA_DIR, or starts without
+// corpora, in memory, when it isn't set
+func NewCorpusManager() (*CorpusManager, error) {
+	m := &CorpusManager{corpora: make(map[string]*Corpus)}
+	path := corpusStorePathFromEnv()
+	if path == "" {
+		return m, nil
+	}
+	store, corpora, err := openCorpusStore(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", path, err)
+	}
+	m.store = store
+	for _, corpus := range corpora {
+		m.corpora[corpusKey(corpus.TenantID, corpus.Name)] = corpus
+	}
+	return m, nil
+}
+
+// corpusKey identifies a corpus among those of all tenants
+func corpusKey(tenantID string, name string) string {
+	return tenantID + "/" + name
+}
+
+// validateCorpusName checks the name of a corpus
+func validateCorpusName(name string) error {
+	if !corpusNamePattern.MatchString(name) {
+		return errors.New("corpus names are 1 to 64 letters, digits, dots, dashes and underscores, starting with a letter or digit")
+	}
+	return nil
+}
+
+// newCorpusDocument wraps a page crawled by a job for a corpus
+func newCorpusDocument(pageURL string, data *CrawledData, jobID string, crawledAt time.Time) *CorpusDocument {
+	host := ""
+	if parsedURL, err := url.Parse(pageURL); err == nil {
+		host = strings.ToLower(parsedURL.Hostname())
+	}
+	return &CorpusDocument{
+		ID:          pageID(pageURL),
+		URL:         pageURL,
+		Title:       data.Metadata["title"],
+		Host:        host,
+		JobID:       jobID,
+		CrawledAt:   crawledAt,
+		ContentHash: data.ContentHash,
+		Topics:      pageTopicNames(data),
+		data:        detachPage(data),
+	}
+}
+
+// detachPage copies a page for a corpus, reading raw HTML spilled to disk back into
+// memory, as the spill files of a job are removed with the job
+func detachPage(data *CrawledData) *CrawledData {
+	detached := *data
+	if data.rawHTMLFile != "" {
+		detached.rawHTMLFile = ""
+		if compressed, err := os.ReadFile(data.rawHTMLFile); err == nil {
+			detached.rawHTMLZstd = compressed
+		}
+	}
+	return &detached
+}
+
+// snapshot copies a corpus with its document counts and size, and those per host and
+// topic withDomains; the manager's mutex must be held
+func (c *Corpus) snapshot(withDomains bool) Corpus {
+	snapshot := *c
+	snapshot.Jobs = append([]string{}, c.Jobs...)
+	snapshot.Documents = len(c.documents)
+	snapshot.StaleDocuments = len(c.staleURLs(time.Now()))
+	snapshot.StorageBytes = 0
+	if withDomains {
+		snapshot.Domains = make(map[string]int)
+		snapshot.Topics = make(map[string]int)
+	}
+	for _, document := range c.documents {
+		snapshot.StorageBytes += crawledDataSize(document.data)
+		if withDomains {
+			snapshot.Domains[document.Host]++
+			for _, topic := range document.Topics {
+				snapshot.Topics[topic]++
+			}
+		}
+	}
+	snapshot.documents = nil
+	return snapshot
+}
+
+// lookup returns a tenant's corpus; the manager's mutex must be held
+func (m *CorpusManager) lookup(tenant *Tenant, name string) (*Corpus, error) {
+	corpus, ok := m.corpora[corpusKey(tenant.ID, name)]
+	if !ok {
+		return nil, errCorpusNotFound
+	}
+	return corpus, nil
+}
+
+// upsert adds documents to a corpus, created if needed, replacing the versions of
+// their URLs crawled before them, and saves the change; the manager's mutex must be held
+func (m *CorpusManager) upsert(tenantID string, name string, documents []*CorpusDocument, jobIDs []string) (CorpusChange, error) {
+	var change CorpusChange
+	key := corpusKey(tenantID, name)
+	corpus, exists := m.corpora[key]
+	now := time.Now()
+	if !exists {
+		corpus = &Corpus{Name: name, TenantID: tenantID, CreatedAt: now, documents: make(map[string]*CorpusDocument)}
+	}
+	changed := make(map[string]*CorpusDocument) // By URL, as a merge may bring a URL more than once
+	for _, document := range documents {
+		current, ok := changed[document.URL]
+		if ok {
+			if !current.CrawledAt.After(document.CrawledAt) {
+				changed[document.URL] = document
+			}
+			continue
+		}
+		current, ok = corpus.documents[document.URL]
+		switch {
+		case !ok:
+			change.Added++
+		case current.CrawledAt.After(document.CrawledAt):
+			change.Unchanged++ // A later crawl is in the corpus already
+			continue
+		case current.ContentHash == document.ContentHash && current.ContentHash != "":
+			change.Unchanged++
+			if current.CrawledAt.Equal(document.CrawledAt) {
+				continue
+			}
+		default:
+			change.Updated++
+		}
+		changed[document.URL] = document
+	}
+	jobs := corpus.Jobs
+	for _, jobID := range jobIDs {
+		if !containsString(jobs, jobID) {
+			jobs = append(jobs, jobID)
+		}
+	}
+
+	updated := *corpus
+	updated.Jobs = jobs
+	if len(changed) > 0 || !exists {
+		updated.UpdatedAt = now
+	}
+	if m.store != nil {
+		saved := make([]*CorpusDocument, 0, len(changed))
+		for _, document := range changed {
+			saved = append(saved, document)
+		}
+		if err := m.store.save(&updated, saved, nil); err != nil {
+			return CorpusChange{}, err
+		}
+	}
+	for _, document := range changed {
+		corpus.documents[document.URL] = document
+	}
+	corpus.Jobs, corpus.UpdatedAt = updated.Jobs, updated.UpdatedAt
+	m.corpora[key] = corpus
+	return change, nil
+}
+
+// remove deletes the documents of a corpus that match, and saves the change; the
+// manager's mutex must be held
+func (m *CorpusManager) remove(corpus *Corpus, match func(*CorpusDocument) bool) (CorpusChange, error) {
+	var removed []string
+	for documentURL, document := range corpus.documents {
+		if match(document) {
+			removed = append(removed, documentURL)
+		}
+	}
+	if len(removed) == 0 {
+		return CorpusChange{Unchanged: len(corpus.documents)}, nil
+	}
+	updated := *corpus
+	updated.UpdatedAt = time.Now()
+	if m.store != nil {
+		if err := m.store.save(&updated, nil, removed); err != nil {
+			return CorpusChange{}, err
+		}
+	}
+	for _, documentURL := range removed {
+		delete(corpus.documents, documentURL)
+	}
+	corpus.UpdatedAt = updated.UpdatedAt
+	return CorpusChange{Removed: len(removed), Unchanged: len(corpus.documents)}, nil
+}
+
+// AddJob adds the pages of a completed job to a tenant's corpus, creating it
+func (m *CorpusManager) AddJob(tenant *Tenant, name string, job Job, results map[string]*CrawledData) (CorpusChange, error) {
+	if err := validateCorpusName(name); err != nil {
+		return CorpusChange{}, err
+	}
+	crawledAt := job.CreatedAt
+	if job.FinishedAt != nil {
+		crawledAt = *job.FinishedAt
+	}
+	documents := make([]*CorpusDocument, 0, len(results))
+	for pageURL, data := range results {
+		documents = append(documents, newCorpusDocument(pageURL, data, job.ID, crawledAt))
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.upsert(tenant.ID, name, documents, []string{job.ID})
+}
+
+// List returns a tenant's corpora ordered by name
+func (m *CorpusManager) List(tenant *Tenant) []Corpus {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	corpora := []Corpus{}
+	for _, corpus := range m.corpora {
+		if corpus.TenantID == tenant.ID {
+			corpora = append(corpora, corpus.snapshot(false))
+		}
+	}
+	sort.Slice(corpora, func(i, j int) bool { return corpora[i].Name < corpora[j].Name })
+	return corpora
+}
+
+// Get returns a tenant's corpus with its documents per host
+func (m *CorpusManager) Get(tenant *Tenant, name string) (Corpus, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	corpus, err := m.lookup(tenant, name)
+	if err != nil {
+		return Corpus{}, err
+	}
+	return corpus.snapshot(true), nil
+}
+
+// Documents lists the documents of a tenant's corpus ordered by URL, those on domain
+// (or its subdomains) only unless it is "", and those tagged with topic only unless it
+// is ""
+func (m *CorpusManager) Documents(tenant *Tenant, name string, domain string, topic string) ([]CorpusDocument, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	corpus, err := m.lookup(tenant, name)
+	if err != nil {
+		return nil, err
+	}
+	documents := []CorpusDocument{}
+	now := time.Now()
+	for _, document := range corpus.documents {
+		if (domain == "" || onDomain(document.Host, domain)) && (topic == "" || containsString(document.Topics, topic)) {
+			listed := *document
+			listed.Stale = corpus.Policy.stale(document, now)
+			documents = append(documents, listed)
+		}
+	}
+	sort.Slice(documents, func(i, j int) bool { return documents[i].URL < documents[j].URL })
+	return documents, nil
+}
+
+// Document returns the page of a document of a tenant's corpus by ID, with the time
+// the document was crawled
+func (m *CorpusManager) Document(tenant *Tenant, name string, id string) (*CrawledData, time.Time, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	corpus, err := m.lookup(tenant, name)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	for _, document := range corpus.documents {
+		if document.ID == id {
+			return document.data, document.CrawledAt, nil
+		}
+	}
+	return nil, time.Time{}, errDocumentNotFound
+}
+
+// DeleteDocument removes a document from a tenant's corpus
+func (m *CorpusManager) DeleteDocument(tenant *Tenant, name string, id string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	corpus, err := m.lookup(tenant, name)
+	if err != nil {
+		return err
+	}
+	change, err := m.remove(corpus, func(document *CorpusDocument) bool { return document.ID == id })
+	if err != nil {
+		return err
+	}
+	if change.Removed == 0 {
+		return errDocumentNotFound
+	}
+	return nil
+}
+
+// Merge adds the documents of other corpora of the tenant to a corpus, creating it.
+// Of the versions of a URL, the last crawled is kept.
+func (m *CorpusManager) Merge(tenant *Tenant, name string, sources []string) (CorpusChange, error) {
+	if err := validateCorpusName(name); err != nil {
+		return CorpusChange{}, err
+	}
+	if len(sources) == 0 {
+		return CorpusChange{}, errors.New("sources must name the corpora to merge")
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var documents []*CorpusDocument
+	var jobs []string
+	for _, source := range sources {
+		if source == name {
+			return CorpusChange{}, errors.New("a corpus can't be merged into itself")
+		}
+		corpus, err := m.lookup(tenant, source)
+		if err != nil {
+			return CorpusChange{}, fmt.Errorf("%w: %s", err, source)
+		}
+		for _, document := range corpus.documents {
+			documents = append(documents, document)
+		}
+		jobs = append(jobs, corpus.Jobs...)
+	}
+	sort.SliceStable(documents, func(i, j int) bool { return documents[i].CrawledAt.Before(documents[j].CrawledAt) }) // Later versions replace earlier ones
+	return m.upsert(tenant.ID, name, documents, jobs)
+}
+
+// Prune removes the documents of a tenant's corpus crawled before a number of days
+// ago, or on some domains
+func (m *CorpusManager) Prune(tenant *Tenant, name string, prune CorpusPrune) (CorpusChange, error) {
+	if prune.OlderThanDays < 0 {
+		return CorpusChange{}, errors.New("older_than_days must not be negative")
+	}
+	if prune.OlderThanDays == 0 && len(prune.Domains) == 0 {
+		return CorpusChange{}, errors.New("older_than_days or domains is required")
+	}
+	cutoff := time.Now().AddDate(0, 0, -prune.OlderThanDays)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	corpus, err := m.lookup(tenant, name)
+	if err != nil {
+		return CorpusChange{}, err
+	}
+	return m.remove(corpus, func(document *CorpusDocument) bool {
+		if prune.OlderThanDays > 0 && !document.CrawledAt.Before(cutoff) {
+			return false
+		}
+		if len(prune.Domains) == 0 {
+			return true
+		}
+		for _, domain := range prune.Domains {
+			if onDomain(document.Host, domain) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// Delete removes a tenant's corpus with all its documents
+func (m *CorpusManager) Delete(tenant *Tenant, name string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, err := m.lookup(tenant, name); err != nil {
+		return err
+	}
+	if m.store != nil {
+		if err := m.store.delete(tenant.ID, name); err != nil {
+			return err
+		}
+	}
+	delete(m.corpora, corpusKey(tenant.ID, name))
+	return nil
+}
+
+// onDomain reports whether host is domain or one of its subdomains
+func onDomain(host string, domain string) bool {
+	domain = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(domain)), ".")
+	return domain != "" && (host == domain || strings.HasSuffix(host, "."+domain))
+}
+
+// containsString reports whether values holds value
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// addJobToCorpus adds the results of a job that completed to the corpus it names
+func (m *JobManager) addJobToCorpus(job *Job) {
+	if m.corpora == nil || job.Request.Corpus == "" {
+		return
+	}
+	m.mutex.Lock()
+	snapshot, results := *job, job.results
+	m.mutex.Unlock()
+	change, err := m.corpora.AddJob(job.tenant, job.Request.Corpus, snapshot, results)
+	if err != nil {
+		fiberlog.Errorf("Adding job %s to corpus %s failed: %v", job.ID, job.Request.Corpus, err)
+		return
+	}
+	fiberlog.Infof("Job %s added %d and updated %d documents of corpus %s", job.ID, change.Added, change.Updated, job.Request.Corpus)
+}
+
+// registerCorpusRoutes exposes the corpus endpoints
+func registerCorpusRoutes(app *fiber.App, corpora *CorpusManager, jobs *JobManager) {
+	// corpusStatus maps the errors of the corpus manager to response statuses
+	corpusStatus := func(err error) int {
+		switch {
+		case errors.Is(err, errCorpusNotFound), errors.Is(err, errDocumentNotFound), errors.Is(err, errJobNotFound):
+			return fiber.StatusNotFound
+		case errors.Is(err, errJobNotDone), errors.Is(err, errRecrawlInProgress):
+			return fiber.StatusConflict
+		}
+		return fiber.StatusBadRequest
+	}
+
+	app.Get("/corpora", func(c *fiber.Ctx) error {
+		return c.JSON(corpora.List(tenantFromCtx(c)))
+	})
+
+	app.Get("/corpora/:name", func(c *fiber.Ctx) error {
+		corpus, err := corpora.Get(tenantFromCtx(c), c.Params("name"))
+		if err != nil {
+			return c.Status(corpusStatus(err)).SendString(err.Error())
+		}
+		return c.JSON(corpus)
+	})
+
+	app.Delete("/corpora/:name", func(c *fiber.Ctx) error {
+		if err := corpora.Delete(tenantFromCtx(c), c.Params("name")); err != nil {
+			return c.Status(corpusStatus(err)).SendString(err.Error())
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	})
+
+	// domain=example.com lists the documents of a host and its subdomains only,
+	// topic=name those tagged with a topic only
+	app.Get("/corpora/:name/documents", func(c *fiber.Ctx) error {
+		documents, err := corpora.Documents(tenantFromCtx(c), c.Params("name"), c.Query("domain"), c.Query("topic"))
+		if err != nil {
+			return c.Status(corpusStatus(err)).SendString(err.Error())
+		}
+		return c.JSON(documents)
+	})
+
+	app.Get("/corpora/:name/documents/:doc", func(c *fiber.Ctx) error {
+		page, crawledAt, err := corpora.Document(tenantFromCtx(c), c.Params("name"), c.Params("doc"))
+		if err != nil {
+			return c.Status(corpusStatus(err)).SendString(err.Error())
+		}
+		format, err := negotiateFormat(c, formatJSON, formatMarkdown, formatText)
+		if err != nil {
+			return c.Status(negotiationStatus(err)).SendString(err.Error())
+		}
+		if notModified(c, pageETag(page, format, format == formatJSON), crawledAt) {
+			return c.SendStatus(fiber.StatusNotModified)
+		}
+		switch format {
+		case formatMarkdown:
+			return sendFormat(c, formatMarkdown, page.Markdown)
+		case formatText:
+			return sendFormat(c, formatText, renderMarkdownText(page.Markdown))
+		}
+		return sendJSON(c, page)
+	})
+
+	app.Delete("/corpora/:name/documents/:doc", func(c *fiber.Ctx) error {
+		if err := corpora.DeleteDocument(tenantFromCtx(c), c.Params("name"), c.Params("doc")); err != nil {
+			return c.Status(corpusStatus(err)).SendString(err.Error())
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	})
+
+	// Adds the pages of a completed job, creating the corpus
+	app.Post("/corpora/:name/jobs/:id", func(c *fiber.Ctx) error {
+		tenant := tenantFromCtx(c)
+		job, results, err := jobs.Results(tenant, c.Params("id"))
+		if err != nil {
+			return c.Status(corpusStatus(err)).SendString(err.Error())
+		}
+		change, err := corpora.AddJob(tenant, c.Params("name"), job, results)
+		if err != nil {
+			return c.Status(corpusStatus(err)).SendString(err.Error())
+		}
+		return c.JSON(change)
+	})
+
+	// Replaces the freshness policy: {"expire_after_days": 90, "recrawl_after_days": 30}
+	app.Put("/corpora/:name/policy", func(c *fiber.Ctx) error {
+		var policy CorpusPolicy
+		if err := c.BodyParser(&policy); err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString("Invalid request body")
+		}
+		corpus, err := corpora.SetPolicy(tenantFromCtx(c), c.Params("name"), policy)
+		if err != nil {
+			return c.Status(corpusStatus(err)).SendString(err.Error())
+		}
+		return c.JSON(corpus)
+	})
+
+	// Recrawls the stale documents now rather than at the next sweep
+	app.Post("/corpora/:name/recrawl", func(c *fiber.Ctx) error {
+		job, err := corpora.Recrawl(tenantFromCtx(c), c.Params("name"), jobs)
+		if errors.Is(err, errNoStaleDocuments) {
+			return c.SendStatus(fiber.StatusNoContent)
+		}
+		if err != nil {
+			return c.Status(corpusStatus(err)).SendString(err.Error())
+		}
+		return c.Status(fiber.StatusAccepted).JSON(job)
+	})
+
+	// Scores retrieval over the corpus for queries with expected pages, see EvalRequest
+	app.Post("/corpora/:name/eval", func(c *fiber.Ctx) error {
+		var request EvalRequest
+		if err := c.BodyParser(&request); err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString("Invalid request body")
+		}
+		if err := request.validate(); err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+		}
+		documents, err := corpora.Documents(tenantFromCtx(c), c.Params("name"), "", "")
+		if err != nil {
+			return c.Status(corpusStatus(err)).SendString(err.Error())
+		}
+		report, err := evaluateRetrieval(c.Params("name"), documents, request)
+		if err != nil {
+			return c.Status(fiber.StatusBadGateway).SendString(err.Error())
+		}
+		return c.JSON(report)
+	})
+
+	// Merges other corpora into this one, creating it: {"sources": ["a", "b"]}
+	app.Post("/corpora/:name/merge", func(c *fiber.Ctx) error {
+		var request struct {
+			Sources []string `json:"sources"`
+		}
+		if err := c.BodyParser(&request); err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString("Invalid request body")
+		}
+		change, err := corpora.Merge(tenantFromCtx(c), c.Params("name"), request.Sources)
+		if err != nil {
+			return c.Status(corpusStatus(err)).SendString(err.Error())
+		}
+		return c.JSON(change)
+	})
+
+	// Removes documents by age and domain: {"older_than_days": 30, "domains": ["example.com"]}
+	app.Post("/corpora/:name/prune", func(c *fiber.Ctx) error {
+		var prune CorpusPrune
+		if err := c.BodyParser(&prune); err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString("Invalid request body")
+		}
+		change, err := corpora.Prune(tenantFromCtx(c), c.Params("name"), prune)
+		if err != nil {
+			return c.Status(corpusStatus(err)).SendString(err.Error())
+		}
+		return c.JSON(change)
+	})
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	fiberlog "github.com/gofiber/fiber/v2/log"
+)
+
+// corpusSweepInterval is how often corpora are checked against their freshness policies
+const corpusSweepInterval = time.Hour
+
+var (
+	errNoStaleDocuments  = errors.New("no documents of the corpus are stale")
+	errRecrawlInProgress = errors.New("a recrawl of the corpus is in progress")
+)
+
+// CorpusPolicy keeps a corpus fresh: documents are recrawled once they are older than
+// RecrawlAfterDays, and removed once they are older than ExpireAfterDays, e.g. when
+// their pages were taken down. Zero disables either.
+type CorpusPolicy struct {
+	ExpireAfterDays  int           `json:"expire_after_days"`
+	RecrawlAfterDays int           `json:"recrawl_after_days"`
+	Recrawl          *CrawlRequest `json:"recrawl,omitempty"` // Settings of recrawl jobs, e.g. {"js": true}; their URLs, depth and corpus are set by the recrawl
+}
+
+// validate checks a policy of the named corpus
+func (p CorpusPolicy) validate(name string) error {
+	if p.ExpireAfterDays < 0 || p.RecrawlAfterDays < 0 {
+		return errors.New("expire_after_days and recrawl_after_days must not be negative")
+	}
+	if p.ExpireAfterDays > 0 && p.RecrawlAfterDays >= p.ExpireAfterDays {
+		return errors.New("recrawl_after_days must be less than expire_after_days, or documents expire before they are recrawled")
+	}
+	_, err := p.recrawlRequest(name, []string{"https://example.com/"}).toConfig()
+	return err
+}
+
+// expired reports whether a document is past the policy's expiry at now
+func (p CorpusPolicy) expired(document *CorpusDocument, now time.Time) bool {
+	return p.ExpireAfterDays > 0 && document.CrawledAt.Before(now.AddDate(0, 0, -p.ExpireAfterDays))
+}
+
+// stale reports whether a document is due for a recrawl at now
+func (p CorpusPolicy) stale(document *CorpusDocument, now time.Time) bool {
+	return p.RecrawlAfterDays > 0 && document.CrawledAt.Before(now.AddDate(0, 0, -p.RecrawlAfterDays))
+}
+
+// recrawlRequest returns the job recrawling urls into the named corpus: the stale
+// pages themselves, without following their links
+func (p CorpusPolicy) recrawlRequest(name string, urls []string) CrawlRequest {
+	var request CrawlRequest
+	if p.Recrawl != nil {
+		request = *p.Recrawl
+	}
+	depth := 1
+	request.URL, request.Archive, request.AllowedDomains = "", "", nil
+	request.MaxDepth, request.MaxPages, request.Corpus = &depth, len(urls), name
+	request.Seeds = make([]Seed, len(urls))
+	for i, pageURL := range urls {
+		request.Seeds[i] = Seed{URL: pageURL}
+	}
+	return request
+}
+
+// staleURLs returns the URLs of the documents of a corpus due for a recrawl at now,
+// sorted; the manager's mutex must be held
+func (c *Corpus) staleURLs(now time.Time) []string {
+	var urls []string
+	for documentURL, document := range c.documents {
+		if c.Policy.stale(document, now) {
+			urls = append(urls, documentURL)
+		}
+	}
+	sort.Strings(urls)
+	return urls
+}
+
+// SetPolicy replaces the freshness policy of a tenant's corpus. It applies from the
+// next sweep, or a recrawl requested before.
+func (m *CorpusManager) SetPolicy(tenant *Tenant, name string, policy CorpusPolicy) (Corpus, error) {
+	if err := policy.validate(name); err != nil {
+		return Corpus{}, err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	corpus, err := m.lookup(tenant, name)
+	if err != nil {
+		return Corpus{}, err
+	}
+	updated := *corpus
+	updated.Policy = policy
+	if m.store != nil {
+		if err := m.store.save(&updated, nil, nil); err != nil {
+			return Corpus{}, err
+		}
+	}
+	corpus.Policy = policy
+	return corpus.snapshot(false), nil
+}
+
+// Recrawl submits a job recrawling the stale documents of a tenant's corpus, which
+// replace them when it completes. Only one recrawl of a corpus runs at a time.
+func (m *CorpusManager) Recrawl(tenant *Tenant, name string, jobs *JobManager) (Job, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	corpus, err := m.lookup(tenant, name)
+	if err != nil {
+		return Job{}, err
+	}
+	return m.recrawl(tenant, corpus, jobs)
+}
+
+// recrawl submits the recrawl job of a corpus; the manager's mutex must be held
+func (m *CorpusManager) recrawl(tenant *Tenant, corpus *Corpus, jobs *JobManager) (Job, error) {
+	if corpus.RecrawlJob != "" && !containsString(corpus.Jobs, corpus.RecrawlJob) { // Not added yet
+		if job, err := jobs.Get(tenant, corpus.RecrawlJob); err == nil && job.Status != JobFailed {
+			return Job{}, errRecrawlInProgress
+		}
+	}
+	urls := corpus.staleURLs(time.Now())
+	if len(urls) == 0 {
+		return Job{}, errNoStaleDocuments
+	}
+	job, err := jobs.Submit(tenant, corpus.Policy.recrawlRequest(corpus.Name, urls))
+	if err != nil {
+		return Job{}, err
+	}
+	updated := *corpus
+	updated.RecrawlJob = job.ID
+	if m.store != nil {
+		if err := m.store.save(&updated, nil, nil); err != nil {
+			fiberlog.Errorf("Saving the recrawl job %s of corpus %s failed: %v", job.ID, corpus.Name, err)
+		}
+	}
+	corpus.RecrawlJob = job.ID
+	return job, nil
+}
+
+// sweep applies the freshness policies of all corpora: expired documents are removed
+// and stale ones recrawled
+func (m *CorpusManager) sweep(jobs *JobManager, tenants *TenantRegistry) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	now := time.Now()
+	for _, corpus := range m.corpora {
+		if corpus.Policy.ExpireAfterDays == 0 && corpus.Policy.RecrawlAfterDays == 0 {
+			continue
+		}
+		change, err := m.remove(corpus, func(document *CorpusDocument) bool { return corpus.Policy.expired(document, now) })
+		if err != nil {
+			fiberlog.Errorf("Expiring documents of corpus %s failed: %v", corpus.Name, err)
+		} else if change.Removed > 0 {
+			fiberlog.Infof("Expired %d documents of corpus %s", change.Removed, corpus.Name)
+		}
+
+		tenant, ok := tenants.tenants[corpus.TenantID]
+		if !ok || corpus.Policy.RecrawlAfterDays == 0 {
+			continue
+		}
+		job, err := m.recrawl(tenant, corpus, jobs)
+		switch {
+		case err == nil:
+			fiberlog.Infof("Job %s recrawls stale documents of corpus %s", job.ID, corpus.Name)
+		case !errors.Is(err, errNoStaleDocuments) && !errors.Is(err, errRecrawlInProgress):
+			fiberlog.Errorf("Recrawling corpus %s failed: %v", corpus.Name, err)
+		}
+	}
+}
+
+// StartSweeps applies the freshness policies of corpora now and every
+// corpusSweepInterval, in the background
+func (m *CorpusManager) StartSweeps(jobs *JobManager, tenants *TenantRegistry) {
+	go func() {
+		for {
+			m.sweep(jobs, tenants)
+			time.Sleep(corpusSweepInterval)
+		}
+	}()
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Keys of the bucket of a corpus, named by its corpusKey
+var (
+	corpusMetaKey         = []byte("corpus")    // The corpus, as JSON
+	corpusDocumentsBucket = []byte("documents") // Documents by URL
+)
+
+// corpusStorePathFromEnv returns the file corpora are kept in, so they survive
+// restarts: "corpora.db" in LEXICRAWLER_DATA_DIR, or "" to keep them in memory
+func corpusStorePathFromEnv() string {
+	dataDir := os.Getenv("LEXICRAWLER_DATA_DIR")
+	if dataDir == "" {
+		return ""
+	}
+	return filepath.Join(dataDir, "corpora.db")
+}
+
+// corpusStore keeps corpora in a bolt file, a bucket per corpus holding the corpus
+// and its documents. Every change of a corpus is one transaction.
+type corpusStore struct {
+	db *bolt.DB
+}
+
+// storedCorpusDocument is the encoding of a document in the documents bucket
+type storedCorpusDocument struct {
+	Document CorpusDocument
+	Page     []byte // encodeStoredPage
+}
+
+// openCorpusStore opens the corpus file at path, creating it, and returns the corpora
+// kept in it
+func openCorpusStore(path string) (*corpusStore, []*Corpus, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, nil, err
+	}
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second}) // Instead of waiting on another server's lock
+	if err != nil {
+		return nil, nil, err
+	}
+	var corpora []*Corpus
+	err = db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, bucket *bolt.Bucket) error {
+			corpus := &Corpus{documents: make(map[string]*CorpusDocument)}
+			if err := json.Unmarshal(bucket.Get(corpusMetaKey), corpus); err != nil {
+				return fmt.Errorf("reading corpus %s: %w", name, err)
+			}
+			documents := bucket.Bucket(corpusDocumentsBucket)
+			if documents == nil {
+				return fmt.Errorf("corpus %s has no documents bucket", name)
+			}
+			err := documents.ForEach(func(key, value []byte) error {
+				var stored storedCorpusDocument
+				if err := gob.NewDecoder(bytes.NewReader(value)).Decode(&stored); err != nil {
+					return fmt.Errorf("decoding %s: %w", key, err)
+				}
+				var page storedPage
+				if err := gob.NewDecoder(bytes.NewReader(stored.Page)).Decode(&page); err != nil {
+					return fmt.Errorf("decoding the page of %s: %w", key, err)
+				}
+				page.Data.rawHTMLZstd = page.RawHTMLZstd
+				document := stored.Document
+				document.data = page.Data
+				corpus.documents[string(key)] = &document
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			corpora = append(corpora, corpus)
+			return nil
+		})
+	})
+	if err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+	return &corpusStore{db: db}, corpora, nil
+}
+
+// save stores a corpus with the documents added or replaced, and deletes the
+// documents removed by URL
+func (s *corpusStore) save(corpus *Corpus, documents []*CorpusDocument, removed []string) error {
+	meta, err := json.Marshal(corpus)
+	if err != nil {
+		return err
+	}
+	encoded := make([][]byte, len(documents))
+	for i, document := range documents {
+		page, err := encodeStoredPage(document.data)
+		if err != nil {
+			return fmt.Errorf("encoding %s: %w", document.URL, err)
+		}
+		var buffer bytes.Buffer
+		if err := gob.NewEncoder(&buffer).Encode(storedCorpusDocument{Document: *document, Page: page}); err != nil {
+			return fmt.Errorf("encoding %s: %w", document.URL, err)
+		}
+		encoded[i] = buffer.Bytes()
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(corpusKey(corpus.TenantID, corpus.Name)))
+		if err != nil {
+			return err
+		}
+		if err := bucket.Put(corpusMetaKey, meta); err != nil {
+			return err
+		}
+		bucketDocuments, err := bucket.CreateBucketIfNotExists(corpusDocumentsBucket)
+		if err != nil {
+			return err
+		}
+		for i, document := range documents {
+			if err := bucketDocuments.Put([]byte(document.URL), encoded[i]); err != nil {
+				return err
+			}
+		}
+		for _, documentURL := range removed {
+			if err := bucketDocuments.Delete([]byte(documentURL)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// delete removes a corpus with its documents
+func (s *corpusStore) delete(tenantID string, name string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		err := tx.DeleteBucket([]byte(corpusKey(tenantID, name)))
+		if err == bolt.ErrBucketNotFound {
+			return nil
+		}
+		return err
+	})
+}
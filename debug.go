@@ -0,0 +1,115 @@
+package main
+
+import (
+	"runtime/pprof"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// trackedCrawl is a running crawl and the pipeline its pages go through
+type trackedCrawl struct {
+	crawler  *Crawler
+	pipeline *crawlPipeline
+	frontier *frontier
+	started  time.Time
+}
+
+// activeCrawls are the crawls running in the process, for /debug/crawls
+var activeCrawls = struct {
+	sync.Mutex
+	crawls map[*Crawler]*trackedCrawl
+}{crawls: make(map[*Crawler]*trackedCrawl)}
+
+// trackCrawl lists a crawl as active until the returned function is called
+func trackCrawl(c *Crawler, pipeline *crawlPipeline, frontier *frontier) func() {
+	activeCrawls.Lock()
+	activeCrawls.crawls[c] = &trackedCrawl{crawler: c, pipeline: pipeline, frontier: frontier, started: time.Now()}
+	activeCrawls.Unlock()
+	return func() {
+		activeCrawls.Lock()
+		delete(activeCrawls.crawls, c)
+		activeCrawls.Unlock()
+	}
+}
+
+// CrawlDebugInfo describes a running crawl to operators looking for stuck jobs
+type CrawlDebugInfo struct {
+	JobID          string           `json:"job_id,omitempty"` // Empty for /crawl requests
+	StartURL       string           `json:"start_url"`
+	StartedAt      time.Time        `json:"started_at"`
+	RunningSeconds float64          `json:"running_seconds"`
+	Stopping       bool             `json:"stopping"` // Cancelled or paused, waiting for pages in flight
+	Visited        int              `json:"visited"`
+	Frontier       int              `json:"frontier"` // Pages queued and waiting to be fetched
+	Stages         []StageDebugInfo `json:"stages"`
+}
+
+// StageDebugInfo is the state of one pipeline stage of a crawl
+type StageDebugInfo struct {
+	Stage      string  `json:"stage"`
+	Queued     int64   `json:"queued"`
+	Busy       int64   `json:"busy"`
+	Processed  int     `json:"processed"`
+	AvgSeconds float64 `json:"avg_seconds"`
+	MaxSeconds float64 `json:"max_seconds"`
+}
+
+// debugInfo snapshots a running crawl
+func (t *trackedCrawl) debugInfo() CrawlDebugInfo {
+	c := t.crawler
+	c.VisitedMutex.Lock()
+	visited := c.pagesVisited
+	c.VisitedMutex.Unlock()
+	info := CrawlDebugInfo{
+		JobID:          c.jobID,
+		StartURL:       c.Config.StartURL,
+		StartedAt:      t.started,
+		RunningSeconds: time.Since(t.started).Seconds(),
+		Stopping:       c.stopped.Load(),
+		Visited:        visited,
+		Frontier:       t.frontier.store.queued() + int(t.pipeline.gauges[stageFetch].queued.Load()), // Also the pages leased and waiting for the fetch stage
+	}
+	for _, stage := range pipelineStages {
+		latency := t.pipeline.latency[stage]
+		latency.Lock()
+		stageInfo := StageDebugInfo{
+			Stage:      stage,
+			Queued:     t.pipeline.gauges[stage].queued.Load(),
+			Busy:       t.pipeline.gauges[stage].busy.Load(),
+			Processed:  latency.processed,
+			MaxSeconds: latency.max.Seconds(),
+		}
+		if latency.processed > 0 {
+			stageInfo.AvgSeconds = latency.total.Seconds() / float64(latency.processed)
+		}
+		latency.Unlock()
+		info.Stages = append(info.Stages, stageInfo)
+	}
+	return info
+}
+
+// registerDebugRoutes serves the running crawls of the caller's tenant at
+// /debug/crawls and the stacks of all goroutines at /debug/goroutines
+func registerDebugRoutes(app *fiber.App) {
+	app.Get("/debug/crawls", func(c *fiber.Ctx) error {
+		tenant := tenantFromCtx(c)
+		activeCrawls.Lock()
+		crawls := []CrawlDebugInfo{}
+		for crawler, tracked := range activeCrawls.crawls {
+			if crawler.tenantID == tenant.ID {
+				crawls = append(crawls, tracked.debugInfo())
+			}
+		}
+		activeCrawls.Unlock()
+		sort.Slice(crawls, func(i, j int) bool { return crawls[i].StartedAt.Before(crawls[j].StartedAt) })
+		return c.JSON(fiber.Map{"crawls": crawls})
+	})
+
+	app.Get("/debug/goroutines", func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderContentType, fiber.MIMETextPlainCharsetUTF8)
+		return pprof.Lookup("goroutine").WriteTo(c.Response().BodyWriter(), 2) // Full stacks, as in a crash dump
+	})
+}
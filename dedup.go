@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/bits-and-blooms/bloom/v3"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Dedup modes of CrawlerConfig.Dedup
+const (
+	dedupExact = "exact" // Every request in memory, the default
+	dedupBloom = "bloom" // A bloom filter in memory, confirmed by an exact store on disk
+)
+
+// defaultDedupExpectedURLs sizes the bloom filter when CrawlerConfig.DedupExpectedURLs
+// is 0, taking about 18MB
+const defaultDedupExpectedURLs = 10_000_000
+
+// dedupFalsePositiveRate is the share of new URLs the bloom filter mistakes for seen
+// ones. These are looked up on disk, so it costs time, not accuracy.
+const dedupFalsePositiveRate = 0.001
+
+// dedupRoot holds the exact stores of bloom-filtered crawls
+var dedupRoot = filepath.Join(os.TempDir(), "lexicrawler-dedup")
+
+// seenBucket is the bolt bucket of the requests a crawl has seen, each stored with
+// seenMarker: bolt's Get can't tell a key with a nil value from a missing one
+var (
+	seenBucket = []byte("seen")
+	seenMarker = []byte{1}
+)
+
+// urlSet remembers the requests an in-memory frontier has queued, by requestHash, so
+// every URL is crawled once
+type urlSet interface {
+	add(hash uint64) error
+	contains(hash uint64) (bool, error)
+	close() error // Releases what the set holds on disk
+}
+
+// newURLSet creates the set of the configured dedup mode
+func (config CrawlerConfig) newURLSet() (urlSet, error) {
+	if config.Dedup != dedupBloom {
+		return &exactURLSet{hashes: make(map[uint64]struct{})}, nil
+	}
+	expected := config.DedupExpectedURLs
+	if expected == 0 {
+		expected = defaultDedupExpectedURLs
+	}
+	return newBloomURLSet(uint(expected))
+}
+
+// exactURLSet keeps every hash in memory, about 40 bytes per URL
+type exactURLSet struct {
+	mutex  sync.Mutex
+	hashes map[uint64]struct{}
+}
+
+func (s *exactURLSet) add(hash uint64) error {
+	s.mutex.Lock()
+	s.hashes[hash] = struct{}{}
+	s.mutex.Unlock()
+	return nil
+}
+
+func (s *exactURLSet) contains(hash uint64) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, ok := s.hashes[hash]
+	return ok, nil
+}
+
+func (s *exactURLSet) close() error {
+	return nil
+}
+
+// bloomURLSet keeps a bloom filter of the hashes in memory, under 2 bytes per URL,
+// and every hash in a bolt file. Only hashes the filter reports as possibly seen are
+// looked up on disk, so new URLs, the bulk of the lookups, rarely touch it.
+type bloomURLSet struct {
+	mutex  sync.Mutex
+	filter *bloom.BloomFilter
+	db     *bolt.DB
+}
+
+// newBloomURLSet creates a set sized for the expected number of URLs; more still
+// work, with more lookups on disk
+func newBloomURLSet(expected uint) (*bloomURLSet, error) {
+	if err := os.MkdirAll(dedupRoot, 0o755); err != nil {
+		return nil, err
+	}
+	file, err := os.CreateTemp(dedupRoot, "crawl-*.db")
+	if err != nil {
+		return nil, err
+	}
+	file.Close()
+	db, err := bolt.Open(file.Name(), 0o600, nil)
+	if err != nil {
+		os.Remove(file.Name())
+		return nil, err
+	}
+	db.NoSync = true // Only needed while the crawl runs
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(seenBucket)
+		return err
+	}); err != nil {
+		db.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	return &bloomURLSet{filter: bloom.NewWithEstimates(expected, dedupFalsePositiveRate), db: db}, nil
+}
+
+func (s *bloomURLSet) add(hash uint64) error {
+	key := binary.BigEndian.AppendUint64(nil, hash)
+	s.mutex.Lock()
+	s.filter.Add(key)
+	s.mutex.Unlock()
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(seenBucket).Put(key, seenMarker)
+	})
+}
+
+func (s *bloomURLSet) contains(hash uint64) (bool, error) {
+	key := binary.BigEndian.AppendUint64(nil, hash)
+	s.mutex.Lock()
+	maybe := s.filter.Test(key)
+	s.mutex.Unlock()
+	if !maybe {
+		return false, nil
+	}
+	var found bool
+	err := s.db.View(func(tx *bolt.Tx) error {
+		found = tx.Bucket(seenBucket).Get(key) != nil
+		return nil
+	})
+	return found, err
+}
+
+func (s *bloomURLSet) close() error {
+	path := s.db.Path()
+	if err := s.db.Close(); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
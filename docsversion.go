@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Docs version modes of CrawlerConfig.DocsVersion; any other value is the version
+// the crawl is restricted to, e.g. "v2", "2.3" or "stable"
+const (
+	docsVersionTag  = "tag"  // Tag pages with their version, crawling every version
+	docsVersionSeed = "seed" // Restrict the crawl to the version of the start URL
+)
+
+// maxDocsVersionSegment is how deep in a URL path versions are looked for; docs put
+// them near the root, as in /v1/, /en/2.3/ or /docs/latest/
+const maxDocsVersionSegment = 4
+
+var (
+	// docsVersionSegment matches path segments naming a version: "v1", "2.3", "1.x"
+	docsVersionSegment = regexp.MustCompile(`(?i)^(v\d+(\.\d+)*(\.x)?|\d+\.\d+(\.\d+)*(\.x)?|\d+\.x)$`)
+	// docsVersionChannels are path segments naming a moving version of the docs
+	docsVersionChannels = wordSet("latest stable dev devel development master main next nightly current")
+)
+
+// docsVersion returns the version of the docs a URL is of, from its version query
+// parameter or a path segment such as /v1/ or /2.3/; "" for unversioned URLs
+func docsVersion(pageURL string) string {
+	parsedURL, err := url.Parse(pageURL)
+	if err != nil {
+		return ""
+	}
+	query := parsedURL.Query()
+	for _, parameter := range []string{"version", "ver"} {
+		if version := strings.TrimSpace(query.Get(parameter)); version != "" {
+			return strings.ToLower(version)
+		}
+	}
+	segments := strings.Split(strings.Trim(parsedURL.Path, "/"), "/")
+	for i, segment := range segments {
+		if i == maxDocsVersionSegment {
+			break
+		}
+		segment = strings.ToLower(segment)
+		if docsVersionSegment.MatchString(segment) {
+			return segment
+		}
+		// Channels are directories of docs, a page named "latest" isn't one
+		if docsVersionChannels[segment] && (i < len(segments)-1 || strings.HasSuffix(parsedURL.Path, "/")) {
+			return segment
+		}
+	}
+	return ""
+}
+
+// pageDocsVersion returns the version of the docs a page is of: its URL's, else the
+// one DocSearch or Read the Docs put in its meta tags
+func pageDocsVersion(pageURL string, metadata map[string]string) string {
+	return firstNonEmpty(docsVersion(pageURL), strings.ToLower(strings.TrimSpace(metadata["docsearch:version"])),
+		strings.ToLower(strings.TrimSpace(metadata["readthedocs-version-slug"])))
+}
+
+// sameDocsVersion compares versions ignoring a "v" prefix, so "v2.3" is "2.3"
+func sameDocsVersion(a string, b string) bool {
+	return strings.TrimPrefix(strings.ToLower(a), "v") == strings.TrimPrefix(strings.ToLower(b), "v")
+}
+
+// crawledDocsVersion returns the version the crawl is restricted to, "" for none
+func (config CrawlerConfig) crawledDocsVersion() string {
+	switch config.DocsVersion {
+	case "", docsVersionTag:
+		return ""
+	case docsVersionSeed:
+		return docsVersion(config.StartURL)
+	}
+	return config.DocsVersion
+}
+
+// isCrawledDocsVersion reports whether a URL is of the version the crawl is restricted
+// to. Unversioned URLs, such as a blog next to the docs, are always crawled.
+func (config CrawlerConfig) isCrawledDocsVersion(pageURL string) bool {
+	restricted := config.crawledDocsVersion()
+	if restricted == "" {
+		return true
+	}
+	version := docsVersion(pageURL)
+	return version == "" || sameDocsVersion(version, restricted)
+}
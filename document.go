@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// isJSONContentType reports whether a Content-Type holds JSON (application/json,
+// text/json or a +json type such as application/ld+json)
+func isJSONContentType(contentType string) bool {
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	return mediaType == "application/json" || mediaType == "text/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// isDocumentContentType reports whether a non-HTML response can still be processed
+func isDocumentContentType(contentType string) bool {
+	return isJSONContentType(contentType) || isXMLContentType(contentType)
+}
+
+// newDocumentPage wraps a non-HTML response; it has no DOM
+func newDocumentPage(pageURL, contentType string, body string) *fetchedPage {
+	return &fetchedPage{URL: pageURL, ContentType: contentType, Body: body}
+}
+
+// extractDocument runs a non-HTML response (JSON or XML) through its own
+// extraction: the markdown shows the document and the structured data holds it parsed
+func (c *Crawler) extractDocument(pageURL, contentType, body string) (*CrawledData, error) {
+	data := &CrawledData{
+		URL:            pageURL,
+		StructuredData: make(map[string]interface{}),
+		Metadata:       map[string]string{"title": pageURL, "content_type": contentType},
+		RawHTML:        body,
+	}
+	var err error
+	if isXMLContentType(contentType) {
+		err = extractXML(data, body)
+	} else {
+		err = c.extractJSON(data, body)
+	}
+	if err != nil {
+		return nil, err
+	}
+	data.StructuredData["outline"] = buildOutline(data.Markdown)
+	return data, nil
+}
+
+// extractJSON pretty-prints a JSON document as a fenced code block and stores it,
+// decoded, as StructuredData["json"], along with the values of the configured
+// JSONPath projections (StructuredData["projections"])
+func (c *Crawler) extractJSON(data *CrawledData, body string) error {
+	var document interface{}
+	decoder := json.NewDecoder(strings.NewReader(body))
+	decoder.UseNumber() // Keep large IDs exact
+	if err := decoder.Decode(&document); err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+	var pretty bytes.Buffer
+	if err := json.Indent(&pretty, []byte(strings.TrimSpace(body)), "", "  "); err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+	code := pretty.String()
+	fence := strings.Repeat("`", max(3, longestRun(code, '`')+1))
+	data.Markdown = "# " + escapeMarkdown(data.URL) + "\n\n---\n\n" + fence + "json\n" + code + "\n" + fence + "\n"
+	data.StructuredData["json"] = document
+
+	if len(c.Config.JSONProjections) > 0 {
+		projections := make(map[string][]interface{})
+		for name, path := range c.Config.JSONProjections {
+			steps, err := compileJSONPath(path)
+			if err != nil {
+				return err // Checked by validateJSONProjections for jobs
+			}
+			projections[name] = evalJSONPath(document, steps)
+		}
+		data.StructuredData["projections"] = projections
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+// cloneTree deep-copies a node and its descendants, recording the copy of every node
+// in clones
+func cloneTree(node *html.Node, clones map[*html.Node]*html.Node) *html.Node {
+	clone := &html.Node{Type: node.Type, DataAtom: node.DataAtom, Data: node.Data, Namespace: node.Namespace}
+	clone.Attr = append([]html.Attribute(nil), node.Attr...)
+	clones[node] = clone
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		clone.AppendChild(cloneTree(child, clones))
+	}
+	return clone
+}
+
+// detachedCopy copies the page a selection belongs to, so the selection can be
+// stripped without changing the page, and returns the copy and the selection in it
+func detachedCopy(doc *goquery.Document, selection *goquery.Selection) (*goquery.Selection, *goquery.Selection) {
+	clones := make(map[*html.Node]*html.Node)
+	root := goquery.NewDocumentFromNode(cloneTree(doc.Nodes[0], clones))
+	nodes := make([]*html.Node, 0, len(selection.Nodes))
+	for _, node := range selection.Nodes {
+		nodes = append(nodes, clones[node])
+	}
+	if len(nodes) == 1 && nodes[0] == root.Nodes[0] {
+		return root.Selection, root.Selection
+	}
+	return root.Selection, root.FindNodes(nodes...)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/url"
+	"sort"
+)
+
+// DiscoveredURL is a URL a crawl would visit, with the depth it was first found at
+type DiscoveredURL struct {
+	URL   string `json:"url"`
+	Depth int    `json:"depth"`
+}
+
+// discoverLinks follows the links of a page in dry-run mode. Links that land on the
+// last allowed depth are recorded without being downloaded, since their own links
+// would never be followed.
+func (c *Crawler) discoverLinks(page *fetchedPage, pageDepth int) {
+	depth := pageDepth + 1
+	if c.Config.MaxDepth > 0 && depth > c.Config.MaxDepth {
+		return
+	}
+	for _, link := range extractLinks(page.DOM, page.URL) {
+		if !c.isAllowedURL(link) {
+			continue
+		}
+		if c.Config.MaxDepth > 0 && depth == c.Config.MaxDepth {
+			c.recordDiscovered(link, depth)
+			continue
+		}
+		page.Visit(link)
+	}
+}
+
+// recordDiscovered stores a URL found during a dry run, keeping the shallowest depth
+func (c *Crawler) recordDiscovered(urlStr string, depth int) {
+	c.DiscoveredMutex.Lock()
+	defer c.DiscoveredMutex.Unlock()
+	if known, ok := c.Discovered[urlStr]; !ok || depth < known {
+		c.Discovered[urlStr] = depth
+	}
+}
+
+// DiscoveredURLs returns the URLs found during a dry run ordered by depth, then URL
+func (c *Crawler) DiscoveredURLs() []DiscoveredURL {
+	c.DiscoveredMutex.Lock()
+	defer c.DiscoveredMutex.Unlock()
+	discovered := make([]DiscoveredURL, 0, len(c.Discovered))
+	for urlStr, depth := range c.Discovered {
+		discovered = append(discovered, DiscoveredURL{URL: urlStr, Depth: depth})
+	}
+	sort.Slice(discovered, func(i, j int) bool {
+		if discovered[i].Depth != discovered[j].Depth {
+			return discovered[i].Depth < discovered[j].Depth
+		}
+		return discovered[i].URL < discovered[j].URL
+	})
+	return discovered
+}
+
+// isAllowedURL reports whether a URL is on one of the crawl's AllowedDomains, if any,
+// and of the docs version the crawl is restricted to, if any
+func (c *Crawler) isAllowedURL(urlStr string) bool {
+	if !c.Config.isCrawledDocsVersion(urlStr) {
+		return false
+	}
+	if len(c.Config.AllowedDomains) == 0 {
+		return true
+	}
+	parsedURL, err := url.Parse(urlStr)
+	if err != nil {
+		return false
+	}
+	for _, domain := range c.Config.AllowedDomains {
+		if parsedURL.Hostname() == domain {
+			return true
+		}
+	}
+	return false
+}
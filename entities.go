@@ -0,0 +1,436 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Entity recognizers of CrawlerConfig.EntityRecognizer
+const (
+	recognizeLocal = "local" // Heuristics over capitalized names and the words around them
+	recognizeLLM   = "llm"   // An OpenAI-compatible chat completions API
+)
+
+// Entity types
+const (
+	entityPerson       = "person"
+	entityOrganization = "organization"
+	entityProduct      = "product"
+	entityLocation     = "location"
+)
+
+// Entities and relations kept per page, the most frequent first
+const (
+	maxPageEntities  = 200
+	maxPageRelations = 500
+	maxEntityLLMText = 24000 // Characters of a page sent to the API
+)
+
+// PageEntities are the named entities of a page with their spans, and the pairs of
+// entities mentioned in the same paragraph, for knowledge-graph builders
+type PageEntities struct {
+	Recognizer string           `json:"recognizer"`
+	Entities   []Entity         `json:"entities"`
+	Relations  []EntityRelation `json:"relations"`
+}
+
+// Entity is a person, organization, product or location named on a page
+type Entity struct {
+	Text  string   `json:"text"`
+	Type  string   `json:"type"`
+	Spans [][2]int `json:"spans"` // Character offsets of its mentions in the page markdown
+}
+
+// EntityRelation is the co-occurrence of two entities in paragraphs of a page
+type EntityRelation struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Type   string `json:"type"`  // "co_occurrence"
+	Count  int    `json:"count"` // Paragraphs mentioning both
+}
+
+// entityRecognizer finds the entities of a page's markdown, by text
+type entityRecognizer interface {
+	name() string
+	recognize(markdown string) (map[string]string, error) // Entity type by text
+}
+
+// newEntityRecognizer returns the recognizer of a CrawlerConfig.EntityRecognizer
+func newEntityRecognizer(kind string) (entityRecognizer, error) {
+	switch kind {
+	case recognizeLocal:
+		return localRecognizer{}, nil
+	case recognizeLLM:
+		apiURL := strings.TrimRight(os.Getenv("LEXICRAWLER_NER_URL"), "/")
+		if apiURL == "" {
+			return nil, errors.New("the llm entity recognizer requires LEXICRAWLER_NER_URL")
+		}
+		model := os.Getenv("LEXICRAWLER_NER_MODEL")
+		if model == "" {
+			model = "gpt-4o-mini"
+		}
+		return &llmRecognizer{apiURL: apiURL, apiKey: os.Getenv("LEXICRAWLER_NER_API_KEY"), model: model}, nil
+	}
+	return nil, fmt.Errorf("unknown entity recognizer %q (want local or llm)", kind)
+}
+
+// extractEntities stores the entities of a page in its structured data ("entities")
+func (c *Crawler) extractEntities(data *CrawledData) {
+	recognizer, err := newEntityRecognizer(c.Config.EntityRecognizer)
+	if err != nil || data.Markdown == "" {
+		return // Checked by toConfig for jobs
+	}
+	types, err := recognizer.recognize(data.Markdown)
+	if err != nil {
+		fmt.Printf("Entity recognition failed for %s: %v\n", data.URL, err)
+		return
+	}
+	entities := locateEntities(data.Markdown, types)
+	entities.Recognizer = recognizer.name()
+	data.StructuredData["entities"] = entities
+}
+
+// relocateEntities finds the entities of a page again after its markdown changed
+func relocateEntities(data *CrawledData) {
+	entities, ok := data.StructuredData["entities"].(*PageEntities)
+	if !ok {
+		return
+	}
+	types := make(map[string]string, len(entities.Entities))
+	for _, entity := range entities.Entities {
+		types[entity.Text] = entity.Type
+	}
+	located := locateEntities(data.Markdown, types)
+	located.Recognizer = entities.Recognizer
+	data.StructuredData["entities"] = located
+}
+
+// locateEntities finds the mentions of entities in markdown outside code blocks,
+// longer names first so "Bank of America" isn't also a mention of "America", and
+// relates the entities mentioned in the same paragraph
+func locateEntities(markdown string, types map[string]string) *PageEntities {
+	texts := make([]string, 0, len(types))
+	for text := range types {
+		texts = append(texts, text)
+	}
+	sort.Slice(texts, func(i, j int) bool {
+		if len(texts[i]) != len(texts[j]) {
+			return len(texts[i]) > len(texts[j])
+		}
+		return texts[i] < texts[j]
+	})
+
+	runes := []rune(markdown)
+	spans := make(map[string][][2]int)
+	var paragraphs []map[string]bool
+	for _, block := range blockSpans(runes, 0, len(runes), false) {
+		if codeFence.MatchString(string(runes[block[0]:block[1]])) {
+			continue
+		}
+		covered := make([]bool, block[1]-block[0])
+		mentioned := make(map[string]bool)
+		for _, text := range texts {
+			name := []rune(text)
+			for start := block[0]; start+len(name) <= block[1]; start++ {
+				if runes[start] != name[0] || !hasRunesAt(runes, start, name) || covered[start-block[0]] ||
+					(start > 0 && isWordRune(runes[start-1])) || (start+len(name) < len(runes) && isWordRune(runes[start+len(name)])) {
+					continue
+				}
+				for i := start; i < start+len(name); i++ {
+					covered[i-block[0]] = true
+				}
+				spans[text] = append(spans[text], [2]int{start, start + len(name)})
+				mentioned[text] = true
+				start += len(name) - 1
+			}
+		}
+		paragraphs = append(paragraphs, mentioned)
+	}
+
+	page := &PageEntities{Entities: []Entity{}, Relations: []EntityRelation{}}
+	for _, text := range texts {
+		if len(spans[text]) > 0 {
+			page.Entities = append(page.Entities, Entity{Text: text, Type: types[text], Spans: spans[text]})
+		}
+	}
+	sort.SliceStable(page.Entities, func(i, j int) bool { return len(page.Entities[i].Spans) > len(page.Entities[j].Spans) })
+	if len(page.Entities) > maxPageEntities {
+		page.Entities = page.Entities[:maxPageEntities]
+	}
+	kept := make(map[string]bool, len(page.Entities))
+	for _, entity := range page.Entities {
+		kept[entity.Text] = true
+	}
+
+	counts := make(map[[2]string]int)
+	for _, mentioned := range paragraphs {
+		var names []string
+		for text := range mentioned {
+			if kept[text] {
+				names = append(names, text)
+			}
+		}
+		sort.Strings(names)
+		for i := range names {
+			for j := i + 1; j < len(names); j++ {
+				counts[[2]string{names[i], names[j]}]++
+			}
+		}
+	}
+	for pair, count := range counts {
+		page.Relations = append(page.Relations, EntityRelation{Source: pair[0], Target: pair[1], Type: "co_occurrence", Count: count})
+	}
+	sort.Slice(page.Relations, func(i, j int) bool {
+		a, b := page.Relations[i], page.Relations[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Source+"\x00"+a.Target < b.Source+"\x00"+b.Target
+	})
+	if len(page.Relations) > maxPageRelations {
+		page.Relations = page.Relations[:maxPageRelations]
+	}
+	return page
+}
+
+// hasRunesAt reports whether runes has name at start
+func hasRunesAt(runes []rune, start int, name []rune) bool {
+	for i, r := range name {
+		if runes[start+i] != r {
+			return false
+		}
+	}
+	return true
+}
+
+// isWordRune reports whether r is part of a word
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsNumber(r)
+}
+
+// Words around names telling their type
+var (
+	personTitles     = wordSet("mr mrs ms dr prof professor sir dame ceo cto founder president senator minister judge author")
+	personVerbs      = wordSet("said says wrote writes told explained added argued noted")
+	orgSuffixes      = wordSet("inc corp corporation ltd llc gmbh ag plc co company group foundation university institute association labs bank agency ministry department council committee society")
+	locationCues     = wordSet("in at from near across throughout")
+	locationSuffixes = wordSet("city county river street avenue island islands mountains lake valley province state republic kingdom bay coast")
+	nameConnectors   = wordSet("of &")
+	notNames         = wordSet("the a an this that these those it its we our you your they their he she his her i if when while but and or for on to with by as is are there here what how why who where which all some no not yes note see also " +
+		"chapter section step figure table page part version item example " +
+		"monday tuesday wednesday thursday friday saturday sunday january february march april may june july august september october november december")
+)
+
+// wordSet makes a set of the space-separated words of a list
+func wordSet(list string) map[string]bool {
+	set := make(map[string]bool)
+	for _, word := range strings.Fields(list) {
+		set[word] = true
+	}
+	return set
+}
+
+// markdownLinkTarget is the URL part of a markdown link or image
+var markdownLinkTarget = regexp.MustCompile(`\]\([^)]*\)`)
+
+// localRecognizer finds entities without a model: runs of capitalized words (and
+// names like iPhone), typed by titles and verbs around people, suffixes of
+// organizations and places, prepositions before places and versions after
+// products. A name's type is the one most of its mentions suggest; names without
+// any are left out.
+type localRecognizer struct{}
+
+func (localRecognizer) name() string { return recognizeLocal }
+
+// nameWord is a word of a block with the punctuation around it removed
+type nameWord struct {
+	text          string
+	sentenceStart bool // First word of a sentence or block
+	sentenceEnd   bool
+}
+
+func (localRecognizer) recognize(markdown string) (map[string]string, error) {
+	votes := make(map[string]map[string]int)
+	runes := []rune(markdown)
+	for _, block := range blockSpans(runes, 0, len(runes), false) {
+		text := string(runes[block[0]:block[1]])
+		if codeFence.MatchString(text) {
+			continue
+		}
+		for _, line := range strings.Split(markdownLinkTarget.ReplaceAllString(text, "] "), "\n") {
+			if strings.HasPrefix(strings.TrimSpace(line), "#") {
+				continue // Headings are title case
+			}
+			recognizeLine(line, votes)
+		}
+	}
+
+	types := make(map[string]string)
+	for name, counts := range votes {
+		best, bestCount := "", 0
+		for _, kind := range []string{entityPerson, entityOrganization, entityProduct, entityLocation} { // Ties go to the more specific cue
+			if counts[kind] > bestCount {
+				best, bestCount = kind, counts[kind]
+			}
+		}
+		if best != "" {
+			types[name] = best
+		}
+	}
+	return types, nil
+}
+
+// recognizeLine votes for the types of the names of a line of text
+func recognizeLine(line string, votes map[string]map[string]int) {
+	var words []nameWord
+	sentenceStart := true
+	for _, field := range strings.Fields(line) {
+		word := strings.TrimFunc(field, func(r rune) bool { return !isWordRune(r) && r != '&' })
+		sentenceEnd := strings.ContainsAny(field[len(field)-1:], ".!?:") && !personTitles[strings.ToLower(word)] // Dr. Jane Goodall
+		if word != "" {
+			words = append(words, nameWord{text: word, sentenceStart: sentenceStart, sentenceEnd: sentenceEnd})
+		}
+		sentenceStart = sentenceEnd || (word == "" && sentenceStart)
+	}
+
+	for i := 0; i < len(words); {
+		if !isNameWord(words[i].text) {
+			i++
+			continue
+		}
+		start := i
+		for i < len(words) && (i == start || !words[i-1].sentenceEnd) &&
+			(isNameWord(words[i].text) || (nameConnectors[strings.ToLower(words[i].text)] && i+1 < len(words) && isNameWord(words[i+1].text))) {
+			i++
+		}
+		run := words[start:i]
+		var previous, next string
+		if start > 0 && !words[start].sentenceStart {
+			previous = strings.ToLower(words[start-1].text)
+		}
+		if i < len(words) && !words[i-1].sentenceEnd {
+			next = strings.ToLower(words[i].text)
+		}
+
+		kind := ""
+		for len(run) > 0 && (personTitles[strings.ToLower(run[0].text)] || notNames[strings.ToLower(run[0].text)]) {
+			if personTitles[strings.ToLower(run[0].text)] {
+				kind = entityPerson
+			}
+			run = run[1:]
+		}
+		if len(run) == 0 {
+			continue
+		}
+		var texts []string
+		for _, word := range run {
+			texts = append(texts, word.text)
+		}
+		name := strings.Join(texts, " ")
+		last := strings.ToLower(run[len(run)-1].text)
+		switch {
+		case kind != "":
+		case personTitles[previous] || personVerbs[next]:
+			kind = entityPerson
+		case len(run) > 1 && orgSuffixes[last]:
+			kind = entityOrganization
+		case len(run) > 1 && locationSuffixes[last]:
+			kind = entityLocation
+		case (next != "" && unicode.IsDigit([]rune(next)[0])) || hasInnerCapital(run[0].text):
+			kind = entityProduct
+		case locationCues[previous]:
+			kind = entityLocation
+		}
+		if votes[name] == nil {
+			votes[name] = make(map[string]int)
+		}
+		if kind != "" {
+			votes[name][kind]++
+		}
+	}
+}
+
+// isNameWord reports whether a word is capitalized, or written like iPhone
+func isNameWord(word string) bool {
+	first := []rune(word)[0]
+	return unicode.IsUpper(first) || (unicode.IsLower(first) && hasInnerCapital(word))
+}
+
+// hasInnerCapital reports whether a word has a capital letter after its first
+// letter and lower-case letters too, like iPhone or PostgreSQL but not NASA
+func hasInnerCapital(word string) bool {
+	runes := []rune(word)
+	inner, lower := false, unicode.IsLower(runes[0])
+	for _, r := range runes[1:] {
+		inner = inner || unicode.IsUpper(r)
+		lower = lower || unicode.IsLower(r)
+	}
+	return inner && lower
+}
+
+// llmRecognizer asks an OpenAI-compatible chat completions API for the entities
+type llmRecognizer struct {
+	apiURL, apiKey, model string
+}
+
+func (r *llmRecognizer) name() string { return recognizeLLM }
+
+func (r *llmRecognizer) recognize(markdown string) (map[string]string, error) {
+	if len(markdown) > maxEntityLLMText {
+		markdown = strings.ToValidUTF8(markdown[:maxEntityLLMText], "")
+	}
+	prompt := "List the named entities of the markdown document the user sends: people, organizations, products and locations. " +
+		`Reply with a JSON object {"entities": [{"text": "<name exactly as written in the document>", "type": "person" or "organization" or "product" or "location"}]}, ` +
+		"each name once, leaving out code."
+	request := map[string]interface{}{
+		"model": r.model,
+		"messages": []map[string]string{
+			{"role": "system", "content": prompt},
+			{"role": "user", "content": markdown},
+		},
+		"response_format": map[string]string{"type": "json_object"},
+	}
+	var response struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	header := http.Header{}
+	if r.apiKey != "" {
+		header.Set("Authorization", "Bearer "+r.apiKey)
+	}
+	if err := postJSON(r.apiURL+"/chat/completions", header, request, &response); err != nil {
+		return nil, err
+	}
+	if len(response.Choices) == 0 {
+		return nil, errors.New("entity API returned no choices")
+	}
+	var result struct {
+		Entities []struct {
+			Text string `json:"text"`
+			Type string `json:"type"`
+		} `json:"entities"`
+	}
+	if err := json.Unmarshal([]byte(response.Choices[0].Message.Content), &result); err != nil {
+		return nil, fmt.Errorf("invalid entity reply: %w", err)
+	}
+	types := make(map[string]string)
+	for _, entity := range result.Entities {
+		switch text := strings.TrimSpace(entity.Text); entity.Type {
+		case entityPerson, entityOrganization, entityProduct, entityLocation:
+			if text != "" {
+				types[text] = entity.Type
+			}
+		}
+	}
+	return types, nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"time"
+)
+
+const (
+	estimateSampleDepth    = 2   // Levels discovered with a dry run to measure the branching factor
+	estimateSamplePages    = 5   // Pages downloaded to measure size and latency
+	estimateParallelism    = 4   // Assumed concurrent fetches when predicting duration
+	jsRenderSecondsPerPage = 3.0 // Rough chromedp overhead per page when EnableJS is set
+)
+
+// CrawlEstimate predicts the size and cost of a crawl before it is run
+type CrawlEstimate struct {
+	StartURL           string   `json:"start_url"`
+	MaxDepth           int      `json:"max_depth"`
+	LevelCounts        []int    `json:"level_counts"` // Pages discovered per depth in the sample, starting at depth 1
+	BranchingFactor    float64  `json:"branching_factor"`
+	SitemapURLs        int      `json:"sitemap_urls"` // In-scope URLs listed in the site's sitemaps
+	SampledPages       int      `json:"sampled_pages"`
+	AvgPageBytes       int64    `json:"avg_page_bytes"`
+	AvgWireBytes       int64    `json:"avg_wire_bytes"` // Compressed as transferred, what bandwidth is billed by
+	AvgFetchMillis     int64    `json:"avg_fetch_millis"`
+	EstimatedPages     int      `json:"estimated_pages"`
+	EstimatedBytes     int64    `json:"estimated_bytes"`
+	EstimatedWireBytes int64    `json:"estimated_wire_bytes"`
+	EstimatedSeconds   float64  `json:"estimated_seconds"`
+	Notes              []string `json:"notes,omitempty"`
+}
+
+// EstimateCrawl samples the sitemap and the first crawl levels to predict page
+// count, duration and bandwidth for a configuration
+func EstimateCrawl(config CrawlerConfig) (*CrawlEstimate, error) {
+	estimate := &CrawlEstimate{StartURL: config.StartURL, MaxDepth: config.MaxDepth, LevelCounts: []int{}}
+
+	sampleConfig := config
+	sampleConfig.DryRun = true
+	sampleConfig.CacheEnabled = false
+	sampleConfig.MaxDepth = estimateSampleDepth
+	if config.MaxDepth > 0 && config.MaxDepth < estimateSampleDepth {
+		sampleConfig.MaxDepth = config.MaxDepth
+	}
+	sampler := NewCrawler(sampleConfig)
+	if _, err := sampler.Crawl(); err != nil {
+		return nil, err
+	}
+	discovered := sampler.DiscoveredURLs()
+	for _, d := range discovered {
+		for len(estimate.LevelCounts) < d.Depth {
+			estimate.LevelCounts = append(estimate.LevelCounts, 0)
+		}
+		estimate.LevelCounts[d.Depth-1]++
+	}
+
+	if sitemaps, err := fetchSitemaps(config.StartURL); err == nil {
+		for _, entry := range sitemaps.URLs {
+			if sampler.isAllowedURL(entry.Loc) {
+				estimate.SitemapURLs++
+			}
+		}
+	} else {
+		estimate.Notes = append(estimate.Notes, "no sitemap available, page count is extrapolated from links only")
+	}
+
+	// Extrapolate level sizes with the branching factor observed between depth 1 and 2
+	if len(estimate.LevelCounts) >= 2 && estimate.LevelCounts[0] > 0 {
+		estimate.BranchingFactor = float64(estimate.LevelCounts[1]) / float64(estimate.LevelCounts[0])
+	}
+	switch {
+	case config.MaxDepth == 0:
+		estimate.EstimatedPages = estimate.SitemapURLs
+		estimate.Notes = append(estimate.Notes, "unlimited depth, page count is based on the sitemap")
+	case config.MaxDepth <= len(estimate.LevelCounts):
+		estimate.EstimatedPages = len(discovered)
+	default:
+		pages := 0.0
+		for depth := 0; depth < config.MaxDepth; depth++ {
+			pages += math.Pow(estimate.BranchingFactor, float64(depth))
+		}
+		estimate.EstimatedPages = int(math.Min(pages, math.MaxInt32))
+	}
+	// A depth-limited crawl can't reach more pages than the site has
+	if estimate.SitemapURLs > 0 && estimate.EstimatedPages > estimate.SitemapURLs {
+		estimate.EstimatedPages = max(estimate.SitemapURLs, len(discovered))
+	}
+	if estimate.EstimatedPages < len(discovered) {
+		estimate.EstimatedPages = len(discovered)
+	}
+
+	sampleURLs := make([]string, 0, estimateSamplePages)
+	for _, d := range discovered {
+		if len(sampleURLs) == estimateSamplePages {
+			break
+		}
+		sampleURLs = append(sampleURLs, d.URL)
+	}
+	var totalBytes, totalWireBytes int64
+	var totalLatency time.Duration
+	for _, sampleURL := range sampleURLs {
+		size, wireSize, latency, err := samplePage(sampleURL)
+		if err != nil {
+			continue
+		}
+		estimate.SampledPages++
+		totalBytes += size
+		totalWireBytes += wireSize
+		totalLatency += latency
+	}
+	if estimate.SampledPages == 0 {
+		estimate.Notes = append(estimate.Notes, "no page could be sampled, bandwidth and duration are unknown")
+		return estimate, nil
+	}
+	estimate.AvgPageBytes = totalBytes / int64(estimate.SampledPages)
+	estimate.AvgWireBytes = totalWireBytes / int64(estimate.SampledPages)
+	avgLatency := totalLatency / time.Duration(estimate.SampledPages)
+	estimate.AvgFetchMillis = avgLatency.Milliseconds()
+
+	estimate.EstimatedBytes = estimate.AvgPageBytes * int64(estimate.EstimatedPages)
+	estimate.EstimatedWireBytes = estimate.AvgWireBytes * int64(estimate.EstimatedPages)
+	secondsPerPage := avgLatency.Seconds()
+	if config.EnableJS {
+		secondsPerPage += jsRenderSecondsPerPage
+	}
+	estimate.EstimatedSeconds = math.Round(secondsPerPage*float64(estimate.EstimatedPages)/estimateParallelism*10) / 10
+	return estimate, nil
+}
+
+// samplePage downloads a page the way crawls do, accepting compressed responses, and
+// reports its size, its size as transferred and the fetch latency
+func samplePage(pageURL string) (int64, int64, time.Duration, error) {
+	counter := &bandwidthCounter{}
+	client := *inspectClient
+	client.Transport = &decodingTransport{next: http.DefaultTransport, counter: counter}
+	start := time.Now()
+	resp, err := client.Get(pageURL)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer resp.Body.Close()
+	size, err := io.Copy(io.Discard, resp.Body)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	return size, counter.wire.Load(), time.Since(start), nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// maxEvalQueries bounds the queries of one evaluation
+const maxEvalQueries = 1000
+
+// defaultEvalK are the cutoffs recall is reported at when a request names none
+var defaultEvalK = []int{1, 5, 10}
+
+// EvalRequest is a set of queries with the pages that should answer them, scored
+// against the retrieval of a corpus's chunks
+type EvalRequest struct {
+	Queries    []EvalQuery   `json:"queries"`
+	Retrievers []string      `json:"retrievers"` // bm25 (default) and embedding (needs LEXICRAWLER_EMBEDDING_URL)
+	K          []int         `json:"k"`          // Cutoffs of recall@k, default 1, 5 and 10
+	Chunking   ChunkerConfig `json:"chunking"`   // How documents are split, by headings by default
+}
+
+// EvalQuery is a query with the URLs of the pages expected to answer it
+type EvalQuery struct {
+	Query    string   `json:"query"`
+	Expected []string `json:"expected"`
+}
+
+// EvalReport scores the retrievers of an evaluation
+type EvalReport struct {
+	Corpus    string             `json:"corpus"`
+	Documents int                `json:"documents"`
+	Chunks    int                `json:"chunks"`
+	Results   []RetrieverResults `json:"results"`
+}
+
+// RetrieverResults are the scores of a retriever over all queries: the mean
+// reciprocal rank of the first expected page, and the share of expected pages
+// retrieved in the first k pages, averaged over queries
+type RetrieverResults struct {
+	Retriever string              `json:"retriever"`
+	MRR       float64             `json:"mrr"`
+	Recall    map[int]float64     `json:"recall"` // By k
+	Queries   []EvalQueryResult   `json:"queries"`
+	Missing   map[string][]string `json:"missing,omitempty"` // Expected URLs not in the corpus, by query
+}
+
+// EvalQueryResult is the retrieval of one query
+type EvalQueryResult struct {
+	Query     string          `json:"query"`
+	Rank      int             `json:"rank"` // Of the first expected page, 0 if it wasn't retrieved
+	Recall    map[int]float64 `json:"recall"`
+	Retrieved []string        `json:"retrieved"` // Pages of the best chunks, up to the largest k
+}
+
+// validate checks an evaluation request and fills in its defaults
+func (r *EvalRequest) validate() error {
+	if len(r.Queries) == 0 {
+		return errors.New("queries are required")
+	}
+	if len(r.Queries) > maxEvalQueries {
+		return fmt.Errorf("at most %d queries can be evaluated at once", maxEvalQueries)
+	}
+	for i, query := range r.Queries {
+		if strings.TrimSpace(query.Query) == "" || len(query.Expected) == 0 {
+			return fmt.Errorf("query %d needs a query and expected URLs", i+1)
+		}
+	}
+	if len(r.Retrievers) == 0 {
+		r.Retrievers = []string{"bm25"}
+	}
+	for _, name := range r.Retrievers {
+		if name != "bm25" && name != "embedding" {
+			return fmt.Errorf("unknown retriever %q (want bm25 or embedding)", name)
+		}
+		if name == "embedding" && embedderFromEnv() == nil {
+			return errors.New("embedding retrieval requires LEXICRAWLER_EMBEDDING_URL")
+		}
+	}
+	if err := r.Chunking.validate(); err != nil {
+		return err
+	}
+	if len(r.K) == 0 {
+		r.K = defaultEvalK
+	}
+	for _, k := range r.K {
+		if k < 1 {
+			return errors.New("k must be positive")
+		}
+	}
+	return nil
+}
+
+// evalURLKey normalizes a page URL for matching retrieved and expected pages,
+// ignoring fragments and trailing slashes
+func evalURLKey(pageURL string) string {
+	pageURL, _, _ = strings.Cut(strings.TrimSpace(pageURL), "#")
+	return strings.TrimSuffix(pageURL, "/")
+}
+
+// evaluateRetrieval splits the documents of a corpus into chunks with the request's
+// chunking, ranks them for every query with each retriever and
+// scores the pages of the ranked chunks against the expected ones
+func evaluateRetrieval(name string, documents []CorpusDocument, request EvalRequest) (EvalReport, error) {
+	report := EvalReport{Corpus: name, Documents: len(documents)}
+	var texts, chunkURLs []string
+	inCorpus := make(map[string]bool, len(documents))
+	deduper := newChunkDeduper()
+	for _, document := range documents {
+		inCorpus[evalURLKey(document.URL)] = true
+		for _, chunk := range deduper.apply(document.URL, pageChunks(document.data, request.Chunking), request.Chunking.Dedupe) {
+			texts = append(texts, strings.Join(chunk.HeadingPath, " ")+"\n"+chunk.Text)
+			chunkURLs = append(chunkURLs, document.URL)
+		}
+	}
+	report.Chunks = len(texts)
+	queries := make([]string, len(request.Queries))
+	for i, query := range request.Queries {
+		queries[i] = query.Query
+	}
+	maxK := 0
+	for _, k := range request.K {
+		maxK = max(maxK, k)
+	}
+
+	for _, kind := range request.Retrievers {
+		var index retriever = newBM25Index(texts)
+		if kind == "embedding" {
+			embedded, err := newEmbeddingIndex(embedderFromEnv(), texts)
+			if err != nil {
+				return EvalReport{}, fmt.Errorf("embedding the corpus: %w", err)
+			}
+			index = embedded
+		}
+		ranked, err := index.rank(queries)
+		if err != nil {
+			return EvalReport{}, fmt.Errorf("ranking with %s: %w", kind, err)
+		}
+
+		results := RetrieverResults{Retriever: kind, Recall: make(map[int]float64)}
+		for q, query := range request.Queries {
+			var pages []string // Pages in the order of their best chunk
+			seen := make(map[string]bool)
+			for _, chunk := range ranked[q] {
+				if key := evalURLKey(chunkURLs[chunk]); !seen[key] {
+					seen[key] = true
+					pages = append(pages, chunkURLs[chunk])
+				}
+			}
+			expected := make(map[string]bool)
+			for _, expectedURL := range query.Expected {
+				key := evalURLKey(expectedURL)
+				expected[key] = true
+				if !inCorpus[key] {
+					if results.Missing == nil {
+						results.Missing = make(map[string][]string)
+					}
+					results.Missing[query.Query] = append(results.Missing[query.Query], expectedURL)
+				}
+			}
+
+			result := EvalQueryResult{Query: query.Query, Recall: make(map[int]float64), Retrieved: append([]string{}, pages[:min(maxK, len(pages))]...)}
+			for _, k := range request.K {
+				result.Recall[k] = 0
+			}
+			for i, page := range pages {
+				if !expected[evalURLKey(page)] {
+					continue
+				}
+				if result.Rank == 0 {
+					result.Rank = i + 1
+				}
+				for _, k := range request.K {
+					if i < k {
+						result.Recall[k] += 1 / float64(len(expected))
+					}
+				}
+			}
+			for _, k := range request.K {
+				results.Recall[k] += result.Recall[k] / float64(len(request.Queries))
+			}
+			if result.Rank > 0 {
+				results.MRR += 1 / float64(result.Rank) / float64(len(request.Queries))
+			}
+			results.Queries = append(results.Queries, result)
+		}
+		report.Results = append(report.Results, results)
+	}
+	return report, nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import "strings"
+
+// Event holds the normalized fields of a schema.org Event (or a subtype such as
+// MusicEvent)
+type Event struct {
+	Name           string     `json:"name"`
+	Description    string     `json:"description,omitempty"`
+	StartDate      string     `json:"start_date,omitempty"`
+	EndDate        string     `json:"end_date,omitempty"`
+	Status         string     `json:"status,omitempty"`          // scheduled, cancelled, postponed, rescheduled, moved_online
+	AttendanceMode string     `json:"attendance_mode,omitempty"` // offline, online or mixed
+	Organizer      string     `json:"organizer,omitempty"`
+	Performers     []string   `json:"performers,omitempty"`
+	Locations      []Location `json:"locations,omitempty"`
+	Price          *float64   `json:"price,omitempty"` // The lowest ticket price
+	Currency       string     `json:"currency,omitempty"`
+	Images         []string   `json:"images,omitempty"`
+	URL            string     `json:"url,omitempty"`
+}
+
+// extractEvent recognizes event pages by a schema.org Event of any subtype
+func extractEvent(page *extractorPage) (interface{}, bool) {
+	item := page.firstMatching(isEventItem)
+	if item == nil {
+		return nil, false
+	}
+	event := Event{
+		Name:        item.str("name"),
+		Description: schemaPlainText(item["description"]),
+		StartDate:   item.str("startDate"),
+		EndDate:     item.str("endDate"),
+		Organizer:   item.str("organizer"),
+		Performers:  item.strs("performer"),
+		Locations:   schemaLocations(item, "location", page.URL),
+		URL:         page.URL,
+	}
+	if status := item.str("eventStatus"); status != "" {
+		event.Status = strings.TrimPrefix(schemaEnum(status), "event_")
+	}
+	if mode := item.str("eventAttendanceMode"); mode != "" {
+		event.AttendanceMode = strings.TrimSuffix(schemaEnum(mode), "_event_attendance_mode")
+	}
+	for _, offer := range item.objects("offers") {
+		if price := priceOf(offer["price"], offer["lowPrice"]); price != nil && (event.Price == nil || *price < *event.Price) {
+			event.Price = price
+			event.Currency = strings.ToUpper(offer.str("priceCurrency"))
+		}
+	}
+	for _, image := range item.strs("image") {
+		event.Images = appendUnique(event.Images, resolveURL(page.URL, image))
+	}
+	if eventURL := item.str("url"); eventURL != "" {
+		event.URL = resolveURL(page.URL, eventURL)
+	}
+	if event.Name == "" {
+		return nil, false
+	}
+	return event, true
+}
+
+// isEventItem matches Event and its subtypes, which all end in "Event" except Festival
+func isEventItem(object schemaObject) bool {
+	for _, name := range object.types() {
+		if strings.HasSuffix(name, "Event") || name == "Festival" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// exportDir holds the generated export files, named after their job
+const exportDir = "./exports"
+
+// Export formats; each is stored as its own file per job
+const (
+	exportZip          = "zip"
+	exportParquetPages = "pages.parquet"
+	exportParquetLinks = "links.parquet"
+	exportLLMs         = "llms.txt"
+	exportLLMsFull     = "llms-full.txt"
+	exportSite         = "site.zip"
+	exportTriples      = "graph.nt"
+	exportNeo4j        = "graph-neo4j.zip"
+)
+
+// exportPage is the per-page entry of pages.json in an export archive
+type exportPage struct {
+	ID             string                 `json:"id"`
+	URL            string                 `json:"url"`
+	File           string                 `json:"file"`
+	Metadata       map[string]string      `json:"metadata"`
+	StructuredData map[string]interface{} `json:"structured_data"`
+	Stats          ContentStats           `json:"stats"`
+	ContentHash    string                 `json:"content_hash"`
+}
+
+// exportPath returns where the export of a job in the given format is stored
+func exportPath(jobID string, format string) string {
+	if format == exportZip {
+		return filepath.Join(exportDir, jobID+".zip")
+	}
+	return filepath.Join(exportDir, jobID+"-"+format)
+}
+
+// removeExports deletes every export of a job
+func removeExports(jobID string) {
+	for _, format := range []string{exportZip, exportParquetPages, exportParquetLinks, exportLLMs, exportLLMsFull, exportSite, exportTriples, exportNeo4j} {
+		os.Remove(exportPath(jobID, format))
+	}
+	templateExports, _ := filepath.Glob(exportPath(jobID, exportTemplatePrefix+"*"))
+	for _, path := range templateExports {
+		os.Remove(path)
+	}
+}
+
+// writeExport writes (or reuses) the export of a job in the given format, or with
+// the output template named after exportTemplatePrefix
+func writeExport(job Job, results map[string]*CrawledData, format string) (string, error) {
+	var output OutputTemplate
+	if name, ok := strings.CutPrefix(format, exportTemplatePrefix); ok {
+		var err error
+		if output, err = job.outputTemplate(name); err != nil {
+			return "", err
+		}
+		format = output.format()
+	}
+	path := exportPath(job.ID, format)
+	if _, err := os.Stat(path); err == nil {
+		return path, nil // Results of a finished job never change
+	}
+
+	var write func(io.Writer) error
+	switch format {
+	case exportZip:
+		write = func(w io.Writer) error { return writeExportArchive(w, job, results) }
+	case exportParquetPages:
+		write = func(w io.Writer) error { return writeParquetPages(w, job.ID, results) }
+	case exportParquetLinks:
+		write = func(w io.Writer) error { return writeParquetLinks(w, job.ID, results) }
+	case exportLLMs:
+		write = func(w io.Writer) error { return writeLLMsTxt(w, job, results) }
+	case exportLLMsFull:
+		write = func(w io.Writer) error { return writeLLMsFullTxt(w, job, results) }
+	case exportSite:
+		write = func(w io.Writer) error { return writeSiteExport(w, job, results) }
+	case exportTriples:
+		write = func(w io.Writer) error { return writeTriples(w, results) }
+	case exportNeo4j:
+		write = func(w io.Writer) error { return writeNeo4jGraph(w, results) }
+	case output.format():
+		write = func(w io.Writer) error { return writeOutputTemplate(w, output, job, results) }
+	default:
+		return "", fmt.Errorf("unknown export format %q", format)
+	}
+	if err := writeFileAtomic(path, write); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// writeFileAtomic writes a temporary file next to path and renames it into place,
+// so a half-written export is never served
+func writeFileAtomic(path string, write func(io.Writer) error) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name()) // No-op once renamed
+
+	if err := write(tmpFile); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpFile.Name(), path); err != nil {
+		return fmt.Errorf("storing export: %w", err)
+	}
+	return nil
+}
+
+// sortedResultURLs returns the page URLs of a job's results in a stable order
+func sortedResultURLs(results map[string]*CrawledData) []string {
+	urls := make([]string, 0, len(results))
+	for pageURL := range results {
+		urls = append(urls, pageURL)
+	}
+	sort.Strings(urls)
+	return urls
+}
+
+// writeExportArchive writes a job's results as a zip archive containing one markdown
+// file per page plus pages.json, report.json and llms.txt. Links between the pages
+// point at their files.
+func writeExportArchive(w io.Writer, job Job, results map[string]*CrawledData) error {
+	archive := zip.NewWriter(w)
+	urls := sortedResultURLs(results)
+	linker := newPageLinker(results, func(pageURL string) string { return pageID(pageURL) + ".md" })
+
+	pages := make([]exportPage, 0, len(urls))
+	for _, pageURL := range urls {
+		data := results[pageURL]
+		page := exportPage{
+			ID:             pageID(pageURL),
+			URL:            pageURL,
+			File:           "pages/" + pageID(pageURL) + ".md",
+			Metadata:       data.Metadata,
+			StructuredData: data.StructuredData,
+			Stats:          data.Stats,
+			ContentHash:    data.ContentHash,
+		}
+		writer, err := archive.Create(page.File)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(writer, linker.rewrite(pageURL, data.Markdown)); err != nil {
+			return err
+		}
+		pages = append(pages, page)
+	}
+
+	for name, value := range map[string]interface{}{"pages.json": pages, "report.json": job.Report} {
+		writer, err := archive.Create(name)
+		if err != nil {
+			return err
+		}
+		encoder := json.NewEncoder(writer)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(value); err != nil {
+			return err
+		}
+	}
+	writer, err := archive.Create(exportLLMs)
+	if err != nil {
+		return err
+	}
+	if err := writeLLMsTxt(writer, job, results); err != nil {
+		return err
+	}
+	return archive.Close()
+}
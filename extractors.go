@@ -0,0 +1,101 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// extractorPage is what page extractors look at: the full page (not the readability
+// content), its schema.org items and its meta tags
+type extractorPage struct {
+	URL    string
+	Doc    *goquery.Selection
+	Schema []schemaObject
+	Meta   map[string]string
+}
+
+// pageExtractor recognizes a kind of page and returns its normalized fields, or
+// false when the page isn't of that kind
+type pageExtractor func(page *extractorPage) (interface{}, bool)
+
+// pageExtractors run on every page; the result of each is stored in the page's
+// structured data under its key
+var pageExtractors = []struct {
+	key     string
+	extract pageExtractor
+}{
+	{"product", extractProduct},
+	{"job_posting", extractJobPosting},
+	{"event", extractEvent},
+	{"recipe", extractRecipe},
+	{"thread", extractThread},
+	{"changelog", extractChangelog},
+}
+
+// runPageExtractors stores the results of the page extractors that recognize a page
+func runPageExtractors(data *CrawledData, doc *goquery.Selection) {
+	page := &extractorPage{
+		URL:    data.URL,
+		Doc:    doc,
+		Schema: schemaObjects(doc),
+		Meta:   extractMetadata(doc, data.URL),
+	}
+	for _, extractor := range pageExtractors {
+		if result, ok := extractor.extract(page); ok {
+			data.StructuredData[extractor.key] = result
+		}
+	}
+}
+
+// markdownSectioner is implemented by extractor results that are also rendered
+// into the page markdown
+type markdownSectioner interface {
+	markdownSection() string
+}
+
+// appendExtractorSections appends the markdown sections of the page's extractor results
+func appendExtractorSections(data *CrawledData) {
+	for _, extractor := range pageExtractors {
+		if result, ok := data.StructuredData[extractor.key].(markdownSectioner); ok {
+			data.Markdown = strings.TrimRight(data.Markdown, "\n") + "\n\n" + result.markdownSection()
+		}
+	}
+}
+
+// markdownReplacer is implemented by extractor results that render the whole body of
+// the page markdown in place of the converted content, such as forum threads
+type markdownReplacer interface {
+	markdownBody() string
+}
+
+// replaceExtractorBodies renders the body of the page markdown from the first extractor
+// result that replaces it, between the page's header and footer
+func replaceExtractorBodies(data *CrawledData, config CrawlerConfig) {
+	for _, extractor := range pageExtractors {
+		if result, ok := data.StructuredData[extractor.key].(markdownReplacer); ok {
+			var markdown strings.Builder
+			frame := markdownFrame{URL: data.URL, Metadata: data.Metadata}
+			renderMarkdownFrame(&markdown, config.markdownHeader(data.URL), frame)
+			markdown.WriteString(result.markdownBody())
+			renderMarkdownFrame(&markdown, config.markdownFooter(data.URL), frame)
+			data.Markdown = markdown.String()
+			return
+		}
+	}
+}
+
+// first returns the first schema.org item of the given type
+func (page *extractorPage) first(schemaType string) schemaObject {
+	return page.firstMatching(func(object schemaObject) bool { return object.is(schemaType) })
+}
+
+// firstMatching returns the first schema.org item match accepts
+func (page *extractorPage) firstMatching(match func(schemaObject) bool) schemaObject {
+	for _, object := range page.Schema {
+		if match(object) {
+			return object
+		}
+	}
+	return nil
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/gocolly/colly/v2"
+)
+
+// Kinds of failures of FailedPage.Kind
+const (
+	failureDNS      = "dns"           // The host name didn't resolve
+	failureTLS      = "tls"           // The certificate or TLS handshake was rejected
+	failureTimeout  = "timeout"       // The fetch or render took too long
+	failure4xx      = "http_4xx"      // The server answered with a client error
+	failure5xx      = "http_5xx"      // The server answered with a server error
+	failureBlocked  = "blocked"       // A bot challenge, rate limit or robots.txt turned the crawler away
+	failureRender   = "render_failed" // Headless Chrome failed to load the page
+	failureParse    = "parse_failed"  // The page was fetched but couldn't be parsed or extracted
+	failureNetwork  = "network"       // The connection was refused, reset or dropped
+	failureRedirect = "redirect"      // A redirect loop, or more redirects than max_redirects
+	failureOther    = "other"
+)
+
+// failureKinds lists the kinds of failures
+var failureKinds = []string{failureDNS, failureTLS, failureTimeout, failure4xx, failure5xx, failureBlocked, failureRender, failureParse, failureNetwork, failureRedirect, failureOther}
+
+var errNoFailedPages = errors.New("no pages of the job failed")
+
+// botChallengeMarkers are found in the bodies of bot challenges and CAPTCHAs served
+// in place of pages, by Cloudflare, DataDome, PerimeterX, Akamai and others
+var botChallengeMarkers = [][]byte{
+	[]byte("challenge-platform"), []byte("cf-chl-"), []byte("just a moment..."), []byte("attention required!"),
+	[]byte("captcha"), []byte("datadome"), []byte("px-captcha"), []byte("_incapsula_resource"), []byte("access denied | "),
+}
+
+// isBotChallenge reports whether an error response turns bots away rather than
+// saying the page is missing or broken: rate limits, and 403 and 503 answers of bot
+// protection
+func isBotChallenge(status int, header http.Header, body []byte) bool {
+	switch {
+	case status == http.StatusTooManyRequests:
+		return true
+	case status != http.StatusForbidden && status != http.StatusServiceUnavailable:
+		return false
+	case header.Get("cf-mitigated") != "":
+		return true
+	}
+	lower := bytes.ToLower(body)
+	for _, marker := range botChallengeMarkers {
+		if bytes.Contains(lower, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// failureKind classifies why fetching or extracting a page failed. Chrome reports
+// network errors as net::ERR_* messages, which are classified like Go's.
+func failureKind(err error) string {
+	var statusErr *statusError
+	var parseErr *parseError
+	var dnsErr *net.DNSError
+	var certErr *tls.CertificateVerificationError
+	var recordErr tls.RecordHeaderError
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidCert x509.CertificateInvalidError
+	var netErr net.Error
+	var renderErr *renderError
+	var opErr *net.OpError
+	var redirectErr *redirectError
+	message := err.Error()
+	switch {
+	case errors.As(err, &statusErr) && statusErr.challenge:
+		return failureBlocked
+	case errors.As(err, &statusErr) && statusErr.status >= 500:
+		return failure5xx
+	case errors.As(err, &statusErr) && statusErr.status >= 400:
+		return failure4xx
+	case errors.As(err, &parseErr):
+		return failureParse
+	case errors.As(err, &redirectErr), strings.Contains(message, "net::ERR_TOO_MANY_REDIRECTS"):
+		return failureRedirect
+	case errors.As(err, &dnsErr), strings.Contains(message, "net::ERR_NAME_NOT_RESOLVED"):
+		return failureDNS
+	case errors.As(err, &certErr), errors.As(err, &recordErr), errors.As(err, &unknownAuthority), errors.As(err, &hostnameErr),
+		errors.As(err, &invalidCert), strings.Contains(message, "net::ERR_CERT_"), strings.Contains(message, "net::ERR_SSL_"):
+		return failureTLS
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout(), strings.Contains(message, "net::ERR_TIMED_OUT"):
+		return failureTimeout
+	case errors.Is(err, colly.ErrRobotsTxtBlocked), strings.Contains(message, "net::ERR_BLOCKED_BY_"):
+		return failureBlocked
+	case strings.Contains(message, "net::ERR_CONNECTION_"), strings.Contains(message, "net::ERR_EMPTY_RESPONSE"):
+		return failureNetwork
+	case errors.As(err, &renderErr):
+		return failureRender
+	case errors.As(err, &opErr), errors.Is(err, syscall.ECONNRESET), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return failureNetwork
+	}
+	return failureOther
+}
+
+// newFailedPage describes a page that failed with err
+func newFailedPage(pageURL string, err error) FailedPage {
+	failed := FailedPage{URL: pageURL, Kind: failureKind(err), Error: err.Error()}
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		failed.Status = statusErr.status
+	}
+	return failed
+}
+
+// JobErrors are the failed pages of a job
+type JobErrors struct {
+	JobID  string         `json:"job_id"`
+	Kinds  map[string]int `json:"kinds"` // Failures by kind, before filtering
+	Errors []FailedPage   `json:"errors"`
+}
+
+// FailureFilter selects failed pages by kind, HTTP status and host; zero values
+// select all
+type FailureFilter struct {
+	Kinds  []string
+	Status int
+	Host   string
+}
+
+// parseFailureFilter reads a filter from the kind (comma-separated), status and host
+// query parameters
+func parseFailureFilter(kinds string, status string, host string) (FailureFilter, error) {
+	filter := FailureFilter{Host: strings.ToLower(host)}
+	for _, kind := range strings.Split(kinds, ",") {
+		if kind = strings.TrimSpace(kind); kind == "" {
+			continue
+		}
+		if !containsString(failureKinds, kind) {
+			return FailureFilter{}, fmt.Errorf("unknown kind %q (want one of %s)", kind, strings.Join(failureKinds, ", "))
+		}
+		filter.Kinds = append(filter.Kinds, kind)
+	}
+	if status != "" {
+		code, err := strconv.Atoi(status)
+		if err != nil || code < 100 || code > 599 {
+			return FailureFilter{}, errors.New("invalid status")
+		}
+		filter.Status = code
+	}
+	return filter, nil
+}
+
+// matches reports whether a failed page is selected by the filter
+func (filter FailureFilter) matches(failed FailedPage) bool {
+	if len(filter.Kinds) > 0 && !containsString(filter.Kinds, failed.Kind) {
+		return false
+	}
+	if filter.Status != 0 && failed.Status != filter.Status {
+		return false
+	}
+	if filter.Host != "" {
+		parsedURL, err := url.Parse(failed.URL)
+		if err != nil || strings.ToLower(parsedURL.Hostname()) != filter.Host {
+			return false
+		}
+	}
+	return true
+}
+
+// Errors returns the failed pages of a tenant's job selected by filter: of its crawl
+// so far while it runs, of its report once it completed
+func (m *JobManager) Errors(tenant *Tenant, id string, filter FailureFilter) (*JobErrors, error) {
+	m.mutex.Lock()
+	job, err := m.lookup(tenant, id)
+	var failed []FailedPage
+	if err == nil {
+		switch {
+		case job.crawler != nil:
+			failed = job.crawler.FailedPages()
+		case job.Report != nil:
+			failed = job.Report.Failed
+		}
+	}
+	m.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	errs := &JobErrors{JobID: id, Kinds: make(map[string]int), Errors: []FailedPage{}}
+	for _, page := range failed {
+		errs.Kinds[page.Kind]++
+		if filter.matches(page) {
+			errs.Errors = append(errs.Errors, page)
+		}
+	}
+	return errs, nil
+}
+
+// RetryFailed submits a job fetching the failed pages of a tenant's completed job
+// selected by filter again, with its settings. Only the pages themselves are
+// fetched, not the pages they link to; retries of a job adding to a corpus add the
+// pages to it too.
+func (m *JobManager) RetryFailed(tenant *Tenant, id string, filter FailureFilter) (Job, error) {
+	snapshot, _, err := m.Results(tenant, id)
+	if err != nil {
+		return Job{}, err
+	}
+	if snapshot.Request.Archive != "" {
+		return Job{}, errors.New("pages of archive replays fail the same way again")
+	}
+	var urls []string
+	for _, failed := range snapshot.Report.Failed {
+		if filter.matches(failed) {
+			urls = append(urls, failed.URL)
+		}
+	}
+	if len(urls) == 0 {
+		return Job{}, errNoFailedPages
+	}
+	sort.Strings(urls)
+
+	request := snapshot.Request
+	seeds := make(map[string]Seed)
+	for _, seed := range request.Seeds {
+		seeds[seed.key()] = seed
+	}
+	depth := 1
+	request.URL, request.AllowedDomains, request.Alerts = "", nil, nil
+	request.MaxDepth, request.MaxPages = &depth, len(urls)
+	request.Seeds = make([]Seed, len(urls))
+	for i, pageURL := range urls {
+		if seed, ok := seeds[pageURL]; ok {
+			request.Seeds[i] = seed // Keeps the method and body of seeds
+		} else {
+			request.Seeds[i] = Seed{URL: pageURL}
+		}
+	}
+	retry, err := m.Submit(tenant, request)
+	if err != nil {
+		return Job{}, err
+	}
+	m.mutex.Lock()
+	if job, ok := m.jobs[retry.ID]; ok {
+		job.RetryOf = id
+	}
+	m.mutex.Unlock()
+	retry.RetryOf = id
+	return retry, nil
+}
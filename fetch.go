@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/gocolly/colly/v2"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+)
+
+// errNotHTML is returned for responses the extraction pipeline can't process (neither
+// HTML nor a document type such as JSON or XML); such pages are skipped silently
+var errNotHTML = errors.New("not an HTML document")
+
+// statusError is a fetch answered with an HTTP error status
+type statusError struct {
+	status    int
+	challenge bool // The answer is a bot challenge or CAPTCHA, see isBotChallenge
+	err       error
+}
+
+func (e *statusError) Error() string { return e.err.Error() }
+
+func (e *statusError) Unwrap() error { return e.err }
+
+// renderError is a headless load of a page that failed
+type renderError struct{ err error }
+
+func (e *renderError) Error() string { return e.err.Error() }
+
+func (e *renderError) Unwrap() error { return e.err }
+
+// parseError is a fetched page that couldn't be parsed
+type parseError struct{ err error }
+
+func (e *parseError) Error() string { return "parsing the page: " + e.err.Error() }
+
+func (e *parseError) Unwrap() error { return e.err }
+
+// Fetcher loads a single page. The crawler's scheduler (colly's frontier, depth and
+// domain rules) decides what to fetch; a Fetcher only decides how.
+type Fetcher interface {
+	Fetch(pageURL string) (*fetchedPage, error)
+}
+
+// fetchedPage is a downloaded or rendered page handed to the extraction pipeline
+type fetchedPage struct {
+	URL             string
+	HTML            string
+	DOM             *goquery.Selection // Nil for non-HTML documents
+	ContentType     string             // Of non-HTML documents, see isDocumentContentType
+	Body            string             // Of non-HTML documents
+	ContentLanguage string             // The Content-Language response header
+	Header          http.Header        // Response headers of static fetches
+	RenderedWithJS  bool
+	Ruled           bool                 // A FetchRule chose how the page was fetched
+	Screenshot      []byte               // Captured while rendering, when screenshots are enabled
+	Accessibility   *AccessibilityReport // Audited while rendering, when accessibility audits are enabled
+	Visit           func(string) error   // Queues a link one level deeper than this page
+}
+
+// newFetchedPage parses the HTML of a page
+func newFetchedPage(pageURL string, content string) (*fetchedPage, error) {
+	htmlDoc, err := html.Parse(strings.NewReader(content))
+	if err != nil {
+		return nil, &parseError{err}
+	}
+	return &fetchedPage{URL: pageURL, HTML: content, DOM: goquery.NewDocumentFromNode(htmlDoc).Selection}, nil
+}
+
+// isHTMLContentType reports whether a Content-Type (or an unknown one) may hold HTML
+func isHTMLContentType(contentType string) bool {
+	return contentType == "" || strings.Contains(contentType, "html")
+}
+
+// StaticFetcher downloads pages over HTTP without running JS. It reuses the crawl's
+// colly backend, so the on-disk response cache and charset detection still apply.
+type StaticFetcher struct {
+	collector *colly.Collector
+	header    http.Header    // Sent with every request (Accept-Language)
+	filter    *ContentFilter // Skips responses by their headers, nil downloads every response
+}
+
+// NewStaticFetcher creates a fetcher sharing the crawl's HTTP backend and settings
+func NewStaticFetcher(backend *colly.Collector, header http.Header) *StaticFetcher {
+	collector := backend.Clone()
+	collector.Async = false
+	collector.AllowURLRevisit = true // The frontier already deduplicated the URL
+	collector.MaxDepth = 0
+	return &StaticFetcher{collector: collector, header: header}
+}
+
+// Fetch downloads a page
+func (f *StaticFetcher) Fetch(pageURL string) (*fetchedPage, error) {
+	return f.fetch(http.MethodGet, pageURL, "", "")
+}
+
+// fetch sends a request, with a body unless it is empty
+func (f *StaticFetcher) fetch(method, pageURL, body, contentType string) (*fetchedPage, error) {
+	collector := f.collector.Clone() // Per-fetch callbacks on a clone, so fetches can run concurrently
+
+	var response *colly.Response
+	collector.OnResponse(func(r *colly.Response) {
+		response = r
+	})
+	var skipped *skippedError
+	if f.filter != nil {
+		collector.OnResponseHeaders(func(r *colly.Response) {
+			if r.StatusCode >= 200 && r.StatusCode < 300 { // Error pages are failures, whatever their type
+				if skipped = f.filter.skipsResponse(*r.Headers); skipped != nil {
+					r.Request.Abort()
+				}
+			}
+		})
+	}
+	status, challenge := 0, false
+	collector.OnError(func(r *colly.Response, _ error) {
+		if r != nil {
+			status = r.StatusCode
+			challenge = r.Headers != nil && isBotChallenge(status, *r.Headers, r.Body)
+		}
+	})
+	header := f.header.Clone()
+	header.Set("User-Agent", collector.UserAgent)
+	var requestBody io.Reader
+	if body != "" {
+		requestBody = strings.NewReader(body)
+		if contentType != "" {
+			header.Set("Content-Type", contentType)
+		}
+	}
+	if err := collector.Request(method, pageURL, requestBody, nil, header); err != nil {
+		if skipped != nil && errors.Is(err, colly.ErrAbortedAfterHeaders) {
+			return nil, skipped
+		}
+		if status != 0 {
+			return nil, &statusError{status: status, challenge: challenge, err: err}
+		}
+		return nil, err
+	}
+	if response == nil {
+		return nil, fmt.Errorf("no response for %s", pageURL)
+	}
+	if contentType := response.Headers.Get("Content-Type"); isDocumentContentType(contentType) {
+		page := newDocumentPage(response.Request.URL.String(), contentType, string(response.Body))
+		page.Header = *response.Headers
+		return page, nil
+	} else if !isHTMLContentType(contentType) {
+		return nil, errNotHTML
+	}
+	page, err := newFetchedPage(response.Request.URL.String(), string(response.Body))
+	if err != nil {
+		return nil, err
+	}
+	page.ContentLanguage = response.Headers.Get("Content-Language")
+	page.Header = *response.Headers
+	return page, nil
+}
+
+// HeadlessFetcher renders pages with chromedp
+type HeadlessFetcher struct {
+	crawler *Crawler
+}
+
+// Fetch renders a page, capturing its screenshot in the same page load when enabled
+func (f *HeadlessFetcher) Fetch(pageURL string) (*fetchedPage, error) {
+	page, err := f.crawler.renderPage(pageURL)
+	if err != nil {
+		return nil, &renderError{err}
+	}
+	return page, nil
+}
+
+// FileFetcher reads pages from the local filesystem (file:// URLs) below Root. A
+// directory URL serves its index.html.
+type FileFetcher struct {
+	Root string
+}
+
+// Fetch reads a local HTML file, detecting its charset from <meta> tags
+func (f *FileFetcher) Fetch(pageURL string) (*fetchedPage, error) {
+	parsedURL, err := url.Parse(pageURL)
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.FromSlash(parsedURL.Path)
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		path = filepath.Join(path, "index.html")
+	}
+	if !isWithinRoot(f.Root, path) { // The file read, which may be a symlink
+		return nil, fmt.Errorf("%s is outside the allowed file root", path)
+	}
+	ext := strings.ToLower(filepath.Ext(path))
+	documentTypes := map[string]string{".json": "application/json", ".xml": "application/xml", ".rss": "application/rss+xml", ".atom": "application/atom+xml"}
+	if ext != ".html" && ext != ".htm" && documentTypes[ext] == "" {
+		return nil, errNotHTML
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if contentType := documentTypes[ext]; contentType != "" {
+		return newDocumentPage(pageURL, contentType, string(content)), nil
+	}
+	reader, err := charset.NewReader(bytes.NewReader(content), "text/html")
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	return newFetchedPage(pageURL, string(decoded))
+}
+
+// ArchivedResponse is a recorded HTTP response of an archive
+type ArchivedResponse struct {
+	URL         string
+	StatusCode  int
+	ContentType string
+	Body        []byte
+}
+
+// ArchiveFetcher serves pages from recorded responses instead of the network, so
+// archived crawls can be re-processed through the same pipeline
+type ArchiveFetcher struct {
+	responses map[string]*ArchivedResponse // By URL
+}
+
+// NewArchiveFetcher indexes recorded responses by URL; later records of a URL win
+func NewArchiveFetcher(responses []*ArchivedResponse) *ArchiveFetcher {
+	fetcher := &ArchiveFetcher{responses: make(map[string]*ArchivedResponse)}
+	for _, response := range responses {
+		fetcher.responses[response.URL] = response
+	}
+	return fetcher
+}
+
+// Fetch returns the archived response of a page
+func (f *ArchiveFetcher) Fetch(pageURL string) (*fetchedPage, error) {
+	response, ok := f.responses[pageURL]
+	if !ok {
+		return nil, fmt.Errorf("%s is not in the archive", pageURL)
+	}
+	if response.StatusCode >= 400 {
+		return nil, fmt.Errorf("archived response for %s has status %d", pageURL, response.StatusCode)
+	}
+	if isDocumentContentType(response.ContentType) {
+		return newDocumentPage(pageURL, response.ContentType, string(response.Body)), nil
+	} else if !isHTMLContentType(response.ContentType) {
+		return nil, errNotHTML
+	}
+	reader, err := charset.NewReader(bytes.NewReader(response.Body), response.ContentType)
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	return newFetchedPage(pageURL, string(decoded))
+}
+
+// fetcherFor selects the fetcher of a URL: seeds with a method or body, local files
+// and archives first, then the
+// FetchRules/EnableJS decision between headless and static fetching. Dry runs never
+// render, they only need links.
+func (c *Crawler) fetcherFor(pageURL string, static *StaticFetcher) (Fetcher, bool) {
+	if seed, ok := c.seedRequests[pageURL]; ok {
+		return &SeedFetcher{static: static, seed: seed}, true // Ruled, so it isn't retried with JS
+	}
+	if strings.HasPrefix(pageURL, "file://") {
+		return &FileFetcher{Root: c.Config.FileRoot}, false
+	}
+	if c.Archive != nil {
+		return c.Archive, false
+	}
+	useJS, ruled := c.fetchMode(pageURL)
+	if useJS && !c.Config.DryRun && !c.Config.MetadataOnly {
+		return &HeadlessFetcher{crawler: c}, ruled
+	}
+	return static, ruled
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Fetch modes of a FetchRule
+const (
+	fetchModeStatic = "static"
+	fetchModeJS     = "js"
+)
+
+// FetchRule decides whether matching URLs are fetched statically or rendered with
+// JS, so only the parts of a site that need a browser pay for one
+type FetchRule struct {
+	// Pattern is a glob matched against the URL path when it starts with "/", or
+	// against the whole URL otherwise. "*" matches within a path segment, "**" across
+	// segments, e.g. "/app/**" or "https://*.example.com/dashboard/*".
+	Pattern string `json:"pattern"`
+	Mode    string `json:"mode"` // "static" or "js"
+}
+
+// compileURLPattern converts a FetchRule glob into an anchored regular expression
+func compileURLPattern(pattern string) (*regexp.Regexp, error) {
+	var expr strings.Builder
+	expr.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch {
+		case strings.HasPrefix(pattern[i:], "/**"):
+			expr.WriteString("(/.*)?") // "/app/**" also matches "/app" itself
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			expr.WriteString(".*")
+			i++
+		case pattern[i] == '*':
+			expr.WriteString("[^/]*")
+		case pattern[i] == '?':
+			expr.WriteString("[^/]")
+		default:
+			expr.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	expr.WriteString("$")
+	return regexp.Compile(expr.String())
+}
+
+// validateFetchRules checks the modes and patterns of a rule set
+func validateFetchRules(rules []FetchRule) error {
+	for _, rule := range rules {
+		if rule.Mode != fetchModeStatic && rule.Mode != fetchModeJS {
+			return fmt.Errorf("fetch rule %q: mode must be static or js", rule.Pattern)
+		}
+		if rule.Pattern == "" {
+			return fmt.Errorf("fetch rule without pattern")
+		}
+		if _, err := compileURLPattern(rule.Pattern); err != nil {
+			return fmt.Errorf("fetch rule %q: %w", rule.Pattern, err)
+		}
+	}
+	return nil
+}
+
+// hasJSRule reports whether any rule renders pages with JS
+func hasJSRule(rules []FetchRule) bool {
+	for _, rule := range rules {
+		if rule.Mode == fetchModeJS {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchMode returns whether a URL is rendered with JS, and whether a rule decided it
+// (as opposed to the global EnableJS default). The first matching rule wins.
+func (c *Crawler) fetchMode(pageURL string) (useJS bool, ruled bool) {
+	for _, rule := range c.Config.FetchRules {
+		if urlPatternMatches(rule.Pattern, pageURL) {
+			return rule.Mode == fetchModeJS, true
+		}
+	}
+	return c.Config.EnableJS, false
+}
+
+// urlPatternMatches reports whether a URL matches a FetchRule-style pattern
+func urlPatternMatches(pattern string, pageURL string) bool {
+	subject := pageURL
+	if strings.HasPrefix(pattern, "/") {
+		parsedURL, err := url.Parse(pageURL)
+		if err != nil {
+			return false
+		}
+		subject = parsedURL.EscapedPath()
+		if subject == "" {
+			subject = "/"
+		}
+	}
+	compiled, err := compileURLPattern(pattern)
+	return err == nil && compiled.MatchString(subject)
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/html"
+)
+
+// Markdown flavors, for the tools the generated markdown is meant for
+const (
+	flavorGFM        = "gfm"        // GitHub: pipe tables, > [!NOTE] alerts; the default
+	flavorCommonMark = "commonmark" // No extensions: tables as HTML blocks, callouts as plain quotes
+	flavorObsidian   = "obsidian"   // Vault notes: > [!note] callouts, [[wikilinks]] between pages, newlines break lines
+)
+
+// lineBreakMarker stands for a <br> in inline text until the block it ends up in is
+// known. The HTML parser replaces NUL in text, so page content can't contain it.
+const lineBreakMarker = "\x00"
+
+// calloutKinds maps the class name parts of admonitions and alerts to the five kinds
+// GitHub renders; Obsidian reads them too
+var calloutKinds = map[string]string{
+	"note": "note", "info": "note", "information": "note", "seealso": "note", "primary": "note", "secondary": "note",
+	"tip": "tip", "hint": "tip", "success": "tip",
+	"important": "important", "essential": "important",
+	"warning": "warning", "warn": "warning", "attention": "warning",
+	"caution": "caution", "danger": "caution", "error": "caution",
+}
+
+// validateMarkdownFlavor checks the markdown flavor of a crawl, "" being flavorGFM
+func validateMarkdownFlavor(flavor string) error {
+	switch flavor {
+	case "", flavorGFM, flavorCommonMark, flavorObsidian:
+		return nil
+	}
+	return fmt.Errorf("markdown_flavor must be %s, %s or %s", flavorGFM, flavorCommonMark, flavorObsidian)
+}
+
+// hardBreak returns the line break of the flavor for a <br>, continued with the
+// prefix of the block (list indentation, quote marker)
+func (w *markdownWriter) hardBreak(prefix string) string {
+	if w.flavor == flavorObsidian {
+		return "\n" + prefix // Obsidian breaks lines at every newline
+	}
+	return "\\\n" + prefix
+}
+
+// inlineLines renders the text of a block that can hold line breaks, see inline
+func (w *markdownWriter) inlineLines(node *html.Node, prefix string) string {
+	w.lineBreak = w.hardBreak(prefix)
+	defer func() { w.lineBreak = "" }()
+	return w.inline(node)
+}
+
+// wikilink links Obsidian notes to the pages of the crawl's host, by their page IDs:
+// the names of their notes in the job's export. It returns "" for other links, and
+// keeps the whitespace around the text.
+func (w *markdownWriter) wikilink(text string, href string) string {
+	label := strings.TrimSpace(strings.ReplaceAll(text, lineBreakMarker, " "))
+	base, err := url.Parse(w.baseURL)
+	if w.flavor != flavorObsidian || label == "" || err != nil {
+		return ""
+	}
+	target, err := base.Parse(strings.TrimSpace(href))
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host != base.Host {
+		return ""
+	}
+	target.Fragment = ""
+	separator := "|"
+	if w.inTable {
+		separator = `\|`
+	}
+	label = strings.NewReplacer(`\[`, "", `\]`, "", "[", "", "]", "", `\|`, "", "|", "").Replace(label) // Would end the alias early
+	leading := text[:len(text)-len(strings.TrimLeftFunc(text, unicode.IsSpace))]
+	trailing := text[len(strings.TrimRightFunc(text, unicode.IsSpace)):]
+	return leading + "[[" + pageID(target.String()) + separator + label + "]]" + trailing
+}
+
+// calloutKind returns the kind of an admonition, callout or alert box (Sphinx, MkDocs,
+// Docusaurus, Asciidoctor, Bootstrap, GitHub, Confluence), "" for other elements
+func calloutKind(node *html.Node) string {
+	switch node.Data {
+	case "div", "aside", "section", "blockquote":
+	default:
+		return ""
+	}
+	classes, _ := attr(node, "class")
+	callout := false
+	kind := ""
+	for _, class := range strings.Fields(strings.ToLower(classes)) {
+		parts := strings.FieldsFunc(class, func(r rune) bool { return r == '-' || r == '_' })
+		for i := len(parts) - 1; i >= 0; i-- { // The kind ends names like confluence-information-macro-warning
+			if strings.Contains(parts[i], "admonition") || parts[i] == "callout" || parts[i] == "alert" || class == "confluence-information-macro" {
+				callout = true
+			} else if calloutKinds[parts[i]] != "" && kind == "" {
+				kind = calloutKinds[parts[i]]
+			}
+		}
+	}
+	if !callout {
+		return ""
+	}
+	if kind == "" {
+		kind = "note"
+	}
+	return kind
+}
+
+// calloutTitle returns the title element of a callout, a child named like
+// admonition-title, callout-title or alert-heading
+func calloutTitle(node *html.Node) *html.Node {
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type != html.ElementNode {
+			continue
+		}
+		classes, _ := attr(child, "class")
+		classes = strings.ToLower(classes)
+		if strings.Contains(classes, "title") || strings.Contains(classes, "heading") {
+			return child
+		}
+	}
+	return nil
+}
+
+// callout emits an admonition as a quote marked with its kind: a GitHub alert, an
+// Obsidian callout or, in CommonMark, a quote starting with the kind in bold
+func (w *markdownWriter) callout(node *html.Node, kind string) {
+	titleNode := calloutTitle(node)
+	title := ""
+	if titleNode != nil {
+		title = w.inline(titleNode)
+	}
+	var body strings.Builder
+	inner := *w
+	inner.out, inner.skip, inner.mediaItems = &body, titleNode, nil
+	inner.children(node)
+	w.mediaItems = append(w.mediaItems, inner.mediaItems...)
+	content := strings.TrimSpace(blankLines.ReplaceAllString(body.String(), "\n\n"))
+	if content == "" {
+		content = w.inlineLines(node, "") // Text directly in the box, outside paragraphs
+		if titleNode != nil {
+			content = strings.TrimSpace(strings.TrimPrefix(content, title))
+		}
+	}
+	if content == "" && title == "" {
+		return
+	}
+	if strings.EqualFold(title, kind) {
+		title = "" // Shown anyway, e.g. GitHub's "Note"
+	}
+
+	label := strings.ToUpper(kind[:1]) + kind[1:]
+	switch w.flavor {
+	case flavorObsidian:
+		w.out.WriteString("> [!" + kind + "]")
+		if title != "" {
+			w.out.WriteString(" " + title)
+		}
+		w.out.WriteString("\n")
+	case flavorCommonMark:
+		w.out.WriteString("> **" + firstNonEmpty(title, label) + "**\n>\n")
+	default:
+		w.out.WriteString("> [!" + strings.ToUpper(kind) + "]\n")
+		if title != "" {
+			w.out.WriteString("> **" + title + "**\n>\n")
+		}
+	}
+	for _, line := range strings.Split(content, "\n") {
+		if line == "" {
+			w.out.WriteString(">\n")
+		} else {
+			w.out.WriteString("> " + line + "\n")
+		}
+	}
+	w.out.WriteString("\n")
+}
+
+// htmlTable emits a table as an HTML block, for flavors without pipe tables. Cells
+// hold their text only, as markdown isn't parsed inside HTML blocks.
+func (w *markdownWriter) htmlTable(node *html.Node) {
+	cell := func(tag string, node *html.Node) string {
+		var text []string
+		for _, line := range strings.Split(nodeTextLines(node), "\n") {
+			if line = strings.Join(strings.Fields(line), " "); line != "" {
+				text = append(text, html.EscapeString(line))
+			}
+		}
+		return "<" + tag + ">" + strings.Join(text, "<br>") + "</" + tag + ">"
+	}
+	var rows []string
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type != html.ElementNode {
+				continue
+			}
+			if child.Data == "table" {
+				continue // Nested tables are flattened into their cell's text
+			}
+			if child.Data != "tr" {
+				walk(child)
+				continue
+			}
+			var row strings.Builder
+			for c := child.FirstChild; c != nil; c = c.NextSibling {
+				if c.Type == html.ElementNode && (c.Data == "th" || c.Data == "td") {
+					row.WriteString(cell(c.Data, c))
+				}
+			}
+			rows = append(rows, "<tr>"+row.String()+"</tr>")
+		}
+	}
+	walk(node)
+	if len(rows) == 0 {
+		return
+	}
+	w.out.WriteString("\n<table>\n" + strings.Join(rows, "\n") + "\n</table>\n\n")
+}
+
+// nodeTextLines returns the text of a node with its <br>s as newlines and other
+// whitespace as spaces
+func nodeTextLines(node *html.Node) string {
+	var text strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type == html.TextNode {
+				text.WriteString(strings.ReplaceAll(child.Data, "\n", " "))
+			} else if child.Type == html.ElementNode && child.Data == "br" {
+				text.WriteString("\n")
+			} else {
+				walk(child)
+			}
+		}
+	}
+	walk(node)
+	return text.String()
+}
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"container/heap"
+	"hash/fnv"
+	"math"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// frontierEntry is a URL queued for crawling
+type frontierEntry struct {
+	URL   string  `json:"url"`
+	Depth int     `json:"depth"`
+	Score float64 `json:"score,omitempty"` // Priority, see URLScorer
+	seq   uint64  // Order of discovery, breaks ties between scores
+	key   []byte  // Key in a persistent queue, set when leased
+}
+
+// frontierStore holds the queue of a crawl. An entry is leased while its page goes
+// through the pipeline and acknowledged once the page is stored, together with the
+// links found on it, so an interrupted crawl gets back the pages it was processing
+// and never queues a link twice.
+type frontierStore interface {
+	// complete acknowledges a leased entry with the page stored for it (nil when none
+	// was stored) and queues the requests found on it that weren't queued before. The
+	// seeds of a crawl are queued with a nil entry.
+	complete(entry *frontierEntry, data *CrawledData, found []frontierEntry) error
+	// lease takes the best queued entry among the hosts ready accepts: the highest
+	// score, the earliest found on ties. False when none of them has one queued.
+	lease(ready func(host string) bool) (frontierEntry, bool, error)
+	// queued counts the entries waiting to be leased
+	queued() int
+	// completed counts the entries acknowledged so far, across pauses and restarts
+	completed() int
+	// close releases the store and everything it holds on disk
+	close() error
+}
+
+// requestHash identifies a request in a frontier, hashed like colly did when it
+// scheduled crawls
+func requestHash(requestURL string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(requestURL))
+	return h.Sum64()
+}
+
+// requestHost returns the host a request is sharded by, "" for file:// URLs
+func requestHost(requestURL string) string {
+	parsedURL, err := url.Parse(requestURL)
+	if err != nil {
+		return ""
+	}
+	return parsedURL.Hostname()
+}
+
+// memoryFrontier queues entries in memory, for crawls that aren't resumed after a
+// restart, with a queue per host. Queued requests are remembered in a urlSet of the
+// crawl's dedup mode.
+type memoryFrontier struct {
+	mutex   sync.Mutex
+	seen    urlSet
+	shards  map[string]*entryHeap // By host
+	waiting int
+	seq     uint64
+	done    int
+}
+
+// entryHeap orders entries by score, then by discovery
+type entryHeap []frontierEntry
+
+// before reports whether an entry is crawled before another
+func (e frontierEntry) before(other frontierEntry) bool {
+	if e.Score != other.Score {
+		return e.Score > other.Score
+	}
+	return e.seq < other.seq
+}
+
+func (h entryHeap) Len() int            { return len(h) }
+func (h entryHeap) Less(i, j int) bool  { return h[i].before(h[j]) }
+func (h entryHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *entryHeap) Push(x interface{}) { *h = append(*h, x.(frontierEntry)) }
+func (h *entryHeap) Pop() interface{} {
+	old := *h
+	entry := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return entry
+}
+
+// newMemoryFrontier creates an empty in-memory frontier
+func (config CrawlerConfig) newMemoryFrontier() (*memoryFrontier, error) {
+	seen, err := config.newURLSet()
+	if err != nil {
+		return nil, err
+	}
+	return &memoryFrontier{seen: seen, shards: make(map[string]*entryHeap)}, nil
+}
+
+func (f *memoryFrontier) complete(entry *frontierEntry, data *CrawledData, found []frontierEntry) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if entry != nil {
+		f.done++
+	}
+	for _, request := range found {
+		hash := requestHash(request.URL)
+		seen, err := f.seen.contains(hash)
+		if err != nil {
+			return err
+		}
+		if seen {
+			continue
+		}
+		if err := f.seen.add(hash); err != nil {
+			return err
+		}
+		f.seq++
+		request.seq = f.seq
+		host := requestHost(request.URL)
+		shard, ok := f.shards[host]
+		if !ok {
+			shard = &entryHeap{}
+			f.shards[host] = shard
+		}
+		heap.Push(shard, request)
+		f.waiting++
+	}
+	return nil
+}
+
+func (f *memoryFrontier) lease(ready func(host string) bool) (frontierEntry, bool, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	var bestHost string
+	var best *entryHeap
+	for host, shard := range f.shards {
+		if (best == nil || (*shard)[0].before((*best)[0])) && ready(host) {
+			bestHost, best = host, shard
+		}
+	}
+	if best == nil {
+		return frontierEntry{}, false, nil
+	}
+	entry := heap.Pop(best).(frontierEntry)
+	if best.Len() == 0 {
+		delete(f.shards, bestHost)
+	}
+	f.waiting--
+	return entry, true, nil
+}
+
+func (f *memoryFrontier) queued() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.waiting
+}
+
+func (f *memoryFrontier) completed() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.done
+}
+
+func (f *memoryFrontier) close() error {
+	return f.seen.close()
+}
+
+// frontier schedules the pages of a crawl from its store: dispatchers lease entries
+// with next and hand them back with done, which queues the links found on the page.
+// The depth and domain rules of the crawl are applied as links are found, and links
+// are scored as they are queued. Every host has its own politeness budget, so
+// dispatchers move on to other hosts while one is at its limit instead of waiting
+// on a slow host.
+type frontier struct {
+	store       frontierStore
+	maxDepth    int
+	allowed     func(string) bool
+	trapped     func(string) bool
+	scorer      URLScorer // nil crawls in discovery order
+	concurrency int       // Pages of a host in flight at once, 0 for no limit
+	delay       time.Duration
+	mutex       sync.Mutex
+	changed     *sync.Cond // Broadcast when entries are queued or leases end
+	leased      int
+	hosts       map[string]*hostBudget
+	wake        *time.Timer // Broadcasts once the delay of a waiting host ends
+	wakeAt      time.Time
+	err         error // First failure of the store, ends the crawl
+}
+
+// hostBudget is the politeness state of a host
+type hostBudget struct {
+	inFlight  int
+	nextStart time.Time // Before which no page of the host is leased
+}
+
+func newFrontier(store frontierStore, config CrawlerConfig, allowed func(string) bool, trapped func(string) bool) *frontier {
+	f := &frontier{
+		store:       store,
+		maxDepth:    config.MaxDepth,
+		allowed:     allowed,
+		trapped:     trapped,
+		scorer:      config.URLScorer,
+		concurrency: config.HostConcurrency,
+		delay:       config.HostDelay,
+		hosts:       make(map[string]*hostBudget),
+	}
+	f.changed = sync.NewCond(&f.mutex)
+	return f
+}
+
+// request returns the entry of a URL found at a depth, false for URLs beyond the
+// crawl's depth or outside its domains
+func (f *frontier) request(rawURL string, depth int) (frontierEntry, bool) {
+	if rawURL == "" || (f.maxDepth > 0 && depth > f.maxDepth) {
+		return frontierEntry{}, false
+	}
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return frontierEntry{}, false
+	}
+	requestURL := parsedURL.String()
+	if !f.allowed(requestURL) {
+		return frontierEntry{}, false
+	}
+	return frontierEntry{URL: requestURL, Depth: depth}, true
+}
+
+// seed queues the start pages of a crawl. Seeds queued by an earlier run of the
+// crawl are skipped like any other known request.
+func (f *frontier) seed(seeds []string) error {
+	var found []frontierEntry
+	for _, seed := range seeds {
+		if entry, ok := f.request(seed, 1); ok {
+			found = append(found, entry)
+		}
+	}
+	f.score(nil, found)
+	if err := f.store.complete(nil, nil, found); err != nil {
+		return err
+	}
+	f.changed.Broadcast()
+	return nil
+}
+
+// next leases the next entry of a host within its budget, waiting while every host
+// with queued entries is at its limit or pages in flight may still queue links. It
+// returns false once the crawl is stopped, or nothing is queued or in flight.
+func (f *frontier) next(stopped func() bool) (frontierEntry, bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for f.err == nil && !stopped() {
+		now := time.Now()
+		var wakeAt time.Time // Earliest end of a host's delay
+		ready := func(host string) bool {
+			budget, ok := f.hosts[host]
+			if !ok {
+				return true
+			}
+			if now.Before(budget.nextStart) {
+				if wakeAt.IsZero() || budget.nextStart.Before(wakeAt) {
+					wakeAt = budget.nextStart
+				}
+				return false
+			}
+			return f.concurrency == 0 || budget.inFlight < f.concurrency
+		}
+		entry, ok, err := f.store.lease(ready)
+		if err != nil {
+			f.err = err
+			break
+		}
+		if ok {
+			f.leased++
+			host := requestHost(entry.URL)
+			budget, known := f.hosts[host]
+			if !known {
+				budget = &hostBudget{}
+				f.hosts[host] = budget
+			}
+			budget.inFlight++
+			budget.nextStart = now.Add(f.delay)
+			return entry, true
+		}
+		if f.leased == 0 && wakeAt.IsZero() {
+			break
+		}
+		if !wakeAt.IsZero() {
+			f.wakeUp(wakeAt)
+		}
+		f.changed.Wait()
+	}
+	f.changed.Broadcast() // Lets the other dispatchers see the crawl is over
+	return frontierEntry{}, false
+}
+
+// wakeUp wakes the waiting dispatchers at a time, unless an earlier wake-up is due
+func (f *frontier) wakeUp(at time.Time) {
+	if f.wake != nil && f.wakeAt.After(time.Now()) && !f.wakeAt.After(at) {
+		return
+	}
+	if f.wake != nil {
+		f.wake.Stop()
+	}
+	f.wakeAt = at
+	f.wake = time.AfterFunc(time.Until(at), func() {
+		f.mutex.Lock()
+		f.changed.Broadcast()
+		f.mutex.Unlock()
+	})
+}
+
+// done acknowledges a leased entry with the page stored for it, nil if none, and
+// queues the links found on the page
+func (f *frontier) done(entry frontierEntry, data *CrawledData, found []frontierEntry) {
+	f.score(data, found)
+	err := f.store.complete(&entry, data, found)
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.leased--
+	host := requestHost(entry.URL)
+	budget := f.hosts[host]
+	if budget.inFlight--; budget.inFlight == 0 && !time.Now().Before(budget.nextStart) {
+		delete(f.hosts, host) // Keeps the map to the hosts being crawled
+	}
+	if err != nil && f.err == nil {
+		f.err = err
+	}
+	f.changed.Broadcast()
+}
+
+// score sets the scores of found requests with the crawl's scorer, if any. Negative
+// scores and NaN count as 0, so they sort last.
+func (f *frontier) score(from *CrawledData, found []frontierEntry) {
+	if f.scorer == nil || len(found) == 0 {
+		return
+	}
+	f.scorer(from, found)
+	for i := range found {
+		switch score := found[i].Score; {
+		case math.IsNaN(score) || score < 0:
+			found[i].Score = 0
+		case math.IsInf(score, 1):
+			found[i].Score = math.MaxFloat64
+		}
+	}
+}
+
+// failure returns the error of the store that ended the crawl, if any
+func (f *frontier) failure() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.err
+}
+
+// pageLinks collects the links found on a page while it is processed, resolved
+// against the page URL like links in a browser. They are queued when the page is done.
+type pageLinks struct {
+	frontier *frontier
+	base     *url.URL
+	depth    int // Of the links
+	mutex    sync.Mutex
+	found    []frontierEntry
+}
+
+func (f *frontier) linksOf(entry frontierEntry) *pageLinks {
+	base, _ := url.Parse(entry.URL) // Parsed before it was queued
+	return &pageLinks{frontier: f, base: base, depth: entry.Depth + 1}
+}
+
+// visit records a link of the page; links outside the crawl or into crawler traps
+// are dropped and fragment-only links ignored
+func (l *pageLinks) visit(link string) error {
+	if strings.HasPrefix(link, "#") {
+		return nil
+	}
+	absURL, err := l.base.Parse(link)
+	if err != nil {
+		return nil
+	}
+	absURL.Fragment = ""
+	entry, ok := l.frontier.request(absURL.String(), l.depth)
+	if !ok || l.frontier.trapped(entry.URL) {
+		return nil
+	}
+	l.mutex.Lock()
+	l.found = append(l.found, entry)
+	l.mutex.Unlock()
+	return nil
+}
+
+// links returns the links recorded so far
+func (l *pageLinks) links() []frontierEntry {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.found
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Buckets of a persistent frontier; seen is seenBucket
+var (
+	frontierMetaBucket   = []byte("frontier") // The job and the count of completed entries
+	frontierQueueBucket  = []byte("queue")    // Entries by queueKey: by host, then in crawl order
+	frontierLeaseBucket  = []byte("leased")   // Entries being processed, queued again on restore
+	frontierPagesBucket  = []byte("pages")    // Stored pages by URL
+	frontierJobKey       = []byte("job")
+	frontierCompletedKey = []byte("completed")
+)
+
+func init() {
+	// The values pages keep in StructuredData, so stored pages decode with their types;
+	// every result type of pageExtractors is among them
+	for _, value := range []interface{}{
+		[]string{}, []interface{}{}, map[string]interface{}{}, map[string][]interface{}{}, []map[string]string{},
+		[]*OutlineNode{}, []HreflangAlternate{}, []Comment{}, []MediaItem{}, []Transcript{},
+		&PageContacts{}, &AccessibilityReport{}, &AccessWall{}, Translation{}, Feed{}, SitemapResult{}, []ImageData{}, &PageEntities{},
+		&PageTopics{}, &PageSentiment{}, Product{}, JobPosting{}, Event{}, Recipe{}, Thread{}, Changelog{},
+	} {
+		gob.Register(value)
+	}
+}
+
+// frontierDirFromEnv returns the directory job frontiers are kept in, so jobs
+// survive restarts: "frontiers" in LEXICRAWLER_DATA_DIR, or "" to keep them in memory
+func frontierDirFromEnv() string {
+	dataDir := os.Getenv("LEXICRAWLER_DATA_DIR")
+	if dataDir == "" {
+		return ""
+	}
+	return filepath.Join(dataDir, "frontiers")
+}
+
+// boltFrontier keeps the queue of a job in a bolt file, with the job itself and the
+// pages completed so far. Every lease and acknowledgement is a synced transaction,
+// and an acknowledgement queues the page's links in the same one, so a crash loses
+// no URL and repeats no completed page. Queued requests are remembered exactly on
+// disk whatever the crawl's dedup mode.
+type boltFrontier struct {
+	mutex   sync.Mutex
+	db      *bolt.DB
+	done    int
+	waiting int               // Queued entries, counted to keep queued off the disk
+	heads   map[string][]byte // Key of the next entry of every host with queued entries
+}
+
+// storedPage is the encoding of a page in the pages bucket
+type storedPage struct {
+	Data        *CrawledData
+	RawHTMLZstd []byte // Kept raw HTML, read back from disk if it was spilled
+}
+
+// newBoltFrontier creates the frontier file of a job
+func newBoltFrontier(path string, job *Job) (*boltFrontier, error) {
+	record, err := json.Marshal(job)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0o600, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{frontierMetaBucket, frontierQueueBucket, frontierLeaseBucket, frontierPagesBucket, seenBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(frontierMetaBucket).Put(frontierJobKey, record)
+	})
+	if err != nil {
+		db.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	return &boltFrontier{db: db, heads: make(map[string][]byte)}, nil
+}
+
+// openBoltFrontier reopens the frontier file of an interrupted job, queueing the
+// entries it was processing again, and returns it with the job it was created for
+func openBoltFrontier(path string) (*boltFrontier, *Job, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second}) // Instead of waiting on another server's lock
+	if err != nil {
+		return nil, nil, err
+	}
+	f := &boltFrontier{db: db, heads: make(map[string][]byte)}
+	job := &Job{}
+	err = db.Update(func(tx *bolt.Tx) error {
+		meta, queue, leased := tx.Bucket(frontierMetaBucket), tx.Bucket(frontierQueueBucket), tx.Bucket(frontierLeaseBucket)
+		if meta == nil || queue == nil || leased == nil || tx.Bucket(frontierPagesBucket) == nil || tx.Bucket(seenBucket) == nil {
+			return fmt.Errorf("not a frontier file")
+		}
+		if err := json.Unmarshal(meta.Get(frontierJobKey), job); err != nil {
+			return fmt.Errorf("reading the job: %w", err)
+		}
+		if completed := meta.Get(frontierCompletedKey); completed != nil {
+			f.done = int(binary.BigEndian.Uint64(completed))
+		}
+		var requeued [][2][]byte
+		leased.ForEach(func(key, value []byte) error {
+			requeued = append(requeued, [2][]byte{key, value})
+			return nil
+		})
+		for _, entry := range requeued { // Same key, so it keeps its place in the queue
+			if err := queue.Put(entry[0], entry[1]); err != nil {
+				return err
+			}
+			if err := leased.Delete(entry[0]); err != nil {
+				return err
+			}
+		}
+		return queue.ForEach(func(key, _ []byte) error {
+			f.waiting++
+			if host := keyHost(key); f.heads[host] == nil { // Keys sort by host, then in crawl order
+				f.heads[host] = append([]byte(nil), key...)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+	return f, job, nil
+}
+
+func (f *boltFrontier) complete(entry *frontierEntry, data *CrawledData, found []frontierEntry) error {
+	var page []byte
+	if data != nil {
+		var err error
+		if page, err = encodeStoredPage(data); err != nil {
+			return fmt.Errorf("encoding %s: %w", data.URL, err)
+		}
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	queued := make(map[string][]byte) // Earliest key queued per host
+	waiting := f.waiting
+	err := f.db.Update(func(tx *bolt.Tx) error {
+		if entry != nil {
+			if err := tx.Bucket(frontierLeaseBucket).Delete(entry.key); err != nil {
+				return err
+			}
+			if page != nil {
+				if err := tx.Bucket(frontierPagesBucket).Put([]byte(entry.URL), page); err != nil {
+					return err
+				}
+			}
+			completed := binary.BigEndian.AppendUint64(nil, uint64(f.done+1))
+			if err := tx.Bucket(frontierMetaBucket).Put(frontierCompletedKey, completed); err != nil {
+				return err
+			}
+		}
+		seen, queue := tx.Bucket(seenBucket), tx.Bucket(frontierQueueBucket)
+		for _, request := range found {
+			hash := binary.BigEndian.AppendUint64(nil, requestHash(request.URL))
+			if seen.Get(hash) != nil {
+				continue
+			}
+			if err := seen.Put(hash, seenMarker); err != nil {
+				return err
+			}
+			value, err := json.Marshal(request)
+			if err != nil {
+				return err
+			}
+			seq, err := queue.NextSequence()
+			if err != nil {
+				return err
+			}
+			host := requestHost(request.URL)
+			key := queueKey(host, request.Score, seq)
+			if err := queue.Put(key, value); err != nil {
+				return err
+			}
+			if earliest, ok := queued[host]; !ok || bytes.Compare(key, earliest) < 0 {
+				queued[host] = key
+			}
+			f.waiting++ // Restored below if the transaction fails
+		}
+		return nil
+	})
+	if err != nil {
+		f.waiting = waiting
+		return err
+	}
+	for host, key := range queued {
+		if head := f.heads[host]; head == nil || bytes.Compare(key, head) < 0 {
+			f.heads[host] = key
+		}
+	}
+	if entry != nil {
+		f.done++
+	}
+	return nil
+}
+
+func (f *boltFrontier) lease(ready func(host string) bool) (frontierEntry, bool, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	var bestHost string
+	var best []byte
+	for host, head := range f.heads {
+		if (best == nil || bytes.Compare(head[len(host)+1:], best[len(bestHost)+1:]) < 0) && ready(host) {
+			bestHost, best = host, head
+		}
+	}
+	if best == nil {
+		return frontierEntry{}, false, nil
+	}
+	var entry frontierEntry
+	var next []byte // The host's following entry, nil when it was its last
+	err := f.db.Update(func(tx *bolt.Tx) error {
+		queue := tx.Bucket(frontierQueueBucket)
+		value := queue.Get(best)
+		if err := json.Unmarshal(value, &entry); err != nil {
+			return err
+		}
+		if err := tx.Bucket(frontierLeaseBucket).Put(best, value); err != nil {
+			return err
+		}
+		cursor := queue.Cursor()
+		cursor.Seek(best)
+		if key, _ := cursor.Next(); key != nil && keyHost(key) == bestHost {
+			next = append([]byte(nil), key...) // Keys are only valid within the transaction
+		}
+		return queue.Delete(best)
+	})
+	if err != nil {
+		return frontierEntry{}, false, err
+	}
+	if next != nil {
+		f.heads[bestHost] = next
+	} else {
+		delete(f.heads, bestHost)
+	}
+	f.waiting--
+	entry.key = best
+	return entry, true, nil
+}
+
+func (f *boltFrontier) queued() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.waiting
+}
+
+func (f *boltFrontier) completed() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.done
+}
+
+// pages returns the pages completed before the job was interrupted
+func (f *boltFrontier) pages() (map[string]*CrawledData, error) {
+	pages := make(map[string]*CrawledData)
+	err := f.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(frontierPagesBucket).ForEach(func(key, value []byte) error {
+			var page storedPage
+			if err := gob.NewDecoder(bytes.NewReader(value)).Decode(&page); err != nil {
+				return fmt.Errorf("decoding %s: %w", key, err)
+			}
+			page.Data.rawHTMLZstd = page.RawHTMLZstd
+			pages[string(key)] = page.Data
+			return nil
+		})
+	})
+	return pages, err
+}
+
+func (f *boltFrontier) close() error {
+	path := f.db.Path()
+	if err := f.db.Close(); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// encodeStoredPage encodes a page with its kept raw HTML
+func encodeStoredPage(data *CrawledData) ([]byte, error) {
+	page := storedPage{Data: data, RawHTMLZstd: data.rawHTMLZstd}
+	if data.rawHTMLFile != "" {
+		compressed, err := os.ReadFile(data.rawHTMLFile)
+		if err != nil {
+			return nil, err
+		}
+		page.RawHTMLZstd = compressed
+	}
+	var buffer bytes.Buffer
+	if err := gob.NewEncoder(&buffer).Encode(page); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// queueKey groups the queue by host, and orders the entries of a host by descending
+// score, then by sequence number. The bits of non-negative floats sort like their
+// values, inverted they sort descending.
+func queueKey(host string, score float64, seq uint64) []byte {
+	key := append([]byte(host), 0)
+	key = binary.BigEndian.AppendUint64(key, ^math.Float64bits(score))
+	return binary.BigEndian.AppendUint64(key, seq)
+}
+
+// keyHost returns the host of a queueKey
+func keyHost(key []byte) string {
+	return string(key[:len(key)-17])
+}
+
+// frontierPath returns the frontier file of a job
+func frontierPath(dir string, jobID string) string {
+	return filepath.Join(dir, jobID+".db")
+}
+
+// frontierFiles lists the frontier files left in a directory by jobs that didn't finish
+func frontierFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".db") {
+			paths = append(paths, filepath.Join(dir, entry.Name()))
+		}
+	}
+	return paths, nil
+}
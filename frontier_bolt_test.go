@@ -0,0 +1,117 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// extractorFixtures are pages each page extractor recognizes, by its key
+var extractorFixtures = map[string]string{
+	"product": `<script type="application/ld+json">{"@context": "https://schema.org", "@type": "Product", "name": "Kettle",
+		"offers": {"@type": "Offer", "price": "39.90", "priceCurrency": "EUR"}}</script>`,
+	"job_posting": `<script type="application/ld+json">{"@context": "https://schema.org", "@type": "JobPosting", "title": "Baker",
+		"hiringOrganization": {"@type": "Organization", "name": "Bakery"}, "datePosted": "2024-05-01"}</script>`,
+	"event": `<script type="application/ld+json">{"@context": "https://schema.org", "@type": "Event", "name": "Concert",
+		"startDate": "2024-06-01T20:00"}</script>`,
+	"recipe": `<script type="application/ld+json">{"@context": "https://schema.org", "@type": "Recipe", "name": "Bread",
+		"recipeIngredient": ["flour", "water"], "recipeInstructions": ["Mix", "Bake"]}</script>`,
+	"thread": `<script type="application/ld+json">{"@context": "https://schema.org", "@type": "QAPage", "mainEntity": {"@type": "Question",
+		"name": "Why?", "text": "Why is it so?", "acceptedAnswer": {"@type": "Answer", "text": "Because."}}}</script>`,
+	"changelog": `<h1>Changelog</h1><h2>1.2.0 - 2024-03-01</h2><ul><li>Added a thing</li></ul>
+		<h2>1.1.0 - 2024-02-01</h2><ul><li>Fixed a bug</li></ul><h2>1.0.0 - 2024-01-01</h2><ul><li>First release</li></ul>`,
+}
+
+// TestBoltFrontierStoresExtractorResults round-trips a page of every page extractor
+// through a persistent frontier, which gob-encodes its structured data
+func TestBoltFrontierStoresExtractorResults(t *testing.T) {
+	frontier, err := newBoltFrontier(filepath.Join(t.TempDir(), "job.bolt"), &Job{ID: "job"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer frontier.close()
+
+	want := make(map[string]reflect.Type)
+	for _, extractor := range pageExtractors {
+		fixture, ok := extractorFixtures[extractor.key]
+		if !ok {
+			t.Fatalf("no fixture for the %s extractor", extractor.key)
+		}
+		pageURL := "https://example.com/" + extractor.key
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader("<html><head><title>" + extractor.key + "</title></head><body>" + fixture + "</body></html>"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := &CrawledData{URL: pageURL, StructuredData: make(map[string]interface{}), Metadata: map[string]string{}}
+		runPageExtractors(data, doc.Selection)
+		result, ok := data.StructuredData[extractor.key]
+		if !ok {
+			t.Fatalf("the %s extractor didn't recognize its fixture", extractor.key)
+		}
+		want[pageURL] = reflect.TypeOf(result)
+
+		if err := frontier.complete(nil, nil, []frontierEntry{{URL: pageURL, Depth: 1}}); err != nil {
+			t.Fatal(err)
+		}
+		entry, ok, err := frontier.lease(func(string) bool { return true })
+		if err != nil || !ok {
+			t.Fatalf("leasing %s: %v", pageURL, err)
+		}
+		if err := frontier.complete(&entry, data, nil); err != nil {
+			t.Fatalf("storing the %s page: %v", extractor.key, err)
+		}
+	}
+
+	pages, err := frontier.pages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for pageURL, resultType := range want {
+		page, ok := pages[pageURL]
+		if !ok {
+			t.Fatalf("%s wasn't stored", pageURL)
+		}
+		key := strings.TrimPrefix(pageURL, "https://example.com/")
+		if got := reflect.TypeOf(page.StructuredData[key]); got != resultType {
+			t.Errorf("%s decoded as %v, want %v", key, got, resultType)
+		}
+	}
+}
+
+// TestBoltFrontierQueuesBatchDuplicatesOnce completes a page whose links hold the
+// same URL twice, as when a link is also an hreflang alternate
+func TestBoltFrontierQueuesBatchDuplicatesOnce(t *testing.T) {
+	frontier, err := newBoltFrontier(filepath.Join(t.TempDir(), "job.bolt"), &Job{ID: "job"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer frontier.close()
+
+	found := []frontierEntry{{URL: "https://example.com/a", Depth: 1}, {URL: "https://example.com/b", Depth: 1}, {URL: "https://example.com/a", Depth: 1}}
+	if err := frontier.complete(nil, nil, found); err != nil {
+		t.Fatal(err)
+	}
+	if queued := frontier.queued(); queued != 2 {
+		t.Fatalf("queued %d entries, want 2", queued)
+	}
+	if err := frontier.complete(nil, nil, found[:1]); err != nil { // Seen in an earlier batch
+		t.Fatal(err)
+	}
+	leased := make(map[string]int)
+	for {
+		entry, ok, err := frontier.lease(func(string) bool { return true })
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			break
+		}
+		leased[entry.URL]++
+	}
+	if len(leased) != 2 || leased["https://example.com/a"] != 1 || leased["https://example.com/b"] != 1 {
+		t.Errorf("leased %v, want each URL once", leased)
+	}
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Vocabulary of the RDF export: schema.org, plus a term of its own for entity
+// co-occurrence, which schema.org has none for
+const (
+	rdfType         = "http://www.w3.org/1999/02/22-rdf-syntax-ns#type"
+	schemaOrg       = "http://schema.org/"
+	coOccursWith    = "urn:lexicrawler:vocab:coOccursWith"
+	entityIRIPrefix = "urn:lexicrawler:entity:"
+)
+
+// entitySchemaTypes are the schema.org types and Neo4j labels of entity types
+var entitySchemaTypes = map[string]string{
+	entityPerson:       "Person",
+	entityOrganization: "Organization",
+	entityProduct:      "Product",
+	entityLocation:     "Place",
+}
+
+// itemSchemaTypes are the schema.org types of the page extractors' results, which
+// become the main entities of their pages
+var itemSchemaTypes = []struct{ key, schemaType string }{
+	{"product", "Product"},
+	{"job_posting", "JobPosting"},
+	{"event", "Event"},
+	{"recipe", "Recipe"},
+}
+
+// knowledgeGraph is a job's results as a graph: pages and the entities they mention
+// are nodes, links and mentions are edges. Entities are merged across pages by type
+// and text.
+type knowledgeGraph struct {
+	pages         []graphPage
+	entities      []graphEntity
+	links         []graphEdge
+	mentions      []graphEdge
+	coOccurrences []graphEdge
+}
+
+// graphPage is a page node; pages linked to but not crawled have no title
+type graphPage struct {
+	ID          string
+	URL         string
+	Title       string
+	Description string
+	Crawled     bool
+	Items       []graphItem
+}
+
+// graphItem is the product, event, ... a page is about, from its schema.org data
+type graphItem struct {
+	Type string // schema.org type
+	Name string
+}
+
+// graphEntity is an entity node
+type graphEntity struct {
+	ID   string
+	Name string
+	Type string
+}
+
+// graphEdge connects two nodes by ID; Count is how often the links, mentions or
+// co-occurrences it stands for were found
+type graphEdge struct {
+	Source string
+	Target string
+	Count  int
+}
+
+// buildKnowledgeGraph collects the graph of a job's results
+func buildKnowledgeGraph(results map[string]*CrawledData) *knowledgeGraph {
+	graph := &knowledgeGraph{}
+	pageIDs := make(map[string]string)
+	for _, pageURL := range sortedResultURLs(results) {
+		data := results[pageURL]
+		page := graphPage{
+			ID:          "page:" + pageID(pageURL),
+			URL:         pageURL,
+			Title:       data.Metadata["title"],
+			Description: data.Metadata["description"],
+			Crawled:     true,
+		}
+		for _, item := range itemSchemaTypes {
+			if name, ok := itemName(data.StructuredData[item.key]); ok {
+				page.Items = append(page.Items, graphItem{Type: item.schemaType, Name: name})
+			}
+		}
+		graph.pages = append(graph.pages, page)
+		pageIDs[pageURL] = page.ID
+	}
+
+	links := make(map[[2]string]int)
+	var linked []string
+	for _, pageURL := range sortedResultURLs(results) {
+		targets, _ := results[pageURL].StructuredData["links"].([]string)
+		for _, target := range targets {
+			if pageIDs[target] == "" {
+				pageIDs[target] = "page:" + pageID(target)
+				linked = append(linked, target)
+			}
+			links[[2]string{pageIDs[pageURL], pageIDs[target]}]++
+		}
+	}
+	sort.Strings(linked)
+	for _, target := range linked {
+		graph.pages = append(graph.pages, graphPage{ID: pageIDs[target], URL: target})
+	}
+	graph.links = sortedEdges(links)
+
+	entities := make(map[string]graphEntity)
+	mentions := make(map[[2]string]int)
+	coOccurrences := make(map[[2]string]int)
+	for _, pageURL := range sortedResultURLs(results) {
+		pageEntities, _ := results[pageURL].StructuredData["entities"].(*PageEntities)
+		if pageEntities == nil {
+			continue
+		}
+		idsByText := make(map[string]string, len(pageEntities.Entities))
+		for _, entity := range pageEntities.Entities {
+			id := "entity:" + entity.Type + ":" + entity.Text
+			entities[id] = graphEntity{ID: id, Name: entity.Text, Type: entity.Type}
+			idsByText[entity.Text] = id
+			mentions[[2]string{pageIDs[pageURL], id}] += len(entity.Spans)
+		}
+		for _, relation := range pageEntities.Relations {
+			source, target := idsByText[relation.Source], idsByText[relation.Target]
+			if source == "" || target == "" {
+				continue
+			}
+			if target < source { // Co-occurrence is symmetric, so each pair is one edge
+				source, target = target, source
+			}
+			coOccurrences[[2]string{source, target}] += relation.Count
+		}
+	}
+	for _, entity := range entities {
+		graph.entities = append(graph.entities, entity)
+	}
+	sort.Slice(graph.entities, func(i, j int) bool { return graph.entities[i].ID < graph.entities[j].ID })
+	graph.mentions = sortedEdges(mentions)
+	graph.coOccurrences = sortedEdges(coOccurrences)
+	return graph
+}
+
+// itemName returns the name of a page extractor's result
+func itemName(result interface{}) (string, bool) {
+	switch item := result.(type) {
+	case Product:
+		return item.Name, true
+	case JobPosting:
+		return item.Title, true
+	case Event:
+		return item.Name, true
+	case Recipe:
+		return item.Name, true
+	}
+	return "", false
+}
+
+// sortedEdges returns counted edges ordered by source and target
+func sortedEdges(counts map[[2]string]int) []graphEdge {
+	edges := make([]graphEdge, 0, len(counts))
+	for pair, count := range counts {
+		edges = append(edges, graphEdge{Source: pair[0], Target: pair[1], Count: count})
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].Source != edges[j].Source {
+			return edges[i].Source < edges[j].Source
+		}
+		return edges[i].Target < edges[j].Target
+	})
+	return edges
+}
+
+// writeTriples writes a job's knowledge graph as N-Triples with the schema.org
+// vocabulary: pages are WebPages named by their URL that link to (relatedLink) other
+// pages and mention entities, and have the items their schema.org data describes as
+// their mainEntity
+func writeTriples(w io.Writer, results map[string]*CrawledData) error {
+	graph := buildKnowledgeGraph(results)
+	iris := make(map[string]string) // By node ID
+	triples := &tripleWriter{w: w}
+	for _, page := range graph.pages {
+		iris[page.ID] = rdfIRI(page.URL)
+		if !page.Crawled {
+			continue
+		}
+		triples.resource(iris[page.ID], rdfType, rdfIRI(schemaOrg+"WebPage"))
+		if page.Title != "" {
+			triples.literal(iris[page.ID], schemaOrg+"name", page.Title)
+		}
+		if page.Description != "" {
+			triples.literal(iris[page.ID], schemaOrg+"description", page.Description)
+		}
+		for i, item := range page.Items {
+			node := fmt.Sprintf("_:%s-%d", strings.TrimPrefix(page.ID, "page:"), i)
+			triples.resource(iris[page.ID], schemaOrg+"mainEntity", node)
+			triples.resource(node, rdfType, rdfIRI(schemaOrg+item.Type))
+			if item.Name != "" {
+				triples.literal(node, schemaOrg+"name", item.Name)
+			}
+		}
+	}
+	for _, entity := range graph.entities {
+		iris[entity.ID] = rdfIRI(entityIRIPrefix + entity.Type + ":" + entity.Name)
+		schemaType := entitySchemaTypes[entity.Type]
+		if schemaType == "" {
+			schemaType = "Thing"
+		}
+		triples.resource(iris[entity.ID], rdfType, rdfIRI(schemaOrg+schemaType))
+		triples.literal(iris[entity.ID], schemaOrg+"name", entity.Name)
+	}
+	for _, link := range graph.links {
+		triples.resource(iris[link.Source], schemaOrg+"relatedLink", iris[link.Target])
+	}
+	for _, mention := range graph.mentions {
+		triples.resource(iris[mention.Source], schemaOrg+"mentions", iris[mention.Target])
+	}
+	for _, pair := range graph.coOccurrences {
+		triples.resource(iris[pair.Source], coOccursWith, iris[pair.Target])
+	}
+	return triples.err
+}
+
+// tripleWriter writes N-Triples lines, keeping the first error
+type tripleWriter struct {
+	w   io.Writer
+	err error
+}
+
+// resource writes a triple whose object is an IRI (in angle brackets) or blank node;
+// subject is one too
+func (t *tripleWriter) resource(subject string, predicate string, object string) {
+	if t.err == nil {
+		_, t.err = fmt.Fprintf(t.w, "%s <%s> %s .\n", subject, predicate, object)
+	}
+}
+
+// literal writes a triple whose object is a string
+func (t *tripleWriter) literal(subject string, predicate string, value string) {
+	t.resource(subject, predicate, rdfLiteral(value))
+}
+
+// rdfIRI returns an IRI in N-Triples syntax, percent-encoding the characters IRIs
+// can't contain
+func rdfIRI(iri string) string {
+	var b strings.Builder
+	b.WriteByte('<')
+	for _, r := range iri {
+		if r <= ' ' || strings.ContainsRune("<>\"{}|^`\\", r) {
+			fmt.Fprintf(&b, "%%%02X", r)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('>')
+	return b.String()
+}
+
+// rdfLiteral returns a string literal in N-Triples syntax
+func rdfLiteral(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`).Replace(value) + `"`
+}
+
+// writeNeo4jGraph writes a job's knowledge graph as a zip archive of CSV files in the
+// format of neo4j-admin database import: Page, Entity and Item nodes, and LINKS_TO,
+// MENTIONS, CO_OCCURS_WITH and MAIN_ENTITY relationships
+func writeNeo4jGraph(w io.Writer, results map[string]*CrawledData) error {
+	graph := buildKnowledgeGraph(results)
+	archive := zip.NewWriter(w)
+
+	pages := [][]string{{"id:ID", "url", "title", "description", "crawled:boolean", ":LABEL"}}
+	items := [][]string{{"id:ID", "name", ":LABEL"}}
+	mainEntities := [][]string{{":START_ID", ":END_ID", ":TYPE"}}
+	for _, page := range graph.pages {
+		pages = append(pages, []string{page.ID, page.URL, page.Title, page.Description, strconv.FormatBool(page.Crawled), "Page"})
+		for i, item := range page.Items {
+			id := fmt.Sprintf("item:%s-%d", strings.TrimPrefix(page.ID, "page:"), i)
+			items = append(items, []string{id, item.Name, "Item;" + item.Type})
+			mainEntities = append(mainEntities, []string{page.ID, id, "MAIN_ENTITY"})
+		}
+	}
+	entities := [][]string{{"id:ID", "name", "type", ":LABEL"}}
+	for _, entity := range graph.entities {
+		label := "Entity"
+		if schemaType := entitySchemaTypes[entity.Type]; schemaType != "" {
+			label += ";" + schemaType
+		}
+		entities = append(entities, []string{entity.ID, entity.Name, entity.Type, label})
+	}
+
+	files := []struct {
+		name string
+		rows [][]string
+	}{
+		{"pages.csv", pages},
+		{"entities.csv", entities},
+		{"items.csv", items},
+		{"links.csv", edgeRows(graph.links, "LINKS_TO")},
+		{"mentions.csv", edgeRows(graph.mentions, "MENTIONS")},
+		{"co_occurrences.csv", edgeRows(graph.coOccurrences, "CO_OCCURS_WITH")},
+		{"main_entities.csv", mainEntities},
+	}
+	for _, file := range files {
+		writer, err := archive.Create(file.name)
+		if err != nil {
+			return err
+		}
+		if err := csv.NewWriter(writer).WriteAll(file.rows); err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+// edgeRows returns the CSV rows of relationships of a type, with their counts
+func edgeRows(edges []graphEdge, relationshipType string) [][]string {
+	rows := [][]string{{":START_ID", ":END_ID", "count:int", ":TYPE"}}
+	for _, edge := range edges {
+		rows = append(rows, []string{edge.Source, edge.Target, strconv.Itoa(edge.Count), relationshipType})
+	}
+	return rows
+}
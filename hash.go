@@ -0,0 +1,35 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
+)
+
+// contentHash returns a stable fingerprint of a page's extracted content. Sinks use
+// it to upsert idempotently: re-crawling an unchanged page yields the same hash, so
+// rows and messages aren't duplicated or rewritten.
+func contentHash(data *CrawledData) string {
+	hash := sha256.New()
+
+	// Trailing whitespace differences aren't content changes
+	lines := strings.Split(strings.TrimSpace(data.Markdown), "\n")
+	for _, line := range lines {
+		hash.Write([]byte(strings.TrimRight(line, " \t\r")))
+		hash.Write([]byte{'\n'})
+	}
+
+	keys := make([]string, 0, len(data.Metadata))
+	for key := range data.Metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		hash.Write([]byte{0})
+		hash.Write([]byte(key))
+		hash.Write([]byte{0})
+		hash.Write([]byte(data.Metadata[key]))
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// HostStats aggregates the fetches of one host during a crawl
+type HostStats struct {
+	Host         string  `json:"host"`
+	Pages        int     `json:"pages"`  // Successful fetches
+	Errors       int     `json:"errors"` // Failed fetches
+	Bytes        int64   `json:"bytes"`  // Of the fetched bodies
+	ErrorRate    float64 `json:"error_rate"`
+	AvgLatencyMs float64 `json:"avg_latency_ms"`
+	latency      time.Duration
+}
+
+// FailedPage is a page whose fetch or extraction failed
+type FailedPage struct {
+	URL    string `json:"url"`
+	Kind   string `json:"kind"`             // Why it failed, see failureKind
+	Status int    `json:"status,omitempty"` // HTTP status, when the server answered with an error
+	Error  string `json:"error"`
+}
+
+// hostMetrics accumulates HostStats of every crawl since the server started,
+// served on /metrics
+var hostMetrics = struct {
+	sync.Mutex
+	hosts map[string]*HostStats
+}{hosts: make(map[string]*HostStats)}
+
+// recordFetch counts a fetch of a page towards its host's statistics, in the
+// crawl's report and the server's metrics
+func (c *Crawler) recordFetch(pageURL string, page *fetchedPage, latency time.Duration, err error) {
+	parsedURL, parseErr := url.Parse(pageURL)
+	if parseErr != nil || parsedURL.Host == "" {
+		return
+	}
+	var bytes int64
+	if page != nil {
+		bytes = int64(len(page.HTML) + len(page.Body))
+	}
+	c.HostStatsMutex.Lock()
+	addFetch(c.HostStats, parsedURL.Host, bytes, latency, err)
+	c.HostStatsMutex.Unlock()
+	if err != nil {
+		c.recordFailure(pageURL, err)
+	}
+	hostMetrics.Lock()
+	addFetch(hostMetrics.hosts, parsedURL.Host, bytes, latency, err)
+	hostMetrics.Unlock()
+}
+
+// recordFailure lists a page that failed with err in the crawl's report
+func (c *Crawler) recordFailure(pageURL string, err error) {
+	c.FailedMutex.Lock()
+	c.Failed = append(c.Failed, newFailedPage(pageURL, err))
+	c.FailedMutex.Unlock()
+}
+
+// FailedPages returns the pages whose fetch failed, ordered by URL
+func (c *Crawler) FailedPages() []FailedPage {
+	c.FailedMutex.Lock()
+	defer c.FailedMutex.Unlock()
+	failed := append([]FailedPage(nil), c.Failed...)
+	sort.Slice(failed, func(i, j int) bool { return failed[i].URL < failed[j].URL })
+	return failed
+}
+
+// addFetch adds a fetch to the statistics of a host
+func addFetch(hosts map[string]*HostStats, host string, bytes int64, latency time.Duration, err error) {
+	stats, ok := hosts[host]
+	if !ok {
+		stats = &HostStats{Host: host}
+		hosts[host] = stats
+	}
+	if err != nil {
+		stats.Errors++
+	} else {
+		stats.Pages++
+		stats.Bytes += bytes
+	}
+	stats.latency += latency
+}
+
+// HostReport returns the statistics of every host fetched from, ordered by host
+func (c *Crawler) HostReport() []HostStats {
+	c.HostStatsMutex.Lock()
+	defer c.HostStatsMutex.Unlock()
+	return summarizeHosts(c.HostStats)
+}
+
+// summarizeHosts computes the rates and averages of host statistics
+func summarizeHosts(hosts map[string]*HostStats) []HostStats {
+	report := make([]HostStats, 0, len(hosts))
+	for _, stats := range hosts {
+		summary := *stats
+		if fetches := summary.Pages + summary.Errors; fetches > 0 {
+			summary.ErrorRate = float64(int(float64(summary.Errors)/float64(fetches)*1000+0.5)) / 1000
+			summary.AvgLatencyMs = float64((summary.latency / time.Duration(fetches)).Microseconds()) / 1000
+		}
+		report = append(report, summary)
+	}
+	sort.Slice(report, func(i, j int) bool { return report[i].Host < report[j].Host })
+	return report
+}
+
+// registerMetricsRoute serves the per-host fetch counters and the pipeline gauges
+// in the Prometheus text exposition format
+func registerMetricsRoute(app *fiber.App) {
+	app.Get("/metrics", func(c *fiber.Ctx) error {
+		hostMetrics.Lock()
+		hosts := make([]*HostStats, 0, len(hostMetrics.hosts))
+		for _, stats := range hostMetrics.hosts {
+			copied := *stats
+			hosts = append(hosts, &copied)
+		}
+		hostMetrics.Unlock()
+		sort.Slice(hosts, func(i, j int) bool { return hosts[i].Host < hosts[j].Host })
+
+		var out strings.Builder
+		metric := func(name, kind, help string, samples map[string]func(*HostStats) string) {
+			fmt.Fprintf(&out, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+			for _, stats := range hosts {
+				for _, suffix := range []string{"", "_sum", "_count"} {
+					if value, ok := samples[suffix]; ok {
+						fmt.Fprintf(&out, "%s%s{host=%q} %s\n", name, suffix, stats.Host, value(stats))
+					}
+				}
+			}
+		}
+		metric("lexicrawler_host_pages_total", "counter", "Pages fetched successfully per host.",
+			map[string]func(*HostStats) string{"": func(s *HostStats) string { return fmt.Sprint(s.Pages) }})
+		metric("lexicrawler_host_errors_total", "counter", "Failed fetches per host.",
+			map[string]func(*HostStats) string{"": func(s *HostStats) string { return fmt.Sprint(s.Errors) }})
+		metric("lexicrawler_host_bytes_total", "counter", "Bytes of fetched bodies per host.",
+			map[string]func(*HostStats) string{"": func(s *HostStats) string { return fmt.Sprint(s.Bytes) }})
+		metric("lexicrawler_host_fetch_seconds", "summary", "Fetch latency per host.", map[string]func(*HostStats) string{
+			"_sum":   func(s *HostStats) string { return fmt.Sprint(s.latency.Seconds()) },
+			"_count": func(s *HostStats) string { return fmt.Sprint(s.Pages + s.Errors) },
+		})
+		writePipelineMetrics(&out)
+		writeBandwidthMetrics(&out)
+
+		c.Set("Content-Type", "text/plain; version=0.0.4")
+		return c.SendString(out.String())
+	})
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// HreflangAlternate is a localized version of a page, declared with
+// <link rel="alternate" hreflang="...">
+type HreflangAlternate struct {
+	Locale string `json:"locale"` // As declared, e.g. "de-AT" or "x-default"
+	URL    string `json:"url"`
+}
+
+// DocumentFamily groups the crawled locales of one document
+type DocumentFamily struct {
+	ID      string            `json:"id"`      // The x-default URL, or the first URL of the family
+	Locales map[string]string `json:"locales"` // Locale to crawled URL
+}
+
+// extractHreflang records the alternates of a page as StructuredData["hreflang"]
+// and marks the page's family and locale in its metadata. Every page of a family
+// declares the same alternates, so they all arrive at the same family ID.
+func extractHreflang(data *CrawledData, doc *goquery.Selection) {
+	var alternates []HreflangAlternate
+	seen := make(map[string]bool)
+	doc.Find("link[rel~='alternate'][hreflang][href]").Each(func(_ int, s *goquery.Selection) {
+		locale := strings.TrimSpace(s.AttrOr("hreflang", ""))
+		href := resolveURL(data.URL, strings.TrimSpace(s.AttrOr("href", "")))
+		if locale == "" || href == "" || seen[locale] {
+			return
+		}
+		seen[locale] = true
+		alternates = append(alternates, HreflangAlternate{Locale: locale, URL: href})
+	})
+	if len(alternates) == 0 {
+		return
+	}
+	data.StructuredData["hreflang"] = alternates
+
+	family := ""
+	self := firstNonEmpty(data.Metadata["canonical_url"], data.URL)
+	for _, alternate := range alternates {
+		switch {
+		case strings.EqualFold(alternate.Locale, "x-default"):
+			family = alternate.URL
+		case alternate.URL == self || alternate.URL == data.URL:
+			data.Metadata["locale"] = alternate.Locale
+		}
+	}
+	if family == "" {
+		family = alternates[0].URL
+		for _, alternate := range alternates {
+			family = min(family, alternate.URL)
+		}
+	}
+	data.Metadata["family"] = family
+}
+
+// visitAlternates queues the hreflang alternates of a page; those on hosts outside
+// the allowed domains are skipped like any other link
+func visitAlternates(visit func(string) error, data *CrawledData) {
+	alternates, _ := data.StructuredData["hreflang"].([]HreflangAlternate)
+	for _, alternate := range alternates {
+		visit(alternate.URL)
+	}
+}
+
+// buildFamilyReport groups the crawled pages by family, for crawls of pages with
+// hreflang alternates
+func buildFamilyReport(crawledDataMap map[string]*CrawledData) []DocumentFamily {
+	families := make(map[string]*DocumentFamily)
+	for pageURL, data := range crawledDataMap {
+		id := data.Metadata["family"]
+		if id == "" {
+			continue
+		}
+		family, ok := families[id]
+		if !ok {
+			family = &DocumentFamily{ID: id, Locales: make(map[string]string)}
+			families[id] = family
+		}
+		family.Locales[firstNonEmpty(data.Metadata["locale"], data.Metadata["language"], pageURL)] = pageURL
+	}
+	report := make([]DocumentFamily, 0, len(families))
+	for _, family := range families {
+		report = append(report, *family)
+	}
+	sort.Slice(report, func(i, j int) bool { return report[i].ID < report[j].ID })
+	return report
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Limits of image data extraction, which sends larger requests than alt text
+const (
+	defaultImageDataMaxImages = 20 // Images read per crawl
+	defaultImageDataPerMinute = 30 // Requests to the vision model per minute
+	maxImageDataTokens        = 4000
+)
+
+// imageDataHints are words of an image's URL or alt text suggesting it shows data
+var imageDataHints = map[string]bool{
+	"chart": true, "charts": true, "graph": true, "graphs": true, "plot": true, "plots": true,
+	"diagram": true, "table": true, "tables": true, "infographic": true, "statistics": true,
+	"stats": true, "figure": true, "fig": true, "trend": true, "trends": true,
+}
+
+// ImageData is the data of a chart or table published as an image, read by the
+// vision model
+type ImageData struct {
+	URL     string     `json:"url"`
+	Kind    string     `json:"kind"` // "chart" or "table"
+	Title   string     `json:"title,omitempty"`
+	Columns []string   `json:"columns"`
+	Rows    [][]string `json:"rows"`              // Cells as shown, including units
+	Summary string     `json:"summary,omitempty"` // What the data shows, e.g. the trend of a chart
+}
+
+// imageDataMaxImages returns the crawl's limit of images read for data
+func (config CrawlerConfig) imageDataMaxImages() int {
+	if config.ImageDataMaxImages == 0 {
+		return defaultImageDataMaxImages
+	}
+	return config.ImageDataMaxImages
+}
+
+// imageDataPerMinute returns the crawl's rate of requests for image data
+func (config CrawlerConfig) imageDataPerMinute() int {
+	if config.ImageDataPerMinute == 0 {
+		return defaultImageDataPerMinute
+	}
+	return config.ImageDataPerMinute
+}
+
+// looksLikeData reports whether the URL or alt text of an image names a chart,
+// table or the like
+func looksLikeData(item MediaItem) bool {
+	text := item.Alt
+	if parsedURL, err := url.Parse(item.URL); err == nil {
+		text += " " + parsedURL.Path
+	}
+	for _, word := range retrievalTokens(text) {
+		if imageDataHints[word] {
+			return true
+		}
+	}
+	return false
+}
+
+// extractImageData reads the data of the images of a page that look like charts or
+// tables into the "image_data" structured data. Images the model finds to be
+// neither are left out.
+func (c *Crawler) extractImageData(data *CrawledData) {
+	model := visionModelFromEnv()
+	media, _ := data.StructuredData["media"].([]MediaItem)
+	if model == nil {
+		return
+	}
+	var extracted []ImageData
+	seen := make(map[string]bool)
+	for _, item := range media {
+		if item.Type != "image" || seen[item.URL] || !looksLikeData(item) {
+			continue
+		}
+		seen[item.URL] = true
+		if imageData := c.imageData(model, item.URL); imageData != nil {
+			extracted = append(extracted, *imageData)
+		}
+	}
+	if len(extracted) > 0 {
+		data.StructuredData["image_data"] = extracted
+	}
+}
+
+// imageData returns the data of an image, read by the model unless it was before or
+// the crawl's budget is used up; nil if the image shows no chart or table
+func (c *Crawler) imageData(model *visionModel, imageURL string) *ImageData {
+	if result, seen := c.imageDataRead.result(imageURL); seen {
+		return result.(*ImageData)
+	}
+	if !c.imageDataRead.reserve(c.Config.imageDataMaxImages(), c.Config.imageDataPerMinute()) {
+		return nil
+	}
+
+	var imageData *ImageData
+	image, err := downloadImage(imageURL)
+	if err == nil {
+		imageData, err = model.readData(image.DataURI)
+	}
+	if err != nil {
+		fmt.Printf("Image data extraction failed for %s: %v\n", imageURL, err)
+	}
+	if imageData != nil {
+		imageData.URL = imageURL
+	}
+	c.imageDataRead.store(imageURL, imageData)
+	return imageData
+}
+
+// readData asks the model for the data of a chart or table shown by an image given
+// as a data URI; nil if it shows neither
+func (m *visionModel) readData(dataURI string) (*ImageData, error) {
+	prompt := "If this image is a chart or a table, transcribe its data. Reply with a JSON object only: " +
+		`{"kind": "chart" or "table" or "other", "title": "<title shown, if any>", "columns": ["<column header>", ...], ` +
+		`"rows": [["<cell>", ...], ...], "summary": "<one sentence on what the data shows>"}. ` +
+		"For charts, make a row per data point with its label and values, reading values off the axes as closely as possible. " +
+		"Keep units in the cells. For any other image reply {\"kind\": \"other\"}."
+	reply, err := m.ask(prompt, dataURI, maxImageDataTokens)
+	if err != nil {
+		return nil, err
+	}
+	reply = strings.TrimSpace(reply)
+	reply = strings.TrimPrefix(strings.TrimPrefix(reply, "```json"), "```") // Models tend to fence JSON
+	reply = strings.TrimSuffix(strings.TrimSpace(reply), "```")
+	var imageData ImageData
+	if err := json.Unmarshal([]byte(reply), &imageData); err != nil {
+		return nil, fmt.Errorf("invalid image data reply: %w", err)
+	}
+	if (imageData.Kind != "chart" && imageData.Kind != "table") || len(imageData.Rows) == 0 {
+		return nil, nil
+	}
+	return &imageData, nil
+}
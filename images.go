@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// maxSiteImageBytes caps the size of a downloaded favicon or social image
+const maxSiteImageBytes = 1 << 20
+
+var siteImageClient = &http.Client{Timeout: 10 * time.Second}
+
+// ImageAsset is a downloaded image embedded in the result as a data URI
+type ImageAsset struct {
+	URL         string `json:"url"`
+	ContentType string `json:"content_type"`
+	Size        int    `json:"size"`
+	DataURI     string `json:"data_uri"`
+}
+
+// fetchSiteImages downloads the favicon and og:image for a page and stores them
+// in its structured data, so link-preview consumers get everything in one call
+func (c *Crawler) fetchSiteImages(pageURL string, metadata map[string]string, structuredData map[string]interface{}) {
+	faviconURL := metadata["favicon_url"]
+	if faviconURL == "" {
+		faviconURL = resolveURL(pageURL, "/favicon.ico") // Browsers fall back to the site root
+	}
+	if favicon, err := c.getSiteImage(faviconURL); err != nil {
+		fmt.Printf("Favicon fetch failed for %s: %v\n", pageURL, err)
+	} else {
+		structuredData["favicon"] = favicon
+	}
+
+	socialImageURL := metadata["og:image"]
+	if socialImageURL == "" {
+		socialImageURL = metadata["twitter:image"]
+	}
+	if socialImageURL == "" {
+		return
+	}
+	if socialImage, err := c.getSiteImage(resolveURL(pageURL, socialImageURL)); err != nil {
+		fmt.Printf("Social image fetch failed for %s: %v\n", pageURL, err)
+	} else {
+		structuredData["og_image"] = socialImage
+	}
+}
+
+// getSiteImage returns a downloaded image, reusing earlier downloads of the same URL
+// (favicons are usually shared by every page of a site)
+func (c *Crawler) getSiteImage(imageURL string) (*ImageAsset, error) {
+	c.ImageMutex.Lock()
+	cached, ok := c.ImageCache[imageURL]
+	c.ImageMutex.Unlock()
+	if ok {
+		return cached, nil
+	}
+
+	asset, err := downloadImage(imageURL)
+	if err != nil {
+		return nil, err
+	}
+	c.ImageMutex.Lock()
+	c.ImageCache[imageURL] = asset
+	c.ImageMutex.Unlock()
+	return asset, nil
+}
+
+// downloadImage fetches an image and encodes it as a data URI
+func downloadImage(imageURL string) (*ImageAsset, error) {
+	parsedURL, err := url.Parse(imageURL)
+	if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") {
+		return nil, fmt.Errorf("unsupported image URL %q", imageURL)
+	}
+
+	resp, err := siteImageClient.Get(imageURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d for %s", resp.StatusCode, imageURL)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxSiteImageBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxSiteImageBytes {
+		return nil, fmt.Errorf("image %s exceeds %d bytes", imageURL, maxSiteImageBytes)
+	}
+
+	contentType := strings.TrimSpace(strings.Split(resp.Header.Get("Content-Type"), ";")[0])
+	if !strings.HasPrefix(contentType, "image/") {
+		contentType = http.DetectContentType(body)
+	}
+	if !strings.HasPrefix(contentType, "image/") {
+		return nil, fmt.Errorf("%s is not an image (%s)", imageURL, contentType)
+	}
+
+	return &ImageAsset{
+		URL:         imageURL,
+		ContentType: contentType,
+		Size:        len(body),
+		DataURI:     "data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(body),
+	}, nil
+}
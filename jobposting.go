@@ -0,0 +1,93 @@
+package main
+
+import "strings"
+
+// JobPosting holds the normalized fields of a schema.org JobPosting
+type JobPosting struct {
+	Title           string     `json:"title"`
+	Description     string     `json:"description,omitempty"` // Plain text
+	Organization    string     `json:"organization,omitempty"`
+	OrganizationURL string     `json:"organization_url,omitempty"`
+	Locations       []Location `json:"locations,omitempty"`
+	Remote          bool       `json:"remote"`                     // jobLocationType TELECOMMUTE
+	EmploymentTypes []string   `json:"employment_types,omitempty"` // full_time, part_time, contractor, ...
+	DatePosted      string     `json:"date_posted,omitempty"`
+	ValidThrough    string     `json:"valid_through,omitempty"`
+	Salary          *Salary    `json:"salary,omitempty"`
+	URL             string     `json:"url,omitempty"`
+}
+
+// Salary is a pay range; Min and Max are equal for a single amount
+type Salary struct {
+	Min      *float64 `json:"min,omitempty"`
+	Max      *float64 `json:"max,omitempty"`
+	Currency string   `json:"currency,omitempty"`
+	Unit     string   `json:"unit,omitempty"` // hour, day, week, month or year
+}
+
+// extractJobPosting recognizes job pages by a schema.org JobPosting
+func extractJobPosting(page *extractorPage) (interface{}, bool) {
+	item := page.first("JobPosting")
+	if item == nil {
+		return nil, false
+	}
+	job := JobPosting{
+		Title:        item.str("title"),
+		Description:  schemaPlainText(item["description"]),
+		DatePosted:   item.str("datePosted"),
+		ValidThrough: item.str("validThrough"),
+		Locations:    schemaLocations(item, "jobLocation", page.URL),
+		Remote:       strings.EqualFold(item.str("jobLocationType"), "TELECOMMUTE"),
+		URL:          page.URL,
+	}
+	if job.Title == "" {
+		job.Title = item.str("name")
+	}
+	if organization := item.object("hiringOrganization"); organization != nil {
+		job.Organization = organization.str("name")
+		if organizationURL := organization.str("sameAs"); organizationURL != "" {
+			job.OrganizationURL = organizationURL
+		}
+		if organizationURL := organization.str("url"); organizationURL != "" {
+			job.OrganizationURL = resolveURL(page.URL, organizationURL)
+		}
+	} else {
+		job.Organization = item.str("hiringOrganization")
+	}
+	for _, employmentType := range item.strs("employmentType") {
+		for _, name := range strings.Split(employmentType, ",") { // Some sites list them in one string
+			job.EmploymentTypes = appendUnique(job.EmploymentTypes, strings.ReplaceAll(strings.ToLower(strings.TrimSpace(name)), "-", "_"))
+		}
+	}
+	if jobURL := item.str("url"); jobURL != "" {
+		job.URL = resolveURL(page.URL, jobURL)
+	}
+	job.Salary = schemaSalary(item.object("baseSalary"))
+	if job.Salary == nil {
+		job.Salary = schemaSalary(item.object("estimatedSalary"))
+	}
+	if job.Title == "" {
+		return nil, false
+	}
+	return job, true
+}
+
+// schemaSalary normalizes a MonetaryAmount whose value is a number or a
+// QuantitativeValue with a range and a unit
+func schemaSalary(amount schemaObject) *Salary {
+	if amount == nil {
+		return nil
+	}
+	salary := &Salary{Currency: strings.ToUpper(amount.str("currency"))}
+	value := amount.object("value")
+	if value == nil {
+		value = amount // Also covers a QuantitativeValue used directly
+	}
+	salary.Min = priceOf(value["minValue"], value["value"])
+	salary.Max = priceOf(value["maxValue"], value["value"])
+	if salary.Min == nil && salary.Max == nil {
+		return nil
+	}
+	salary.Unit = strings.ToLower(firstNonEmpty(value.str("unitText"), amount.str("unitText")))
+	return salary
+}
@@ -0,0 +1,1073 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image/png"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	fiberlog "github.com/gofiber/fiber/v2/log"
+)
+
+// JobStatus is the lifecycle state of a crawl job
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobCompleted JobStatus = "completed"
+	JobFailed    JobStatus = "failed"
+	JobPaused    JobStatus = "paused" // Preempted by a higher-priority job, waiting to resume
+)
+
+var (
+	errJobNotFound = errors.New("job not found")
+	errJobRunning  = errors.New("job is still running")
+	errJobNotDone  = errors.New("job has not completed")
+)
+
+// Job is an asynchronous crawl owned by a tenant
+type Job struct {
+	ID           string        `json:"id"`
+	TenantID     string        `json:"tenant_id"`
+	Request      CrawlRequest  `json:"request"`
+	Status       JobStatus     `json:"status"`
+	Error        string        `json:"error,omitempty"`
+	CreatedAt    time.Time     `json:"created_at"`
+	StartedAt    *time.Time    `json:"started_at,omitempty"`
+	FinishedAt   *time.Time    `json:"finished_at,omitempty"`
+	StorageBytes int64         `json:"storage_bytes"`
+	Usage        ResourceUsage `json:"usage"` // Of every run so far, including the one in progress
+	Report       *CrawlReport  `json:"report,omitempty"`
+	Preemptions  int           `json:"preemptions"`
+	PagesVisited int           `json:"pages_visited"` // Progress of a running job, page count once finished
+	Alerts       *AlertReport  `json:"alerts,omitempty"`
+	RetryOf      string        `json:"retry_of,omitempty"` // Job whose failed pages this one fetches again, see RetryFailed
+
+	config     CrawlerConfig
+	tenant     *Tenant
+	results    map[string]*CrawledData
+	updatedAt  time.Time               // When results last changed, the Last-Modified of its pages
+	crawler    *Crawler                // Set while running
+	checkpoint map[string]*CrawledData // Pages completed before the job was paused or interrupted
+	frontier   frontierStore           // Queue of the job until it finishes, kept across pauses
+	pausing    bool
+}
+
+// JobChunk is a chunk in the listing of all chunks of a job, with its page
+type JobChunk struct {
+	PageID string `json:"page_id"`
+	URL    string `json:"url"`
+	Chunk
+}
+
+// JobPage is the summary of a crawled page in a job's page listing
+type JobPage struct {
+	ID          string   `json:"id"`
+	URL         string   `json:"url"`
+	Title       string   `json:"title"`
+	Topics      []string `json:"topics,omitempty"`
+	DocsVersion string   `json:"docs_version,omitempty"`
+}
+
+// JobManager runs crawl jobs in the background and keeps their results in memory,
+// isolated per tenant
+type JobManager struct {
+	jobs       map[string]*Job
+	pending    []*Job // Queued and paused jobs
+	running    map[*Job]bool
+	maxRunning int            // Global limit on concurrently running jobs, 0 for no limit
+	corpora    *CorpusManager // Completed jobs are added to the corpus they name
+	mutex      sync.Mutex
+}
+
+// NewJobManager creates an empty JobManager running at most maxRunning jobs at once and
+// adding completed jobs to corpora
+func NewJobManager(maxRunning int, corpora *CorpusManager) *JobManager {
+	return &JobManager{jobs: make(map[string]*Job), running: make(map[*Job]bool), maxRunning: maxRunning, corpora: corpora}
+}
+
+// Submit queues a crawl for a tenant, enforcing its page and storage quotas
+func (m *JobManager) Submit(tenant *Tenant, request CrawlRequest) (Job, error) {
+	config, err := request.toConfig()
+	if err != nil {
+		return Job{}, err
+	}
+	if err := tenant.checkSubmitQuota(); err != nil {
+		return Job{}, err
+	}
+
+	job := &Job{
+		ID:        newJobID(),
+		TenantID:  tenant.ID,
+		Request:   request,
+		Status:    JobQueued,
+		CreatedAt: time.Now(),
+		config:    config,
+		tenant:    tenant,
+	}
+	if job.frontier, err = newJobFrontier(job); err != nil {
+		return Job{}, fmt.Errorf("creating the job's frontier: %w", err)
+	}
+	tenant.recordJobSubmitted()
+
+	m.mutex.Lock()
+	m.jobs[job.ID] = job
+	m.pending = append(m.pending, job)
+	snapshot := *job
+	m.mutex.Unlock()
+
+	m.schedule()
+	return snapshot, nil
+}
+
+// schedule starts queued jobs in priority order while global and tenant slots are
+// free. When all global slots are taken, a waiting job may preempt a running
+// preemptible job of lower priority, which is paused and re-queued.
+func (m *JobManager) schedule() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	sort.SliceStable(m.pending, func(i, j int) bool {
+		if m.pending[i].Request.Priority != m.pending[j].Request.Priority {
+			return m.pending[i].Request.Priority > m.pending[j].Request.Priority
+		}
+		return m.pending[i].CreatedAt.Before(m.pending[j].CreatedAt)
+	})
+
+	remaining := m.pending[:0]
+	for _, job := range m.pending {
+		if m.maxRunning > 0 && len(m.running) >= m.maxRunning {
+			m.preemptFor(job)
+			remaining = append(remaining, job)
+			continue
+		}
+		if !job.tenant.tryStartJob() {
+			remaining = append(remaining, job)
+			continue
+		}
+		now := time.Now()
+		job.Status = JobRunning
+		if job.StartedAt == nil {
+			job.StartedAt = &now
+		}
+		job.crawler = m.newJobCrawler(job)
+		m.running[job] = true
+		go m.run(job)
+	}
+	m.pending = remaining
+}
+
+// preemptFor pauses the lowest-priority preemptible running job if it ranks below job
+func (m *JobManager) preemptFor(job *Job) {
+	var victim *Job
+	for running := range m.running {
+		if running.pausing {
+			return // A slot is already being freed
+		}
+		if running.Request.Preemptible && running.Request.Priority < job.Request.Priority &&
+			(victim == nil || running.Request.Priority < victim.Request.Priority) {
+			victim = running
+		}
+	}
+	if victim != nil {
+		fiberlog.Infof("Job %s (priority %d) preempts job %s (priority %d)", job.ID, job.Request.Priority, victim.ID, victim.Request.Priority)
+		victim.pausing = true
+		victim.crawler.Stop()
+	}
+}
+
+// newJobFrontier creates the frontier of a new job, on disk when jobs are kept across
+// restarts, see frontierDirFromEnv
+func newJobFrontier(job *Job) (frontierStore, error) {
+	if dir := frontierDirFromEnv(); dir != "" {
+		return newBoltFrontier(frontierPath(dir, job.ID), job)
+	}
+	return job.config.newMemoryFrontier()
+}
+
+// newJobCrawler prepares the crawler of a job, continuing from its frontier if it was
+// paused or interrupted
+func (m *JobManager) newJobCrawler(job *Job) *Crawler {
+	config := job.config
+	if remaining := job.tenant.remainingPages(); remaining > 0 && (config.MaxPages == 0 || remaining < config.MaxPages) {
+		config.MaxPages = remaining // Never crawl beyond the tenant's page quota
+	}
+	crawler := NewCrawler(config)
+	crawler.jobID, crawler.tenantID = job.ID, job.TenantID
+	crawler.queue = job.frontier
+	crawler.pagesVisited = job.frontier.completed() // Pages of earlier runs count against MaxPages
+	return crawler
+}
+
+// run crawls a job and accounts its results to the tenant
+func (m *JobManager) run(job *Job) {
+	crawler := job.crawler // Cleared once the crawl ends
+	var results map[string]*CrawledData
+	err := job.tenant.checkSubmitQuota() // Quotas may have been used up while the job was queued
+	if err == nil {
+		results, err = job.crawler.Crawl()
+	}
+
+	m.mutex.Lock()
+	delete(m.running, job)
+	if err == nil && job.pausing {
+		job.pausing = false
+		job.Status = JobPaused
+		job.Preemptions++
+		job.checkpoint = mergeResults(job.checkpoint, results)
+		job.crawler = nil
+		job.Usage = job.Usage.add(crawler.Usage())
+		m.pending = append(m.pending, job)
+		m.mutex.Unlock()
+		job.tenant.recordJobPaused()
+		job.tenant.recordUsage(crawler.Usage())
+		m.schedule()
+		return
+	}
+	checkpoint, frontier := job.checkpoint, job.frontier
+	job.crawler = nil
+	job.checkpoint = nil
+	job.frontier = nil
+	m.mutex.Unlock()
+	if closeErr := frontier.close(); closeErr != nil {
+		fiberlog.Errorf("Releasing the frontier of job %s failed: %v", job.ID, closeErr)
+	}
+	if err == nil {
+		results = mergeResults(checkpoint, results)
+	}
+
+	var storageBytes int64
+	for _, data := range results {
+		storageBytes += crawledDataSize(data)
+	}
+	job.tenant.recordJobFinished(len(results), storageBytes)
+	job.tenant.recordUsage(crawler.Usage())
+
+	m.mutex.Lock()
+	now := time.Now()
+	job.FinishedAt = &now
+	job.Usage = job.Usage.add(crawler.Usage())
+	if err != nil {
+		fiberlog.Errorf("Job %s failed: %v", job.ID, err)
+		job.Status = JobFailed
+		job.Error = err.Error()
+	} else {
+		job.Status = JobCompleted
+		job.results = results
+		job.updatedAt = now
+		job.StorageBytes = storageBytes
+		job.Usage.StorageBytes = storageBytes
+		job.Report = crawler.Report(job.config.StartURL, results)
+	}
+	m.mutex.Unlock()
+
+	if err == nil {
+		m.evaluateAlerts(job)
+		m.addJobToCorpus(job)
+	}
+	m.notifyFinished(job)
+	m.schedule()
+}
+
+// mergeResults adds the pages of earlier runs of a job to the results of its last run
+func mergeResults(earlier map[string]*CrawledData, results map[string]*CrawledData) map[string]*CrawledData {
+	if results == nil {
+		results = make(map[string]*CrawledData)
+	}
+	for pageURL, data := range earlier {
+		if _, ok := results[pageURL]; !ok {
+			results[pageURL] = data
+		}
+	}
+	return results
+}
+
+// Restore queues the jobs that hadn't finished when the server stopped again, from
+// their frontiers in LEXICRAWLER_DATA_DIR. Their crawls continue where they were
+// interrupted, with the pages completed before.
+func (m *JobManager) Restore(tenants *TenantRegistry) error {
+	dir := frontierDirFromEnv()
+	if dir == "" {
+		return nil
+	}
+	paths, err := frontierFiles(dir)
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		frontier, job, err := openBoltFrontier(path)
+		if err != nil {
+			fiberlog.Errorf("Restoring the job of %s failed: %v", path, err)
+			continue
+		}
+		if err := m.restore(job, frontier, tenants); err != nil {
+			fiberlog.Errorf("Restoring job %s failed: %v", job.ID, err)
+			frontier.db.Close() // The file stays for another attempt
+			continue
+		}
+		fiberlog.Infof("Restored job %s with %d pages done and %d queued", job.ID, frontier.completed(), frontier.queued())
+	}
+	m.schedule()
+	return nil
+}
+
+// restore queues a job read back from its frontier, paused if it had started
+func (m *JobManager) restore(job *Job, frontier *boltFrontier, tenants *TenantRegistry) error {
+	tenant, ok := tenants.tenants[job.TenantID]
+	if !ok {
+		return fmt.Errorf("tenant %q no longer exists", job.TenantID)
+	}
+	config, err := job.Request.toConfig()
+	if err != nil {
+		return err
+	}
+	pages, err := frontier.pages()
+	if err != nil {
+		return err
+	}
+	job.config, job.tenant, job.frontier = config, tenant, frontier
+	job.Status = JobQueued
+	if frontier.completed() > 0 {
+		job.Status = JobPaused
+		job.checkpoint = pages
+	}
+	tenant.recordJobSubmitted()
+
+	m.mutex.Lock()
+	m.jobs[job.ID] = job
+	m.pending = append(m.pending, job)
+	m.mutex.Unlock()
+	return nil
+}
+
+// lookup returns a tenant's job; jobs of other tenants are reported as not found
+func (m *JobManager) lookup(tenant *Tenant, id string) (*Job, error) {
+	job, ok := m.jobs[id]
+	if !ok || job.TenantID != tenant.ID {
+		return nil, errJobNotFound
+	}
+	return job, nil
+}
+
+// Get returns a snapshot of a tenant's job
+func (m *JobManager) Get(tenant *Tenant, id string) (Job, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	job, err := m.lookup(tenant, id)
+	if err != nil {
+		return Job{}, err
+	}
+	return job.snapshot(), nil
+}
+
+// snapshot copies a job with its current progress; the manager's mutex must be held
+func (j *Job) snapshot() Job {
+	snapshot := *j
+	switch {
+	case j.crawler != nil:
+		snapshot.PagesVisited = j.crawler.VisitedCount()
+		snapshot.Usage = j.Usage.add(j.crawler.Usage())
+	case j.checkpoint != nil:
+		snapshot.PagesVisited = len(j.checkpoint)
+	default:
+		snapshot.PagesVisited = len(j.results)
+	}
+	return snapshot
+}
+
+// List returns snapshots of a tenant's jobs, newest first
+func (m *JobManager) List(tenant *Tenant) []Job {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	jobs := []Job{}
+	for _, job := range m.jobs {
+		if job.TenantID == tenant.ID {
+			snapshot := job.snapshot()
+			snapshot.Report = nil // Reports can be large, they are returned by Get
+			jobs = append(jobs, snapshot)
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].CreatedAt.After(jobs[j].CreatedAt) })
+	return jobs
+}
+
+// Pages lists the crawled pages of a tenant's job ordered by URL
+func (m *JobManager) Pages(tenant *Tenant, id string) ([]JobPage, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	job, err := m.lookup(tenant, id)
+	if err != nil {
+		return nil, err
+	}
+	pages := make([]JobPage, 0, len(job.results))
+	for pageURL, data := range job.results {
+		pages = append(pages, JobPage{ID: pageID(pageURL), URL: pageURL, Title: data.Metadata["title"], Topics: pageTopicNames(data), DocsVersion: data.Metadata["docs_version"]})
+	}
+	sort.Slice(pages, func(i, j int) bool { return pages[i].URL < pages[j].URL })
+	return pages, nil
+}
+
+// Page returns a single crawled page of a tenant's job by page ID, with the time the
+// job's results last changed
+func (m *JobManager) Page(tenant *Tenant, id string, page string) (*CrawledData, time.Time, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	job, err := m.lookup(tenant, id)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	for pageURL, data := range job.results {
+		if pageID(pageURL) == page {
+			return data, job.updatedAt, nil
+		}
+	}
+	return nil, time.Time{}, fmt.Errorf("page %s not found", page)
+}
+
+// PageLinker links the pages of a tenant's job to target(pageURL), see pageLinker
+func (m *JobManager) PageLinker(tenant *Tenant, id string, target func(pageURL string) string) (*pageLinker, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	job, err := m.lookup(tenant, id)
+	if err != nil {
+		return nil, err
+	}
+	return newPageLinker(job.results, target), nil
+}
+
+// RenderPage renders a page of a tenant's job with one of the job's output
+// templates, returning the output and the template's extension
+func (m *JobManager) RenderPage(tenant *Tenant, id string, page string, name string) (string, string, error) {
+	m.mutex.Lock()
+	job, err := m.lookup(tenant, id)
+	if err != nil {
+		m.mutex.Unlock()
+		return "", "", err
+	}
+	output, err := job.outputTemplate(name)
+	if err != nil {
+		m.mutex.Unlock()
+		return "", "", err
+	}
+	snapshot := *job
+	var pageURL string
+	var data *CrawledData
+	for resultURL, result := range job.results {
+		if pageID(resultURL) == page {
+			pageURL, data = resultURL, result
+		}
+	}
+	m.mutex.Unlock()
+	if data == nil {
+		return "", "", fmt.Errorf("page %s not found", page)
+	}
+	rendered, err := renderPageTemplate(output, snapshot, pageURL, data)
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %v", errTemplateFailed, err)
+	}
+	return rendered, strings.TrimPrefix(filepath.Ext(output.format()), "."), nil
+}
+
+// Delete removes a finished job and releases its storage
+func (m *JobManager) Delete(tenant *Tenant, id string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	job, err := m.lookup(tenant, id)
+	if err != nil {
+		return err
+	}
+	if job.Status == JobRunning {
+		return errJobRunning
+	}
+	delete(m.jobs, id)
+	for i, pendingJob := range m.pending {
+		if pendingJob == job {
+			m.pending = append(m.pending[:i], m.pending[i+1:]...)
+			break
+		}
+	}
+	if job.frontier != nil {
+		if err := job.frontier.close(); err != nil {
+			fiberlog.Errorf("Releasing the frontier of job %s failed: %v", job.ID, err)
+		}
+	}
+	tenant.releaseStorage(job.StorageBytes)
+	removeExports(job.ID)
+	removeSpilled(job.results)
+	removeSpilled(job.checkpoint)
+	return nil
+}
+
+// Results returns a snapshot of a tenant's completed job with its results
+func (m *JobManager) Results(tenant *Tenant, id string) (Job, map[string]*CrawledData, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	job, err := m.lookup(tenant, id)
+	if err != nil {
+		return Job{}, nil, err
+	}
+	if job.Status != JobCompleted {
+		return Job{}, nil, errJobNotDone
+	}
+	return *job, job.results, nil
+}
+
+// previousRun returns the job against, or else the latest earlier completed job of the
+// tenant for the same start URL (of jobs without one, such as corpus recrawls, for the
+// same corpus), which runs of a job are compared with; the manager's mutex must be held
+func (m *JobManager) previousRun(tenant *Tenant, job *Job, against string) (*Job, error) {
+	if against != "" {
+		return m.lookup(tenant, against)
+	}
+	var previous *Job
+	for _, candidate := range m.jobs {
+		if candidate.TenantID == tenant.ID && candidate != job && candidate.Status == JobCompleted &&
+			candidate.Request.URL == job.Request.URL && (job.Request.URL != "" || candidate.Request.Corpus == job.Request.Corpus) &&
+			candidate.CreatedAt.Before(job.CreatedAt) &&
+			(previous == nil || candidate.CreatedAt.After(previous.CreatedAt)) {
+			previous = candidate
+		}
+	}
+	if previous == nil {
+		return nil, fmt.Errorf("no earlier completed crawl of %s to compare with", firstNonEmpty(job.Request.URL, "corpus "+job.Request.Corpus))
+	}
+	return previous, nil
+}
+
+// Export writes (or reuses) the export of a completed job in the given format and returns its path
+func (m *JobManager) Export(tenant *Tenant, id string, format string) (string, error) {
+	snapshot, results, err := m.Results(tenant, id)
+	if err != nil {
+		return "", err
+	}
+	return writeExport(snapshot, results, format)
+}
+
+// maxRunningJobsFromEnv reads the global running-job limit from LEXICRAWLER_MAX_RUNNING_JOBS
+func maxRunningJobsFromEnv() int {
+	limit, err := strconv.Atoi(os.Getenv("LEXICRAWLER_MAX_RUNNING_JOBS"))
+	if err != nil || limit < 0 {
+		return 0
+	}
+	return limit
+}
+
+// newJobID returns a random hex job identifier
+func newJobID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// pageID returns the stable identifier of a page within a job
+func pageID(pageURL string) string {
+	sum := sha1.Sum([]byte(pageURL))
+	return hex.EncodeToString(sum[:6])
+}
+
+// crawledDataSize approximates the storage used by a page's results
+func crawledDataSize(data *CrawledData) int64 {
+	size := len(data.URL) + len(data.Markdown) + len(data.RawHTML) + len(data.rawHTMLZstd) + len(data.ScreenshotPath)
+	for key, value := range data.Metadata {
+		size += len(key) + len(value)
+	}
+	return int64(size)
+}
+
+// registerJobRoutes exposes the job and usage endpoints
+func registerJobRoutes(app *fiber.App, jobs *JobManager) {
+	app.Post("/jobs", func(c *fiber.Ctx) error {
+		var request CrawlRequest
+		if err := c.BodyParser(&request); err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString("Invalid request body")
+		}
+		tenant := tenantFromCtx(c)
+		if err := tenant.checkSubmitQuota(); err != nil {
+			return c.Status(fiber.StatusTooManyRequests).SendString(err.Error())
+		}
+		job, err := jobs.Submit(tenant, request)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+		}
+		return c.Status(fiber.StatusAccepted).JSON(job)
+	})
+
+	app.Get("/jobs", func(c *fiber.Ctx) error {
+		return c.JSON(jobs.List(tenantFromCtx(c)))
+	})
+
+	app.Get("/jobs/:id", func(c *fiber.Ctx) error {
+		job, err := jobs.Get(tenantFromCtx(c), c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusNotFound).SendString(err.Error())
+		}
+		return c.JSON(job)
+	})
+
+	app.Delete("/jobs/:id", func(c *fiber.Ctx) error {
+		err := jobs.Delete(tenantFromCtx(c), c.Params("id"))
+		switch {
+		case errors.Is(err, errJobRunning):
+			return c.Status(fiber.StatusConflict).SendString(err.Error())
+		case err != nil:
+			return c.Status(fiber.StatusNotFound).SendString(err.Error())
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	})
+
+	// Re-runs extraction over the stored HTML with new settings instead of recrawling
+	app.Post("/jobs/:id/reprocess", func(c *fiber.Ctx) error {
+		var request ReprocessRequest
+		if len(c.Body()) > 0 {
+			if err := c.BodyParser(&request); err != nil {
+				return c.Status(fiber.StatusBadRequest).SendString("Invalid request body")
+			}
+		}
+		if err := request.validate(); err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+		}
+		job, err := jobs.Reprocess(tenantFromCtx(c), c.Params("id"), request)
+		switch {
+		case errors.Is(err, errJobNotDone), errors.Is(err, errNoRawHTML):
+			return c.Status(fiber.StatusConflict).SendString(err.Error())
+		case errors.Is(err, errJobNotFound):
+			return c.Status(fiber.StatusNotFound).SendString(err.Error())
+		case err != nil:
+			fiberlog.Errorf("Reprocessing job %s failed: %v", c.Params("id"), err)
+			return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+		}
+		return c.JSON(job)
+	})
+
+	// Failed pages of the crawl so far, by kind; kind=dns,timeout, status=404 and
+	// host=example.com filter them
+	app.Get("/jobs/:id/errors", func(c *fiber.Ctx) error {
+		filter, err := parseFailureFilter(c.Query("kind"), c.Query("status"), c.Query("host"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+		}
+		errs, err := jobs.Errors(tenantFromCtx(c), c.Params("id"), filter)
+		if err != nil {
+			return c.Status(fiber.StatusNotFound).SendString(err.Error())
+		}
+		return c.JSON(errs)
+	})
+
+	// Fetches the failed pages of a completed job again in a new job, those selected
+	// by the filters of /errors
+	app.Post("/jobs/:id/retry-failed", func(c *fiber.Ctx) error {
+		filter, err := parseFailureFilter(c.Query("kind"), c.Query("status"), c.Query("host"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+		}
+		tenant := tenantFromCtx(c)
+		if err := tenant.checkSubmitQuota(); err != nil {
+			return c.Status(fiber.StatusTooManyRequests).SendString(err.Error())
+		}
+		job, err := jobs.RetryFailed(tenant, c.Params("id"), filter)
+		switch {
+		case errors.Is(err, errNoFailedPages):
+			return c.SendStatus(fiber.StatusNoContent)
+		case errors.Is(err, errJobNotDone):
+			return c.Status(fiber.StatusConflict).SendString(err.Error())
+		case errors.Is(err, errJobNotFound):
+			return c.Status(fiber.StatusNotFound).SendString(err.Error())
+		case err != nil:
+			return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+		}
+		return c.Status(fiber.StatusAccepted).JSON(job)
+	})
+
+	// topic=name lists the pages tagged with a topic only, docs_version=v the pages of
+	// a docs version only
+	app.Get("/jobs/:id/pages", func(c *fiber.Ctx) error {
+		pages, err := jobs.Pages(tenantFromCtx(c), c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusNotFound).SendString(err.Error())
+		}
+		topic, version := c.Query("topic"), c.Query("docs_version")
+		if topic != "" || version != "" {
+			tagged := []JobPage{}
+			for _, page := range pages {
+				if (topic == "" || containsString(page.Topics, topic)) && (version == "" || sameDocsVersion(page.DocsVersion, version)) {
+					tagged = append(tagged, page)
+				}
+			}
+			pages = tagged
+		}
+		return c.JSON(pages)
+	})
+
+	// Page representations carry an ETag of the page's content hash and Last-Modified,
+	// and conditional requests for unchanged pages get 304 Not Modified.
+	// The format (json, markdown, text or ndjson) is negotiated from the Accept header
+	// unless given as a parameter. raw_html=true inlines the page's raw HTML in JSON,
+	// when the job kept it; template=name renders the page with one of the job's
+	// output templates instead
+	app.Get("/jobs/:id/pages/:page", func(c *fiber.Ctx) error {
+		page, modified, err := jobs.Page(tenantFromCtx(c), c.Params("id"), c.Params("page"))
+		if err != nil {
+			return c.Status(fiber.StatusNotFound).SendString(err.Error())
+		}
+		if name := c.Query("template"); name != "" {
+			if notModified(c, pageETag(page, "template-"+name, false), modified) {
+				return c.SendStatus(fiber.StatusNotModified)
+			}
+			rendered, extension, err := jobs.RenderPage(tenantFromCtx(c), c.Params("id"), c.Params("page"), name)
+			if errors.Is(err, errTemplateFailed) {
+				return c.Status(fiber.StatusUnprocessableEntity).SendString(err.Error())
+			} else if err != nil {
+				return c.Status(fiber.StatusNotFound).SendString(err.Error())
+			}
+			c.Type(extension, "utf-8")
+			c.Set("X-Content-Type-Options", "nosniff")
+			c.Set("Content-Security-Policy", "sandbox; default-src 'none'; img-src * data:; style-src 'unsafe-inline'") // Rendered page content must not run as our origin
+			return c.SendString(rendered)
+		}
+		format, err := negotiateFormat(c, formatJSON, formatMarkdown, formatText, formatNDJSON)
+		if err != nil {
+			return c.Status(negotiationStatus(err)).SendString(err.Error())
+		}
+		// links=api points links to other pages of the job at their page URLs here
+		linkSuffix := ""
+		switch c.Query("links", "original") {
+		case "original":
+		case "api":
+			linker, err := jobs.PageLinker(tenantFromCtx(c), c.Params("id"), func(pageURL string) string {
+				return "/jobs/" + c.Params("id") + "/pages/" + pageID(pageURL)
+			})
+			if err != nil {
+				return c.Status(fiber.StatusNotFound).SendString(err.Error())
+			}
+			linked := *page
+			linked.Markdown = linker.rewrite(page.URL, page.Markdown)
+			page, linkSuffix = &linked, "-api-links"
+		default:
+			return c.Status(fiber.StatusBadRequest).SendString("Invalid links, expected original or api")
+		}
+		switch format {
+		case formatMarkdown:
+			if notModified(c, pageETag(page, strings.TrimPrefix(linkSuffix, "-"), false), modified) {
+				return c.SendStatus(fiber.StatusNotModified)
+			}
+			return sendFormat(c, formatMarkdown, page.Markdown)
+		case formatText:
+			if notModified(c, pageETag(page, formatText+linkSuffix, false), modified) {
+				return c.SendStatus(fiber.StatusNotModified)
+			}
+			return sendFormat(c, formatText, renderMarkdownText(page.Markdown))
+		}
+		representation := format + linkSuffix
+		if c.QueryBool("raw_html") {
+			representation += "-raw_html"
+		}
+		if notModified(c, pageETag(page, representation, true), modified) {
+			return c.SendStatus(fiber.StatusNotModified)
+		}
+		if c.QueryBool("raw_html") {
+			if page, err = page.withRawHTML(); errors.Is(err, errNoRawHTML) {
+				return c.Status(fiber.StatusNotFound).SendString(err.Error())
+			} else if err != nil {
+				return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+			}
+		}
+		if format == formatNDJSON {
+			return sendNDJSON(c, []*CrawledData{page})
+		}
+		return sendJSON(c, page)
+	})
+
+	// Serves the raw HTML of a page as plain text, so it isn't rendered as our origin
+	app.Get("/jobs/:id/pages/:page/raw", func(c *fiber.Ctx) error {
+		page, modified, err := jobs.Page(tenantFromCtx(c), c.Params("id"), c.Params("page"))
+		if err != nil {
+			return c.Status(fiber.StatusNotFound).SendString(err.Error())
+		}
+		if notModified(c, pageETag(page, "raw", false), modified) {
+			return c.SendStatus(fiber.StatusNotModified)
+		}
+		rawHTML, err := page.rawHTML()
+		if errors.Is(err, errNoRawHTML) {
+			return c.Status(fiber.StatusNotFound).SendString(err.Error())
+		} else if err != nil {
+			return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+		}
+		c.Type("txt", "utf-8")
+		c.Set("X-Content-Type-Options", "nosniff")
+		return c.SendString(rawHTML)
+	})
+
+	// Renders a page's markdown as sanitized HTML for reviewing extraction quality;
+	// fragment=true returns only the body for embedding
+	app.Get("/jobs/:id/pages/:page/preview", func(c *fiber.Ctx) error {
+		page, modified, err := jobs.Page(tenantFromCtx(c), c.Params("id"), c.Params("page"))
+		if err != nil {
+			return c.Status(fiber.StatusNotFound).SendString(err.Error())
+		}
+		representation := "preview"
+		if c.QueryBool("fragment") {
+			representation = "preview-fragment"
+		}
+		if notModified(c, pageETag(page, representation, false), modified) {
+			return c.SendStatus(fiber.StatusNotModified)
+		}
+		body, err := renderMarkdownHTML(page.Markdown)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+		}
+		c.Type("html", "utf-8")
+		c.Set("Content-Security-Policy", "default-src 'none'; img-src * data:; style-src 'unsafe-inline'")
+		if c.QueryBool("fragment") {
+			return c.SendString(body)
+		}
+		return c.SendString(renderPreviewDocument(page.Metadata["title"], body))
+	})
+
+	// Splits a page into the chunks stored for retrieval, by the job's chunking
+	// strategy, each with a citation deep-linking to its section of the source page
+	app.Get("/jobs/:id/pages/:page/chunks", func(c *fiber.Ctx) error {
+		page, modified, err := jobs.Page(tenantFromCtx(c), c.Params("id"), c.Params("page"))
+		if err != nil {
+			return c.Status(fiber.StatusNotFound).SendString(err.Error())
+		}
+		job, err := jobs.Get(tenantFromCtx(c), c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusNotFound).SendString(err.Error())
+		}
+		var chunking ChunkerConfig
+		if job.Request.Chunking != nil {
+			chunking = *job.Request.Chunking
+		}
+		format, err := negotiateFormat(c, formatJSON, formatNDJSON)
+		if err != nil {
+			return c.Status(negotiationStatus(err)).SendString(err.Error())
+		}
+		representation := fmt.Sprintf("chunks-%s-%s-%d-%d", format, chunking.strategy(), chunking.maxTokens(), chunking.OverlapTokens)
+		if notModified(c, pageETag(page, representation, true), modified) {
+			return c.SendStatus(fiber.StatusNotModified)
+		}
+		chunks := pageChunks(page, chunking)
+		if format == formatNDJSON {
+			return sendNDJSON(c, chunks)
+		}
+		return sendJSON(c, chunks)
+	})
+
+	// Lists the chunks of all pages of a completed job, for loading into a vector
+	// store. Chunks repeated across pages are tagged or dropped by the job's dedupe
+	// mode, or the dedupe parameter. topic=name lists the chunks tagged with a topic only,
+	// docs_version=v the chunks of pages of a docs version only.
+	app.Get("/jobs/:id/chunks", func(c *fiber.Ctx) error {
+		job, results, err := jobs.Results(tenantFromCtx(c), c.Params("id"))
+		if errors.Is(err, errJobNotDone) {
+			return c.Status(fiber.StatusConflict).SendString(err.Error())
+		}
+		if err != nil {
+			return c.Status(fiber.StatusNotFound).SendString(err.Error())
+		}
+		var chunking ChunkerConfig
+		if job.Request.Chunking != nil {
+			chunking = *job.Request.Chunking
+		}
+		if dedupe := c.Query("dedupe"); dedupe != "" {
+			chunking.Dedupe = dedupe
+			if dedupe == "none" {
+				chunking.Dedupe = ""
+			}
+			if err := chunking.validate(); err != nil {
+				return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+			}
+		}
+		format, err := negotiateFormat(c, formatJSON, formatNDJSON)
+		if err != nil {
+			return c.Status(negotiationStatus(err)).SendString(err.Error())
+		}
+		deduper := newChunkDeduper()
+		chunks := []JobChunk{}
+		for _, pageURL := range sortedResultURLs(results) {
+			for _, chunk := range deduper.apply(pageURL, pageChunks(results[pageURL], chunking), chunking.Dedupe) {
+				if topic := c.Query("topic"); topic != "" && !containsString(chunk.Topics, topic) {
+					continue
+				}
+				if version := c.Query("docs_version"); version != "" && !sameDocsVersion(chunk.DocsVersion, version) {
+					continue
+				}
+				chunks = append(chunks, JobChunk{PageID: pageID(pageURL), URL: pageURL, Chunk: chunk})
+			}
+		}
+		if format == formatNDJSON {
+			return sendNDJSON(c, chunks)
+		}
+		return sendJSON(c, chunks)
+	})
+
+	app.Get("/jobs/:id/pages/:page/screenshot", func(c *fiber.Ctx) error {
+		page, _, err := jobs.Page(tenantFromCtx(c), c.Params("id"), c.Params("page"))
+		if err != nil {
+			return c.Status(fiber.StatusNotFound).SendString(err.Error())
+		}
+		if page.ScreenshotPath == "" {
+			return c.Status(fiber.StatusNotFound).SendString("page has no screenshot")
+		}
+		return c.SendFile(page.ScreenshotPath)
+	})
+
+	app.Get("/jobs/:id/screenshots", func(c *fiber.Ctx) error {
+		screenshots, err := jobs.Screenshots(tenantFromCtx(c), c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusNotFound).SendString(err.Error())
+		}
+		return c.JSON(screenshots)
+	})
+
+	app.Get("/jobs/:id/visual-diff", func(c *fiber.Ctx) error {
+		diffs, err := jobs.VisualDiff(tenantFromCtx(c), c.Params("id"), c.Query("against"))
+		if err != nil {
+			return c.Status(fiber.StatusNotFound).SendString(err.Error())
+		}
+		return c.JSON(diffs)
+	})
+
+	app.Get("/jobs/:id/pages/:page/visual-diff", func(c *fiber.Ctx) error {
+		img, err := jobs.VisualDiffImage(tenantFromCtx(c), c.Params("id"), c.Params("page"), c.Query("against"))
+		if err != nil {
+			return c.Status(fiber.StatusNotFound).SendString(err.Error())
+		}
+		c.Type("png")
+		return png.Encode(c, img)
+	})
+
+	// Compares two completed crawls of a site, jobA the earlier one: the pages added,
+	// removed and changed, with links to the diff of each changed page
+	app.Get("/compare", func(c *fiber.Ctx) error {
+		if c.Query("jobA") == "" || c.Query("jobB") == "" {
+			return c.Status(fiber.StatusBadRequest).SendString("jobA and jobB are required")
+		}
+		comparison, err := jobs.Compare(tenantFromCtx(c), c.Query("jobA"), c.Query("jobB"))
+		if errors.Is(err, errJobNotDone) {
+			return c.Status(fiber.StatusConflict).SendString(err.Error())
+		}
+		if err != nil {
+			return c.Status(fiber.StatusNotFound).SendString(err.Error())
+		}
+		return c.JSON(comparison)
+	})
+
+	// The unified diff of the markdown of a page, by its canonical url, between two crawls
+	app.Get("/compare/diff", func(c *fiber.Ctx) error {
+		if c.Query("jobA") == "" || c.Query("jobB") == "" || c.Query("url") == "" {
+			return c.Status(fiber.StatusBadRequest).SendString("jobA, jobB and url are required")
+		}
+		diff, err := jobs.CompareDiff(tenantFromCtx(c), c.Query("jobA"), c.Query("jobB"), c.Query("url"))
+		if errors.Is(err, errJobNotDone) {
+			return c.Status(fiber.StatusConflict).SendString(err.Error())
+		}
+		if err != nil {
+			return c.Status(fiber.StatusNotFound).SendString(err.Error())
+		}
+		c.Type("txt", "utf-8")
+		return c.SendString(diff)
+	})
+
+	// Exports are served with byte-range support so multi-GB downloads can be resumed,
+	// or pushed to S3 with destination=s3, returning a presigned URL instead.
+	// format=parquet exports the page table, or the link table with table=links.
+	// format=llms and llms-full export the llms.txt index and full text of the site,
+	// format=template renders the job with its output template of the given name,
+	// format=site exports a static HTML mirror with an index and search.
+	// format=graph exports the knowledge graph of pages, links and entities as
+	// N-Triples, or as CSV files for neo4j-admin import with as=neo4j.
+	app.Get("/jobs/:id/export", func(c *fiber.Ctx) error {
+		var format string
+		switch c.Query("format", "zip") {
+		case "zip":
+			format = exportZip
+		case "parquet":
+			switch c.Query("table", "pages") {
+			case "pages":
+				format = exportParquetPages
+			case "links":
+				format = exportParquetLinks
+			default:
+				return c.Status(fiber.StatusBadRequest).SendString("Invalid table, expected pages or links")
+			}
+		case "llms":
+			format = exportLLMs
+		case "llms-full":
+			format = exportLLMsFull
+		case "template":
+			format = exportTemplatePrefix + c.Query("name")
+		case "site":
+			format = exportSite
+		case "graph":
+			switch c.Query("as", "ntriples") {
+			case "ntriples":
+				format = exportTriples
+			case "neo4j":
+				format = exportNeo4j
+			default:
+				return c.Status(fiber.StatusBadRequest).SendString("Invalid graph format, expected ntriples or neo4j")
+			}
+		default:
+			return c.Status(fiber.StatusBadRequest).SendString("Invalid format, expected zip, parquet, llms, llms-full, template, site or graph")
+		}
+
+		path, err := jobs.Export(tenantFromCtx(c), c.Params("id"), format)
+		switch {
+		case errors.Is(err, errJobNotDone):
+			return c.Status(fiber.StatusConflict).SendString(err.Error())
+		case errors.Is(err, errJobNotFound), errors.Is(err, errNoOutputTemplate):
+			return c.Status(fiber.StatusNotFound).SendString(err.Error())
+		case err != nil:
+			fiberlog.Errorf("Export of job %s failed: %v", c.Params("id"), err)
+			return c.Status(fiber.StatusInternalServerError).SendString("Export failed")
+		}
+
+		switch c.Query("destination", "download") {
+		case "download":
+			c.Attachment(filepath.Base(path))
+			return c.SendFile(path)
+		case "s3":
+			s3Config, err := s3ConfigFromEnv()
+			if err != nil {
+				return c.Status(fiber.StatusNotImplemented).SendString(err.Error())
+			}
+			expires := time.Duration(c.QueryInt("expires", 3600)) * time.Second
+			if expires <= 0 || expires > 7*24*time.Hour { // SigV4 presigned URLs are valid for at most 7 days
+				return c.Status(fiber.StatusBadRequest).SendString("expires must be between 1 and 604800 seconds")
+			}
+			key := filepath.Base(path)
+			if err := s3Config.UploadFile(key, path); err != nil {
+				fiberlog.Errorf("S3 upload of %s failed: %v", path, err)
+				return c.Status(fiber.StatusBadGateway).SendString("Upload to S3 failed")
+			}
+			presignedURL, err := s3Config.PresignGet(key, expires)
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+			}
+			return c.JSON(fiber.Map{"url": presignedURL, "expires_at": time.Now().Add(expires)})
+		default:
+			return c.Status(fiber.StatusBadRequest).SendString("Invalid destination, expected download or s3")
+		}
+	})
+
+	app.Get("/usage", func(c *fiber.Ctx) error {
+		tenant := tenantFromCtx(c)
+		return c.JSON(fiber.Map{
+			"tenant_id": tenant.ID,
+			"quotas":    tenant.Quotas,
+			"usage":     tenant.Usage(),
+		})
+	})
+}
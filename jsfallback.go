@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// dynamicFetchTimeout bounds a single headless page load
+const dynamicFetchTimeout = 30 * time.Second
+
+// JS fallback decisions recorded in PageDiagnostics.JSFallback
+const (
+	jsFallbackUsed     = "used"     // The JS-rendered result scored better and replaced the static one
+	jsFallbackRejected = "rejected" // JS rendering didn't improve the result, the static one was kept
+	jsFallbackFailed   = "failed"   // Rendering failed, the static result was kept
+)
+
+// PageDiagnostics records how a page was fetched and processed
+type PageDiagnostics struct {
+	RenderedWithJS  bool        `json:"rendered_with_js"`
+	JSFallback      string      `json:"js_fallback,omitempty"` // Outcome of the automatic JS retry, if one was made
+	JSFallbackError string      `json:"js_fallback_error,omitempty"`
+	StaticScore     float64     `json:"static_score,omitempty"` // Quality scores compared by the fallback
+	JSScore         float64     `json:"js_score,omitempty"`
+	Timings         PageTimings `json:"timings"`
+}
+
+// retryWithJS re-fetches a page whose static extraction scored low with chromedp and
+// returns whichever result has the better quality score. Many single-page apps ship
+// an almost empty HTML shell, so this saves users from finding them by hand.
+func (c *Crawler) retryWithJS(static *CrawledData) *CrawledData {
+	static.Diagnostics.StaticScore = static.Quality.Score
+	fmt.Printf("Low extraction quality (%.2f) for %s, retrying with JS rendering\n", static.Quality.Score, static.URL)
+
+	renderStart := time.Now()
+	rendered, err := c.fetchDynamicContent(static.URL)
+	recordPhase(&static.Diagnostics.Timings.RenderMs, renderStart)
+	var dynamic *CrawledData
+	if err == nil {
+		dynamic, err = c.extractPage(static.URL, rendered)
+	}
+	if err != nil {
+		log.Printf("JS fallback failed for %s: %v", static.URL, err)
+		static.Diagnostics.JSFallback = jsFallbackFailed
+		static.Diagnostics.JSFallbackError = err.Error()
+		return static
+	}
+
+	static.Diagnostics.JSScore = dynamic.Quality.Score
+	static.Diagnostics.Timings.add(dynamic.Diagnostics.Timings) // The page was extracted twice
+	if dynamic.Quality.Score <= static.Quality.Score {
+		static.Diagnostics.JSFallback = jsFallbackRejected
+		return static
+	}
+	dynamic.Diagnostics = static.Diagnostics
+	dynamic.Diagnostics.RenderedWithJS = true
+	dynamic.Diagnostics.JSFallback = jsFallbackUsed
+	return dynamic
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// jsonPathStep selects children of the current nodes: a member by name, an array
+// element by index (negative from the end) or, for wildcards, every child
+type jsonPathStep struct {
+	recursive bool // ".." descends into every nested value first
+	wildcard  bool
+	name      string
+	index     *int
+}
+
+// compileJSONPath parses the JSONPath subset used for projections: $, .name,
+// ['name'], [n], [*], .* and ..name / ..* (recursive descent)
+func compileJSONPath(path string) ([]jsonPathStep, error) {
+	path = strings.TrimSpace(path)
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("JSONPath %q must start with $", path)
+	}
+	var steps []jsonPathStep
+	rest := path[1:]
+	for rest != "" {
+		step := jsonPathStep{}
+		switch {
+		case strings.HasPrefix(rest, ".."):
+			step.recursive = true
+			rest = rest[2:]
+			if strings.HasPrefix(rest, "[") {
+				break
+			}
+			fallthrough
+		case strings.HasPrefix(rest, "."):
+			rest = strings.TrimPrefix(rest, ".")
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("JSONPath %q: missing member name", path)
+			}
+			step.name, rest = rest[:end], rest[end:]
+			step.wildcard = step.name == "*"
+			steps = append(steps, step)
+			continue
+		case !strings.HasPrefix(rest, "["):
+			return nil, fmt.Errorf("JSONPath %q: unexpected %q", path, rest)
+		}
+
+		end := strings.Index(rest, "]")
+		if end < 0 {
+			return nil, fmt.Errorf("JSONPath %q: missing ]", path)
+		}
+		selector := strings.TrimSpace(rest[1:end])
+		rest = rest[end+1:]
+		switch {
+		case selector == "*":
+			step.wildcard = true
+		case len(selector) >= 2 && (selector[0] == '\'' || selector[0] == '"') && selector[len(selector)-1] == selector[0]:
+			step.name = selector[1 : len(selector)-1]
+		default:
+			index, err := strconv.Atoi(selector)
+			if err != nil {
+				return nil, fmt.Errorf("JSONPath %q: unsupported selector [%s]", path, selector)
+			}
+			step.index = &index
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// evalJSONPath returns every value of a decoded JSON document that a compiled
+// path selects, in document order (object members by name)
+func evalJSONPath(document interface{}, steps []jsonPathStep) []interface{} {
+	nodes := []interface{}{document}
+	for _, step := range steps {
+		var next []interface{}
+		for _, node := range nodes {
+			if step.recursive {
+				for _, descendant := range jsonDescendants(node) {
+					next = append(next, step.apply(descendant)...)
+				}
+			} else {
+				next = append(next, step.apply(node)...)
+			}
+		}
+		nodes = next
+	}
+	return nodes
+}
+
+// apply selects the children of one node
+func (step jsonPathStep) apply(node interface{}) []interface{} {
+	switch value := node.(type) {
+	case map[string]interface{}:
+		if step.wildcard {
+			return jsonChildren(value)
+		}
+		if child, ok := value[step.name]; ok && step.index == nil {
+			return []interface{}{child}
+		}
+	case []interface{}:
+		if step.wildcard {
+			return value
+		}
+		if step.index != nil {
+			index := *step.index
+			if index < 0 {
+				index += len(value)
+			}
+			if index >= 0 && index < len(value) {
+				return []interface{}{value[index]}
+			}
+		}
+	}
+	return nil
+}
+
+// jsonDescendants returns a node and every value nested in it
+func jsonDescendants(node interface{}) []interface{} {
+	descendants := []interface{}{node}
+	for _, child := range jsonChildren(node) {
+		descendants = append(descendants, jsonDescendants(child)...)
+	}
+	return descendants
+}
+
+// jsonChildren returns the elements of an array or the members of an object
+func jsonChildren(node interface{}) []interface{} {
+	switch value := node.(type) {
+	case []interface{}:
+		return value
+	case map[string]interface{}:
+		names := make([]string, 0, len(value))
+		for name := range value {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		children := make([]interface{}, 0, len(names))
+		for _, name := range names {
+			children = append(children, value[name])
+		}
+		return children
+	}
+	return nil
+}
+
+// validateJSONProjections checks the JSONPath of every projection
+func validateJSONProjections(projections map[string]string) error {
+	for name, path := range projections {
+		if _, err := compileJSONPath(path); err != nil {
+			return fmt.Errorf("json projection %q: %w", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxLLMsSummary is the length from which page summaries in llms.txt are shortened
+const maxLLMsSummary = 200
+
+// llmsOptionalSection lists the pages agents may skip, as the llms.txt format defines
+const llmsOptionalSection = "Optional"
+
+// markdownLink matches inline links and images, keeping their text
+var markdownLink = regexp.MustCompile(`!?\[([^\]]*)\]\([^)]*\)`)
+
+// llmsPage is a page listed in llms.txt
+type llmsPage struct {
+	URL     string
+	Title   string
+	Summary string
+	data    *CrawledData
+}
+
+// llmsSite is a crawled site arranged for llms.txt: its start page, and the other
+// pages in sections by their first path segment
+type llmsSite struct {
+	Title       string
+	Description string
+	Start       *llmsPage // Described by the header, not listed unless it's the only page
+	Sections    map[string][]llmsPage
+}
+
+// newLLMsSite arranges the results of a job for llms.txt. Low-value pages and pages
+// whose extraction failed go to the Optional section.
+func newLLMsSite(job Job, results map[string]*CrawledData) llmsSite {
+	site := llmsSite{Sections: make(map[string][]llmsPage)}
+	startURL := llmsStartPage(job.config.StartURL, results)
+	if start := results[startURL]; start != nil {
+		site.Title = firstNonEmpty(start.Metadata["og:site_name"], start.Metadata["title"])
+		site.Description = firstNonEmpty(start.Metadata["description"], start.Metadata["og:description"])
+	}
+	if site.Title == "" {
+		if parsedURL, err := url.Parse(startURL); err == nil {
+			site.Title = parsedURL.Host
+		}
+	}
+	for _, pageURL := range sortedResultURLs(results) {
+		data := results[pageURL]
+		page := llmsPage{
+			URL:     pageURL,
+			Title:   firstNonEmpty(data.Metadata["title"], data.Metadata["og:title"], pageURL),
+			Summary: llmsSummary(data),
+			data:    data,
+		}
+		if pageURL == startURL && len(results) > 1 {
+			site.Start = &page
+			continue
+		}
+		section := llmsSection(pageURL)
+		if (data.Value != nil && data.Value.Low) || (data.Quality != nil && data.Quality.Low) {
+			section = llmsOptionalSection
+		}
+		site.Sections[section] = append(site.Sections[section], page)
+	}
+	return site
+}
+
+// llmsStartPage returns the result of the start URL, or the page with the shortest
+// URL when the crawl had none (seeds, archives)
+func llmsStartPage(startURL string, results map[string]*CrawledData) string {
+	for _, candidate := range []string{startURL, strings.TrimSuffix(startURL, "/"), startURL + "/"} {
+		if _, ok := results[candidate]; ok {
+			return candidate
+		}
+	}
+	shortest := ""
+	for pageURL := range results {
+		if shortest == "" || len(pageURL) < len(shortest) || (len(pageURL) == len(shortest) && pageURL < shortest) {
+			shortest = pageURL
+		}
+	}
+	return shortest
+}
+
+// llmsSection names the section of a page after its first path segment, e.g. "Docs"
+// for /docs/install; pages at the root of the site go to "Pages"
+func llmsSection(pageURL string) string {
+	parsedURL, err := url.Parse(pageURL)
+	if err != nil {
+		return "Pages"
+	}
+	segments := strings.Split(strings.Trim(parsedURL.Path, "/"), "/")
+	if len(segments) < 2 || segments[0] == "" {
+		return "Pages"
+	}
+	name, err := url.PathUnescape(segments[0])
+	if err != nil {
+		name = segments[0]
+	}
+	words := strings.Fields(strings.NewReplacer("-", " ", "_", " ").Replace(name))
+	for i, word := range words {
+		first, size := utf8.DecodeRuneInString(word)
+		words[i] = string(unicode.ToUpper(first)) + word[size:]
+	}
+	if len(words) == 0 {
+		return "Pages"
+	}
+	return strings.Join(words, " ")
+}
+
+// llmsSummary describes a page in a sentence or two: its meta description, else the
+// first paragraph of its markdown
+func llmsSummary(data *CrawledData) string {
+	summary := firstNonEmpty(data.Metadata["description"], data.Metadata["og:description"])
+	if summary == "" {
+		summary = firstParagraph(data.Markdown)
+	}
+	summary = strings.Join(strings.Fields(summary), " ")
+	if len(summary) <= maxLLMsSummary {
+		return summary
+	}
+	end := maxLLMsSummary
+	for !utf8.RuneStart(summary[end]) {
+		end--
+	}
+	cut := summary[:end]
+	if end := strings.LastIndex(cut, ". "); end > maxLLMsSummary/2 {
+		return cut[:end+1]
+	}
+	if end := strings.LastIndex(cut, " "); end > 0 {
+		cut = cut[:end]
+	}
+	return cut + "…"
+}
+
+// firstParagraph returns the first line of prose in markdown, as plain text: not a
+// heading, quote, list, table, rule, code or the metadata of the header
+func firstParagraph(markdown string) string {
+	scanner := bufio.NewScanner(strings.NewReader(markdown))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	inCode := false
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "```") {
+			inCode = !inCode
+			continue
+		}
+		if inCode || line == "" || strings.ContainsAny(line[:1], "#>|-*+!<") || strings.HasPrefix(line, "**") {
+			continue
+		}
+		text := markdownLink.ReplaceAllString(line, "$1")
+		text = strings.NewReplacer(`\`, "", "**", "", "`", "").Replace(text)
+		if len(strings.Fields(text)) >= 5 { // Skips captions, bylines and stray labels
+			return text
+		}
+	}
+	return ""
+}
+
+// sortedSections orders the sections by name, with Optional last
+func (site llmsSite) sortedSections() []string {
+	var names []string
+	for name := range site.Sections {
+		if name != llmsOptionalSection {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	if _, ok := site.Sections[llmsOptionalSection]; ok {
+		names = append(names, llmsOptionalSection)
+	}
+	return names
+}
+
+// linkText escapes text for the label of a markdown link
+func linkText(text string) string {
+	return escapeMarkdown(strings.Join(strings.Fields(text), " ")) // Escapes the brackets too
+}
+
+// writeLLMsTxt writes the llms.txt index of a job: the site's title and description,
+// then its pages with their summaries, in sections (see https://llmstxt.org)
+func writeLLMsTxt(w io.Writer, job Job, results map[string]*CrawledData) error {
+	site := newLLMsSite(job, results)
+	var out strings.Builder
+	site.writeHeader(&out)
+	for _, section := range site.sortedSections() {
+		fmt.Fprintf(&out, "\n## %s\n\n", section)
+		for _, page := range site.Sections[section] {
+			fmt.Fprintf(&out, "- [%s](%s)", linkText(page.Title), page.URL)
+			if page.Summary != "" {
+				out.WriteString(": " + escapeMarkdown(page.Summary))
+			}
+			out.WriteString("\n")
+		}
+	}
+	_, err := io.WriteString(w, out.String())
+	return err
+}
+
+// writeLLMsFullTxt writes llms-full.txt: the header of llms.txt followed by the
+// markdown of every page, section by section, for agents that read a site in one go
+func writeLLMsFullTxt(w io.Writer, job Job, results map[string]*CrawledData) error {
+	site := newLLMsSite(job, results)
+	var header strings.Builder
+	site.writeHeader(&header)
+	if _, err := io.WriteString(w, header.String()); err != nil {
+		return err
+	}
+	var pages []llmsPage
+	if site.Start != nil {
+		pages = append(pages, *site.Start)
+	}
+	for _, section := range site.sortedSections() {
+		pages = append(pages, site.Sections[section]...)
+	}
+	for _, page := range pages {
+		if strings.TrimSpace(page.data.Markdown) == "" {
+			continue // Metadata-only pages have no content
+		}
+		if _, err := fmt.Fprintf(w, "\n---\n\nSource: <%s>\n\n%s\n", page.URL, strings.TrimSpace(page.data.Markdown)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeHeader writes the title and description of the site
+func (site llmsSite) writeHeader(out *strings.Builder) {
+	fmt.Fprintf(out, "# %s\n", escapeMarkdown(strings.Join(strings.Fields(site.Title), " ")))
+	if description := strings.Join(strings.Fields(site.Description), " "); description != "" {
+		fmt.Fprintf(out, "\n> %s\n", escapeMarkdown(description))
+	}
+}
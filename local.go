@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// fileRootFromEnv returns the directory file:// URLs submitted over the API may read
+// from (LEXICRAWLER_FILE_ROOT). Without it the API rejects file:// URLs.
+func fileRootFromEnv() string {
+	return os.Getenv("LEXICRAWLER_FILE_ROOT")
+}
+
+// fileURL converts a local path to an absolute file:// URL
+func fileURL(path string) (string, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(absPath)}).String(), nil
+}
+
+// isWithinRoot reports whether path is root or inside it, once symlinks are resolved,
+// so a link inside the root can't lead outside it
+func isWithinRoot(root string, path string) bool {
+	if root == "" {
+		return false
+	}
+	realRoot, err := resolvePath(root)
+	if err != nil {
+		return false
+	}
+	realPath, err := resolvePath(path)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(realRoot, realPath)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// resolvePath returns the absolute path with its symlinks resolved. Of a path that
+// doesn't exist yet, the part that exists is resolved and the rest kept as it is.
+func resolvePath(path string) (string, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	missing := ""
+	for {
+		resolved, err := filepath.EvalSymlinks(absPath)
+		if err == nil {
+			return filepath.Join(resolved, missing), nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+		parent := filepath.Dir(absPath)
+		if parent == absPath {
+			return "", err
+		}
+		missing = filepath.Join(filepath.Base(absPath), missing)
+		absPath = parent
+	}
+}
+
+// checkArchivePath validates an archive path submitted over the API against the
+// allowed root directory and returns it as an absolute path
+func checkArchivePath(path string, root string) (string, error) {
+	if root == "" {
+		return "", errors.New("archives are disabled (set LEXICRAWLER_FILE_ROOT to allow a directory)")
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil || !isWithinRoot(root, absPath) {
+		return "", fmt.Errorf("%s is outside the allowed file root", path)
+	}
+	return absPath, nil
+}
+
+// checkFileURL validates a file:// start URL against the allowed root directory
+func checkFileURL(parsedURL *url.URL, root string) error {
+	if root == "" {
+		return errors.New("file:// URLs are disabled (set LEXICRAWLER_FILE_ROOT to allow a directory)")
+	}
+	if !isWithinRoot(root, filepath.FromSlash(parsedURL.Path)) {
+		return fmt.Errorf("%s is outside the allowed file root", parsedURL.Path)
+	}
+	return nil
+}
+
+// fileSeeds lists the HTML files below a file:// directory URL as file:// URLs, so a
+// folder of saved pages is processed even when the pages don't link to each other
+func fileSeeds(dirURL string) ([]string, error) {
+	parsedURL, err := url.Parse(dirURL)
+	if err != nil {
+		return nil, err
+	}
+	root := filepath.FromSlash(parsedURL.Path)
+	if info, err := os.Stat(root); err != nil || !info.IsDir() {
+		return nil, nil // A single file is crawled as is
+	}
+
+	var seeds []string
+	err = filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		ext := strings.ToLower(filepath.Ext(path))
+		if entry.IsDir() || (ext != ".html" && ext != ".htm") {
+			return nil
+		}
+		seed, err := fileURL(path)
+		if err != nil {
+			return err
+		}
+		seeds = append(seeds, seed)
+		return nil
+	})
+	sort.Strings(seeds)
+	return seeds, err
+}
+
+// runDirCrawl processes a folder of saved HTML through the extraction pipeline and
+// writes one markdown file per page (mirroring the folder layout) plus report.json
+// to outDir. It backs the -dir command-line flag.
+func runDirCrawl(dir string, outDir string, config CrawlerConfig) error {
+	startURL, err := fileURL(dir)
+	if err != nil {
+		return err
+	}
+	config.StartURL = startURL
+	config.FileRoot = dir
+	config.MaxDepth = 1 // Every file is a seed, links between files don't need following
+	config.DisableJSFallback = true
+
+	results, err := NewCrawler(config).Crawl()
+	if err != nil {
+		return err
+	}
+	defer removeSpilled(results)
+	if len(results) == 0 {
+		return fmt.Errorf("no HTML files found in %s", dir)
+	}
+
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	err = writeOfflineOutput(outDir, startURL, results, func(parsedURL *url.URL) (string, error) {
+		rel, err := filepath.Rel(absDir, filepath.FromSlash(parsedURL.Path))
+		return strings.TrimSuffix(rel, filepath.Ext(rel)), err
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Processed %d pages from %s into %s\n", len(results), dir, outDir)
+	return nil
+}
+
+// writeOfflineOutput writes each page's markdown to outDir under the path returned
+// by target (without extension) plus report.json
+func writeOfflineOutput(outDir string, startURL string, results map[string]*CrawledData, target func(*url.URL) (string, error)) error {
+	for pageURL, data := range results {
+		parsedURL, err := url.Parse(pageURL)
+		if err != nil {
+			return err
+		}
+		rel, err := target(parsedURL)
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(outDir, rel+".md")
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, []byte(data.Markdown), 0644); err != nil {
+			return err
+		}
+	}
+
+	report, err := json.MarshalIndent(NewCrawlReport(startURL, results), "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(outDir, "report.json"), report, 0644)
+}
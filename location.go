@@ -0,0 +1,61 @@
+package main
+
+import "strings"
+
+// Location is a normalized schema.org Place, PostalAddress or VirtualLocation
+type Location struct {
+	Name       string `json:"name,omitempty"`
+	Address    string `json:"address,omitempty"` // The full address on one line
+	Locality   string `json:"locality,omitempty"`
+	Region     string `json:"region,omitempty"`
+	PostalCode string `json:"postal_code,omitempty"`
+	Country    string `json:"country,omitempty"`
+	URL        string `json:"url,omitempty"` // For online locations
+}
+
+// schemaLocations normalizes the places of a property (jobLocation, location),
+// which may be Places, addresses or plain text
+func schemaLocations(item schemaObject, property, pageURL string) []Location {
+	var locations []Location
+	for _, value := range schemaList(item[property]) {
+		var location Location
+		if object, ok := asSchemaObject(value); !ok {
+			location.Name = schemaText(value)
+		} else {
+			address := object
+			if !object.is("PostalAddress") {
+				location.Name = object.str("name")
+				address = object.object("address")
+				if address == nil && object.str("address") != "" {
+					location.Address = object.str("address")
+				}
+			}
+			if address != nil {
+				location.Locality = address.str("addressLocality")
+				location.Region = address.str("addressRegion")
+				location.PostalCode = address.str("postalCode")
+				location.Country = address.str("addressCountry")
+				location.Address = joinNonEmpty(", ", address.str("streetAddress"), location.Locality,
+					strings.TrimSpace(location.Region+" "+location.PostalCode), location.Country)
+			}
+			if object.is("VirtualLocation") {
+				location.URL = resolveURL(pageURL, object.str("url"))
+			}
+		}
+		if location != (Location{}) {
+			locations = append(locations, location)
+		}
+	}
+	return locations
+}
+
+// joinNonEmpty joins the non-empty values with sep
+func joinNonEmpty(sep string, values ...string) string {
+	var parts []string
+	for _, value := range values {
+		if value != "" {
+			parts = append(parts, value)
+		}
+	}
+	return strings.Join(parts, sep)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// defaultLowValueThreshold is the score from which pages count as low value
+const defaultLowValueThreshold = 0.6
+
+// Low-value signal weights; a page's score is their sum, capped at 1
+const (
+	lowValueThinWeight      = 0.35 // Scaled by how far the page falls short of lowValueThinWords
+	lowValueLinkWeight      = 0.25 // Scaled by the link density above lowValueLinkDensity
+	lowValueArchiveWeight   = 0.2
+	lowValueTagCloudWeight  = 0.3
+	lowValueStuffingWeight  = 0.3
+	lowValueRepeatWeight    = 0.25
+	lowValueNoindexWeight   = 0.15
+	lowValueThinWords       = 200
+	lowValueLinkDensity     = 0.4
+	lowValueTagCloudLinks   = 20   // Short links needed for a tag cloud
+	lowValueStuffingShare   = 0.05 // Share of the words taken by the most frequent one
+	lowValueRepeatedShare   = 0.3  // Share of repeated lines
+	lowValueMinWordsChecked = 100  // Stuffing and repetition need some text to judge
+)
+
+// archivePathPattern matches tag, category, author, pagination and date archive URLs
+var archivePathPattern = regexp.MustCompile(`(?i)/(tags?|category|categories|archives?|author|label|topics?)(/|$)|/page/\d+/?$|/\d{4}(/\d{2}){0,2}/?$`)
+
+// stuffingStopwords are frequent words that don't indicate keyword stuffing
+var stuffingStopwords = map[string]bool{
+	"that": true, "this": true, "with": true, "from": true, "have": true, "your": true, "will": true, "they": true,
+	"their": true, "there": true, "which": true, "were": true, "been": true, "what": true, "when": true, "about": true,
+	"more": true, "also": true, "into": true, "than": true, "them": true, "then": true, "these": true, "some": true,
+	"über": true, "nicht": true, "eine": true, "sind": true, "oder": true, "dans": true, "pour": true, "avec": true,
+}
+
+// ValueAssessment scores how likely a page is thin or low-value content (doorway
+// pages, tag clouds, auto-generated archives); Signals explain the score
+type ValueAssessment struct {
+	Score   float64  `json:"score"`   // 0 (substantial content) to 1
+	Signals []string `json:"signals"` // e.g. "thin_content", "tag_cloud"
+	Low     bool     `json:"low"`     // Score reached the crawl's threshold
+}
+
+// ExcludedPage is a page left out of the output as low value, kept for auditing
+type ExcludedPage struct {
+	URL   string          `json:"url"`
+	Value ValueAssessment `json:"value"`
+}
+
+// assessValue scores a page from its extracted content, markdown statistics and
+// quality, its URL and its robots meta tag
+func assessValue(pageURL string, content *goquery.Selection, metadata map[string]string, stats ContentStats, quality ExtractionQuality, threshold float64) ValueAssessment {
+	assessment := ValueAssessment{Signals: []string{}}
+	score := 0.0
+	signal := func(name string, weight float64) {
+		score += weight
+		assessment.Signals = append(assessment.Signals, name)
+	}
+
+	if stats.Words < lowValueThinWords {
+		signal("thin_content", lowValueThinWeight*float64(lowValueThinWords-stats.Words)/lowValueThinWords)
+	}
+	if quality.LinkDensity > lowValueLinkDensity {
+		signal("link_heavy", lowValueLinkWeight*(quality.LinkDensity-lowValueLinkDensity)/(1-lowValueLinkDensity))
+	}
+	if parsedURL, err := url.Parse(pageURL); err == nil && archivePathPattern.MatchString(parsedURL.Path) {
+		signal("archive_url", lowValueArchiveWeight)
+	}
+
+	text := visibleText(content)
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+	shortLinks, linkWords := 0, 0
+	content.Find("a").Each(func(_ int, s *goquery.Selection) {
+		if n := len(strings.Fields(s.Text())); n > 0 && n <= 3 {
+			shortLinks++
+			linkWords += n
+		}
+	})
+	if shortLinks >= lowValueTagCloudLinks && linkWords*2 >= len(words) {
+		signal("tag_cloud", lowValueTagCloudWeight)
+	}
+	if len(words) >= lowValueMinWordsChecked {
+		counts := make(map[string]int)
+		top := 0
+		for _, word := range words {
+			if len([]rune(word)) >= 4 && !stuffingStopwords[word] {
+				counts[word]++
+				top = max(top, counts[word])
+			}
+		}
+		if float64(top)/float64(len(words)) > lowValueStuffingShare {
+			signal("keyword_stuffing", lowValueStuffingWeight)
+		}
+
+		lines := make(map[string]int)
+		total, repeated := 0, 0
+		content.Find("p, li, h1, h2, h3, h4, td").Each(func(_ int, s *goquery.Selection) {
+			line := strings.Join(strings.Fields(s.Text()), " ")
+			if len(line) < 20 {
+				return
+			}
+			total++
+			lines[line]++
+			if lines[line] > 1 {
+				repeated++
+			}
+		})
+		if total >= 10 && float64(repeated)/float64(total) > lowValueRepeatedShare {
+			signal("repetitive", lowValueRepeatWeight)
+		}
+	}
+	if robots := strings.ToLower(metadata["robots"]); strings.Contains(robots, "noindex") {
+		signal("noindex", lowValueNoindexWeight)
+	}
+
+	assessment.Score = float64(int(min(score, 1)*100+0.5)) / 100
+	assessment.Low = assessment.Score >= threshold
+	return assessment
+}
+
+// lowValueThreshold returns the configured threshold or the default
+func (config CrawlerConfig) lowValueThreshold() float64 {
+	if config.LowValueThreshold > 0 {
+		return config.LowValueThreshold
+	}
+	return defaultLowValueThreshold
+}
+
+// addToMetadata records the low-value score in a page's string metadata map
+func (v ValueAssessment) addToMetadata(metadata map[string]string) {
+	metadata["low_value_score"] = strconv.FormatFloat(v.Score, 'f', 2, 64)
+	if v.Low {
+		metadata["low_value"] = "true"
+	}
+}
+
+// recordExcluded notes a page left out of the output as low value
+func (c *Crawler) recordExcluded(data *CrawledData, value ValueAssessment) {
+	c.ExcludedMutex.Lock()
+	defer c.ExcludedMutex.Unlock()
+	c.Excluded = append(c.Excluded, ExcludedPage{URL: data.URL, Value: value})
+}
+
+// ExcludedPages returns the pages left out as low value, ordered by URL
+func (c *Crawler) ExcludedPages() []ExcludedPage {
+	c.ExcludedMutex.Lock()
+	defer c.ExcludedMutex.Unlock()
+	excluded := append([]ExcludedPage(nil), c.Excluded...)
+	sort.Slice(excluded, func(i, j int) bool { return excluded[i].URL < excluded[j].URL })
+	return excluded
+}
@@ -2,13 +2,17 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/PuerkitoBio/goquery"
@@ -16,6 +20,7 @@ import (
 	"github.com/gocolly/colly/v2"
 	"github.com/gofiber/fiber/v2"
 	fiberlog "github.com/gofiber/fiber/v2/log"
+	"github.com/gofiber/fiber/v2/middleware/pprof"
 	"github.com/go-shiori/go-readability"
 	"golang.org/x/net/html" // For explicit UTF-8 parsing
 )
@@ -34,6 +39,62 @@ type CrawlerConfig struct {
 	BM25Query       string // Placeholder
 	HeuristicsEnabled bool
 	EnableReadability bool // New: Enable Readability
+	FetchSiteImages bool // Download favicon and og:image as data URIs
+	ImageTargetWidth int // Width of the srcset candidate to reference, 0 for the largest
+	FetchTranscripts bool // Attach caption tracks and linked transcript pages of video/audio
+	TranscribeMedia bool // Transcribe media without captions with a Whisper-compatible API
+	ExtractComments bool // Collect comment threads (native markup and Discourse embeds) into structured data
+	HarvestContacts bool // Collect emails, phone numbers and social profiles of pages on AllowedDomains
+	FollowHreflang  bool // Also crawl the hreflang alternates of every page, grouped into document families
+	TranslateTo     string // Language pages in other languages are translated into, see translatorFromEnv
+	EntityRecognizer string // recognizeLocal or recognizeLLM to extract named entities, "" for none
+	Topics          *TopicConfig // Taxonomy pages are classified into, nil for none
+	SentimentAnalyzer string // sentimentLexicon or sentimentLLM to score the sentiment of pages, "" for none
+	DocsVersion     string // docsVersionTag, docsVersionSeed or a version to restrict the crawl to, "" to ignore versions
+	GenerateAltText bool // Describe images without alt text with a vision model, see visionModelFromEnv
+	AltTextMaxImages int // Images described per crawl, 0 for defaultAltTextMaxImages
+	AltTextPerMinute int // Requests to the vision model per minute, 0 for defaultAltTextPerMinute
+	ExtractImageData bool // Read the data of images of charts and tables with the vision model, see ImageData
+	ImageDataMaxImages int // Images read per crawl, 0 for defaultImageDataMaxImages
+	ImageDataPerMinute int // Requests for image data per minute, 0 for defaultImageDataPerMinute
+	MetadataOnly    bool // Fast inventory mode: only metadata and links, following links up to MaxDepth
+	DryRun          bool // Only discover the URLs that would be crawled, see DiscoveredURLs
+	MaxPages        int  // Stop requesting new pages after this many, 0 for no limit
+	MaxConcurrency  int  // Workers per network-bound pipeline stage, 0 for defaultMaxConcurrency
+	HostConcurrency int  // Pages of a host in flight at once, 0 for no limit
+	HostDelay       time.Duration // Between the starts of two fetches from a host
+	MemoryBudget    int64 // Bytes of results kept in memory before raw HTML is spilled to disk, 0 for defaultMemoryBudget
+	KeepRawHTML     bool // Keep the raw HTML of pages, compressed, so jobs can be reprocessed
+	ExtractWorkers  int  // Workers of the CPU-bound extract stage, 0 for one per CPU
+	Dedup           string // How visited URLs are remembered: dedupExact (default) or dedupBloom for crawls of millions of URLs
+	DedupExpectedURLs int // Sizes the bloom filter of dedupBloom, 0 for defaultDedupExpectedURLs
+	URLScorer       URLScorer // Orders the frontier, see URLPriority; nil crawls pages in the order they are found
+	Sinks           []SinkConfig // Destinations that receive every page as it is processed
+	Chunking        ChunkerConfig // How sinks storing chunks split pages
+	LearnBoilerplate bool // Strip lines repeated on most pages of a site, see boilerplateLearner
+	DisableJSFallback bool // Don't retry low-quality static pages with JS rendering
+	KeepCookieBanners bool // Don't dismiss cookie consent banners when rendering with JS
+	Accessibility bool // Audit pages rendered with JS for accessibility issues
+	SecurityReport bool // Record the security headers and TLS certificate of every host, see Crawler.SecurityReport
+	ExcludeLowValue bool    // Leave pages scoring LowValueThreshold or more out of the output, see ExcludedPages
+	LowValueThreshold float64 // 0 for defaultLowValueThreshold
+	FetchRules      []FetchRule // Per-URL-pattern static/JS decisions, overriding EnableJS
+	ContentFilter   ContentFilter // Extensions and content types not downloaded
+	MaxRedirects    int        // Redirects a static fetch follows, 0 for defaultMaxRedirects; negative follows none
+	Traps           TrapLimits // Limits on URLs generated without end, zero fields for the defaults
+	Seeds           []Seed      // Start pages besides StartURL, with their own method and body
+	JSONProjections map[string]string // Name to JSONPath, evaluated on JSON responses into StructuredData["projections"]
+	StripSelectors  []string    // Elements removed before markdown generation, nil for defaultStripSelectors
+	SiteProfiles    []SiteProfile // Per-URL-pattern extraction overrides
+	ContentSelector string      // Container holding the main content; bypasses readability and heuristics where it matches
+	MarkdownHeader  string // text/template of the metadata preamble of every page's markdown, "" for defaultMarkdownHeader
+	MarkdownFooter  string // text/template appended to every page's markdown, "" for none
+	MarkdownFlavor  string // flavorGFM, flavorCommonMark or flavorObsidian, "" for flavorGFM
+	AcceptLanguage  string // Accept-Language sent with every request, e.g. "de-DE,de;q=0.9"
+	Region          string // Exit region of the proxy the crawl goes through, see LEXICRAWLER_PROXIES
+	ProxyURL        string // Proxy of Region
+	FileRoot        string // Directory file:// URLs may be read from; none are read when empty
+	ArchivePath     string // WARC or HAR file to replay instead of fetching from the network
 }
 
 // CrawledData stores the extracted information for a URL
@@ -43,7 +104,14 @@ type CrawledData struct {
 	StructuredData   map[string]interface{}
 	Metadata         map[string]string
 	ScreenshotPath   string
-	RawHTML          string // Optional: For raw data crawling
+	RawHTML          string // The fetched HTML, before readability; only set during extraction and in responses asking for it, see retainRawHTML
+	rawHTMLZstd      []byte // RawHTML compressed, when the crawl keeps it
+	rawHTMLFile      string // Where rawHTMLZstd was spilled to once the crawl exceeded its memory budget, see resultBudget
+	Stats            ContentStats
+	ContentHash      string // Fingerprint of Markdown and Metadata, see contentHash
+	Quality          *ExtractionQuality // Not assessed in metadata-only mode
+	Value            *ValueAssessment   // Low-value score, not assessed in metadata-only mode
+	Diagnostics      PageDiagnostics
 }
 
 // Crawler struct
@@ -51,8 +119,36 @@ type Crawler struct {
 	Config      CrawlerConfig
 	Cache       map[string]*CrawledData // Simple in-memory cache
 	CacheMutex  sync.Mutex
-	VisitedURLs map[string]bool
+	pagesVisited int // Pages taken up by the crawl, counted against MaxPages
 	VisitedMutex sync.Mutex
+	ImageCache  map[string]*ImageAsset // Downloaded favicons/social images by URL
+	ImageMutex  sync.Mutex
+	stopped     atomic.Bool // Set by Stop, aborts all further requests
+	Discovered  map[string]int // Dry-run results: URL to depth
+	DiscoveredMutex sync.Mutex
+	Archive     *ArchiveFetcher // Serves every page from recorded responses when set
+	seedRequests map[string]Seed // Seeds with a method or body, by result key
+	Excluded    []ExcludedPage // Low-value pages left out of the output
+	ExcludedMutex sync.Mutex
+	Security    map[string]*HostSecurity // Security headers and TLS details by host
+	SecurityMutex sync.Mutex
+	HostStats   map[string]*HostStats // Fetch statistics by host
+	HostStatsMutex sync.Mutex
+	Failed      []FailedPage // Pages whose fetch failed, see FailedPages
+	FailedMutex sync.Mutex
+	Skipped     map[string]*SkippedContent // URLs the content filter skipped, by reason and type
+	SkippedMutex sync.Mutex
+	Traps       map[string]*TrapWarning // Crawler traps stayed out of, by kind and pattern
+	trapURLs    map[string]map[string]bool // Links followed per trap pattern, up to its limit
+	TrapsMutex  sync.Mutex
+	jobID       string // Job the crawl runs for, empty for /crawl requests
+	tenantID    string // Tenant that started the crawl, see /debug/crawls
+	bandwidth   bandwidthCounter // Bytes of static fetches
+	usage       usageCounters // CPU, Chrome and wall time, see Usage
+	queue       frontierStore // The frontier of a job, kept across pauses and restarts; nil for a new in-memory one
+	boilerplate *boilerplateLearner // Lines repeated across the pages of each site, nil unless LearnBoilerplate
+	altText     visionBudget // Images described by the vision model
+	imageDataRead visionBudget // Images read for data by the vision model
 }
 
 // NewCrawler creates a new Crawler instance
@@ -60,179 +156,591 @@ func NewCrawler(config CrawlerConfig) *Crawler {
 	return &Crawler{
 		Config:      config,
 		Cache:       make(map[string]*CrawledData),
-		VisitedURLs: make(map[string]bool),
+		ImageCache:  make(map[string]*ImageAsset),
+		Discovered:  make(map[string]int),
+		Security:    make(map[string]*HostSecurity),
+		HostStats:   make(map[string]*HostStats),
 	}
 }
 
 // Crawl starts the crawling process
 func (c *Crawler) Crawl() (map[string]*CrawledData, error) {
+	c.usage.started.Store(time.Now().UnixNano())
+	defer func() { c.usage.finished.Store(time.Now().UnixNano()) }()
+	sinks, err := openSinks(c.Config.Sinks, c.Config.Chunking)
+	if err != nil {
+		return nil, fmt.Errorf("opening sinks: %w", err)
+	}
+	defer closeSinks(sinks)
+	if c.Config.LearnBoilerplate {
+		c.boilerplate = newBoilerplateLearner()
+	}
+
 	allCrawledData := make(map[string]*CrawledData)
+	var allCrawledDataMutex sync.Mutex // Pages are stored by concurrent pipeline workers
+	budget := &resultBudget{limit: c.Config.memoryBudget()}
+	storeCrawledData := func(urlStr string, data *CrawledData) {
+		if data.ContentHash == "" {
+			data.ContentHash = contentHash(data) // Also fills in pages cached by older versions
+		}
+		c.retainRawHTML(data)
+		budget.account(data)
+		allCrawledDataMutex.Lock()
+		allCrawledData[urlStr] = data
+		allCrawledDataMutex.Unlock()
+		for _, sink := range sinks {
+			if err := sink.Write(data); err != nil {
+				log.Printf("Sink write failed for %s: %v", urlStr, err)
+			}
+		}
+	}
 
 	collector := colly.NewCollector(
 		colly.AllowedDomains(c.Config.AllowedDomains...),
-		colly.MaxDepth(c.Config.MaxDepth),
-		colly.Async(),
-		colly.CacheDir("./.crawler_cache"),
+		colly.CacheDir(c.Config.responseCacheDir()),
 		colly.DetectCharset(), // Re-enable charset detection - IMPORTANT
 	)
+	collector.SetRedirectHandler(c.checkRedirect)
+	store := c.queue
+	if store == nil {
+		memory, err := c.Config.newMemoryFrontier()
+		if err != nil {
+			return nil, fmt.Errorf("creating the frontier: %w", err)
+		}
+		defer memory.close()
+		store = memory
+	}
+	scheduler := newFrontier(store, c.Config, c.isAllowedURL, c.isTrapURL)
 
-	collector.OnRequest(func(r *colly.Request) {
-		fmt.Println("Visiting:", r.URL.String())
-		c.VisitedMutex.Lock()
-		c.VisitedURLs[r.URL.String()] = true
-		c.VisitedMutex.Unlock()
-	})
-
-	collector.OnError(func(_ *colly.Response, err error) {
-		log.Println("Error:", err)
-	})
+	// serveCached stores a cached page instead of processing it again
+	serveCached := func(currentURL string, visit func(string) error) bool {
+		if !c.Config.CacheEnabled {
+			return false
+		}
+		cachedData := c.getCachedData(currentURL)
+		if cachedData == nil {
+			return false
+		}
+		fmt.Println("Serving from cache:", currentURL)
+		if c.Config.MetadataOnly {
+			visitLinks(visit, cachedData) // Keep discovering the pages behind cached ones
+		}
+		if c.Config.FollowHreflang {
+			visitAlternates(visit, cachedData)
+		}
+		storeCrawledData(currentURL, cachedData)
+		return true
+	}
 
-	collector.OnHTML("html", func(e *colly.HTMLElement) {
-		currentURL := e.Request.URL.String()
+	// loadPage fetches or renders the page of a job and hands it to extraction
+	loadPage := func(job *pipelineJob) string {
+		fetchStart := time.Now()
+		page, err := job.Fetcher.Fetch(job.URL)
+		var skipped *skippedError
+		if errors.As(err, &skipped) {
+			c.recordSkipped(skipped)
+			return ""
+		}
+		if errors.Is(err, errNotHTML) {
+			return ""
+		}
+		took := time.Since(fetchStart)
+		c.recordFetch(job.URL, page, took, err)
+		if _, headless := job.Fetcher.(*HeadlessFetcher); headless {
+			job.Timings.RenderMs = milliseconds(took)
+		} else {
+			job.Timings.FetchMs = milliseconds(took)
+		}
+		if err != nil {
+			log.Printf("Error fetching %s: %v", job.URL, err)
+			return ""
+		}
+		page.Ruled = job.Ruled
+		page.Visit = job.Visit
+		if c.Config.SecurityReport && c.Archive == nil { // Replayed responses say nothing about the live hosts
+			c.recordSecurity(job.URL, page.Header)
+		}
 
-		if c.Config.CacheEnabled {
-			if cachedData := c.getCachedData(currentURL); cachedData != nil {
-				fmt.Println("Serving from cache:", currentURL)
-				allCrawledData[currentURL] = cachedData
-				return
+		// Dry runs only discover links, page bodies are not processed
+		if c.Config.DryRun {
+			if page.DOM != nil {
+				c.discoverLinks(page, job.Depth)
 			}
+			return ""
 		}
+		job.Page = page
+		return stageExtract
+	}
 
-		crawledData := &CrawledData{
-			URL:            currentURL,
-			StructuredData: make(map[string]interface{}),
-			Metadata:       make(map[string]string),
-		}
+	// The stages of the pipeline, see pipelineStages
+	stages := map[string]stageFunc{
+		stageFetch: func(job *pipelineJob) string {
+			if _, headless := job.Fetcher.(*HeadlessFetcher); headless {
+				return stageRender
+			}
+			return loadPage(job)
+		},
+		stageRender: loadPage,
 
-		var doc *goquery.Document
+		stageExtract: func(job *pipelineJob) string {
+			defer spend(&c.usage.cpu, time.Now())
+			page, currentURL := job.Page, job.URL
 
-		if c.Config.EnableJS {
-			dynamicContent, err := c.fetchDynamicContent(currentURL)
-			if err != nil {
-				log.Printf("Error fetching dynamic content for %s: %v", currentURL, err)
-				return
+			// JSON and other non-HTML documents have their own extraction and no links, media or screenshot
+			if page.DOM == nil {
+				crawledData, err := c.extractDocument(currentURL, page.ContentType, page.Body)
+				if err != nil {
+					log.Printf("Error extracting %s: %v", currentURL, err)
+					c.recordFailure(currentURL, &parseError{err})
+					return ""
+				}
+				job.Data = crawledData
+				return stageSink
 			}
-			crawledData.RawHTML = dynamicContent
-			htmlContentUTF8 := dynamicContent // dynamicContent should already be UTF-8 from fetchDynamicContent
 
-			// Explicitly parse dynamic content as UTF-8 using x/net/html
-			htmlDoc, err := html.Parse(strings.NewReader(htmlContentUTF8))
-			if err != nil {
-				log.Printf("Error parsing dynamic HTML as UTF-8 for %s: %v", currentURL, err)
-				return
+			// Metadata-only mode skips JS, readability, markdown and screenshots entirely
+			if c.Config.MetadataOnly {
+				crawledData := &CrawledData{URL: currentURL, StructuredData: make(map[string]interface{})}
+				crawledData.Metadata = extractMetadata(page.DOM, currentURL)
+				crawledData.StructuredData["links"] = extractLinks(page.DOM, currentURL)
+				extractHreflang(crawledData, page.DOM)
+				c.recordVariant(crawledData, page)
+				visitLinks(page.Visit, crawledData)
+				if c.Config.FollowHreflang {
+					visitAlternates(page.Visit, crawledData)
+				}
+				job.Data = crawledData
+				return stageSink
 			}
-			doc = goquery.NewDocumentFromNode(htmlDoc)
 
-		} else {
-			htmlContentUTF8 := string(e.Response.Body)
-			crawledData.RawHTML = htmlContentUTF8
+			crawledData := c.extractParsedPage(currentURL, page.HTML, page.DOM.Nodes[0]) // Parsed once by the fetcher
+			crawledData.Diagnostics.RenderedWithJS = page.RenderedWithJS
+			job.Data = crawledData
+			if !page.RenderedWithJS && !page.Ruled && !c.Config.DisableJSFallback && crawledData.Quality.Low && crawledData.Metadata["access_wall"] == "" { // Pages a rule keeps static stay static, rendering doesn't lift walls
+				return stageFallback
+			}
+			return stageEnrich
+		},
+
+		stageFallback: func(job *pipelineJob) string {
+			job.Data = c.retryWithJS(job.Data)
+			return stageEnrich
+		},
+
+		stageEnrich: func(job *pipelineJob) string {
+			page, currentURL, crawledData := job.Page, job.URL, job.Data
+			defer recordPhase(&crawledData.Diagnostics.Timings.EnrichMs, time.Now())
+			c.recordVariant(crawledData, page)
+			if c.Config.FollowHreflang {
+				visitAlternates(page.Visit, crawledData)
+			}
+			if page.Accessibility != nil {
+				crawledData.StructuredData["accessibility"] = page.Accessibility
+			}
+			if c.Config.ExcludeLowValue && crawledData.Value.Low {
+				fmt.Printf("Excluding low-value page %s (score %.2f: %s)\n", currentURL, crawledData.Value.Score, strings.Join(crawledData.Value.Signals, ", "))
+				c.recordExcluded(crawledData, *crawledData.Value)
+				return ""
+			}
+			c.stripBoilerplate(crawledData) // Before translation, transcripts and comments
 
-			// Explicitly parse static content as UTF-8 using x/net/html
-			htmlDoc, err := html.Parse(strings.NewReader(htmlContentUTF8))
-			if err != nil {
-				log.Printf("Error parsing static HTML as UTF-8 for %s: %v", currentURL, err)
-				return
+			if c.Config.FetchSiteImages {
+				c.fetchSiteImages(currentURL, crawledData.Metadata, crawledData.StructuredData)
+			}
+			if c.Config.FetchTranscripts || c.Config.TranscribeMedia {
+				c.fetchTranscripts(crawledData, page.DOM)
+			}
+			if c.Config.GenerateAltText {
+				c.describeImages(crawledData)
+			}
+			if c.Config.ExtractImageData {
+				c.extractImageData(crawledData) // After alt text, which can tell charts and tables apart
+			}
+			if c.Config.ExtractComments {
+				c.extractComments(crawledData, page.DOM) // From the unstripped page, comment sections are stripped before conversion
+			}
+			if c.Config.TranslateTo != "" {
+				c.translatePage(crawledData)
+			}
+			if c.Config.EntityRecognizer != "" {
+				c.extractEntities(crawledData) // Of the final text, translated or not
+			}
+			if c.Config.Topics != nil {
+				c.classifyTopics(crawledData)
+			}
+			if c.Config.SentimentAnalyzer != "" {
+				c.analyzeSentiment(crawledData) // After comments are collected, which are scored too
 			}
-			doc = goquery.NewDocumentFromNode(htmlDoc)
-		}
 
-		// --- Readability Integration using go-shiori/go-readability ---
-		if c.Config.EnableReadability {
-			parsedURL, _ := url.Parse(currentURL) // Parse URL for readability
-			article, err := readability.FromReader(strings.NewReader(crawledData.RawHTML), parsedURL)
-			if err != nil {
-				log.Printf("Readability failed for %s: %v. Using raw HTML.", currentURL, err)
-				e.DOM = doc.Selection // Fallback to original doc
-			} else {
-				readabilityHTMLDoc, err := html.Parse(strings.NewReader(article.Content))
+			// 4. Screenshot (Optional), captured while rendering for JS pages
+			if c.Config.EnableScreenshots {
+				screenshot := page.Screenshot
+				var err error
+				if screenshot == nil {
+					screenshot, err = c.captureScreenshot(currentURL)
+				}
+				var screenshotPath string
+				if err == nil {
+					screenshotPath, err = saveScreenshot(screenshot)
+				}
 				if err != nil {
-					log.Printf("Error parsing readability HTML as UTF-8 for %s: %v. Using raw HTML.", currentURL, err)
-					e.DOM = doc.Selection
+					log.Printf("Error capturing screenshot for %s: %v", currentURL, err)
+					return ""
 				} else {
-					e.DOM = goquery.NewDocumentFromNode(readabilityHTMLDoc).Selection // Use readability's cleaned content
-					fmt.Println("Readability applied for:", currentURL)
-					crawledData.RawHTML = article.Content // Update RawHTML with cleaned content
+					crawledData.ScreenshotPath = screenshotPath
+					fmt.Println("Screenshot saved:", screenshotPath)
 				}
 			}
-		} else {
-			e.DOM = doc.Selection // Use the document parsed from raw/dynamic HTML if readability is not enabled
+			return stageSink
+		},
+
+		stageSink: func(job *pipelineJob) string {
+			job.Data.Diagnostics.Timings.add(job.Timings)
+			defer recordPhase(&job.Data.Diagnostics.Timings.SinkMs, time.Now()) // Known after the sinks wrote the page
+			// Cache the data
+			if c.Config.CacheEnabled {
+				c.cacheData(job.URL, job.Data)
+			}
+			storeCrawledData(job.URL, job.Data)
+			return ""
+		},
+	}
+	pipeline := newCrawlPipeline(c.Config, stages)
+	defer pipeline.close()
+	defer trackCrawl(c, pipeline, scheduler)()
+
+	transport, err := sharedTransport(c.Config.ProxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("setting proxy: %w", err)
+	}
+	collector.WithTransport(&decodingTransport{next: transport, counter: &c.bandwidth}) // Shared with the static fetcher's clones, the connections with other crawls too
+	static := NewStaticFetcher(collector, c.Config.requestHeader())
+	static.filter = &c.Config.ContentFilter
+
+	// The frontier schedules the pages: dispatchers lease queued URLs and run their
+	// pages through the pipeline, loaded by the Fetcher chosen for their URL. Links
+	// found on a page are queued once it is done, keeping the crawl's depth and domain
+	// rules. colly only serves the HTTP requests of the static fetcher.
+	crawlPage := func(entry frontierEntry, links *pageLinks) {
+		currentURL := entry.URL
+		if extension, skip := c.Config.ContentFilter.skipsURL(currentURL); skip {
+			c.recordSkipped(&skippedError{reason: skipExtension, value: extension}) // Before it counts against MaxPages
+			return
+		}
+		c.VisitedMutex.Lock()
+		if c.Config.MaxPages > 0 && c.pagesVisited >= c.Config.MaxPages {
+			c.VisitedMutex.Unlock()
+			return
+		}
+		c.pagesVisited++
+		c.VisitedMutex.Unlock()
+		fmt.Println("Visiting:", currentURL)
+		if c.Config.DryRun {
+			c.recordDiscovered(currentURL, entry.Depth)
+		} else if serveCached(currentURL, links.visit) {
+			return
+		}
+
+		fetcher, ruled := c.fetcherFor(currentURL, static)
+		pipeline.process(&pipelineJob{URL: currentURL, Depth: entry.Depth, Visit: links.visit, Fetcher: fetcher, Ruled: ruled})
+	}
+	// finished stops the dispatchers, leaving the rest of the queue for a resumed crawl
+	finished := func() bool {
+		if c.stopped.Load() {
+			return true
 		}
+		c.VisitedMutex.Lock()
+		defer c.VisitedMutex.Unlock()
+		return c.Config.MaxPages > 0 && c.pagesVisited >= c.Config.MaxPages
+	}
 
-		// 1. Metadata Extraction (Enhanced and Corrected)
-		metadata := make(map[string]string) // Create a local metadata map
-		e.DOM.Find("meta").Each(func(_ int, s *goquery.Selection) {
-			nameAttr, nameExists := s.Attr("name")
-			propertyAttr, propertyExists := s.Attr("property")
-			contentAttr, contentExists := s.Attr("content")
+	// A file:// directory is crawled file by file, an archive without a start URL
+	// response by response
+	var seeds []string
+	if c.Config.StartURL != "" {
+		seeds = append(seeds, c.Config.StartURL)
+	}
+	if c.Config.ArchivePath != "" && c.Archive == nil {
+		archive, err := loadArchiveFetcher(c.Config.ArchivePath)
+		if err != nil {
+			return nil, fmt.Errorf("loading archive %s: %w", c.Config.ArchivePath, err)
+		}
+		c.Archive = archive
+	}
+	if c.Archive != nil && c.Config.StartURL == "" {
+		seeds = c.Archive.PageURLs()
+	} else if strings.HasPrefix(c.Config.StartURL, "file://") {
+		files, err := fileSeeds(c.Config.StartURL)
+		if err != nil {
+			return nil, fmt.Errorf("listing %s: %w", c.Config.StartURL, err)
+		}
+		if len(files) > 0 {
+			seeds = files
+		}
+	}
+	c.seedRequests = seedRequests(c.Config.Seeds)
+	for _, seed := range c.Config.Seeds {
+		seeds = append(seeds, seed.key()) // The fetcher sends the method and body of the seed
+	}
+	if err := scheduler.seed(seeds); err != nil {
+		return nil, fmt.Errorf("queueing the start pages: %w", err)
+	}
 
-			if contentExists {
-				if nameExists {
-					metadata[nameAttr] = contentAttr
-				} else if propertyExists {
-					metadata[propertyAttr] = contentAttr // property for OG and other semantic meta
+	var dispatchers sync.WaitGroup
+	for i := 0; i < c.Config.pipelineCapacity(); i++ { // Enough to keep every stage busy
+		dispatchers.Add(1)
+		go func() {
+			defer dispatchers.Done()
+			for {
+				entry, ok := scheduler.next(finished)
+				if !ok {
+					return
 				}
+				links := scheduler.linksOf(entry)
+				crawlPage(entry, links)
+				allCrawledDataMutex.Lock()
+				data := allCrawledData[entry.URL]
+				allCrawledDataMutex.Unlock()
+				scheduler.done(entry, data, links.links())
 			}
-		})
-		metadata["title"] = e.DOM.Find("title").Text()
-		if canonicalURL, ok := e.DOM.Find("link[rel='canonical']").Attr("href"); ok {
-			metadata["canonical_url"] = e.Request.AbsoluteURL(canonicalURL)
-		}
-		if faviconURL, ok := e.DOM.Find("link[rel='icon']").Attr("href"); ok {
-			metadata["favicon_url"] = e.Request.AbsoluteURL(faviconURL)
-		} else if faviconURL, ok := e.DOM.Find("link[rel='shortcut icon']").Attr("href"); ok {
-			metadata["favicon_url"] = e.Request.AbsoluteURL(faviconURL)
-		}
-		crawledData.Metadata = metadata // Assign the populated metadata map
-
-		// 2. Markdown Generation (Enhanced Table Support and Metadata)
-		markdownContent, references := generateMarkdown(e.DOM, currentURL, c.Config, crawledData.Metadata) // Pass metadata
-		crawledData.Markdown = markdownContent
-
-		if len(references) > 0 {
-			crawledData.Markdown += "\n\n**References:**\n"
-			for i, ref := range references {
-				crawledData.Markdown += fmt.Sprintf("[%d] %s\n", i+1, ref)
-			}
-		}
+		}()
+	}
+	dispatchers.Wait()
+	if err := scheduler.failure(); err != nil {
+		return nil, fmt.Errorf("frontier: %w", err)
+	}
+	c.stripLearnedBoilerplate(allCrawledData)
+	return allCrawledData, nil
+}
 
-		// 3. Structured Data Extraction (Example - Extracting blog post titles and links) - Keep Example
-		blogPosts := []map[string]string{}
-		e.DOM.Find(".card-body").Each(func(_ int, s *goquery.Selection) {
-			title := s.Find("h2.card-title a").Text()
-			link, _ := s.Find("h2.card-title a").Attr("href")
-			description := s.Find("h4.card-text").Text()
-			blogPosts = append(blogPosts, map[string]string{"title": title, "link": e.Request.AbsoluteURL(link), "description": description})
-		})
-		crawledData.StructuredData["blog_posts"] = blogPosts
+// extractPage parses the HTML of a page and runs the extraction pipeline on it, see
+// extractParsedPage
+func (c *Crawler) extractPage(currentURL string, rawHTML string) (*CrawledData, error) {
+	// Explicitly parse the content as UTF-8 using x/net/html
+	htmlDoc, err := html.Parse(strings.NewReader(rawHTML))
+	if err != nil {
+		return nil, err
+	}
+	return c.extractParsedPage(currentURL, rawHTML, htmlDoc), nil
+}
 
-		// 4. Screenshot (Optional)
-		if c.Config.EnableScreenshots {
-			screenshotPath, err := c.captureScreenshot(currentURL)
+// extractParsedPage runs the extraction pipeline (readability, metadata, links,
+// markdown, outline, statistics and quality) on a parsed page. Every step shares the
+// one tree instead of parsing the HTML again: readability works on a copy it makes
+// itself and the markdown conversion strips a copy, so the tree is still intact for
+// the steps after extraction (comments, transcripts).
+func (c *Crawler) extractParsedPage(currentURL string, rawHTML string, htmlDoc *html.Node) *CrawledData {
+	crawledData := &CrawledData{
+		URL:            currentURL,
+		StructuredData: make(map[string]interface{}),
+		Metadata:       make(map[string]string),
+		RawHTML:        rawHTML,
+	}
+
+	doc := goquery.NewDocumentFromNode(htmlDoc)
+	var content *goquery.Selection
+	var metadataSource *goquery.Selection
+	config := c.Config
+	shared := true // Content is part of doc
+
+	// A content selector that matches takes precedence over readability and heuristics
+	contentSelector := c.Config.contentSelector(currentURL)
+	if selected := doc.Find(contentSelector); contentSelector != "" && selected.Length() > 0 {
+		content = selected
+		metadataSource = doc.Selection // The selected container rarely holds the head
+		config.HeuristicsEnabled = false
+	} else if c.Config.EnableReadability {
+		if contentSelector != "" {
+			log.Printf("Content selector %q matched nothing on %s, using readability", contentSelector, currentURL)
+		}
+		parsedURL, _ := url.Parse(currentURL) // Parse URL for readability
+		readabilityStart := time.Now()
+		article, err := readability.FromDocument(htmlDoc, parsedURL)
+		recordPhase(&crawledData.Diagnostics.Timings.ReadabilityMs, readabilityStart)
+		if err != nil {
+			log.Printf("Readability failed for %s: %v. Using raw HTML.", currentURL, err)
+			content = doc.Selection // Fallback to original doc
+		} else {
+			// Parsed again, it's small and the parser fixes the nesting readability may leave (headings in paragraphs)
+			readabilityHTMLDoc, err := html.Parse(strings.NewReader(article.Content))
 			if err != nil {
-				log.Printf("Error capturing screenshot for %s: %v", currentURL, err)
-				return
+				log.Printf("Error parsing readability HTML as UTF-8 for %s: %v. Using raw HTML.", currentURL, err)
+				content = doc.Selection
 			} else {
-				crawledData.ScreenshotPath = screenshotPath
-				fmt.Println("Screenshot saved:", screenshotPath)
+				content = goquery.NewDocumentFromNode(readabilityHTMLDoc).Selection // Use readability's cleaned content
+				shared = false
+				fmt.Println("Readability applied for:", currentURL)
 			}
 		}
+	} else {
+		if contentSelector != "" {
+			log.Printf("Content selector %q matched nothing on %s, using the whole page", contentSelector, currentURL)
+		}
+		content = doc.Selection // Use the document parsed from raw/dynamic HTML if readability is not enabled
+	}
+	if metadataSource == nil {
+		metadataSource = content
+	}
 
-		// Cache the data
-		if c.Config.CacheEnabled {
-			c.cacheData(currentURL, crawledData)
+	// 1. Metadata Extraction (Enhanced and Corrected)
+	crawledData.Metadata = extractMetadata(metadataSource, currentURL)
+	if c.Config.DocsVersion != "" {
+		if version := pageDocsVersion(currentURL, crawledData.Metadata); version != "" {
+			crawledData.Metadata["docs_version"] = version
 		}
-		allCrawledData[currentURL] = crawledData
-	})
+	}
+	crawledData.StructuredData["links"] = extractLinks(doc.Selection, currentURL) // From the full page, before readability
+	runPageExtractors(crawledData, doc.Selection) // Product pages etc., from the full page before the strip removes scripts
+	extractHreflang(crawledData, doc.Selection)
+	accessWall := detectAccessWall(doc.Selection) // Reads isAccessibleForFree from scripts the strip removes
+	if c.Config.HarvestContacts && c.isAllowedURL(currentURL) {
+		if contacts := harvestContacts(doc.Selection, currentURL); contacts != nil { // Footers hold most contacts, take them before the strip
+			crawledData.StructuredData["contacts"] = contacts
+		}
+	}
 
-	collector.Visit(c.Config.StartURL)
-	collector.Wait()
-	return allCrawledData, nil
+	// The conversion strips its input, so content of the shared tree is copied first;
+	// quality then compares it with the copy of the page it was stripped in
+	page := doc.Selection
+	if shared {
+		page, content = detachedCopy(doc, content)
+	}
+
+	// 2. Markdown Generation (Enhanced Table Support and Metadata)
+	markdownStart := time.Now()
+	markdownContent, references, media := generateMarkdown(content, currentURL, config, crawledData.Metadata) // Pass metadata
+	recordPhase(&crawledData.Diagnostics.Timings.MarkdownMs, markdownStart)
+	crawledData.Markdown = markdownContent
+	crawledData.StructuredData["media"] = media
+	replaceExtractorBodies(crawledData, config) // Forum threads, post by post
+	appendExtractorSections(crawledData)
+	if accessWall != nil {
+		flagAccessWall(crawledData, accessWall)
+	}
+
+	if len(references) > 0 {
+		crawledData.Markdown += "\n\n**References:**\n"
+		for i, ref := range references {
+			crawledData.Markdown += fmt.Sprintf("[%d] %s\n", i+1, ref)
+		}
+	}
+	outline := buildOutline(crawledData.Markdown)
+	addSourceAnchors(outline, content)
+	crawledData.StructuredData["outline"] = outline
+
+	// Content statistics (computed after boilerplate removal in generateMarkdown)
+	crawledData.Stats = computeContentStats(content)
+	crawledData.Stats.addToMetadata(crawledData.Metadata)
+	if readability, ok := assessReadability(content, crawledData.Metadata["language"]); ok {
+		readability.addToMetadata(crawledData.Metadata)
+	}
+	quality := assessExtractionQuality(page, content, crawledData.Markdown, crawledData.Stats)
+	if accessWall != nil {
+		quality.Flags = append(quality.Flags, accessWall.qualityFlag())
+	}
+	quality.addToMetadata(crawledData.Metadata)
+	crawledData.Quality = &quality
+	value := assessValue(currentURL, content, crawledData.Metadata, crawledData.Stats, quality, c.Config.lowValueThreshold())
+	value.addToMetadata(crawledData.Metadata)
+	crawledData.Value = &value
+
+	// 3. Structured Data Extraction (Example - Extracting blog post titles and links) - Keep Example
+	blogPosts := []map[string]string{}
+	content.Find(".card-body").Each(func(_ int, s *goquery.Selection) {
+		title := s.Find("h2.card-title a").Text()
+		link, _ := s.Find("h2.card-title a").Attr("href")
+		description := s.Find("h4.card-text").Text()
+		blogPosts = append(blogPosts, map[string]string{"title": title, "link": resolveURL(currentURL, link), "description": description})
+	})
+	crawledData.StructuredData["blog_posts"] = blogPosts
+	return crawledData
 }
 
 // getCachedData, cacheData, fetchDynamicContent, captureScreenshot, parseSrcset, resolveURL, applyHeuristics - remain the same
 
 // ... (getCachedData, cacheData, fetchDynamicContent, captureScreenshot, parseSrcset, resolveURL, applyHeuristics functions are the same as before) ...
 
+// extractMetadata collects meta tags, title, canonical and favicon URLs from a document
+func extractMetadata(selection *goquery.Selection, pageURL string) map[string]string {
+	metadata := make(map[string]string)
+	selection.Find("meta").Each(func(_ int, s *goquery.Selection) {
+		nameAttr, nameExists := s.Attr("name")
+		propertyAttr, propertyExists := s.Attr("property")
+		contentAttr, contentExists := s.Attr("content")
+
+		if contentExists {
+			if nameExists {
+				metadata[nameAttr] = contentAttr
+			} else if propertyExists {
+				metadata[propertyAttr] = contentAttr // property for OG and other semantic meta
+			}
+		}
+	})
+	metadata["title"] = selection.Find("title").Text()
+	if language, ok := selection.Find("html").Attr("lang"); ok && strings.TrimSpace(language) != "" {
+		metadata["language"] = strings.TrimSpace(language)
+	} else if language, ok := selection.Find("meta[http-equiv='content-language' i]").Attr("content"); ok {
+		metadata["language"] = strings.TrimSpace(language)
+	}
+	if canonicalURL, ok := selection.Find("link[rel='canonical']").Attr("href"); ok {
+		metadata["canonical_url"] = resolveURL(pageURL, canonicalURL)
+	}
+	if faviconURL, ok := selection.Find("link[rel='icon']").Attr("href"); ok {
+		metadata["favicon_url"] = resolveURL(pageURL, faviconURL)
+	} else if faviconURL, ok := selection.Find("link[rel='shortcut icon']").Attr("href"); ok {
+		metadata["favicon_url"] = resolveURL(pageURL, faviconURL)
+	}
+	return metadata
+}
+
+// Stop aborts all requests that haven't started yet; Crawl then returns the pages
+// completed so far
+func (c *Crawler) Stop() {
+	c.stopped.Store(true)
+}
+
+// Stopped reports whether Stop was called
+func (c *Crawler) Stopped() bool {
+	return c.stopped.Load()
+}
+
+// VisitedCount returns how many pages have been requested so far
+func (c *Crawler) VisitedCount() int {
+	c.VisitedMutex.Lock()
+	defer c.VisitedMutex.Unlock()
+	return c.pagesVisited
+}
+
+// visitLinks queues the links recorded for a page in metadata-only mode
+func visitLinks(visit func(string) error, data *CrawledData) {
+	links, _ := data.StructuredData["links"].([]string)
+	for _, link := range links {
+		visit(link) // Out-of-domain, too deep and already visited links are rejected by the frontier
+	}
+}
+
+// extractLinks returns the unique absolute http(s) links of a document, without
+// fragments; file:// links are kept for local pages
+func extractLinks(selection *goquery.Selection, pageURL string) []string {
+	links := []string{}
+	seen := make(map[string]bool)
+	selection.Find("a[href]").Each(func(_ int, a *goquery.Selection) {
+		href, _ := a.Attr("href")
+		linkURL, err := url.Parse(resolveURL(pageURL, strings.TrimSpace(href)))
+		localLink := linkURL != nil && linkURL.Scheme == "file" && strings.HasPrefix(pageURL, "file://") // Saved pages link to each other
+		if err != nil || (linkURL.Scheme != "http" && linkURL.Scheme != "https" && !localLink) {
+			return
+		}
+		linkURL.Fragment = ""
+		link := linkURL.String()
+		if !seen[link] {
+			seen[link] = true
+			links = append(links, link)
+		}
+	})
+	return links
+}
+
 // getCachedData retrieves data from cache
 func (c *Crawler) getCachedData(urlStr string) *CrawledData {
 	c.CacheMutex.Lock()
@@ -249,36 +757,71 @@ func (c *Crawler) cacheData(urlStr string, data *CrawledData) {
 
 // fetchDynamicContent uses chromedp to fetch content after JS execution
 func (c *Crawler) fetchDynamicContent(urlStr string) (string, error) {
-	ctx, cancel := chromedp.NewContext(context.Background())
-	defer cancel()
+	content, _, err := c.runChrome(urlStr, true, false, nil)
+	return content, err
+}
 
-	var content string
-	err := chromedp.Run(ctx,
-		chromedp.Navigate(urlStr),
-		chromedp.WaitReady("body"),
-		chromedp.OuterHTML("html", &content, chromedp.ByQuery),
-	)
+// renderPage loads a page with chromedp for the JS pipeline, capturing the
+// screenshot in the same navigation when screenshots are enabled
+func (c *Crawler) renderPage(urlStr string) (*fetchedPage, error) {
+	var audit *AccessibilityReport
+	if c.Config.Accessibility {
+		audit = &AccessibilityReport{}
+	}
+	content, screenshot, err := c.runChrome(urlStr, true, c.Config.EnableScreenshots, audit)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	page, err := newFetchedPage(urlStr, content)
+	if err != nil {
+		return nil, err
 	}
-	return content, nil
+	page.RenderedWithJS = true
+	page.Screenshot = screenshot
+	page.Accessibility = audit
+	return page, nil
 }
 
 // captureScreenshot uses chromedp to capture a screenshot
-func (c *Crawler) captureScreenshot(urlStr string) (string, error) {
-	ctx, cancel := chromedp.NewContext(context.Background())
+func (c *Crawler) captureScreenshot(urlStr string) ([]byte, error) {
+	_, screenshot, err := c.runChrome(urlStr, false, true, nil)
+	return screenshot, err
+}
+
+// runChrome navigates a fresh tab to a page once and collects its rendered HTML
+// and/or a screenshot
+func (c *Crawler) runChrome(urlStr string, withHTML bool, withScreenshot bool, audit *AccessibilityReport) (string, []byte, error) {
+	defer spend(&c.usage.chrome, time.Now())
+	ctx, cancel := c.chromeContext()
 	defer cancel()
+	ctx, cancelTimeout := context.WithTimeout(ctx, dynamicFetchTimeout)
+	defer cancelTimeout()
 
-	var buf []byte
-	err := chromedp.Run(ctx,
+	var content string
+	var screenshot []byte
+	actions := []chromedp.Action{
+		c.chromeHeaders(),
 		chromedp.Navigate(urlStr),
 		chromedp.WaitReady("body"),
-		chromedp.CaptureScreenshot(&buf),
-	)
-	if err != nil {
-		return "", err
+		c.dismissConsent(urlStr),
+	}
+	if audit != nil {
+		actions = append(actions, auditAccessibility(audit))
+	}
+	if withHTML {
+		actions = append(actions, chromedp.OuterHTML("html", &content, chromedp.ByQuery))
 	}
+	if withScreenshot {
+		actions = append(actions, chromedp.CaptureScreenshot(&screenshot))
+	}
+	if err := chromedp.Run(ctx, actions...); err != nil {
+		return "", nil, err
+	}
+	return content, screenshot, nil
+}
 
+// saveScreenshot stores a PNG screenshot under ./screenshots and returns its path
+func saveScreenshot(buf []byte) (string, error) {
 	filename := fmt.Sprintf("screenshot_%d.png", time.Now().UnixNano())
 	filepath := filepath.Join("./screenshots", filename)
 	if _, err := os.Stat("./screenshots"); os.IsNotExist(err) {
@@ -292,202 +835,56 @@ func (c *Crawler) captureScreenshot(urlStr string) (string, error) {
 }
 
 // generateMarkdown converts HTML to Markdown
-func generateMarkdown(selection *goquery.Selection, baseURL string, config CrawlerConfig, metadata map[string]string) (string, []string) { // Added metadata param
+func generateMarkdown(selection *goquery.Selection, baseURL string, config CrawlerConfig, metadata map[string]string) (string, []string, []MediaItem) { // Added metadata param
 	var markdownContent strings.Builder
 	var references []string
 
-	// Add Metadata at the beginning of Markdown
-	if title, ok := metadata["title"]; ok && title != "" {
-		markdownContent.WriteString("# " + title + "\n\n")
-	}
-	if description, ok := metadata["description"]; ok && description != "" {
-		markdownContent.WriteString("> " + description + "\n\n")
-	}
-	if keywords, ok := metadata["keywords"]; ok && keywords != "" {
-		markdownContent.WriteString("**Keywords:** " + keywords + "\n\n")
-	}
-	if author, ok := metadata["author"]; ok && author != "" {
-		markdownContent.WriteString("**Author:** " + author + "\n\n")
+	// Add Metadata at the beginning of Markdown, see defaultMarkdownHeader
+	frame := markdownFrame{URL: baseURL, Metadata: metadata}
+	renderMarkdownFrame(&markdownContent, config.markdownHeader(baseURL), frame)
+
+	if stripSelector := config.stripSelector(baseURL); stripSelector != "" {
+		selection.Find(stripSelector).Remove()
 	}
-	if canonicalURL, ok := metadata["canonical_url"]; ok && canonicalURL != "" {
-		markdownContent.WriteString("**Canonical URL:** " + canonicalURL + "\n\n")
+	if config.HeuristicsEnabled {
+		applyHeuristics(selection)
 	}
-	markdownContent.WriteString("---\n\n") // Separator after metadata
 
-	selection.Find("nav, footer, script, style, noscript").Each(func(_ int, s *goquery.Selection) {
-		s.Remove()
-	})
+	media := writeMarkdownBody(selection, baseURL, config.ImageTargetWidth, config.MarkdownFlavor, &markdownContent) // Headings, paragraphs, lists, code, quotes, tables and media in page order
 
-	// Headers
-	selection.Find("h1").Each(func(_ int, s *goquery.Selection) { markdownContent.WriteString("# " + strings.TrimSpace(s.Text()) + "\n\n") })
-	selection.Find("h2").Each(func(_ int, s *goquery.Selection) { markdownContent.WriteString("## " + strings.TrimSpace(s.Text()) + "\n\n") })
-	selection.Find("h3").Each(func(_ int, s *goquery.Selection) { markdownContent.WriteString("### " + strings.TrimSpace(s.Text()) + "\n\n") })
-	selection.Find("h4").Each(func(_ int, s *goquery.Selection) { markdownContent.WriteString("#### " + strings.TrimSpace(s.Text()) + "\n\n") })
-	selection.Find("h5").Each(func(_ int, s *goquery.Selection) { markdownContent.WriteString("##### " + strings.TrimSpace(s.Text()) + "\n\n") })
-	selection.Find("h6").Each(func(_ int, s *goquery.Selection) { markdownContent.WriteString("###### " + strings.TrimSpace(s.Text()) + "\n\n") })
+	renderMarkdownFrame(&markdownContent, config.markdownFooter(baseURL), frame)
 
-	// Paragraphs
-	selection.Find("p").Each(func(_ int, p *goquery.Selection) {
-		paragraphText := strings.TrimSpace(p.Text())
-		if paragraphText != "" {
-			markdownContent.WriteString(paragraphText + "\n\n")
-		}
-	})
-
-	// Lists (Ordered and Unordered)
-	selection.Find("ul").Each(func(_ int, ul *goquery.Selection) {
-		markdownContent.WriteString("\n")
-		ul.Find("li").Each(func(_ int, li *goquery.Selection) {
-			markdownContent.WriteString("* " + strings.TrimSpace(li.Text()) + "\n")
-		})
-		markdownContent.WriteString("\n")
-	})
-
-	selection.Find("ol").Each(func(_ int, ol *goquery.Selection) {
-		markdownContent.WriteString("\n")
-		ol.Find("li").Each(func(i int, li *goquery.Selection) {
-			markdownContent.WriteString(fmt.Sprintf("%d. %s\n", i+1, strings.TrimSpace(li.Text())))
-		})
-		markdownContent.WriteString("\n")
-	})
-
-	// Code Blocks
-	selection.Find("pre code").Each(func(_ int, code *goquery.Selection) {
-		languageClass := ""
-		classes := strings.Fields(code.Parent().AttrOr("class", "")) // Get class from <pre>
-		for _, class := range classes {
-			if strings.HasPrefix(class, "language-") {
-				languageClass = strings.TrimPrefix(class, "language-")
-				break
-			}
-		}
-		codeText := strings.TrimSpace(code.Text())
-		if languageClass != "" {
-			markdownContent.WriteString(fmt.Sprintf("```%s\n%s\n```\n\n", languageClass, codeText))
-		} else {
-			markdownContent.WriteString(fmt.Sprintf("```\n%s\n```\n\n", codeText))
-		}
-	})
-	selection.Find("code").Each(func(_ int, code *goquery.Selection) { // Inline code
-		parentTag := goquery.NodeName(code.Parent())
-		if parentTag != "pre" { // Avoid double rendering of code blocks already handled above
-			markdownContent.WriteString(fmt.Sprintf("`%s`", strings.TrimSpace(code.Text())))
-		}
-	})
-
-	// Blockquotes
-	selection.Find("blockquote").Each(func(_ int, blockquote *goquery.Selection) {
-		markdownContent.WriteString("> " + strings.TrimSpace(blockquote.Text()) + "\n\n")
-	})
-
-	// Tables
-	selection.Find("table").Each(func(_ int, table *goquery.Selection) {
-		markdownContent.WriteString("\n") // Add a newline before the table
-
-		headerRow := table.Find("thead tr").First() // Get the first header row
-		if headerRow.Length() > 0 {
-			markdownContent.WriteString("|")
-			headerRow.Find("th").Each(func(_ int, th *goquery.Selection) {
-				markdownContent.WriteString(strings.TrimSpace(th.Text()) + "|")
-			})
-			markdownContent.WriteString("\n|")
-			headerRow.Find("th").Each(func(_ int, _ *goquery.Selection) {
-				markdownContent.WriteString("---|") // Separator row
-			})
-			markdownContent.WriteString("\n")
-		}
-
-		table.Find("tbody tr").Each(func(_ int, row *goquery.Selection) {
-			markdownContent.WriteString("|")
-			row.Find("td").Each(func(_ int, td *goquery.Selection) {
-				markdownContent.WriteString(strings.TrimSpace(td.Text()) + "|")
-			})
-			markdownContent.WriteString("\n")
-		})
-		markdownContent.WriteString("\n") // Add a newline after the table
-	})
-
-	selection.Find(".card-body").Each(func(_ int, cardBody *goquery.Selection) { // Keep card-body section
-		cardBody.Find("h2.card-title a").Each(func(_ int, titleLink *goquery.Selection) {
-			title := strings.TrimSpace(titleLink.Text())
-			link, _ := titleLink.Attr("href")
-			markdownContent.WriteString("## [" + title + "](" + resolveURL(baseURL, link) + ")\n\n")
-		})
-		cardBody.Find("h4.card-text").Each(func(_ int, desc *goquery.Selection) {
-			description := strings.TrimSpace(desc.Text())
-			markdownContent.WriteString(description + "\n\n")
-		})
-	})
-
-	selection.Find("img").Each(func(_ int, img *goquery.Selection) {
-		altText, _ := img.Attr("alt")
-		src, exists := img.Attr("src")
-		if exists {
-			absoluteSrc := resolveURL(baseURL, src)
-			markdownContent.WriteString(fmt.Sprintf("![%s](%s)\n\n", altText, absoluteSrc))
-		}
-	})
-
-	selection.Find("picture source").Each(func(_ int, source *goquery.Selection) {
-		if srcset, srcsetExists := source.Attr("srcset"); srcsetExists {
-			srcsetURLs := parseSrcset(srcset)
-			for _, srcsetURL := range srcsetURLs {
-				markdownContent.WriteString(fmt.Sprintf("[Image Link](%s)\n\n", resolveURL(baseURL, srcsetURL)))
-			}
-		}
-	})
-	selection.Find("img[srcset]").Each(func(_ int, img *goquery.Selection) { // Handle srcset on img tags directly
-		if srcset, srcsetExists := img.Attr("srcset"); srcsetExists {
-			srcsetURLs := parseSrcset(srcset)
-			for _, srcsetURL := range srcsetURLs {
-				markdownContent.WriteString(fmt.Sprintf("[Image Link](%s)\n\n", resolveURL(baseURL, srcsetURL)))
-			}
-		}
-	})
-
-	selection.Find("audio source, audio").Each(func(_ int, audioElem *goquery.Selection) {
-		src, exists := audioElem.Attr("src")
-		if exists {
-			absoluteSrc := resolveURL(baseURL, src)
-			markdownContent.WriteString(fmt.Sprintf("[Audio Link](%s)\n\n", absoluteSrc))
-		}
-	})
-
-	selection.Find("video source, video").Each(func(_ int, videoElem *goquery.Selection) {
-		src, exists := videoElem.Attr("src")
-		if exists {
-			absoluteSrc := resolveURL(baseURL, src)
-			markdownContent.WriteString(fmt.Sprintf("[Video Link](%s)\n\n", absoluteSrc))
-		}
-	})
-
-	fullMarkdownContent := markdownContent.String()
+	return markdownContent.String(), references, media
+}
 
-	if config.HeuristicsEnabled {
-		filteredMarkdown := applyHeuristics(fullMarkdownContent)
-		markdownContent.Reset()
-		markdownContent.WriteString(filteredMarkdown)
-		fullMarkdownContent = markdownContent.String()
-	}
 
-	markdownContent.Reset()
-	markdownContent.WriteString(fullMarkdownContent)
+// markdownEscaper backslash-escapes characters that carry inline markdown meaning
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	"*", `\*`,
+	"_", `\_`,
+	"|", `\|`,
+	"`", "\\`",
+	"[", `\[`, // Brackets would turn text like [x](javascript:...) into a live link
+	"]", `\]`,
+)
 
-	return markdownContent.String(), references
+// escapeMarkdown escapes extracted text so page content can't corrupt the
+// generated markdown structure (emphasis, links, table cells, code spans, headings)
+func escapeMarkdown(text string) string {
+	return escapeLineStarts(markdownEscaper.Replace(text))
 }
 
-
-// Helper function to parse srcset attribute
-func parseSrcset(srcset string) []string {
-	var urls []string
-	entries := strings.Split(srcset, ",")
-	for _, entry := range entries {
-		parts := strings.Fields(strings.TrimSpace(entry))
-		if len(parts) > 0 {
-			urls = append(urls, strings.TrimSpace(parts[0]))
+// escapeLineStarts escapes line-leading characters of already escaped text
+func escapeLineStarts(escaped string) string {
+	lines := strings.Split(escaped, "\n")
+	for i, line := range lines {
+		trimmed := strings.TrimLeft(line, " \t")
+		if strings.HasPrefix(trimmed, "#") { // Line-leading '#' would turn text into a heading
+			lines[i] = line[:len(line)-len(trimmed)] + `\` + trimmed
 		}
 	}
-	return urls
+	return strings.Join(lines, "\n")
 }
 
 // resolveURL resolves relative URLs to absolute URLs
@@ -503,22 +900,65 @@ func resolveURL(baseURL string, relativeURL string) string {
 	return base.ResolveReference(rel).String()
 }
 
-// applyHeuristics applies basic heuristics to filter markdown content
-func applyHeuristics(markdownContent string) string {
-	var filteredMarkdown strings.Builder
-	paragraphs := strings.Split(markdownContent, "\n\n")
-
-	for _, p := range paragraphs {
-		p = strings.TrimSpace(p)
-		if len(strings.Fields(p)) > 5 {
-			filteredMarkdown.WriteString(p + "\n\n")
+// applyHeuristics removes the text blocks of five words or fewer (paragraphs and
+// quotes such as bylines, share prompts and calls to action) from the content before
+// conversion. Headings, lists, tables, code and media are structure, kept whatever
+// their length, as are blocks holding them.
+func applyHeuristics(selection *goquery.Selection) {
+	selection.Find("p, blockquote").Each(func(_ int, s *goquery.Selection) {
+		if s.Find("h1, h2, h3, h4, h5, h6, ul, ol, table, pre, code, img, picture, audio, video, iframe, lite-youtube").Length() > 0 {
+			return
 		}
-	}
-	return filteredMarkdown.String()
+		if len(strings.Fields(s.Text())) <= 5 {
+			s.Remove()
+		}
+	})
 }
 
 func main() {
+	dir := flag.String("dir", "", "Process a folder of saved HTML files and exit instead of serving the API")
+	archivePath := flag.String("archive", "", "Process the HTML responses of a WARC (.warc, .warc.gz) or HAR file and exit")
+	outDir := flag.String("out", "output", "Where -dir and -archive write the markdown files and report.json")
+	dirReadability := flag.Bool("readability", false, "Apply readability to the pages processed with -dir or -archive")
+	profile := flag.Bool("pprof", false, "Serve the Go profiler under /debug/pprof, behind the API key check")
+	debug := flag.Bool("debug", false, "Serve /debug/crawls and /debug/goroutines, behind the API key check")
+	flag.Parse()
+	if *archivePath != "" {
+		if err := runArchiveCrawl(*archivePath, *outDir, CrawlerConfig{EnableReadability: *dirReadability}); err != nil {
+			log.Fatalf("Processing %s failed: %v", *archivePath, err)
+		}
+		return
+	}
+	if *dir != "" {
+		if err := runDirCrawl(*dir, *outDir, CrawlerConfig{EnableReadability: *dirReadability}); err != nil {
+			log.Fatalf("Processing %s failed: %v", *dir, err)
+		}
+		return
+	}
+
+	tenants, err := LoadTenantRegistry()
+	if err != nil {
+		fiberlog.Fatalf("Loading tenants failed: %v", err)
+	}
+	corpora, err := NewCorpusManager()
+	if err != nil {
+		fiberlog.Fatalf("Loading corpora failed: %v", err)
+	}
+	jobs := NewJobManager(maxRunningJobsFromEnv(), corpora)
+	if err := jobs.Restore(tenants); err != nil {
+		fiberlog.Fatalf("Restoring jobs failed: %v", err)
+	}
+
 	app := fiber.New()
+	registerUIRoutes(app) // Before the API key check, the UI itself is public
+	app.Use(tenants.Middleware())
+	registerMetricsRoute(app)
+	if *profile {
+		app.Use(pprof.New())
+	}
+	if *debug {
+		registerDebugRoutes(app)
+	}
 
 	app.Get("/crawl", func(c *fiber.Ctx) error {
 		startURL := c.Query("url")
@@ -530,8 +970,27 @@ func main() {
 		if err != nil {
 			return c.Status(fiber.StatusBadRequest).SendString("Invalid URL provided")
 		}
+		if parsedURL.Scheme == "file" {
+			if err := checkFileURL(parsedURL, fileRootFromEnv()); err != nil {
+				return c.Status(fiber.StatusForbidden).SendString(err.Error())
+			}
+		}
 
 		enableReadability := c.QueryBool("readability")
+		fetchSiteImages := c.QueryBool("images")
+		mode := c.Query("mode", "full")
+		if mode != "full" && mode != "metadata" && mode != "dryrun" {
+			return c.Status(fiber.StatusBadRequest).SendString("Invalid mode, expected full, metadata or dryrun")
+		}
+		// Dry runs and metadata-only crawls answer in JSON unless NDJSON is asked for
+		format, err := negotiateFormat(c, formatMarkdown, formatJSON, formatText, formatNDJSON)
+		if err != nil {
+			return c.Status(negotiationStatus(err)).SendString(err.Error())
+		}
+		flavor := c.Query("flavor")
+		if err := validateMarkdownFlavor(flavor); err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+		}
 
 		config := CrawlerConfig{
 			StartURL:        startURL,
@@ -542,24 +1001,138 @@ func main() {
 			CacheEnabled:    false,
 			HeuristicsEnabled: false,
 			EnableReadability: enableReadability,
+			FetchSiteImages: fetchSiteImages,
+			MetadataOnly:    mode == "metadata",
+			DryRun:          mode == "dryrun",
+			DisableJSFallback: !c.QueryBool("js_fallback", true),
+			KeepRawHTML:     c.QueryBool("raw_html"),
+			MarkdownFlavor:  flavor,
+			FileRoot:        fileRootFromEnv(),
 		}
 
 		crawler := NewCrawler(config)
+		crawler.tenantID = tenantFromCtx(c).ID
 		crawledDataMap, err := crawler.Crawl()
 		if err != nil {
 			fiberlog.Errorf("Crawler failed: %v", err)
 			return c.Status(fiber.StatusInternalServerError).SendString("Crawling failed")
 		}
+		defer removeSpilled(crawledDataMap)
+
+		if config.DryRun {
+			if format == formatNDJSON {
+				return sendNDJSON(c, crawler.DiscoveredURLs())
+			}
+			return sendJSON(c, fiber.Map{"urls": crawler.DiscoveredURLs()})
+		}
+
+		// Metadata-only crawls are site inventories, so every page is returned
+		if config.MetadataOnly {
+			pages := make([]*CrawledData, 0, len(crawledDataMap))
+			for _, data := range crawledDataMap {
+				pages = append(pages, data)
+			}
+			sort.Slice(pages, func(i, j int) bool { return pages[i].URL < pages[j].URL })
+			if format == formatNDJSON {
+				return sendNDJSON(c, pages)
+			}
+			return sendJSON(c, fiber.Map{"pages": pages})
+		}
 
 		data, ok := crawledDataMap[startURL]
 		if !ok {
 			return c.Status(fiber.StatusNotFound).SendString("No data crawled for the given URL")
 		}
 
-		c.Set("Content-Type", "text/markdown")
+		switch format {
+		case formatJSON, formatNDJSON:
+			if config.KeepRawHTML {
+				if data, err = data.withRawHTML(); err != nil {
+					return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+				}
+			}
+			result := fiber.Map{
+				"page":   data,
+				"report": crawler.Report(startURL, crawledDataMap),
+			}
+			if format == formatNDJSON {
+				return sendNDJSON(c, []fiber.Map{result})
+			}
+			return sendJSON(c, result)
+		case formatText:
+			return sendFormat(c, formatText, renderMarkdownText(data.Markdown))
+		}
+
 		// c.Set("Content-Disposition", "inline; filename=\"crawled_content.md\"") // Removed Content-Disposition
-		return c.SendString(data.Markdown)
+		return sendFormat(c, formatMarkdown, data.Markdown)
 	})
 
+	app.Get("/preview", func(c *fiber.Ctx) error {
+		pageURL := c.Query("url")
+		if pageURL == "" {
+			return c.Status(fiber.StatusBadRequest).SendString("Please provide a URL as a query parameter, e.g., /preview?url=https://example.com")
+		}
+		if _, err := url.ParseRequestURI(pageURL); err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString("Invalid URL provided")
+		}
+
+		preview, err := fetchLinkPreview(pageURL, c.QueryBool("images"))
+		if err != nil {
+			fiberlog.Errorf("Preview failed for %s: %v", pageURL, err)
+			return c.Status(fiber.StatusBadGateway).SendString("Failed to fetch preview")
+		}
+		return c.JSON(preview)
+	})
+
+	app.Get("/robots", func(c *fiber.Ctx) error {
+		siteURL := c.Query("url")
+		if _, err := url.ParseRequestURI(siteURL); err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString("Please provide a valid URL as a query parameter, e.g., /robots?url=https://example.com")
+		}
+
+		rules, err := fetchRobots(siteURL)
+		if err != nil {
+			fiberlog.Errorf("robots.txt fetch failed for %s: %v", siteURL, err)
+			return c.Status(fiber.StatusBadGateway).SendString("Failed to fetch robots.txt")
+		}
+		return c.JSON(rules)
+	})
+
+	app.Get("/sitemap", func(c *fiber.Ctx) error {
+		siteURL := c.Query("url")
+		if _, err := url.ParseRequestURI(siteURL); err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString("Please provide a valid URL as a query parameter, e.g., /sitemap?url=https://example.com")
+		}
+
+		sitemaps, err := fetchSitemaps(siteURL)
+		if err != nil {
+			fiberlog.Errorf("Sitemap fetch failed for %s: %v", siteURL, err)
+			return c.Status(fiber.StatusBadGateway).SendString("Failed to fetch sitemap")
+		}
+		return c.JSON(sitemaps)
+	})
+
+	app.Post("/jobs/estimate", func(c *fiber.Ctx) error {
+		var request CrawlRequest
+		if err := c.BodyParser(&request); err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString("Invalid request body")
+		}
+		config, err := request.toConfig()
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+		}
+
+		estimate, err := EstimateCrawl(config)
+		if err != nil {
+			fiberlog.Errorf("Estimate failed for %s: %v", config.StartURL, err)
+			return c.Status(fiber.StatusInternalServerError).SendString("Estimation failed")
+		}
+		return c.JSON(estimate)
+	})
+
+	registerJobRoutes(app, jobs)
+	registerCorpusRoutes(app, corpora, jobs)
+	corpora.StartSweeps(jobs, tenants)
+
 	fiberlog.Fatal(app.Listen(":3000"))
 }
\ No newline at end of file
@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestEscapeMarkdown(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"plain text", "plain text"},
+		{"*bold* and _em_", `\*bold\* and \_em\_`},
+		{"a | b", `a \| b`},
+		{"`code`", "\\`code\\`"},
+		{`back\slash`, `back\\slash`},
+		{"# not a heading", `\# not a heading`},
+		{"[x](javascript:alert(1))", `\[x\](javascript:alert(1))`},
+		{"![img](https://example.com/a.png)", `!\[img\](https://example.com/a.png)`},
+		{"see [1] and [2]", `see \[1\] and \[2\]`},
+	}
+	for _, test := range tests {
+		if got := escapeMarkdown(test.text); got != test.want {
+			t.Errorf("escapeMarkdown(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+// markdownWriter converts an HTML subtree to markdown in document order, so
+// headings, paragraphs, lists, code and media keep the order they have on the page
+type markdownWriter struct {
+	baseURL     string
+	targetWidth int    // Preferred srcset candidate width, 0 for the largest
+	flavor      string // Markdown flavor, see flavor.go
+	out         *strings.Builder
+	inTable     bool       // Pipes in code spans must be escaped inside table cells
+	lineBreak   string     // What a <br> becomes in the current block, a space where lines can't break
+	skip        *html.Node // Rendered already, as the title of a callout
+	mediaItems  []MediaItem
+}
+
+// writeMarkdownBody appends the markdown of the content elements of selection to out,
+// in the given flavor, and returns the media it references
+func writeMarkdownBody(selection *goquery.Selection, baseURL string, targetWidth int, flavor string, out *strings.Builder) []MediaItem {
+	w := &markdownWriter{baseURL: baseURL, targetWidth: targetWidth, flavor: flavor, out: out, mediaItems: []MediaItem{}}
+	for _, node := range selection.Nodes {
+		w.block(node)
+	}
+	return w.mediaItems
+}
+
+// block emits the markdown of a node; text outside the handled block elements is skipped
+func (w *markdownWriter) block(node *html.Node) {
+	if node.Type == html.DocumentNode {
+		w.children(node)
+		return
+	}
+	if node.Type != html.ElementNode || node == w.skip {
+		return
+	}
+	if kind := calloutKind(node); kind != "" {
+		w.callout(node, kind)
+		return
+	}
+	if hasClass(node, "card-body") {
+		w.card(node)
+		w.mediaIn(node)
+		return
+	}
+
+	switch node.Data {
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		level := int(node.Data[1] - '0')
+		w.out.WriteString(strings.Repeat("#", level) + " " + w.inline(node) + "\n\n")
+		w.mediaIn(node)
+	case "p":
+		if text := w.inlineLines(node, ""); text != "" {
+			w.out.WriteString(text + "\n\n")
+		}
+		w.mediaIn(node)
+	case "ul", "ol":
+		w.out.WriteString("\n")
+		w.list(node, 0)
+		w.out.WriteString("\n")
+		w.mediaIn(node)
+	case "pre":
+		w.codeBlock(node)
+	case "blockquote":
+		w.out.WriteString("> " + w.inlineLines(node, "> ") + "\n\n")
+		w.mediaIn(node)
+	case "table":
+		w.table(node)
+		w.mediaIn(node)
+	case "img", "picture", "audio", "video", "iframe", "lite-youtube":
+		w.media(node)
+	default:
+		w.children(node)
+	}
+}
+
+// children emits the blocks of a node's children
+func (w *markdownWriter) children(node *html.Node) {
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		w.block(child)
+	}
+}
+
+// inline renders the text of a node with code spans, escaped and trimmed. Nested
+// lists are left to list and media to mediaIn. A <br> becomes w.lineBreak.
+func (w *markdownWriter) inline(node *html.Node) string {
+	var walk func(*html.Node, *strings.Builder)
+	walk = func(n *html.Node, text *strings.Builder) {
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			switch {
+			case child.Type == html.TextNode:
+				data := child.Data
+				if w.flavor == flavorObsidian {
+					data = strings.ReplaceAll(data, "\n", " ") // Source line wraps would break lines in Obsidian
+				}
+				text.WriteString(markdownEscaper.Replace(data))
+			case child.Type != html.ElementNode:
+			case child.Data == "code" || child.Data == "kbd" || child.Data == "samp":
+				span := codeSpan(nodeText(child))
+				if w.inTable {
+					span = strings.ReplaceAll(span, "|", `\|`)
+				}
+				text.WriteString(span)
+			case child.Data == "br":
+				text.WriteString(lineBreakMarker)
+			case child.Data == "a" && w.flavor == flavorObsidian:
+				var label strings.Builder
+				walk(child, &label)
+				href, _ := attr(child, "href")
+				if wikilink := w.wikilink(label.String(), href); wikilink != "" {
+					text.WriteString(wikilink)
+				} else {
+					text.WriteString(label.String())
+				}
+			case child.Data == "ul" || child.Data == "ol":
+			default:
+				walk(child, text)
+			}
+		}
+	}
+	var text strings.Builder
+	walk(node, &text)
+	lineBreak := w.lineBreak
+	if lineBreak == "" {
+		lineBreak = " "
+	}
+	trimmed := strings.TrimFunc(text.String(), func(r rune) bool { return unicode.IsSpace(r) || r == 0 })
+	return escapeLineStarts(strings.ReplaceAll(trimmed, lineBreakMarker, lineBreak))
+}
+
+// list emits the items of a ul or ol, indenting nested lists below their item
+func (w *markdownWriter) list(node *html.Node, depth int) {
+	indent := strings.Repeat("  ", depth)
+	number := 0
+	for item := node.FirstChild; item != nil; item = item.NextSibling {
+		if item.Type != html.ElementNode || item.Data != "li" {
+			continue
+		}
+		number++
+		marker := "* "
+		if node.Data == "ol" {
+			marker = fmt.Sprintf("%d. ", number)
+		}
+		w.out.WriteString(indent + marker + w.inlineLines(item, indent+strings.Repeat(" ", len(marker))) + "\n")
+
+		var nested func(*html.Node)
+		nested = func(n *html.Node) {
+			for child := n.FirstChild; child != nil; child = child.NextSibling {
+				if child.Type == html.ElementNode && (child.Data == "ul" || child.Data == "ol") {
+					w.list(child, depth+1)
+				} else {
+					nested(child)
+				}
+			}
+		}
+		nested(item)
+	}
+}
+
+// codeBlock emits a pre element as a fenced code block. The fence is longer than
+// any backtick run in the code, so code containing ``` can't end the block early.
+func (w *markdownWriter) codeBlock(node *html.Node) {
+	language := codeLanguage(node)
+	for child := node.FirstChild; child != nil && language == ""; child = child.NextSibling {
+		if child.Type == html.ElementNode && child.Data == "code" {
+			language = codeLanguage(child)
+		}
+	}
+	code := strings.TrimRight(strings.Trim(nodeText(node), "\n"), " \t\n")
+	if code == "" {
+		return
+	}
+	fence := strings.Repeat("`", max(3, longestRun(code, '`')+1))
+	w.out.WriteString(fence + language + "\n" + code + "\n" + fence + "\n\n")
+}
+
+// table emits a table with its thead header row and tbody rows
+func (w *markdownWriter) table(node *html.Node) {
+	if w.flavor == flavorCommonMark {
+		w.htmlTable(node)
+		return
+	}
+	table := goquery.NewDocumentFromNode(node).Selection
+	w.inTable, w.lineBreak = true, "<br>"
+	defer func() { w.inTable, w.lineBreak = false, "" }()
+	w.out.WriteString("\n") // Add a newline before the table
+
+	headerRow := table.Find("thead tr").First() // Get the first header row
+	if headerRow.Length() > 0 {
+		w.out.WriteString("|")
+		headerRow.Find("th").Each(func(_ int, th *goquery.Selection) {
+			w.out.WriteString(w.inline(th.Nodes[0]) + "|")
+		})
+		w.out.WriteString("\n|")
+		headerRow.Find("th").Each(func(_ int, _ *goquery.Selection) {
+			w.out.WriteString("---|") // Separator row
+		})
+		w.out.WriteString("\n")
+	}
+
+	table.Find("tbody tr").Each(func(_ int, row *goquery.Selection) {
+		w.out.WriteString("|")
+		row.Find("td").Each(func(_ int, td *goquery.Selection) {
+			w.out.WriteString(strings.ReplaceAll(w.inline(td.Nodes[0]), "\n", " ") + "|")
+		})
+		w.out.WriteString("\n")
+	})
+	w.out.WriteString("\n") // Add a newline after the table
+}
+
+// card emits a blog listing card as a linked heading followed by its description
+func (w *markdownWriter) card(node *html.Node) {
+	cardBody := goquery.NewDocumentFromNode(node).Selection
+	cardBody.Find("h2.card-title a").Each(func(_ int, titleLink *goquery.Selection) {
+		title := escapeMarkdown(strings.TrimSpace(titleLink.Text()))
+		link, _ := titleLink.Attr("href")
+		if wikilink := w.wikilink(title, link); wikilink != "" {
+			w.out.WriteString("## " + wikilink + "\n\n")
+			return
+		}
+		w.out.WriteString("## [" + title + "](" + resolveURL(w.baseURL, link) + ")\n\n")
+	})
+	cardBody.Find("h4.card-text").Each(func(_ int, desc *goquery.Selection) {
+		w.out.WriteString(escapeMarkdown(strings.TrimSpace(desc.Text())) + "\n\n")
+	})
+}
+
+// mediaIn emits the images, audio and video inside a text block after its text
+func (w *markdownWriter) mediaIn(node *html.Node) {
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type != html.ElementNode {
+			continue
+		}
+		switch child.Data {
+		case "img", "picture", "audio", "video", "iframe", "lite-youtube":
+			w.media(child)
+		default:
+			w.mediaIn(child)
+		}
+	}
+}
+
+// media emits an image, picture, audio or video element
+func (w *markdownWriter) media(node *html.Node) {
+	switch node.Data {
+	case "img":
+		w.image(node, nil)
+	case "picture":
+		var img *html.Node
+		var sources []*html.Node
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type == html.ElementNode && child.Data == "source" {
+				sources = append(sources, child)
+			} else if child.Type == html.ElementNode && child.Data == "img" && img == nil {
+				img = child
+			}
+		}
+		w.image(img, sources)
+	case "audio", "video", "iframe", "lite-youtube":
+		if item := playerItem(node, w.baseURL); item != nil {
+			w.out.WriteString(item.markdown())
+			w.mediaItems = append(w.mediaItems, *item)
+		}
+	}
+}
+
+// image emits a single image reference for an img and the sources of its picture
+// element, choosing among their srcset candidates; img may be nil
+func (w *markdownWriter) image(img *html.Node, sources []*html.Node) {
+	var candidates []ImageCandidate
+	for _, element := range append(sources, img) {
+		if element == nil {
+			continue
+		}
+		if srcset := lazyAttr(element, "srcset", lazySrcsetAttrs); srcset != "" {
+			for _, candidate := range parseSrcset(srcset, w.baseURL) {
+				if !isPlaceholderImage(candidate.URL) {
+					candidates = append(candidates, candidate)
+				}
+			}
+		}
+	}
+
+	var src, alt string
+	hasAlt := false
+	if img != nil {
+		src = lazyAttr(img, "src", lazySrcAttrs)
+		alt, hasAlt = attr(img, "alt")
+	}
+	if src != "" {
+		src = resolveURL(w.baseURL, src)
+	}
+	if len(candidates) > 0 {
+		src = bestCandidate(candidates, w.targetWidth).URL
+	}
+	if src == "" || isPlaceholderImage(src) {
+		return // A lazy-loading placeholder without a resolvable real image
+	}
+	w.out.WriteString(fmt.Sprintf("![%s](%s)\n\n", escapeMarkdown(alt), src))
+	w.mediaItems = append(w.mediaItems, MediaItem{Type: "image", URL: src, Alt: alt, Candidates: candidates, altMissing: !hasAlt})
+}
+
+// codeSpan wraps inline code in a backtick run longer than any run inside it,
+// padding with spaces when the code starts or ends with a backtick
+func codeSpan(code string) string {
+	code = strings.Join(strings.Fields(code), " ") // Code spans can't span paragraphs
+	if code == "" {
+		return ""
+	}
+	fence := strings.Repeat("`", longestRun(code, '`')+1)
+	if strings.HasPrefix(code, "`") || strings.HasSuffix(code, "`") {
+		code = " " + code + " "
+	}
+	return fence + code + fence
+}
+
+// codeLanguage returns the language of a language-xxx or lang-xxx class
+func codeLanguage(node *html.Node) string {
+	class, _ := attr(node, "class")
+	for _, name := range strings.Fields(class) {
+		for _, prefix := range []string{"language-", "lang-"} {
+			if strings.HasPrefix(name, prefix) {
+				return strings.TrimPrefix(name, prefix)
+			}
+		}
+	}
+	return ""
+}
+
+// longestRun returns the length of the longest run of r in s
+func longestRun(s string, r byte) int {
+	longest, current := 0, 0
+	for i := 0; i < len(s); i++ {
+		if s[i] == r {
+			current++
+			longest = max(longest, current)
+		} else {
+			current = 0
+		}
+	}
+	return longest
+}
+
+// nodeText returns the text content of a node
+func nodeText(node *html.Node) string {
+	return goquery.NewDocumentFromNode(node).Text()
+}
+
+// attr returns the value of an attribute of an element node
+func attr(node *html.Node, name string) (string, bool) {
+	for _, attribute := range node.Attr {
+		if attribute.Key == name {
+			return attribute.Val, true
+		}
+	}
+	return "", false
+}
+
+// hasClass reports whether an element has the given class
+func hasClass(node *html.Node, class string) bool {
+	classes, _ := attr(node, "class")
+	for _, name := range strings.Fields(classes) {
+		if name == class {
+			return true
+		}
+	}
+	return false
+}
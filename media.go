@@ -0,0 +1,350 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// ImageCandidate is one entry of a srcset attribute
+type ImageCandidate struct {
+	URL     string  `json:"url"`
+	Width   int     `json:"width,omitempty"`   // From a "640w" descriptor
+	Density float64 `json:"density,omitempty"` // From a "2x" descriptor, 1 when there is none
+}
+
+// MediaItem is an image, video or audio element of a page, collected into the
+// "media" structured data in page order
+type MediaItem struct {
+	Type         string           `json:"type"` // "image", "video" or "audio"
+	URL          string           `json:"url"`  // The URL referenced in the markdown
+	Alt          string           `json:"alt,omitempty"`
+	AltGenerated bool             `json:"alt_generated,omitempty"` // Alt is described by a vision model, see describeImages
+	Candidates   []ImageCandidate `json:"candidates,omitempty"`    // Every srcset candidate the URL was chosen from
+
+	Platform string        `json:"platform,omitempty"` // "youtube" or "vimeo" for embedded players
+	Title    string        `json:"title,omitempty"`
+	Poster   string        `json:"poster,omitempty"`
+	Duration float64       `json:"duration,omitempty"` // Seconds, when the page states it
+	Sources  []MediaSource `json:"sources,omitempty"`
+	Tracks   []MediaTrack  `json:"tracks,omitempty"` // Captions, subtitles and chapters
+
+	altMissing bool // The image has no alt attribute, see describeImages
+}
+
+// MediaSource is a source file of a video or audio element
+type MediaSource struct {
+	URL  string `json:"url"`
+	Type string `json:"type,omitempty"`
+}
+
+// MediaTrack is a timed text track of a video or audio element
+type MediaTrack struct {
+	URL   string `json:"url"`
+	Kind  string `json:"kind,omitempty"` // "captions", "subtitles", "chapters", ...
+	Lang  string `json:"lang,omitempty"`
+	Label string `json:"label,omitempty"`
+}
+
+// parseSrcset parses a srcset attribute into candidates with absolute URLs. URLs
+// may contain commas (e.g. data URIs), so candidates are split on the comma that
+// follows the descriptors rather than on every comma.
+func parseSrcset(srcset string, baseURL string) []ImageCandidate {
+	var candidates []ImageCandidate
+	rest := srcset
+	for {
+		rest = strings.TrimLeft(rest, " \t\n\r\f,")
+		if rest == "" {
+			return candidates
+		}
+		end := strings.IndexAny(rest, " \t\n\r\f")
+		if end < 0 {
+			end = len(rest)
+		}
+		rawURL := rest[:end]
+		rest = rest[end:]
+
+		descriptors := ""
+		if trimmed := strings.TrimRight(rawURL, ","); trimmed != rawURL {
+			rawURL = trimmed // "a.jpg," has no descriptors
+		} else if comma := strings.Index(rest, ","); comma >= 0 {
+			descriptors, rest = rest[:comma], rest[comma+1:]
+		} else {
+			descriptors, rest = rest, ""
+		}
+
+		candidate := ImageCandidate{URL: resolveURL(baseURL, rawURL), Density: 1}
+		for _, descriptor := range strings.Fields(descriptors) {
+			value := descriptor[:len(descriptor)-1]
+			switch descriptor[len(descriptor)-1] {
+			case 'w':
+				if width, err := strconv.Atoi(value); err == nil {
+					candidate.Width = width
+					candidate.Density = 0
+				}
+			case 'x':
+				if density, err := strconv.ParseFloat(value, 64); err == nil {
+					candidate.Density = density
+				}
+			}
+		}
+		candidates = append(candidates, candidate)
+	}
+}
+
+// bestCandidate picks the image to reference: with a target width, the narrowest
+// candidate at least that wide, otherwise (or when none is wide enough) the widest,
+// or the highest density when the candidates have no widths
+func bestCandidate(candidates []ImageCandidate, targetWidth int) ImageCandidate {
+	best := candidates[0]
+	for _, candidate := range candidates[1:] {
+		switch {
+		case candidate.Width > 0 || best.Width > 0:
+			bestFits := targetWidth > 0 && best.Width >= targetWidth
+			candidateFits := targetWidth > 0 && candidate.Width >= targetWidth
+			if (candidateFits && (!bestFits || candidate.Width < best.Width)) || (!bestFits && !candidateFits && candidate.Width > best.Width) {
+				best = candidate
+			}
+		case candidate.Density > best.Density:
+			best = candidate
+		}
+	}
+	return best
+}
+
+// Attributes lazy-loading libraries (lazysizes, lozad, WordPress, jQuery lazyload)
+// keep the real image in until the image scrolls into view
+var (
+	lazySrcAttrs    = []string{"data-src", "data-lazy-src", "data-original", "data-lazy", "data-url"}
+	lazySrcsetAttrs = []string{"data-srcset", "data-lazy-srcset"}
+)
+
+// lazyAttr returns the first non-empty lazy-loading attribute of an element,
+// falling back to the regular attribute
+func lazyAttr(node *html.Node, name string, lazyNames []string) string {
+	for _, lazyName := range lazyNames {
+		if value, ok := attr(node, lazyName); ok && strings.TrimSpace(value) != "" {
+			return strings.TrimSpace(value)
+		}
+	}
+	value, _ := attr(node, name)
+	return strings.TrimSpace(value)
+}
+
+// placeholderNames are file name fragments of the transparent images sites show
+// until a lazy image loads
+var placeholderNames = []string{"blank.", "spacer.", "placeholder", "transparent.", "pixel.gif", "1x1.", "lazy.", "loading."}
+
+// isPlaceholderImage reports whether an image URL is an inline or well-known
+// placeholder rather than content
+func isPlaceholderImage(imageURL string) bool {
+	if strings.HasPrefix(imageURL, "data:") {
+		return len(imageURL) < 1024 // Inline 1px GIFs and empty SVGs, real images are much larger
+	}
+	parsedURL, err := url.Parse(imageURL)
+	if err != nil {
+		return false
+	}
+	name := strings.ToLower(path.Base(parsedURL.Path))
+	for _, fragment := range placeholderNames {
+		if strings.Contains(name, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	youtubeIDPattern  = regexp.MustCompile(`^/(?:embed|v|shorts)/([A-Za-z0-9_-]{11})`)
+	vimeoIDPattern    = regexp.MustCompile(`^/(?:video/)?(\d+)`)
+	isoDurationFormat = regexp.MustCompile(`^P(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+(?:\.\d+)?)S)?)?$`)
+)
+
+// playerItem describes a video or audio element, a YouTube or Vimeo iframe or a
+// lite-youtube element, or returns nil when it references nothing
+func playerItem(node *html.Node, baseURL string) *MediaItem {
+	item := &MediaItem{Type: node.Data}
+	item.Title, _ = attr(node, "title")
+	if item.Title == "" {
+		item.Title, _ = attr(node, "aria-label")
+	}
+
+	switch node.Data {
+	case "iframe":
+		src := lazyAttr(node, "src", lazySrcAttrs)
+		if src == "" {
+			return nil
+		}
+		item.Type = "video"
+		item.Platform, item.URL, item.Poster = embedPlayer(resolveURL(baseURL, src))
+		if item.Platform == "" {
+			return nil // Other iframes (ads, maps, widgets) aren't media
+		}
+	case "lite-youtube":
+		videoID, _ := attr(node, "videoid")
+		if videoID == "" {
+			return nil
+		}
+		item.Type = "video"
+		item.Platform, item.URL, item.Poster = embedPlayer("https://www.youtube.com/embed/" + videoID)
+	default:
+		if src := lazyAttr(node, "src", lazySrcAttrs); src != "" {
+			item.Sources = append(item.Sources, MediaSource{URL: resolveURL(baseURL, src)})
+		}
+		if poster := lazyAttr(node, "poster", []string{"data-poster"}); poster != "" {
+			item.Poster = resolveURL(baseURL, poster)
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type != html.ElementNode {
+				continue
+			}
+			src := lazyAttr(child, "src", lazySrcAttrs)
+			switch {
+			case src == "":
+			case child.Data == "source":
+				sourceType, _ := attr(child, "type")
+				item.Sources = append(item.Sources, MediaSource{URL: resolveURL(baseURL, src), Type: sourceType})
+			case child.Data == "track":
+				track := MediaTrack{URL: resolveURL(baseURL, src)}
+				track.Kind, _ = attr(child, "kind")
+				track.Lang, _ = attr(child, "srclang")
+				track.Label, _ = attr(child, "label")
+				if track.Kind == "" {
+					track.Kind = "subtitles" // The HTML default
+				}
+				item.Tracks = append(item.Tracks, track)
+			}
+		}
+		if len(item.Sources) == 0 {
+			return nil
+		}
+		item.URL = item.Sources[0].URL
+	}
+	item.Duration = mediaDuration(node)
+	return item
+}
+
+// embedPlayer recognizes YouTube and Vimeo player URLs and returns the platform,
+// the canonical watch URL and, for YouTube, the thumbnail
+func embedPlayer(playerURL string) (platform string, watchURL string, thumbnail string) {
+	parsedURL, err := url.Parse(playerURL)
+	if err != nil {
+		return "", "", ""
+	}
+	host := strings.TrimPrefix(strings.ToLower(parsedURL.Hostname()), "www.")
+	switch host {
+	case "youtube.com", "m.youtube.com", "youtube-nocookie.com":
+		if match := youtubeIDPattern.FindStringSubmatch(parsedURL.Path); match != nil {
+			return "youtube", "https://www.youtube.com/watch?v=" + match[1], "https://i.ytimg.com/vi/" + match[1] + "/hqdefault.jpg"
+		}
+	case "youtu.be":
+		if videoID := strings.Trim(parsedURL.Path, "/"); videoID != "" {
+			return "youtube", "https://www.youtube.com/watch?v=" + videoID, "https://i.ytimg.com/vi/" + videoID + "/hqdefault.jpg"
+		}
+	case "player.vimeo.com", "vimeo.com":
+		if match := vimeoIDPattern.FindStringSubmatch(parsedURL.Path); match != nil {
+			return "vimeo", "https://vimeo.com/" + match[1], ""
+		}
+	}
+	return "", "", ""
+}
+
+// mediaDuration reads a duration in seconds from a data-duration attribute or a
+// schema.org duration property on the element or its parent; 0 when there is none
+func mediaDuration(node *html.Node) float64 {
+	if value, ok := attr(node, "data-duration"); ok {
+		return parseMediaDuration(value)
+	}
+	if node.Parent == nil {
+		return 0
+	}
+	for sibling := node.Parent.FirstChild; sibling != nil; sibling = sibling.NextSibling {
+		if property, _ := attr(sibling, "itemprop"); sibling.Type == html.ElementNode && property == "duration" {
+			content, _ := attr(sibling, "content")
+			return parseMediaDuration(content)
+		}
+	}
+	return 0
+}
+
+// parseMediaDuration parses seconds ("93", "93.5"), clock time ("1:33", "1:02:03")
+// or an ISO 8601 duration ("PT1M33S")
+func parseMediaDuration(value string) float64 {
+	value = strings.TrimSpace(value)
+	if match := isoDurationFormat.FindStringSubmatch(value); match != nil && value != "P" {
+		var seconds float64
+		for i, unit := range []float64{86400, 3600, 60, 1} {
+			if part, err := strconv.ParseFloat(match[i+1], 64); err == nil {
+				seconds += part * unit
+			}
+		}
+		return seconds
+	}
+	var seconds float64
+	for _, part := range strings.Split(value, ":") {
+		number, err := strconv.ParseFloat(part, 64)
+		if err != nil || number < 0 {
+			return 0
+		}
+		seconds = seconds*60 + number
+	}
+	return seconds
+}
+
+// formatDuration formats seconds as m:ss or h:mm:ss
+func formatDuration(seconds float64) string {
+	total := int(seconds + 0.5)
+	if total >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", total/3600, total%3600/60, total%60)
+	}
+	return fmt.Sprintf("%d:%02d", total/60, total%60)
+}
+
+// markdown renders a video or audio item as a media block: a labelled link followed
+// by its poster, duration, alternative sources and text tracks
+func (item *MediaItem) markdown() string {
+	var block strings.Builder
+	label := "Video"
+	if item.Type == "audio" {
+		label = "Audio"
+	}
+	if item.Platform != "" {
+		label += " (" + map[string]string{"youtube": "YouTube", "vimeo": "Vimeo"}[item.Platform] + ")"
+	}
+	title := item.Title
+	if title == "" {
+		title = item.URL
+	}
+	block.WriteString(fmt.Sprintf("**%s:** [%s](%s)\n", label, escapeMarkdown(title), item.URL))
+	if item.Poster != "" {
+		block.WriteString(fmt.Sprintf("* Poster: ![poster](%s)\n", item.Poster))
+	}
+	if item.Duration > 0 {
+		block.WriteString("* Duration: " + formatDuration(item.Duration) + "\n")
+	}
+	for _, source := range item.Sources[min(1, len(item.Sources)):] {
+		name := source.Type
+		if name == "" {
+			name = path.Base(source.URL)
+		}
+		block.WriteString(fmt.Sprintf("* Source: [%s](%s)\n", escapeMarkdown(name), source.URL))
+	}
+	for _, track := range item.Tracks {
+		name := track.Label
+		switch {
+		case name != "" && track.Lang != "":
+			name += " (" + track.Lang + ")"
+		case track.Lang != "":
+			name = track.Lang
+		case name == "":
+			name = path.Base(track.URL)
+		}
+		block.WriteString(fmt.Sprintf("* %s: [%s](%s)\n", strings.ToUpper(track.Kind[:1])+track.Kind[1:], escapeMarkdown(name), track.URL))
+	}
+	return block.String() + "\n"
+}
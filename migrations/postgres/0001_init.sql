@@ -0,0 +1,38 @@
+CREATE TABLE pages (
+    id              BIGSERIAL PRIMARY KEY,
+    url             TEXT NOT NULL UNIQUE,
+    title           TEXT NOT NULL DEFAULT '',
+    markdown        TEXT NOT NULL DEFAULT '',
+    screenshot_path TEXT NOT NULL DEFAULT '',
+    structured_data JSONB NOT NULL DEFAULT '{}',
+    word_count      INTEGER NOT NULL DEFAULT 0,
+    link_count      INTEGER NOT NULL DEFAULT 0,
+    image_count     INTEGER NOT NULL DEFAULT 0,
+    crawled_at      TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE page_metadata (
+    page_id BIGINT NOT NULL REFERENCES pages (id) ON DELETE CASCADE,
+    key     TEXT NOT NULL,
+    value   TEXT NOT NULL,
+    PRIMARY KEY (page_id, key)
+);
+
+CREATE TABLE links (
+    page_id    BIGINT NOT NULL REFERENCES pages (id) ON DELETE CASCADE,
+    target_url TEXT NOT NULL,
+    position   INTEGER NOT NULL,
+    PRIMARY KEY (page_id, position)
+);
+
+CREATE INDEX links_target_url_idx ON links (target_url);
+
+CREATE TABLE chunks (
+    page_id      BIGINT NOT NULL REFERENCES pages (id) ON DELETE CASCADE,
+    chunk_index  INTEGER NOT NULL,
+    heading_path TEXT[] NOT NULL DEFAULT '{}',
+    content      TEXT NOT NULL,
+    start_offset INTEGER NOT NULL,
+    end_offset   INTEGER NOT NULL,
+    PRIMARY KEY (page_id, chunk_index)
+);
@@ -0,0 +1 @@
+ALTER TABLE pages ADD COLUMN content_hash TEXT NOT NULL DEFAULT '';
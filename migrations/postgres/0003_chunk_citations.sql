@@ -0,0 +1,2 @@
+ALTER TABLE chunks ADD COLUMN citation_url TEXT NOT NULL DEFAULT '';
+ALTER TABLE chunks ADD COLUMN anchor TEXT NOT NULL DEFAULT '';
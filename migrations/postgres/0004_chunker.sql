@@ -0,0 +1 @@
+ALTER TABLE chunks ADD COLUMN chunker TEXT NOT NULL DEFAULT 'headings';
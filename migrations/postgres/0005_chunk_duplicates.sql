@@ -0,0 +1,2 @@
+ALTER TABLE chunks ADD COLUMN duplicate_of_url TEXT NOT NULL DEFAULT '';
+ALTER TABLE chunks ADD COLUMN duplicate_of_index INTEGER;
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Response formats of the endpoints serving page content, chosen by the format query
+// parameter or the Accept header
+const (
+	formatMarkdown = "markdown"
+	formatJSON     = "json"
+	formatText     = "text"
+	formatNDJSON   = "ndjson"
+)
+
+// formatContentTypes maps response formats to their media types
+var formatContentTypes = map[string]string{
+	formatMarkdown: "text/markdown",
+	formatJSON:     "application/json",
+	formatText:     "text/plain",
+	formatNDJSON:   "application/x-ndjson",
+}
+
+// errNotAcceptable is returned when the Accept header rules out every format
+var errNotAcceptable = errors.New("none of the accepted media types can be served")
+
+// negotiateFormat picks the format of a response among those an endpoint offers, the
+// first being its default: the format query parameter when given, else the best
+// match of the Accept header. Responses vary by Accept either way.
+func negotiateFormat(c *fiber.Ctx, offers ...string) (string, error) {
+	c.Vary(fiber.HeaderAccept)
+	if format := c.Query("format"); format != "" {
+		for _, offer := range offers {
+			if format == offer {
+				return format, nil
+			}
+		}
+		return "", fmt.Errorf("invalid format, expected %s", strings.Join(offers, ", "))
+	}
+	mediaTypes := make([]string, len(offers))
+	for i, offer := range offers {
+		mediaTypes[i] = formatContentTypes[offer]
+	}
+	accepted := c.Accepts(mediaTypes...)
+	for i, mediaType := range mediaTypes {
+		if accepted == mediaType {
+			return offers[i], nil
+		}
+	}
+	return "", fmt.Errorf("%w, expected %s", errNotAcceptable, strings.Join(mediaTypes, ", "))
+}
+
+// negotiationStatus returns the status of a failed negotiation: 406 when the Accept
+// header didn't match, 400 for an unknown format parameter
+func negotiationStatus(err error) int {
+	if errors.Is(err, errNotAcceptable) {
+		return fiber.StatusNotAcceptable
+	}
+	return fiber.StatusBadRequest
+}
+
+// sendFormat sets the content type of a format, in UTF-8, and sends the body
+func sendFormat(c *fiber.Ctx, format string, body string) error {
+	c.Set(fiber.HeaderContentType, formatContentTypes[format]+"; charset=utf-8")
+	return c.SendString(body)
+}
+
+// sendJSON sends a value as JSON, declaring its UTF-8 charset
+func sendJSON(c *fiber.Ctx, value interface{}) error {
+	return c.JSON(value, formatContentTypes[formatJSON]+"; charset=utf-8")
+}
+
+// sendNDJSON sends values as newline-delimited JSON, one per line
+func sendNDJSON[T any](c *fiber.Ctx, values []T) error {
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	for _, value := range values {
+		if err := encoder.Encode(value); err != nil {
+			return err
+		}
+	}
+	return sendFormat(c, formatNDJSON, body.String())
+}
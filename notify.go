@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	fiberlog "github.com/gofiber/fiber/v2/log"
+)
+
+// Notification target types of NotifyTarget.Type
+const (
+	notifyWebhook = "webhook" // POSTs the notification as JSON
+	notifySlack   = "slack"   // A Slack incoming webhook
+	notifyDiscord = "discord" // A Discord channel webhook
+)
+
+// Notification events of NotifyTarget.Events
+const (
+	notifyCompleted = "completed" // A job completed, with a summary of its crawl
+	notifyFailed    = "failed"    // A job failed
+	notifyAlerts    = "alerts"    // Alert rules fired, see AlertConfig
+)
+
+// Limits of notifications
+const (
+	maxNotifyTargets     = 10
+	maxNotificationLines = 20   // Alert hits and failed pages listed in a chat message, the rest are counted
+	maxDiscordChars      = 2000 // Discord rejects longer messages
+)
+
+// notifyClient delivers notifications; slow receivers don't hold up their job for long
+var notifyClient = &http.Client{Timeout: 10 * time.Second}
+
+// NotifyTarget is where a job's notifications are delivered
+type NotifyTarget struct {
+	Type   string   `json:"type"` // "webhook", "slack" or "discord"
+	URL    string   `json:"url"`
+	Events []string `json:"events,omitempty"` // "completed", "failed" and "alerts"; all by default
+}
+
+// validate checks the type, URL and events of a target
+func (target NotifyTarget) validate() error {
+	switch target.Type {
+	case notifyWebhook, notifySlack, notifyDiscord:
+	default:
+		return fmt.Errorf("unknown notify type %q (want webhook, slack or discord)", target.Type)
+	}
+	parsedURL, err := url.Parse(target.URL)
+	if err != nil || (parsedURL.Scheme != "https" && parsedURL.Scheme != "http") || parsedURL.Host == "" {
+		return fmt.Errorf("invalid %s notify url", target.Type)
+	}
+	for _, event := range target.Events {
+		switch event {
+		case notifyCompleted, notifyFailed, notifyAlerts:
+		default:
+			return fmt.Errorf("unknown notify event %q (want completed, failed or alerts)", event)
+		}
+	}
+	return nil
+}
+
+// wants reports whether a target is notified of an event
+func (target NotifyTarget) wants(event string) bool {
+	return len(target.Events) == 0 || containsString(target.Events, event)
+}
+
+// publicURLFromEnv returns the URL the API is reachable at (LEXICRAWLER_PUBLIC_URL),
+// which notifications link to; without it they carry API paths
+func publicURLFromEnv() string {
+	return strings.TrimRight(os.Getenv("LEXICRAWLER_PUBLIC_URL"), "/")
+}
+
+// NotificationLinks point from a notification back to the API
+type NotificationLinks struct {
+	UI      string `json:"ui"`                // The job in the web UI
+	Job     string `json:"job"`               // The job with its crawl report
+	Pages   string `json:"pages,omitempty"`   // Its pages, once completed
+	Compare string `json:"compare,omitempty"` // The comparison with the crawl alerts were evaluated against
+}
+
+// jobLinks returns the links of a job's notifications
+func jobLinks(job Job) NotificationLinks {
+	base := publicURLFromEnv()
+	links := NotificationLinks{UI: base + "/ui/#job=" + job.ID, Job: base + "/jobs/" + job.ID}
+	if job.Status == JobCompleted {
+		links.Pages = base + "/jobs/" + job.ID + "/pages"
+	}
+	if job.Alerts != nil && job.Alerts.Against != "" {
+		links.Compare = base + "/compare?" + url.Values{"jobA": {job.Alerts.Against}, "jobB": {job.ID}}.Encode()
+	}
+	return links
+}
+
+// jobNotification is the JSON body webhooks receive when a job completes or fails
+type jobNotification struct {
+	Event    string            `json:"event"` // "completed" or "failed"
+	JobID    string            `json:"job_id"`
+	URL      string            `json:"url,omitempty"`    // Start URL of the job
+	Corpus   string            `json:"corpus,omitempty"` // Corpus the job adds to, e.g. of a scheduled recrawl
+	Error    string            `json:"error,omitempty"`
+	Pages    int               `json:"pages"`
+	Failed   []FailedPage      `json:"failed,omitempty"`
+	Alerts   int               `json:"alerts"`
+	Duration float64           `json:"duration_seconds"`
+	Links    NotificationLinks `json:"links"`
+}
+
+// alertNotification is the JSON body webhooks receive for the alert hits of a job
+type alertNotification struct {
+	Event   string            `json:"event"` // "alerts"
+	JobID   string            `json:"job_id"`
+	URL     string            `json:"url,omitempty"`
+	Corpus  string            `json:"corpus,omitempty"`
+	Against string            `json:"against"`
+	Alerts  []AlertHit        `json:"alerts"`
+	Links   NotificationLinks `json:"links"`
+}
+
+// notifyFinished notifies the targets of a job that just completed or failed, in
+// the background
+func (m *JobManager) notifyFinished(job *Job) {
+	if len(job.Request.Notify) == 0 {
+		return
+	}
+	m.mutex.Lock()
+	snapshot := job.snapshot()
+	m.mutex.Unlock()
+	go notifyJob(snapshot)
+}
+
+// notifyJob delivers the summary of a finished job, and its alert hits, to its
+// targets; failures are logged
+func notifyJob(job Job) {
+	event := notifyCompleted
+	if job.Status == JobFailed {
+		event = notifyFailed
+	}
+	links := jobLinks(job)
+	for _, target := range job.Request.Notify {
+		if target.wants(event) {
+			var payload interface{}
+			switch target.Type {
+			case notifyWebhook:
+				payload = summaryNotification(job, event, links)
+			default:
+				payload = chatPayload(target.Type, summaryText(target.Type, job, links))
+			}
+			if err := postNotification(target.URL, payload); err != nil {
+				fiberlog.Errorf("Notifying %s that job %s %s failed: %v", target.Type, job.ID, event, err)
+			}
+		}
+		if job.Alerts != nil && len(job.Alerts.Hits) > 0 && target.wants(notifyAlerts) {
+			var payload interface{}
+			switch target.Type {
+			case notifyWebhook:
+				payload = alertNotification{Event: notifyAlerts, JobID: job.ID, URL: job.Request.URL, Corpus: job.Request.Corpus,
+					Against: job.Alerts.Against, Alerts: job.Alerts.Hits, Links: links}
+			default:
+				payload = chatPayload(target.Type, alertText(target.Type, job, links))
+			}
+			if err := postNotification(target.URL, payload); err != nil {
+				fiberlog.Errorf("Notifying %s of the alerts of job %s failed: %v", target.Type, job.ID, err)
+			}
+		}
+	}
+}
+
+// summaryNotification returns the webhook body of a finished job
+func summaryNotification(job Job, event string, links NotificationLinks) jobNotification {
+	notification := jobNotification{Event: event, JobID: job.ID, URL: job.Request.URL, Corpus: job.Request.Corpus,
+		Error: job.Error, Pages: job.PagesVisited, Duration: jobDuration(job).Seconds(), Links: links}
+	if job.Report != nil {
+		notification.Failed = job.Report.Failed
+	}
+	if job.Alerts != nil {
+		notification.Alerts = len(job.Alerts.Hits)
+	}
+	return notification
+}
+
+// jobDuration returns how long a finished job took, to the second
+func jobDuration(job Job) time.Duration {
+	if job.StartedAt == nil || job.FinishedAt == nil {
+		return 0
+	}
+	return job.FinishedAt.Sub(*job.StartedAt).Round(time.Second)
+}
+
+// jobName names a job in a chat message: its ID with its start URL, else its corpus
+func jobName(job Job) string {
+	switch {
+	case job.Request.URL != "":
+		return job.ID + " of " + job.Request.URL
+	case job.Request.Corpus != "":
+		return job.ID + " of corpus " + job.Request.Corpus
+	}
+	return job.ID
+}
+
+// summaryText summarizes a finished job for a Slack or Discord message
+func summaryText(platform string, job Job, links NotificationLinks) string {
+	message := &chatMessage{platform: platform}
+	if job.Status == JobFailed {
+		message.printf("Crawl %s failed after %s: %s\n", jobName(job), jobDuration(job), job.Error)
+	} else {
+		var failed []FailedPage
+		if job.Report != nil {
+			failed = job.Report.Failed
+		}
+		message.printf("Crawl %s completed in %s: %d pages, %d failed", jobName(job), jobDuration(job), job.PagesVisited, len(failed))
+		if job.Alerts != nil && len(job.Alerts.Hits) > 0 {
+			message.printf(", %d alerts", len(job.Alerts.Hits))
+		}
+		message.printf("\n")
+		for i, page := range failed {
+			if i == maxNotificationLines {
+				message.printf("and %d more\n", len(failed)-i)
+				break
+			}
+			message.printf("• %s: %s\n", page.URL, firstNonEmpty(page.Error, fmt.Sprint(page.Status)))
+		}
+	}
+	message.links(links)
+	return message.String()
+}
+
+// alertText lists the alert hits of a job for a Slack or Discord message
+func alertText(platform string, job Job, links NotificationLinks) string {
+	message := &chatMessage{platform: platform}
+	message.printf("%d alerts for crawl %s (compared with %s):\n", len(job.Alerts.Hits), jobName(job), job.Alerts.Against)
+	for i, hit := range job.Alerts.Hits {
+		if i == maxNotificationLines {
+			message.printf("and %d more\n", len(job.Alerts.Hits)-i)
+			break
+		}
+		message.printf("• [%s] %s: %s\n", hit.Rule, hit.URL, hit.Message)
+	}
+	message.links(links)
+	return message.String()
+}
+
+// chatMessage is the text of a Slack or Discord message
+type chatMessage struct {
+	platform string
+	text     strings.Builder
+}
+
+// slackEscaper escapes the characters Slack reads as markup
+var slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// printf adds formatted text to a message
+func (message *chatMessage) printf(format string, args ...interface{}) {
+	text := fmt.Sprintf(format, args...)
+	if message.platform == notifySlack {
+		text = slackEscaper.Replace(text)
+	}
+	message.text.WriteString(text)
+}
+
+// links adds the links of a notification as a line. API paths, without a public
+// URL, are given as they are since chat apps can't link them.
+func (message *chatMessage) links(links NotificationLinks) {
+	var parts []string
+	for _, link := range []struct{ label, target string }{{"Open in UI", links.UI}, {"Report", links.Job}, {"Pages", links.Pages}, {"Comparison", links.Compare}} {
+		switch {
+		case link.target == "":
+		case !strings.HasPrefix(link.target, "http"):
+			parts = append(parts, link.label+": "+link.target)
+		case message.platform == notifySlack:
+			parts = append(parts, "<"+link.target+"|"+link.label+">")
+		default:
+			parts = append(parts, "["+link.label+"]("+link.target+")")
+		}
+	}
+	message.text.WriteString(strings.Join(parts, " · "))
+}
+
+// String returns the text of a message
+func (message *chatMessage) String() string {
+	return strings.TrimRight(message.text.String(), "\n")
+}
+
+// chatPayload returns the webhook body of a Slack or Discord message
+func chatPayload(platform string, text string) interface{} {
+	if platform == notifyDiscord {
+		if runes := []rune(text); len(runes) > maxDiscordChars {
+			text = string(runes[:maxDiscordChars-1]) + "…"
+		}
+		return map[string]string{"content": text}
+	}
+	return map[string]string{"text": text}
+}
+
+// postNotification POSTs a JSON payload to a webhook, which must accept it with a 2xx
+func postNotification(targetURL string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	resp, err := notifyClient.Post(targetURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s returned %d: %s", targetURL, resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
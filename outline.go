@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// OutlineNode is a single heading in a page's document outline
+type OutlineNode struct {
+	Level        int            `json:"level"`
+	Text         string         `json:"text"`
+	Anchor       string         `json:"anchor"`
+	SourceAnchor string         `json:"source_anchor,omitempty"` // ID of the heading on the source page, see addSourceAnchors
+	Start        int            `json:"start"`                   // Character offset of the heading line in the page markdown
+	End          int            `json:"end"`                     // Character offset where the heading's section ends
+	Children     []*OutlineNode `json:"children,omitempty"`
+}
+
+var atxHeadingPattern = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+
+// markdownUnescaper reverses escapeMarkdown for display text
+var markdownUnescaper = strings.NewReplacer(`\\`, `\`, `\*`, "*", `\_`, "_", `\|`, "|", "\\`", "`", `\[`, "[", `\]`, "]", `\#`, "#")
+
+// buildOutline derives a heading tree from generated markdown so downstream
+// chunkers and navigation UIs don't need to re-parse it
+func buildOutline(markdown string) []*OutlineNode {
+	var roots []*OutlineNode
+	var stack []*OutlineNode
+	anchors := make(map[string]int)
+	fence := "" // Opening fence of the code block being skipped
+	offset := 0
+	totalLength := utf8.RuneCountInString(markdown)
+
+	for _, line := range strings.SplitAfter(markdown, "\n") {
+		lineStart := offset
+		offset += utf8.RuneCountInString(line)
+
+		trimmed := strings.TrimRight(line, "\r\n")
+		if run := strings.TrimSpace(trimmed); strings.HasPrefix(run, "```") {
+			run = run[:len(run)-len(strings.TrimLeft(run, "`"))]
+			switch {
+			case fence == "":
+				fence = run
+			case len(run) >= len(fence) && strings.TrimSpace(trimmed) == run: // A closing fence is at least as long and has no info string
+				fence = ""
+			}
+			continue
+		}
+		if fence != "" {
+			continue
+		}
+		match := atxHeadingPattern.FindStringSubmatch(trimmed)
+		if match == nil || match[2] == "" {
+			continue
+		}
+
+		node := &OutlineNode{
+			Level: len(match[1]),
+			Text:  markdownUnescaper.Replace(match[2]),
+			Start: lineStart,
+			End:   totalLength,
+		}
+		node.Anchor = uniqueAnchor(slugify(node.Text), anchors)
+
+		// Close every open section at the same or a deeper level
+		for len(stack) > 0 && stack[len(stack)-1].Level >= node.Level {
+			stack[len(stack)-1].End = lineStart
+			stack = stack[:len(stack)-1]
+		}
+		if len(stack) == 0 {
+			roots = append(roots, node)
+		} else {
+			parent := stack[len(stack)-1]
+			parent.Children = append(parent.Children, node)
+		}
+		stack = append(stack, node)
+	}
+	return roots
+}
+
+// slugify produces a GitHub-style anchor for heading text
+func slugify(text string) string {
+	var slug strings.Builder
+	for _, r := range strings.ToLower(strings.TrimSpace(text)) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsNumber(r) || r == '-' || r == '_':
+			slug.WriteRune(r)
+		case unicode.IsSpace(r):
+			slug.WriteRune('-')
+		}
+	}
+	return slug.String()
+}
+
+// uniqueAnchor de-duplicates anchors the same way GitHub does (name, name-1, name-2, ...)
+func uniqueAnchor(anchor string, seen map[string]int) string {
+	count, exists := seen[anchor]
+	seen[anchor] = count + 1
+	if !exists {
+		return anchor
+	}
+	return fmt.Sprintf("%s-%d", anchor, count)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// maxOutputTemplates bounds the output templates of a job
+const maxOutputTemplates = 16
+
+// exportTemplatePrefix starts the export format of an OutputTemplate, see format
+const exportTemplatePrefix = "template-"
+
+// outputTemplateName restricts names to what is safe in export file names
+var outputTemplateName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// OutputTemplate renders a completed job into a custom output, e.g. an llms.txt-style
+// digest, an XML feed or a summary card per page, from the pages' extraction results
+type OutputTemplate struct {
+	Name      string `json:"name"`      // Selects the template in exports, lower case letters, digits, - and _
+	Template  string `json:"template"`  // Go text/template source, see outputData
+	Extension string `json:"extension"` // Of the exported file, which also sets its content type; default txt
+}
+
+// outputData is what output templates see: the whole job when it is exported, one
+// page (also the only one in Pages) when a page is rendered
+type outputData struct {
+	Job    outputJob
+	Pages  []outputPage // By URL
+	Page   *outputPage  // nil in exports
+	Report *CrawlReport
+}
+
+// outputJob describes the job a template renders
+type outputJob struct {
+	ID         string
+	StartURL   string
+	CreatedAt  time.Time
+	FinishedAt *time.Time
+}
+
+// outputPage is a page of the job with its page ID, as used by the page endpoints
+type outputPage struct {
+	ID string
+	*CrawledData
+}
+
+// format returns the export format of the template
+func (t OutputTemplate) format() string {
+	extension := t.Extension
+	if extension == "" {
+		extension = "txt"
+	}
+	return exportTemplatePrefix + t.Name + "." + extension
+}
+
+// validateOutputTemplates checks the names, extensions and sources of a job's
+// output templates, rendering each on a job with an empty page
+func validateOutputTemplates(templates []OutputTemplate) error {
+	if len(templates) > maxOutputTemplates {
+		return fmt.Errorf("at most %d output_templates", maxOutputTemplates)
+	}
+	names := make(map[string]bool)
+	for _, output := range templates {
+		if !outputTemplateName.MatchString(output.Name) {
+			return fmt.Errorf("output template name %q must be lower case letters, digits, - and _", output.Name)
+		}
+		if names[output.Name] {
+			return fmt.Errorf("output template %q is defined twice", output.Name)
+		}
+		names[output.Name] = true
+		if output.Extension != "" && !outputTemplateName.MatchString(output.Extension) {
+			return fmt.Errorf("output template %q: invalid extension %q", output.Name, output.Extension)
+		}
+		tmpl, err := parseTemplate(output.Template)
+		if err == nil {
+			sample := outputPage{CrawledData: &CrawledData{Metadata: map[string]string{}, StructuredData: map[string]interface{}{}}}
+			err = tmpl.Execute(io.Discard, outputData{Pages: []outputPage{sample}, Page: &sample, Report: &CrawlReport{}})
+		}
+		if err != nil {
+			return fmt.Errorf("output template %q: %w", output.Name, err)
+		}
+	}
+	return nil
+}
+
+// Errors of rendering a job with an output template
+var (
+	errNoOutputTemplate = errors.New("no output template of that name")
+	errTemplateFailed   = errors.New("rendering the output template failed")
+)
+
+// outputTemplate returns the output template of a job by name
+func (j *Job) outputTemplate(name string) (OutputTemplate, error) {
+	for _, output := range j.Request.OutputTemplates {
+		if output.Name == name {
+			return output, nil
+		}
+	}
+	return OutputTemplate{}, errNoOutputTemplate
+}
+
+// newOutputData prepares the results of a job for its templates
+func newOutputData(job Job, results map[string]*CrawledData) outputData {
+	data := outputData{
+		Job:    outputJob{ID: job.ID, StartURL: job.config.StartURL, CreatedAt: job.CreatedAt, FinishedAt: job.FinishedAt},
+		Pages:  make([]outputPage, 0, len(results)),
+		Report: job.Report,
+	}
+	for _, pageURL := range sortedResultURLs(results) {
+		data.Pages = append(data.Pages, outputPage{ID: pageID(pageURL), CrawledData: results[pageURL]})
+	}
+	return data
+}
+
+// writeOutputTemplate renders a template over the results of a job
+func writeOutputTemplate(w io.Writer, output OutputTemplate, job Job, results map[string]*CrawledData) error {
+	tmpl, err := parseTemplate(output.Template)
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(w, newOutputData(job, results))
+}
+
+// renderPageTemplate renders a template for one page of a job
+func renderPageTemplate(output OutputTemplate, job Job, pageURL string, page *CrawledData) (string, error) {
+	tmpl, err := parseTemplate(output.Template)
+	if err != nil {
+		return "", err
+	}
+	data := newOutputData(job, map[string]*CrawledData{pageURL: page})
+	data.Page = &data.Pages[0]
+	var rendered strings.Builder
+	if err := tmpl.Execute(&rendered, data); err != nil {
+		return "", err
+	}
+	return rendered.String(), nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"io"
+	"net/url"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// parquetRowGroupSize bounds how many rows are buffered before a row group is flushed
+const parquetRowGroupSize = 10000
+
+// parquetPage is a row of the page-level Parquet table
+type parquetPage struct {
+	JobID              string            `parquet:"job_id,dict"`
+	PageID             string            `parquet:"page_id"`
+	URL                string            `parquet:"url"`
+	ContentHash        string            `parquet:"content_hash"`
+	Host               string            `parquet:"host,dict"`
+	Title              string            `parquet:"title"`
+	Description        string            `parquet:"description"`
+	Markdown           string            `parquet:"markdown"`
+	Words              int64             `parquet:"word_count"`
+	Sentences          int64             `parquet:"sentence_count"`
+	ReadingTimeMinutes int64             `parquet:"reading_time_minutes"`
+	Links              int64             `parquet:"link_count"`
+	Images             int64             `parquet:"image_count"`
+	CodeBlocks         int64             `parquet:"code_block_count"`
+	ScreenshotPath     string            `parquet:"screenshot_path,optional"`
+	Metadata           map[string]string `parquet:"metadata"`
+}
+
+// parquetLink is a row of the link-level Parquet table (the crawl's link graph)
+type parquetLink struct {
+	JobID     string `parquet:"job_id,dict"`
+	SourceID  string `parquet:"source_page_id"`
+	SourceURL string `parquet:"source_url"`
+	TargetURL string `parquet:"target_url"`
+	Position  int64  `parquet:"position"`
+	Internal  bool   `parquet:"internal"` // Target is on the same host as the source
+}
+
+// writeParquetPages writes one row per crawled page
+func writeParquetPages(w io.Writer, jobID string, results map[string]*CrawledData) error {
+	writer := parquet.NewGenericWriter[parquetPage](w, parquet.Compression(&parquet.Zstd))
+	var rows []parquetPage
+	for _, pageURL := range sortedResultURLs(results) {
+		data := results[pageURL]
+		rows = append(rows, parquetPage{
+			JobID:              jobID,
+			PageID:             pageID(pageURL),
+			URL:                pageURL,
+			ContentHash:        data.ContentHash,
+			Host:               hostOf(pageURL),
+			Title:              data.Metadata["title"],
+			Description:        data.Metadata["description"],
+			Markdown:           data.Markdown,
+			Words:              int64(data.Stats.Words),
+			Sentences:          int64(data.Stats.Sentences),
+			ReadingTimeMinutes: int64(data.Stats.ReadingTimeMinutes),
+			Links:              int64(data.Stats.Links),
+			Images:             int64(data.Stats.Images),
+			CodeBlocks:         int64(data.Stats.CodeBlocks),
+			ScreenshotPath:     data.ScreenshotPath,
+			Metadata:           data.Metadata,
+		})
+		if len(rows) == parquetRowGroupSize {
+			if err := flushParquetRows(writer, rows); err != nil {
+				return err
+			}
+			rows = rows[:0]
+		}
+	}
+	if err := flushParquetRows(writer, rows); err != nil {
+		return err
+	}
+	return writer.Close()
+}
+
+// writeParquetLinks writes one row per outgoing link of every crawled page
+func writeParquetLinks(w io.Writer, jobID string, results map[string]*CrawledData) error {
+	writer := parquet.NewGenericWriter[parquetLink](w, parquet.Compression(&parquet.Zstd))
+	var rows []parquetLink
+	for _, pageURL := range sortedResultURLs(results) {
+		links, _ := results[pageURL].StructuredData["links"].([]string)
+		sourceHost := hostOf(pageURL)
+		for position, link := range links {
+			rows = append(rows, parquetLink{
+				JobID:     jobID,
+				SourceID:  pageID(pageURL),
+				SourceURL: pageURL,
+				TargetURL: link,
+				Position:  int64(position),
+				Internal:  hostOf(link) == sourceHost,
+			})
+			if len(rows) == parquetRowGroupSize {
+				if err := flushParquetRows(writer, rows); err != nil {
+					return err
+				}
+				rows = rows[:0]
+			}
+		}
+	}
+	if err := flushParquetRows(writer, rows); err != nil {
+		return err
+	}
+	return writer.Close()
+}
+
+// flushParquetRows writes rows and closes the current row group
+func flushParquetRows[T any](writer *parquet.GenericWriter[T], rows []T) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	if _, err := writer.Write(rows); err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+// hostOf returns the host of a URL, or "" when it doesn't parse
+func hostOf(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return parsed.Hostname()
+}
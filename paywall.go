@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Access wall types
+const (
+	accessPaywall   = "paywall"
+	accessLoginWall = "login"
+)
+
+// Quality flags of walled pages
+const (
+	qualityPaywalled   = "paywalled"
+	qualityLoginWalled = "login_walled"
+)
+
+// Pages with fewer words in their main content than these are short enough for a
+// paywall element or a password form to be the reason
+const (
+	paywallTeaserMaxWords = 250
+	loginWallMaxWords     = 150
+)
+
+// paywallSelectors are elements of common paywall and registration wall products
+const paywallSelectors = "[class*='paywall'], [id*='paywall'], [data-paywall], .tp-modal, .tp-backdrop, #piano-inline, .piano-offer, .subscriber-only, .regwall, [class*='regwall']"
+
+// paywallPhrases and loginWallPhrases are matched against the lower-cased page text
+var (
+	paywallPhrases = []string{
+		"subscribe to continue reading", "subscribe to read", "subscribe now to continue", "to continue reading, subscribe",
+		"this article is for subscribers", "this article is exclusive to subscribers", "available to subscribers only",
+		"reached your free article limit", "you have reached your limit of free articles", "become a member to read",
+		"this content is for members only", "this post is for paid subscribers", "unlock this article",
+	}
+	loginWallPhrases = []string{
+		"sign in to continue reading", "log in to continue reading", "login to continue reading", "sign in to read",
+		"log in to read", "create a free account to continue", "register to continue reading", "you must be logged in",
+		"please log in to view", "please sign in to view",
+	}
+)
+
+// AccessWall records why a page is considered paywalled or behind a login
+type AccessWall struct {
+	Type    string   `json:"type"`    // paywall or login
+	Signals []string `json:"signals"` // The evidence, e.g. "schema:isAccessibleForFree=false"
+}
+
+// detectAccessWall looks for paywall and login-wall evidence on the full page,
+// before the strip removes its scripts: isAccessibleForFree in the structured data,
+// phrases of subscription and login prompts, paywall elements on pages with little
+// content, and password forms on pages with little else
+func detectAccessWall(doc *goquery.Selection) *AccessWall {
+	wall := &AccessWall{}
+	for _, object := range schemaObjects(doc) {
+		if !isFreeValue(object["isAccessibleForFree"]) {
+			wall.add(accessPaywall, "schema:isAccessibleForFree=false")
+		}
+		for _, part := range object.objects("hasPart") {
+			if !isFreeValue(part["isAccessibleForFree"]) {
+				wall.add(accessPaywall, "schema:hasPart.isAccessibleForFree=false")
+			}
+		}
+	}
+
+	text := strings.ToLower(strings.Join(strings.Fields(visibleText(doc)), " "))
+	for _, phrase := range paywallPhrases {
+		if strings.Contains(text, phrase) {
+			wall.add(accessPaywall, "text:"+phrase)
+		}
+	}
+	for _, phrase := range loginWallPhrases {
+		if strings.Contains(text, phrase) {
+			wall.add(accessLoginWall, "text:"+phrase)
+		}
+	}
+
+	main := doc.Find("article, main, [role=main]").First()
+	if main.Length() == 0 {
+		main = doc.Find("body")
+	}
+	words := len(strings.Fields(visibleText(main)))
+	if words < paywallTeaserMaxWords && doc.Find(paywallSelectors).Length() > 0 {
+		wall.add(accessPaywall, "element:paywall")
+	}
+	if words < loginWallMaxWords && doc.Find("input[type=password]").Length() > 0 {
+		wall.add(accessLoginWall, "element:password_form")
+	}
+	if wall.Type == "" {
+		return nil
+	}
+	return wall
+}
+
+// add records a signal; paywall evidence takes precedence for the type
+func (wall *AccessWall) add(wallType, signal string) {
+	if wall.Type == "" || wallType == accessPaywall {
+		wall.Type = wallType
+	}
+	wall.Signals = appendUnique(wall.Signals, signal)
+}
+
+// isFreeValue reports whether an isAccessibleForFree value doesn't say "false"; a
+// missing value is free
+func isFreeValue(value interface{}) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case string:
+		return !strings.EqualFold(strings.TrimSpace(v), "false")
+	case []interface{}:
+		return len(v) == 0 || isFreeValue(v[0])
+	}
+	return true
+}
+
+// flagAccessWall marks a walled page in its structured data and metadata, and puts
+// a notice under the markdown header so the teaser isn't mistaken for the full article
+func flagAccessWall(data *CrawledData, wall *AccessWall) {
+	data.StructuredData["access_wall"] = wall
+	data.Metadata["access_wall"] = wall.Type
+	label, requirement := "Paywall", "a subscription"
+	if wall.Type == accessLoginWall {
+		label, requirement = "Login wall", "signing in"
+	}
+	notice := fmt.Sprintf("> **%s:** only part of this page is accessible without %s; the text below may be a teaser, not the full content.\n\n", label, requirement)
+	if i := strings.Index(data.Markdown, "---\n\n"); i >= 0 {
+		data.Markdown = data.Markdown[:i+5] + notice + data.Markdown[i+5:]
+	} else {
+		data.Markdown = notice + data.Markdown
+	}
+}
+
+// qualityFlag returns the extraction quality flag of the wall
+func (wall *AccessWall) qualityFlag() string {
+	if wall.Type == accessLoginWall {
+		return qualityLoginWalled
+	}
+	return qualityPaywalled
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultMaxConcurrency is the number of workers of every network-bound pipeline
+// stage when CrawlerConfig.MaxConcurrency is 0
+const defaultMaxConcurrency = 8
+
+// maxConcurrencyLimit caps the workers per stage a request may ask for
+const maxConcurrencyLimit = 64
+
+// Stages of the crawl pipeline. A page goes fetch → render (JS pages) → extract →
+// fallback (low-quality static pages) → enrich → sink, and may leave it early, e.g.
+// when it fails, is excluded or the crawl is a dry run.
+const (
+	stageFetch    = "fetch"    // Static, file, archive and seed fetches
+	stageRender   = "render"   // Headless rendering of JS pages
+	stageExtract  = "extract"  // Readability, metadata and markdown conversion; the only CPU-bound stage
+	stageFallback = "fallback" // JS re-rendering of pages whose static extraction scored low
+	stageEnrich   = "enrich"   // Images, transcripts, comments, translation and screenshots
+	stageSink     = "sink"     // Results, cache and sinks
+)
+
+// pipelineStages lists the stages in flow order. Stages only hand pages to later
+// ones, so full queues hold back earlier stages but can't deadlock.
+var pipelineStages = []string{stageFetch, stageRender, stageExtract, stageFallback, stageEnrich, stageSink}
+
+// stageGauges count the pages waiting in and being processed by every stage, across
+// all running crawls, for /metrics
+type stageGauges struct {
+	queued atomic.Int64 // Includes pages blocked on entering a full queue
+	busy   atomic.Int64
+}
+
+// stageLatency sums up the time a stage took per page
+type stageLatency struct {
+	sync.Mutex
+	processed int
+	total     time.Duration
+	max       time.Duration
+}
+
+// record adds the time a page took
+func (l *stageLatency) record(took time.Duration) {
+	l.Lock()
+	l.processed++
+	l.total += took
+	l.max = max(l.max, took)
+	l.Unlock()
+}
+
+// pipelineMetrics are the stageGauges by stage
+var pipelineMetrics = func() map[string]*stageGauges {
+	gauges := make(map[string]*stageGauges)
+	for _, stage := range pipelineStages {
+		gauges[stage] = &stageGauges{}
+	}
+	return gauges
+}()
+
+// pipelineJob is a page moving through the pipeline
+type pipelineJob struct {
+	URL     string
+	Depth   int
+	Visit   func(string) error // Queues a link one level deeper than this page
+	Fetcher Fetcher
+	Ruled   bool // A FetchRule chose the fetcher
+	Page    *fetchedPage
+	Data    *CrawledData
+	Timings PageTimings   // Of fetching or rendering, added to the page's once it is extracted
+	done    chan struct{} // Closed when the page leaves the pipeline
+}
+
+// stageFunc processes a job and returns the stage it goes to next, or "" when the
+// page is done
+type stageFunc func(job *pipelineJob) string
+
+// crawlPipeline runs every stage with its own pool of workers, so slow conversion of
+// huge pages doesn't stall fetching and slow hosts don't leave the CPU idle. Stages
+// are connected by bounded queues: a stage that falls behind blocks the ones before
+// it, down to the scheduler, instead of piling up fetched pages in memory.
+type crawlPipeline struct {
+	queues   map[string]chan *pipelineJob
+	handlers map[string]stageFunc
+	workers  map[string]*sync.WaitGroup
+	gauges   map[string]*stageGauges // Of this crawl only, unlike pipelineMetrics
+	latency  map[string]*stageLatency
+}
+
+// newCrawlPipeline starts the workers of every stage, see stageWorkers
+func newCrawlPipeline(config CrawlerConfig, handlers map[string]stageFunc) *crawlPipeline {
+	p := &crawlPipeline{
+		queues:   make(map[string]chan *pipelineJob),
+		handlers: handlers,
+		workers:  make(map[string]*sync.WaitGroup),
+		gauges:   make(map[string]*stageGauges),
+		latency:  make(map[string]*stageLatency),
+	}
+	for _, stage := range pipelineStages {
+		p.queues[stage] = make(chan *pipelineJob, config.stageWorkers(stage))
+		p.workers[stage] = &sync.WaitGroup{}
+		p.gauges[stage] = &stageGauges{}
+		p.latency[stage] = &stageLatency{}
+	}
+	for _, stage := range pipelineStages { // Once the maps are complete, workers read them
+		for i := 0; i < config.stageWorkers(stage); i++ {
+			p.workers[stage].Add(1)
+			go p.work(stage)
+		}
+	}
+	return p
+}
+
+// work processes the jobs of a stage until its queue is closed
+func (p *crawlPipeline) work(stage string) {
+	defer p.workers[stage].Done()
+	gauges, crawlGauges := pipelineMetrics[stage], p.gauges[stage]
+	for job := range p.queues[stage] {
+		gauges.queued.Add(-1)
+		crawlGauges.queued.Add(-1)
+		gauges.busy.Add(1)
+		crawlGauges.busy.Add(1)
+		start := time.Now()
+		next := p.handlers[stage](job)
+		p.latency[stage].record(time.Since(start))
+		gauges.busy.Add(-1)
+		crawlGauges.busy.Add(-1)
+		if next == "" {
+			close(job.done)
+			continue
+		}
+		p.enqueue(next, job)
+	}
+}
+
+// enqueue hands a job to a stage, blocking while its queue is full
+func (p *crawlPipeline) enqueue(stage string, job *pipelineJob) {
+	pipelineMetrics[stage].queued.Add(1)
+	p.gauges[stage].queued.Add(1)
+	p.queues[stage] <- job
+}
+
+// process runs a page through the pipeline and returns once it has left it.
+// Blocking the frontier's dispatcher is what applies the backpressure, and the
+// page's links are queued once it returns.
+func (p *crawlPipeline) process(job *pipelineJob) {
+	job.done = make(chan struct{})
+	p.enqueue(stageFetch, job)
+	<-job.done
+}
+
+// close stops the workers once every job is done, stage by stage
+func (p *crawlPipeline) close() {
+	for _, stage := range pipelineStages {
+		close(p.queues[stage])
+		p.workers[stage].Wait()
+	}
+}
+
+// pipelineCapacity is the number of pages the pipeline holds with every worker busy
+// and every queue full, the number of dispatchers that keeps it busy
+func (config CrawlerConfig) pipelineCapacity() int {
+	capacity := 0
+	for _, stage := range pipelineStages {
+		capacity += 2 * config.stageWorkers(stage)
+	}
+	return capacity
+}
+
+// stageWorkers returns the number of workers of a stage: ExtractWorkers (default
+// one per CPU) for extraction, MaxConcurrency (default defaultMaxConcurrency) for
+// the network-bound stages
+func (config CrawlerConfig) stageWorkers(stage string) int {
+	if stage == stageExtract {
+		if config.ExtractWorkers > 0 {
+			return config.ExtractWorkers
+		}
+		return runtime.GOMAXPROCS(0)
+	}
+	if config.MaxConcurrency > 0 {
+		return config.MaxConcurrency
+	}
+	return defaultMaxConcurrency
+}
+
+// writePipelineMetrics writes the queue depth and busy workers of every stage in
+// the Prometheus text exposition format
+func writePipelineMetrics(out *strings.Builder) {
+	out.WriteString("# HELP lexicrawler_pipeline_queue_depth Pages waiting for a pipeline stage.\n# TYPE lexicrawler_pipeline_queue_depth gauge\n")
+	for _, stage := range pipelineStages {
+		fmt.Fprintf(out, "lexicrawler_pipeline_queue_depth{stage=%q} %d\n", stage, pipelineMetrics[stage].queued.Load())
+	}
+	out.WriteString("# HELP lexicrawler_pipeline_busy_workers Workers processing a page per pipeline stage.\n# TYPE lexicrawler_pipeline_busy_workers gauge\n")
+	for _, stage := range pipelineStages {
+		fmt.Fprintf(out, "lexicrawler_pipeline_busy_workers{stage=%q} %d\n", stage, pipelineMetrics[stage].busy.Load())
+	}
+}
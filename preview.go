@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+)
+
+// maxPreviewBytes caps how much of a page is read for a link preview; the <head>
+// is almost always within the first few hundred kilobytes
+const maxPreviewBytes = 2 << 20
+
+var previewClient = &http.Client{Timeout: 10 * time.Second}
+
+// LinkPreview is the unfurl information for a single page
+type LinkPreview struct {
+	URL          string      `json:"url"`
+	Title        string      `json:"title"`
+	Description  string      `json:"description"`
+	SiteName     string      `json:"site_name,omitempty"`
+	CanonicalURL string      `json:"canonical_url,omitempty"`
+	FaviconURL   string      `json:"favicon_url"`
+	ImageURL     string      `json:"image_url,omitempty"`
+	Favicon      *ImageAsset `json:"favicon,omitempty"`
+	Image        *ImageAsset `json:"image,omitempty"`
+}
+
+// fetchLinkPreview fetches a single page and extracts its unfurl metadata without
+// going through the crawl pipeline
+func fetchLinkPreview(pageURL string, embedImages bool) (*LinkPreview, error) {
+	resp, err := previewClient.Get(pageURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return nil, fmt.Errorf("unexpected status %d for %s", resp.StatusCode, pageURL)
+	}
+
+	// Decode to UTF-8 using the Content-Type header and <meta charset> sniffing
+	body, err := charset.NewReader(io.LimitReader(resp.Body, maxPreviewBytes), resp.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, err
+	}
+	htmlDoc, err := html.Parse(body)
+	if err != nil {
+		return nil, err
+	}
+	finalURL := resp.Request.URL.String() // After redirects
+	metadata := extractMetadata(goquery.NewDocumentFromNode(htmlDoc).Selection, finalURL)
+
+	preview := &LinkPreview{
+		URL:          finalURL,
+		Title:        firstNonEmpty(metadata["og:title"], metadata["twitter:title"], metadata["title"]),
+		Description:  firstNonEmpty(metadata["og:description"], metadata["twitter:description"], metadata["description"]),
+		SiteName:     metadata["og:site_name"],
+		CanonicalURL: metadata["canonical_url"],
+		FaviconURL:   firstNonEmpty(metadata["favicon_url"], resolveURL(finalURL, "/favicon.ico")),
+	}
+	if imageURL := firstNonEmpty(metadata["og:image"], metadata["twitter:image"]); imageURL != "" {
+		preview.ImageURL = resolveURL(finalURL, imageURL)
+	}
+
+	if embedImages {
+		if favicon, err := downloadImage(preview.FaviconURL); err == nil {
+			preview.Favicon = favicon
+		}
+		if preview.ImageURL != "" {
+			if image, err := downloadImage(preview.ImageURL); err == nil {
+				preview.Image = image
+			}
+		}
+	}
+	return preview, nil
+}
+
+// firstNonEmpty returns the first non-empty string
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// URLScorer sets the Score of the requests found on a page, or of the start pages
+// when from is nil (also for pages that stored no result). The frontier crawls
+// higher scores first, and equal ones in the order they were found.
+type URLScorer func(from *CrawledData, found []frontierEntry)
+
+// URLPriority orders the frontier of a crawl, so the most valuable pages are crawled
+// first when MaxPages or a quota cuts it short. A URL's score starts at 1 and is
+// multiplied by the weight of its pattern, the depth decay and its relevance.
+type URLPriority struct {
+	Patterns   []PriorityPattern `json:"patterns"`    // The first matching pattern weighs the URL, others weigh 1
+	DepthDecay float64           `json:"depth_decay"` // Factor applied per level below the start pages, e.g. 0.5; 0 for none
+	Keywords   []string          `json:"keywords"`    // Found in the URL or on the page linking to it, multiply the score by up to 2
+}
+
+// PriorityPattern weighs the URLs matching a FetchRule-style pattern, e.g. 3 for
+// "/docs/**" or 0 for "/tag/*" to crawl them last
+type PriorityPattern struct {
+	Pattern string  `json:"pattern"`
+	Weight  float64 `json:"weight"`
+}
+
+// validate checks the patterns, weights and decay
+func (p *URLPriority) validate() error {
+	for _, pattern := range p.Patterns {
+		if pattern.Pattern == "" {
+			return errors.New("url_priority pattern without pattern")
+		}
+		if _, err := compileURLPattern(pattern.Pattern); err != nil {
+			return fmt.Errorf("url_priority pattern %q: %w", pattern.Pattern, err)
+		}
+		if pattern.Weight < 0 || math.IsInf(pattern.Weight, 0) || math.IsNaN(pattern.Weight) {
+			return fmt.Errorf("url_priority pattern %q: weight must not be negative", pattern.Pattern)
+		}
+	}
+	if p.DepthDecay < 0 || p.DepthDecay > 1 {
+		return errors.New("url_priority depth_decay must be between 0 and 1")
+	}
+	return nil
+}
+
+// priorityPattern is a compiled PriorityPattern
+type priorityPattern struct {
+	expr     *regexp.Regexp
+	pathOnly bool // Matched against the path, see FetchRule.Pattern
+	weight   float64
+}
+
+// scorer compiles the priority into a URLScorer; the priority must be valid
+func (p *URLPriority) scorer() URLScorer {
+	var patterns []priorityPattern
+	for _, pattern := range p.Patterns {
+		expr, _ := compileURLPattern(pattern.Pattern) // Checked by validate
+		patterns = append(patterns, priorityPattern{expr: expr, pathOnly: strings.HasPrefix(pattern.Pattern, "/"), weight: pattern.Weight})
+	}
+	var keywords []string
+	for _, keyword := range p.Keywords {
+		if keyword = strings.ToLower(strings.TrimSpace(keyword)); keyword != "" {
+			keywords = append(keywords, keyword)
+		}
+	}
+	decay := p.DepthDecay
+
+	return func(from *CrawledData, found []frontierEntry) {
+		pageRelevance := 0.0
+		if from != nil && len(keywords) > 0 {
+			pageRelevance = keywordShare(keywords, from.Metadata["title"]+"\n"+from.Metadata["description"]+"\n"+from.Markdown)
+		}
+		for i := range found {
+			score := 1.0
+			if parsedURL, err := url.Parse(found[i].URL); err == nil {
+				for _, pattern := range patterns {
+					subject := found[i].URL
+					if pattern.pathOnly {
+						if subject = parsedURL.EscapedPath(); subject == "" {
+							subject = "/"
+						}
+					}
+					if pattern.expr.MatchString(subject) {
+						score = pattern.weight
+						break
+					}
+				}
+			}
+			if decay > 0 {
+				score *= math.Pow(decay, float64(found[i].Depth-1))
+			}
+			if len(keywords) > 0 {
+				score *= 1 + (pageRelevance+keywordShare(keywords, found[i].URL))/2
+			}
+			found[i].Score = score
+		}
+	}
+}
+
+// keywordShare returns the share of the keywords (lower case) found in a text
+func keywordShare(keywords []string, text string) float64 {
+	text = strings.ToLower(text)
+	matched := 0
+	for _, keyword := range keywords {
+		if strings.Contains(text, keyword) {
+			matched++
+		}
+	}
+	return float64(matched) / float64(len(keywords))
+}
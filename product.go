@@ -0,0 +1,176 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Product holds the normalized fields of a product page
+type Product struct {
+	Name         string   `json:"name"`
+	Description  string   `json:"description,omitempty"`
+	Brand        string   `json:"brand,omitempty"`
+	SKU          string   `json:"sku,omitempty"`
+	GTIN         string   `json:"gtin,omitempty"`
+	URL          string   `json:"url,omitempty"`
+	Price        *float64 `json:"price,omitempty"`        // The lowest price for price ranges
+	HighPrice    *float64 `json:"high_price,omitempty"`   // Set for price ranges (AggregateOffer)
+	Currency     string   `json:"currency,omitempty"`     // ISO 4217 code
+	Availability string   `json:"availability,omitempty"` // in_stock, out_of_stock, pre_order, ...
+	Images       []string `json:"images,omitempty"`
+	Rating       *Rating  `json:"rating,omitempty"`
+}
+
+// Rating is the aggregate rating of a product
+type Rating struct {
+	Value float64 `json:"value"`
+	Best  float64 `json:"best,omitempty"`  // The top of the scale, usually 5
+	Count int     `json:"count,omitempty"` // Number of ratings (or reviews)
+}
+
+// ogAvailability maps the availability values of OpenGraph product tags
+var ogAvailability = map[string]string{
+	"instock":           "in_stock",
+	"oos":               "out_of_stock",
+	"outofstock":        "out_of_stock",
+	"preorder":          "pre_order",
+	"availablefororder": "in_stock",
+	"pending":           "pre_order",
+	"discontinued":      "discontinued",
+}
+
+// extractProduct recognizes product pages by a schema.org Product (JSON-LD or
+// microdata) or OpenGraph product tags. Schema.org fields win; OpenGraph fills gaps.
+func extractProduct(page *extractorPage) (interface{}, bool) {
+	product := Product{}
+	item := page.first("Product")
+	isOGProduct := strings.HasPrefix(page.Meta["og:type"], "product") || page.Meta["product:price:amount"] != ""
+	if item == nil && !isOGProduct {
+		return nil, false
+	}
+
+	if item != nil {
+		product.Name = item.str("name")
+		product.Description = item.str("description")
+		product.Brand = item.str("brand")
+		product.SKU = item.str("sku")
+		for _, property := range []string{"gtin", "gtin13", "gtin12", "gtin14", "gtin8"} {
+			if product.GTIN == "" {
+				product.GTIN = item.str(property)
+			}
+		}
+		if productURL := item.str("url"); productURL != "" {
+			product.URL = resolveURL(page.URL, productURL)
+		}
+		for _, image := range item.strs("image") {
+			product.Images = appendUnique(product.Images, resolveURL(page.URL, image))
+		}
+		product.offers(item.objects("offers"))
+		if rating := item.object("aggregateRating"); rating != nil {
+			if value, ok := schemaNumber(rating["ratingValue"]); ok {
+				product.Rating = &Rating{Value: value}
+				product.Rating.Best, _ = schemaNumber(rating["bestRating"])
+				count, ok := schemaNumber(rating["ratingCount"])
+				if !ok {
+					count, _ = schemaNumber(rating["reviewCount"])
+				}
+				product.Rating.Count = int(count)
+			}
+		}
+	}
+
+	if product.Name == "" {
+		product.Name = page.Meta["og:title"]
+	}
+	if product.Description == "" {
+		product.Description = page.Meta["og:description"]
+	}
+	if product.Brand == "" {
+		product.Brand = page.Meta["product:brand"]
+	}
+	if product.Price == nil {
+		product.Price = priceOf(page.Meta["product:price:amount"], page.Meta["og:price:amount"])
+	}
+	if product.Currency == "" {
+		product.Currency = firstNonEmpty(page.Meta["product:price:currency"], page.Meta["og:price:currency"])
+	}
+	if product.Availability == "" {
+		product.Availability = normalizeAvailability(firstNonEmpty(page.Meta["product:availability"], page.Meta["og:availability"]))
+	}
+	if image := page.Meta["og:image"]; image != "" {
+		product.Images = appendUnique(product.Images, resolveURL(page.URL, image))
+	}
+	if product.URL == "" {
+		product.URL = page.Meta["og:url"]
+	}
+
+	if product.Name == "" {
+		return nil, false
+	}
+	product.Currency = strings.ToUpper(product.Currency)
+	return product, true
+}
+
+// offers takes price, currency and availability from the first offer with a price
+func (product *Product) offers(offers []schemaObject) {
+	for _, offer := range offers {
+		price := priceOf(offer["price"], offer["lowPrice"])
+		currency := offer.str("priceCurrency")
+		if specification := offer.object("priceSpecification"); price == nil && specification != nil {
+			price = priceOf(specification["price"], specification["minPrice"])
+			currency = firstNonEmpty(currency, specification.str("priceCurrency"))
+		}
+		if price == nil {
+			continue
+		}
+		product.Price = price
+		product.HighPrice = priceOf(offer["highPrice"])
+		product.Currency = currency
+		product.Availability = normalizeAvailability(offer.str("availability"))
+		return
+	}
+}
+
+// priceOf parses the first of the values that holds a price, tolerating currency
+// symbols and thousands separators ("$1,299.00")
+func priceOf(values ...interface{}) *float64 {
+	for _, value := range values {
+		text := strings.Map(func(r rune) rune {
+			if (r >= '0' && r <= '9') || r == '.' {
+				return r
+			}
+			return -1
+		}, schemaText(value))
+		if price, err := strconv.ParseFloat(text, 64); err == nil {
+			return &price
+		}
+	}
+	return nil
+}
+
+// normalizeAvailability converts schema.org ItemAvailability URLs ("https://schema.org/InStock")
+// and OpenGraph values ("instock", "out of stock") to snake case names
+func normalizeAvailability(value string) string {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return ""
+	}
+	key := strings.ToLower(strings.NewReplacer(" ", "", "_", "", "-", "").Replace(value[strings.LastIndexAny(value, "/#")+1:]))
+	if name, ok := ogAvailability[key]; ok {
+		return name
+	}
+	return schemaEnum(value)
+}
+
+// appendUnique appends value to values unless it is empty or already present
+func appendUnique(values []string, value string) []string {
+	if value == "" {
+		return values
+	}
+	for _, existing := range values {
+		if existing == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
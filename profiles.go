@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/andybalholm/cascadia"
+)
+
+// defaultStripSelectors are removed from every page before markdown generation:
+// navigation and non-content elements, cookie banners, share widgets and comments
+var defaultStripSelectors = []string{
+	"nav", "footer", "script", "style", "noscript",
+	"#cookie-banner", ".cookie-banner", "#cookie-consent", ".cookie-consent", "#onetrust-consent-sdk", "#CybotCookiebotDialog", "[aria-label='cookieconsent']",
+	".share", ".share-buttons", ".social-share", ".sharing", ".addthis_toolbox", ".sharethis-inline-share-buttons",
+	"#comments", ".comments", ".comment-list", "#disqus_thread",
+}
+
+// SiteProfile overrides extraction settings for the pages matching its pattern
+type SiteProfile struct {
+	Pattern         string   `json:"pattern"`          // Same syntax as FetchRule patterns
+	StripSelectors  []string `json:"strip_selectors"`  // Replaces the crawl's strip selectors, an empty list strips nothing
+	ContentSelector string   `json:"content_selector"` // Replaces the crawl's content selector
+	MarkdownHeader  string   `json:"markdown_header"`  // Replaces the crawl's markdown header template
+	MarkdownFooter  string   `json:"markdown_footer"`  // Replaces the crawl's markdown footer template
+}
+
+// validateSiteProfiles checks the patterns, selectors and templates of site profiles
+func validateSiteProfiles(profiles []SiteProfile) error {
+	for _, profile := range profiles {
+		if profile.Pattern == "" {
+			return fmt.Errorf("site profile without pattern")
+		}
+		if _, err := compileURLPattern(profile.Pattern); err != nil {
+			return fmt.Errorf("site profile %q: %w", profile.Pattern, err)
+		}
+		if err := validateSelectors(append(nonEmpty(profile.ContentSelector), profile.StripSelectors...)); err != nil {
+			return fmt.Errorf("site profile %q: %w", profile.Pattern, err)
+		}
+		if err := validateMarkdownTemplates(profile.MarkdownHeader, profile.MarkdownFooter); err != nil {
+			return fmt.Errorf("site profile %q: %w", profile.Pattern, err)
+		}
+	}
+	return nil
+}
+
+// validateSelectors checks that every entry is a valid CSS selector
+func validateSelectors(selectors []string) error {
+	for _, selector := range selectors {
+		if _, err := cascadia.ParseGroup(selector); err != nil {
+			return fmt.Errorf("invalid selector %q: %w", selector, err)
+		}
+	}
+	return nil
+}
+
+// nonEmpty returns a single-element slice holding value, or nil when it is empty
+func nonEmpty(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return []string{value}
+}
+
+// siteProfile returns the first site profile matching a URL, or nil
+func (config CrawlerConfig) siteProfile(pageURL string) *SiteProfile {
+	for i := range config.SiteProfiles {
+		if urlPatternMatches(config.SiteProfiles[i].Pattern, pageURL) {
+			return &config.SiteProfiles[i]
+		}
+	}
+	return nil
+}
+
+// stripSelector returns the selector group removed from a page before markdown
+// generation: the matching site profile's, else the crawl's, else the defaults
+func (config CrawlerConfig) stripSelector(pageURL string) string {
+	selectors := defaultStripSelectors
+	if config.StripSelectors != nil {
+		selectors = config.StripSelectors
+	}
+	if profile := config.siteProfile(pageURL); profile != nil && profile.StripSelectors != nil {
+		selectors = profile.StripSelectors
+	}
+	return strings.Join(selectors, ", ")
+}
+
+// contentSelector returns the selector of the element(s) holding the main content
+// of a page, or "" to detect it with readability or use the whole page
+func (config CrawlerConfig) contentSelector(pageURL string) string {
+	if profile := config.siteProfile(pageURL); profile != nil && profile.ContentSelector != "" {
+		return profile.ContentSelector
+	}
+	return config.ContentSelector
+}
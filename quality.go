@@ -0,0 +1,141 @@
+package main
+
+import (
+	"strconv"
+	"unicode"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+// Extraction quality thresholds
+const (
+	minExtractionRatio  = 0.1  // Below this share of the page's visible text, content was probably missed
+	fullCoverageRatio   = 0.25 // Extracting this share of the visible text counts as full coverage
+	maxLinkDensity      = 0.5  // Above this share of link text, the extract is mostly navigation
+	maxBoilerplateRatio = 0.6  // Above this share of nav/header/footer text, the page is mostly chrome
+	minQualityWords     = 50   // Shorter extracts are penalized proportionally
+	lowQualityScore     = 0.4
+	jsShellMaxChars     = 200 // Pages with less visible text but scripts are probably rendered client-side
+)
+
+// Quality flags
+const (
+	qualityEmptyMarkdown    = "empty_markdown"
+	qualityLowExtraction    = "low_extraction_ratio"
+	qualityHighLinkDensity  = "high_link_density"
+	qualityBoilerplateHeavy = "boilerplate_heavy"
+	qualityNeedsJSRendering = "needs_js_rendering"
+	qualityShortContent     = "short_content"
+)
+
+// ExtractionQuality estimates how well a page's content was extracted, so pages that
+// need a per-site profile or JS rendering can be found without reading them all
+type ExtractionQuality struct {
+	Score            float64  `json:"score"`             // 0 (nothing useful) to 1
+	SourceChars      int      `json:"source_chars"`      // Letters and digits visible in the raw page
+	ExtractedChars   int      `json:"extracted_chars"`   // Letters and digits in the markdown
+	ExtractionRatio  float64  `json:"extraction_ratio"`  // ExtractedChars / SourceChars
+	LinkDensity      float64  `json:"link_density"`      // Share of the extracted content's text inside links
+	BoilerplateRatio float64  `json:"boilerplate_ratio"` // Share of the raw page's text in nav, header, footer and aside
+	Flags            []string `json:"flags,omitempty"`
+	Low              bool     `json:"low"`
+}
+
+// assessExtractionQuality compares the generated markdown with the raw page and the
+// content selection it was generated from
+func assessExtractionQuality(page *goquery.Selection, content *goquery.Selection, markdown string, stats ContentStats) ExtractionQuality {
+	quality := ExtractionQuality{
+		SourceChars:    countContentChars(visibleText(page)),
+		ExtractedChars: countContentChars(markdown),
+	}
+	if quality.SourceChars > 0 {
+		quality.ExtractionRatio = min(float64(quality.ExtractedChars)/float64(quality.SourceChars), 1)
+		boilerplate := 0
+		page.Find("nav, header, footer, aside, [role=navigation], [role=banner], [role=contentinfo]").Each(func(_ int, s *goquery.Selection) {
+			if s.ParentsFiltered("nav, header, footer, aside").Length() == 0 { // Count nested chrome once
+				boilerplate += countContentChars(visibleText(s))
+			}
+		})
+		quality.BoilerplateRatio = min(float64(boilerplate)/float64(quality.SourceChars), 1)
+	}
+	if contentChars := countContentChars(visibleText(content)); contentChars > 0 {
+		quality.LinkDensity = min(float64(countContentChars(visibleText(content.Find("a"))))/float64(contentChars), 1)
+	}
+
+	if quality.ExtractedChars == 0 {
+		quality.Flags = append(quality.Flags, qualityEmptyMarkdown)
+	}
+	if quality.SourceChars > 0 && quality.ExtractionRatio < minExtractionRatio {
+		quality.Flags = append(quality.Flags, qualityLowExtraction)
+	}
+	if quality.LinkDensity > maxLinkDensity {
+		quality.Flags = append(quality.Flags, qualityHighLinkDensity)
+	}
+	if quality.BoilerplateRatio > maxBoilerplateRatio {
+		quality.Flags = append(quality.Flags, qualityBoilerplateHeavy)
+	}
+	if stats.Words < minQualityWords {
+		quality.Flags = append(quality.Flags, qualityShortContent)
+	}
+	if quality.SourceChars < jsShellMaxChars && page.Find("script").Length() > 0 {
+		quality.Flags = append(quality.Flags, qualityNeedsJSRendering)
+	}
+
+	coverage := 1.0
+	if quality.SourceChars > 0 {
+		coverage = min(quality.ExtractionRatio/fullCoverageRatio, 1)
+	}
+	quality.Score = coverage * (1 - quality.LinkDensity)
+	if stats.Words < minQualityWords {
+		quality.Score *= float64(stats.Words) / minQualityWords
+	}
+	if quality.ExtractedChars == 0 {
+		quality.Score = 0
+	}
+	quality.Score = float64(int(quality.Score*100+0.5)) / 100
+	quality.Low = quality.Score < lowQualityScore
+	return quality
+}
+
+// visibleText returns the text of a selection without script, style and template contents
+func visibleText(selection *goquery.Selection) string {
+	var text []byte
+	var walk func(*html.Node)
+	walk = func(node *html.Node) {
+		switch {
+		case node.Type == html.TextNode:
+			text = append(text, node.Data...)
+			text = append(text, ' ')
+			return
+		case node.Type == html.ElementNode && (node.Data == "script" || node.Data == "style" || node.Data == "noscript" || node.Data == "template"):
+			return
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	for _, node := range selection.Nodes {
+		walk(node)
+	}
+	return string(text)
+}
+
+// countContentChars counts letters and digits, ignoring markup and whitespace
+func countContentChars(text string) int {
+	count := 0
+	for _, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			count++
+		}
+	}
+	return count
+}
+
+// addToMetadata records the quality score and flags in a page's string metadata map
+func (q ExtractionQuality) addToMetadata(metadata map[string]string) {
+	metadata["quality_score"] = strconv.FormatFloat(q.Score, 'f', 2, 64)
+	if q.Low {
+		metadata["quality_low"] = "true"
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// errNoRawHTML is returned for pages whose raw HTML wasn't kept
+var errNoRawHTML = errors.New("raw HTML was not kept, crawl with keep_raw_html")
+
+// rawHTMLEncoder and rawHTMLDecoder compress kept raw HTML; EncodeAll and DecodeAll
+// are safe for concurrent use
+var (
+	rawHTMLEncoder, _ = zstd.NewWriter(nil)
+	rawHTMLDecoder, _ = zstd.NewReader(nil)
+)
+
+// retainRawHTML runs when a page is stored: its raw HTML is dropped, or compressed
+// when the crawl keeps it. Either way it's no longer inlined in API responses.
+func (c *Crawler) retainRawHTML(data *CrawledData) {
+	if data.RawHTML == "" {
+		return // Nothing fetched, or already retained (pages served from the cache)
+	}
+	if c.Config.KeepRawHTML {
+		data.rawHTMLZstd = rawHTMLEncoder.EncodeAll([]byte(data.RawHTML), nil)
+	}
+	data.RawHTML = ""
+}
+
+// hasRawHTML reports whether the raw HTML of a page is available
+func (data *CrawledData) hasRawHTML() bool {
+	return data.RawHTML != "" || len(data.rawHTMLZstd) > 0 || data.rawHTMLFile != ""
+}
+
+// rawHTML returns the raw HTML of a page, decompressing it and reading it back
+// from disk if it was spilled
+func (data *CrawledData) rawHTML() (string, error) {
+	if !data.hasRawHTML() {
+		return "", errNoRawHTML
+	}
+	if data.RawHTML != "" {
+		return data.RawHTML, nil
+	}
+	compressed := data.rawHTMLZstd
+	if data.rawHTMLFile != "" {
+		content, err := os.ReadFile(data.rawHTMLFile)
+		if err != nil {
+			return "", err
+		}
+		compressed = content
+	}
+	decompressed, err := rawHTMLDecoder.DecodeAll(compressed, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(decompressed), nil
+}
+
+// withRawHTML returns a copy of a page with its raw HTML inlined, for responses
+// that ask for it
+func (data *CrawledData) withRawHTML() (*CrawledData, error) {
+	rawHTML, err := data.rawHTML()
+	if err != nil {
+		return nil, err
+	}
+	inlined := *data
+	inlined.RawHTML = rawHTML
+	return &inlined, nil
+}
+
+// shareRawHTML makes a page derived from another (e.g. by reprocessing) refer to the
+// other's stored raw HTML instead of holding its own copy
+func (data *CrawledData) shareRawHTML(from *CrawledData) {
+	data.RawHTML, data.rawHTMLZstd, data.rawHTMLFile = "", from.rawHTMLZstd, from.rawHTMLFile
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// minReadabilityWords is the prose a page needs for readability scores, which are
+// noise on shorter texts
+const minReadabilityWords = 30
+
+// proseSelector matches the elements whose text is scored; code, tables and
+// navigation lists of links are left out
+const proseSelector = "p, li, dd, blockquote"
+
+// readabilityScores are the classic readability formulas over a page's prose, kept
+// in its metadata. Grade levels are US school grades; the formulas are calibrated
+// for English.
+type readabilityScores struct {
+	fleschReadingEase    float64 // 0 (very hard) to 100 (very easy)
+	fleschKincaidGrade   float64
+	gunningFog           float64
+	smog                 float64
+	colemanLiau          float64
+	automatedReadability float64
+	grade                float64 // Mean of the grade levels
+
+	words, sentences        int
+	syllables, complexWords int // complexWords have three or more syllables
+	letters                 int
+}
+
+// assessReadability scores the prose of a page's content. Pages in languages other
+// than English and pages with little prose aren't scored.
+func assessReadability(content *goquery.Selection, language string) (readabilityScores, bool) {
+	if language != "" && !sameLanguage(language, "en") {
+		return readabilityScores{}, false
+	}
+	var scores readabilityScores
+	content.Find(proseSelector).Each(func(_ int, block *goquery.Selection) {
+		if block.Find(proseSelector).Length() > 0 || block.ParentsFiltered("pre, table").Length() > 0 {
+			return // Scored by its innermost blocks
+		}
+		text := strings.TrimSpace(block.Text())
+		if text == "" || (block.Is("li") && block.Find("a").Length() > 0 && strings.TrimSpace(block.Find("a").Text()) == text) {
+			return // A link in a list, such as a table of contents
+		}
+		sentences := len(sentenceEndPattern.FindAllStringIndex(text, -1))
+		if !sentenceEndPattern.MatchString(text[len(text)-1:]) {
+			sentences++ // A block is a sentence, whether it ends with a full stop or not
+		}
+		for _, word := range strings.Fields(text) {
+			letters := 0
+			for _, r := range word {
+				if unicode.IsLetter(r) || unicode.IsDigit(r) {
+					letters++
+				}
+			}
+			if letters == 0 {
+				continue
+			}
+			syllables := countSyllables(word)
+			scores.words++
+			scores.letters += letters
+			scores.syllables += syllables
+			if syllables >= 3 {
+				scores.complexWords++
+			}
+		}
+		scores.sentences += sentences
+	})
+	if scores.words < minReadabilityWords || scores.sentences == 0 {
+		return readabilityScores{}, false
+	}
+
+	words, sentences := float64(scores.words), float64(scores.sentences)
+	wordsPerSentence := words / sentences
+	syllablesPerWord := float64(scores.syllables) / words
+	scores.fleschReadingEase = roundScore(206.835 - 1.015*wordsPerSentence - 84.6*syllablesPerWord)
+	scores.fleschKincaidGrade = roundScore(0.39*wordsPerSentence + 11.8*syllablesPerWord - 15.59)
+	scores.gunningFog = roundScore(0.4 * (wordsPerSentence + 100*float64(scores.complexWords)/words))
+	scores.smog = roundScore(1.043*math.Sqrt(float64(scores.complexWords)*30/sentences) + 3.1291)
+	scores.colemanLiau = roundScore(0.0588*100*float64(scores.letters)/words - 0.296*100*sentences/words - 15.8)
+	scores.automatedReadability = roundScore(4.71*float64(scores.letters)/words + 0.5*wordsPerSentence - 21.43)
+	scores.grade = roundScore((scores.fleschKincaidGrade + scores.gunningFog + scores.smog + scores.colemanLiau + scores.automatedReadability) / 5)
+	return scores, true
+}
+
+// countSyllables estimates the syllables of an English word by its groups of vowels
+func countSyllables(word string) int {
+	word = strings.ToLower(strings.TrimFunc(word, func(r rune) bool { return !unicode.IsLetter(r) }))
+	if len(word) <= 3 {
+		return 1
+	}
+	if strings.HasSuffix(word, "es") || strings.HasSuffix(word, "ed") {
+		if !strings.HasSuffix(word, "ted") && !strings.HasSuffix(word, "ded") && !strings.HasSuffix(word, "ses") && !strings.HasSuffix(word, "ces") {
+			word = word[:len(word)-2] // Silent, as in "makes" and "used"
+		}
+	} else if strings.HasSuffix(word, "e") && !strings.HasSuffix(word, "le") {
+		word = word[:len(word)-1] // Silent, as in "make"
+	}
+	syllables, inVowels := 0, false
+	for _, r := range word {
+		vowel := strings.ContainsRune("aeiouy", r)
+		if vowel && !inVowels {
+			syllables++
+		}
+		inVowels = vowel
+	}
+	return max(syllables, 1)
+}
+
+// roundScore rounds a score to one decimal
+func roundScore(score float64) float64 {
+	return math.Round(score*10) / 10
+}
+
+// addToMetadata records the readability scores in a page's string metadata map
+func (s readabilityScores) addToMetadata(metadata map[string]string) {
+	format := func(score float64) string { return strconv.FormatFloat(score, 'f', 1, 64) }
+	metadata["flesch_reading_ease"] = format(s.fleschReadingEase)
+	metadata["flesch_kincaid_grade"] = format(s.fleschKincaidGrade)
+	metadata["gunning_fog"] = format(s.gunningFog)
+	metadata["smog_index"] = format(s.smog)
+	metadata["coleman_liau_index"] = format(s.colemanLiau)
+	metadata["automated_readability_index"] = format(s.automatedReadability)
+	metadata["readability_grade"] = format(s.grade)
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Recipe holds the normalized fields of a recipe page
+type Recipe struct {
+	Name         string            `json:"name"`
+	Description  string            `json:"description,omitempty"`
+	Author       string            `json:"author,omitempty"`
+	Images       []string          `json:"images,omitempty"`
+	Yield        string            `json:"yield,omitempty"` // e.g. "4 servings"
+	PrepMinutes  int               `json:"prep_minutes,omitempty"`
+	CookMinutes  int               `json:"cook_minutes,omitempty"`
+	TotalMinutes int               `json:"total_minutes,omitempty"`
+	Category     string            `json:"category,omitempty"`
+	Cuisine      string            `json:"cuisine,omitempty"`
+	Ingredients  []string          `json:"ingredients"`
+	Steps        []string          `json:"steps"`
+	Nutrition    map[string]string `json:"nutrition,omitempty"` // e.g. "calories": "240 kcal", "fat": "9 g"
+	Rating       *Rating           `json:"rating,omitempty"`
+}
+
+// extractRecipe recognizes recipe pages by a schema.org Recipe (JSON-LD or
+// microdata) or h-recipe/hRecipe microformats
+func extractRecipe(page *extractorPage) (interface{}, bool) {
+	var recipe Recipe
+	if item := page.first("Recipe"); item != nil {
+		recipe = schemaRecipe(item, page.URL)
+	} else if root := page.Doc.Find(".h-recipe, .hrecipe").First(); root.Length() > 0 {
+		recipe = microformatRecipe(root, page.URL)
+	}
+	if recipe.Name == "" || (len(recipe.Ingredients) == 0 && len(recipe.Steps) == 0) {
+		return nil, false
+	}
+	return recipe, true
+}
+
+// schemaRecipe normalizes a schema.org Recipe
+func schemaRecipe(item schemaObject, pageURL string) Recipe {
+	recipe := Recipe{
+		Name:         item.str("name"),
+		Description:  schemaPlainText(item["description"]),
+		Author:       item.str("author"),
+		PrepMinutes:  durationMinutes(item.str("prepTime")),
+		CookMinutes:  durationMinutes(item.str("cookTime")),
+		TotalMinutes: durationMinutes(item.str("totalTime")),
+		Category:     strings.Join(item.strs("recipeCategory"), ", "),
+		Cuisine:      strings.Join(item.strs("recipeCuisine"), ", "),
+		Ingredients:  item.strs("recipeIngredient"),
+		Steps:        instructionSteps(item["recipeInstructions"]),
+	}
+	if len(recipe.Ingredients) == 0 {
+		recipe.Ingredients = item.strs("ingredients") // The superseded property name
+	}
+	for _, yield := range item.strs("recipeYield") { // Often both "4" and "4 servings"
+		if len(yield) > len(recipe.Yield) {
+			recipe.Yield = yield
+		}
+	}
+	for _, image := range item.strs("image") {
+		recipe.Images = appendUnique(recipe.Images, resolveURL(pageURL, image))
+	}
+	if nutrition := item.object("nutrition"); nutrition != nil {
+		for property := range nutrition {
+			if value := nutrition.str(property); value != "" && !strings.HasPrefix(property, "@") {
+				recipe.addNutrition(property, value)
+			}
+		}
+	}
+	if rating := item.object("aggregateRating"); rating != nil {
+		if value, ok := schemaNumber(rating["ratingValue"]); ok {
+			recipe.Rating = &Rating{Value: value}
+			recipe.Rating.Best, _ = schemaNumber(rating["bestRating"])
+			count, ok := schemaNumber(rating["ratingCount"])
+			if !ok {
+				count, _ = schemaNumber(rating["reviewCount"])
+			}
+			recipe.Rating.Count = int(count)
+		}
+	}
+	return recipe
+}
+
+// instructionSteps flattens recipeInstructions, which may be text (plain, one step
+// per line, or HTML), HowToSteps or HowToSections of steps
+func instructionSteps(value interface{}) []string {
+	var steps []string
+	for _, entry := range schemaList(value) {
+		if object, ok := asSchemaObject(entry); ok {
+			if object["itemListElement"] != nil { // HowToSection or ItemList
+				steps = append(steps, instructionSteps(object["itemListElement"])...)
+			} else if text := firstNonEmpty(schemaPlainText(object["text"]), object.str("name")); text != "" {
+				steps = append(steps, text)
+			}
+			continue
+		}
+		text := schemaText(entry)
+		if strings.Contains(text, "<") {
+			if doc, err := goquery.NewDocumentFromReader(strings.NewReader(text)); err == nil {
+				steps = append(steps, elementSteps(doc.Selection)...)
+				continue
+			}
+		}
+		for _, line := range strings.Split(text, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				steps = append(steps, line)
+			}
+		}
+	}
+	return steps
+}
+
+// elementSteps splits an instructions element into its list items or paragraphs
+func elementSteps(instructions *goquery.Selection) []string {
+	var steps []string
+	parts := instructions.Find("li")
+	if parts.Length() == 0 {
+		parts = instructions.Find("p")
+	}
+	if parts.Length() == 0 {
+		parts = instructions
+	}
+	parts.Each(func(_ int, part *goquery.Selection) {
+		if text := strings.Join(strings.Fields(part.Text()), " "); text != "" {
+			steps = append(steps, text)
+		}
+	})
+	return steps
+}
+
+// microformatRecipe reads an h-recipe (or the older hRecipe) element
+func microformatRecipe(root *goquery.Selection, pageURL string) Recipe {
+	text := func(selectors ...string) string { // The first selector matching wins
+		for _, selector := range selectors {
+			if match := root.Find(selector).First(); match.Length() > 0 {
+				return strings.Join(strings.Fields(match.Text()), " ")
+			}
+		}
+		return ""
+	}
+	recipe := Recipe{
+		Name:        text(".p-name", ".fn"),
+		Description: text(".p-summary", ".summary"),
+		Author:      text(".p-author", ".author"),
+		Yield:       text(".p-yield", ".yield"),
+	}
+	if duration := root.Find(".dt-duration, .duration").First(); duration.Length() > 0 {
+		recipe.TotalMinutes = durationMinutes(duration.AttrOr("datetime", duration.AttrOr("title", duration.Text())))
+	}
+	root.Find(".p-ingredient, .ingredient").Each(func(_ int, ingredient *goquery.Selection) {
+		if text := strings.Join(strings.Fields(ingredient.Text()), " "); text != "" {
+			recipe.Ingredients = append(recipe.Ingredients, text)
+		}
+	})
+	if instructions := root.Find(".e-instructions, .instructions").First(); instructions.Length() > 0 {
+		recipe.Steps = elementSteps(instructions)
+	}
+	root.Find(".p-nutrition, .nutrition").Each(func(_ int, nutrition *goquery.Selection) {
+		if name, value, ok := strings.Cut(strings.Join(strings.Fields(nutrition.Text()), " "), ":"); ok {
+			recipe.addNutrition(name, strings.TrimSpace(value))
+		}
+	})
+	root.Find(".u-photo, .photo").Each(func(_ int, photo *goquery.Selection) {
+		recipe.Images = appendUnique(recipe.Images, resolveURL(pageURL, photo.AttrOr("src", photo.AttrOr("href", ""))))
+	})
+	return recipe
+}
+
+// addNutrition stores a nutrition value under a snake case name without the
+// schema.org "Content" suffix ("saturatedFatContent" -> "saturated_fat")
+func (recipe *Recipe) addNutrition(name, value string) {
+	name = strings.TrimSuffix(strings.TrimSpace(name), "Content")
+	if strings.Contains(name, " ") { // Microformat labels such as "Total Fat"
+		name = strings.Join(strings.Fields(strings.ToLower(name)), "_")
+	} else {
+		name = schemaEnum(name)
+	}
+	if name == "" || value == "" {
+		return
+	}
+	if recipe.Nutrition == nil {
+		recipe.Nutrition = map[string]string{}
+	}
+	recipe.Nutrition[name] = value
+}
+
+// durationMinutes converts an ISO 8601 duration (PT1H30M) to whole minutes
+func durationMinutes(value string) int {
+	return int(parseMediaDuration(value)/60 + 0.5)
+}
+
+// markdownSection renders the recipe as a section appended to the page markdown,
+// so the ingredients and steps read the same on every site
+func (recipe Recipe) markdownSection() string {
+	var section strings.Builder
+	section.WriteString("## Recipe: " + escapeMarkdown(recipe.Name) + "\n\n")
+	for _, detail := range []struct {
+		label string
+		value string
+	}{
+		{"Yield", recipe.Yield},
+		{"Prep time", minutesText(recipe.PrepMinutes)},
+		{"Cook time", minutesText(recipe.CookMinutes)},
+		{"Total time", minutesText(recipe.TotalMinutes)},
+	} {
+		if detail.value != "" {
+			section.WriteString("* " + detail.label + ": " + escapeMarkdown(detail.value) + "\n")
+		}
+	}
+
+	if len(recipe.Ingredients) > 0 {
+		section.WriteString("\n### Ingredients\n\n")
+		for _, ingredient := range recipe.Ingredients {
+			section.WriteString("* " + escapeMarkdown(ingredient) + "\n")
+		}
+	}
+	if len(recipe.Steps) > 0 {
+		section.WriteString("\n### Instructions\n\n")
+		for i, step := range recipe.Steps {
+			section.WriteString(fmt.Sprintf("%d. %s\n", i+1, escapeMarkdown(step)))
+		}
+	}
+	if len(recipe.Nutrition) > 0 {
+		section.WriteString("\n### Nutrition\n\n")
+		names := make([]string, 0, len(recipe.Nutrition))
+		for name := range recipe.Nutrition {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			label := []rune(strings.ReplaceAll(name, "_", " "))
+			label[0] = unicode.ToUpper(label[0])
+			section.WriteString("* " + string(label) + ": " + escapeMarkdown(recipe.Nutrition[name]) + "\n")
+		}
+	}
+	return section.String()
+}
+
+// minutesText formats a recipe time as "45 min" or "1 h 30 min", empty when unknown
+func minutesText(minutes int) string {
+	switch {
+	case minutes == 0:
+		return ""
+	case minutes < 60:
+		return fmt.Sprintf("%d min", minutes)
+	case minutes%60 == 0:
+		return fmt.Sprintf("%d h", minutes/60)
+	default:
+		return fmt.Sprintf("%d h %d min", minutes/60, minutes%60)
+	}
+}
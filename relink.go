@@ -0,0 +1,127 @@
+package main
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// inlineLinkDestination matches the destination of an inline link or image, with its
+// optional title: the "(https://example.com/a "Title")" of [text](...)
+var inlineLinkDestination = regexp.MustCompile(`\]\((<[^<>\n]*>|[^\s()<>]+)([ \t]+"[^"\n]*")?\)`)
+
+// codeFence matches the fence opening or closing a code block
+var codeFence = regexp.MustCompile("^ {0,3}(`{3,}|~{3,})")
+
+// pageLinker maps links between the pages of a job to where an export stores them,
+// so exported pages link to each other instead of back to the live site
+type pageLinker struct {
+	pages  map[string]string // Page URL without fragment to its target
+	target func(pageURL string) string
+}
+
+// newPageLinker links the pages of results to target(pageURL): relative file paths,
+// API URLs. URLs differing only by a trailing slash link to the same page.
+func newPageLinker(results map[string]*CrawledData, target func(pageURL string) string) *pageLinker {
+	linker := &pageLinker{pages: make(map[string]string, len(results)), target: target}
+	for pageURL := range results {
+		linker.pages[pageURL] = pageURL
+	}
+	for pageURL := range results {
+		for _, alias := range []string{strings.TrimSuffix(pageURL, "/"), pageURL + "/"} {
+			if _, ok := linker.pages[alias]; !ok {
+				linker.pages[alias] = pageURL
+			}
+		}
+	}
+	return linker
+}
+
+// rewrite returns the markdown of the page at pageURL with its inline links to other
+// pages of the job pointing at their targets. Links to pages outside the job, autolinks
+// (which show their URL) and code are left alone.
+func (l *pageLinker) rewrite(pageURL string, markdown string) string {
+	if !strings.Contains(markdown, "](") {
+		return markdown
+	}
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return markdown
+	}
+	lines := strings.SplitAfter(markdown, "\n")
+	fence := ""
+	for i, line := range lines {
+		if fence != "" {
+			if marker := codeFence.FindStringSubmatch(line); marker != nil && marker[1][0] == fence[0] && len(marker[1]) >= len(fence) {
+				fence = ""
+			}
+			continue
+		}
+		if marker := codeFence.FindStringSubmatch(line); marker != nil {
+			fence = marker[1]
+			continue
+		}
+		if strings.Contains(line, "](") {
+			lines[i] = outsideCodeSpans(line, func(text string) string {
+				return inlineLinkDestination.ReplaceAllStringFunc(text, func(link string) string {
+					return l.rewriteLink(base, link)
+				})
+			})
+		}
+	}
+	return strings.Join(lines, "")
+}
+
+// rewriteLink rewrites one match of inlineLinkDestination
+func (l *pageLinker) rewriteLink(base *url.URL, link string) string {
+	match := inlineLinkDestination.FindStringSubmatch(link)
+	destination, title := match[1], match[2]
+	angled := strings.HasPrefix(destination, "<")
+	destination = strings.TrimSuffix(strings.TrimPrefix(destination, "<"), ">")
+	resolved, err := base.Parse(destination)
+	if err != nil || (resolved.Scheme != "http" && resolved.Scheme != "https") {
+		return link
+	}
+	fragment := resolved.EscapedFragment()
+	resolved.Fragment, resolved.RawFragment = "", ""
+	pageURL, ok := l.pages[resolved.String()]
+	if !ok {
+		return link
+	}
+	target := l.target(pageURL)
+	if fragment != "" {
+		target += "#" + fragment
+	}
+	if angled {
+		target = "<" + target + ">"
+	}
+	return "](" + target + title + ")"
+}
+
+// outsideCodeSpans applies rewrite to the parts of a line outside its code spans
+func outsideCodeSpans(line string, rewrite func(string) string) string {
+	if !strings.Contains(line, "`") {
+		return rewrite(line)
+	}
+	var out strings.Builder
+	for line != "" {
+		start := strings.Index(line, "`")
+		if start < 0 {
+			out.WriteString(rewrite(line))
+			break
+		}
+		out.WriteString(rewrite(line[:start]))
+		run := len(line[start:]) - len(strings.TrimLeft(line[start:], "`"))
+		ticks := line[start : start+run]
+		end := strings.Index(line[start+run:], ticks)
+		if end < 0 { // An unclosed run is literal text
+			out.WriteString(ticks)
+			line = line[start+run:]
+			continue
+		}
+		spanEnd := start + run + end + run
+		out.WriteString(line[start:spanEnd])
+		line = line[spanEnd:]
+	}
+	return out.String()
+}
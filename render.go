@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	east "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// markdownRenderer converts generated markdown back to HTML. Raw HTML in the markdown
+// is dropped and dangerous link schemes (javascript:, vbscript:, ...) are removed, so
+// the output is safe to show even though it comes from crawled pages.
+var markdownRenderer = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+// previewStyle keeps rendered previews readable without external assets
+const previewStyle = `body{font-family:system-ui,sans-serif;max-width:48rem;margin:2rem auto;padding:0 1rem;line-height:1.5;color:#222}
+pre{background:#f3f4f6;padding:.75rem;overflow:auto}code{font-size:.9em}img{max-width:100%}
+table{border-collapse:collapse}th,td{border:1px solid #ddd;padding:.25rem .5rem}`
+
+// renderMarkdownHTML renders markdown to a sanitized HTML fragment
+func renderMarkdownHTML(markdown string) (string, error) {
+	var buf bytes.Buffer
+	if err := markdownRenderer.Convert([]byte(markdown), &buf); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// renderPreviewDocument wraps a rendered page in a standalone HTML document
+func renderPreviewDocument(title string, body string) string {
+	return "<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\"><title>" + html.EscapeString(title) +
+		"</title><style>" + previewStyle + "</style></head><body>\n" + body + "</body></html>\n"
+}
+
+// blankLines matches runs of blank lines in rendered plain text
+var blankLines = regexp.MustCompile(`\n{3,}`)
+
+// renderMarkdownText renders markdown as plain text: the prose, list items, table
+// rows and code of the page without markup, a blank line between blocks
+func renderMarkdownText(markdown string) string {
+	source := []byte(markdown)
+	var out strings.Builder
+	ast.Walk(markdownRenderer.Parser().Parse(text.NewReader(source)), func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		switch node := node.(type) {
+		case *ast.Text:
+			if entering {
+				value := node.Segment.Value(source)
+				if _, code := node.Parent().(*ast.CodeSpan); !code {
+					value = util.UnescapePunctuations(value)
+				}
+				out.Write(value)
+				if node.HardLineBreak() {
+					out.WriteString("\n")
+				} else if node.SoftLineBreak() {
+					out.WriteString(" ")
+				}
+			}
+		case *ast.String:
+			if entering {
+				out.Write(node.Value)
+			}
+		case *ast.AutoLink:
+			if entering {
+				out.Write(node.Label(source))
+			}
+		case *ast.RawHTML, *ast.HTMLBlock:
+			return ast.WalkSkipChildren, nil
+		case *ast.CodeBlock, *ast.FencedCodeBlock:
+			if entering {
+				lines := node.Lines()
+				for i := 0; i < lines.Len(); i++ {
+					segment := lines.At(i)
+					out.Write(segment.Value(source))
+				}
+				out.WriteString("\n")
+			}
+			return ast.WalkSkipChildren, nil
+		case *ast.ListItem:
+			if entering {
+				list := node.Parent().(*ast.List)
+				if list.IsOrdered() {
+					index := list.Start
+					for sibling := node.PreviousSibling(); sibling != nil; sibling = sibling.PreviousSibling() {
+						index++
+					}
+					out.WriteString(strconv.Itoa(index) + ". ")
+				} else {
+					out.WriteString("- ")
+				}
+			}
+		case *ast.TextBlock, *east.TableHeader, *east.TableRow:
+			if !entering {
+				out.WriteString("\n")
+			}
+		case *east.TableCell:
+			if !entering && node.NextSibling() != nil {
+				out.WriteString("\t")
+			}
+		case *ast.Paragraph, *ast.Heading, *ast.List, *east.Table:
+			if !entering {
+				out.WriteString("\n\n")
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+	return strings.TrimSpace(blankLines.ReplaceAllString(out.String(), "\n\n")) + "\n"
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"sort"
+)
+
+// PageReport summarizes a single crawled page in the crawl report
+type PageReport struct {
+	URL     string             `json:"url"`
+	Title   string             `json:"title"`
+	Stats   ContentStats       `json:"stats"`
+	Quality *ExtractionQuality `json:"quality,omitempty"`
+}
+
+// CrawlReport aggregates per-page results of a crawl
+type CrawlReport struct {
+	StartURL                string                `json:"start_url"`
+	PagesCrawled            int                   `json:"pages_crawled"`
+	TotalWords              int                   `json:"total_words"`
+	TotalReadingTimeMinutes int                   `json:"total_reading_time_minutes"`
+	TotalLinks              int                   `json:"total_links"`
+	TotalImages             int                   `json:"total_images"`
+	TotalCodeBlocks         int                   `json:"total_code_blocks"`
+	LowQualityPages         []string              `json:"low_quality_pages"`       // URLs whose extraction needs a closer look
+	WalledPages             []string              `json:"walled_pages,omitempty"`  // URLs behind a paywall or login, see AccessWall
+	Excluded                []ExcludedPage        `json:"excluded,omitempty"`      // Low-value pages left out of the results, with their scores
+	Accessibility           *AccessibilitySummary `json:"accessibility,omitempty"` // Issues of crawls with accessibility audits
+	Security                []HostSecurity        `json:"security,omitempty"`      // Per-host security posture of crawls with security reports
+	Hosts                   []HostStats           `json:"hosts,omitempty"`         // Fetch statistics per host
+	Failed                  []FailedPage          `json:"failed,omitempty"`        // Pages whose fetch failed, with the HTTP status of error responses
+	Skipped                 []SkippedContent      `json:"skipped,omitempty"`       // URLs the content filter skipped, by reason and type
+	Traps                   []TrapWarning         `json:"traps,omitempty"`         // Crawler traps and redirect loops the crawl stayed out of
+	Bandwidth               *Bandwidth            `json:"bandwidth,omitempty"`     // Bytes downloaded by static fetches
+	Boilerplate             []SiteBoilerplate     `json:"boilerplate,omitempty"`   // Lines stripped as boilerplate of each site, see learn_boilerplate
+	Pages                   []PageReport          `json:"pages"`
+	Contacts                []SiteContacts        `json:"contacts,omitempty"` // Per-site contacts of crawls with contact harvesting
+	Families                []DocumentFamily      `json:"families,omitempty"` // Locales crawled of each page with hreflang alternates
+	Timings                 []PhaseTiming         `json:"timings,omitempty"`  // Percentiles of the time pages spent in each phase, see PageTimings
+}
+
+// NewCrawlReport builds a report from the data returned by Crawl
+func NewCrawlReport(startURL string, crawledDataMap map[string]*CrawledData) *CrawlReport {
+	report := &CrawlReport{
+		StartURL:        startURL,
+		LowQualityPages: []string{},
+		Pages:           []PageReport{},
+	}
+	for pageURL, data := range crawledDataMap {
+		report.Pages = append(report.Pages, PageReport{
+			URL:     pageURL,
+			Title:   data.Metadata["title"],
+			Stats:   data.Stats,
+			Quality: data.Quality,
+		})
+		if data.Quality != nil && data.Quality.Low {
+			report.LowQualityPages = append(report.LowQualityPages, pageURL)
+		}
+		if data.Metadata["access_wall"] != "" {
+			report.WalledPages = append(report.WalledPages, pageURL)
+		}
+		report.TotalWords += data.Stats.Words
+		report.TotalReadingTimeMinutes += data.Stats.ReadingTimeMinutes
+		report.TotalLinks += data.Stats.Links
+		report.TotalImages += data.Stats.Images
+		report.TotalCodeBlocks += data.Stats.CodeBlocks
+	}
+	report.PagesCrawled = len(report.Pages)
+	report.Contacts = buildContactReport(crawledDataMap)
+	report.Families = buildFamilyReport(crawledDataMap)
+	report.Accessibility = buildAccessibilitySummary(crawledDataMap)
+	report.Timings = buildTimingReport(crawledDataMap)
+	sort.Slice(report.Pages, func(i, j int) bool { return report.Pages[i].URL < report.Pages[j].URL })
+	sort.Strings(report.LowQualityPages)
+	sort.Strings(report.WalledPages)
+	return report
+}
+
+// Report builds the report of a finished crawl, including what the crawler
+// recorded besides the pages: excluded pages, host security and host statistics
+func (c *Crawler) Report(startURL string, crawledDataMap map[string]*CrawledData) *CrawlReport {
+	report := NewCrawlReport(startURL, crawledDataMap)
+	report.Excluded = c.ExcludedPages()
+	report.Security = c.SecurityReport()
+	report.Hosts = c.HostReport()
+	report.Failed = c.FailedPages()
+	report.Skipped = c.SkippedReport()
+	report.Traps = c.TrapReport()
+	report.Bandwidth = c.BandwidthReport()
+	report.Boilerplate = c.BoilerplateReport()
+	return report
+}
+
+// withCrawlRecords copies what the crawler recorded besides the pages from the
+// report of an earlier build over the same crawl, such as a reprocessed one's
+func (report *CrawlReport) withCrawlRecords(previous *CrawlReport) *CrawlReport {
+	if previous == nil {
+		return report
+	}
+	report.Excluded = previous.Excluded
+	report.Security = previous.Security
+	report.Hosts = previous.Hosts
+	report.Failed = previous.Failed
+	report.Skipped = previous.Skipped
+	report.Traps = previous.Traps
+	report.Bandwidth = previous.Bandwidth
+	report.Boilerplate = previous.Boilerplate
+	return report
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ReprocessRequest is the JSON body of POST /jobs/:id/reprocess. Omitted settings
+// keep the values the job was crawled with.
+type ReprocessRequest struct {
+	EnableReadability *bool         `json:"readability"`
+	HeuristicsEnabled *bool         `json:"heuristics"`
+	StripSelectors    []string      `json:"strip_selectors"`
+	SiteProfiles      []SiteProfile `json:"site_profiles"`
+	ContentSelector   *string       `json:"content_selector"`
+	MarkdownHeader    *string       `json:"markdown_header"`
+	MarkdownFooter    *string       `json:"markdown_footer"`
+	MarkdownFlavor    *string       `json:"markdown_flavor"`
+}
+
+// apply returns the crawl request and config with the reprocessing settings applied
+func (r ReprocessRequest) apply(request CrawlRequest, config CrawlerConfig) (CrawlRequest, CrawlerConfig) {
+	if r.EnableReadability != nil {
+		request.EnableReadability = *r.EnableReadability
+		config.EnableReadability = *r.EnableReadability
+	}
+	if r.HeuristicsEnabled != nil {
+		request.HeuristicsEnabled = *r.HeuristicsEnabled
+		config.HeuristicsEnabled = *r.HeuristicsEnabled
+	}
+	if r.StripSelectors != nil {
+		request.StripSelectors = r.StripSelectors
+		config.StripSelectors = r.StripSelectors
+	}
+	if r.ContentSelector != nil {
+		request.ContentSelector = *r.ContentSelector
+		config.ContentSelector = *r.ContentSelector
+	}
+	if r.SiteProfiles != nil {
+		request.SiteProfiles = r.SiteProfiles
+		config.SiteProfiles = r.SiteProfiles
+	}
+	if r.MarkdownHeader != nil {
+		request.MarkdownHeader = *r.MarkdownHeader
+		config.MarkdownHeader = *r.MarkdownHeader
+	}
+	if r.MarkdownFooter != nil {
+		request.MarkdownFooter = *r.MarkdownFooter
+		config.MarkdownFooter = *r.MarkdownFooter
+	}
+	if r.MarkdownFlavor != nil {
+		request.MarkdownFlavor = *r.MarkdownFlavor
+		config.MarkdownFlavor = *r.MarkdownFlavor
+	}
+	return request, config
+}
+
+// validate checks the selectors, templates and profiles of the request
+func (r ReprocessRequest) validate() error {
+	var contentSelector []string
+	if r.ContentSelector != nil {
+		contentSelector = nonEmpty(*r.ContentSelector)
+	}
+	if err := validateSelectors(append(contentSelector, r.StripSelectors...)); err != nil {
+		return err
+	}
+	var header, footer string
+	if r.MarkdownHeader != nil {
+		header = *r.MarkdownHeader
+	}
+	if r.MarkdownFooter != nil {
+		footer = *r.MarkdownFooter
+	}
+	if err := validateMarkdownTemplates(header, footer); err != nil {
+		return err
+	}
+	if r.MarkdownFlavor != nil {
+		if err := validateMarkdownFlavor(*r.MarkdownFlavor); err != nil {
+			return err
+		}
+	}
+	return validateSiteProfiles(r.SiteProfiles)
+}
+
+// Reprocess re-runs extraction over the raw HTML stored with a completed job's pages
+// using new settings and replaces the job's results, without refetching anything.
+// Pages stored without HTML (metadata-only crawls) are kept as they are.
+func (m *JobManager) Reprocess(tenant *Tenant, id string, reprocess ReprocessRequest) (Job, error) {
+	m.mutex.Lock()
+	job, err := m.lookup(tenant, id)
+	if err != nil {
+		m.mutex.Unlock()
+		return Job{}, err
+	}
+	if job.Status != JobCompleted {
+		m.mutex.Unlock()
+		return Job{}, errJobNotDone
+	}
+	if !job.config.KeepRawHTML {
+		m.mutex.Unlock()
+		return Job{}, errNoRawHTML
+	}
+	request, config := reprocess.apply(job.Request, job.config)
+	previous := job.results
+	m.mutex.Unlock()
+
+	crawler := NewCrawler(config)
+	started := time.Now()
+	results := make(map[string]*CrawledData, len(previous))
+	var storageBytes int64
+	for pageURL, page := range previous {
+		data := page
+		if page.hasRawHTML() {
+			data, err = crawler.reprocessPage(page)
+			if err != nil {
+				return Job{}, fmt.Errorf("reprocessing %s: %w", pageURL, err)
+			}
+		}
+		results[pageURL] = data
+		storageBytes += crawledDataSize(data)
+	}
+	took := time.Since(started).Seconds() // Extraction only, all of it CPU-bound
+	usage := ResourceUsage{CPUSeconds: took, WallSeconds: took}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.jobs[id] != job || job.Status != JobCompleted {
+		return Job{}, errors.New("job changed while reprocessing")
+	}
+	tenant.adjustStorage(storageBytes - job.StorageBytes)
+	tenant.recordUsage(usage)
+	job.Usage = job.Usage.add(usage)
+	job.Usage.StorageBytes = storageBytes
+	job.Request = request
+	job.config = config
+	job.results = results
+	job.updatedAt = time.Now()
+	job.StorageBytes = storageBytes
+	job.Report = NewCrawlReport(config.StartURL, results).withCrawlRecords(job.Report)
+	removeExports(job.ID) // Exports of the previous results are stale
+	return job.snapshot(), nil
+}
+
+// reprocessPage extracts a stored page again, keeping what extraction doesn't
+// produce (screenshot, fetch diagnostics, downloaded site images, transcripts,
+// comments, accessibility audits, image data and sentiment). Enrichments of the
+// markdown are applied to the new markdown in the order of the enrich stage without
+// asking their models again: generated alt text, the translation (only text that
+// changed is translated) and the mentions of entities and topics.
+func (c *Crawler) reprocessPage(page *CrawledData) (*CrawledData, error) {
+	extract := c.extractPage
+	if contentType := page.Metadata["content_type"]; isDocumentContentType(contentType) {
+		extract = func(pageURL, body string) (*CrawledData, error) { return c.extractDocument(pageURL, contentType, body) }
+	}
+	rawHTML, err := page.rawHTML()
+	if err != nil {
+		return nil, err
+	}
+	data, err := extract(page.URL, rawHTML)
+	if err != nil {
+		return nil, err
+	}
+	data.shareRawHTML(page)
+	data.ScreenshotPath = page.ScreenshotPath
+	data.Diagnostics = page.Diagnostics
+	for _, key := range []string{"favicon", "og_image", "comments", "accessibility", "image_data", "sentiment", "entities", "topics"} {
+		if value, ok := page.StructuredData[key]; ok {
+			data.StructuredData[key] = value
+		}
+	}
+	if transcripts, ok := page.StructuredData["transcripts"].([]Transcript); ok {
+		appendTranscripts(data, transcripts)
+	}
+	alts := make(map[string]string)
+	media, _ := page.StructuredData["media"].([]MediaItem)
+	for _, item := range media {
+		if item.AltGenerated {
+			alts[item.URL] = item.Alt
+		}
+	}
+	useAltText(data, alts)
+	if translation, ok := page.StructuredData["translation"].(Translation); ok {
+		provider, _ := translatorFromEnv() // Without one, text that changed stays untranslated
+		c.translateWith(data, newTranslationMemory(provider, page, translation))
+	}
+	relocateEntities(data)
+	c.relocateTopics(data)
+	data.ContentHash = contentHash(data)
+	return data, nil
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// defaultMaxDepth is the crawl depth used when a request doesn't specify one
+const defaultMaxDepth = 2
+
+// maxHostDelayMS bounds host_delay_ms, so a typo can't stall a job for hours
+const maxHostDelayMS = 60000
+
+// CrawlRequest is the JSON body accepted by the job endpoints
+type CrawlRequest struct {
+	URL               string            `json:"url"`
+	Archive           string            `json:"archive"` // WARC or HAR file under LEXICRAWLER_FILE_ROOT to replay instead of fetching
+	Seeds             []Seed            `json:"seeds"`   // More start pages, e.g. POST requests to search forms or GraphQL endpoints
+	AllowedDomains    []string          `json:"allowed_domains"`
+	MaxDepth          *int              `json:"max_depth"`
+	EnableJS          bool              `json:"js"`
+	EnableScreenshots bool              `json:"screenshots"`
+	CacheEnabled      bool              `json:"cache"`
+	HeuristicsEnabled bool              `json:"heuristics"`
+	EnableReadability bool              `json:"readability"`
+	FetchSiteImages   bool              `json:"images"`
+	ImageTargetWidth  int               `json:"image_target_width"`    // Preferred srcset candidate width, 0 for the largest
+	FetchTranscripts  bool              `json:"transcripts"`           // Attach caption tracks and transcript pages of media
+	TranscribeMedia   bool              `json:"transcribe"`            // Transcribe media without captions (needs LEXICRAWLER_WHISPER_URL)
+	ExtractComments   bool              `json:"comments"`              // Collect comment threads into structured data
+	HarvestContacts   bool              `json:"contacts"`              // Collect emails, phones and social profiles into a contact report
+	FollowHreflang    bool              `json:"hreflang"`              // Crawl every hreflang alternate of the pages as a document family
+	TranslateTo       string            `json:"translate_to"`          // Translate pages in other languages into this one (needs LEXICRAWLER_TRANSLATE_PROVIDER)
+	Entities          string            `json:"entities"`              // "local" or "llm" (needs LEXICRAWLER_NER_URL) to extract named entities and their relations
+	Topics            *TopicConfig      `json:"topics"`                // Classify pages into a taxonomy, tagging pages and chunks
+	Sentiment         string            `json:"sentiment"`             // "lexicon" or "llm" (needs LEXICRAWLER_SENTIMENT_URL) to score the sentiment of pages and comments
+	DocsVersion       string            `json:"docs_version"`          // "tag" to tag pages with their docs version, "seed" or a version such as "v2" to crawl only that one
+	AltText           bool              `json:"alt_text"`              // Generate alt text for images without any (needs LEXICRAWLER_VISION_URL)
+	AltTextMaxImages  int               `json:"alt_text_max_images"`   // Images described per crawl, default 100
+	AltTextPerMinute  int               `json:"alt_text_per_minute"`   // Requests to the vision model per minute, default 30
+	ImageData         bool              `json:"image_data"`            // Read the data of charts and tables published as images (needs LEXICRAWLER_VISION_URL)
+	ImageDataLimit    int               `json:"image_data_max_images"` // Images read for data per crawl, default 20
+	ImageDataRate     int               `json:"image_data_per_minute"` // Requests for image data per minute, default 30
+	MetadataOnly      bool              `json:"metadata_only"`
+	MaxPages          int               `json:"max_pages"`
+	MaxConcurrency    int               `json:"max_concurrency"`     // Workers per network-bound pipeline stage (fetching, rendering, ...), default 8
+	HostConcurrency   int               `json:"host_concurrency"`    // Pages of one host fetched at once, default no limit
+	HostDelayMS       int               `json:"host_delay_ms"`       // Milliseconds between the starts of two fetches from one host
+	MemoryBudgetMB    int               `json:"memory_budget_mb"`    // Results kept in memory before raw HTML is spilled to disk, default 512
+	KeepRawHTML       bool              `json:"keep_raw_html"`       // Keep the raw HTML of pages (compressed), needed to reprocess the job
+	ExtractWorkers    int               `json:"extract_workers"`     // Workers converting pages to markdown, default one per CPU
+	Dedup             string            `json:"dedup"`               // "exact", or "bloom" to bound the memory of crawls of millions of URLs
+	DedupExpectedURLs int               `json:"dedup_expected_urls"` // URLs the bloom filter is sized for, default 10 million
+	URLPriority       *URLPriority      `json:"url_priority"`        // Crawl the most valuable pages first, by pattern weights, depth and keywords
+	DisableJSFallback bool              `json:"disable_js_fallback"` // Don't retry low-quality pages with JS rendering
+	KeepCookieBanners bool              `json:"keep_cookie_banners"` // Don't dismiss cookie consent banners when rendering with JS
+	ExcludeLowValue   bool              `json:"exclude_low_value"`   // Leave thin and low-value pages out of the results
+	LearnBoilerplate  bool              `json:"learn_boilerplate"`   // Strip lines repeated on most pages of a site, such as navigation labels
+	Accessibility     bool              `json:"accessibility"`       // Audit pages rendered with JS for accessibility issues
+	SecurityReport    bool              `json:"security"`            // Report the security headers and TLS certificate of every host
+	LowValueThreshold float64           `json:"low_value_threshold"` // Low-value score (0 to 1) from which pages are excluded, default 0.6
+	FetchRules        []FetchRule       `json:"fetch_rules"`         // Per-URL-pattern static or JS fetching
+	StripSelectors    []string          `json:"strip_selectors"`     // Replaces the default elements removed before conversion
+	SiteProfiles      []SiteProfile     `json:"site_profiles"`       // Per-URL-pattern extraction overrides
+	ContentSelector   string            `json:"content_selector"`    // Element(s) holding the main content, e.g. "main" or "article"
+	MarkdownHeader    string            `json:"markdown_header"`     // Go text/template of the metadata preamble of every page's markdown
+	MarkdownFooter    string            `json:"markdown_footer"`     // Go text/template appended to every page's markdown
+	MarkdownFlavor    string            `json:"markdown_flavor"`     // gfm (default), commonmark or obsidian
+	JSONProjections   map[string]string `json:"json_projections"`    // Name to JSONPath, evaluated on JSON responses
+	AcceptLanguage    string            `json:"accept_language"`     // Sent as the Accept-Language header, e.g. "de-DE,de;q=0.9"
+	Region            string            `json:"region"`              // Exit region of the proxy to crawl through, see LEXICRAWLER_PROXIES
+	Priority          int               `json:"priority"`            // Jobs with higher priority are scheduled first
+	Preemptible       bool              `json:"preemptible"`         // Allow pausing this job for higher-priority ones
+	Sinks             []SinkConfig      `json:"sinks"`
+	Chunking          *ChunkerConfig    `json:"chunking"`         // How the chunks of pages are split, for the chunks endpoint and sinks
+	Corpus            string            `json:"corpus"`           // Named corpus the pages of a completed job are added to
+	OutputTemplates   []OutputTemplate  `json:"output_templates"` // Custom outputs rendered from the results, see GET /jobs/:id/export
+	Alerts            *AlertConfig      `json:"alerts"`           // Rules evaluated against the previous crawl of the site when the job completes
+	Notify            []NotifyTarget    `json:"notify"`           // Webhooks, Slack and Discord channels notified when the job finishes and of alert hits
+	ContentFilter     *ContentFilter    `json:"content_filter"`   // Extensions and content types not downloaded, by default binary assets
+	MaxRedirects      *int              `json:"max_redirects"`    // Redirects a static fetch follows, default 10; 0 follows none
+	Traps             *TrapLimits       `json:"traps"`            // Limits on calendars, faceted navigation and other URLs generated without end
+}
+
+// toConfig validates the request and converts it into a CrawlerConfig
+func (r CrawlRequest) toConfig() (CrawlerConfig, error) {
+	var archivePath string
+	if r.Archive != "" {
+		path, err := checkArchivePath(r.Archive, fileRootFromEnv())
+		if err != nil {
+			return CrawlerConfig{}, err
+		}
+		archivePath = path
+	}
+	if r.URL == "" && archivePath == "" && len(r.Seeds) == 0 {
+		return CrawlerConfig{}, errors.New("url is required")
+	}
+	if err := validateSeeds(r.Seeds); err != nil {
+		return CrawlerConfig{}, err
+	}
+	if r.Corpus != "" {
+		if err := validateCorpusName(r.Corpus); err != nil {
+			return CrawlerConfig{}, err
+		}
+	}
+	parsedURL := &url.URL{}
+	if r.URL != "" {
+		var err error
+		parsedURL, err = url.ParseRequestURI(r.URL)
+		if err != nil || (parsedURL.Hostname() == "" && parsedURL.Scheme != "file") {
+			return CrawlerConfig{}, errors.New("invalid url")
+		}
+	}
+	if parsedURL.Scheme == "file" {
+		if err := checkFileURL(parsedURL, fileRootFromEnv()); err != nil {
+			return CrawlerConfig{}, err
+		}
+	}
+
+	config := CrawlerConfig{
+		StartURL:           r.URL,
+		AllowedDomains:     r.AllowedDomains,
+		MaxDepth:           defaultMaxDepth,
+		EnableJS:           r.EnableJS,
+		EnableScreenshots:  r.EnableScreenshots,
+		CacheEnabled:       r.CacheEnabled,
+		HeuristicsEnabled:  r.HeuristicsEnabled,
+		EnableReadability:  r.EnableReadability,
+		FetchSiteImages:    r.FetchSiteImages,
+		ImageTargetWidth:   r.ImageTargetWidth,
+		FetchTranscripts:   r.FetchTranscripts,
+		TranscribeMedia:    r.TranscribeMedia,
+		ExtractComments:    r.ExtractComments,
+		HarvestContacts:    r.HarvestContacts,
+		FollowHreflang:     r.FollowHreflang,
+		TranslateTo:        strings.TrimSpace(r.TranslateTo),
+		EntityRecognizer:   r.Entities,
+		Topics:             r.Topics,
+		SentimentAnalyzer:  r.Sentiment,
+		DocsVersion:        strings.TrimSpace(r.DocsVersion),
+		GenerateAltText:    r.AltText,
+		AltTextMaxImages:   r.AltTextMaxImages,
+		AltTextPerMinute:   r.AltTextPerMinute,
+		ExtractImageData:   r.ImageData,
+		ImageDataMaxImages: r.ImageDataLimit,
+		ImageDataPerMinute: r.ImageDataRate,
+		KeepCookieBanners:  r.KeepCookieBanners,
+		ExcludeLowValue:    r.ExcludeLowValue,
+		LearnBoilerplate:   r.LearnBoilerplate,
+		Accessibility:      r.Accessibility,
+		SecurityReport:     r.SecurityReport,
+		LowValueThreshold:  r.LowValueThreshold,
+		MetadataOnly:       r.MetadataOnly,
+		MaxPages:           r.MaxPages,
+		MaxConcurrency:     r.MaxConcurrency,
+		HostConcurrency:    r.HostConcurrency,
+		HostDelay:          time.Duration(r.HostDelayMS) * time.Millisecond,
+		MemoryBudget:       int64(r.MemoryBudgetMB) << 20,
+		KeepRawHTML:        r.KeepRawHTML,
+		ExtractWorkers:     r.ExtractWorkers,
+		Dedup:              r.Dedup,
+		DedupExpectedURLs:  r.DedupExpectedURLs,
+		DisableJSFallback:  r.DisableJSFallback,
+		FetchRules:         r.FetchRules,
+		Seeds:              r.Seeds,
+		StripSelectors:     r.StripSelectors,
+		SiteProfiles:       r.SiteProfiles,
+		ContentSelector:    r.ContentSelector,
+		MarkdownHeader:     r.MarkdownHeader,
+		MarkdownFooter:     r.MarkdownFooter,
+		MarkdownFlavor:     r.MarkdownFlavor,
+		JSONProjections:    r.JSONProjections,
+		AcceptLanguage:     strings.TrimSpace(r.AcceptLanguage),
+		Region:             strings.TrimSpace(r.Region),
+		FileRoot:           fileRootFromEnv(),
+		ArchivePath:        archivePath,
+		Sinks:              r.Sinks,
+	}
+	if r.Chunking != nil {
+		if err := r.Chunking.validate(); err != nil {
+			return CrawlerConfig{}, err
+		}
+		config.Chunking = *r.Chunking
+	}
+	if len(config.AllowedDomains) == 0 {
+		if parsedURL.Hostname() != "" {
+			config.AllowedDomains = []string{parsedURL.Hostname()}
+		}
+		for _, seed := range config.Seeds {
+			seedURL, _ := url.Parse(seed.URL) // Checked by validateSeeds
+			config.AllowedDomains = appendUnique(config.AllowedDomains, seedURL.Hostname())
+		}
+	}
+	if r.MaxDepth != nil {
+		if *r.MaxDepth < 0 {
+			return CrawlerConfig{}, errors.New("max_depth must not be negative")
+		}
+		config.MaxDepth = *r.MaxDepth
+	}
+	if err := validateFetchRules(config.FetchRules); err != nil {
+		return CrawlerConfig{}, err
+	}
+	if err := validateSelectors(append(nonEmpty(config.ContentSelector), config.StripSelectors...)); err != nil {
+		return CrawlerConfig{}, err
+	}
+	if err := validateSiteProfiles(config.SiteProfiles); err != nil {
+		return CrawlerConfig{}, err
+	}
+	if err := validateMarkdownTemplates(config.MarkdownHeader, config.MarkdownFooter); err != nil {
+		return CrawlerConfig{}, err
+	}
+	if err := validateMarkdownFlavor(config.MarkdownFlavor); err != nil {
+		return CrawlerConfig{}, err
+	}
+	if err := validateOutputTemplates(r.OutputTemplates); err != nil {
+		return CrawlerConfig{}, err
+	}
+	if err := validateJSONProjections(config.JSONProjections); err != nil {
+		return CrawlerConfig{}, err
+	}
+	if strings.ContainsAny(config.AcceptLanguage, "\r\n") {
+		return CrawlerConfig{}, errors.New("invalid accept_language")
+	}
+	if config.TranslateTo != "" {
+		if provider, err := translatorFromEnv(); err != nil {
+			return CrawlerConfig{}, err
+		} else if provider == nil {
+			return CrawlerConfig{}, errors.New("translate_to needs LEXICRAWLER_TRANSLATE_PROVIDER")
+		}
+	}
+	if config.EntityRecognizer != "" {
+		if _, err := newEntityRecognizer(config.EntityRecognizer); err != nil {
+			return CrawlerConfig{}, err
+		}
+	}
+	if config.SentimentAnalyzer != "" {
+		if _, err := newSentimentAnalyzer(config.SentimentAnalyzer); err != nil {
+			return CrawlerConfig{}, err
+		}
+	}
+	if config.Topics != nil {
+		if err := config.Topics.validate(); err != nil {
+			return CrawlerConfig{}, err
+		}
+	}
+	if r.Alerts != nil {
+		if err := r.Alerts.validate(); err != nil {
+			return CrawlerConfig{}, err
+		}
+	}
+	if r.ContentFilter != nil {
+		filter, err := r.ContentFilter.normalized()
+		if err != nil {
+			return CrawlerConfig{}, err
+		}
+		config.ContentFilter = filter
+	}
+	if r.MaxRedirects != nil {
+		if *r.MaxRedirects < 0 || *r.MaxRedirects > maxRedirectsLimit {
+			return CrawlerConfig{}, fmt.Errorf("max_redirects must be between 0 and %d", maxRedirectsLimit)
+		}
+		config.MaxRedirects = *r.MaxRedirects
+		if config.MaxRedirects == 0 {
+			config.MaxRedirects = -1 // Follows none, 0 is the default
+		}
+	}
+	if r.Traps != nil {
+		if err := r.Traps.validate(); err != nil {
+			return CrawlerConfig{}, err
+		}
+		config.Traps = *r.Traps
+	}
+	if len(r.Notify) > maxNotifyTargets {
+		return CrawlerConfig{}, fmt.Errorf("at most %d notify targets", maxNotifyTargets)
+	}
+	for _, target := range r.Notify {
+		if err := target.validate(); err != nil {
+			return CrawlerConfig{}, err
+		}
+	}
+	for _, sink := range r.Sinks {
+		if sink.Type != "git" {
+			continue
+		}
+		if err := validateGitSink(sink); err != nil {
+			return CrawlerConfig{}, err
+		}
+	}
+	if (config.GenerateAltText || config.ExtractImageData) && visionModelFromEnv() == nil {
+		return CrawlerConfig{}, errors.New("alt_text and image_data need LEXICRAWLER_VISION_URL")
+	}
+	if config.AltTextMaxImages < 0 || config.AltTextPerMinute < 0 || config.ImageDataMaxImages < 0 || config.ImageDataPerMinute < 0 {
+		return CrawlerConfig{}, errors.New("image limits and rates must not be negative")
+	}
+	if config.Region != "" {
+		proxyURL, err := proxyForRegion(config.Region)
+		if err != nil {
+			return CrawlerConfig{}, err
+		}
+		config.ProxyURL = proxyURL
+	}
+	if config.Accessibility && !config.EnableJS && !hasJSRule(config.FetchRules) {
+		return CrawlerConfig{}, errors.New("accessibility audits need js or a js fetch rule")
+	}
+	if config.LowValueThreshold < 0 || config.LowValueThreshold > 1 {
+		return CrawlerConfig{}, errors.New("low_value_threshold must be between 0 and 1")
+	}
+	if config.ImageTargetWidth < 0 {
+		return CrawlerConfig{}, errors.New("image_target_width must not be negative")
+	}
+	if config.MaxPages < 0 {
+		return CrawlerConfig{}, errors.New("max_pages must not be negative")
+	}
+	if config.MaxConcurrency < 0 || config.MaxConcurrency > maxConcurrencyLimit {
+		return CrawlerConfig{}, fmt.Errorf("max_concurrency must be between 0 and %d", maxConcurrencyLimit)
+	}
+	if config.HostConcurrency < 0 || config.HostConcurrency > maxConcurrencyLimit {
+		return CrawlerConfig{}, fmt.Errorf("host_concurrency must be between 0 and %d", maxConcurrencyLimit)
+	}
+	if r.HostDelayMS < 0 || r.HostDelayMS > maxHostDelayMS {
+		return CrawlerConfig{}, fmt.Errorf("host_delay_ms must be between 0 and %d", maxHostDelayMS)
+	}
+	if config.MemoryBudget < 0 {
+		return CrawlerConfig{}, errors.New("memory_budget_mb must not be negative")
+	}
+	if config.ExtractWorkers < 0 || config.ExtractWorkers > maxConcurrencyLimit {
+		return CrawlerConfig{}, fmt.Errorf("extract_workers must be between 0 and %d", maxConcurrencyLimit)
+	}
+	if r.URLPriority != nil {
+		if err := r.URLPriority.validate(); err != nil {
+			return CrawlerConfig{}, err
+		}
+		config.URLScorer = r.URLPriority.scorer()
+	}
+	if config.Dedup != "" && config.Dedup != dedupExact && config.Dedup != dedupBloom {
+		return CrawlerConfig{}, errors.New("dedup must be exact or bloom")
+	}
+	if config.DedupExpectedURLs < 0 {
+		return CrawlerConfig{}, errors.New("dedup_expected_urls must not be negative")
+	}
+	return config, nil
+}
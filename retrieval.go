@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// BM25 parameters, the usual defaults
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// Embedding requests stay well below the providers' limits
+const (
+	maxEmbeddingBatchTexts = 64
+	maxEmbeddingTextChars  = 8000 // Longer texts are cut, roughly 2000 tokens
+	maxEmbeddingResponse   = 64 << 20
+)
+
+// embeddingClient calls the embedding API
+var embeddingClient = &http.Client{Timeout: 2 * time.Minute}
+
+// retriever ranks texts for queries: for every query, the indexes of the texts it
+// was built from, most relevant first
+type retriever interface {
+	name() string
+	rank(queries []string) ([][]int, error)
+}
+
+// retrievalTokens splits text into lower-case words of letters and digits
+func retrievalTokens(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// bm25Index ranks texts by Okapi BM25
+type bm25Index struct {
+	terms     []map[string]int // Term frequencies per text
+	lengths   []int
+	avgLength float64
+	documents map[string]int // Texts containing each term
+}
+
+// newBM25Index indexes texts for BM25 ranking
+func newBM25Index(texts []string) *bm25Index {
+	index := &bm25Index{terms: make([]map[string]int, len(texts)), lengths: make([]int, len(texts)), documents: make(map[string]int)}
+	total := 0
+	for i, text := range texts {
+		tokens := retrievalTokens(text)
+		index.terms[i] = make(map[string]int)
+		for _, token := range tokens {
+			if index.terms[i][token] == 0 {
+				index.documents[token]++
+			}
+			index.terms[i][token]++
+		}
+		index.lengths[i] = len(tokens)
+		total += len(tokens)
+	}
+	if len(texts) > 0 {
+		index.avgLength = float64(total) / float64(len(texts))
+	}
+	return index
+}
+
+func (index *bm25Index) name() string { return "bm25" }
+
+func (index *bm25Index) rank(queries []string) ([][]int, error) {
+	ranked := make([][]int, len(queries))
+	n := float64(len(index.terms))
+	for q, query := range queries {
+		scores := make([]float64, len(index.terms))
+		for _, token := range uniqueStrings(retrievalTokens(query)) {
+			df := float64(index.documents[token])
+			if df == 0 {
+				continue
+			}
+			idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+			for i, terms := range index.terms {
+				tf := float64(terms[token])
+				if tf == 0 {
+					continue
+				}
+				norm := 1 - bm25B + bm25B*float64(index.lengths[i])/math.Max(index.avgLength, 1)
+				scores[i] += idf * tf * (bm25K1 + 1) / (tf + bm25K1*norm)
+			}
+		}
+		ranked[q] = rankScores(scores)
+	}
+	return ranked, nil
+}
+
+// embeddingIndex ranks texts by the cosine similarity of their embeddings
+type embeddingIndex struct {
+	embedder *embedder
+	vectors  [][]float64
+}
+
+// newEmbeddingIndex embeds texts for ranking
+func newEmbeddingIndex(embedder *embedder, texts []string) (*embeddingIndex, error) {
+	vectors, err := embedder.embed(texts)
+	if err != nil {
+		return nil, err
+	}
+	return &embeddingIndex{embedder: embedder, vectors: vectors}, nil
+}
+
+func (index *embeddingIndex) name() string { return "embedding" }
+
+func (index *embeddingIndex) rank(queries []string) ([][]int, error) {
+	vectors, err := index.embedder.embed(queries)
+	if err != nil {
+		return nil, err
+	}
+	ranked := make([][]int, len(queries))
+	for q, query := range vectors {
+		scores := make([]float64, len(index.vectors))
+		for i, vector := range index.vectors {
+			scores[i] = cosineSimilarity(query, vector)
+		}
+		ranked[q] = rankScores(scores)
+	}
+	return ranked, nil
+}
+
+// rankScores returns the indexes of the positive scores, highest first; ties keep
+// the order of the texts
+func rankScores(scores []float64) []int {
+	var ranked []int
+	for i, score := range scores {
+		if score > 0 {
+			ranked = append(ranked, i)
+		}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool { return scores[ranked[i]] > scores[ranked[j]] })
+	return ranked
+}
+
+// cosineSimilarity of two vectors, 0 when either is zero
+func cosineSimilarity(a []float64, b []float64) float64 {
+	var dot, normA, normB float64
+	for i := 0; i < len(a) && i < len(b); i++ {
+		dot += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(normA*normB)
+}
+
+// uniqueStrings returns values without repetitions, in order
+func uniqueStrings(values []string) []string {
+	var unique []string
+	for _, value := range values {
+		unique = appendUnique(unique, value)
+	}
+	return unique
+}
+
+// embedder calls an OpenAI-compatible embeddings API
+type embedder struct {
+	apiURL, apiKey, model string
+}
+
+// embedderFromEnv returns the embeddings API at LEXICRAWLER_EMBEDDING_URL (e.g.
+// https://api.openai.com/v1), authenticated with LEXICRAWLER_EMBEDDING_API_KEY, with
+// the model LEXICRAWLER_EMBEDDING_MODEL. It returns nil without a URL.
+func embedderFromEnv() *embedder {
+	apiURL := strings.TrimRight(os.Getenv("LEXICRAWLER_EMBEDDING_URL"), "/")
+	if apiURL == "" {
+		return nil
+	}
+	model := os.Getenv("LEXICRAWLER_EMBEDDING_MODEL")
+	if model == "" {
+		model = "text-embedding-3-small"
+	}
+	return &embedder{apiURL: apiURL, apiKey: os.Getenv("LEXICRAWLER_EMBEDDING_API_KEY"), model: model}
+}
+
+// embed returns the embeddings of texts, in order, requesting them in batches
+func (e *embedder) embed(texts []string) ([][]float64, error) {
+	vectors := make([][]float64, 0, len(texts))
+	for start := 0; start < len(texts); start += maxEmbeddingBatchTexts {
+		end := min(start+maxEmbeddingBatchTexts, len(texts))
+		batch := make([]string, end-start)
+		for i, text := range texts[start:end] {
+			if len(text) > maxEmbeddingTextChars {
+				text = strings.ToValidUTF8(text[:maxEmbeddingTextChars], "")
+			}
+			if strings.TrimSpace(text) == "" {
+				text = " " // Rejected by some APIs when empty
+			}
+			batch[i] = text
+		}
+		embedded, err := e.embedBatch(batch)
+		if err != nil {
+			return nil, err
+		}
+		vectors = append(vectors, embedded...)
+	}
+	return vectors, nil
+}
+
+// embedBatch makes one request to the embeddings API
+func (e *embedder) embedBatch(texts []string) ([][]float64, error) {
+	body, err := json.Marshal(map[string]interface{}{"model": e.model, "input": texts})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, e.apiURL+"/embeddings", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+e.apiKey)
+	}
+	resp, err := embeddingClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, maxEmbeddingResponse))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("embedding API returned %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+	var response struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float64 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(respBody, &response); err != nil {
+		return nil, err
+	}
+	vectors := make([][]float64, len(texts))
+	for _, item := range response.Data {
+		if item.Index < 0 || item.Index >= len(texts) {
+			return nil, errors.New("embedding API returned an embedding for an unknown input")
+		}
+		vectors[item.Index] = item.Embedding
+	}
+	for i, vector := range vectors {
+		if vector == nil {
+			return nil, fmt.Errorf("embedding API returned no embedding for input %d", i)
+		}
+	}
+	return vectors, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxInspectBytes caps the size of robots.txt and sitemap documents
+const maxInspectBytes = 50 << 20
+
+var inspectClient = &http.Client{Timeout: 30 * time.Second}
+
+// RobotsGroup is a set of rules that applies to one or more user agents
+type RobotsGroup struct {
+	UserAgents []string `json:"user_agents"`
+	Allow      []string `json:"allow"`
+	Disallow   []string `json:"disallow"`
+	CrawlDelay float64  `json:"crawl_delay,omitempty"`
+}
+
+// RobotsRules is a parsed robots.txt file
+type RobotsRules struct {
+	URL      string         `json:"url"`
+	Found    bool           `json:"found"`
+	Groups   []*RobotsGroup `json:"groups"`
+	Sitemaps []string       `json:"sitemaps"`
+}
+
+// fetchRobots downloads and parses the robots.txt of the site that pageURL belongs to.
+// A missing robots.txt is not an error, it just means everything is allowed.
+func fetchRobots(pageURL string) (*RobotsRules, error) {
+	robotsURL := resolveURL(pageURL, "/robots.txt")
+	resp, err := inspectClient.Get(robotsURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	rules := &RobotsRules{URL: robotsURL, Groups: []*RobotsGroup{}, Sitemaps: []string{}}
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		rules.Found = true
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		return rules, nil
+	default:
+		return nil, fmt.Errorf("unexpected status %d for %s", resp.StatusCode, robotsURL)
+	}
+
+	if err := parseRobots(io.LimitReader(resp.Body, maxInspectBytes), rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// parseRobots reads robots.txt directives into rules
+func parseRobots(r io.Reader, rules *RobotsRules) error {
+	var group *RobotsGroup
+	groupHasRules := false
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			// Consecutive user-agent lines share one group
+			if group == nil || groupHasRules {
+				group = &RobotsGroup{Allow: []string{}, Disallow: []string{}}
+				rules.Groups = append(rules.Groups, group)
+				groupHasRules = false
+			}
+			group.UserAgents = append(group.UserAgents, value)
+		case "allow", "disallow", "crawl-delay":
+			if group == nil {
+				continue // Rules before any user-agent line are invalid
+			}
+			groupHasRules = true
+			switch key {
+			case "allow":
+				group.Allow = append(group.Allow, value)
+			case "disallow":
+				if value != "" { // An empty Disallow allows everything
+					group.Disallow = append(group.Disallow, value)
+				}
+			case "crawl-delay":
+				if delay, err := strconv.ParseFloat(value, 64); err == nil {
+					group.CrawlDelay = delay
+				}
+			}
+		case "sitemap":
+			if value != "" {
+				rules.Sitemaps = append(rules.Sitemaps, value)
+			}
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	s3UnsignedPayload  = "UNSIGNED-PAYLOAD"
+	s3MultipartMinSize = 100 << 20 // Files above this size are uploaded in parts
+	s3PartSize         = 64 << 20
+)
+
+var s3Client = &http.Client{Timeout: 30 * time.Minute}
+
+// S3Config addresses a bucket on S3 or an S3-compatible store (MinIO, R2, ...)
+type S3Config struct {
+	Endpoint     string // e.g. https://s3.eu-west-1.amazonaws.com; custom endpoints use path-style URLs
+	Region       string
+	Bucket       string
+	Prefix       string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	pathStyle    bool
+}
+
+// s3ConfigFromEnv reads the S3 settings from LEXICRAWLER_S3_* and the standard AWS credential variables
+func s3ConfigFromEnv() (*S3Config, error) {
+	config := &S3Config{
+		Endpoint:     os.Getenv("LEXICRAWLER_S3_ENDPOINT"),
+		Region:       os.Getenv("LEXICRAWLER_S3_REGION"),
+		Bucket:       os.Getenv("LEXICRAWLER_S3_BUCKET"),
+		Prefix:       os.Getenv("LEXICRAWLER_S3_PREFIX"),
+		AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if config.Bucket == "" || config.AccessKey == "" || config.SecretKey == "" {
+		return nil, errors.New("S3 is not configured (LEXICRAWLER_S3_BUCKET, AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are required)")
+	}
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	if config.Endpoint == "" {
+		config.Endpoint = "https://s3." + config.Region + ".amazonaws.com"
+	} else {
+		config.pathStyle = true
+	}
+	return config, nil
+}
+
+// objectURL returns the URL of an object key
+func (s *S3Config) objectURL(key string) (*url.URL, error) {
+	endpoint, err := url.Parse(s.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	objectKey := strings.TrimPrefix(s.Prefix+key, "/")
+	if s.pathStyle {
+		endpoint.Path = "/" + s.Bucket + "/" + objectKey
+	} else {
+		endpoint.Host = s.Bucket + "." + endpoint.Host
+		endpoint.Path = "/" + objectKey
+	}
+	return endpoint, nil
+}
+
+// UploadFile stores a local file under key, using a multipart upload for large files
+func (s *S3Config) UploadFile(key string, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	objectURL, err := s.objectURL(key)
+	if err != nil {
+		return err
+	}
+
+	if info.Size() <= s3MultipartMinSize {
+		_, err := s.do(http.MethodPut, objectURL, url.Values{}, file, info.Size())
+		return err
+	}
+	return s.uploadMultipart(objectURL, file, info.Size())
+}
+
+// uploadMultipart uploads a file in s3PartSize parts, aborting the upload on failure
+func (s *S3Config) uploadMultipart(objectURL *url.URL, file *os.File, size int64) error {
+	body, err := s.do(http.MethodPost, objectURL, url.Values{"uploads": {""}}, nil, 0)
+	if err != nil {
+		return err
+	}
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.Unmarshal(body, &initiated); err != nil {
+		return fmt.Errorf("parsing multipart upload response: %w", err)
+	}
+
+	type completedPart struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	}
+	var parts []completedPart
+	for offset, partNumber := int64(0), 1; offset < size; offset, partNumber = offset+s3PartSize, partNumber+1 {
+		partSize := min(s3PartSize, size-offset)
+		query := url.Values{"partNumber": {fmt.Sprint(partNumber)}, "uploadId": {initiated.UploadID}}
+		etag, err := s.doPart(objectURL, query, io.NewSectionReader(file, offset, partSize), partSize)
+		if err != nil {
+			s.do(http.MethodDelete, objectURL, url.Values{"uploadId": {initiated.UploadID}}, nil, 0)
+			return fmt.Errorf("uploading part %d: %w", partNumber, err)
+		}
+		parts = append(parts, completedPart{PartNumber: partNumber, ETag: etag})
+	}
+
+	completion, err := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+	_, err = s.do(http.MethodPost, objectURL, url.Values{"uploadId": {initiated.UploadID}}, bytes.NewReader(completion), int64(len(completion)))
+	return err
+}
+
+// doPart uploads a single part and returns its ETag
+func (s *S3Config) doPart(objectURL *url.URL, query url.Values, body io.Reader, size int64) (string, error) {
+	resp, err := s.send(http.MethodPut, objectURL, query, body, size)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	return resp.Header.Get("ETag"), nil
+}
+
+// do sends a signed request and returns the response body
+func (s *S3Config) do(method string, objectURL *url.URL, query url.Values, body io.Reader, size int64) ([]byte, error) {
+	resp, err := s.send(method, objectURL, query, body, size)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+// send signs a request with AWS Signature Version 4 and checks the response status
+func (s *S3Config) send(method string, objectURL *url.URL, query url.Values, body io.Reader, size int64) (*http.Response, error) {
+	requestURL := *objectURL
+	requestURL.RawQuery = canonicalQuery(query)
+	req, err := http.NewRequest(method, requestURL.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+
+	now := time.Now().UTC()
+	req.Header.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	req.Header.Set("X-Amz-Content-Sha256", s3UnsignedPayload)
+	if s.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+	signedHeaders := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	if s.SessionToken != "" {
+		signedHeaders = append(signedHeaders, "x-amz-security-token")
+	}
+	var canonicalHeaders strings.Builder
+	for _, name := range signedHeaders {
+		value := req.Header.Get(name)
+		if name == "host" {
+			value = requestURL.Host
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+
+	scope, signature := s.sign(now, method, &requestURL, canonicalHeaders.String(), strings.Join(signedHeaders, ";"))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKey, scope, strings.Join(signedHeaders, ";"), signature))
+
+	resp, err := s3Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("S3 %s %s: status %d: %s", method, objectURL.Path, resp.StatusCode, message)
+	}
+	return resp, nil
+}
+
+// PresignGet returns a URL that allows downloading key without credentials until it expires
+func (s *S3Config) PresignGet(key string, expires time.Duration) (string, error) {
+	objectURL, err := s.objectURL(key)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now().UTC()
+	scope := now.Format("20060102") + "/" + s.Region + "/s3/aws4_request"
+	query := url.Values{
+		"X-Amz-Algorithm":     {"AWS4-HMAC-SHA256"},
+		"X-Amz-Credential":    {s.AccessKey + "/" + scope},
+		"X-Amz-Date":          {now.Format("20060102T150405Z")},
+		"X-Amz-Expires":       {fmt.Sprint(int(expires.Seconds()))},
+		"X-Amz-SignedHeaders": {"host"},
+	}
+	if s.SessionToken != "" {
+		query.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+	objectURL.RawQuery = canonicalQuery(query)
+
+	_, signature := s.sign(now, http.MethodGet, objectURL, "host:"+objectURL.Host+"\n", "host")
+	objectURL.RawQuery += "&X-Amz-Signature=" + signature
+	return objectURL.String(), nil
+}
+
+// sign computes the SigV4 credential scope and signature of a request
+func (s *S3Config) sign(now time.Time, method string, requestURL *url.URL, canonicalHeaders string, signedHeaders string) (string, string) {
+	canonicalRequest := strings.Join([]string{
+		method,
+		requestURL.EscapedPath(),
+		requestURL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		s3UnsignedPayload,
+	}, "\n")
+
+	date := now.Format("20060102")
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + now.Format("20060102T150405Z") + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return scope, hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// canonicalQuery encodes query parameters sorted by key, as SigV4 requires
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, key := range keys {
+		for _, value := range query[key] {
+			parts = append(parts, sigV4Escape(key)+"="+sigV4Escape(value))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// sigV4Escape percent-encodes everything except unreserved characters
+func sigV4Escape(value string) string {
+	return strings.ReplaceAll(url.QueryEscape(value), "+", "%20")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
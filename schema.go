@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+// schemaObject is a schema.org item from JSON-LD or microdata, as decoded JSON
+type schemaObject map[string]interface{}
+
+// schemaObjects collects the schema.org items of a page: JSON-LD blocks
+// (including @graph lists and nested items) and microdata itemscopes
+func schemaObjects(doc *goquery.Selection) []schemaObject {
+	var objects []schemaObject
+	var collect func(value interface{})
+	collect = func(value interface{}) {
+		switch v := value.(type) {
+		case []interface{}:
+			for _, item := range v {
+				collect(item)
+			}
+		case map[string]interface{}:
+			if v["@type"] != nil {
+				objects = append(objects, v)
+			}
+			if graph, ok := v["@graph"]; ok {
+				collect(graph)
+			}
+			if entity, ok := v["mainEntity"]; ok { // e.g. the Product of an ItemPage
+				collect(entity)
+			}
+		}
+	}
+	doc.Find("script[type='application/ld+json']").Each(func(_ int, script *goquery.Selection) {
+		var value interface{}
+		if err := json.Unmarshal([]byte(strings.TrimSpace(script.Text())), &value); err == nil {
+			collect(value)
+		}
+	})
+
+	doc.Find("[itemscope][itemtype]").Each(func(_ int, item *goquery.Selection) {
+		if _, nested := item.Attr("itemprop"); !nested {
+			objects = append(objects, microdataObject(item.Nodes[0]))
+		}
+	})
+	return objects
+}
+
+// microdataObject converts a microdata itemscope to the JSON-LD shape
+func microdataObject(node *html.Node) schemaObject {
+	object := schemaObject{}
+	itemType, _ := attr(node, "itemtype")
+	types := strings.Fields(itemType)
+	if len(types) > 0 {
+		object["@type"] = types[0]
+	}
+
+	var walk func(*html.Node)
+	walk = func(parent *html.Node) {
+		for child := parent.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type != html.ElementNode {
+				continue
+			}
+			property, hasProperty := attr(child, "itemprop")
+			_, isScope := attr(child, "itemscope")
+			if hasProperty {
+				var value interface{} = microdataValue(child)
+				if isScope {
+					value = microdataObject(child)
+				}
+				for _, name := range strings.Fields(property) {
+					if existing, ok := object[name]; ok { // Repeated properties become lists
+						if list, isList := existing.([]interface{}); isList {
+							object[name] = append(list, value)
+						} else {
+							object[name] = []interface{}{existing, value}
+						}
+					} else {
+						object[name] = value
+					}
+				}
+			}
+			if !isScope {
+				walk(child)
+			}
+		}
+	}
+	walk(node)
+	return object
+}
+
+// microdataValue returns the value of a microdata property element
+func microdataValue(node *html.Node) string {
+	if content, ok := attr(node, "content"); ok {
+		return content
+	}
+	switch node.Data {
+	case "a", "link", "area":
+		href, _ := attr(node, "href")
+		return href
+	case "img", "audio", "video", "source", "iframe", "embed", "track":
+		src, _ := attr(node, "src")
+		return src
+	case "time":
+		if datetime, ok := attr(node, "datetime"); ok {
+			return datetime
+		}
+	case "data", "meter":
+		if value, ok := attr(node, "value"); ok {
+			return value
+		}
+	}
+	return strings.Join(strings.Fields(nodeText(node)), " ")
+}
+
+// is reports whether an item has the given schema.org type (e.g. "Product"),
+// accepting full type URLs and multiple types
+func (o schemaObject) is(schemaType string) bool {
+	for _, name := range o.types() {
+		if name == schemaType {
+			return true
+		}
+	}
+	return false
+}
+
+// types returns the type names of an item without their schema.org prefix
+func (o schemaObject) types() []string {
+	var names []string
+	for _, value := range schemaList(o["@type"]) {
+		name, _ := value.(string)
+		names = append(names, name[strings.LastIndexAny(name, "/#")+1:])
+	}
+	return names
+}
+
+// str returns a property as text: strings and numbers as they are, items by their
+// name, lists by their first entry
+func (o schemaObject) str(property string) string {
+	return schemaText(o[property])
+}
+
+// object returns a property holding a nested item, or nil
+func (o schemaObject) object(property string) schemaObject {
+	if objects := o.objects(property); len(objects) > 0 {
+		return objects[0]
+	}
+	return nil
+}
+
+// objects returns the nested items of a property that may hold one item or a list
+func (o schemaObject) objects(property string) []schemaObject {
+	var objects []schemaObject
+	for _, value := range schemaList(o[property]) {
+		if nested, ok := asSchemaObject(value); ok {
+			objects = append(objects, nested)
+		}
+	}
+	return objects
+}
+
+// asSchemaObject returns a property value holding a nested item
+func asSchemaObject(value interface{}) (schemaObject, bool) {
+	switch nested := value.(type) {
+	case map[string]interface{}:
+		return nested, true
+	case schemaObject:
+		return nested, true
+	}
+	return nil, false
+}
+
+// strs returns the text of every value of a property that may be a list
+func (o schemaObject) strs(property string) []string {
+	var values []string
+	for _, value := range schemaList(o[property]) {
+		if text := schemaText(value); text != "" {
+			values = append(values, text)
+		}
+	}
+	return values
+}
+
+// schemaList wraps a single value in a list
+func schemaList(value interface{}) []interface{} {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		return v
+	default:
+		return []interface{}{v}
+	}
+}
+
+// schemaText converts a property value to text
+func schemaText(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return strings.TrimSpace(html.UnescapeString(v))
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case []interface{}:
+		if len(v) > 0 {
+			return schemaText(v[0])
+		}
+	case map[string]interface{}:
+		return schemaObject(v).itemText()
+	case schemaObject:
+		return v.itemText()
+	case nil:
+	default:
+		return fmt.Sprint(v)
+	}
+	return ""
+}
+
+// itemText names an item used where text is expected (a Brand, a Place, a URL object)
+func (o schemaObject) itemText() string {
+	for _, property := range []string{"name", "@value", "url", "contentUrl", "@id"} {
+		if text := schemaText(o[property]); text != "" {
+			return text
+		}
+	}
+	return ""
+}
+
+// schemaNumber parses a numeric property value ("19.99", 19.99, "1,299.00")
+func schemaNumber(value interface{}) (float64, bool) {
+	text := strings.ReplaceAll(schemaText(value), ",", "")
+	number, err := strconv.ParseFloat(text, 64)
+	return number, err == nil
+}
+
+// schemaEnum returns the name of a schema.org enumeration value in snake case,
+// e.g. "https://schema.org/InStock" -> "in_stock"
+func schemaEnum(value string) string {
+	name := value[strings.LastIndexAny(value, "/#")+1:]
+	var snake strings.Builder
+	for i, r := range name {
+		if r >= 'A' && r <= 'Z' {
+			if i > 0 {
+				snake.WriteByte('_')
+			}
+			r += 'a' - 'A'
+		}
+		snake.WriteRune(r)
+	}
+	return snake.String()
+}
+
+// schemaPlainText returns a property holding text or HTML (JobPosting descriptions)
+// as plain text
+func schemaPlainText(value interface{}) string {
+	return plainText(schemaText(value))
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tlsDialTimeout bounds the TLS handshake made to read a host's certificate
+const tlsDialTimeout = 10 * time.Second
+
+// Security posture thresholds
+const (
+	minHSTSMaxAge         = 180 * 24 * 60 * 60 // Seconds; shorter HSTS policies are flagged
+	certificateExpiryDays = 30                 // Certificates expiring sooner are flagged
+)
+
+// securityHeaders are the response headers recorded per host
+var securityHeaders = []string{
+	"Content-Security-Policy", "Content-Security-Policy-Report-Only", "Strict-Transport-Security",
+	"X-Frame-Options", "X-Content-Type-Options", "Referrer-Policy", "Permissions-Policy",
+	"Cross-Origin-Opener-Policy", "Cross-Origin-Embedder-Policy", "Cross-Origin-Resource-Policy",
+}
+
+// expectedSecurityHeaders are reported as missing when absent
+var expectedSecurityHeaders = []string{
+	"Content-Security-Policy", "Strict-Transport-Security", "X-Frame-Options", "X-Content-Type-Options", "Referrer-Policy",
+}
+
+// HostSecurity is the security posture of one host: the security headers of its
+// first response, its TLS certificate and the findings derived from both
+type HostSecurity struct {
+	Host     string            `json:"host"`
+	Grade    string            `json:"grade"`    // A (no findings) to F (no HTTPS, or an invalid or expired certificate)
+	Findings []string          `json:"findings"` // e.g. "missing Content-Security-Policy"
+	Headers  map[string]string `json:"headers"`  // Security headers present
+	TLS      *TLSDetails       `json:"tls,omitempty"`
+	scheme   string
+}
+
+// TLSDetails describes the TLS connection and leaf certificate of a host
+type TLSDetails struct {
+	Version       string    `json:"version"` // e.g. TLS 1.3
+	CipherSuite   string    `json:"cipher_suite"`
+	Subject       string    `json:"subject"`
+	Issuer        string    `json:"issuer"`
+	DNSNames      []string  `json:"dns_names"`
+	NotBefore     time.Time `json:"not_before"`
+	NotAfter      time.Time `json:"not_after"`
+	DaysRemaining int       `json:"days_remaining"`
+	Verified      bool      `json:"verified"`
+	Error         string    `json:"error,omitempty"` // Why verification or the handshake failed
+}
+
+// recordSecurity notes the security headers and TLS certificate of a page's host
+// the first time the host is seen. Pages rendered with JS have no headers, so a
+// later static response of the host still fills them in.
+func (c *Crawler) recordSecurity(pageURL string, header http.Header) {
+	parsedURL, err := url.Parse(pageURL)
+	if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") {
+		return
+	}
+	host := parsedURL.Hostname()
+	c.SecurityMutex.Lock()
+	security, seen := c.Security[host]
+	if !seen {
+		security = &HostSecurity{Host: host, scheme: parsedURL.Scheme}
+		c.Security[host] = security
+	}
+	if header != nil && security.Headers == nil {
+		security.Headers = make(map[string]string)
+		for _, name := range securityHeaders {
+			if value := header.Get(name); value != "" {
+				security.Headers[name] = value
+			}
+		}
+	}
+	c.SecurityMutex.Unlock()
+
+	if !seen && parsedURL.Scheme == "https" {
+		details := inspectTLS(parsedURL.Host)
+		c.SecurityMutex.Lock()
+		security.TLS = details
+		c.SecurityMutex.Unlock()
+	}
+}
+
+// inspectTLS connects to a host and reads its certificate, connecting again
+// without verification when the certificate doesn't verify
+func inspectTLS(hostPort string) *TLSDetails {
+	host := hostPort
+	if h, _, err := net.SplitHostPort(hostPort); err == nil {
+		host = h
+	} else {
+		hostPort = net.JoinHostPort(hostPort, "443")
+	}
+	dialer := &net.Dialer{Timeout: tlsDialTimeout}
+	details := &TLSDetails{Verified: true}
+	conn, err := tls.DialWithDialer(dialer, "tcp", hostPort, &tls.Config{ServerName: host})
+	if err != nil {
+		details.Verified = false
+		details.Error = err.Error()
+		conn, err = tls.DialWithDialer(dialer, "tcp", hostPort, &tls.Config{ServerName: host, InsecureSkipVerify: true})
+		if err != nil {
+			return details
+		}
+	}
+	defer conn.Close()
+
+	state := conn.ConnectionState()
+	details.Version = tls.VersionName(state.Version)
+	details.CipherSuite = tls.CipherSuiteName(state.CipherSuite)
+	if len(state.PeerCertificates) > 0 {
+		leaf := state.PeerCertificates[0]
+		details.Subject = leaf.Subject.String()
+		details.Issuer = leaf.Issuer.String()
+		details.DNSNames = leaf.DNSNames
+		details.NotBefore = leaf.NotBefore
+		details.NotAfter = leaf.NotAfter
+		details.DaysRemaining = int(time.Until(leaf.NotAfter).Hours() / 24)
+	}
+	return details
+}
+
+// assess derives the findings and grade of a host
+func (security *HostSecurity) assess() {
+	security.Findings = []string{}
+	critical := false
+	if security.scheme != "https" {
+		security.Findings = append(security.Findings, "no HTTPS")
+		critical = true
+	}
+	if security.Headers != nil {
+		for _, name := range expectedSecurityHeaders {
+			if _, ok := security.Headers[name]; ok {
+				continue
+			}
+			if name == "Strict-Transport-Security" && security.scheme != "https" {
+				continue // Only sent over HTTPS
+			}
+			if name == "X-Frame-Options" && strings.Contains(security.Headers["Content-Security-Policy"], "frame-ancestors") {
+				continue // Superseded by the CSP
+			}
+			security.Findings = append(security.Findings, "missing "+name)
+		}
+		if hsts, ok := security.Headers["Strict-Transport-Security"]; ok && hstsMaxAge(hsts) < minHSTSMaxAge {
+			security.Findings = append(security.Findings, "HSTS max-age below 180 days")
+		}
+	}
+	if tlsDetails := security.TLS; tlsDetails != nil {
+		switch {
+		case tlsDetails.Version == "":
+			security.Findings = append(security.Findings, "TLS handshake failed")
+			critical = true
+		case !tlsDetails.Verified:
+			security.Findings = append(security.Findings, "certificate does not verify")
+			critical = true
+		case tlsDetails.DaysRemaining < 0:
+			security.Findings = append(security.Findings, "certificate expired")
+			critical = true
+		case tlsDetails.DaysRemaining < certificateExpiryDays:
+			security.Findings = append(security.Findings, "certificate expires in "+strconv.Itoa(tlsDetails.DaysRemaining)+" days")
+		}
+		if tlsDetails.Version == "TLS 1.0" || tlsDetails.Version == "TLS 1.1" || tlsDetails.Version == "SSLv3" {
+			security.Findings = append(security.Findings, "outdated "+tlsDetails.Version)
+		}
+	}
+
+	grades := "ABCDF"
+	security.Grade = string(grades[min(len(security.Findings), len(grades)-1)])
+	if critical {
+		security.Grade = "F"
+	}
+}
+
+// hstsMaxAge returns the max-age directive of an HSTS header in seconds
+func hstsMaxAge(header string) int {
+	for _, directive := range strings.Split(header, ";") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		if strings.EqualFold(name, "max-age") {
+			seconds, _ := strconv.Atoi(strings.Trim(value, `" `))
+			return seconds
+		}
+	}
+	return 0
+}
+
+// SecurityReport returns the assessed security posture of every host crawled,
+// ordered by host
+func (c *Crawler) SecurityReport() []HostSecurity {
+	c.SecurityMutex.Lock()
+	defer c.SecurityMutex.Unlock()
+	report := make([]HostSecurity, 0, len(c.Security))
+	for _, security := range c.Security {
+		security.assess()
+		report = append(report, *security)
+	}
+	sort.Slice(report, func(i, j int) bool { return report[i].Host < report[j].Host })
+	return report
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Seed is an extra start page of a crawl, optionally requested with another method
+// and a body (search forms, GraphQL endpoints)
+type Seed struct {
+	URL         string `json:"url"`
+	Method      string `json:"method"`       // GET when empty
+	Body        string `json:"body"`         // Sent as is
+	ContentType string `json:"content_type"` // Of the body, e.g. application/json
+}
+
+// seedMethods are the methods seeds may use
+var seedMethods = map[string]bool{http.MethodGet: true, http.MethodPost: true, http.MethodPut: true, http.MethodPatch: true}
+
+// method returns the request method of a seed in upper case
+func (s Seed) method() string {
+	if s.Method == "" {
+		return http.MethodGet
+	}
+	return strings.ToUpper(s.Method)
+}
+
+// isPlainGET reports whether a seed is an ordinary page visit
+func (s Seed) isPlainGET() bool {
+	return s.method() == http.MethodGet && s.Body == ""
+}
+
+// key identifies the page of a seed in the crawl results. Requests with a body are
+// told apart by a fragment naming the method and a hash of the body, so several
+// queries to one GraphQL endpoint are separate pages.
+func (s Seed) key() string {
+	if s.isPlainGET() {
+		return s.URL
+	}
+	hash := sha256.Sum256([]byte(s.Body))
+	keyURL, err := url.Parse(s.URL)
+	if err != nil {
+		return s.URL
+	}
+	keyURL.Fragment = strings.ToLower(s.method()) + "-" + hex.EncodeToString(hash[:4])
+	return keyURL.String()
+}
+
+// validateSeeds checks the URL and method of every seed
+func validateSeeds(seeds []Seed) error {
+	for _, seed := range seeds {
+		parsedURL, err := url.ParseRequestURI(seed.URL)
+		if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") || parsedURL.Hostname() == "" {
+			return fmt.Errorf("seed %q: invalid url", seed.URL)
+		}
+		if !seedMethods[seed.method()] {
+			return fmt.Errorf("seed %q: unsupported method %s", seed.URL, seed.Method)
+		}
+	}
+	return nil
+}
+
+// seedRequests indexes the seeds that aren't plain GETs by their result key
+func seedRequests(seeds []Seed) map[string]Seed {
+	requests := make(map[string]Seed)
+	for _, seed := range seeds {
+		if !seed.isPlainGET() {
+			requests[seed.key()] = seed
+		}
+	}
+	return requests
+}
+
+// SeedFetcher sends the request of a seed with its method and body. Its response is
+// never rendered with JS, which would repeat the request as a GET.
+type SeedFetcher struct {
+	static *StaticFetcher
+	seed   Seed
+}
+
+// Fetch sends the seed's request; the page keeps the seed's result key as its URL
+func (f *SeedFetcher) Fetch(pageURL string) (*fetchedPage, error) {
+	page, err := f.static.fetch(f.seed.method(), f.seed.URL, f.seed.Body, f.seed.ContentType)
+	if err != nil {
+		return nil, err
+	}
+	page.URL = pageURL
+	return page, nil
+}
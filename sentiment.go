@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Sentiment analyzers of CrawlerConfig.SentimentAnalyzer
+const (
+	sentimentLexicon = "lexicon" // Sums the polarity of opinion words, minding negations
+	sentimentLLM     = "llm"     // An OpenAI-compatible chat completions API, which also names the tone
+)
+
+// Sentiment labels
+const (
+	sentimentPositive = "positive"
+	sentimentNegative = "negative"
+	sentimentNeutral  = "neutral"
+	sentimentMixed    = "mixed" // Strong opinions both ways that cancel out
+)
+
+// Limits of sentiment analysis
+const (
+	neutralSentiment     = 0.05 // Scores closer to 0 are neutral
+	maxSentimentComments = 100  // Comments scored per page
+	maxSentimentLLMText  = 12000
+	maxSentimentLLMItem  = 1000 // Characters of each comment sent to the API
+)
+
+// PageSentiment is the sentiment of a page's text and of its comments
+type PageSentiment struct {
+	Analyzer string             `json:"analyzer"`
+	Score    float64            `json:"score"` // -1 (negative) to 1 (positive)
+	Label    string             `json:"label"`
+	Tones    []string           `json:"tones,omitempty"` // e.g. "formal", "frustrated", "promotional"; by the llm analyzer only
+	Comments []CommentSentiment `json:"comments,omitempty"`
+}
+
+// CommentSentiment is the sentiment of a comment of the page
+type CommentSentiment struct {
+	Index int     `json:"index"` // Of the comment in the "comments" structured data
+	Score float64 `json:"score"`
+	Label string  `json:"label"`
+}
+
+// sentimentAnalyzer scores a page's markdown and its comments' texts
+type sentimentAnalyzer interface {
+	name() string
+	analyze(markdown string, comments []string) (page sentimentScore, commentScores []sentimentScore, tones []string, err error)
+}
+
+// sentimentScore is a score with its label
+type sentimentScore struct {
+	score float64
+	label string
+}
+
+// newSentimentAnalyzer returns the analyzer of a CrawlerConfig.SentimentAnalyzer
+func newSentimentAnalyzer(kind string) (sentimentAnalyzer, error) {
+	switch kind {
+	case sentimentLexicon:
+		return lexiconAnalyzer{}, nil
+	case sentimentLLM:
+		apiURL := strings.TrimRight(os.Getenv("LEXICRAWLER_SENTIMENT_URL"), "/")
+		if apiURL == "" {
+			return nil, errors.New("the llm sentiment analyzer requires LEXICRAWLER_SENTIMENT_URL")
+		}
+		model := os.Getenv("LEXICRAWLER_SENTIMENT_MODEL")
+		if model == "" {
+			model = "gpt-4o-mini"
+		}
+		return &llmSentimentAnalyzer{apiURL: apiURL, apiKey: os.Getenv("LEXICRAWLER_SENTIMENT_API_KEY"), model: model}, nil
+	}
+	return nil, fmt.Errorf("unknown sentiment analyzer %q (want lexicon or llm)", kind)
+}
+
+// analyzeSentiment scores the sentiment of a page, and of its comments when they
+// were collected, into the "sentiment" structured data
+func (c *Crawler) analyzeSentiment(data *CrawledData) {
+	analyzer, err := newSentimentAnalyzer(c.Config.SentimentAnalyzer)
+	if err != nil || data.Markdown == "" {
+		return // Checked by toConfig for jobs
+	}
+	comments, _ := data.StructuredData["comments"].([]Comment)
+	var texts []string
+	for i := 0; i < len(comments) && i < maxSentimentComments; i++ {
+		texts = append(texts, comments[i].Text)
+	}
+	page, commentScores, tones, err := analyzer.analyze(data.Markdown, texts)
+	if err != nil {
+		fmt.Printf("Sentiment analysis failed for %s: %v\n", data.URL, err)
+		return
+	}
+	sentiment := &PageSentiment{Analyzer: analyzer.name(), Score: page.score, Label: page.label, Tones: tones}
+	for i, score := range commentScores {
+		sentiment.Comments = append(sentiment.Comments, CommentSentiment{Index: i, Score: score.score, Label: score.label})
+	}
+	data.StructuredData["sentiment"] = sentiment
+}
+
+// sentimentLabel labels a score; mixed when the positive and negative polarity
+// are both strong but close
+func sentimentLabel(score float64, positive float64, negative float64) string {
+	switch {
+	case positive >= 2 && negative >= 2 && math.Abs(positive-negative) < (positive+negative)/3:
+		return sentimentMixed
+	case score >= neutralSentiment:
+		return sentimentPositive
+	case score <= -neutralSentiment:
+		return sentimentNegative
+	}
+	return sentimentNeutral
+}
+
+// lexiconAnalyzer scores texts by the polarity of the opinion words in them
+type lexiconAnalyzer struct{}
+
+func (lexiconAnalyzer) name() string { return sentimentLexicon }
+
+func (lexiconAnalyzer) analyze(markdown string, comments []string) (sentimentScore, []sentimentScore, []string, error) {
+	page := lexiconScore(markdown)
+	scores := make([]sentimentScore, len(comments))
+	for i, comment := range comments {
+		scores[i] = lexiconScore(comment)
+	}
+	return page, scores, nil, nil
+}
+
+// lexiconScore sums the polarity of the words of a text outside code blocks. Negations
+// flip the polarity of the next three words and intensifiers strengthen the next one.
+// The sum is normalized to -1..1 as VADER does.
+func lexiconScore(text string) sentimentScore {
+	var sum, positive, negative float64
+	negatedUntil, intensity := -1, 1.0
+	words := topicWords(text)
+	for i, word := range words {
+		if sentimentNegations[word.text] {
+			negatedUntil = i + 3
+			continue
+		}
+		if sentimentIntensifiers[word.text] {
+			intensity = 1.5
+			continue
+		}
+		polarity := float64(opinionWords[word.text]) * intensity
+		intensity = 1
+		if i <= negatedUntil {
+			polarity *= -0.75 // "not good" is less negative than "bad"
+		}
+		sum += polarity
+		if polarity > 0 {
+			positive += polarity
+		} else {
+			negative -= polarity
+		}
+	}
+	score := math.Round(sum/math.Sqrt(sum*sum+15)*100) / 100
+	return sentimentScore{score: score, label: sentimentLabel(score, positive, negative)}
+}
+
+// sentimentNegations flip the polarity of the words after them; "t" is what remains
+// of "n't"
+var sentimentNegations = wordSet("not no never t cannot without hardly barely nothing neither nor none")
+
+// sentimentIntensifiers strengthen the next opinion word
+var sentimentIntensifiers = wordSet("very really extremely so too highly incredibly absolutely totally truly super quite")
+
+// opinionWords is the polarity of opinion words, -3 (very negative) to 3. Words as
+// common in neutral documentation as in opinions ("like", "works", "error") are left out.
+var opinionWords = func() map[string]int {
+	lexicon := make(map[string]int)
+	for polarity, words := range map[int]string{
+		3: "excellent outstanding amazing awesome fantastic superb wonderful perfect brilliant love loved loves best exceptional incredible delighted",
+		2: "good great nice happy pleased enjoy enjoyed enjoyable recommend recommended impressive reliable beautiful helpful easy fast smooth " +
+			"solid favorite glad satisfied worth win wins useful friendly clean comfortable elegant fun thanks thank appreciate",
+		1:  "ok okay liked decent stable better improved improvement interesting affordable cheap quick",
+		-1: "slow problem problems bug bugs confusing unclear expensive difficult complicated lack lacks wrong odd meh crash",
+		-2: "bad poor broken disappointing disappointed annoying frustrating frustrated unreliable useless ugly hate hated fail failed fails " +
+			"failure worse waste sad angry unhappy complaint refund rude scam buggy crashes crashed",
+		-3: "terrible awful horrible worst disgusting pathetic atrocious garbage furious nightmare unacceptable",
+	} {
+		for _, word := range strings.Fields(words) {
+			lexicon[word] = polarity
+		}
+	}
+	return lexicon
+}()
+
+// llmSentimentAnalyzer asks an OpenAI-compatible chat completions API for the
+// sentiment and tone of a page and its comments
+type llmSentimentAnalyzer struct {
+	apiURL, apiKey, model string
+}
+
+func (a *llmSentimentAnalyzer) name() string { return sentimentLLM }
+
+func (a *llmSentimentAnalyzer) analyze(markdown string, comments []string) (sentimentScore, []sentimentScore, []string, error) {
+	if len(markdown) > maxSentimentLLMText {
+		markdown = strings.ToValidUTF8(markdown[:maxSentimentLLMText], "")
+	}
+	document := map[string]interface{}{"page": markdown}
+	if len(comments) > 0 {
+		truncated := make([]string, len(comments))
+		for i, comment := range comments {
+			if len(comment) > maxSentimentLLMItem {
+				comment = strings.ToValidUTF8(comment[:maxSentimentLLMItem], "")
+			}
+			truncated[i] = comment
+		}
+		document["comments"] = truncated
+	}
+	content, err := json.Marshal(document)
+	if err != nil {
+		return sentimentScore{}, nil, nil, err
+	}
+	prompt := "The user sends a JSON object with the markdown of a web page and the comments on it, if any. " +
+		`Reply with a JSON object {"score": <sentiment of the page, -1 (negative) to 1 (positive)>, ` +
+		`"label": "positive" or "negative" or "neutral" or "mixed", "tones": ["<up to three adjectives for the tone, e.g. formal, sarcastic, promotional>"], ` +
+		`"comments": [{"score": <-1 to 1>, "label": "positive" or "negative" or "neutral" or "mixed"}, one per comment in order]}.`
+	request := map[string]interface{}{
+		"model": a.model,
+		"messages": []map[string]string{
+			{"role": "system", "content": prompt},
+			{"role": "user", "content": string(content)},
+		},
+		"response_format": map[string]string{"type": "json_object"},
+	}
+	var response struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	header := http.Header{}
+	if a.apiKey != "" {
+		header.Set("Authorization", "Bearer "+a.apiKey)
+	}
+	if err := postJSON(a.apiURL+"/chat/completions", header, request, &response); err != nil {
+		return sentimentScore{}, nil, nil, err
+	}
+	if len(response.Choices) == 0 {
+		return sentimentScore{}, nil, nil, errors.New("sentiment API returned no choices")
+	}
+	type reply struct {
+		Score float64 `json:"score"`
+		Label string  `json:"label"`
+	}
+	var result struct {
+		reply
+		Tones    []string `json:"tones"`
+		Comments []reply  `json:"comments"`
+	}
+	if err := json.Unmarshal([]byte(response.Choices[0].Message.Content), &result); err != nil {
+		return sentimentScore{}, nil, nil, fmt.Errorf("invalid sentiment reply: %w", err)
+	}
+	// score checks a reply, labeling it by its score when the label is unknown
+	score := func(r reply) sentimentScore {
+		s := sentimentScore{score: min(max(r.Score, -1), 1), label: r.Label}
+		switch s.label {
+		case sentimentPositive, sentimentNegative, sentimentNeutral, sentimentMixed:
+		default:
+			s.label = sentimentLabel(s.score, 0, 0)
+		}
+		return s
+	}
+	var commentScores []sentimentScore
+	if len(result.Comments) == len(comments) { // Scores can't be matched to comments otherwise
+		for _, comment := range result.Comments {
+			commentScores = append(commentScores, score(comment))
+		}
+	}
+	return score(result.reply), commentScores, result.Tones, nil
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sink receives every page as soon as it has been processed, so crawl output can
+// flow into other systems while the crawl is still running
+type Sink interface {
+	Write(data *CrawledData) error
+	Close() error
+}
+
+// SinkConfig selects and configures a sink
+type SinkConfig struct {
+	Type      string `json:"type"`      // "kafka", "nats", "postgres", "notion", "confluence" or "git"
+	URL       string `json:"url"`       // Comma-separated Kafka brokers, a NATS server URL, a Postgres DSN, the Confluence base URL or a Git repository
+	Topic     string `json:"topic"`     // Kafka topic or NATS subject
+	Format    string `json:"format"`    // Message serialization: "json" (default) or "avro"
+	JetStream bool   `json:"jetstream"` // Publish to NATS JetStream and wait for acknowledgements
+	Space     string `json:"space"`     // Confluence space key
+	Parent    string `json:"parent"`    // ID of the Notion or Confluence page that pages are created under
+	Branch    string `json:"branch"`    // Git branch to commit to
+}
+
+// newSink opens the sink described by config; sinks storing chunks split pages with
+// chunking
+func newSink(config SinkConfig, chunking ChunkerConfig) (Sink, error) {
+	if config.Format == "" {
+		config.Format = "json"
+	}
+	if config.Format != "json" && config.Format != "avro" {
+		return nil, fmt.Errorf("unsupported sink format %q", config.Format)
+	}
+	if config.URL == "" && config.Type != "notion" { // Notion has one API
+		return nil, fmt.Errorf("%s sink requires url", config.Type)
+	}
+	if config.Topic == "" && (config.Type == "kafka" || config.Type == "nats") {
+		return nil, fmt.Errorf("%s sink requires topic", config.Type)
+	}
+
+	switch config.Type {
+	case "kafka":
+		return newKafkaSink(config), nil
+	case "nats":
+		return newNATSSink(config)
+	case "postgres":
+		return newPostgresSink(config.URL, chunking)
+	case "notion":
+		return newNotionSink(config)
+	case "confluence":
+		return newConfluenceSink(config)
+	case "git":
+		return newGitSink(config)
+	default:
+		return nil, fmt.Errorf("unknown sink type %q", config.Type)
+	}
+}
+
+// openSinks opens all configured sinks, closing the ones already opened on failure
+func openSinks(configs []SinkConfig, chunking ChunkerConfig) ([]Sink, error) {
+	var sinks []Sink
+	for _, config := range configs {
+		sink, err := newSink(config, chunking)
+		if err != nil {
+			closeSinks(sinks)
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	return sinks, nil
+}
+
+// closeSinks flushes and closes sinks, logging failures
+func closeSinks(sinks []Sink) {
+	for _, sink := range sinks {
+		if err := sink.Close(); err != nil {
+			fmt.Printf("Closing sink failed: %v\n", err)
+		}
+	}
+}
+
+// encodePage serializes a page for a message-based sink
+func encodePage(data *CrawledData, format string) ([]byte, error) {
+	if format == "avro" {
+		return encodePageAvro(data)
+	}
+	return json.Marshal(data)
+}
+
+// avroPageSchema is the schema of pages published with the "avro" format. Messages
+// carry the binary encoding of a single record, without a container or schema registry header.
+const avroPageSchema = `{
+  "type": "record",
+  "name": "CrawledPage",
+  "namespace": "lexicrawler",
+  "fields": [
+    {"name": "url", "type": "string"},
+    {"name": "markdown", "type": "string"},
+    {"name": "metadata", "type": {"type": "map", "values": "string"}},
+    {"name": "structured_data_json", "type": "string"},
+    {"name": "screenshot_path", "type": "string"},
+    {"name": "words", "type": "long"},
+    {"name": "links", "type": "long"},
+    {"name": "images", "type": "long"},
+    {"name": "content_hash", "type": "string"}
+  ]
+}`
+
+// encodePageAvro encodes a page with avroPageSchema
+func encodePageAvro(data *CrawledData) ([]byte, error) {
+	structuredData, err := json.Marshal(data.StructuredData)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf []byte
+	buf = appendAvroString(buf, data.URL)
+	buf = appendAvroString(buf, data.Markdown)
+
+	// Maps are encoded as one block of entries followed by a zero-length block
+	keys := make([]string, 0, len(data.Metadata))
+	for key := range data.Metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if len(keys) > 0 {
+		buf = binary.AppendVarint(buf, int64(len(keys)))
+		for _, key := range keys {
+			buf = appendAvroString(buf, key)
+			buf = appendAvroString(buf, data.Metadata[key])
+		}
+	}
+	buf = binary.AppendVarint(buf, 0)
+
+	buf = appendAvroString(buf, string(structuredData))
+	buf = appendAvroString(buf, data.ScreenshotPath)
+	buf = binary.AppendVarint(buf, int64(data.Stats.Words))
+	buf = binary.AppendVarint(buf, int64(data.Stats.Links))
+	buf = binary.AppendVarint(buf, int64(data.Stats.Images))
+	buf = appendAvroString(buf, data.ContentHash)
+	return buf, nil
+}
+
+// appendAvroString appends an Avro string: zig-zag varint length followed by UTF-8 bytes
+func appendAvroString(buf []byte, value string) []byte {
+	buf = binary.AppendVarint(buf, int64(len(value)))
+	return append(buf, value...)
+}
+
+// sinkClient calls the APIs of the knowledge base sinks (Notion, Confluence)
+var sinkClient = &http.Client{Timeout: time.Minute}
+
+// Limits of knowledge base API calls
+const (
+	maxSinkAttempts = 4
+	maxSinkResponse = 16 << 20
+)
+
+// sinkAPI calls the JSON API of a knowledge base, spacing requests out for its rate
+// limit and retrying the ones it rejects as too many or fails on with a 5xx
+type sinkAPI struct {
+	name     string // For errors
+	baseURL  string
+	header   http.Header
+	interval time.Duration // Between the starts of two requests
+	mutex    sync.Mutex
+	next     time.Time
+}
+
+// wait blocks until the next request may start
+func (api *sinkAPI) wait() {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+	now := time.Now()
+	if api.next.After(now) {
+		time.Sleep(api.next.Sub(now))
+		now = api.next
+	}
+	api.next = now.Add(api.interval)
+}
+
+// call sends request as JSON to the API path (with its query) and decodes the JSON
+// answer into response, which may be nil
+func (api *sinkAPI) call(method string, path string, request interface{}, response interface{}) error {
+	var body []byte
+	if request != nil {
+		var err error
+		if body, err = json.Marshal(request); err != nil {
+			return err
+		}
+	}
+	for attempt := 1; ; attempt++ {
+		api.wait()
+		req, err := http.NewRequest(method, api.baseURL+path, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header = api.header.Clone()
+		req.Header.Set("Accept", "application/json")
+		if request != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		resp, err := sinkClient.Do(req)
+		if err != nil {
+			return err
+		}
+		respBody, err := io.ReadAll(io.LimitReader(resp.Body, maxSinkResponse))
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		if retry && attempt < maxSinkAttempts {
+			delay := time.Duration(attempt) * time.Second
+			if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds >= 0 && seconds <= 60 {
+				delay = time.Duration(seconds) * time.Second
+			}
+			time.Sleep(delay)
+			continue
+		}
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			message := strings.TrimSpace(string(respBody))
+			if len(message) > 300 {
+				message = message[:300] + "..."
+			}
+			return fmt.Errorf("%s API returned %d for %s %s: %s", api.name, resp.StatusCode, method, path, message)
+		}
+		if response == nil || len(respBody) == 0 {
+			return nil
+		}
+		return json.Unmarshal(respBody, response)
+	}
+}
+
+// sinkTitles gives the pages of a crawl unique titles in a knowledge base: their
+// title, qualified with their path when another page of the crawl has it too
+type sinkTitles struct {
+	mutex   sync.Mutex
+	claimed map[string]string // Title to page URL
+}
+
+// claim returns the title of a page, the same on every call for its URL
+func (t *sinkTitles) claim(data *CrawledData) string {
+	title := strings.Join(strings.Fields(firstNonEmpty(data.Metadata["title"], data.URL)), " ")
+	if len(title) > 200 {
+		title = strings.ToValidUTF8(title[:200], "")
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.claimed == nil {
+		t.claimed = make(map[string]string)
+	}
+	candidates := []string{title}
+	if parsedURL, err := url.Parse(data.URL); err == nil && parsedURL.Path != "" {
+		candidates = append(candidates, title+" ("+parsedURL.Path+")")
+	}
+	candidates = append(candidates, title+" ("+data.URL+")")
+	for _, candidate := range candidates {
+		if owner, ok := t.claimed[candidate]; !ok || owner == data.URL {
+			t.claimed[candidate] = data.URL
+			return candidate
+		}
+	}
+	return candidates[len(candidates)-1]
+}
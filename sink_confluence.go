@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	goldmarkhtml "github.com/yuin/goldmark/renderer/html"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// confluenceRequestPeriod spaces out Confluence API calls
+const confluenceRequestPeriod = 100 * time.Millisecond
+
+// confluenceHashPrefix starts the version message of mirrored pages, followed by
+// the page's content hash, so unchanged pages aren't saved as new versions
+const confluenceHashPrefix = "lexicrawler content hash "
+
+// confluenceRenderer renders markdown as XHTML, the base of Confluence's storage format
+var confluenceRenderer = goldmark.New(goldmark.WithExtensions(extension.GFM), goldmark.WithRendererOptions(goldmarkhtml.WithXHTML()))
+
+// confluenceMacros maps the kinds of calloutKinds to Confluence's panel macros
+var confluenceMacros = map[string]string{"note": "info", "tip": "tip", "important": "note", "warning": "note", "caution": "warning"}
+
+// confluenceSink mirrors pages into a Confluence space, updating the page of the
+// same title when a page is crawled again
+type confluenceSink struct {
+	api    *sinkAPI
+	space  string
+	parent string
+	titles sinkTitles
+}
+
+// confluencePage is a page of the Confluence REST API
+type confluencePage struct {
+	ID      string `json:"id"`
+	Version struct {
+		Number  int    `json:"number"`
+		Message string `json:"message"`
+	} `json:"version"`
+}
+
+// newConfluenceSink connects to the Confluence at config.URL (e.g.
+// https://example.atlassian.net/wiki). Confluence Cloud authenticates with an
+// account's e-mail in LEXICRAWLER_CONFLUENCE_USER and an API token in
+// LEXICRAWLER_CONFLUENCE_TOKEN; Confluence Server and Data Center with a personal
+// access token alone.
+func newConfluenceSink(config SinkConfig) (*confluenceSink, error) {
+	user, token := os.Getenv("LEXICRAWLER_CONFLUENCE_USER"), os.Getenv("LEXICRAWLER_CONFLUENCE_TOKEN")
+	if token == "" {
+		return nil, errors.New("confluence sink requires LEXICRAWLER_CONFLUENCE_TOKEN")
+	}
+	if config.Space == "" {
+		return nil, errors.New("confluence sink requires space, the key of the space to create pages in")
+	}
+	authorization := "Bearer " + token
+	if user != "" {
+		authorization = "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+token))
+	}
+	return &confluenceSink{
+		api: &sinkAPI{
+			name:     "Confluence",
+			baseURL:  strings.TrimRight(config.URL, "/"),
+			header:   http.Header{"Authorization": {authorization}},
+			interval: confluenceRequestPeriod,
+		},
+		space:  config.Space,
+		parent: config.Parent,
+	}, nil
+}
+
+// Write creates or updates the page in the space. Pages whose content hash is
+// recorded in their current version are left alone.
+func (s *confluenceSink) Write(data *CrawledData) error {
+	title := s.titles.claim(data)
+	var existing struct {
+		Results []confluencePage `json:"results"`
+	}
+	query := url.Values{"spaceKey": {s.space}, "title": {title}, "type": {"page"}, "expand": {"version"}}
+	if err := s.api.call(http.MethodGet, "/rest/api/content?"+query.Encode(), nil, &existing); err != nil {
+		return err
+	}
+	hashMessage := confluenceHashPrefix + data.ContentHash
+	if len(existing.Results) > 0 && existing.Results[0].Version.Message == hashMessage {
+		return nil
+	}
+	body, err := confluenceStorage(data)
+	if err != nil {
+		return fmt.Errorf("converting to the storage format: %w", err)
+	}
+
+	page := map[string]interface{}{
+		"type":  "page",
+		"title": title,
+		"space": map[string]string{"key": s.space},
+		"body":  map[string]interface{}{"storage": map[string]string{"value": body, "representation": "storage"}},
+	}
+	if len(existing.Results) == 0 {
+		if s.parent != "" {
+			page["ancestors"] = []map[string]string{{"id": s.parent}}
+		}
+		page["version"] = map[string]interface{}{"number": 1, "message": hashMessage}
+		return s.api.call(http.MethodPost, "/rest/api/content", page, nil)
+	}
+	current := existing.Results[0]
+	page["id"] = current.ID
+	page["version"] = map[string]interface{}{"number": current.Version.Number + 1, "message": hashMessage}
+	return s.api.call(http.MethodPut, "/rest/api/content/"+current.ID, page, nil)
+}
+
+// Close releases nothing, Confluence is called over HTTP
+func (s *confluenceSink) Close() error {
+	return nil
+}
+
+// confluenceStorage converts a page to Confluence's storage format: a link to the
+// page, then its markdown as XHTML with images, code blocks and alerts as macros
+func confluenceStorage(data *CrawledData) (string, error) {
+	var rendered bytes.Buffer
+	if err := confluenceRenderer.Convert([]byte(data.Markdown), &rendered); err != nil {
+		return "", err
+	}
+	nodes, err := html.ParseFragment(&rendered, &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body})
+	if err != nil {
+		return "", err
+	}
+	container := &html.Node{Type: html.ElementNode, Data: "div", DataAtom: atom.Div}
+	for _, node := range nodes {
+		container.AppendChild(node)
+	}
+	confluenceMacrosIn(container)
+
+	var out strings.Builder
+	out.WriteString(`<p>Source: <a href="` + html.EscapeString(data.URL) + `">` + html.EscapeString(data.URL) + "</a></p>")
+	for node := container.FirstChild; node != nil; node = node.NextSibling {
+		if err := html.Render(&out, node); err != nil {
+			return "", err
+		}
+	}
+	return out.String(), nil
+}
+
+// confluenceMacrosIn replaces images, code blocks and GitHub alerts below node with
+// the Confluence macros showing them
+func confluenceMacrosIn(node *html.Node) {
+	for child := node.FirstChild; child != nil; {
+		next := child.NextSibling
+		if child.Type == html.ElementNode {
+			var replacement *html.Node
+			switch child.Data {
+			case "img":
+				replacement = confluenceImage(child)
+			case "pre":
+				replacement = confluenceCode(child)
+			case "blockquote":
+				replacement = confluenceAlert(child)
+			}
+			if replacement != nil || child.Data == "img" {
+				if replacement != nil {
+					node.InsertBefore(replacement, child)
+				}
+				node.RemoveChild(child) // Images Confluence can't load are dropped
+				child = next
+				continue
+			}
+			confluenceMacrosIn(child)
+		}
+		child = next
+	}
+}
+
+// confluenceImage returns the image macro of an img, nil if it isn't on the web
+func confluenceImage(img *html.Node) *html.Node {
+	src, _ := attr(img, "src")
+	if !strings.HasPrefix(src, "http://") && !strings.HasPrefix(src, "https://") {
+		return nil
+	}
+	alt, _ := attr(img, "alt")
+	image := &html.Node{Type: html.ElementNode, Data: "ac:image", Attr: []html.Attribute{{Key: "ac:alt", Val: alt}}}
+	image.AppendChild(&html.Node{Type: html.ElementNode, Data: "ri:url", Attr: []html.Attribute{{Key: "ri:value", Val: src}}})
+	return image
+}
+
+// confluenceCode returns the code macro of a code block, with its language
+func confluenceCode(pre *html.Node) *html.Node {
+	language := codeLanguage(pre)
+	for child := pre.FirstChild; child != nil && language == ""; child = child.NextSibling {
+		if child.Type == html.ElementNode && child.Data == "code" {
+			language = codeLanguage(child)
+		}
+	}
+	var macro strings.Builder
+	macro.WriteString(`<ac:structured-macro ac:name="code">`)
+	if language != "" {
+		macro.WriteString(`<ac:parameter ac:name="language">` + html.EscapeString(language) + "</ac:parameter>")
+	}
+	code := strings.TrimRight(nodeText(pre), "\n")
+	macro.WriteString("<ac:plain-text-body><![CDATA[" + strings.ReplaceAll(code, "]]>", "]]]]><![CDATA[>") + "]]></ac:plain-text-body></ac:structured-macro>")
+	return &html.Node{Type: html.RawNode, Data: macro.String()}
+}
+
+// confluenceAlert returns the panel macro (info, tip, note or warning) of a quote
+// starting with the marker of a GitHub alert or Obsidian callout, nil for other quotes
+func confluenceAlert(quote *html.Node) *html.Node {
+	first := quote.FirstChild
+	for first != nil && first.Type != html.ElementNode {
+		first = first.NextSibling
+	}
+	if first == nil || first.Data != "p" || first.FirstChild == nil || first.FirstChild.Type != html.TextNode {
+		return nil
+	}
+	marker := alertMarker.FindStringSubmatch(first.FirstChild.Data)
+	if marker == nil {
+		return nil
+	}
+	first.FirstChild.Data = strings.TrimLeft(first.FirstChild.Data[len(marker[0]):], "\n")
+	kind := calloutKinds[strings.ToLower(marker[1])]
+	if kind == "" {
+		kind = "note"
+	}
+	macro := &html.Node{Type: html.ElementNode, Data: "ac:structured-macro", Attr: []html.Attribute{{Key: "ac:name", Val: confluenceMacros[kind]}}}
+	body := &html.Node{Type: html.ElementNode, Data: "ac:rich-text-body"}
+	macro.AppendChild(body)
+	for child := quote.FirstChild; child != nil; {
+		next := child.NextSibling
+		quote.RemoveChild(child)
+		body.AppendChild(child)
+		child = next
+	}
+	confluenceMacrosIn(body)
+	return macro
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxGitPushAttempts bounds the pushes of a crawl's commit, each after rebasing it
+// onto commits pushed by other crawls in the meantime
+const maxGitPushAttempts = 3
+
+// maxGitMessageFiles bounds the files listed per section of a commit message
+const maxGitMessageFiles = 50
+
+// scpLikeRemote matches remotes in git's user@host:path syntax
+var scpLikeRemote = regexp.MustCompile(`^[\w.-]+@[\w.-]+:`)
+
+// gitRemoteSchemes are the transports git sinks clone and push over; file:// and
+// git's transport::address helpers would reach into the server
+var gitRemoteSchemes = wordSet("https http ssh git")
+
+// gitAllowedProtocols limits the protocols of every git command a sink runs, so
+// neither a remote nor its redirects can switch to another transport
+const gitAllowedProtocols = "https:http:ssh:git"
+
+// gitRootFromEnv returns the directory local repositories of git sinks are kept in:
+// "git" in LEXICRAWLER_DATA_DIR, or "" when local repositories are disabled
+func gitRootFromEnv() string {
+	dataDir := os.Getenv("LEXICRAWLER_DATA_DIR")
+	if dataDir == "" {
+		return ""
+	}
+	return filepath.Join(dataDir, "git")
+}
+
+// gitRepository returns the remote a git sink's url names, or the path of its local
+// repository below root; relative paths are resolved against root
+func gitRepository(location string, root string) (repository string, remote bool, err error) {
+	switch {
+	case strings.HasPrefix(location, "-"):
+		return "", false, fmt.Errorf("invalid git sink url %q", location)
+	case strings.Contains(location, "://"):
+		parsedURL, err := url.Parse(location)
+		if err != nil || !gitRemoteSchemes[strings.ToLower(parsedURL.Scheme)] || parsedURL.Host == "" {
+			return "", false, errors.New("git sink remotes must be https, http, ssh or git URLs")
+		}
+		return location, true, nil
+	case strings.Contains(location, "::"): // transport::address
+		return "", false, errors.New("git sink remotes must be https, http, ssh or git URLs")
+	case scpLikeRemote.MatchString(location):
+		return location, true, nil
+	}
+	if root == "" {
+		return "", false, errors.New("local git repositories are disabled (set LEXICRAWLER_DATA_DIR to keep them under its git directory)")
+	}
+	path := location
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(root, path)
+	}
+	if !isWithinRoot(root, path) {
+		return "", false, fmt.Errorf("%s is outside the git sink directory %s", location, root)
+	}
+	return path, false, nil
+}
+
+// validateGitSink checks the repository and branch of a git sink
+func validateGitSink(config SinkConfig) error {
+	if _, err := exec.LookPath("git"); err != nil {
+		return errors.New("git sink requires the git command")
+	}
+	if _, _, err := gitRepository(config.URL, gitRootFromEnv()); err != nil {
+		return err
+	}
+	if config.Branch == "" {
+		return nil
+	}
+	if strings.HasPrefix(config.Branch, "-") || exec.Command("git", "check-ref-format", "--branch", config.Branch).Run() != nil {
+		return fmt.Errorf("invalid git branch %q", config.Branch)
+	}
+	return nil
+}
+
+// gitSink writes pages as markdown files into a Git repository and commits them
+// once per crawl, so the history of a site can be diffed and reviewed. Pages are
+// stored at host/path.md, like the output of archive replays.
+type gitSink struct {
+	dir     string // Working tree
+	remote  bool   // dir is a clone of a remote repository, pushed to and removed on Close
+	branch  string
+	env     []string // Committer identity, when git has none configured
+	started time.Time
+
+	mutex sync.Mutex
+	files map[string]bool // Files written, relative to dir
+	hosts map[string]bool
+	pages int
+}
+
+// newGitSink opens the repository at config.URL: a local directory below
+// gitRootFromEnv, created and initialized if needed, or a remote that is cloned to a
+// temporary directory. Pages are committed to config.Branch, by default the current
+// or remote's default branch.
+func newGitSink(config SinkConfig) (*gitSink, error) {
+	if err := validateGitSink(config); err != nil {
+		return nil, err
+	}
+	repository, remote, _ := gitRepository(config.URL, gitRootFromEnv()) // Checked by validateGitSink
+	s := &gitSink{
+		branch:  config.Branch,
+		started: time.Now(),
+		files:   make(map[string]bool),
+		hosts:   make(map[string]bool),
+	}
+	var err error
+	if remote {
+		err = s.clone(repository)
+	} else {
+		err = s.openLocal(repository)
+	}
+	if err != nil {
+		if s.remote {
+			os.RemoveAll(s.dir)
+		}
+		return nil, err
+	}
+	if email, _ := s.git("", "config", "user.email"); email == "" {
+		s.env = []string{"GIT_AUTHOR_NAME=lexicrawler", "GIT_AUTHOR_EMAIL=lexicrawler@localhost",
+			"GIT_COMMITTER_NAME=lexicrawler", "GIT_COMMITTER_EMAIL=lexicrawler@localhost"}
+	}
+	return s, nil
+}
+
+// clone makes a shallow clone of the sink's branch, or starts the branch in an empty
+// repository when the remote doesn't have it yet
+func (s *gitSink) clone(remote string) error {
+	dir, err := os.MkdirTemp("", "lexicrawler-git-")
+	if err != nil {
+		return err
+	}
+	s.dir, s.remote = dir, true
+	if s.branch == "" {
+		_, err := s.git("", "clone", "-q", "--depth", "1", "--", remote, ".")
+		return err
+	}
+	heads, err := s.git("", "ls-remote", "--heads", "--", remote, s.branch)
+	if err != nil {
+		return err
+	}
+	if heads != "" {
+		_, err := s.git("", "clone", "-q", "--depth", "1", "--branch", s.branch, "--", remote, ".")
+		return err
+	}
+	if _, err := s.git("", "init", "-q"); err != nil {
+		return err
+	}
+	if _, err := s.git("", "remote", "add", "origin", "--", remote); err != nil {
+		return err
+	}
+	_, err = s.git("", "symbolic-ref", "HEAD", "refs/heads/"+s.branch)
+	return err
+}
+
+// openLocal initializes the repository at dir unless it is one already, and checks
+// out the sink's branch
+func (s *gitSink) openLocal(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	s.dir = dir
+	if _, err := s.git("", "rev-parse", "--git-dir"); err != nil {
+		if _, err := s.git("", "init", "-q"); err != nil {
+			return err
+		}
+	}
+	if s.branch == "" {
+		return nil
+	}
+	current, _ := s.git("", "symbolic-ref", "--short", "HEAD")
+	if current == s.branch {
+		return nil
+	}
+	if _, err := s.git("", "rev-parse", "--verify", "-q", "refs/heads/"+s.branch); err == nil {
+		_, err := s.git("", "checkout", "-q", s.branch)
+		return err
+	}
+	_, err := s.git("", "checkout", "-q", "-b", s.branch)
+	return err
+}
+
+// Write stores the page's markdown in the working tree
+func (s *gitSink) Write(data *CrawledData) error {
+	parsedURL, err := url.Parse(data.URL)
+	if err != nil {
+		return err
+	}
+	rel, err := archiveOutputPath(parsedURL)
+	if err != nil {
+		return err
+	}
+	rel += ".md"
+	path := filepath.Join(s.dir, rel)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, []byte(data.Markdown), 0644); err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	s.files[filepath.ToSlash(rel)] = true
+	s.hosts[parsedURL.Host] = true
+	s.pages++
+	s.mutex.Unlock()
+	return nil
+}
+
+// Close commits the pages written by the crawl, if any changed, and pushes the
+// commit to the remote
+func (s *gitSink) Close() error {
+	if s.remote {
+		defer os.RemoveAll(s.dir)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(s.files) == 0 {
+		return nil
+	}
+	files := make([]string, 0, len(s.files))
+	for file := range s.files {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	if _, err := s.git(strings.Join(files, "\n"), "add", "-A", "--pathspec-from-file=-"); err != nil {
+		return err
+	}
+	status, err := s.git("", "diff", "--cached", "--name-status", "--no-renames")
+	if err != nil {
+		return err
+	}
+	var added, updated []string
+	for _, line := range strings.Split(status, "\n") {
+		change, file, found := strings.Cut(line, "\t")
+		if !found {
+			continue
+		}
+		if change == "A" {
+			added = append(added, file)
+		} else {
+			updated = append(updated, file)
+		}
+	}
+	if len(added)+len(updated) == 0 {
+		return nil // Nothing changed since the last crawl
+	}
+
+	if _, err := s.git(s.commitMessage(added, updated), "commit", "-q", "-F", "-"); err != nil {
+		return err
+	}
+	if !s.remote {
+		return nil
+	}
+	return s.push()
+}
+
+// commitMessage summarizes a crawl's changes: the hosts and counts in the subject,
+// the files in the body
+func (s *gitSink) commitMessage(added []string, updated []string) string {
+	hosts := make([]string, 0, len(s.hosts))
+	for host := range s.hosts {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	if len(hosts) > 3 {
+		hosts = append(hosts[:3], fmt.Sprintf("%d more hosts", len(hosts)-3))
+	}
+
+	var changes []string
+	if len(added) > 0 {
+		changes = append(changes, countPages(len(added))+" added")
+	}
+	if len(updated) > 0 {
+		changes = append(changes, countPages(len(updated))+" updated")
+	}
+	var message strings.Builder
+	fmt.Fprintf(&message, "Crawl of %s: %s\n\n", strings.Join(hosts, ", "), strings.Join(changes, ", "))
+	fmt.Fprintf(&message, "Crawled %s on %s, %d unchanged.\n", countPages(s.pages),
+		s.started.UTC().Format("2006-01-02 15:04 MST"), len(s.files)-len(added)-len(updated))
+	for _, section := range []struct {
+		title string
+		files []string
+	}{{"Added", added}, {"Updated", updated}} {
+		if len(section.files) == 0 {
+			continue
+		}
+		message.WriteString("\n" + section.title + ":\n")
+		for i, file := range section.files {
+			if i == maxGitMessageFiles {
+				fmt.Fprintf(&message, "- and %d more\n", len(section.files)-i)
+				break
+			}
+			message.WriteString("- " + file + "\n")
+		}
+	}
+	return message.String()
+}
+
+// countPages returns "1 page" or "n pages"
+func countPages(n int) string {
+	if n == 1 {
+		return "1 page"
+	}
+	return fmt.Sprintf("%d pages", n)
+}
+
+// push pushes the crawl's commit, rebasing it onto the remote branch when other
+// crawls pushed first
+func (s *gitSink) push() error {
+	branch, err := s.git("", "symbolic-ref", "--short", "HEAD")
+	if err != nil {
+		return err
+	}
+	for attempt := 1; ; attempt++ {
+		_, err := s.git("", "push", "-q", "origin", "HEAD:refs/heads/"+branch)
+		if err == nil || attempt == maxGitPushAttempts {
+			return err
+		}
+		if _, err := s.git("", "pull", "-q", "--rebase", "origin", branch); err != nil {
+			s.git("", "rebase", "--abort")
+			return fmt.Errorf("rebasing onto %s after a rejected push: %w", branch, err)
+		}
+	}
+}
+
+// git runs a git command in the working tree with stdin as its input, returning its
+// trimmed output. Prompts for credentials are disabled, as nobody could answer them,
+// and remotes are limited to gitAllowedProtocols.
+func (s *gitSink) git(stdin string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = s.dir
+	cmd.Env = append(append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "GIT_ALLOW_PROTOCOL="+gitAllowedProtocols), s.env...)
+	cmd.Stdin = strings.NewReader(stdin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
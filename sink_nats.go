@@ -0,0 +1,56 @@
+package main
+
+import (
+	"github.com/nats-io/nats.go"
+)
+
+// natsSink publishes pages to a NATS subject, optionally through JetStream
+type natsSink struct {
+	conn    *nats.Conn
+	js      nats.JetStreamContext // nil for core NATS
+	subject string
+	format  string
+}
+
+// newNATSSink connects to the NATS server of config
+func newNATSSink(config SinkConfig) (*natsSink, error) {
+	conn, err := nats.Connect(config.URL, nats.Name("lexicrawler"))
+	if err != nil {
+		return nil, err
+	}
+	sink := &natsSink{conn: conn, subject: config.Topic, format: config.Format}
+	if config.JetStream {
+		if sink.js, err = conn.JetStream(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return sink, nil
+}
+
+// Write publishes a page; with JetStream it waits for the stream's acknowledgement
+func (s *natsSink) Write(data *CrawledData) error {
+	payload, err := encodePage(data, s.format)
+	if err != nil {
+		return err
+	}
+	msg := nats.NewMsg(s.subject)
+	msg.Header.Set("Lexicrawler-Url", data.URL)
+	msg.Header.Set("Content-Format", s.format)
+	msg.Header.Set("Content-Hash", data.ContentHash)
+	// JetStream drops re-publications of the same content within its duplicate window
+	msg.Header.Set(nats.MsgIdHdr, pageID(data.URL)+"-"+data.ContentHash)
+	msg.Data = payload
+
+	if s.js != nil {
+		_, err = s.js.PublishMsg(msg)
+		return err
+	}
+	return s.conn.PublishMsg(msg)
+}
+
+// Close flushes buffered messages and closes the connection
+func (s *natsSink) Close() error {
+	defer s.conn.Close()
+	return s.conn.Flush()
+}
@@ -0,0 +1,465 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/yuin/goldmark/ast"
+	east "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// Limits of the Notion API
+const (
+	notionVersion       = "2022-06-28"
+	maxNotionBlocks     = 100  // Children per request
+	maxNotionText       = 2000 // Characters of a rich text object
+	maxNotionRichText   = 100  // Rich text objects of a block
+	notionRequestPeriod = 350 * time.Millisecond
+)
+
+// alertMarker matches the marker of a GitHub alert or Obsidian callout starting a quote
+var alertMarker = regexp.MustCompile(`^\[!(\w+)\][ \t]*`)
+
+// notionCalloutIcons are the icons of callouts, by the kinds of calloutKinds
+var notionCalloutIcons = map[string]string{"note": "ℹ️", "tip": "💡", "important": "❗", "warning": "⚠️", "caution": "🛑"}
+
+// notionLanguages maps code block languages to the names Notion accepts
+var notionLanguages = map[string]string{
+	"bash": "bash", "sh": "shell", "shell": "shell", "zsh": "shell", "console": "shell",
+	"c": "c", "cpp": "c++", "c++": "c++", "csharp": "c#", "cs": "c#", "c#": "c#",
+	"css": "css", "docker": "docker", "dockerfile": "docker", "go": "go", "golang": "go",
+	"html": "html", "java": "java", "javascript": "javascript", "js": "javascript", "jsx": "javascript",
+	"json": "json", "kotlin": "kotlin", "markdown": "markdown", "md": "markdown", "php": "php",
+	"python": "python", "py": "python", "ruby": "ruby", "rb": "ruby", "rust": "rust", "rs": "rust",
+	"scala": "scala", "sql": "sql", "swift": "swift", "typescript": "typescript", "ts": "typescript",
+	"tsx": "typescript", "xml": "xml", "yaml": "yaml", "yml": "yaml", "graphql": "graphql", "diff": "diff",
+}
+
+// notionSink mirrors pages into Notion, as child pages of a parent page. A page
+// crawled again replaces the page of the same title, which is archived.
+type notionSink struct {
+	api    *sinkAPI
+	parent string
+	titles sinkTitles
+	mutex  sync.Mutex
+	pages  map[string]string // Title to ID of the child pages of the parent
+}
+
+// notionBlock is a block of the Notion API, e.g. {"type": "paragraph", "paragraph": {...}}
+type notionBlock map[string]interface{}
+
+// notionSpan is a run of text with its formatting, see notionRichText
+type notionSpan struct {
+	text                              string
+	bold, italic, code, strikethrough bool
+	link                              string
+}
+
+// newNotionSink connects to Notion with the integration token in
+// LEXICRAWLER_NOTION_TOKEN and lists the pages already under the parent page
+func newNotionSink(config SinkConfig) (*notionSink, error) {
+	token := os.Getenv("LEXICRAWLER_NOTION_TOKEN")
+	if token == "" {
+		return nil, errors.New("notion sink requires LEXICRAWLER_NOTION_TOKEN")
+	}
+	if config.Parent == "" {
+		return nil, errors.New("notion sink requires parent, the ID of the page to create pages under")
+	}
+	baseURL := strings.TrimRight(config.URL, "/")
+	if baseURL == "" {
+		baseURL = "https://api.notion.com"
+	}
+	sink := &notionSink{
+		api: &sinkAPI{
+			name:     "Notion",
+			baseURL:  baseURL,
+			header:   http.Header{"Authorization": {"Bearer " + token}, "Notion-Version": {notionVersion}},
+			interval: notionRequestPeriod, // Notion allows three requests per second on average
+		},
+		parent: strings.ReplaceAll(config.Parent, "-", ""),
+		pages:  make(map[string]string),
+	}
+	if err := sink.listPages(); err != nil {
+		return nil, err
+	}
+	return sink, nil
+}
+
+// listPages indexes the child pages of the parent page by title
+func (s *notionSink) listPages() error {
+	cursor := ""
+	for {
+		var response struct {
+			Results []struct {
+				ID        string `json:"id"`
+				Type      string `json:"type"`
+				ChildPage struct {
+					Title string `json:"title"`
+				} `json:"child_page"`
+			} `json:"results"`
+			HasMore    bool   `json:"has_more"`
+			NextCursor string `json:"next_cursor"`
+		}
+		query := url.Values{"page_size": {"100"}}
+		if cursor != "" {
+			query.Set("start_cursor", cursor)
+		}
+		if err := s.api.call(http.MethodGet, "/v1/blocks/"+s.parent+"/children?"+query.Encode(), nil, &response); err != nil {
+			return err
+		}
+		for _, block := range response.Results {
+			if block.Type == "child_page" {
+				s.pages[block.ChildPage.Title] = block.ID
+			}
+		}
+		if !response.HasMore || response.NextCursor == "" {
+			return nil
+		}
+		cursor = response.NextCursor
+	}
+}
+
+// Write creates the page in Notion, archiving the page it replaces. Blocks beyond
+// the first hundred are appended in batches.
+func (s *notionSink) Write(data *CrawledData) error {
+	title := s.titles.claim(data)
+	blocks := notionBlocks(data)
+	first := blocks
+	if len(first) > maxNotionBlocks {
+		first = first[:maxNotionBlocks]
+	}
+	var created struct {
+		ID string `json:"id"`
+	}
+	err := s.api.call(http.MethodPost, "/v1/pages", map[string]interface{}{
+		"parent":     map[string]string{"page_id": s.parent},
+		"properties": map[string]interface{}{"title": map[string]interface{}{"title": notionRichText([]notionSpan{{text: title}})}},
+		"children":   first,
+	}, &created)
+	if err != nil {
+		return err
+	}
+	for start := len(first); start < len(blocks); start += maxNotionBlocks {
+		batch := blocks[start:min(start+maxNotionBlocks, len(blocks))]
+		if err := s.api.call(http.MethodPatch, "/v1/blocks/"+created.ID+"/children", map[string]interface{}{"children": batch}, nil); err != nil {
+			return err
+		}
+	}
+
+	s.mutex.Lock()
+	previous := s.pages[title]
+	s.pages[title] = created.ID
+	s.mutex.Unlock()
+	if previous != "" {
+		return s.api.call(http.MethodPatch, "/v1/pages/"+previous, map[string]bool{"archived": true}, nil)
+	}
+	return nil
+}
+
+// Close releases nothing, Notion is called over HTTP
+func (s *notionSink) Close() error {
+	return nil
+}
+
+// notionBlocks converts a page to Notion blocks: a link to the page, then its
+// markdown as headings, paragraphs, lists, code, quotes, callouts, tables and images
+func notionBlocks(data *CrawledData) []notionBlock {
+	source := []byte(data.Markdown)
+	converter := notionConverter{source: source}
+	blocks := []notionBlock{newNotionBlock("paragraph", notionRichText([]notionSpan{{text: "Source: "}, {text: data.URL, link: data.URL}}))}
+	return append(blocks, converter.blocks(markdownRenderer.Parser().Parse(text.NewReader(source)).FirstChild(), 0)...)
+}
+
+// newNotionBlock returns a block of a type holding rich text
+func newNotionBlock(kind string, richText []map[string]interface{}) notionBlock {
+	return notionBlock{"object": "block", "type": kind, kind: map[string]interface{}{"rich_text": richText}}
+}
+
+// notionConverter converts the markdown AST of a page to Notion blocks
+type notionConverter struct {
+	source []byte
+}
+
+// blocks converts a node and its following siblings. Notion nests children two
+// levels deep at most, so lists deeper than that are flattened.
+func (c notionConverter) blocks(first ast.Node, depth int) []notionBlock {
+	var blocks []notionBlock
+	for child := first; child != nil; child = child.NextSibling() {
+		switch child := child.(type) {
+		case *ast.Heading:
+			kind := "heading_" + strconv.Itoa(min(child.Level, 3))
+			blocks = append(blocks, newNotionBlock(kind, notionRichText(c.spans(child, notionSpan{}))))
+		case *ast.Paragraph, *ast.TextBlock:
+			if spans := c.spans(child, notionSpan{}); strings.TrimSpace(spansText(spans)) != "" {
+				blocks = append(blocks, newNotionBlock("paragraph", notionRichText(spans)))
+			}
+			blocks = append(blocks, c.images(child)...)
+		case *ast.List:
+			kind := "bulleted_list_item"
+			if child.IsOrdered() {
+				kind = "numbered_list_item"
+			}
+			for item := child.FirstChild(); item != nil; item = item.NextSibling() {
+				var spans []notionSpan
+				rest := item.FirstChild()
+				if rest != nil && (rest.Kind() == ast.KindParagraph || rest.Kind() == ast.KindTextBlock) {
+					spans = c.spans(rest, notionSpan{})
+					rest = rest.NextSibling()
+				}
+				block := newNotionBlock(kind, notionRichText(spans))
+				nested := c.blocks(rest, depth+1)
+				blocks = append(blocks, block)
+				if depth == 0 && len(nested) > 0 {
+					block[kind].(map[string]interface{})["children"] = nested
+				} else {
+					blocks = append(blocks, nested...)
+				}
+			}
+		case *ast.FencedCodeBlock, *ast.CodeBlock:
+			var code strings.Builder
+			lines := child.Lines()
+			for i := 0; i < lines.Len(); i++ {
+				segment := lines.At(i)
+				code.Write(segment.Value(c.source))
+			}
+			language := "plain text"
+			if fenced, ok := child.(*ast.FencedCodeBlock); ok {
+				if name, ok := notionLanguages[strings.ToLower(string(fenced.Language(c.source)))]; ok {
+					language = name
+				}
+			}
+			block := newNotionBlock("code", notionRichText([]notionSpan{{text: strings.TrimRight(code.String(), "\n")}}))
+			block["code"].(map[string]interface{})["language"] = language
+			blocks = append(blocks, block)
+		case *ast.Blockquote:
+			blocks = append(blocks, c.quote(child))
+		case *ast.ThematicBreak:
+			blocks = append(blocks, notionBlock{"object": "block", "type": "divider", "divider": map[string]interface{}{}})
+		case *east.Table:
+			blocks = append(blocks, c.table(child))
+		case *ast.HTMLBlock:
+			// Raw HTML isn't carried over
+		default:
+			blocks = append(blocks, c.blocks(child.FirstChild(), depth)...)
+		}
+	}
+	return blocks
+}
+
+// quote converts a blockquote to a quote block, or to a callout when it starts with
+// the marker of a GitHub alert or Obsidian callout
+func (c notionConverter) quote(node *ast.Blockquote) notionBlock {
+	var spans []notionSpan
+	for child := node.FirstChild(); child != nil; child = child.NextSibling() {
+		if len(spans) > 0 {
+			spans = append(spans, notionSpan{text: "\n"})
+		}
+		if child.Kind() == ast.KindList {
+			for item := child.FirstChild(); item != nil; item = item.NextSibling() {
+				spans = append(spans, notionSpan{text: "• "})
+				spans = append(spans, c.spans(item, notionSpan{})...)
+				spans = append(spans, notionSpan{text: "\n"})
+			}
+			continue
+		}
+		spans = append(spans, c.spans(child, notionSpan{})...)
+	}
+	marker := alertMarker.FindStringSubmatch(spansText(spans))
+	if marker == nil {
+		return newNotionBlock("quote", notionRichText(spans))
+	}
+	kind := calloutKinds[strings.ToLower(marker[1])]
+	if kind == "" {
+		kind = "note"
+	}
+	block := newNotionBlock("callout", notionRichText(trimSpans(spans, len(marker[0]))))
+	block["callout"].(map[string]interface{})["icon"] = map[string]string{"type": "emoji", "emoji": notionCalloutIcons[kind]}
+	return block
+}
+
+// table converts a GFM table to a table block with a header row
+func (c notionConverter) table(node *east.Table) notionBlock {
+	var rows [][][]map[string]interface{}
+	for row := node.FirstChild(); row != nil; row = row.NextSibling() {
+		var cells [][]map[string]interface{}
+		for cell := row.FirstChild(); cell != nil; cell = cell.NextSibling() {
+			cells = append(cells, notionRichText(c.spans(cell, notionSpan{})))
+		}
+		rows = append(rows, cells)
+	}
+	width := 1
+	if len(rows) > 0 {
+		width = max(1, len(rows[0]))
+	}
+	children := make([]notionBlock, 0, len(rows))
+	for _, cells := range rows {
+		for len(cells) < width {
+			cells = append(cells, []map[string]interface{}{})
+		}
+		children = append(children, notionBlock{"object": "block", "type": "table_row", "table_row": map[string]interface{}{"cells": cells[:width]}})
+	}
+	return notionBlock{"object": "block", "type": "table", "table": map[string]interface{}{
+		"table_width":       width,
+		"has_column_header": true,
+		"children":          children,
+	}}
+}
+
+// images returns image blocks for the images of a paragraph; Notion only shows
+// images it can load from a URL
+func (c notionConverter) images(node ast.Node) []notionBlock {
+	var blocks []notionBlock
+	ast.Walk(node, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if image, ok := n.(*ast.Image); ok && entering {
+			src := string(image.Destination)
+			if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+				blocks = append(blocks, notionBlock{"object": "block", "type": "image", "image": map[string]interface{}{
+					"type":     "external",
+					"external": map[string]string{"url": src},
+				}})
+			}
+			return ast.WalkSkipChildren, nil
+		}
+		return ast.WalkContinue, nil
+	})
+	return blocks
+}
+
+// spans converts the inline children of node to formatted text. Images are left to
+// images.
+func (c notionConverter) spans(node ast.Node, format notionSpan) []notionSpan {
+	var spans []notionSpan
+	for child := node.FirstChild(); child != nil; child = child.NextSibling() {
+		switch child := child.(type) {
+		case *ast.Text:
+			span := format
+			value := child.Segment.Value(c.source)
+			if !format.code {
+				value = util.UnescapePunctuations(value)
+			}
+			span.text = string(value)
+			if child.HardLineBreak() {
+				span.text += "\n"
+			} else if child.SoftLineBreak() {
+				span.text += " "
+			}
+			spans = append(spans, span)
+		case *ast.String:
+			span := format
+			span.text = string(child.Value)
+			spans = append(spans, span)
+		case *ast.CodeSpan:
+			code := format
+			code.code = true
+			spans = append(spans, c.spans(child, code)...)
+		case *ast.Emphasis:
+			emphasis := format
+			if child.Level >= 2 {
+				emphasis.bold = true
+			} else {
+				emphasis.italic = true
+			}
+			spans = append(spans, c.spans(child, emphasis)...)
+		case *east.Strikethrough:
+			struck := format
+			struck.strikethrough = true
+			spans = append(spans, c.spans(child, struck)...)
+		case *ast.Link:
+			link := format
+			if destination := string(child.Destination); strings.HasPrefix(destination, "http://") || strings.HasPrefix(destination, "https://") {
+				link.link = destination
+			}
+			spans = append(spans, c.spans(child, link)...)
+		case *ast.AutoLink:
+			span := format
+			span.text = string(child.Label(c.source))
+			if destination := string(child.URL(c.source)); strings.HasPrefix(destination, "http") {
+				span.link = destination
+			}
+			spans = append(spans, span)
+		case *ast.Image, *ast.RawHTML:
+		default:
+			spans = append(spans, c.spans(child, format)...)
+		}
+	}
+	return spans
+}
+
+// notionRichText converts spans to rich text objects, merging runs of the same
+// formatting and splitting text longer than Notion accepts
+func notionRichText(spans []notionSpan) []map[string]interface{} {
+	var merged []notionSpan
+	for _, span := range spans {
+		if span.text == "" {
+			continue
+		}
+		if last := len(merged) - 1; last >= 0 && merged[last].sameFormat(span) {
+			merged[last].text += span.text
+			continue
+		}
+		merged = append(merged, span)
+	}
+	richText := []map[string]interface{}{}
+	for _, span := range merged {
+		for remaining := span.text; remaining != "" && len(richText) < maxNotionRichText; {
+			chunk := remaining
+			if utf8.RuneCountInString(chunk) > maxNotionText {
+				chunk = string([]rune(chunk)[:maxNotionText])
+			}
+			remaining = remaining[len(chunk):]
+			content := map[string]interface{}{"content": chunk}
+			if span.link != "" {
+				content["link"] = map[string]string{"url": span.link}
+			}
+			richText = append(richText, map[string]interface{}{
+				"type": "text",
+				"text": content,
+				"annotations": map[string]bool{
+					"bold": span.bold, "italic": span.italic, "code": span.code, "strikethrough": span.strikethrough,
+				},
+			})
+		}
+	}
+	return richText
+}
+
+// sameFormat reports whether two spans can be merged
+func (s notionSpan) sameFormat(other notionSpan) bool {
+	return s.bold == other.bold && s.italic == other.italic && s.code == other.code &&
+		s.strikethrough == other.strikethrough && s.link == other.link
+}
+
+// spansText returns the text of spans
+func spansText(spans []notionSpan) string {
+	var text strings.Builder
+	for _, span := range spans {
+		text.WriteString(span.text)
+	}
+	return text.String()
+}
+
+// trimSpans drops the first n bytes of the text of spans
+func trimSpans(spans []notionSpan, n int) []notionSpan {
+	for len(spans) > 0 && n > 0 {
+		if len(spans[0].text) > n {
+			spans[0].text = spans[0].text[n:]
+			break
+		}
+		n -= len(spans[0].text)
+		spans = spans[1:]
+	}
+	if len(spans) > 0 {
+		spans[0].text = strings.TrimLeft(spans[0].text, " \n")
+	}
+	return spans
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"database/sql"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+//go:embed migrations/postgres/*.sql
+var postgresMigrations embed.FS
+
+// postgresSink stores pages, their metadata, links and chunks in normalized tables
+type postgresSink struct {
+	db       *sql.DB
+	chunking ChunkerConfig
+	chunks   *chunkDeduper // Chunks written by the crawl, see ChunkerConfig.Dedupe
+}
+
+// newPostgresSink connects to the database at dsn and applies pending migrations
+func newPostgresSink(dsn string, chunking ChunkerConfig) (*postgresSink, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := migratePostgres(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrating postgres schema: %w", err)
+	}
+	return &postgresSink{db: db, chunking: chunking, chunks: newChunkDeduper()}, nil
+}
+
+// migratePostgres applies every embedded migration newer than the recorded schema
+// version, each in its own transaction. Migration files are named NNNN_description.sql.
+func migratePostgres(db *sql.DB) error {
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`); err != nil {
+		return err
+	}
+	var current int
+	if err := db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return err
+	}
+
+	files, err := fs.Glob(postgresMigrations, "migrations/postgres/*.sql")
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		versionText, _, _ := strings.Cut(path.Base(file), "_")
+		version, err := strconv.Atoi(versionText)
+		if err != nil {
+			return fmt.Errorf("migration %s has no numeric version prefix", file)
+		}
+		if version <= current {
+			continue
+		}
+		script, err := postgresMigrations.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(string(script)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("applying %s: %w", file, err)
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_migrations (version) VALUES ($1)`, version); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write upserts a page by URL and replaces its metadata, links and chunks. Pages
+// whose content hash is unchanged are left untouched.
+func (s *postgresSink) Write(data *CrawledData) error {
+	structuredData, err := json.Marshal(data.StructuredData)
+	if err != nil {
+		return err
+	}
+	// Chunked before the upsert, so repeated chunks are found on unchanged pages too
+	chunks := s.chunks.apply(data.URL, pageChunks(data, s.chunking), s.chunking.Dedupe)
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() // No-op after Commit
+
+	var id int64
+	err = tx.QueryRow(`
+		INSERT INTO pages (url, title, markdown, screenshot_path, structured_data, word_count, link_count, image_count, content_hash, crawled_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, now())
+		ON CONFLICT (url) DO UPDATE SET
+			title = EXCLUDED.title,
+			markdown = EXCLUDED.markdown,
+			screenshot_path = EXCLUDED.screenshot_path,
+			structured_data = EXCLUDED.structured_data,
+			word_count = EXCLUDED.word_count,
+			link_count = EXCLUDED.link_count,
+			image_count = EXCLUDED.image_count,
+			content_hash = EXCLUDED.content_hash,
+			crawled_at = EXCLUDED.crawled_at
+		WHERE pages.content_hash <> EXCLUDED.content_hash
+		RETURNING id`,
+		data.URL, data.Metadata["title"], data.Markdown, data.ScreenshotPath, string(structuredData),
+		data.Stats.Words, data.Stats.Links, data.Stats.Images, data.ContentHash,
+	).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil // Unchanged since it was last stored
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM page_metadata WHERE page_id = $1`, id); err != nil {
+		return err
+	}
+	for key, value := range data.Metadata {
+		if _, err := tx.Exec(`INSERT INTO page_metadata (page_id, key, value) VALUES ($1, $2, $3)`, id, key, value); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(`DELETE FROM links WHERE page_id = $1`, id); err != nil {
+		return err
+	}
+	links, _ := data.StructuredData["links"].([]string)
+	for position, link := range links {
+		if _, err := tx.Exec(`INSERT INTO links (page_id, target_url, position) VALUES ($1, $2, $3)`, id, link, position); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(`DELETE FROM chunks WHERE page_id = $1`, id); err != nil {
+		return err
+	}
+	for _, chunk := range chunks {
+		var duplicateURL string
+		var duplicateIndex sql.NullInt64
+		if chunk.DuplicateOf != nil {
+			duplicateURL, duplicateIndex = chunk.DuplicateOf.URL, sql.NullInt64{Int64: int64(chunk.DuplicateOf.Index), Valid: true}
+		}
+		_, err := tx.Exec(`INSERT INTO chunks (page_id, chunk_index, heading_path, content, start_offset, end_offset, citation_url, anchor, chunker, duplicate_of_url, duplicate_of_index)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+			id, chunk.Index, pq.Array(chunk.HeadingPath), chunk.Text, chunk.Start, chunk.End, chunk.Citation.URL, chunk.Citation.Anchor, chunk.Chunker, duplicateURL, duplicateIndex)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Close closes the connection pool
+func (s *postgresSink) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"archive/zip"
+	"embed"
+	"encoding/json"
+	"html/template"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+//go:embed site
+var siteAssets embed.FS
+
+// maxSiteSearchText bounds the text of a page in the search index of a static site,
+// so sites with long pages stay quick to load
+const maxSiteSearchText = 20000
+
+// siteTemplates lay out the pages of a static site export. Pages live in pages/ and
+// the assets in assets/, so pages reach them through their Root, "../".
+var siteTemplates = template.Must(template.New("").Funcs(template.FuncMap{"pagePath": sitePagePath}).Parse(`
+{{define "head"}}<!DOCTYPE html>
+<html><head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title><link rel="stylesheet" href="{{.Root}}assets/style.css"></head><body>{{end}}
+
+{{define "page"}}{{template "head" .}}
+<header><a class="site" href="../index.html">{{.Site}}</a>
+<form action="../index.html"><input name="q" type="search" placeholder="Search"></form></header>
+<main><p class="source">Source: <a href="{{.URL}}">{{.URL}}</a></p>
+{{.Body}}</main></body></html>
+{{end}}
+
+{{define "index"}}{{template "head" .}}
+<header><a class="site" href="index.html">{{.Title}}</a>
+<input id="search" type="search" placeholder="Search" autofocus></header>
+<main><div id="results" hidden></div>
+<div id="listing">{{with .Description}}<p>{{.}}</p>{{end}}
+{{with .Start}}<p><a href="{{pagePath .URL}}">{{.Title}}</a></p>{{end}}
+{{range .Sections}}<h2>{{.Name}}</h2>
+<ul class="pages">{{range .Pages}}<li><a href="{{pagePath .URL}}">{{.Title}}</a>{{with .Summary}}<p class="summary">{{.}}</p>{{end}}</li>
+{{end}}</ul>
+{{end}}</div></main>
+<script src="assets/search-index.js"></script><script src="assets/search.js"></script>
+</body></html>
+{{end}}`))
+
+// siteSection is a section of the index page of a static site
+type siteSection struct {
+	Name  string
+	Pages []llmsPage
+}
+
+// siteSearchEntry is a page in the search index of a static site
+type siteSearchEntry struct {
+	Title string `json:"title"`
+	Path  string `json:"path"`
+	Text  string `json:"text"`
+}
+
+// sitePagePath returns where a page is stored in a static site export
+func sitePagePath(pageURL string) string {
+	return "pages/" + pageID(pageURL) + ".html"
+}
+
+// writeSiteExport writes a job's results as a zip archive of a static HTML mirror:
+// the pages rendered from their markdown, linking to each other, an index of them in
+// the sections of llms.txt, and a search running in the browser. It can be browsed
+// from disk.
+func writeSiteExport(w io.Writer, job Job, results map[string]*CrawledData) error {
+	archive := zip.NewWriter(w)
+	site := newLLMsSite(job, results)
+	linker := newPageLinker(results, func(pageURL string) string { return pageID(pageURL) + ".html" })
+	var searchIndex []siteSearchEntry
+	for _, pageURL := range sortedResultURLs(results) {
+		data := results[pageURL]
+		body, err := renderMarkdownHTML(linker.rewrite(pageURL, data.Markdown))
+		if err != nil {
+			return err
+		}
+		title := firstNonEmpty(data.Metadata["title"], data.Metadata["og:title"], pageURL)
+		if err := writeSiteFile(archive, sitePagePath(pageURL), "page", map[string]interface{}{
+			"Root":  "../",
+			"Site":  site.Title,
+			"Title": title,
+			"URL":   pageURL,
+			"Body":  template.HTML(body), // Sanitized by the renderer
+		}); err != nil {
+			return err
+		}
+
+		text := strings.Join(strings.Fields(renderMarkdownText(data.Markdown)), " ")
+		if len(text) > maxSiteSearchText {
+			end := maxSiteSearchText
+			for !utf8.RuneStart(text[end]) {
+				end--
+			}
+			text = text[:end]
+		}
+		searchIndex = append(searchIndex, siteSearchEntry{Title: title, Path: sitePagePath(pageURL), Text: text})
+	}
+
+	sections := make([]siteSection, 0, len(site.Sections))
+	for _, name := range site.sortedSections() {
+		sections = append(sections, siteSection{Name: name, Pages: site.Sections[name]})
+	}
+	if err := writeSiteFile(archive, "index.html", "index", map[string]interface{}{
+		"Root":        "",
+		"Title":       site.Title,
+		"Description": site.Description,
+		"Start":       site.Start,
+		"Sections":    sections,
+	}); err != nil {
+		return err
+	}
+
+	// The index is a script rather than JSON, as browsers don't let pages opened
+	// from disk fetch files
+	encoded, err := json.Marshal(searchIndex)
+	if err != nil {
+		return err
+	}
+	writer, err := archive.Create("assets/search-index.js")
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(writer, "window.searchIndex = "+string(encoded)+";\n"); err != nil {
+		return err
+	}
+	for _, name := range []string{"style.css", "search.js"} {
+		asset, err := siteAssets.ReadFile("site/" + name)
+		if err != nil {
+			return err
+		}
+		writer, err := archive.Create("assets/" + name)
+		if err != nil {
+			return err
+		}
+		if _, err := writer.Write(asset); err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+// writeSiteFile renders one of siteTemplates into the archive
+func writeSiteFile(archive *zip.Writer, name string, layout string, data map[string]interface{}) error {
+	writer, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	return siteTemplates.ExecuteTemplate(writer, layout, data)
+}
@@ -0,0 +1,73 @@
+// Searches the pages of a static site export in the browser, without a server:
+// the index is loaded by search-index.js as window.searchIndex.
+(function () {
+  'use strict';
+
+  const maxResults = 50;
+  const input = document.querySelector('#search');
+  const results = document.querySelector('#results');
+  const listing = document.querySelector('#listing');
+  const pages = (window.searchIndex || []).map((page) => Object.assign(page, {
+    lowerTitle: page.title.toLowerCase(),
+    lowerText: page.text.toLowerCase(),
+  }));
+
+  function escapeHTML(text) {
+    return text.replace(/[&<>"']/g, (c) => ({ '&': '&amp;', '<': '&lt;', '>': '&gt;', '"': '&quot;', "'": '&#39;' }[c]));
+  }
+
+  // snippet returns the text around the first match of a term, with the terms marked
+  function snippet(page, terms) {
+    let at = -1;
+    for (const term of terms) {
+      const i = page.lowerText.indexOf(term);
+      if (i >= 0 && (at < 0 || i < at)) {
+        at = i;
+      }
+    }
+    const start = Math.max(0, at - 80);
+    const end = start + 240;
+    let text = escapeHTML((start > 0 ? '…' : '') + page.text.slice(start, end) + (end < page.text.length ? '…' : ''));
+    for (const term of terms) {
+      text = text.replace(new RegExp(escapeHTML(term).replace(/[.*+?^${}()|[\]\\]/g, '\\$&'), 'gi'), (m) => `<mark>${m}</mark>`);
+    }
+    return text;
+  }
+
+  function search() {
+    const terms = input.value.toLowerCase().split(/\s+/).filter(Boolean);
+    listing.hidden = terms.length > 0;
+    results.hidden = terms.length === 0;
+    if (terms.length === 0) {
+      return;
+    }
+    const matches = [];
+    for (const page of pages) {
+      let score = 0;
+      for (const term of terms) {
+        const inTitle = page.lowerTitle.includes(term);
+        if (!inTitle && !page.lowerText.includes(term)) {
+          score = 0;
+          break;
+        }
+        score += inTitle ? 10 : 1;
+      }
+      if (score > 0) {
+        matches.push({ page, score });
+      }
+    }
+    matches.sort((a, b) => b.score - a.score || a.page.title.localeCompare(b.page.title));
+    if (matches.length === 0) {
+      results.innerHTML = '<p>No pages found.</p>';
+      return;
+    }
+    results.innerHTML = `<p>${matches.length} ${matches.length === 1 ? 'page' : 'pages'} found</p><ul class="pages">` +
+      matches.slice(0, maxResults).map(({ page }) =>
+        `<li><a href="${escapeHTML(page.path)}">${escapeHTML(page.title)}</a><p class="summary">${snippet(page, terms)}</p></li>`).join('') +
+      '</ul>';
+  }
+
+  input.addEventListener('input', search);
+  input.value = new URLSearchParams(location.search).get('q') || '';
+  search();
+})();
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	maxSitemapDocuments = 50    // Sitemap index files are followed up to this many documents
+	maxSitemapURLs      = 50000 // The sitemap protocol's own per-file limit
+)
+
+// SitemapURL is a single page entry of a sitemap
+type SitemapURL struct {
+	Loc        string `xml:"loc" json:"loc"`
+	LastMod    string `xml:"lastmod" json:"lastmod,omitempty"`
+	ChangeFreq string `xml:"changefreq" json:"changefreq,omitempty"`
+	Priority   string `xml:"priority" json:"priority,omitempty"`
+}
+
+// SitemapResult lists the sitemap documents that were read and the page URLs they contain
+type SitemapResult struct {
+	Sitemaps  []string     `json:"sitemaps"`
+	URLs      []SitemapURL `json:"urls"`
+	Truncated bool         `json:"truncated"`
+	Errors    []string     `json:"errors,omitempty"`
+}
+
+// sitemapDocument covers both <urlset> and <sitemapindex> documents
+type sitemapDocument struct {
+	XMLName  xml.Name
+	URLs     []SitemapURL `xml:"url"`
+	Sitemaps []struct {
+		Loc string `xml:"loc"`
+	} `xml:"sitemap"`
+}
+
+// fetchSitemaps collects page URLs from a site's sitemaps. siteURL may point at a
+// sitemap directly; otherwise sitemaps are discovered via robots.txt, falling back
+// to /sitemap.xml.
+func fetchSitemaps(siteURL string) (*SitemapResult, error) {
+	var queue []string
+	if isSitemapURL(siteURL) {
+		queue = append(queue, siteURL)
+	} else {
+		rules, err := fetchRobots(siteURL)
+		if err == nil {
+			queue = append(queue, rules.Sitemaps...)
+		}
+		if len(queue) == 0 {
+			queue = append(queue, resolveURL(siteURL, "/sitemap.xml"))
+		}
+	}
+
+	result := &SitemapResult{Sitemaps: []string{}, URLs: []SitemapURL{}}
+	seen := make(map[string]bool)
+	for len(queue) > 0 && len(result.Sitemaps) < maxSitemapDocuments {
+		sitemapURL := queue[0]
+		queue = queue[1:]
+		if seen[sitemapURL] {
+			continue
+		}
+		seen[sitemapURL] = true
+
+		doc, err := fetchSitemapDocument(sitemapURL)
+		if err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", sitemapURL, err))
+			continue
+		}
+		result.Sitemaps = append(result.Sitemaps, sitemapURL)
+		for _, child := range doc.Sitemaps {
+			queue = append(queue, strings.TrimSpace(child.Loc))
+		}
+		for _, entry := range doc.URLs {
+			if len(result.URLs) >= maxSitemapURLs {
+				result.Truncated = true
+				break
+			}
+			entry.Loc = strings.TrimSpace(entry.Loc)
+			result.URLs = append(result.URLs, entry)
+		}
+	}
+	if len(queue) > 0 {
+		result.Truncated = true
+	}
+	if len(result.Sitemaps) == 0 && len(result.Errors) > 0 {
+		return nil, fmt.Errorf("no sitemap could be read: %s", strings.Join(result.Errors, "; "))
+	}
+	return result, nil
+}
+
+// fetchSitemapDocument downloads and decodes a single (optionally gzipped) sitemap
+func fetchSitemapDocument(sitemapURL string) (*sitemapDocument, error) {
+	resp, err := inspectClient.Get(sitemapURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var body io.Reader = io.LimitReader(resp.Body, maxInspectBytes)
+	if strings.HasSuffix(resp.Request.URL.Path, ".gz") || resp.Header.Get("Content-Type") == "application/x-gzip" {
+		gzipReader, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, err
+		}
+		defer gzipReader.Close()
+		body = io.LimitReader(gzipReader, maxInspectBytes)
+	}
+
+	doc := &sitemapDocument{}
+	if err := xml.NewDecoder(body).Decode(doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// isSitemapURL reports whether a URL looks like a sitemap document rather than a site page
+func isSitemapURL(rawURL string) bool {
+	path := strings.ToLower(strings.SplitN(rawURL, "?", 2)[0])
+	return strings.HasSuffix(path, ".xml") || strings.HasSuffix(path, ".xml.gz")
+}
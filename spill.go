@@ -0,0 +1,87 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+)
+
+// defaultMemoryBudget is the size of the results a crawl keeps in memory before it
+// spills raw HTML to disk, when CrawlerConfig.MemoryBudget is 0
+const defaultMemoryBudget = 512 << 20
+
+// spillRoot holds a directory of spilled raw HTML per crawl
+var spillRoot = filepath.Join(os.TempDir(), "lexicrawler-spill")
+
+// resultBudget tracks the memory taken by the results of a crawl and spills the
+// kept raw HTML of pages stored beyond the budget to files. Even compressed, raw
+// HTML is usually most of a page's size and is only read again on request.
+type resultBudget struct {
+	limit int64
+	used  atomic.Int64
+	once  sync.Once
+	dir   string // Created with the first spilled page
+	err   error
+}
+
+// memoryBudget returns the configured budget or the default
+func (config CrawlerConfig) memoryBudget() int64 {
+	if config.MemoryBudget > 0 {
+		return config.MemoryBudget
+	}
+	return defaultMemoryBudget
+}
+
+// account adds a stored page to the memory used, spilling its raw HTML first when
+// the budget would be exceeded. Pages stay in memory if spilling fails.
+func (b *resultBudget) account(data *CrawledData) {
+	size := crawledDataSize(data)
+	if len(data.rawHTMLZstd) > 0 && b.used.Load()+size > b.limit {
+		if err := b.spill(data); err != nil {
+			log.Printf("Spilling the raw HTML of %s failed: %v", data.URL, err)
+		} else {
+			size = crawledDataSize(data)
+		}
+	}
+	b.used.Add(size)
+}
+
+// spill moves the compressed raw HTML of a page to a file
+func (b *resultBudget) spill(data *CrawledData) error {
+	b.once.Do(func() {
+		if b.err = os.MkdirAll(spillRoot, 0o755); b.err == nil {
+			b.dir, b.err = os.MkdirTemp(spillRoot, "crawl-")
+		}
+	})
+	if b.err != nil {
+		return b.err
+	}
+	file, err := os.CreateTemp(b.dir, "page-*.html.zst")
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data.rawHTMLZstd)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	data.rawHTMLFile = file.Name()
+	data.rawHTMLZstd = nil
+	return nil
+}
+
+// removeSpilled deletes the spilled raw HTML of results that are discarded, and
+// their crawl directories once empty
+func removeSpilled(results map[string]*CrawledData) {
+	for _, data := range results {
+		if data.rawHTMLFile != "" {
+			os.Remove(data.rawHTMLFile)
+			os.Remove(filepath.Dir(data.rawHTMLFile)) // Fails while other pages remain
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// wordsPerMinute is the average adult reading speed used for reading-time estimates
+const wordsPerMinute = 200
+
+var sentenceEndPattern = regexp.MustCompile(`[.!?]+(\s|$)`)
+
+// ContentStats holds simple size and composition statistics for a page
+type ContentStats struct {
+	Words              int `json:"words"`
+	Sentences          int `json:"sentences"`
+	ReadingTimeMinutes int `json:"reading_time_minutes"`
+	Links              int `json:"links"`
+	Images             int `json:"images"`
+	CodeBlocks         int `json:"code_blocks"`
+}
+
+// computeContentStats counts words, sentences, links, images and code blocks in the page body
+func computeContentStats(selection *goquery.Selection) ContentStats {
+	body := selection.Find("body")
+	if body.Length() == 0 {
+		body = selection
+	}
+	text := strings.TrimSpace(body.Text())
+
+	stats := ContentStats{
+		Words:      len(strings.Fields(text)),
+		Links:      body.Find("a[href]").Length(),
+		Images:     body.Find("img").Length(),
+		CodeBlocks: body.Find("pre").Length(),
+	}
+	if stats.Words > 0 {
+		stats.Sentences = len(sentenceEndPattern.FindAllStringIndex(text, -1))
+		if stats.Sentences == 0 {
+			stats.Sentences = 1 // Text without terminal punctuation is still one sentence
+		}
+		stats.ReadingTimeMinutes = int(math.Ceil(float64(stats.Words) / wordsPerMinute))
+	}
+	return stats
+}
+
+// addToMetadata records the stats in a page's string metadata map
+func (s ContentStats) addToMetadata(metadata map[string]string) {
+	metadata["word_count"] = strconv.Itoa(s.Words)
+	metadata["sentence_count"] = strconv.Itoa(s.Sentences)
+	metadata["reading_time_minutes"] = strconv.Itoa(s.ReadingTimeMinutes)
+	metadata["link_count"] = strconv.Itoa(s.Links)
+	metadata["image_count"] = strconv.Itoa(s.Images)
+	metadata["code_block_count"] = strconv.Itoa(s.CodeBlocks)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// defaultMarkdownHeader is the metadata preamble of a page's markdown when neither
+// the crawl nor a site profile sets one
+const defaultMarkdownHeader = `{{with .Metadata.title}}# {{escape .}}
+
+{{end}}{{with .Metadata.description}}> {{escape .}}
+
+{{end}}{{with .Metadata.keywords}}**Keywords:** {{escape .}}
+
+{{end}}{{with .Metadata.author}}**Author:** {{escape .}}
+
+{{end}}{{with .Metadata.canonical_url}}**Canonical URL:** {{.}}
+
+{{end}}---
+
+`
+
+// markdownFrame is what the header and footer templates of a page's markdown see
+type markdownFrame struct {
+	URL      string
+	Metadata map[string]string // Names with other characters than letters, digits and _ need index, e.g. {{index .Metadata "og:title"}}
+}
+
+// templateFuncs are the functions available in templates besides the builtins
+var templateFuncs = template.FuncMap{
+	"escape": escapeMarkdown, // Markdown-escapes extracted text
+	"trim":   strings.TrimSpace,
+	"lower":  strings.ToLower,
+	"upper":  strings.ToUpper,
+	"join":   func(separator string, values []string) string { return strings.Join(values, separator) },
+	"json": func(value interface{}) (string, error) { // Encodes a value as JSON
+		encoded, err := json.Marshal(value)
+		return string(encoded), err
+	},
+	"xml": func(text string) (string, error) { // Escapes text for XML
+		var escaped strings.Builder
+		err := xml.EscapeText(&escaped, []byte(text))
+		return escaped.String(), err
+	},
+	"truncate": func(length int, text string) string { // Shortens text to a number of characters
+		if runes := []rune(text); len(runes) > length {
+			return strings.TrimSpace(string(runes[:length])) + "…"
+		}
+		return text
+	},
+}
+
+// maxParsedTemplates bounds parsedTemplates, as sources come with requests
+const maxParsedTemplates = 256
+
+// parsedTemplates caches templates by source, as every page renders the crawl's
+var parsedTemplates = struct {
+	sync.Mutex
+	bySource map[string]*template.Template
+}{bySource: make(map[string]*template.Template)}
+
+// parseTemplate parses a template source once
+func parseTemplate(source string) (*template.Template, error) {
+	parsedTemplates.Lock()
+	defer parsedTemplates.Unlock()
+	if tmpl, ok := parsedTemplates.bySource[source]; ok {
+		return tmpl, nil
+	}
+	tmpl, err := template.New("").Funcs(templateFuncs).Option("missingkey=zero").Parse(source) // Missing metadata reads as ""
+	if err != nil {
+		return nil, err
+	}
+	if len(parsedTemplates.bySource) >= maxParsedTemplates {
+		clear(parsedTemplates.bySource) // Running crawls parse theirs again
+	}
+	parsedTemplates.bySource[source] = tmpl
+	return tmpl, nil
+}
+
+// validateMarkdownTemplates checks a header and a footer template
+func validateMarkdownTemplates(header string, footer string) error {
+	if err := validateMarkdownTemplate("markdown_header", header); err != nil {
+		return err
+	}
+	return validateMarkdownTemplate("markdown_footer", footer)
+}
+
+// validateMarkdownTemplate checks that a header or footer template parses and renders
+// a page without metadata
+func validateMarkdownTemplate(name string, source string) error {
+	if source == "" {
+		return nil
+	}
+	tmpl, err := parseTemplate(source)
+	if err == nil {
+		err = tmpl.Execute(&strings.Builder{}, markdownFrame{Metadata: map[string]string{}})
+	}
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return nil
+}
+
+// renderMarkdownFrame writes a header or footer template of a page; a template that
+// fails on the page is left out
+func renderMarkdownFrame(out *strings.Builder, source string, frame markdownFrame) {
+	if source == "" {
+		return
+	}
+	tmpl, err := parseTemplate(source)
+	var rendered strings.Builder
+	if err == nil {
+		err = tmpl.Execute(&rendered, frame)
+	}
+	if err != nil {
+		log.Printf("Error rendering the markdown template of %s: %v", frame.URL, err)
+		return
+	}
+	out.WriteString(rendered.String())
+}
+
+// markdownHeader returns the header template of a page: the matching site profile's,
+// else the crawl's, else defaultMarkdownHeader
+func (config CrawlerConfig) markdownHeader(pageURL string) string {
+	if profile := config.siteProfile(pageURL); profile != nil && profile.MarkdownHeader != "" {
+		return profile.MarkdownHeader
+	}
+	if config.MarkdownHeader != "" {
+		return config.MarkdownHeader
+	}
+	return defaultMarkdownHeader
+}
+
+// markdownFooter returns the footer template of a page: the matching site profile's,
+// else the crawl's
+func (config CrawlerConfig) markdownFooter(pageURL string) string {
+	if profile := config.siteProfile(pageURL); profile != nil && profile.MarkdownFooter != "" {
+		return profile.MarkdownFooter
+	}
+	return config.MarkdownFooter
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// defaultTenantID owns every job when no tenants are configured (single-tenant mode)
+const defaultTenantID = "default"
+
+// TenantQuotas limits what a tenant may use; zero means unlimited
+type TenantQuotas struct {
+	MaxConcurrentJobs int   `json:"max_concurrent_jobs"`
+	MaxPages          int   `json:"max_pages"`         // Total pages across all of the tenant's jobs
+	MaxStorageBytes   int64 `json:"max_storage_bytes"` // Total size of stored results
+}
+
+// TenantUsage is the running resource accounting of a tenant
+type TenantUsage struct {
+	JobsSubmitted int   `json:"jobs_submitted"`
+	JobsRunning   int   `json:"jobs_running"`
+	PagesCrawled  int   `json:"pages_crawled"`
+	StorageBytes  int64 `json:"storage_bytes"`
+	// Summed over the runs of all jobs, see ResourceUsage
+	CPUSeconds      float64 `json:"cpu_seconds"`
+	WallSeconds     float64 `json:"wall_seconds"`
+	BytesDownloaded int64   `json:"bytes_downloaded"`
+	ChromeMinutes   float64 `json:"chrome_minutes"`
+}
+
+// Tenant is a team sharing the deployment, identified by its API keys
+type Tenant struct {
+	ID      string       `json:"id"`
+	Name    string       `json:"name"`
+	APIKeys []string     `json:"api_keys"`
+	Quotas  TenantQuotas `json:"quotas"`
+
+	usage TenantUsage
+	mutex sync.Mutex
+}
+
+// TenantRegistry resolves API keys to tenants
+type TenantRegistry struct {
+	tenants map[string]*Tenant // By ID
+	byKey   map[string]*Tenant
+}
+
+// LoadTenantRegistry reads tenants from the JSON file named by LEXICRAWLER_TENANTS_FILE.
+// Without that variable the API runs in single-tenant mode with no authentication.
+func LoadTenantRegistry() (*TenantRegistry, error) {
+	registry := &TenantRegistry{tenants: make(map[string]*Tenant), byKey: make(map[string]*Tenant)}
+
+	path := os.Getenv("LEXICRAWLER_TENANTS_FILE")
+	if path == "" {
+		registry.tenants[defaultTenantID] = &Tenant{ID: defaultTenantID, Name: "Default"}
+		return registry, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Tenants []*Tenant `json:"tenants"`
+	}
+	if err := json.Unmarshal(content, &file); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	for _, tenant := range file.Tenants {
+		if tenant.ID == "" {
+			return nil, fmt.Errorf("tenant without id in %s", path)
+		}
+		if _, exists := registry.tenants[tenant.ID]; exists {
+			return nil, fmt.Errorf("duplicate tenant id %q in %s", tenant.ID, path)
+		}
+		registry.tenants[tenant.ID] = tenant
+		for _, key := range tenant.APIKeys {
+			if _, exists := registry.byKey[key]; exists {
+				return nil, fmt.Errorf("API key of tenant %q is already in use", tenant.ID)
+			}
+			registry.byKey[key] = tenant
+		}
+	}
+	return registry, nil
+}
+
+// MultiTenant reports whether API keys are required
+func (r *TenantRegistry) MultiTenant() bool {
+	return len(r.byKey) > 0
+}
+
+// Middleware authenticates requests by X-API-Key header (or Bearer token) and stores
+// the tenant in the request locals
+func (r *TenantRegistry) Middleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !r.MultiTenant() {
+			c.Locals("tenant", r.tenants[defaultTenantID])
+			return c.Next()
+		}
+
+		key := c.Get("X-API-Key")
+		if key == "" {
+			key = strings.TrimPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+		}
+		tenant, ok := r.byKey[key]
+		if key == "" || !ok {
+			return c.Status(fiber.StatusUnauthorized).SendString("Missing or invalid API key")
+		}
+		c.Locals("tenant", tenant)
+		return c.Next()
+	}
+}
+
+// tenantFromCtx returns the tenant authenticated by the middleware
+func tenantFromCtx(c *fiber.Ctx) *Tenant {
+	tenant, _ := c.Locals("tenant").(*Tenant)
+	return tenant
+}
+
+// Usage returns a snapshot of the tenant's usage
+func (t *Tenant) Usage() TenantUsage {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.usage
+}
+
+// checkSubmitQuota reports why a new job can't be accepted, if it can't
+func (t *Tenant) checkSubmitQuota() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.Quotas.MaxPages > 0 && t.usage.PagesCrawled >= t.Quotas.MaxPages {
+		return fmt.Errorf("page quota of %d pages exhausted", t.Quotas.MaxPages)
+	}
+	if t.Quotas.MaxStorageBytes > 0 && t.usage.StorageBytes >= t.Quotas.MaxStorageBytes {
+		return fmt.Errorf("storage quota of %d bytes exhausted", t.Quotas.MaxStorageBytes)
+	}
+	return nil
+}
+
+// remainingPages returns how many more pages the tenant may crawl, or 0 for unlimited
+func (t *Tenant) remainingPages() int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.Quotas.MaxPages == 0 {
+		return 0
+	}
+	return max(t.Quotas.MaxPages-t.usage.PagesCrawled, 0)
+}
+
+// tryStartJob takes a concurrency slot, returning false when all slots are in use
+func (t *Tenant) tryStartJob() bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.Quotas.MaxConcurrentJobs > 0 && t.usage.JobsRunning >= t.Quotas.MaxConcurrentJobs {
+		return false
+	}
+	t.usage.JobsRunning++
+	return true
+}
+
+// recordJobSubmitted counts an accepted job
+func (t *Tenant) recordJobSubmitted() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.usage.JobsSubmitted++
+}
+
+// recordJobFinished releases the job's concurrency slot and accounts its results
+func (t *Tenant) recordJobFinished(pages int, storageBytes int64) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.usage.JobsRunning--
+	t.usage.PagesCrawled += pages
+	t.usage.StorageBytes += storageBytes
+}
+
+// recordUsage accounts the resources of a job's run, or of reprocessing it
+func (t *Tenant) recordUsage(usage ResourceUsage) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.usage.CPUSeconds += usage.CPUSeconds
+	t.usage.WallSeconds += usage.WallSeconds
+	t.usage.BytesDownloaded += usage.BytesDownloaded
+	t.usage.ChromeMinutes += usage.ChromeMinutes
+}
+
+// recordJobPaused releases the concurrency slot of a preempted job
+func (t *Tenant) recordJobPaused() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.usage.JobsRunning--
+}
+
+// adjustStorage accounts a change in the storage of an existing job
+func (t *Tenant) adjustStorage(deltaBytes int64) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.usage.StorageBytes = max(t.usage.StorageBytes+deltaBytes, 0)
+}
+
+// releaseStorage gives back the storage of a deleted job
+func (t *Tenant) releaseStorage(storageBytes int64) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.usage.StorageBytes = max(t.usage.StorageBytes-storageBytes, 0)
+}
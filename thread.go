@@ -0,0 +1,381 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// quotePlaceholder marks where a quote goes in the markdown of a post while the
+// rest of it is converted
+const quotePlaceholder = "LEXICRAWLERQUOTE"
+
+// Thread is a forum topic or Q&A page as its posts, in page order, with who wrote
+// and who quoted what
+type Thread struct {
+	Platform string       `json:"platform"` // discourse, phpbb, xenforo, stackexchange or schema.org
+	Title    string       `json:"title,omitempty"`
+	URL      string       `json:"url"`
+	Posts    []ThreadPost `json:"posts"`
+}
+
+// ThreadPost is a post of a thread
+type ThreadPost struct {
+	ID       string        `json:"id,omitempty"`
+	Kind     string        `json:"kind,omitempty"` // "question" or "answer" on Q&A sites
+	Author   string        `json:"author,omitempty"`
+	Date     string        `json:"date,omitempty"`
+	Text     string        `json:"text"`               // Markdown, quotes as blockquotes naming their author
+	Quotes   []ThreadQuote `json:"quotes,omitempty"`   // In the order they appear, nested quotes after the quote holding them
+	ReplyTo  string        `json:"reply_to,omitempty"` // ID of the post answered, on Q&A sites
+	Votes    *int          `json:"votes,omitempty"`
+	Accepted bool          `json:"accepted,omitempty"`
+}
+
+// ThreadQuote is a quote in a post
+type ThreadQuote struct {
+	Author string `json:"author,omitempty"`
+	PostID string `json:"post_id,omitempty"` // Of the post quoted, where the forum links it
+	Depth  int    `json:"depth"`             // 0 when quoted by the post, 1 when quoted by that quote, ...
+	Text   string `json:"text"`              // Plain text, without nested quotes
+}
+
+// threadMarkup is how a forum platform marks up posts; each field lists selectors
+// tried in order within a post
+type threadMarkup struct {
+	platform string
+	posts    string
+	body     string
+	author   string
+	date     string
+}
+
+// threadMarkups are the forum platforms recognized by their markup
+var threadMarkups = []threadMarkup{
+	{
+		platform: "stackexchange",
+		posts:    "#question, div.answer",
+		body:     ".js-post-body, .s-prose, .post-text",
+		author:   ".post-signature.owner .user-details [itemprop=name], .post-signature.owner .user-details a, .post-signature .user-details a",
+		date:     ".post-signature.owner .relativetime, .post-signature .relativetime, time[itemprop=dateCreated]",
+	},
+	{
+		platform: "discourse",
+		posts:    ".crawler-post, article.topic-post",
+		body:     "[itemprop=text], .cooked",
+		author:   ".creator [itemprop=name], .creator, .username",
+		date:     "time[itemprop=datePublished], .post-date span[title], .post-date",
+	},
+	{
+		platform: "xenforo",
+		posts:    "article.message--post",
+		body:     ".message-body .bbWrapper",
+		author:   ".message-name .username, .message-name",
+		date:     ".message-attribution-main time, time.u-dt",
+	},
+	{
+		platform: "phpbb",
+		posts:    "div.post",
+		body:     ".postbody .content",
+		author:   ".author .username, .author .username-coloured, .postprofile .username, .postprofile .username-coloured",
+		date:     ".author time",
+	},
+}
+
+// quoteSelector matches the quotes of the platforms: Discourse asides, XenForo
+// quote blocks and blockquotes
+const quoteSelector = "aside.quote, blockquote"
+
+// extractThread recognizes forum topics and Q&A pages by the markup of their
+// platform, or by a schema.org DiscussionForumPosting or QAPage
+func extractThread(page *extractorPage) (interface{}, bool) {
+	for _, markup := range threadMarkups {
+		if thread := markupThread(page, markup); thread != nil {
+			return *thread, true
+		}
+	}
+	if thread := schemaThread(page); thread != nil {
+		return *thread, true
+	}
+	return nil, false
+}
+
+// markupThread reads the posts of a page marked up by a platform; nil if it has none
+func markupThread(page *extractorPage, markup threadMarkup) *Thread {
+	thread := &Thread{Platform: markup.platform, URL: page.URL, Title: threadTitle(page)}
+	var questionID string
+	page.Doc.Find(markup.posts).Each(func(i int, element *goquery.Selection) {
+		if element.ParentsFiltered(markup.posts).Length() > 0 {
+			return // Posts don't nest, the match is part of another post
+		}
+		body := firstWithin(element, markup.body)
+		if body.Length() == 0 {
+			return
+		}
+		post := ThreadPost{
+			ID:     firstNonEmpty(element.AttrOr("data-answerid", ""), element.AttrOr("data-questionid", ""), element.AttrOr("id", "")),
+			Author: strings.Join(strings.Fields(firstWithin(element, markup.author).Text()), " "),
+		}
+		if date := firstWithin(element, markup.date); date.Length() > 0 {
+			post.Date = firstNonEmpty(date.AttrOr("datetime", ""), date.AttrOr("title", ""), strings.TrimSpace(date.Text()))
+		}
+		if markup.platform == "stackexchange" {
+			post.Kind = "answer"
+			if element.Is("#question") {
+				post.Kind, questionID = "question", post.ID
+			} else {
+				post.ReplyTo = questionID
+			}
+			if votes, err := strconv.Atoi(strings.TrimSpace(firstNonEmpty(firstWithin(element, ".js-vote-count").AttrOr("data-value", ""), firstWithin(element, ".js-vote-count, .vote-count-post").Text()))); err == nil {
+				post.Votes = &votes
+			}
+			post.Accepted = element.HasClass("accepted-answer") || element.AttrOr("itemprop", "") == "acceptedAnswer"
+		}
+		post.Text = postMarkdown(body, page.URL, 0, &post.Quotes)
+		if post.Text != "" {
+			thread.Posts = append(thread.Posts, post)
+		}
+	})
+	if len(thread.Posts) == 0 {
+		return nil
+	}
+	return thread
+}
+
+// schemaThread reads a DiscussionForumPosting with its comments, or the Question of a
+// QAPage with its answers; nil if the page has neither
+func schemaThread(page *extractorPage) *Thread {
+	schemaPost := func(item schemaObject, kind string) ThreadPost {
+		return ThreadPost{
+			ID:     item.str("@id"),
+			Kind:   kind,
+			Author: item.str("author"),
+			Date:   firstNonEmpty(item.str("datePublished"), item.str("dateCreated")),
+			Text:   escapeMarkdown(schemaPlainText(firstNonNil(item["text"], item["articleBody"]))),
+		}
+	}
+	thread := &Thread{Platform: "schema.org", URL: page.URL, Title: threadTitle(page)}
+	if question := page.first("Question"); question != nil {
+		thread.Title = firstNonEmpty(question.str("name"), thread.Title)
+		thread.Posts = append(thread.Posts, schemaPost(question, "question"))
+		for _, property := range []string{"acceptedAnswer", "suggestedAnswer"} {
+			for _, item := range question.objects(property) {
+				answer := schemaPost(item, "answer")
+				answer.ReplyTo, answer.Accepted = thread.Posts[0].ID, property == "acceptedAnswer"
+				if votes, ok := schemaNumber(item["upvoteCount"]); ok {
+					count := int(votes)
+					answer.Votes = &count
+				}
+				thread.Posts = append(thread.Posts, answer)
+			}
+		}
+	} else if posting := page.first("DiscussionForumPosting"); posting != nil {
+		thread.Title = firstNonEmpty(posting.str("headline"), thread.Title)
+		thread.Posts = append(thread.Posts, schemaPost(posting, ""))
+		for _, item := range posting.objects("comment") {
+			thread.Posts = append(thread.Posts, schemaPost(item, ""))
+		}
+	}
+	var posts []ThreadPost
+	for _, post := range thread.Posts {
+		if post.Text != "" {
+			posts = append(posts, post)
+		}
+	}
+	if len(posts) == 0 {
+		return nil
+	}
+	thread.Posts = posts
+	return thread
+}
+
+// threadTitle returns the title of a thread page: its first heading, else its og:title
+func threadTitle(page *extractorPage) string {
+	if heading := strings.Join(strings.Fields(page.Doc.Find("h1").First().Text()), " "); heading != "" {
+		return heading
+	}
+	return page.Meta["og:title"]
+}
+
+// firstWithin returns the first element within a post matching the first of the
+// comma-separated selectors that matches any
+func firstWithin(element *goquery.Selection, selectors string) *goquery.Selection {
+	for _, selector := range strings.Split(selectors, ",") {
+		if match := element.Find(strings.TrimSpace(selector)).First(); match.Length() > 0 {
+			return match
+		}
+	}
+	return element.Slice(0, 0)
+}
+
+// firstNonNil returns the first value that isn't nil
+func firstNonNil(values ...interface{}) interface{} {
+	for _, value := range values {
+		if value != nil {
+			return value
+		}
+	}
+	return nil
+}
+
+// postMarkdown converts the body of a post to markdown, rendering its quotes as
+// blockquotes headed by their author and recording them in quotes
+func postMarkdown(body *goquery.Selection, pageURL string, depth int, quotes *[]ThreadQuote) string {
+	body = body.Clone()
+	var rendered []string
+	topQuotes := body.Find(quoteSelector).FilterFunction(func(_ int, quote *goquery.Selection) bool {
+		return quote.ParentsUntilSelection(body).Filter(quoteSelector).Length() == 0
+	})
+	topQuotes.Each(func(i int, quote *goquery.Selection) {
+		author, postID := quoteSource(quote)
+		content := quote
+		if quote.Is("aside") {
+			content = quote.Find("blockquote").First()
+		}
+		content = content.Clone()
+		content.Find("cite, .title, .bbCodeBlock-title").FilterFunction(func(_ int, cite *goquery.Selection) bool {
+			return cite.ParentsUntilSelection(content).Filter(quoteSelector).Length() == 0 // Nested quotes keep theirs
+		}).Remove()
+		plain := content.Clone()
+		plain.Find(quoteSelector).Remove()
+
+		record := len(*quotes)
+		*quotes = append(*quotes, ThreadQuote{Author: author, PostID: postID, Depth: depth, Text: strings.Join(strings.Fields(plain.Text()), " ")})
+		text := postMarkdown(content.Contents(), pageURL, depth+1, quotes)
+		if (*quotes)[record].Text == "" && text == "" {
+			*quotes = append((*quotes)[:record], (*quotes)[record+1:]...)
+			quote.Remove()
+			return
+		}
+
+		var markdown strings.Builder
+		if author != "" {
+			markdown.WriteString("> **" + escapeMarkdown(author) + "** wrote:\n>\n")
+		}
+		for _, line := range strings.Split(text, "\n") {
+			markdown.WriteString(strings.TrimRight("> "+line, " ") + "\n")
+		}
+		quote.ReplaceWithHtml(fmt.Sprintf("<p>%s%d</p>", quotePlaceholder, len(rendered)))
+		rendered = append(rendered, strings.TrimRight(markdown.String(), "\n"))
+	})
+
+	for _, node := range body.Nodes {
+		wrapLooseText(node)
+	}
+	var markdown strings.Builder
+	writeMarkdownBody(body, pageURL, 0, "", &markdown)
+	text := strings.TrimSpace(markdown.String())
+	for i := len(rendered) - 1; i >= 0; i-- { // Backwards, so QUOTE1 isn't replaced within QUOTE10
+		text = strings.Replace(text, fmt.Sprintf("%s%d", quotePlaceholder, i), rendered[i], 1)
+	}
+	return text
+}
+
+// quoteSource returns who a quote is by and the post it quotes, from the attributes
+// Discourse and XenForo set or the "... wrote:" citation of phpBB
+func quoteSource(quote *goquery.Selection) (string, string) {
+	author := firstNonEmpty(quote.AttrOr("data-username", ""), quote.AttrOr("data-quote", ""))
+	postID := firstNonEmpty(quote.AttrOr("data-post", ""), strings.TrimPrefix(quote.AttrOr("data-source", ""), "post: "))
+	if author == "" {
+		cite := quote.Find("cite").First().Clone()
+		if jump := cite.Find("a[data-post-id]"); jump.Length() > 0 { // phpBB links the quoted post with an arrow
+			postID = firstNonEmpty(postID, jump.AttrOr("data-post-id", ""))
+			jump.Remove()
+		}
+		cite.Find(".responsive-hide").Remove() // The date of the quoted post
+		author = strings.TrimSpace(cite.Text())
+		for _, suffix := range []string{"wrote:", "said:", "wrote", "said", ":"} {
+			author = strings.TrimSpace(strings.TrimSuffix(author, suffix))
+		}
+	}
+	return strings.Join(strings.Fields(author), " "), postID
+}
+
+// wrapLooseText wraps runs of text and inline elements directly inside containers in
+// paragraphs, since only block elements are converted to markdown. Forum posts are
+// often text broken by <br> rather than paragraphs.
+func wrapLooseText(node *html.Node) {
+	if node.Type != html.ElementNode && node.Type != html.DocumentNode {
+		return
+	}
+	switch node.Data {
+	case "p", "pre", "table", "ul", "ol", "h1", "h2", "h3", "h4", "h5", "h6":
+		return // Converted with their inline content
+	}
+	var run []*html.Node
+	flush := func(before *html.Node) {
+		hasText := false
+		for _, inline := range run {
+			if strings.TrimSpace(nodeText(inline)) != "" || (inline.Type == html.ElementNode && inline.Data == "img") {
+				hasText = true
+			}
+		}
+		if hasText {
+			paragraph := &html.Node{Type: html.ElementNode, Data: "p", DataAtom: atom.P}
+			node.InsertBefore(paragraph, before)
+			for _, inline := range run {
+				node.RemoveChild(inline)
+				paragraph.AppendChild(inline)
+			}
+		}
+		run = nil
+	}
+	for child := node.FirstChild; child != nil; {
+		next := child.NextSibling
+		if child.Type == html.TextNode || (child.Type == html.ElementNode && isInlineElement(child.Data)) {
+			run = append(run, child)
+		} else {
+			flush(child)
+			wrapLooseText(child)
+		}
+		child = next
+	}
+	flush(nil)
+}
+
+// isInlineElement reports whether an element is part of a paragraph's text
+func isInlineElement(tag string) bool {
+	switch tag {
+	case "a", "abbr", "b", "br", "cite", "code", "em", "i", "kbd", "mark", "q", "s", "samp", "small", "span", "strong", "sub", "sup", "time", "u", "var", "del", "ins":
+		return true
+	}
+	return false
+}
+
+// markdownBody renders the thread as the page markdown: the title, then a section
+// per post headed by its author and date
+func (thread Thread) markdownBody() string {
+	var markdown strings.Builder
+	if thread.Title != "" {
+		markdown.WriteString("# " + escapeMarkdown(thread.Title) + "\n\n")
+	}
+	for i, post := range thread.Posts {
+		heading := firstNonEmpty(post.Author, "Anonymous")
+		if post.Kind != "" {
+			heading = strings.ToUpper(post.Kind[:1]) + post.Kind[1:] + " by " + heading
+		}
+		markdown.WriteString("## " + escapeMarkdown(heading) + "\n\n")
+		var details []string
+		if post.Date != "" {
+			details = append(details, escapeMarkdown(post.Date))
+		}
+		if post.Votes != nil {
+			details = append(details, fmt.Sprintf("%d votes", *post.Votes))
+		}
+		if post.Accepted {
+			details = append(details, "accepted answer")
+		}
+		if len(details) > 0 {
+			markdown.WriteString("*" + strings.Join(details, " · ") + "*\n\n")
+		}
+		markdown.WriteString(post.Text + "\n\n")
+		if i < len(thread.Posts)-1 {
+			markdown.WriteString("---\n\n")
+		}
+	}
+	return markdown.String()
+}
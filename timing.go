@@ -0,0 +1,129 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// PageTimings records where the time processing a page went, in milliseconds. Fetch
+// is the download of static pages, Render the headless load of JS pages including
+// the JS fallback's, Readability and Markdown the extraction's main steps (of both
+// extractions when the fallback ran), Enrich the optional steps such as translation
+// and screenshots, Sink storing the page and writing it to the job's sinks.
+type PageTimings struct {
+	FetchMs       float64 `json:"fetch_ms,omitempty"`
+	RenderMs      float64 `json:"render_ms,omitempty"`
+	ReadabilityMs float64 `json:"readability_ms,omitempty"`
+	MarkdownMs    float64 `json:"markdown_ms,omitempty"`
+	EnrichMs      float64 `json:"enrich_ms,omitempty"`
+	SinkMs        float64 `json:"sink_ms,omitempty"`
+}
+
+// Phases of PageTimings, in pipeline order
+const (
+	phaseFetch       = "fetch"
+	phaseRender      = "render"
+	phaseReadability = "readability"
+	phaseMarkdown    = "markdown"
+	phaseEnrich      = "enrich"
+	phaseSink        = "sink"
+)
+
+// timingPhases lists the phases in pipeline order
+var timingPhases = []string{phaseFetch, phaseRender, phaseReadability, phaseMarkdown, phaseEnrich, phaseSink}
+
+// phase returns the time of a phase
+func (t PageTimings) phase(name string) float64 {
+	switch name {
+	case phaseFetch:
+		return t.FetchMs
+	case phaseRender:
+		return t.RenderMs
+	case phaseReadability:
+		return t.ReadabilityMs
+	case phaseMarkdown:
+		return t.MarkdownMs
+	case phaseEnrich:
+		return t.EnrichMs
+	case phaseSink:
+		return t.SinkMs
+	}
+	return 0
+}
+
+// add adds the times of another run of the phases, e.g. of a second extraction
+func (t *PageTimings) add(other PageTimings) {
+	t.FetchMs += other.FetchMs
+	t.RenderMs += other.RenderMs
+	t.ReadabilityMs += other.ReadabilityMs
+	t.MarkdownMs += other.MarkdownMs
+	t.EnrichMs += other.EnrichMs
+	t.SinkMs += other.SinkMs
+}
+
+// recordPhase adds the time since start to a phase of PageTimings, deferred as in
+// defer recordPhase(&timings.EnrichMs, time.Now())
+func recordPhase(ms *float64, start time.Time) {
+	*ms += milliseconds(time.Since(start))
+}
+
+// milliseconds returns a duration in milliseconds, to the microsecond
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// PhaseTiming aggregates the time of a phase over the pages of a crawl
+type PhaseTiming struct {
+	Phase   string  `json:"phase"`
+	Pages   int     `json:"pages"` // Pages that went through the phase
+	TotalMs float64 `json:"total_ms"`
+	Share   float64 `json:"share"` // Of the time of all phases
+	P50Ms   float64 `json:"p50_ms"`
+	P90Ms   float64 `json:"p90_ms"`
+	P99Ms   float64 `json:"p99_ms"`
+	MaxMs   float64 `json:"max_ms"`
+}
+
+// buildTimingReport aggregates the timings of the pages of a crawl by phase, leaving
+// out phases no page went through; nil when no page has timings, e.g. pages of
+// archives written before they were recorded
+func buildTimingReport(crawledDataMap map[string]*CrawledData) []PhaseTiming {
+	var report []PhaseTiming
+	var total float64
+	for _, phase := range timingPhases {
+		var times []float64
+		summary := PhaseTiming{Phase: phase}
+		for _, data := range crawledDataMap {
+			if took := data.Diagnostics.Timings.phase(phase); took > 0 {
+				times = append(times, took)
+				summary.TotalMs += took
+			}
+		}
+		if len(times) == 0 {
+			continue
+		}
+		sort.Float64s(times)
+		summary.Pages = len(times)
+		summary.P50Ms, summary.P90Ms, summary.P99Ms = percentile(times, 50), percentile(times, 90), percentile(times, 99)
+		summary.MaxMs = times[len(times)-1]
+		summary.TotalMs = roundMs(summary.TotalMs)
+		total += summary.TotalMs
+		report = append(report, summary)
+	}
+	for i := range report {
+		report[i].Share = math.Round(report[i].TotalMs/total*1000) / 1000
+	}
+	return report
+}
+
+// percentile returns the nearest-rank percentile of sorted values
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[max(rank, 1)-1]
+}
+
+// roundMs rounds a sum of milliseconds to the microsecond
+func roundMs(ms float64) float64 {
+	return math.Round(ms*1000) / 1000
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Topic classifiers of TopicConfig.Classifier
+const (
+	classifyKeywords = "keywords" // Counts the keywords of each topic on the page
+	classifyLLM      = "llm"      // Zero-shot, by an OpenAI-compatible chat completions API
+)
+
+// Defaults and limits of topic classification
+const (
+	defaultMaxTopics  = 3
+	defaultMinScore   = 0.2
+	minKeywordHits    = 2 // Keyword matches a page needs for a topic
+	maxTaxonomyTopics = 200
+	maxTopicLLMText   = 12000 // Characters of a page sent to the API
+)
+
+// TopicConfig classifies pages into a taxonomy of topics, tagging the pages and their
+// chunks
+type TopicConfig struct {
+	Classifier string  `json:"classifier"` // "keywords" (default) or "llm" (needs LEXICRAWLER_TOPICS_URL)
+	Taxonomy   []Topic `json:"taxonomy"`
+	MaxTopics  int     `json:"max_topics"` // Tags per page, default 3
+	MinScore   float64 `json:"min_score"`  // Score (0 to 1) a topic needs, default 0.2
+}
+
+// Topic is a topic of a taxonomy. Its name counts as one of its keywords.
+type Topic struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"` // What belongs to the topic, for the llm classifier
+	Keywords    []string `json:"keywords,omitempty"`    // Words or phrases, matched case-insensitively
+}
+
+// PageTopics are the topics a page was tagged with, the best first
+type PageTopics struct {
+	Classifier string     `json:"classifier"`
+	Topics     []TopicTag `json:"topics"`
+}
+
+// TopicTag is a topic of a page with its score: the topic's share of the keyword
+// matches of the page, or the confidence of the model
+type TopicTag struct {
+	Topic string   `json:"topic"`
+	Score float64  `json:"score"`
+	Spans [][2]int `json:"spans,omitempty"` // Character offsets of its keywords in the page markdown
+}
+
+// validate checks the taxonomy and the classifier, which for llm needs its API
+func (config *TopicConfig) validate() error {
+	switch config.Classifier {
+	case "", classifyKeywords:
+	case classifyLLM:
+		if topicModelFromEnv() == nil {
+			return errors.New("the llm topic classifier requires LEXICRAWLER_TOPICS_URL")
+		}
+	default:
+		return fmt.Errorf("unknown topic classifier %q (want keywords or llm)", config.Classifier)
+	}
+	if len(config.Taxonomy) == 0 || len(config.Taxonomy) > maxTaxonomyTopics {
+		return fmt.Errorf("topics need a taxonomy of 1 to %d topics", maxTaxonomyTopics)
+	}
+	names := make(map[string]bool)
+	for _, topic := range config.Taxonomy {
+		if strings.TrimSpace(topic.Name) == "" || names[topic.Name] {
+			return errors.New("topic names must be non-empty and distinct")
+		}
+		names[topic.Name] = true
+	}
+	if config.MaxTopics < 0 || config.MinScore < 0 || config.MinScore > 1 {
+		return errors.New("max_topics must not be negative and min_score must be between 0 and 1")
+	}
+	return nil
+}
+
+// classifier returns the configured classifier, keywords by default
+func (config *TopicConfig) classifier() string {
+	if config.Classifier == "" {
+		return classifyKeywords
+	}
+	return config.Classifier
+}
+
+// maxTopics returns the number of tags per page
+func (config *TopicConfig) maxTopics() int {
+	if config.MaxTopics == 0 {
+		return defaultMaxTopics
+	}
+	return config.MaxTopics
+}
+
+// minScore returns the score a topic needs
+func (config *TopicConfig) minScore() float64 {
+	if config.MinScore == 0 {
+		return defaultMinScore
+	}
+	return config.MinScore
+}
+
+// classifyTopics tags a page with the topics of the crawl's taxonomy it is about into
+// the "topics" structured data
+func (c *Crawler) classifyTopics(data *CrawledData) {
+	config := c.Config.Topics
+	if data.Markdown == "" {
+		return
+	}
+	var scores map[string]float64
+	var err error
+	if config.classifier() == classifyLLM {
+		scores, err = topicModelFromEnv().classify(data.Markdown, config.Taxonomy)
+	} else {
+		scores = keywordScores(data.Markdown, config.Taxonomy)
+	}
+	if err != nil {
+		fmt.Printf("Topic classification failed for %s: %v\n", data.URL, err)
+		return
+	}
+
+	var tags []TopicTag
+	for _, topic := range config.Taxonomy {
+		if score := scores[topic.Name]; score >= config.minScore() {
+			tags = append(tags, TopicTag{Topic: topic.Name, Score: score})
+		}
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].Score > tags[j].Score })
+	if len(tags) > config.maxTopics() {
+		tags = tags[:config.maxTopics()]
+	}
+	if len(tags) == 0 {
+		return
+	}
+	topics := &PageTopics{Classifier: config.classifier(), Topics: tags}
+	locateTopics(data.Markdown, topics, config.Taxonomy)
+	data.StructuredData["topics"] = topics
+}
+
+// relocateTopics finds the keywords of a page's topics again after its markdown
+// changed, keeping the topics
+func (c *Crawler) relocateTopics(data *CrawledData) {
+	if topics, ok := data.StructuredData["topics"].(*PageTopics); ok && c.Config.Topics != nil {
+		locateTopics(data.Markdown, topics, c.Config.Topics.Taxonomy)
+	}
+}
+
+// topicWord is a lower-case word of a page's markdown with its character offsets
+type topicWord struct {
+	text       string
+	start, end int
+}
+
+// topicWords returns the words of markdown outside code blocks
+func topicWords(markdown string) []topicWord {
+	runes := []rune(markdown)
+	var words []topicWord
+	for _, block := range blockSpans(runes, 0, len(runes), false) {
+		if codeFence.MatchString(string(runes[block[0]:block[1]])) {
+			continue
+		}
+		for i := block[0]; i < block[1]; {
+			if !unicode.IsLetter(runes[i]) && !unicode.IsNumber(runes[i]) {
+				i++
+				continue
+			}
+			start := i
+			for i < block[1] && (unicode.IsLetter(runes[i]) || unicode.IsNumber(runes[i])) {
+				i++
+			}
+			words = append(words, topicWord{text: strings.ToLower(string(runes[start:i])), start: start, end: i})
+		}
+	}
+	return words
+}
+
+// keywordMatches returns the spans of the keywords of a topic, and of its name, in words
+func keywordMatches(words []topicWord, topic Topic) [][2]int {
+	var spans [][2]int
+	for _, keyword := range append([]string{topic.Name}, topic.Keywords...) {
+		tokens := retrievalTokens(keyword)
+		if len(tokens) == 0 {
+			continue
+		}
+		for i := 0; i+len(tokens) <= len(words); i++ {
+			matched := true
+			for j, token := range tokens {
+				if word := words[i+j].text; word != token && word != token+"s" && word != token+"es" { // Plurals
+					matched = false
+					break
+				}
+			}
+			if matched {
+				spans = append(spans, [2]int{words[i].start, words[i+len(tokens)-1].end})
+				i += len(tokens) - 1
+			}
+		}
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i][0] < spans[j][0] })
+	return spans
+}
+
+// keywordScores scores each topic by its share of the keyword matches of a page;
+// topics with fewer than minKeywordHits matches score 0
+func keywordScores(markdown string, taxonomy []Topic) map[string]float64 {
+	words := topicWords(markdown)
+	hits := make(map[string]int)
+	total := 0
+	for _, topic := range taxonomy {
+		if count := len(keywordMatches(words, topic)); count >= minKeywordHits {
+			hits[topic.Name] = count
+			total += count
+		}
+	}
+	scores := make(map[string]float64)
+	for name, count := range hits {
+		scores[name] = float64(count) / float64(total)
+	}
+	return scores
+}
+
+// locateTopics sets the spans of the keywords of a page's topics, which place the
+// topics in the page's chunks
+func locateTopics(markdown string, topics *PageTopics, taxonomy []Topic) {
+	words := topicWords(markdown)
+	for i, tag := range topics.Topics {
+		topics.Topics[i].Spans = nil
+		for _, topic := range taxonomy {
+			if topic.Name == tag.Topic {
+				topics.Topics[i].Spans = keywordMatches(words, topic)
+			}
+		}
+	}
+}
+
+// pageTopicNames returns the names of the topics of a page
+func pageTopicNames(data *CrawledData) []string {
+	topics, ok := data.StructuredData["topics"].(*PageTopics)
+	if !ok {
+		return nil
+	}
+	names := make([]string, len(topics.Topics))
+	for i, tag := range topics.Topics {
+		names[i] = tag.Topic
+	}
+	return names
+}
+
+// chunkTopics returns the topics of a page that apply to one of its chunks: those
+// with keywords in the chunk, and those without keywords on the page at all (tagged
+// by the model for the page as a whole)
+func chunkTopics(data *CrawledData, chunk Chunk) []string {
+	topics, ok := data.StructuredData["topics"].(*PageTopics)
+	if !ok {
+		return nil
+	}
+	var names []string
+	for _, tag := range topics.Topics {
+		inChunk := len(tag.Spans) == 0
+		for _, span := range tag.Spans {
+			if span[0] >= chunk.Start && span[1] <= chunk.End {
+				inChunk = true
+				break
+			}
+		}
+		if inChunk {
+			names = append(names, tag.Topic)
+		}
+	}
+	return names
+}
+
+// topicModel classifies pages with an OpenAI-compatible chat completions API
+type topicModel struct {
+	apiURL, apiKey, model string
+}
+
+// topicModelFromEnv returns the model at LEXICRAWLER_TOPICS_URL (e.g.
+// https://api.openai.com/v1), authenticated with LEXICRAWLER_TOPICS_API_KEY, named by
+// LEXICRAWLER_TOPICS_MODEL. It returns nil without a URL.
+func topicModelFromEnv() *topicModel {
+	apiURL := strings.TrimRight(os.Getenv("LEXICRAWLER_TOPICS_URL"), "/")
+	if apiURL == "" {
+		return nil
+	}
+	model := os.Getenv("LEXICRAWLER_TOPICS_MODEL")
+	if model == "" {
+		model = "gpt-4o-mini"
+	}
+	return &topicModel{apiURL: apiURL, apiKey: os.Getenv("LEXICRAWLER_TOPICS_API_KEY"), model: model}
+}
+
+// classify asks the model how confident it is that a page is about each topic of the
+// taxonomy it applies to
+func (m *topicModel) classify(markdown string, taxonomy []Topic) (map[string]float64, error) {
+	if len(markdown) > maxTopicLLMText {
+		markdown = strings.ToValidUTF8(markdown[:maxTopicLLMText], "")
+	}
+	var prompt strings.Builder
+	prompt.WriteString("Classify the markdown document the user sends into these topics:\n")
+	for _, topic := range taxonomy {
+		prompt.WriteString("- " + topic.Name)
+		if topic.Description != "" {
+			prompt.WriteString(": " + topic.Description)
+		}
+		prompt.WriteString("\n")
+	}
+	prompt.WriteString(`Reply with a JSON object {"topics": [{"name": "<topic name as listed>", "confidence": <0 to 1>}]} ` +
+		"listing the topics the document is about, leaving out topics it only mentions in passing.")
+	request := map[string]interface{}{
+		"model": m.model,
+		"messages": []map[string]string{
+			{"role": "system", "content": prompt.String()},
+			{"role": "user", "content": markdown},
+		},
+		"response_format": map[string]string{"type": "json_object"},
+	}
+	var response struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	header := http.Header{}
+	if m.apiKey != "" {
+		header.Set("Authorization", "Bearer "+m.apiKey)
+	}
+	if err := postJSON(m.apiURL+"/chat/completions", header, request, &response); err != nil {
+		return nil, err
+	}
+	if len(response.Choices) == 0 {
+		return nil, errors.New("topic API returned no choices")
+	}
+	var result struct {
+		Topics []struct {
+			Name       string  `json:"name"`
+			Confidence float64 `json:"confidence"`
+		} `json:"topics"`
+	}
+	if err := json.Unmarshal([]byte(response.Choices[0].Message.Content), &result); err != nil {
+		return nil, fmt.Errorf("invalid topic reply: %w", err)
+	}
+	scores := make(map[string]float64)
+	for _, topic := range result.Topics {
+		scores[strings.TrimSpace(topic.Name)] = min(max(topic.Confidence, 0), 1) // Names outside the taxonomy are ignored by the caller
+	}
+	return scores, nil
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+const (
+	maxTranscriptBytes      = 2 << 20  // Caption files and transcript pages
+	maxTranscribeMediaBytes = 25 << 20 // Upload limit of the OpenAI transcription API
+)
+
+var transcriptClient = &http.Client{Timeout: 30 * time.Second}
+
+// transcribeClient waits for the transcription of media up to maxTranscribeMediaBytes
+var transcribeClient = &http.Client{Timeout: 5 * time.Minute}
+
+// Transcript sources
+const (
+	transcriptCaptions = "captions" // A captions or subtitles track of the media element
+	transcriptPage     = "page"     // A page linked as the transcript
+	transcriptWhisper  = "whisper"  // Transcribed by a Whisper-compatible API
+)
+
+// Transcript is the text of a page's video or audio
+type Transcript struct {
+	MediaURL string `json:"media_url,omitempty"` // Empty for transcript pages not tied to one element
+	Source   string `json:"source"`
+	URL      string `json:"url"` // The caption file, transcript page or transcribed media file
+	Lang     string `json:"lang,omitempty"`
+	Text     string `json:"text"`
+}
+
+var (
+	captionTagPattern = regexp.MustCompile(`<[^>]*>`)
+	srtIndexPattern   = regexp.MustCompile(`^\d+$`)
+)
+
+// fetchTranscripts attaches the transcripts of a page's media to its structured
+// data ("transcripts") and appends them to its markdown. Caption tracks and linked
+// transcript pages are used first; media without either is transcribed when
+// TranscribeMedia is set and a Whisper-compatible API is configured.
+func (c *Crawler) fetchTranscripts(data *CrawledData, dom *goquery.Selection) {
+	var transcripts []Transcript
+	media, _ := data.StructuredData["media"].([]MediaItem)
+	for _, item := range media {
+		if item.Type != "video" && item.Type != "audio" {
+			continue
+		}
+		transcript, err := captionTranscript(item)
+		if err == nil && transcript == nil && c.Config.TranscribeMedia && item.Platform == "" {
+			transcript, err = whisperTranscript(item)
+		}
+		if err != nil {
+			fmt.Printf("Transcript fetch failed for %s on %s: %v\n", item.URL, data.URL, err)
+			continue
+		}
+		if transcript != nil {
+			transcripts = append(transcripts, *transcript)
+		}
+	}
+
+	if dom != nil && len(media) > 0 {
+		for _, pageURL := range transcriptLinks(dom, data.URL) {
+			transcript, err := pageTranscript(pageURL)
+			if err != nil {
+				fmt.Printf("Transcript page fetch failed for %s: %v\n", pageURL, err)
+				continue
+			}
+			transcripts = append(transcripts, *transcript)
+		}
+	}
+	if len(transcripts) > 0 {
+		appendTranscripts(data, transcripts)
+	}
+}
+
+// appendTranscripts stores transcripts in a page's structured data and appends
+// them to its markdown
+func appendTranscripts(data *CrawledData, transcripts []Transcript) {
+	data.StructuredData["transcripts"] = transcripts
+	var markdown strings.Builder
+	markdown.WriteString(strings.TrimRight(data.Markdown, "\n"))
+	for _, transcript := range transcripts {
+		markdown.WriteString("\n\n## Transcript\n\n" + escapeMarkdown(transcript.Text) + "\n")
+	}
+	data.Markdown = markdown.String()
+	outline := buildOutline(data.Markdown)
+	carrySourceAnchors(pageOutline(data), outline)
+	data.StructuredData["outline"] = outline
+}
+
+// captionTranscript downloads the first captions (or else subtitles) track of a
+// media item; nil when it has no track
+func captionTranscript(item MediaItem) (*Transcript, error) {
+	var track *MediaTrack
+	for i := range item.Tracks {
+		switch item.Tracks[i].Kind {
+		case "captions":
+			if track == nil || track.Kind != "captions" {
+				track = &item.Tracks[i]
+			}
+		case "subtitles":
+			if track == nil {
+				track = &item.Tracks[i]
+			}
+		}
+	}
+	if track == nil {
+		return nil, nil
+	}
+
+	body, _, err := downloadText(track.URL)
+	if err != nil {
+		return nil, err
+	}
+	text := captionText(body)
+	if text == "" {
+		return nil, errors.New("caption track is empty")
+	}
+	return &Transcript{MediaURL: item.URL, Source: transcriptCaptions, URL: track.URL, Lang: track.Lang, Text: text}, nil
+}
+
+// captionText converts a WebVTT or SRT file to plain text: cue timings, indexes,
+// NOTE/STYLE blocks and markup are dropped, as are lines repeating the previous one
+// (roll-up captions)
+func captionText(captions string) string {
+	var lines []string
+	skipBlock := false
+	for i, line := range strings.Split(strings.ReplaceAll(captions, "\r\n", "\n"), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+			skipBlock = false
+			continue
+		case skipBlock:
+			continue
+		case i == 0 && strings.HasPrefix(line, "WEBVTT"),
+			strings.HasPrefix(line, "NOTE"), line == "STYLE", line == "REGION":
+			skipBlock = true
+			continue
+		case strings.Contains(line, "-->"), srtIndexPattern.MatchString(line):
+			continue
+		}
+		text := strings.Join(strings.Fields(html.UnescapeString(captionTagPattern.ReplaceAllString(line, ""))), " ")
+		if text != "" && (len(lines) == 0 || lines[len(lines)-1] != text) {
+			lines = append(lines, text)
+		}
+	}
+	return strings.Join(lines, " ")
+}
+
+// transcriptLinks returns the same-host links of a page whose text or URL mentions
+// a transcript
+func transcriptLinks(dom *goquery.Selection, pageURL string) []string {
+	parsedPageURL, err := url.Parse(pageURL)
+	if err != nil {
+		return nil
+	}
+	var links []string
+	seen := map[string]bool{pageURL: true}
+	dom.Find("a[href]").Each(func(_ int, a *goquery.Selection) {
+		href, _ := a.Attr("href")
+		if !strings.Contains(strings.ToLower(a.Text()+" "+href), "transcript") {
+			return
+		}
+		linkURL, err := url.Parse(resolveURL(pageURL, strings.TrimSpace(href)))
+		if err != nil || linkURL.Host != parsedPageURL.Host || (linkURL.Scheme != "http" && linkURL.Scheme != "https") {
+			return
+		}
+		linkURL.Fragment = ""
+		if link := linkURL.String(); !seen[link] {
+			seen[link] = true
+			links = append(links, link)
+		}
+	})
+	return links
+}
+
+// pageTranscript downloads a transcript page and returns the text of its main
+// content (main or article element, else the body) or a plain-text/caption file
+func pageTranscript(pageURL string) (*Transcript, error) {
+	body, contentType, err := downloadText(pageURL)
+	if err != nil {
+		return nil, err
+	}
+	text := captionText(body)
+	if isHTMLContentType(contentType) {
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		content := doc.Find("main, article").First()
+		if content.Length() == 0 {
+			content = doc.Find("body")
+		}
+		content.Find("nav, header, footer, aside").Remove()
+		text = strings.Join(strings.Fields(visibleText(content)), " ")
+	}
+	if text == "" {
+		return nil, errors.New("transcript page is empty")
+	}
+	return &Transcript{Source: transcriptPage, URL: pageURL, Text: text}, nil
+}
+
+// downloadText fetches a caption file or transcript page
+func downloadText(textURL string) (string, string, error) {
+	resp, err := transcriptClient.Get(textURL)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("unexpected status %d for %s", resp.StatusCode, textURL)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxTranscriptBytes))
+	if err != nil {
+		return "", "", err
+	}
+	return string(body), resp.Header.Get("Content-Type"), nil
+}
+
+// whisperTranscript transcribes a media file with the Whisper-compatible API at
+// LEXICRAWLER_WHISPER_URL (e.g. https://api.openai.com/v1, or a local
+// faster-whisper server), authenticated with LEXICRAWLER_WHISPER_API_KEY. The
+// model defaults to whisper-1 and can be changed with LEXICRAWLER_WHISPER_MODEL.
+func whisperTranscript(item MediaItem) (*Transcript, error) {
+	apiURL := strings.TrimRight(os.Getenv("LEXICRAWLER_WHISPER_URL"), "/")
+	if apiURL == "" {
+		return nil, nil
+	}
+	model := os.Getenv("LEXICRAWLER_WHISPER_MODEL")
+	if model == "" {
+		model = "whisper-1"
+	}
+
+	resp, err := transcribeClient.Get(item.URL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d for %s", resp.StatusCode, item.URL)
+	}
+	media, err := io.ReadAll(io.LimitReader(resp.Body, maxTranscribeMediaBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(media) > maxTranscribeMediaBytes {
+		return nil, fmt.Errorf("media %s exceeds %d bytes", item.URL, maxTranscribeMediaBytes)
+	}
+
+	var form bytes.Buffer
+	writer := multipart.NewWriter(&form)
+	writer.WriteField("model", model)
+	writer.WriteField("response_format", "text")
+	fileName := path.Base(item.URL)
+	if parsedURL, err := url.Parse(item.URL); err == nil {
+		fileName = path.Base(parsedURL.Path) // The API detects the format from the extension
+	}
+	part, err := writer.CreateFormFile("file", fileName)
+	if err != nil {
+		return nil, err
+	}
+	part.Write(media)
+	writer.Close()
+
+	req, err := http.NewRequest(http.MethodPost, apiURL+"/audio/transcriptions", &form)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	if apiKey := os.Getenv("LEXICRAWLER_WHISPER_API_KEY"); apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+	apiResp, err := transcribeClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer apiResp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(apiResp.Body, maxTranscriptBytes))
+	if err != nil {
+		return nil, err
+	}
+	if apiResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("transcription API returned %d: %s", apiResp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	text := strings.TrimSpace(string(body))
+	if text == "" {
+		return nil, nil
+	}
+	return &Transcript{MediaURL: item.URL, Source: transcriptWhisper, URL: item.URL, Text: text}, nil
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// translateClient calls the translation providers
+var translateClient = &http.Client{Timeout: 2 * time.Minute}
+
+// Translation batches stay well below the providers' request limits
+const (
+	maxTranslateBatchTexts = 50
+	maxTranslateBatchChars = 30000
+	maxTranslateResponse   = 16 << 20
+)
+
+// Translation records that a page's markdown was translated; the page's Markdown
+// holds the translation and OriginalMarkdown the text as crawled
+type Translation struct {
+	Provider         string `json:"provider"`
+	SourceLanguage   string `json:"source_language"` // As detected by the provider
+	TargetLanguage   string `json:"target_language"`
+	OriginalMarkdown string `json:"original_markdown"`
+}
+
+// translator translates texts into a target language, returning the translations
+// in order and the detected language of the source
+type translator interface {
+	name() string
+	translate(texts []string, target string) ([]string, string, error)
+}
+
+// translatorFromEnv returns the provider named by LEXICRAWLER_TRANSLATE_PROVIDER
+// (deepl, google or llm), authenticated with LEXICRAWLER_TRANSLATE_API_KEY.
+// LEXICRAWLER_TRANSLATE_URL overrides the API base URL and is required for llm
+// (any OpenAI-compatible chat completions API); LEXICRAWLER_TRANSLATE_MODEL picks
+// its model. It returns nil without a provider.
+func translatorFromEnv() (translator, error) {
+	provider := strings.ToLower(strings.TrimSpace(os.Getenv("LEXICRAWLER_TRANSLATE_PROVIDER")))
+	apiKey := os.Getenv("LEXICRAWLER_TRANSLATE_API_KEY")
+	apiURL := strings.TrimRight(os.Getenv("LEXICRAWLER_TRANSLATE_URL"), "/")
+	switch provider {
+	case "":
+		return nil, nil
+	case "deepl":
+		if apiURL == "" {
+			apiURL = "https://api.deepl.com/v2"
+			if strings.HasSuffix(apiKey, ":fx") { // Free API keys have their own host
+				apiURL = "https://api-free.deepl.com/v2"
+			}
+		}
+		return &deeplTranslator{apiURL: apiURL, apiKey: apiKey}, nil
+	case "google":
+		if apiURL == "" {
+			apiURL = "https://translation.googleapis.com/language/translate/v2"
+		}
+		return &googleTranslator{apiURL: apiURL, apiKey: apiKey}, nil
+	case "llm":
+		if apiURL == "" {
+			return nil, errors.New("LEXICRAWLER_TRANSLATE_URL is required for the llm translation provider")
+		}
+		model := os.Getenv("LEXICRAWLER_TRANSLATE_MODEL")
+		if model == "" {
+			model = "gpt-4o-mini"
+		}
+		return &llmTranslator{apiURL: apiURL, apiKey: apiKey, model: model}, nil
+	}
+	return nil, fmt.Errorf("unknown translation provider %q (want deepl, google or llm)", provider)
+}
+
+// translatePage replaces the markdown of a page in another language with its
+// translation into TranslateTo, keeping the original in StructuredData["translation"].
+// Pages declared in the target language are left alone.
+func (c *Crawler) translatePage(data *CrawledData) {
+	provider, err := translatorFromEnv()
+	if err != nil {
+		fmt.Printf("Translation skipped for %s: %v\n", data.URL, err)
+		return
+	}
+	if provider == nil {
+		return // Checked by toConfig for jobs
+	}
+	c.translateWith(data, provider)
+}
+
+// translateWith translates the markdown of a page with a provider, see translatePage
+func (c *Crawler) translateWith(data *CrawledData, provider translator) {
+	target := c.Config.TranslateTo
+	if target == "" || data.Markdown == "" || sameLanguage(data.Metadata["language"], target) {
+		return
+	}
+
+	blocks := markdownBlocks(data.Markdown)
+	var texts []string
+	var indexes []int
+	for i, block := range blocks {
+		if isTranslatable(block) {
+			texts = append(texts, block)
+			indexes = append(indexes, i)
+		}
+	}
+	if len(texts) == 0 {
+		return
+	}
+	source := ""
+	for start := 0; start < len(texts); {
+		end, chars := start, 0
+		for end < len(texts) && end-start < maxTranslateBatchTexts && (end == start || chars+len(texts[end]) <= maxTranslateBatchChars) {
+			chars += len(texts[end])
+			end++
+		}
+		translated, detected, err := provider.translate(texts[start:end], target)
+		if err == nil && len(translated) != end-start {
+			err = fmt.Errorf("got %d translations for %d texts", len(translated), end-start)
+		}
+		if err != nil {
+			fmt.Printf("Translation failed for %s: %v\n", data.URL, err)
+			return
+		}
+		if start == 0 {
+			source = detected
+			if sameLanguage(source, target) {
+				return // Undeclared pages may already be in the target language
+			}
+		}
+		for i, text := range translated {
+			blocks[indexes[start+i]] = text
+		}
+		start = end
+	}
+
+	data.StructuredData["translation"] = Translation{
+		Provider:         provider.name(),
+		SourceLanguage:   strings.ToLower(source),
+		TargetLanguage:   target,
+		OriginalMarkdown: data.Markdown,
+	}
+	previous := pageOutline(data)
+	data.Markdown = strings.Join(blocks, "\n\n") + "\n"
+	outline := buildOutline(data.Markdown)
+	carrySourceAnchors(previous, outline)
+	data.StructuredData["outline"] = outline
+	data.Metadata["translated_from"] = strings.ToLower(source)
+	data.Metadata["translated_to"] = target
+}
+
+// translationMemory translates the blocks an earlier translation of a page
+// translated as it did, and only the others with its provider
+type translationMemory struct {
+	provider     translator // nil when no provider is configured anymore, the others are kept untranslated
+	previous     Translation
+	translations map[string]string // Translated blocks by their original
+}
+
+// newTranslationMemory remembers the blocks of a translated page, paired with the
+// blocks of its original markdown
+func newTranslationMemory(provider translator, page *CrawledData, previous Translation) *translationMemory {
+	memory := &translationMemory{provider: provider, previous: previous, translations: make(map[string]string)}
+	originals, translated := markdownBlocks(previous.OriginalMarkdown), markdownBlocks(page.Markdown)
+	if len(originals) != len(translated) {
+		return memory // A translation spanning blocks, none can be paired
+	}
+	for i, original := range originals {
+		if isTranslatable(original) {
+			memory.translations[original] = translated[i]
+		}
+	}
+	return memory
+}
+
+func (m *translationMemory) name() string { return m.previous.Provider }
+
+func (m *translationMemory) translate(texts []string, target string) ([]string, string, error) {
+	var missing []string
+	for _, text := range texts {
+		if _, ok := m.translations[text]; !ok {
+			missing = append(missing, text)
+		}
+	}
+	if len(missing) > 0 && m.provider == nil {
+		for _, text := range missing {
+			m.translations[text] = text
+		}
+	} else if len(missing) > 0 {
+		translated, _, err := m.provider.translate(missing, target)
+		if err == nil && len(translated) != len(missing) {
+			err = fmt.Errorf("got %d translations for %d texts", len(translated), len(missing))
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		for i, text := range missing {
+			m.translations[text] = translated[i]
+		}
+	}
+	translations := make([]string, len(texts))
+	for i, text := range texts {
+		translations[i] = m.translations[text]
+	}
+	return translations, m.previous.SourceLanguage, nil
+}
+
+// sameLanguage compares the primary subtags of two language tags ("de-AT" and "de")
+func sameLanguage(a, b string) bool {
+	primary := func(tag string) string {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if i := strings.IndexAny(tag, "-_"); i >= 0 {
+			tag = tag[:i]
+		}
+		return tag
+	}
+	return a != "" && primary(a) == primary(b)
+}
+
+// markdownBlocks splits markdown at blank lines; fenced code blocks are blocks
+// of their own
+func markdownBlocks(markdown string) []string {
+	var blocks []string
+	var current []string
+	fence := ""
+	flush := func() {
+		if len(current) > 0 {
+			blocks = append(blocks, strings.Join(current, "\n"))
+			current = nil
+		}
+	}
+	for _, line := range strings.Split(strings.TrimSpace(markdown), "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case fence != "":
+			current = append(current, line)
+			if strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]) == "" {
+				fence = ""
+				flush()
+			}
+		case strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~"):
+			flush()
+			fence = trimmed[:len(trimmed)-len(strings.TrimLeft(trimmed, trimmed[:1]))]
+			current = append(current, line)
+		case trimmed == "":
+			flush()
+		default:
+			current = append(current, line)
+		}
+	}
+	flush()
+	return blocks
+}
+
+// isTranslatable reports whether a block holds prose: code blocks, rules and
+// blocks of bare links or images are kept as they are
+func isTranslatable(block string) bool {
+	trimmed := strings.TrimSpace(block)
+	if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") || trimmed == "---" {
+		return false
+	}
+	if !strings.Contains(trimmed, " ") && (strings.HasPrefix(trimmed, "http") || strings.HasPrefix(trimmed, "![")) {
+		return false
+	}
+	return strings.IndexFunc(trimmed, unicode.IsLetter) >= 0
+}
+
+// postJSON sends a JSON request to an API and decodes the JSON response
+func postJSON(apiURL string, header http.Header, request, response interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, apiURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header = header
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := translateClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, maxTranslateResponse))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %d: %s", apiURL, resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+	return json.Unmarshal(respBody, response)
+}
+
+// deeplTranslator uses the DeepL API
+type deeplTranslator struct {
+	apiURL, apiKey string
+}
+
+func (t *deeplTranslator) name() string { return "deepl" }
+
+func (t *deeplTranslator) translate(texts []string, target string) ([]string, string, error) {
+	var response struct {
+		Translations []struct {
+			DetectedSourceLanguage string `json:"detected_source_language"`
+			Text                   string `json:"text"`
+		} `json:"translations"`
+	}
+	request := map[string]interface{}{"text": texts, "target_lang": strings.ToUpper(target), "preserve_formatting": true}
+	header := http.Header{"Authorization": []string{"DeepL-Auth-Key " + t.apiKey}}
+	if err := postJSON(t.apiURL+"/translate", header, request, &response); err != nil {
+		return nil, "", err
+	}
+	translated := make([]string, len(response.Translations))
+	source := ""
+	for i, translation := range response.Translations {
+		translated[i] = translation.Text
+		source = firstNonEmpty(source, translation.DetectedSourceLanguage)
+	}
+	return translated, source, nil
+}
+
+// googleTranslator uses the Google Cloud Translation API (v2)
+type googleTranslator struct {
+	apiURL, apiKey string
+}
+
+func (t *googleTranslator) name() string { return "google" }
+
+func (t *googleTranslator) translate(texts []string, target string) ([]string, string, error) {
+	var response struct {
+		Data struct {
+			Translations []struct {
+				TranslatedText         string `json:"translatedText"`
+				DetectedSourceLanguage string `json:"detectedSourceLanguage"`
+			} `json:"translations"`
+		} `json:"data"`
+	}
+	request := map[string]interface{}{"q": texts, "target": target, "format": "text"}
+	header := http.Header{"X-Goog-Api-Key": []string{t.apiKey}}
+	if err := postJSON(t.apiURL, header, request, &response); err != nil {
+		return nil, "", err
+	}
+	translated := make([]string, len(response.Data.Translations))
+	source := ""
+	for i, translation := range response.Data.Translations {
+		translated[i] = translation.TranslatedText
+		source = firstNonEmpty(source, translation.DetectedSourceLanguage)
+	}
+	return translated, source, nil
+}
+
+// llmTranslator asks an OpenAI-compatible chat completions API for the translation
+type llmTranslator struct {
+	apiURL, apiKey, model string
+}
+
+func (t *llmTranslator) name() string { return "llm" }
+
+func (t *llmTranslator) translate(texts []string, target string) ([]string, string, error) {
+	input, err := json.Marshal(texts)
+	if err != nil {
+		return nil, "", err
+	}
+	prompt := "Translate every string of the JSON array the user sends into the language with the code " + target +
+		". Keep markdown syntax, URLs and code unchanged. Reply with a JSON object " +
+		`{"source_language": "<ISO 639-1 code of the original language>", "translations": [<one translated string per input string, in order>]}.`
+	request := map[string]interface{}{
+		"model": t.model,
+		"messages": []map[string]string{
+			{"role": "system", "content": prompt},
+			{"role": "user", "content": string(input)},
+		},
+		"response_format": map[string]string{"type": "json_object"},
+	}
+	var response struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	header := http.Header{}
+	if t.apiKey != "" {
+		header.Set("Authorization", "Bearer "+t.apiKey)
+	}
+	if err := postJSON(t.apiURL+"/chat/completions", header, request, &response); err != nil {
+		return nil, "", err
+	}
+	if len(response.Choices) == 0 {
+		return nil, "", errors.New("translation API returned no choices")
+	}
+	var result struct {
+		SourceLanguage string   `json:"source_language"`
+		Translations   []string `json:"translations"`
+	}
+	if err := json.Unmarshal([]byte(response.Choices[0].Message.Content), &result); err != nil {
+		return nil, "", fmt.Errorf("invalid translation reply: %w", err)
+	}
+	return result.Translations, result.SourceLanguage, nil
+}
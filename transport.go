@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Defaults of the connection limits of the shared transport, see transportLimitsFromEnv
+const (
+	defaultMaxIdleConns        = 256
+	defaultMaxIdleConnsPerHost = 16
+)
+
+// dnsCacheTTL is how long resolved addresses are reused. The resolver doesn't report
+// record TTLs, a few minutes keeps up with hosts that move.
+const dnsCacheTTL = 5 * time.Minute
+
+// A host's circuit opens after circuitFailureThreshold failed fetches in a row, and
+// fetches from it fail fast for circuitCooldown before one is tried again. Hosts
+// that haven't failed for circuitIdleTimeout are forgotten.
+const (
+	circuitFailureThreshold = 5
+	circuitCooldown         = 30 * time.Second
+	circuitIdleTimeout      = 10 * time.Minute
+)
+
+// errCircuitOpen is returned for fetches from a host whose circuit is open
+var errCircuitOpen = errors.New("host circuit open after repeated failures")
+
+// transports are the shared transports of all crawls by proxy URL ("" for direct
+// connections), so crawls reuse each other's connections and DNS lookups
+var transports = struct {
+	sync.Mutex
+	byProxy map[string]http.RoundTripper
+}{byProxy: make(map[string]http.RoundTripper)}
+
+// sharedTransport returns the transport of crawls through a proxy, or without one
+func sharedTransport(proxyURL string) (http.RoundTripper, error) {
+	transports.Lock()
+	defer transports.Unlock()
+	if transport, ok := transports.byProxy[proxyURL]; ok {
+		return transport, nil
+	}
+	transport := newHTTPTransport(transportLimitsFromEnv())
+	if proxyURL != "" {
+		parsedURL, err := url.Parse(proxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(parsedURL)
+	}
+	breaker := &circuitBreaker{next: transport, hosts: make(map[string]*hostCircuit)}
+	transports.byProxy[proxyURL] = breaker
+	return breaker, nil
+}
+
+// transportLimits are the connection pool settings of the shared transports
+type transportLimits struct {
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	MaxConnsPerHost     int // 0 for no limit
+}
+
+// transportLimitsFromEnv reads the connection limits from LEXICRAWLER_MAX_IDLE_CONNS,
+// LEXICRAWLER_MAX_IDLE_CONNS_PER_HOST and LEXICRAWLER_MAX_CONNS_PER_HOST
+func transportLimitsFromEnv() transportLimits {
+	limit := func(name string, fallback int) int {
+		value, err := strconv.Atoi(os.Getenv(name))
+		if err != nil || value < 0 {
+			return fallback
+		}
+		return value
+	}
+	return transportLimits{
+		MaxIdleConns:        limit("LEXICRAWLER_MAX_IDLE_CONNS", defaultMaxIdleConns),
+		MaxIdleConnsPerHost: limit("LEXICRAWLER_MAX_IDLE_CONNS_PER_HOST", defaultMaxIdleConnsPerHost),
+		MaxConnsPerHost:     limit("LEXICRAWLER_MAX_CONNS_PER_HOST", 0),
+	}
+}
+
+// newHTTPTransport creates a pooling HTTP/1.1 and HTTP/2 transport dialing through
+// the DNS cache
+func newHTTPTransport(limits transportLimits) *http.Transport {
+	dialer := &net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           sharedDNSCache.dialContext(dialer),
+		ForceAttemptHTTP2:     true, // A custom DialContext turns it off otherwise
+		MaxIdleConns:          limits.MaxIdleConns,
+		MaxIdleConnsPerHost:   limits.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       limits.MaxConnsPerHost,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+}
+
+// dnsCache keeps the addresses of resolved hosts for dnsCacheTTL
+type dnsCache struct {
+	sync.Mutex
+	entries map[string]dnsEntry
+}
+
+type dnsEntry struct {
+	addrs   []string
+	expires time.Time
+}
+
+var sharedDNSCache = &dnsCache{entries: make(map[string]dnsEntry)}
+
+// lookup returns the addresses of a host, resolving it when not cached. Failed
+// lookups aren't cached.
+func (d *dnsCache) lookup(ctx context.Context, host string) ([]string, error) {
+	d.Lock()
+	entry, ok := d.entries[host]
+	d.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.addrs, nil
+	}
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	d.Lock()
+	d.entries[host] = dnsEntry{addrs: addrs, expires: time.Now().Add(dnsCacheTTL)}
+	d.Unlock()
+	return addrs, nil
+}
+
+// dialContext dials the cached addresses of a host in turn until one connects
+func (d *dnsCache) dialContext(dialer *net.Dialer) func(ctx context.Context, network, address string) (net.Conn, error) {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil || net.ParseIP(host) != nil {
+			return dialer.DialContext(ctx, network, address)
+		}
+		addrs, err := d.lookup(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		var dialErr error
+		for _, addr := range addrs {
+			conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(addr, port))
+			if err == nil {
+				return conn, nil
+			}
+			dialErr = err
+		}
+		return nil, dialErr
+	}
+}
+
+// circuitBreaker fails requests to hosts that failed repeatedly, instead of tying up
+// workers with timeouts of a host that is down or blocking the crawler. Errors, 5xx
+// and 429 responses count as failures.
+type circuitBreaker struct {
+	next  http.RoundTripper
+	mutex sync.Mutex
+	hosts map[string]*hostCircuit // Hosts that failed lately; healthy hosts aren't kept
+	swept time.Time
+}
+
+// hostCircuit is the failure count of a host
+type hostCircuit struct {
+	failures    int // In a row
+	lastFailure time.Time
+	openUntil   time.Time
+}
+
+// RoundTrip sends a request unless the circuit of its host is open
+func (b *circuitBreaker) RoundTrip(request *http.Request) (*http.Response, error) {
+	host := request.URL.Host
+	b.mutex.Lock()
+	circuit := b.hosts[host]
+	open := circuit != nil && time.Now().Before(circuit.openUntil)
+	b.mutex.Unlock()
+	if open {
+		return nil, fmt.Errorf("%s: %w", host, errCircuitOpen)
+	}
+
+	response, err := b.next.RoundTrip(request)
+	failed := err != nil || response.StatusCode >= 500 || response.StatusCode == http.StatusTooManyRequests
+	now := time.Now()
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.sweep(now)
+	circuit = b.hosts[host] // Swept, or added by a concurrent request, meanwhile
+	if !failed {
+		if circuit != nil {
+			circuit.failures = 0
+			if !now.Before(circuit.openUntil) {
+				delete(b.hosts, host) // Closed, nothing left to remember
+			}
+		}
+		return response, err
+	}
+	if circuit == nil {
+		circuit = &hostCircuit{}
+		b.hosts[host] = circuit
+	}
+	circuit.failures++
+	circuit.lastFailure = now
+	if circuit.failures >= circuitFailureThreshold { // Also after a failed trial once the cooldown ended
+		circuit.openUntil = time.Now().Add(circuitCooldown)
+		log.Printf("Pausing fetches from %s for %s after %d failures in a row", host, circuitCooldown, circuit.failures)
+	}
+	return response, err
+}
+
+// sweep forgets the hosts whose circuit is closed and that haven't failed for
+// circuitIdleTimeout, at most once per circuitIdleTimeout, so broad crawls don't keep
+// every host that ever failed. Called with the mutex held.
+func (b *circuitBreaker) sweep(now time.Time) {
+	if now.Sub(b.swept) < circuitIdleTimeout {
+		return
+	}
+	b.swept = now
+	for host, circuit := range b.hosts {
+		if now.Sub(circuit.lastFailure) >= circuitIdleTimeout && !now.Before(circuit.openUntil) {
+			delete(b.hosts, host)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Kinds of TrapWarning.Kind
+const (
+	trapRedirectLoop  = "redirect_loop"      // A URL redirected back to itself
+	trapRedirectLimit = "redirect_limit"     // A redirect chain longer than MaxRedirects
+	trapCalendar      = "calendar"           // Dated URLs of a pattern beyond MaxCalendarPages, e.g. a calendar's next month links
+	trapFacets        = "faceted_navigation" // Query strings of a path beyond MaxQueryVariants, e.g. filter combinations
+	trapRepeatedPath  = "repeated_path"      // A path segment repeated beyond MaxRepeatedSegments, e.g. relative links resolved again and again
+	trapLongURL       = "long_url"           // A URL longer than MaxURLLength
+)
+
+// Defaults and limits of redirects and traps
+const (
+	defaultMaxRedirects        = 10 // As Go's http.Client
+	maxRedirectsLimit          = 30
+	defaultMaxQueryVariants    = 200
+	defaultMaxCalendarPages    = 100
+	defaultMaxRepeatedSegments = 3
+	defaultMaxURLLength        = 2048
+	maxTrapExamples            = 3
+	maxTrapPatternChars        = 200
+	redirectLoopVisits         = 2 // Requests of a URL in one chain before redirecting to it again is a loop; one return, as after setting a cookie, is fine
+)
+
+var (
+	// calendarDatePattern matches a year and month in a path or query, as in
+	// /2024/05/, ?month=2024-05 or /events/20240501
+	calendarDatePattern = regexp.MustCompile(`(?:^|\D)(?:19|20)\d{2}[-/_.]?(?:0[1-9]|1[0-2])(?:\D|$)`)
+	// calendarParameters are query parameters paging through dates
+	calendarParameters = wordSet("date day month year week cal calendar")
+	// digitRuns are replaced by N in the patterns of trap URLs
+	digitRuns = regexp.MustCompile(`\d+`)
+)
+
+// TrapLimits keep a crawl out of crawler traps: sites generating URLs without end,
+// such as calendars linking to the next month forever or filters combining into
+// countless query strings. Links beyond a limit aren't followed. Zero fields take
+// their default, -1 lifts the limit.
+type TrapLimits struct {
+	MaxQueryVariants    int `json:"max_query_variants"`    // Query strings followed per path, default 200
+	MaxCalendarPages    int `json:"max_calendar_pages"`    // Dated URLs followed per pattern, default 100
+	MaxRepeatedSegments int `json:"max_repeated_segments"` // Times a segment may appear in a path, default 3
+	MaxURLLength        int `json:"max_url_length"`        // Default 2048
+}
+
+// TrapWarning is a crawler trap the crawl stayed out of
+type TrapWarning struct {
+	Kind     string   `json:"kind"`
+	Pattern  string   `json:"pattern"`  // What the URLs share: their path, or their urlPattern; the redirect target of redirect traps
+	Links    int      `json:"links"`    // Links not followed, or redirects refused
+	Examples []string `json:"examples"` // The first URLs not followed
+}
+
+// redirectError is a redirect a static fetch refused to follow
+type redirectError struct {
+	loop bool
+	url  string
+	max  int
+}
+
+func (e *redirectError) Error() string {
+	if e.loop {
+		return "redirect loop at " + e.url
+	}
+	return fmt.Sprintf("stopped after %d redirects at %s", e.max, e.url)
+}
+
+// validate checks the limits are -1 or more
+func (limits TrapLimits) validate() error {
+	for _, limit := range []int{limits.MaxQueryVariants, limits.MaxCalendarPages, limits.MaxRepeatedSegments, limits.MaxURLLength} {
+		if limit < -1 {
+			return errors.New("trap limits must be -1 (no limit), 0 (the default) or more")
+		}
+	}
+	return nil
+}
+
+// trapLimit returns a limit, its default when 0
+func trapLimit(limit int, fallback int) int {
+	if limit == 0 {
+		return fallback
+	}
+	return limit
+}
+
+// maxRedirects returns how many redirects a static fetch follows
+func (config CrawlerConfig) maxRedirects() int {
+	switch {
+	case config.MaxRedirects == 0:
+		return defaultMaxRedirects
+	case config.MaxRedirects < 0:
+		return 0
+	}
+	return config.MaxRedirects
+}
+
+// checkRedirect follows the redirects of static fetches up to MaxRedirects, and
+// refuses loops; both are recorded as traps
+func (c *Crawler) checkRedirect(req *http.Request, via []*http.Request) error {
+	target := req.URL.String()
+	visits := 0
+	for _, previous := range via {
+		if previous.URL.String() == target {
+			visits++
+		}
+	}
+	if visits >= redirectLoopVisits {
+		c.recordTrap(trapRedirectLoop, target, via[0].URL.String())
+		return &redirectError{loop: true, url: target}
+	}
+	if limit := c.Config.maxRedirects(); len(via) > limit {
+		c.recordTrap(trapRedirectLimit, target, via[0].URL.String())
+		return &redirectError{url: target, max: limit}
+	}
+	if req.URL.Host != via[len(via)-1].URL.Host {
+		req.Header.Del("Authorization") // As colly does without a handler
+	}
+	return nil
+}
+
+// isTrapURL reports whether a link leads into a crawler trap, recording it in the
+// report when it does. Links counted against a limit are remembered up to the limit,
+// so a link found again on another page is still followed.
+func (c *Crawler) isTrapURL(linkURL string) bool {
+	parsedURL, err := url.Parse(linkURL)
+	if err != nil {
+		return false
+	}
+	limits := c.Config.Traps
+	if limit := trapLimit(limits.MaxURLLength, defaultMaxURLLength); limit > 0 && len(linkURL) > limit {
+		c.recordTrap(trapLongURL, urlPattern(parsedURL), linkURL)
+		return true
+	}
+	if limit := trapLimit(limits.MaxRepeatedSegments, defaultMaxRepeatedSegments); limit > 0 && repeatedSegments(parsedURL.Path) > limit {
+		c.recordTrap(trapRepeatedPath, urlPattern(parsedURL), linkURL)
+		return true
+	}
+	kind, pattern, limit := "", "", 0
+	switch {
+	case isCalendarURL(parsedURL):
+		kind, pattern, limit = trapCalendar, urlPattern(parsedURL), trapLimit(limits.MaxCalendarPages, defaultMaxCalendarPages)
+	case parsedURL.RawQuery != "":
+		kind, pattern, limit = trapFacets, parsedURL.Host+parsedURL.EscapedPath(), trapLimit(limits.MaxQueryVariants, defaultMaxQueryVariants)
+	}
+	if limit <= 0 {
+		return false
+	}
+
+	c.TrapsMutex.Lock()
+	if c.trapURLs == nil {
+		c.trapURLs = make(map[string]map[string]bool)
+	}
+	key := kind + " " + pattern
+	followed := c.trapURLs[key]
+	if followed == nil {
+		followed = make(map[string]bool)
+		c.trapURLs[key] = followed
+	}
+	trapped := !followed[linkURL] && len(followed) >= limit
+	if !trapped {
+		followed[linkURL] = true
+	}
+	c.TrapsMutex.Unlock()
+	if trapped {
+		c.recordTrap(kind, pattern, linkURL)
+	}
+	return trapped
+}
+
+// isCalendarURL reports whether a URL is of a date: a year and month in its path or
+// query, or a date query parameter
+func isCalendarURL(parsedURL *url.URL) bool {
+	if calendarDatePattern.MatchString(parsedURL.Path) {
+		return true
+	}
+	for name, values := range parsedURL.Query() {
+		for _, value := range values {
+			if calendarDatePattern.MatchString(value) || (calendarParameters[strings.ToLower(name)] && digitRuns.MatchString(value)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// repeatedSegments returns how often the most frequent segment of a path appears
+func repeatedSegments(path string) int {
+	counts := make(map[string]int)
+	most := 0
+	for _, segment := range strings.Split(path, "/") {
+		if segment == "" {
+			continue
+		}
+		counts[segment]++
+		most = max(most, counts[segment])
+	}
+	return most
+}
+
+// urlPattern returns the pattern URLs of a trap are grouped by: host, path and query,
+// with its parameters sorted and the digits of both as N
+func urlPattern(parsedURL *url.URL) string {
+	pattern := parsedURL.EscapedPath()
+	if parsedURL.RawQuery != "" {
+		pattern += "?" + parsedURL.Query().Encode() // Sorted by name
+	}
+	pattern = parsedURL.Host + digitRuns.ReplaceAllString(pattern, "N")
+	if runes := []rune(pattern); len(runes) > maxTrapPatternChars {
+		pattern = string(runes[:maxTrapPatternChars-1]) + "…"
+	}
+	return pattern
+}
+
+// recordTrap counts a link not followed, or a redirect refused, for a trap
+func (c *Crawler) recordTrap(kind string, pattern string, example string) {
+	c.TrapsMutex.Lock()
+	defer c.TrapsMutex.Unlock()
+	if c.Traps == nil {
+		c.Traps = make(map[string]*TrapWarning)
+	}
+	key := kind + " " + pattern
+	warning := c.Traps[key]
+	if warning == nil {
+		warning = &TrapWarning{Kind: kind, Pattern: pattern}
+		c.Traps[key] = warning
+	}
+	warning.Links++
+	if len(warning.Examples) < maxTrapExamples && !containsString(warning.Examples, example) {
+		warning.Examples = append(warning.Examples, example)
+	}
+}
+
+// TrapReport returns the traps of the crawl, those with the most links first
+func (c *Crawler) TrapReport() []TrapWarning {
+	c.TrapsMutex.Lock()
+	defer c.TrapsMutex.Unlock()
+	var traps []TrapWarning
+	for _, warning := range c.Traps {
+		copied := *warning
+		copied.Examples = append([]string(nil), warning.Examples...)
+		traps = append(traps, copied)
+	}
+	sort.Slice(traps, func(i, j int) bool {
+		if traps[i].Links != traps[j].Links {
+			return traps[i].Links > traps[j].Links
+		}
+		if traps[i].Kind != traps[j].Kind {
+			return traps[i].Kind < traps[j].Kind
+		}
+		return traps[i].Pattern < traps[j].Pattern
+	})
+	return traps
+}
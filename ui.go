@@ -0,0 +1,26 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/filesystem"
+)
+
+//go:embed ui
+var uiFiles embed.FS
+
+// registerUIRoutes serves the embedded web UI under /ui. The static files are public;
+// the UI sends the API key entered by the user with every API call.
+func registerUIRoutes(app *fiber.App) {
+	root, err := fs.Sub(uiFiles, "ui")
+	if err != nil {
+		panic(err) // The directory is embedded at build time
+	}
+	app.Use("/ui", filesystem.New(filesystem.Config{
+		Root:  http.FS(root),
+		Index: "index.html",
+	}))
+}
@@ -0,0 +1,207 @@
+// LexiCrawler web UI: submits jobs and browses their results through the JSON API.
+(function () {
+  'use strict';
+
+  const $ = (selector) => document.querySelector(selector);
+  const apiKeyInput = $('#api-key');
+  apiKeyInput.value = localStorage.getItem('lexicrawler-api-key') || '';
+  apiKeyInput.addEventListener('change', () => {
+    localStorage.setItem('lexicrawler-api-key', apiKeyInput.value);
+    refreshJobs();
+  });
+
+  let selectedJob = null;
+  let selectedPage = null;
+  let currentView = 'rendered';
+
+  async function api(path, options = {}) {
+    options.headers = Object.assign({}, options.headers);
+    if (apiKeyInput.value) {
+      options.headers['X-API-Key'] = apiKeyInput.value;
+    }
+    const response = await fetch(path, options);
+    if (!response.ok) {
+      throw new Error(`${response.status}: ${await response.text()}`);
+    }
+    return response;
+  }
+
+  function escapeHTML(text) {
+    return text.replace(/[&<>"']/g, (c) => ({ '&': '&amp;', '<': '&lt;', '>': '&gt;', '"': '&quot;', "'": '&#39;' }[c]));
+  }
+
+  $('#crawl-form').addEventListener('submit', async (event) => {
+    event.preventDefault();
+    const form = event.target;
+    const request = {
+      url: form.url.value,
+      max_depth: Number(form.max_depth.value),
+      max_pages: Number(form.max_pages.value),
+      readability: form.readability.checked,
+      js: form.js.checked,
+      screenshots: form.screenshots.checked,
+      metadata_only: form.metadata_only.checked,
+    };
+    $('#submit-error').textContent = '';
+    try {
+      const response = await api('/jobs', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify(request),
+      });
+      const job = await response.json();
+      await refreshJobs();
+      selectJob(job.id);
+    } catch (err) {
+      $('#submit-error').textContent = err.message;
+    }
+  });
+
+  async function refreshJobs() {
+    let jobs;
+    try {
+      jobs = await (await api('/jobs')).json();
+    } catch (err) {
+      $('#job-rows').innerHTML = `<tr><td colspan="5" class="error">${escapeHTML(err.message)}</td></tr>`;
+      return;
+    }
+    $('#job-rows').innerHTML = jobs.map((job) => `
+      <tr data-id="${job.id}" class="${job.id === selectedJob ? 'selected' : ''}">
+        <td>${escapeHTML(job.request.url)}</td>
+        <td class="status-${job.status}">${job.status}${job.error ? ': ' + escapeHTML(job.error) : ''}</td>
+        <td>${job.pages_visited}</td>
+        <td>${new Date(job.created_at).toLocaleString()}</td>
+        <td><button>Open</button></td>
+      </tr>`).join('');
+    const selected = jobs.find((job) => job.id === selectedJob);
+    if (selected) showJobSummary(selected);
+  }
+
+  $('#job-rows').addEventListener('click', (event) => {
+    const row = event.target.closest('tr[data-id]');
+    if (row) selectJob(row.dataset.id);
+  });
+
+  async function selectJob(id) {
+    selectedJob = id;
+    history.replaceState(null, '', `#job=${id}`);
+    selectedPage = null;
+    document.querySelectorAll('#job-rows tr').forEach((row) => row.classList.toggle('selected', row.dataset.id === id));
+    $('#job').hidden = false;
+    $('#page-content').innerHTML = '<p class="hint">Select a page.</p>';
+    const job = await (await api(`/jobs/${id}`)).json();
+    $('#job-title').textContent = job.request.url;
+    showJobSummary(job);
+    const pages = await (await api(`/jobs/${id}/pages`)).json();
+    $('#page-list').innerHTML = pages.map((page) => `
+      <li data-id="${page.id}">${escapeHTML(page.title || page.url)}<small>${escapeHTML(page.url)}</small></li>`).join('');
+  }
+
+  function showJobSummary(job) {
+    const parts = [`Status: ${job.status}`, `${job.pages_visited} pages`];
+    if (job.report) {
+      parts.push(`${job.report.total_words} words`);
+    }
+    $('#job-summary').textContent = parts.join(' · ');
+    document.querySelectorAll('[data-export]').forEach((button) => { button.disabled = job.status !== 'completed'; });
+  }
+
+  $('#page-list').addEventListener('click', (event) => {
+    const item = event.target.closest('li[data-id]');
+    if (!item) return;
+    document.querySelectorAll('#page-list li').forEach((li) => li.classList.toggle('selected', li === item));
+    selectedPage = item.dataset.id;
+    showPage();
+  });
+
+  document.querySelectorAll('[data-view]').forEach((button) => button.addEventListener('click', () => {
+    currentView = button.dataset.view;
+    document.querySelectorAll('[data-view]').forEach((b) => b.classList.toggle('active', b === button));
+    showPage();
+  }));
+
+  async function showPage() {
+    if (!selectedPage) return;
+    const content = $('#page-content');
+    const base = `/jobs/${selectedJob}/pages/${selectedPage}`;
+    try {
+      if (currentView === 'screenshot') {
+        content.innerHTML = `<img alt="Screenshot" src="${await imageURL(`${base}/screenshot`)}">`;
+        return;
+      }
+      if (currentView === 'rendered') {
+        // Rendered and sanitized server-side
+        content.innerHTML = await (await api(`${base}/preview?fragment=true`)).text();
+        return;
+      }
+      const page = await (await api(base)).json();
+      if (currentView === 'markdown') {
+        content.innerHTML = `<pre>${escapeHTML(page.Markdown)}</pre>`;
+      } else {
+        content.innerHTML = `<pre>${escapeHTML(JSON.stringify({ metadata: page.Metadata, stats: page.Stats }, null, 2))}</pre>`;
+      }
+    } catch (err) {
+      content.innerHTML = `<p class="error">${escapeHTML(err.message)}</p>`;
+    }
+  }
+
+  async function imageURL(path) {
+    return URL.createObjectURL(await (await api(path)).blob());
+  }
+
+  // The gallery shows every screenshot of the job and, when an earlier run of the same
+  // URL exists, flags the pages whose appearance changed; click a flag for the diff.
+  $('#gallery-button').addEventListener('click', async () => {
+    const content = $('#page-content');
+    try {
+      const screenshots = await (await api(`/jobs/${selectedJob}/screenshots`)).json();
+      if (!screenshots.length) {
+        content.innerHTML = '<p class="hint">This crawl has no screenshots.</p>';
+        return;
+      }
+      const diffs = {};
+      try {
+        for (const diff of await (await api(`/jobs/${selectedJob}/visual-diff`)).json()) diffs[diff.page_id] = diff;
+      } catch (err) {
+        // No earlier run to compare with
+      }
+      content.innerHTML = '<div class="gallery"></div>';
+      for (const shot of screenshots) {
+        const diff = diffs[shot.page_id];
+        const figure = document.createElement('figure');
+        figure.innerHTML = `<img alt="" src="${await imageURL(shot.path)}"><figcaption>${escapeHTML(shot.title || shot.url)}
+          ${diff && !diff.error ? `<span class="badge ${diff.changed ? 'changed' : ''}">${diff.changed ? 'changed' : 'unchanged'} ${(diff.changed_ratio * 100).toFixed(1)}%</span>` : ''}</figcaption>`;
+        const badge = figure.querySelector('.badge.changed');
+        if (badge) {
+          badge.addEventListener('click', async () => {
+            figure.querySelector('img').src = await imageURL(`/jobs/${selectedJob}/pages/${shot.page_id}/visual-diff`);
+          });
+        }
+        content.querySelector('.gallery').appendChild(figure);
+      }
+    } catch (err) {
+      content.innerHTML = `<p class="error">${escapeHTML(err.message)}</p>`;
+    }
+  });
+
+  document.querySelectorAll('[data-export]').forEach((button) => button.addEventListener('click', async () => {
+    try {
+      const response = await api(`/jobs/${selectedJob}/export?${button.dataset.export}`);
+      const disposition = response.headers.get('Content-Disposition') || '';
+      const filename = (disposition.match(/filename="?([^"]+)"?/) || [])[1] || 'export';
+      const link = document.createElement('a');
+      link.href = URL.createObjectURL(await response.blob());
+      link.download = filename;
+      link.click();
+    } catch (err) {
+      alert(err.message);
+    }
+  }));
+
+  refreshJobs();
+  setInterval(refreshJobs, 3000);
+
+  // Notifications link to their job as /ui/#job=ID
+  const linkedJob = location.hash.match(/^#job=([0-9a-f]+)$/);
+  if (linkedJob) selectJob(linkedJob[1]).catch((err) => alert(err.message));
+})();
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// ResourceUsage is what a job consumed across its runs, for chargeback between the
+// tenants of a deployment
+type ResourceUsage struct {
+	CPUSeconds      float64 `json:"cpu_seconds"`      // Time spent extracting pages, the CPU-bound work of a crawl, and reprocessing them
+	WallSeconds     float64 `json:"wall_seconds"`     // Time the job ran, not counting time queued or paused
+	BytesDownloaded int64   `json:"bytes_downloaded"` // By static fetches, as transferred; Chrome's traffic is billed as chrome_minutes
+	ChromeMinutes   float64 `json:"chrome_minutes"`   // Time browser tabs were open rendering, auditing or capturing pages
+	StorageBytes    int64   `json:"storage_bytes"`    // Size of the stored results, once the job completed
+}
+
+// add sums the usage of two runs; storage is not summed, it is set once the job completes
+func (u ResourceUsage) add(other ResourceUsage) ResourceUsage {
+	u.CPUSeconds += other.CPUSeconds
+	u.WallSeconds += other.WallSeconds
+	u.BytesDownloaded += other.BytesDownloaded
+	u.ChromeMinutes += other.ChromeMinutes
+	return u
+}
+
+// usageCounters measure the resources of a crawl while it runs
+type usageCounters struct {
+	started  atomic.Int64 // Unix nanoseconds, 0 until the crawl starts
+	finished atomic.Int64 // Unix nanoseconds, 0 while it runs
+	cpu      atomic.Int64 // Nanoseconds
+	chrome   atomic.Int64 // Nanoseconds
+}
+
+// spend adds the time since start to a counter, deferred around the work it measures
+func spend(counter *atomic.Int64, start time.Time) {
+	counter.Add(int64(time.Since(start)))
+}
+
+// Usage returns the resources the crawl used so far
+func (c *Crawler) Usage() ResourceUsage {
+	usage := ResourceUsage{
+		CPUSeconds:      time.Duration(c.usage.cpu.Load()).Seconds(),
+		BytesDownloaded: c.bandwidth.wire.Load(),
+		ChromeMinutes:   time.Duration(c.usage.chrome.Load()).Minutes(),
+	}
+	if started := c.usage.started.Load(); started != 0 {
+		end := c.usage.finished.Load()
+		if end == 0 {
+			end = time.Now().UnixNano()
+		}
+		usage.WallSeconds = time.Duration(end - started).Seconds()
+	}
+	return usage
+}
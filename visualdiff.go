@@ -0,0 +1,267 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math/bits"
+	"os"
+	"sort"
+)
+
+const (
+	// visualChangeThreshold is the share of changed pixels above which a page is
+	// flagged as having changed appearance
+	visualChangeThreshold = 0.05
+	// pixelDeltaThreshold ignores anti-aliasing and compression noise (0-255 per channel)
+	pixelDeltaThreshold = 32
+)
+
+// ScreenshotDiff compares the screenshots of one URL in two crawls
+type ScreenshotDiff struct {
+	PageID       string  `json:"page_id"`
+	URL          string  `json:"url"`
+	HashDistance int     `json:"hash_distance"`   // Hamming distance of the perceptual hashes, 0-64
+	ChangedRatio float64 `json:"changed_ratio"`   // Share of pixels that differ, 0-1
+	Changed      bool    `json:"changed"`         // ChangedRatio is above visualChangeThreshold
+	SizeChanged  bool    `json:"size_changed"`    // Screenshot dimensions differ
+	Error        string  `json:"error,omitempty"` // Set when a screenshot couldn't be read
+	PreviousJob  string  `json:"previous_job_id"`
+}
+
+// ScreenshotEntry is a single screenshot of a crawl's gallery
+type ScreenshotEntry struct {
+	PageID string `json:"page_id"`
+	URL    string `json:"url"`
+	Title  string `json:"title"`
+	Path   string `json:"path"` // API path serving the image
+}
+
+// loadScreenshot decodes a PNG screenshot
+func loadScreenshot(path string) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return png.Decode(file)
+}
+
+// perceptualHash computes a 64-bit difference hash (dHash): the image is reduced to
+// 9x8 grayscale cells and each bit records whether a cell is brighter than its right
+// neighbour. Similar-looking images have hashes with a small Hamming distance.
+func perceptualHash(img image.Image) uint64 {
+	const width, height = 9, 8
+	bounds := img.Bounds()
+	var cells [height][width]float64
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + x*bounds.Dx()/width
+			x1 := bounds.Min.X + (x+1)*bounds.Dx()/width
+			y0 := bounds.Min.Y + y*bounds.Dy()/height
+			y1 := bounds.Min.Y + (y+1)*bounds.Dy()/height
+			cells[y][x] = averageLuminance(img, x0, y0, max(x1, x0+1), max(y1, y0+1))
+		}
+	}
+
+	var hash uint64
+	for y := 0; y < height; y++ {
+		for x := 0; x < width-1; x++ {
+			hash <<= 1
+			if cells[y][x] > cells[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// averageLuminance averages the luminance of a rectangle, sampling at most ~32x32 pixels
+func averageLuminance(img image.Image, x0, y0, x1, y1 int) float64 {
+	stepX, stepY := max((x1-x0)/32, 1), max((y1-y0)/32, 1)
+	var sum float64
+	var count int
+	for y := y0; y < y1; y += stepY {
+		for x := x0; x < x1; x += stepX {
+			sum += float64(color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y)
+			count++
+		}
+	}
+	if count == 0 {
+		return 0
+	}
+	return sum / float64(count)
+}
+
+// pixelChanged reports whether two colors differ beyond noise
+func pixelChanged(a, b color.Color) bool {
+	ar, ag, ab, _ := a.RGBA()
+	br, bg, bb, _ := b.RGBA()
+	delta := func(x, y uint32) int {
+		d := int(x>>8) - int(y>>8)
+		if d < 0 {
+			return -d
+		}
+		return d
+	}
+	return delta(ar, br) > pixelDeltaThreshold || delta(ag, bg) > pixelDeltaThreshold || delta(ab, bb) > pixelDeltaThreshold
+}
+
+// compareImages returns the perceptual hash distance and the share of changed pixels.
+// Pixels outside the overlap of two differently sized images count as changed.
+func compareImages(previous, current image.Image) (int, float64) {
+	distance := bits.OnesCount64(perceptualHash(previous) ^ perceptualHash(current))
+
+	pb, cb := previous.Bounds(), current.Bounds()
+	width, height := min(pb.Dx(), cb.Dx()), min(pb.Dy(), cb.Dy())
+	total := max(pb.Dx(), cb.Dx()) * max(pb.Dy(), cb.Dy())
+	if total == 0 {
+		return distance, 0
+	}
+	changed := total - width*height
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if pixelChanged(previous.At(pb.Min.X+x, pb.Min.Y+y), current.At(cb.Min.X+x, cb.Min.Y+y)) {
+				changed++
+			}
+		}
+	}
+	return distance, float64(changed) / float64(total)
+}
+
+// diffImage renders the current screenshot dimmed, with changed pixels in red
+func diffImage(previous, current image.Image) *image.RGBA {
+	pb, cb := previous.Bounds(), current.Bounds()
+	out := image.NewRGBA(image.Rect(0, 0, max(pb.Dx(), cb.Dx()), max(pb.Dy(), cb.Dy())))
+	highlight := color.RGBA{R: 255, A: 255}
+	for y := 0; y < out.Rect.Dy(); y++ {
+		for x := 0; x < out.Rect.Dx(); x++ {
+			inPrevious := x < pb.Dx() && y < pb.Dy()
+			inCurrent := x < cb.Dx() && y < cb.Dy()
+			if !inPrevious || !inCurrent {
+				out.Set(x, y, highlight)
+				continue
+			}
+			currentPixel := current.At(cb.Min.X+x, cb.Min.Y+y)
+			if pixelChanged(previous.At(pb.Min.X+x, pb.Min.Y+y), currentPixel) {
+				out.Set(x, y, highlight)
+				continue
+			}
+			gray := color.GrayModel.Convert(currentPixel).(color.Gray).Y
+			faded := 255 - (255-gray)/4 // Unchanged areas are washed out so changes stand out
+			out.Set(x, y, color.RGBA{R: faded, G: faded, B: faded, A: 255})
+		}
+	}
+	return out
+}
+
+// compareScreenshots compares the screenshots of the same page in two crawls
+func compareScreenshots(pageURL string, previousJobID string, previousPath string, currentPath string) ScreenshotDiff {
+	diff := ScreenshotDiff{PageID: pageID(pageURL), URL: pageURL, PreviousJob: previousJobID}
+	previous, err := loadScreenshot(previousPath)
+	if err != nil {
+		diff.Error = err.Error()
+		return diff
+	}
+	current, err := loadScreenshot(currentPath)
+	if err != nil {
+		diff.Error = err.Error()
+		return diff
+	}
+	diff.HashDistance, diff.ChangedRatio = compareImages(previous, current)
+	diff.SizeChanged = previous.Bounds().Size() != current.Bounds().Size()
+	diff.Changed = diff.ChangedRatio > visualChangeThreshold
+	return diff
+}
+
+// Screenshots lists the pages of a tenant's job that have a screenshot, ordered by URL
+func (m *JobManager) Screenshots(tenant *Tenant, id string) ([]ScreenshotEntry, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	job, err := m.lookup(tenant, id)
+	if err != nil {
+		return nil, err
+	}
+	entries := []ScreenshotEntry{}
+	for _, pageURL := range sortedResultURLs(job.results) {
+		data := job.results[pageURL]
+		if data.ScreenshotPath == "" {
+			continue
+		}
+		entries = append(entries, ScreenshotEntry{
+			PageID: pageID(pageURL),
+			URL:    pageURL,
+			Title:  data.Metadata["title"],
+			Path:   "/jobs/" + job.ID + "/pages/" + pageID(pageURL) + "/screenshot",
+		})
+	}
+	return entries, nil
+}
+
+// screenshotPairs returns the screenshot paths (previous, current) of every URL that
+// has a screenshot in both jobs. Without against, the job is compared with the latest
+// earlier completed job of the tenant for the same start URL.
+func (m *JobManager) screenshotPairs(tenant *Tenant, id string, against string) (string, map[string][2]string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	job, err := m.lookup(tenant, id)
+	if err != nil {
+		return "", nil, err
+	}
+	previous, err := m.previousRun(tenant, job, against)
+	if err != nil {
+		return "", nil, err
+	}
+
+	pairs := make(map[string][2]string)
+	for pageURL, data := range job.results {
+		if before, ok := previous.results[pageURL]; ok && data.ScreenshotPath != "" && before.ScreenshotPath != "" {
+			pairs[pageURL] = [2]string{before.ScreenshotPath, data.ScreenshotPath}
+		}
+	}
+	return previous.ID, pairs, nil
+}
+
+// VisualDiff compares a job's screenshots with those of another run, flagging pages
+// whose appearance changed significantly
+func (m *JobManager) VisualDiff(tenant *Tenant, id string, against string) ([]ScreenshotDiff, error) {
+	previousID, pairs, err := m.screenshotPairs(tenant, id, against)
+	if err != nil {
+		return nil, err
+	}
+	diffs := []ScreenshotDiff{}
+	for pageURL, paths := range pairs {
+		diffs = append(diffs, compareScreenshots(pageURL, previousID, paths[0], paths[1]))
+	}
+	sort.Slice(diffs, func(i, j int) bool {
+		if diffs[i].Changed != diffs[j].Changed {
+			return diffs[i].Changed // Changed pages first
+		}
+		return diffs[i].URL < diffs[j].URL
+	})
+	return diffs, nil
+}
+
+// VisualDiffImage renders the difference between a page's screenshots in two runs
+func (m *JobManager) VisualDiffImage(tenant *Tenant, id string, page string, against string) (image.Image, error) {
+	_, pairs, err := m.screenshotPairs(tenant, id, against)
+	if err != nil {
+		return nil, err
+	}
+	for pageURL, paths := range pairs {
+		if pageID(pageURL) != page {
+			continue
+		}
+		previous, err := loadScreenshot(paths[0])
+		if err != nil {
+			return nil, err
+		}
+		current, err := loadScreenshot(paths[1])
+		if err != nil {
+			return nil, err
+		}
+		return diffImage(previous, current), nil
+	}
+	return nil, fmt.Errorf("page %s has no screenshot in both crawls", page)
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html/charset"
+)
+
+// Feed is a parsed RSS (0.9x, 1.0 or 2.0) or Atom feed
+type Feed struct {
+	Format      string     `json:"format"` // rss or atom
+	Title       string     `json:"title"`
+	Link        string     `json:"link,omitempty"`
+	Description string     `json:"description,omitempty"`
+	Items       []FeedItem `json:"items"`
+}
+
+// FeedItem is an entry of a feed
+type FeedItem struct {
+	Title     string `json:"title"`
+	Link      string `json:"link,omitempty"`
+	Published string `json:"published,omitempty"` // As written in the feed
+	Author    string `json:"author,omitempty"`
+	Summary   string `json:"summary,omitempty"` // Plain text
+}
+
+// xmlNode is an element of an arbitrary XML document
+type xmlNode struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr `xml:",any,attr"`
+	Text    string     `xml:",chardata"`
+	Nodes   []xmlNode  `xml:",any"`
+}
+
+// isXMLContentType reports whether a Content-Type holds XML other than XHTML
+// (application/xml, text/xml, application/rss+xml, application/atom+xml, ...)
+func isXMLContentType(contentType string) bool {
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	if strings.Contains(mediaType, "html") {
+		return false
+	}
+	return mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml")
+}
+
+// extractXML renders an XML document as markdown: feeds as their items, sitemaps as
+// lists of URLs and any other document as a nested list of its elements. The parsed
+// document is stored as StructuredData["feed"], ["sitemap"] or ["xml"].
+func extractXML(data *CrawledData, body string) error {
+	decoder := xml.NewDecoder(strings.NewReader(body))
+	decoder.CharsetReader = charset.NewReaderLabel
+	decoder.Strict = false // Feeds in the wild often contain HTML entities
+	decoder.Entity = xml.HTMLEntity
+	var root xmlNode
+	if err := decoder.Decode(&root); err != nil {
+		return fmt.Errorf("invalid XML: %w", err)
+	}
+
+	var markdown strings.Builder
+	switch root.XMLName.Local {
+	case "rss", "RDF", "feed":
+		feed := parseFeed(&root, data.URL)
+		data.Metadata["title"] = firstNonEmpty(feed.Title, data.URL)
+		feed.writeMarkdown(&markdown)
+		data.StructuredData["feed"] = feed
+	case "urlset", "sitemapindex":
+		sitemap := sitemapDocument{}
+		if err := xml.Unmarshal([]byte(body), &sitemap); err != nil {
+			return fmt.Errorf("invalid sitemap: %w", err)
+		}
+		result := SitemapResult{Sitemaps: []string{}, URLs: sitemap.URLs}
+		for _, child := range sitemap.Sitemaps {
+			result.Sitemaps = append(result.Sitemaps, child.Loc)
+		}
+		markdown.WriteString("# " + escapeMarkdown(data.URL) + "\n\n---\n\n")
+		for _, child := range result.Sitemaps {
+			markdown.WriteString("* Sitemap: " + child + "\n")
+		}
+		for _, entry := range result.URLs {
+			markdown.WriteString("* " + entry.Loc)
+			if entry.LastMod != "" {
+				markdown.WriteString(" (" + escapeMarkdown(entry.LastMod) + ")")
+			}
+			markdown.WriteString("\n")
+		}
+		data.StructuredData["sitemap"] = result
+	default:
+		markdown.WriteString("# " + escapeMarkdown(data.URL) + "\n\n---\n\n")
+		root.writeMarkdown(&markdown, 0)
+		data.StructuredData["xml"] = map[string]interface{}{root.XMLName.Local: root.value()}
+	}
+	data.Markdown = markdown.String()
+	return nil
+}
+
+// parseFeed reads the channel and items of an RSS feed or the entries of an Atom feed
+func parseFeed(root *xmlNode, feedURL string) Feed {
+	feed := Feed{Format: "rss", Items: []FeedItem{}}
+	channel := root.child("channel")
+	items := root.children("item") // RSS 1.0 items are siblings of the channel
+	if root.XMLName.Local == "feed" {
+		feed.Format = "atom"
+		channel = root
+		items = root.children("entry")
+	}
+	if channel != nil {
+		feed.Title = channel.childText("title")
+		feed.Link = resolveURL(feedURL, channel.link())
+		feed.Description = plainText(firstNonEmpty(channel.childText("description"), channel.childText("subtitle")))
+		if len(items) == 0 {
+			items = channel.children("item")
+		}
+	}
+	for _, item := range items {
+		feed.Items = append(feed.Items, FeedItem{
+			Title:     plainText(item.childText("title")),
+			Link:      resolveURL(feedURL, item.link()),
+			Published: firstNonEmpty(item.childText("pubDate"), item.childText("published"), item.childText("updated"), item.childText("date")),
+			Author:    firstNonEmpty(item.childText("author"), item.childText("creator")),
+			Summary:   plainText(firstNonEmpty(item.childText("description"), item.childText("summary"), item.childText("content"))),
+		})
+	}
+	return feed
+}
+
+// writeMarkdown renders a feed as a heading per item with its date and summary
+func (feed *Feed) writeMarkdown(markdown *strings.Builder) {
+	markdown.WriteString("# " + escapeMarkdown(feed.Title) + "\n\n")
+	if feed.Description != "" {
+		markdown.WriteString("> " + escapeMarkdown(feed.Description) + "\n\n")
+	}
+	markdown.WriteString("---\n\n")
+	for _, item := range feed.Items {
+		title := escapeMarkdown(firstNonEmpty(item.Title, item.Link))
+		if item.Link != "" {
+			title = "[" + title + "](" + item.Link + ")"
+		}
+		markdown.WriteString("## " + title + "\n\n")
+		if byline := joinNonEmpty(" · ", item.Published, item.Author); byline != "" {
+			markdown.WriteString("*" + escapeMarkdown(byline) + "*\n\n")
+		}
+		if item.Summary != "" {
+			markdown.WriteString(escapeMarkdown(item.Summary) + "\n\n")
+		}
+	}
+}
+
+// child returns the first child element with the given local name
+func (node *xmlNode) child(name string) *xmlNode {
+	for i := range node.Nodes {
+		if node.Nodes[i].XMLName.Local == name {
+			return &node.Nodes[i]
+		}
+	}
+	return nil
+}
+
+// children returns the child elements with the given local name
+func (node *xmlNode) children(name string) []*xmlNode {
+	var children []*xmlNode
+	for i := range node.Nodes {
+		if node.Nodes[i].XMLName.Local == name {
+			children = append(children, &node.Nodes[i])
+		}
+	}
+	return children
+}
+
+// childText returns the trimmed text of the first child with the given local name.
+// Atom authors keep their name in a nested element.
+func (node *xmlNode) childText(name string) string {
+	child := node.child(name)
+	if child == nil {
+		return ""
+	}
+	if nested := child.child("name"); nested != nil {
+		return strings.TrimSpace(nested.Text)
+	}
+	return strings.TrimSpace(child.Text)
+}
+
+// link returns the link of an RSS item (text) or an Atom entry (the alternate
+// link's href)
+func (node *xmlNode) link() string {
+	for _, link := range node.children("link") {
+		if text := strings.TrimSpace(link.Text); text != "" {
+			return text
+		}
+		if rel := link.attr("rel"); rel == "" || rel == "alternate" {
+			return link.attr("href")
+		}
+	}
+	return ""
+}
+
+// attr returns the value of an attribute by local name
+func (node *xmlNode) attr(name string) string {
+	for _, attribute := range node.Attrs {
+		if attribute.Name.Local == name {
+			return attribute.Value
+		}
+	}
+	return ""
+}
+
+// writeMarkdown renders an element and its descendants as a nested list
+func (node *xmlNode) writeMarkdown(markdown *strings.Builder, depth int) {
+	line := strings.Repeat("  ", depth) + "* **" + escapeMarkdown(node.XMLName.Local) + "**"
+	for _, attribute := range node.Attrs {
+		line += " " + escapeMarkdown(attribute.Name.Local) + `="` + escapeMarkdown(attribute.Value) + `"`
+	}
+	if text := strings.Join(strings.Fields(node.Text), " "); text != "" {
+		line += ": " + escapeMarkdown(text)
+	}
+	markdown.WriteString(line + "\n")
+	for i := range node.Nodes {
+		node.Nodes[i].writeMarkdown(markdown, depth+1)
+	}
+}
+
+// value converts an element to JSON-friendly data: text-only elements become
+// strings, others maps of "@attribute", "#text" and child names (lists when repeated)
+func (node *xmlNode) value() interface{} {
+	text := strings.TrimSpace(node.Text)
+	if len(node.Attrs) == 0 && len(node.Nodes) == 0 {
+		return text
+	}
+	value := make(map[string]interface{})
+	for _, attribute := range node.Attrs {
+		value["@"+attribute.Name.Local] = attribute.Value
+	}
+	if text != "" {
+		value["#text"] = text
+	}
+	for i := range node.Nodes {
+		child := &node.Nodes[i]
+		name := child.XMLName.Local
+		if existing, ok := value[name]; !ok {
+			value[name] = child.value()
+		} else if list, isList := existing.([]interface{}); isList {
+			value[name] = append(list, child.value())
+		} else {
+			value[name] = []interface{}{existing, child.value()}
+		}
+	}
+	return value
+}
+
+// plainText converts text that may hold HTML (feed summaries) to plain text
+func plainText(text string) string {
+	if !strings.Contains(text, "<") {
+		return strings.Join(strings.Fields(text), " ")
+	}
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(text))
+	if err != nil {
+		return text
+	}
+	return strings.Join(strings.Fields(doc.Text()), " ")
+}